@@ -0,0 +1,29 @@
+//go:build js && wasm
+
+package enet
+
+// enetAddress keeps host names as they are, the browser resolves them when dialing.
+type enetAddress struct {
+	host string
+	port uint16
+}
+
+func (addr *enetAddress) SetHostAny() {
+	addr.SetHost("::")
+}
+
+func (addr *enetAddress) SetHost(hostname string) {
+	addr.host = hostname
+}
+
+func (addr *enetAddress) SetPort(port uint16) {
+	addr.port = port
+}
+
+func (addr *enetAddress) String() string {
+	return addr.host
+}
+
+func (addr *enetAddress) GetPort() uint16 {
+	return addr.port
+}
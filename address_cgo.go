@@ -0,0 +1,56 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// #include "enet.h"
+// #cgo noescape enet_address_get_ip
+// #cgo nocallback enet_address_get_ip
+import "C"
+
+// maxIPLength is the size of the buffer addresses are formatted in, INET6_ADDRSTRLEN.
+const maxIPLength = 46
+
+type enetAddress struct {
+	cAddr C.ENetAddress
+}
+
+func (addr *enetAddress) SetHostAny() {
+	//TODO: fix ipv6 ENET_HOST_ANY assignment
+	addr.SetHost("::")
+}
+
+func (addr *enetAddress) SetHost(hostname string) {
+	cHostname := C.CString(hostname)
+	C.enet_address_set_hostname(
+		&addr.cAddr,
+		cHostname,
+	)
+	C.free(unsafe.Pointer(cHostname))
+}
+
+func (addr *enetAddress) SetPort(port uint16) {
+	addr.cAddr.port = (C.uint16_t)(port)
+}
+
+// String formats the address in a buffer on the stack, which enet is told it doesn't
+// keep, so only the returned string is allocated.
+func (addr *enetAddress) String() string {
+	var buffer [maxIPLength]C.char
+	if C.enet_address_get_ip(&addr.cAddr, &buffer[0], maxIPLength) < 0 {
+		return ""
+	}
+	ip := unsafe.Slice((*byte)(unsafe.Pointer(&buffer[0])), maxIPLength)
+	if n := bytes.IndexByte(ip, 0); n >= 0 {
+		ip = ip[:n]
+	}
+	return string(ip)
+}
+
+func (addr *enetAddress) GetPort() uint16 {
+	return uint16(addr.cAddr.port)
+}
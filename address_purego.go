@@ -0,0 +1,45 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+type enetAddress struct {
+	addr protocol.Address
+}
+
+func (addr *enetAddress) SetHostAny() {
+	addr.SetHost("::")
+}
+
+// SetHost sets the IP of the address, resolving host names like
+// enet_address_set_hostname. The address is left unchanged if that fails.
+func (addr *enetAddress) SetHost(hostname string) {
+	if ip, err := netip.ParseAddr(hostname); err == nil {
+		addr.addr.IP = ip.As16()
+		return
+	}
+
+	ips, err := net.LookupIP(hostname)
+	if err != nil || len(ips) == 0 {
+		return
+	}
+	copy(addr.addr.IP[:], ips[0].To16())
+}
+
+func (addr *enetAddress) SetPort(port uint16) {
+	addr.addr.Port = port
+}
+
+func (addr *enetAddress) String() string {
+	return addr.addr.String()
+}
+
+func (addr *enetAddress) GetPort() uint16 {
+	return addr.addr.Port
+}
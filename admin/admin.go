@@ -0,0 +1,306 @@
+// Package admin lets operators run commands on a server while it runs: list its peers
+// with their statistics, kick and ban them, broadcast notices, change bandwidth limits
+// and toggle debug logging. Commands are lines of text, sent by admin clients over a
+// channel reserved to them, or over a local socket, after authenticating with a token:
+//
+//	logLevel := new(slog.LevelVar)
+//	host.ApplyConfig(enet.HostConfig{
+//		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})),
+//	})
+//
+//	ops := admin.New(host, admin.Config{
+//		Channel:       7,
+//		NoticeChannel: 1,
+//		Token:         os.Getenv("ADMIN_TOKEN"),
+//		LogLevel:      logLevel,
+//	})
+//	listener, err := net.Listen("unix", "/run/game/admin.sock")
+//	go ops.Serve(ctx, listener)
+//
+//	// On the goroutine servicing the host, for every event:
+//	if ops.Handle(event) {
+//		return
+//	}
+//
+//	// And every tick, running the commands of the socket:
+//	ops.Process()
+//
+// An admin client connected to the server, or to the socket with a tool such as
+// socat, authenticates then runs commands:
+//
+//	auth s3cret
+//	peers
+//	kick 12
+//	ban 203.0.113.7
+//	ban 198.51.100.0/24 1h
+//	notice Server restarting in 5 minutes
+//	bandwidth 0 1048576
+//	debug on
+//
+// Every command is answered with a Reply encoded as JSON, on the same channel or as a
+// line of the socket.
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+var (
+	// ErrUnauthorized is replied to commands run before authenticating
+	ErrUnauthorized = errors.New("unauthorized")
+
+	errUnknownCommand = errors.New("unknown command, try help")
+)
+
+// Command is a command added to the built-in ones, run with the arguments following its
+// name and returning its output
+type Command func(args []string) (string, error)
+
+// Reply is the reply to a command
+type Reply struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Config configures an Admin
+type Config struct {
+	// Channel is the channel reserved to admin commands
+	Channel uint8
+
+	// NoticeChannel is the channel notices are broadcast on, reliably
+	NoticeChannel uint8
+
+	// Token authenticates admin clients. It must be set for commands to be accepted
+	// over the admin channel, and is required over sockets if set.
+	Token string
+
+	// LogLevel is the level of the logger of the host, see enet.HostConfig.Logger,
+	// which the debug command switches between debug and info. It may be nil.
+	LogLevel *slog.LevelVar
+
+	// Commands are commands added to the built-in ones, by name. They may override
+	// built-in commands.
+	Commands map[string]Command
+
+	// Bans holds the bans of the ban commands, for example a list saved to a file that
+	// is also enforced with enet.EnforceBans. Defaults to a list kept in memory.
+	Bans enet.BanList
+}
+
+// socketRequest is a command received over a socket, waiting for Process to run it.
+type socketRequest struct {
+	line  string
+	reply chan Reply
+}
+
+// Admin runs admin commands on a host, see the package documentation. Apart from Serve,
+// it is not safe for concurrent use, and must be used by the goroutine servicing the
+// host.
+type Admin struct {
+	host   enet.Host
+	config Config
+
+	peers      map[uint32]enet.Peer
+	authorized map[enet.Peer]bool
+
+	requests chan socketRequest
+}
+
+// New creates an admin for host
+func New(host enet.Host, config Config) *Admin {
+	if config.Bans == nil {
+		// Without a store it can't fail.
+		config.Bans, _ = enet.NewBanList(nil)
+	}
+	return &Admin{
+		host:       host,
+		config:     config,
+		peers:      make(map[uint32]enet.Peer),
+		authorized: make(map[enet.Peer]bool),
+		requests:   make(chan socketRequest),
+	}
+}
+
+// Handle runs the commands received on the admin channel, returning true if the event
+// carried one. It also keeps track of the peers commands refer to, and disconnects
+// banned peers as they connect, returning true for their connect event too, which the
+// application must not handle. It must be called for every event serviced. The packet
+// of the event is left to the caller.
+func (a *Admin) Handle(event enet.Event) bool {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect:
+		if _, banned := a.config.Bans.Banned(addrOf(peer), peer.Identity()); banned {
+			peer.Disconnect(0)
+			return true
+		}
+		delete(a.authorized, peer)
+		a.peers[peer.GetID()] = peer
+		return false
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		delete(a.authorized, peer)
+		if a.peers[peer.GetID()] == peer {
+			delete(a.peers, peer.GetID())
+		}
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != a.config.Channel {
+		return false
+	}
+
+	authorized := a.authorized[peer]
+	reply := a.run(string(event.GetPacket().GetData()), &authorized)
+	peer.SendBytes(encodeReply(reply), a.config.Channel, enet.PacketFlagReliable)
+	if authorized {
+		a.authorized[peer] = true
+	} else {
+		// Failing to authenticate costs the connection, so tokens can't be guessed.
+		delete(a.authorized, peer)
+		peer.DisconnectLater(0)
+	}
+	return true
+}
+
+// Serve accepts admin clients on listener until ctx is cancelled or accepting fails,
+// reading a command per line and writing a Reply per line. The commands are run by
+// Process, on the goroutine servicing the host. Connections are authenticated with
+// the token if there is one, otherwise access to the listener is trusted.
+func (a *Admin) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go a.serveConn(ctx, conn)
+	}
+}
+
+func (a *Admin) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	authorized := a.config.Token == ""
+	encoder := json.NewEncoder(conn)
+	encoder.SetEscapeHTML(false)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var reply Reply
+		if name, token, _ := strings.Cut(line, " "); name == "auth" {
+			reply = a.auth(token, &authorized)
+		} else if !authorized {
+			reply = Reply{Error: ErrUnauthorized.Error()}
+		} else {
+			request := socketRequest{line: line, reply: make(chan Reply, 1)}
+			select {
+			case a.requests <- request:
+			case <-ctx.Done():
+				return
+			}
+			reply = <-request.reply
+		}
+		if encoder.Encode(reply) != nil || (reply.Error != "" && !authorized) {
+			return
+		}
+	}
+}
+
+// Process runs the commands received over sockets since the last call. It must be
+// called regularly, every tick for example, for socket clients to get replies.
+func (a *Admin) Process() {
+	for {
+		select {
+		case request := <-a.requests:
+			authorized := true
+			request.reply <- a.run(request.line, &authorized)
+		default:
+			return
+		}
+	}
+}
+
+// Banned returns the addresses banned, as prefixes for those of networks
+func (a *Admin) Banned() []string {
+	var banned []string
+	for _, ban := range a.config.Bans.Bans() {
+		if ban.Prefix.IsValid() {
+			banned = append(banned, formatPrefix(ban.Prefix))
+		}
+	}
+	return banned
+}
+
+// run runs a command line, authenticating the client if it is an auth command.
+func (a *Admin) run(line string, authorized *bool) Reply {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Reply{Error: errUnknownCommand.Error()}
+	}
+	name, args := fields[0], fields[1:]
+	if name == "auth" {
+		_, token, _ := strings.Cut(strings.TrimSpace(line), " ")
+		return a.auth(token, authorized)
+	}
+	if !*authorized {
+		return Reply{Error: ErrUnauthorized.Error()}
+	}
+
+	command, ok := a.config.Commands[name]
+	if !ok {
+		command, ok = a.builtin(name, line)
+	}
+	if !ok {
+		return Reply{Error: errUnknownCommand.Error()}
+	}
+	output, err := command(args)
+	if err != nil {
+		return Reply{Output: output, Error: err.Error()}
+	}
+	return Reply{Output: output}
+}
+
+// auth authenticates a client with token.
+func (a *Admin) auth(token string, authorized *bool) Reply {
+	if a.config.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Token)) != 1 {
+		*authorized = false
+		return Reply{Error: ErrUnauthorized.Error()}
+	}
+	*authorized = true
+	return Reply{Output: "authenticated"}
+}
+
+// encodeReply encodes reply as JSON, leaving the usage of commands readable.
+func encodeReply(reply Reply) []byte {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(reply)
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,261 @@
+package admin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const help = `auth <token>                authenticate
+peers                       list the connected peers and their statistics
+kick <id> [data]            disconnect a peer
+ban <ip|prefix> [duration]  disconnect the peers of an address or network and refuse them
+unban <ip|prefix>           lift a ban
+bans                        list the bans
+notice <text>               broadcast a notice to every peer
+bandwidth <in> <out>        set the bandwidth of the host in bytes per second, 0 for unlimited
+debug on|off                toggle debug logging
+help                        list the commands`
+
+// builtin returns the built-in command name, run from line.
+func (a *Admin) builtin(name, line string) (Command, bool) {
+	switch name {
+	case "help":
+		return func([]string) (string, error) { return help, nil }, true
+	case "peers":
+		return a.listPeers, true
+	case "kick":
+		return a.kick, true
+	case "ban":
+		return a.ban, true
+	case "unban":
+		return a.unban, true
+	case "bans":
+		return a.listBans, true
+	case "notice":
+		return func([]string) (string, error) {
+			_, text, _ := strings.Cut(strings.TrimSpace(line), " ")
+			return a.notice(strings.TrimSpace(text))
+		}, true
+	case "bandwidth":
+		return a.bandwidth, true
+	case "debug":
+		return a.debug, true
+	}
+	return nil, false
+}
+
+func (a *Admin) listPeers([]string) (string, error) {
+	rtts := make(map[uint32]string)
+	for _, stats := range enet.GetPeerStats(a.host) {
+		rtts[stats.ID] = stats.RoundTripTime.String()
+	}
+
+	ids := make([]uint32, 0, len(a.peers))
+	for id := range a.peers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var output strings.Builder
+	w := tabwriter.NewWriter(&output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSENT\tRECEIVED\tPACKETS\tLOST\tRTT\tBANDWIDTH")
+	for _, id := range ids {
+		peer := a.peers[id]
+		rtt, ok := rtts[id]
+		if !ok {
+			rtt = "-"
+		}
+		address := net.JoinHostPort(ipOf(peer), strconv.Itoa(int(peer.GetAddress().GetPort())))
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%s\t%d\n", id, address, peer.GetBytesSent(),
+			peer.GetBytesReceived(), peer.GetPacketsSent(), peer.GetPacketsLost(), rtt, peer.EstimatedBandwidth())
+	}
+	w.Flush()
+	return strings.TrimSuffix(output.String(), "\n"), nil
+}
+
+func (a *Admin) kick(args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("usage: kick <id> [data]")
+	}
+	id, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return "", err
+	}
+	var data uint64
+	if len(args) == 2 {
+		if data, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			return "", err
+		}
+	}
+	peer, ok := a.peers[uint32(id)]
+	if !ok {
+		return "", fmt.Errorf("no peer %d", id)
+	}
+	peer.Disconnect(uint32(data))
+	return fmt.Sprintf("kicked %d", id), nil
+}
+
+func (a *Admin) ban(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", errors.New("usage: ban <ip|prefix> [duration]")
+	}
+	prefix, err := parsePrefix(args[0])
+	if err != nil {
+		return "", err
+	}
+	var duration time.Duration
+	if len(args) == 2 {
+		if duration, err = time.ParseDuration(args[1]); err != nil {
+			return "", err
+		}
+		if duration <= 0 {
+			return "", errors.New("duration must be positive")
+		}
+	}
+	if err := a.config.Bans.BanAddress(prefix, duration, "banned by an admin"); err != nil {
+		return "", err
+	}
+
+	kicked := 0
+	for _, peer := range a.peers {
+		if prefix.Contains(addrOf(peer)) {
+			peer.Disconnect(0)
+			kicked++
+		}
+	}
+	if duration > 0 {
+		return fmt.Sprintf("banned %s for %s, kicked %d peers", formatPrefix(prefix), duration, kicked), nil
+	}
+	return fmt.Sprintf("banned %s, kicked %d peers", formatPrefix(prefix), kicked), nil
+}
+
+func (a *Admin) unban(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: unban <ip|prefix>")
+	}
+	prefix, err := parsePrefix(args[0])
+	if err != nil {
+		return "", err
+	}
+	found, err := a.config.Bans.UnbanAddress(prefix)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%s is not banned", formatPrefix(prefix))
+	}
+	return fmt.Sprintf("unbanned %s", formatPrefix(prefix)), nil
+}
+
+func (a *Admin) listBans([]string) (string, error) {
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BANNED\tEXPIRES\tREASON")
+	for _, ban := range a.config.Bans.Bans() {
+		banned := "identity " + hex.EncodeToString(ban.Identity)
+		if ban.Prefix.IsValid() {
+			banned = formatPrefix(ban.Prefix)
+		}
+		expires := "never"
+		if !ban.Expires.IsZero() {
+			expires = ban.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", banned, expires, ban.Reason)
+	}
+	w.Flush()
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+func (a *Admin) notice(text string) (string, error) {
+	if text == "" {
+		return "", errors.New("usage: notice <text>")
+	}
+	if err := a.host.BroadcastString(text, a.config.NoticeChannel, enet.PacketFlagReliable); err != nil {
+		return "", err
+	}
+	return "notice sent", nil
+}
+
+func (a *Admin) bandwidth(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("usage: bandwidth <in> <out>")
+	}
+	incoming, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return "", err
+	}
+	outgoing, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return "", err
+	}
+	if err := enet.SetBandwidthLimit(a.host, uint32(incoming), uint32(outgoing)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bandwidth set to %d in, %d out", incoming, outgoing), nil
+}
+
+func (a *Admin) debug(args []string) (string, error) {
+	if a.config.LogLevel == nil {
+		return "", errors.New("the log level cannot be changed")
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return "", errors.New("usage: debug on|off")
+	}
+	if args[0] == "on" {
+		a.config.LogLevel.Set(slog.LevelDebug)
+	} else {
+		a.config.LogLevel.Set(slog.LevelInfo)
+	}
+	return "debug logging " + args[0], nil
+}
+
+// ipOf returns the IP address of peer, IPv4 addresses mapped to IPv6 ones unmapped.
+func ipOf(peer enet.Peer) string {
+	ip, err := netip.ParseAddr(peer.GetAddress().String())
+	if err != nil {
+		return peer.GetAddress().String()
+	}
+	return ip.Unmap().String()
+}
+
+// addrOf returns the IP address of peer as ipOf does, invalid if it has none.
+func addrOf(peer enet.Peer) netip.Addr {
+	ip, _ := netip.ParseAddr(peer.GetAddress().String())
+	return ip.Unmap()
+}
+
+// parsePrefix parses an IP address, as a single address prefix, or a prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// formatPrefix formats prefix, single addresses without their length.
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
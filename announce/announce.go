@@ -0,0 +1,350 @@
+// Package announce keeps the entry of a server on a master server current, so server
+// lists show it with its current player count. An Announcer reports the server every
+// interval, backs off while the master server is unreachable, and deregisters the
+// server as it stops:
+//
+//	announcer, err := announce.New(announce.Config{
+//		URL:  "https://master.example.com/servers",
+//		Info: announce.HostInfo(host, announce.Info{Address: "play.example.com:7777", Name: "EU #1"}),
+//	})
+//	go announcer.Run(ctx)
+//
+// Master servers are reached over HTTP or enet. Over HTTP, the server is announced by
+// POSTing its Info as JSON to the URL and deregistered by DELETEing it. Over enet, with
+// a URL like enet://master.example.com:7000, the announcer stays connected and sends
+// Messages as JSON, reliably on channel 0.
+package announce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultInterval is how often servers are announced by default
+	DefaultInterval = 30 * time.Second
+
+	// DefaultMinBackoff and DefaultMaxBackoff bound the time waited before retrying
+	// a failed announcement by default
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+
+	// connectTimeout bounds connecting to a master server over enet, and requests to
+	// one over HTTP.
+	connectTimeout = 10 * time.Second
+)
+
+// Info describes a server to the master server
+type Info struct {
+	// Address is the address players connect to
+	Address string `json:"address"`
+
+	Name       string            `json:"name,omitempty"`
+	Players    int               `json:"players"`
+	MaxPlayers int               `json:"max_players,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Message is a message sent to master servers over enet
+type Message struct {
+	// Type is "announce" or "deregister"
+	Type   string `json:"type"`
+	Server Info   `json:"server"`
+}
+
+// Config configures an Announcer
+type Config struct {
+	// URL is the address of the master server, an http, https or enet URL
+	URL string
+
+	// Info returns the current description of the server, called for every
+	// announcement
+	Info func() Info
+
+	// Interval is how often the server is announced. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// MinBackoff and MaxBackoff bound the time waited before retrying a failed
+	// announcement, doubling from one to the other. Default to DefaultMinBackoff and
+	// DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnError is called for every failed announcement. It may be nil.
+	OnError func(error)
+
+	// HTTPClient sends the requests to HTTP master servers. Defaults to a client with a
+	// timeout of 10 seconds.
+	HTTPClient *http.Client
+}
+
+// HostInfo returns an Info function describing a server as info with the number of
+// peers connected to host as its players
+func HostInfo(host enet.Host, info Info) func() Info {
+	return func() Info {
+		ret := info
+		ret.Players = int(host.GetStats().ConnectedPeers)
+		return ret
+	}
+}
+
+// master is the connection to a master server.
+type master interface {
+	// send announces the server, or deregisters it if deregister.
+	send(ctx context.Context, info Info, deregister bool) error
+
+	// wait waits for d or ctx to be done, keeping the connection alive.
+	wait(ctx context.Context, d time.Duration)
+
+	close()
+}
+
+// Announcer announces a server to a master server, see the package documentation
+type Announcer struct {
+	config Config
+	master master
+}
+
+// New creates an announcer for the master server at config.URL
+func New(config Config) (*Announcer, error) {
+	if config.Info == nil {
+		return nil, errors.New("no info function")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	config.MaxBackoff = max(config.MaxBackoff, config.MinBackoff)
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: connectTimeout}
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	announcer := &Announcer{config: config}
+	switch u.Scheme {
+	case "http", "https":
+		announcer.master = &httpMaster{url: config.URL, client: config.HTTPClient}
+	case "enet":
+		addr, err := resolve(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		announcer.master = &enetMaster{addr: addr}
+	default:
+		return nil, fmt.Errorf("unsupported master server scheme %q", u.Scheme)
+	}
+	return announcer, nil
+}
+
+// Run announces the server until ctx is done, then deregisters it, giving up after 10
+// seconds. Failed announcements are passed to OnError and retried with backoff.
+func (a *Announcer) Run(ctx context.Context) error {
+	defer a.master.close()
+
+	backoff := time.Duration(0)
+	for ctx.Err() == nil {
+		wait := a.config.Interval
+		if err := a.master.send(ctx, a.config.Info(), false); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if a.config.OnError != nil {
+				a.config.OnError(err)
+			}
+			backoff = min(max(backoff*2, a.config.MinBackoff), a.config.MaxBackoff)
+			// Jitter keeps servers restarted together from retrying together.
+			wait = backoff/2 + rand.N(backoff/2+1)
+		} else {
+			backoff = 0
+		}
+		a.master.wait(ctx, wait)
+	}
+
+	deregister, cancel := context.WithTimeout(context.WithoutCancel(ctx), connectTimeout)
+	defer cancel()
+	return a.master.send(deregister, a.config.Info(), true)
+}
+
+type httpMaster struct {
+	url    string
+	client *http.Client
+}
+
+func (m *httpMaster) send(ctx context.Context, info Info, deregister bool) error {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	method := http.MethodPost
+	if deregister {
+		method = http.MethodDelete
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("master server responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (m *httpMaster) wait(ctx context.Context, d time.Duration) {
+	sleep(ctx, d)
+}
+
+func (m *httpMaster) close() {}
+
+// enetMaster is a connection to a master server over enet, kept open between
+// announcements. Only used by the goroutine running the announcer.
+type enetMaster struct {
+	addr enet.Address
+	host enet.Host
+	peer enet.Peer
+}
+
+func (m *enetMaster) send(ctx context.Context, info Info, deregister bool) error {
+	if m.peer == nil {
+		if deregister {
+			// Never registered, or the master server dropped us already.
+			return nil
+		}
+		if err := m.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	message := Message{Type: "announce", Server: info}
+	if deregister {
+		message.Type = "deregister"
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := m.peer.SendBytes(data, 0, enet.PacketFlagReliable); err != nil {
+		return err
+	}
+	if deregister {
+		m.peer.Disconnect(0)
+		m.service(ctx, connectTimeout)
+	}
+	return nil
+}
+
+// connect connects to the master server.
+func (m *enetMaster) connect(ctx context.Context) error {
+	if m.host == nil {
+		host, err := enet.NewHost(nil, 1, 1, 0, 0, 0)
+		if err != nil {
+			return err
+		}
+		m.host = host
+	}
+	peer, err := m.host.Connect(m.addr, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(connectTimeout)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		event := m.host.Service(100)
+		switch event.GetType() {
+		case enet.EventConnect:
+			m.peer = peer
+			return nil
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			return errors.New("unable to connect to master server")
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+	peer.DisconnectNow(0)
+	return errors.New("timed out connecting to master server")
+}
+
+func (m *enetMaster) wait(ctx context.Context, d time.Duration) {
+	deadline := time.Now().Add(d)
+	if m.host != nil {
+		m.service(ctx, d)
+	}
+	// Once disconnected, the next announcement reconnects.
+	sleep(ctx, time.Until(deadline))
+}
+
+// service services the host for up to d, or until the master server disconnects.
+func (m *enetMaster) service(ctx context.Context, d time.Duration) {
+	deadline := time.Now().Add(d)
+	for m.peer != nil && time.Now().Before(deadline) && ctx.Err() == nil {
+		event := m.host.Service(uint32(min(time.Until(deadline), 100*time.Millisecond) / time.Millisecond))
+		switch event.GetType() {
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			m.peer = nil
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+}
+
+func (m *enetMaster) close() {
+	if m.host != nil {
+		m.host.Destroy()
+		m.host = nil
+	}
+}
+
+// sleep waits for d or ctx to be done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// resolve resolves the host and port of an enet URL.
+func resolve(hostport string) (enet.Address, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %q", host)
+	}
+	return enet.NewAddress(ips[0].String(), uint16(p)), nil
+}
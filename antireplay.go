@@ -0,0 +1,161 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+const (
+	// DefaultReplayWindow is how many sequence numbers back a replay guard remembers
+	// by default
+	DefaultReplayWindow = 1024
+
+	// replayStampSize is the size of the sequence number stamped on packets.
+	replayStampSize = 8
+)
+
+// ReplayGuard stamps the packets sent to each peer with a sequence number, and drops
+// received packets whose sequence number has been seen before or is too old to tell.
+// Injected copies of captured datagrams then can't replay game commands sent on the
+// unreliable and unsequenced paths, where enet itself only drops duplicates within a
+// short window. Both sides must use a guard on the channels they guard.
+//
+// Stamps are not authenticated, so an attacker able to rewrite them isn't stopped,
+// while packets sent with SendSealed are checked against replays anyway.
+type ReplayGuard interface {
+	// Stamp returns data headed by the next sequence number of peer
+	Stamp(peer Peer, data []byte) []byte
+
+	// Send stamps data and sends it to peer
+	Send(peer Peer, data []byte, channel uint8, flags PacketFlags) error
+
+	// Check returns the payload of stamped data received from peer, or false if it
+	// must be dropped as a replay
+	Check(peer Peer, data []byte) ([]byte, bool)
+
+	// Forget forgets the sequence numbers of peer. Peers must be forgotten once they
+	// disconnect, since their IDs are reused, Handle does it for the disconnect events
+	// it sees.
+	Forget(peer Peer)
+
+	// Handle forgets peers when they disconnect. It can be called for every event
+	// serviced.
+	Handle(event Event)
+}
+
+type replayGuardPeer struct {
+	next   uint64
+	window replayWindow
+}
+
+type enetReplayGuard struct {
+	lock   sync.Mutex
+	window int
+	peers  map[Peer]*replayGuardPeer
+}
+
+// NewReplayGuard creates a guard remembering window sequence numbers back per peer,
+// or DefaultReplayWindow if window is 0. Packets arriving later than window packets
+// after their successors are dropped too.
+func NewReplayGuard(window int) (ReplayGuard, error) {
+	if window < 0 {
+		return nil, errors.New("window must not be negative")
+	}
+	if window == 0 {
+		window = DefaultReplayWindow
+	}
+	return &enetReplayGuard{
+		window: window,
+		peers:  make(map[Peer]*replayGuardPeer),
+	}, nil
+}
+
+// peer returns the state of peer. Must be called with the lock held.
+func (g *enetReplayGuard) peer(peer Peer) *replayGuardPeer {
+	state, ok := g.peers[peer]
+	if !ok {
+		state = &replayGuardPeer{window: newReplayWindow(g.window)}
+		g.peers[peer] = state
+	}
+	return state
+}
+
+func (g *enetReplayGuard) Stamp(peer Peer, data []byte) []byte {
+	g.lock.Lock()
+	state := g.peer(peer)
+	seq := state.next
+	state.next++
+	g.lock.Unlock()
+
+	stamped := binary.BigEndian.AppendUint64(make([]byte, 0, replayStampSize+len(data)), seq)
+	return append(stamped, data...)
+}
+
+func (g *enetReplayGuard) Send(peer Peer, data []byte, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes(g.Stamp(peer, data), channel, flags)
+}
+
+func (g *enetReplayGuard) Check(peer Peer, data []byte) ([]byte, bool) {
+	if len(data) < replayStampSize {
+		return nil, false
+	}
+	seq := binary.BigEndian.Uint64(data)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.peer(peer).window.accept(seq) {
+		return nil, false
+	}
+	return data[replayStampSize:], true
+}
+
+func (g *enetReplayGuard) Forget(peer Peer) {
+	g.lock.Lock()
+	delete(g.peers, peer)
+	g.lock.Unlock()
+}
+
+func (g *enetReplayGuard) Handle(event Event) {
+	switch event.GetType() {
+	case EventDisconnect, EventDisconnectTimeout:
+		g.Forget(event.GetPeer())
+	}
+}
+
+// replayWindow remembers which of the latest sequence numbers have been seen, as a
+// ring of bits.
+type replayWindow struct {
+	// next is one past the highest sequence number seen.
+	next uint64
+	bits []uint64
+}
+
+func newReplayWindow(size int) replayWindow {
+	return replayWindow{bits: make([]uint64, (size+63)/64)}
+}
+
+// accept returns true and records seq if it has not been seen before and is recent
+// enough to tell.
+func (w *replayWindow) accept(seq uint64) bool {
+	size := uint64(len(w.bits)) * 64
+	if seq >= w.next {
+		// Slide the window forward, forgetting what falls out of it.
+		for i := w.next; i < seq && i-w.next < size; i++ {
+			w.bits[(i%size)/64] &^= 1 << (i % 64)
+		}
+		w.next = seq + 1
+		w.bits[(seq%size)/64] |= 1 << (seq % 64)
+		return true
+	}
+	if w.next-seq > size {
+		return false
+	}
+
+	word, bit := (seq%size)/64, uint64(1)<<(seq%64)
+	if w.bits[word]&bit != 0 {
+		return false
+	}
+	w.bits[word] |= bit
+	return true
+}
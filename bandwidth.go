@@ -0,0 +1,161 @@
+package enet
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	// bandwidthSampleInterval is the shortest span the loss of a peer is sampled over.
+	bandwidthSampleInterval = time.Second
+
+	// bandwidthLossWeight is the weight of a new sample in the smoothed loss.
+	bandwidthLossWeight = 0.25
+)
+
+// bandwidthInputs are the state of a peer its bandwidth is estimated from.
+type bandwidthInputs struct {
+	mtu        uint32
+	windowSize uint32
+
+	// throttle is the throttle of unreliable packets in percent.
+	throttle float32
+
+	// roundTripTime is the mean round trip time in milliseconds.
+	roundTripTime uint32
+
+	packetsSent uint64
+	packetsLost uint64
+
+	// incomingBandwidth is the downstream bandwidth of the peer and outgoingBandwidth
+	// the upstream bandwidth of the host, in bytes per second, 0 if unlimited.
+	incomingBandwidth uint32
+	outgoingBandwidth uint32
+}
+
+type peerBandwidth struct {
+	packetsSent uint64
+	packetsLost uint64
+	sampled     time.Time
+	loss        float64
+}
+
+// bandwidthTable holds the loss of the peers of a host, smoothed over the samples taken
+// as their bandwidth is estimated. Must only be used by the goroutine servicing the
+// host.
+type bandwidthTable struct {
+	peers map[enetPeer]*peerBandwidth
+}
+
+// estimateBandwidth estimates the bandwidth available to send to the peer in bytes per
+// second, 0 if it cannot be told yet.
+func (peer enetPeer) estimateBandwidth() uint32 {
+	inputs := peer.bandwidthInputs()
+	if inputs.mtu == 0 {
+		return 0
+	}
+	var loss float64
+	if host := peer.host(); host != nil {
+		host.features.join(featureBandwidth)
+		loss = host.bandwidth.sample(peer, inputs)
+	}
+	return estimateBandwidth(inputs, loss)
+}
+
+// sample returns the smoothed loss of peer, taking a new sample if due.
+func (table *bandwidthTable) sample(peer enetPeer, inputs bandwidthInputs) float64 {
+	if table.peers == nil {
+		table.peers = make(map[enetPeer]*peerBandwidth)
+	}
+
+	now := time.Now()
+	bandwidth, ok := table.peers[peer]
+	if !ok {
+		// Until a sample is due, take the loss over the whole connection.
+		bandwidth = &peerBandwidth{sampled: now}
+		if inputs.packetsSent > 0 {
+			bandwidth.loss = min(float64(inputs.packetsLost)/float64(inputs.packetsSent), 1)
+		}
+		bandwidth.packetsSent = inputs.packetsSent
+		bandwidth.packetsLost = inputs.packetsLost
+		table.peers[peer] = bandwidth
+		return bandwidth.loss
+	}
+
+	sent := rateDelta(bandwidth.packetsSent, inputs.packetsSent)
+	if now.Sub(bandwidth.sampled) < bandwidthSampleInterval || sent == 0 {
+		return bandwidth.loss
+	}
+	lost := rateDelta(bandwidth.packetsLost, inputs.packetsLost)
+	loss := min(float64(lost)/float64(sent), 1)
+	bandwidth.loss += (loss - bandwidth.loss) * bandwidthLossWeight
+	bandwidth.packetsSent = inputs.packetsSent
+	bandwidth.packetsLost = inputs.packetsLost
+	bandwidth.sampled = now
+	return bandwidth.loss
+}
+
+// forget drops the loss of a peer that disconnected, as its slot is reused by the next
+// peer to connect.
+func (table *bandwidthTable) forget(event *enetEvent) {
+	eventType := event.GetType()
+	if eventType != EventDisconnect && eventType != EventDisconnectTimeout {
+		return
+	}
+	if peer, ok := event.GetPeer().(enetPeer); ok {
+		delete(table.peers, peer)
+	}
+}
+
+// estimateBandwidth estimates the bandwidth to a peer in bytes per second from its state
+// and smoothed loss.
+//
+// Like enet, the reliable data in transit is capped by the window of the peer scaled by
+// its throttle, and no more than that window can be sent per round trip. Loss caps it
+// further like TCP would back off, following the formula of Mathis et al. Limits
+// configured on either side cap the result.
+func estimateBandwidth(inputs bandwidthInputs, loss float64) uint32 {
+	rtt := float64(max(inputs.roundTripTime, 1)) / 1000
+	window := max(float64(inputs.windowSize)*float64(inputs.throttle)/100, float64(inputs.mtu))
+	estimate := window / rtt
+
+	if loss > 0 {
+		estimate = min(estimate, float64(inputs.mtu)/rtt*1.22/math.Sqrt(loss))
+	}
+	if inputs.incomingBandwidth != 0 {
+		estimate = min(estimate, float64(inputs.incomingBandwidth))
+	}
+	if inputs.outgoingBandwidth != 0 {
+		estimate = min(estimate, float64(inputs.outgoingBandwidth))
+	}
+	return uint32(min(estimate, math.MaxUint32))
+}
+
+// SetBandwidthLimit changes the incoming and outgoing bandwidth of host in bytes per
+// second, as passed to NewHost, 0 meaning unlimited. Peers are told the new limits and
+// throttle to them.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func SetBandwidthLimit(host Host, incoming, outgoing uint32) error {
+	var err error
+	limit := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("bandwidth limits are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		err = h.setBandwidthLimit(incoming, outgoing)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(limit)
+	} else {
+		limit(host)
+	}
+	return err
+}
@@ -0,0 +1,431 @@
+package enet
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ban is an entry of a BanList, banning either the addresses of a prefix or an identity
+type Ban struct {
+	// Prefix is the prefix of the addresses banned, a single address for a /32 or a
+	// /128. It is invalid for identity bans.
+	Prefix netip.Prefix `json:",omitzero"`
+
+	// Identity is the static public key banned, see Peer.Identity. It is nil for
+	// address bans.
+	Identity []byte `json:",omitempty"`
+
+	// Reason is what the ban was given for, for operators
+	Reason string `json:",omitempty"`
+
+	// Expires is when the ban expires, zero if it is permanent
+	Expires time.Time `json:",omitzero"`
+}
+
+// expired returns true if the ban expired at now.
+func (ban Ban) expired(now time.Time) bool {
+	return !ban.Expires.IsZero() && !now.Before(ban.Expires)
+}
+
+// BanStore persists the bans of a BanList, for example to a file or a database, so they
+// survive restarts
+type BanStore interface {
+	// Load returns the bans saved
+	Load() ([]Ban, error)
+
+	// Save replaces the bans saved with bans
+	Save(bans []Ban) error
+}
+
+// fileBanStore saves bans as JSON to a file.
+type fileBanStore struct {
+	path string
+}
+
+// NewFileBanStore creates a store saving bans as JSON to the file at path, which is
+// replaced atomically on every save. A missing file holds no bans.
+func NewFileBanStore(path string) BanStore {
+	return &fileBanStore{path: path}
+}
+
+func (store *fileBanStore) Load() ([]Ban, error) {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bans []Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+func (store *fileBanStore) Save(bans []Ban) error {
+	data, err := json.MarshalIndent(bans, "", "\t")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), store.path)
+}
+
+// BanList holds bans of addresses and identities that expire, see NewBanList. It is
+// safe for concurrent use.
+type BanList interface {
+	// BanAddress bans the addresses of prefix for duration, forever if it is 0,
+	// replacing any ban of the same prefix. A single address is banned with a /32 or a
+	// /128 prefix.
+	BanAddress(prefix netip.Prefix, duration time.Duration, reason string) error
+
+	// BanIdentity bans the peers with identity, their static public key, for duration,
+	// forever if it is 0, replacing any ban of the same identity
+	BanIdentity(identity []byte, duration time.Duration, reason string) error
+
+	// UnbanAddress lifts the ban of prefix. Returns false if it was not banned.
+	UnbanAddress(prefix netip.Prefix) (bool, error)
+
+	// UnbanIdentity lifts the ban of identity. Returns false if it was not banned.
+	UnbanIdentity(identity []byte) (bool, error)
+
+	// Banned returns the ban applying to a peer at addr with identity, which may be nil
+	// if it is not known. Returns false if neither is banned.
+	Banned(addr netip.Addr, identity []byte) (Ban, bool)
+
+	// Bans returns the bans that have not expired
+	Bans() []Ban
+}
+
+type banList struct {
+	lock       sync.RWMutex
+	store      BanStore
+	clock      Clock
+	addresses  map[netip.Addr]Ban
+	prefixes   []Ban
+	identities map[string]Ban
+}
+
+// NewBanList creates a ban list with the bans of store, which saves them again on every
+// change. The store may be nil for bans to only last as long as the list. Expired bans
+// no longer apply, and are dropped from the store on the next change.
+//
+// EnforceBans enforces the bans of a list on a host.
+func NewBanList(store BanStore) (BanList, error) {
+	list := &banList{
+		store:      store,
+		clock:      SystemClock,
+		addresses:  make(map[netip.Addr]Ban),
+		identities: make(map[string]Ban),
+	}
+	if store == nil {
+		return list, nil
+	}
+
+	bans, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	now := list.clock.Now()
+	for _, ban := range bans {
+		if ban.expired(now) {
+			continue
+		}
+		switch {
+		case ban.Identity != nil:
+			list.identities[string(ban.Identity)] = ban
+		case ban.Prefix.IsValid():
+			list.add(ban)
+		}
+	}
+	return list, nil
+}
+
+// expiry returns when a ban for duration given now expires.
+func (list *banList) expiry(duration time.Duration) time.Time {
+	if duration == 0 {
+		return time.Time{}
+	}
+	return list.clock.Now().Add(duration)
+}
+
+// add adds an address ban, replacing the ban of the same prefix.
+func (list *banList) add(ban Ban) {
+	ban.Prefix = normalizePrefix(ban.Prefix)
+	if ban.Prefix.IsSingleIP() {
+		list.addresses[ban.Prefix.Addr()] = ban
+		return
+	}
+	list.prefixes = slices.DeleteFunc(list.prefixes, func(other Ban) bool { return other.Prefix == ban.Prefix })
+	list.prefixes = append(list.prefixes, ban)
+}
+
+func (list *banList) BanAddress(prefix netip.Prefix, duration time.Duration, reason string) error {
+	if !prefix.IsValid() {
+		return errors.New("invalid prefix")
+	}
+	if duration < 0 {
+		return errors.New("duration is negative")
+	}
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.add(Ban{Prefix: prefix, Reason: reason, Expires: list.expiry(duration)})
+	return list.save()
+}
+
+func (list *banList) BanIdentity(identity []byte, duration time.Duration, reason string) error {
+	if len(identity) == 0 {
+		return errors.New("identity is empty")
+	}
+	if duration < 0 {
+		return errors.New("duration is negative")
+	}
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.identities[string(identity)] = Ban{
+		Identity: append([]byte(nil), identity...),
+		Reason:   reason,
+		Expires:  list.expiry(duration),
+	}
+	return list.save()
+}
+
+func (list *banList) UnbanAddress(prefix netip.Prefix) (bool, error) {
+	prefix = normalizePrefix(prefix)
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	found := false
+	if prefix.IsSingleIP() {
+		_, found = list.addresses[prefix.Addr()]
+		delete(list.addresses, prefix.Addr())
+	} else {
+		n := len(list.prefixes)
+		list.prefixes = slices.DeleteFunc(list.prefixes, func(ban Ban) bool { return ban.Prefix == prefix })
+		found = len(list.prefixes) < n
+	}
+	if !found {
+		return false, nil
+	}
+	return true, list.save()
+}
+
+func (list *banList) UnbanIdentity(identity []byte) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if _, ok := list.identities[string(identity)]; !ok {
+		return false, nil
+	}
+	delete(list.identities, string(identity))
+	return true, list.save()
+}
+
+func (list *banList) Banned(addr netip.Addr, identity []byte) (Ban, bool) {
+	now := list.clock.Now()
+	addr = addr.Unmap()
+
+	list.lock.RLock()
+	defer list.lock.RUnlock()
+	if identity != nil {
+		if ban, ok := list.identities[string(identity)]; ok && !ban.expired(now) {
+			return ban, true
+		}
+	}
+	if !addr.IsValid() {
+		return Ban{}, false
+	}
+	if ban, ok := list.addresses[addr]; ok && !ban.expired(now) {
+		return ban, true
+	}
+	for _, ban := range list.prefixes {
+		if ban.Prefix.Contains(addr) && !ban.expired(now) {
+			return ban, true
+		}
+	}
+	return Ban{}, false
+}
+
+func (list *banList) Bans() []Ban {
+	list.lock.RLock()
+	defer list.lock.RUnlock()
+	return list.active(list.clock.Now())
+}
+
+// empty returns true if the list holds no ban, expired or not.
+func (list *banList) empty() bool {
+	list.lock.RLock()
+	defer list.lock.RUnlock()
+	return len(list.addresses) == 0 && len(list.prefixes) == 0 && len(list.identities) == 0
+}
+
+// active returns the bans that have not expired at now, addresses first.
+func (list *banList) active(now time.Time) []Ban {
+	var bans []Ban
+	for _, ban := range list.addresses {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	for _, ban := range list.prefixes {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int { return a.Prefix.Addr().Compare(b.Prefix.Addr()) })
+	for _, ban := range list.identities {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// save drops the expired bans and saves the others to the store. The list must be
+// locked.
+func (list *banList) save() error {
+	now := list.clock.Now()
+	for addr, ban := range list.addresses {
+		if ban.expired(now) {
+			delete(list.addresses, addr)
+		}
+	}
+	list.prefixes = slices.DeleteFunc(list.prefixes, func(ban Ban) bool { return ban.expired(now) })
+	for identity, ban := range list.identities {
+		if ban.expired(now) {
+			delete(list.identities, identity)
+		}
+	}
+
+	if list.store == nil {
+		return nil
+	}
+	return list.store.Save(list.active(now))
+}
+
+// normalizePrefix unmaps IPv4 addresses mapped to IPv6 ones and masks the bits past the
+// prefix, so equal prefixes compare equal.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked()
+}
+
+// banTable enforces a BanList on a host.
+type banTable struct {
+	lock sync.RWMutex
+	list *banList
+}
+
+// EnforceBans makes host refuse the peers banned by list: datagrams from banned
+// addresses are dropped before enet sees them, and peers connecting from a banned
+// address or with a banned identity are disconnected at once, without the application
+// seeing them. Identities are only known once Noise handshakes complete, see
+// EnableNoise. Bans do not disconnect the peers already connected, which the
+// application disconnects as it bans them. Calling it again replaces the list, and a
+// nil list stops enforcing bans.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func EnforceBans(host Host, list BanList) error {
+	l, ok := list.(*banList)
+	if list != nil && !ok {
+		return errors.New("list must be created with NewBanList")
+	}
+
+	var err error
+	enforce := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("bans are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.bans
+		table.lock.Lock()
+		install := table.list == nil && l != nil
+		table.list = l
+		table.lock.Unlock()
+
+		if install {
+			h.intercepts.add(h, table.intercept)
+			h.features.join(featureBans)
+		}
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(enforce)
+	} else {
+		enforce(host)
+	}
+	return err
+}
+
+// current returns the list enforced, nil if there is none or it is empty.
+func (table *banTable) current() *banList {
+	table.lock.RLock()
+	list := table.list
+	table.lock.RUnlock()
+	if list == nil || list.empty() {
+		return nil
+	}
+	return list
+}
+
+// intercept drops the datagrams from banned addresses.
+func (table *banTable) intercept(addr rawAddress, data []byte) bool {
+	list := table.current()
+	if list == nil {
+		return false
+	}
+	_, banned := list.Banned(udpAddrOf(addr).AddrPort().Addr(), nil)
+	return banned
+}
+
+// admit disconnects the peers of host connecting while banned. Returns true if the
+// event has been consumed.
+func (table *banTable) admit(host *enetHost, event *enetEvent) bool {
+	if event.GetType() != EventConnect {
+		return false
+	}
+	list := table.current()
+	if list == nil {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+
+	ban, banned := list.Banned(udpAddrOf(peer.address()).AddrPort().Addr(), peer.Identity())
+	if !banned {
+		return false
+	}
+	logBanned(host, peer, ban)
+	host.noise.forget(peer)
+	peer.DisconnectNow(0)
+	return true
+}
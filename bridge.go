@@ -0,0 +1,147 @@
+package enet
+
+import (
+	"errors"
+	"sync"
+)
+
+// Bridge connects virtual peers that do not speak enet, such as browser clients, and
+// produces their events. A host created with NewBridgedHost merges them into its own
+// event stream so the application handles both kinds of peers the same way.
+type Bridge interface {
+	// Events returns the channel the bridge posts the events of its peers on. It is
+	// closed once the bridge is closed.
+	Events() <-chan Event
+
+	// Broadcast sends data to all peers connected through the bridge
+	Broadcast(data []byte, channel uint8, flags PacketFlags) error
+
+	// Close disconnects all peers and stops accepting new ones
+	Close() error
+}
+
+// bridgeWaitSlice bounds how long a bridged host blocks in the wrapped host while
+// bridge events may be waiting, in milliseconds.
+const bridgeWaitSlice = 1
+
+type bridgedHost struct {
+	Host
+	bridges []Bridge
+
+	events chan Event
+	wg     sync.WaitGroup
+}
+
+// NewBridgedHost wraps host so Service also returns the events of peers connected
+// through the given bridges. Broadcasts reach the peers of the bridges as well, and
+// destroying the host closes them.
+func NewBridgedHost(host Host, bridges ...Bridge) Host {
+	bridged := &bridgedHost{
+		Host:    host,
+		bridges: bridges,
+		events:  make(chan Event),
+	}
+
+	for _, bridge := range bridges {
+		bridged.wg.Add(1)
+		go func(events <-chan Event) {
+			defer bridged.wg.Done()
+			for event := range events {
+				bridged.events <- event
+			}
+		}(bridge.Events())
+	}
+
+	return bridged
+}
+
+func (host *bridgedHost) Destroy() error {
+	var errs []error
+	for _, bridge := range host.bridges {
+		errs = append(errs, bridge.Close())
+	}
+
+	// Drop whatever the bridges still had queued until they are done.
+	done := make(chan struct{})
+	go func() {
+		host.wg.Wait()
+		close(done)
+	}()
+	for drained := false; !drained; {
+		select {
+		case <-host.events:
+		case <-done:
+			drained = true
+		}
+	}
+
+	errs = append(errs, host.Host.Destroy())
+	return errors.Join(errs...)
+}
+
+func (host *bridgedHost) Service(timeout uint32) Event {
+	event := NewEvent()
+	host.ServiceV2(event, timeout)
+	return event
+}
+
+func (host *bridgedHost) ServiceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.ServiceV2, events, timeout)
+}
+
+func (host *bridgedHost) ServiceV2(event *enetEvent, timeout uint32) int {
+	// The wrapped host can't wait on the bridges, so wait on it in small slices and
+	// look for bridge events in between.
+	for {
+		select {
+		case received := <-host.events:
+			*event = enetEvent{}
+			event.goEvent = received
+			event.timestamp = received.GetTimestamp()
+			return 1
+		default:
+		}
+
+		wait := timeout
+		if wait > bridgeWaitSlice {
+			wait = bridgeWaitSlice
+		}
+		if ret := host.Host.ServiceV2(event, wait); ret != 0 || timeout == 0 {
+			return ret
+		}
+		timeout -= wait
+	}
+}
+
+func (host *bridgedHost) BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error {
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	if err := host.Host.BroadcastPacket(packet, channel); err != nil {
+		packet.Destroy()
+		return err
+	}
+	return host.broadcastBridges(data, channel, flags)
+}
+
+func (host *bridgedHost) BroadcastPacket(packet Packet, channel uint8) error {
+	// Copy the data first, the wrapped host takes ownership of the packet.
+	data, flags := packet.GetData(), packet.GetFlags()
+	if err := host.Host.BroadcastPacket(packet, channel); err != nil {
+		return err
+	}
+	return host.broadcastBridges(data, channel, flags)
+}
+
+func (host *bridgedHost) broadcastBridges(data []byte, channel uint8, flags PacketFlags) error {
+	var errs []error
+	for _, bridge := range host.bridges {
+		errs = append(errs, bridge.Broadcast(data, channel, flags))
+	}
+	return errors.Join(errs...)
+}
+
+func (host *bridgedHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
+}
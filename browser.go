@@ -0,0 +1,18 @@
+package enet
+
+import "sync/atomic"
+
+var browserURL atomic.Pointer[func(addr Address) string]
+
+// SetBrowserURL sets how builds for js/wasm map the address passed to Host.Connect to
+// the URL of the bridge serving it. Browsers can't speak enet, so they connect to a
+// wsbridge or, for https URLs, a wtbridge instead. By default the address is dialed
+// as a WebSocket at the root of the same host and port. Other builds ignore the URL,
+// so it can be set from code shared with native clients.
+func SetBrowserURL(url func(addr Address) string) {
+	if url == nil {
+		browserURL.Store(nil)
+		return
+	}
+	browserURL.Store(&url)
+}
@@ -0,0 +1,133 @@
+package enet
+
+import "fmt"
+
+// Channel names a channel and the flags its packets are sent with
+type Channel struct {
+	Name  string
+	ID    uint8
+	Flags PacketFlags
+}
+
+// Channels maps channel names to their IDs and default flags, so the application can
+// send by name instead of scattering channel numbers around:
+//
+//	channels, err := enet.NewChannels(
+//		enet.Channel{Name: "state", ID: 0},
+//		enet.Channel{Name: "chat", ID: 1, Flags: enet.PacketFlagReliable},
+//	)
+//	host.Connect(addr, channels.Count(), 0)
+//	channels.Send(peer, "chat", data)
+type Channels interface {
+	// Lookup returns the channel with the given name
+	Lookup(name string) (Channel, bool)
+
+	// ID returns the ID of the channel with the given name
+	ID(name string) (uint8, bool)
+
+	// Name returns the name of the channel with the given ID, or an empty string if
+	// it has none. This suits receive events, see Event.GetChannelID.
+	Name(id uint8) string
+
+	// Count returns the channel count covering all channels, to create hosts and
+	// connect to peers with
+	Count() uint64
+
+	// Send sends data to peer on the named channel with its flags
+	Send(peer Peer, name string, data []byte) error
+
+	// SendString sends str to peer on the named channel with its flags
+	SendString(peer Peer, name string, str string) error
+
+	// SendAsync queues data for peer on the named channel with its flags, see
+	// Peer.SendAsync
+	SendAsync(peer Peer, name string, data []byte) error
+
+	// Broadcast broadcasts data to all peers of host on the named channel with its
+	// flags
+	Broadcast(host Host, name string, data []byte) error
+}
+
+type enetChannels struct {
+	byName map[string]Channel
+	names  [256]string
+	count  uint64
+}
+
+// NewChannels creates a registry of the channels. Names and IDs must be unique.
+func NewChannels(channels ...Channel) (Channels, error) {
+	c := &enetChannels{byName: make(map[string]Channel, len(channels))}
+	for _, channel := range channels {
+		if channel.Name == "" {
+			return nil, fmt.Errorf("channel %d has no name", channel.ID)
+		}
+		if _, ok := c.byName[channel.Name]; ok {
+			return nil, fmt.Errorf("channel %q is declared twice", channel.Name)
+		}
+		if name := c.names[channel.ID]; name != "" {
+			return nil, fmt.Errorf("channels %q and %q have the same ID %d", name, channel.Name, channel.ID)
+		}
+		c.byName[channel.Name] = channel
+		c.names[channel.ID] = channel.Name
+		c.count = max(c.count, uint64(channel.ID)+1)
+	}
+	return c, nil
+}
+
+func (c *enetChannels) Lookup(name string) (Channel, bool) {
+	channel, ok := c.byName[name]
+	return channel, ok
+}
+
+func (c *enetChannels) ID(name string) (uint8, bool) {
+	channel, ok := c.byName[name]
+	return channel.ID, ok
+}
+
+func (c *enetChannels) Name(id uint8) string {
+	return c.names[id]
+}
+
+func (c *enetChannels) Count() uint64 {
+	return c.count
+}
+
+func (c *enetChannels) lookup(name string) (Channel, error) {
+	channel, ok := c.byName[name]
+	if !ok {
+		return channel, fmt.Errorf("unknown channel %q", name)
+	}
+	return channel, nil
+}
+
+func (c *enetChannels) Send(peer Peer, name string, data []byte) error {
+	channel, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	return peer.SendBytes(data, channel.ID, channel.Flags)
+}
+
+func (c *enetChannels) SendString(peer Peer, name string, str string) error {
+	channel, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	return peer.SendString(str, channel.ID, channel.Flags)
+}
+
+func (c *enetChannels) SendAsync(peer Peer, name string, data []byte) error {
+	channel, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	return peer.SendAsync(data, channel.ID, channel.Flags)
+}
+
+func (c *enetChannels) Broadcast(host Host, name string, data []byte) error {
+	channel, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	return host.BroadcastBytes(data, channel.ID, channel.Flags)
+}
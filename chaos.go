@@ -0,0 +1,210 @@
+package enet
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Chaos injects faults into the network underneath hosts and into their connections,
+// driven by tests to check how applications handle reconnects and timeouts. Faults of
+// the network apply to the datagrams a NetworkSimulator carries both ways, timed by
+// its clock.
+type Chaos interface {
+	// Blackhole drops every datagram exchanged with the peer at addr for d
+	Blackhole(addr Address, d time.Duration)
+
+	// Flap cuts the peer at addr off for down after every up, for d
+	Flap(addr Address, up, down, d time.Duration)
+
+	// DelayAcks holds the datagrams acknowledging traffic exchanged with the peer at
+	// addr back by delay, for d. Acknowledgements are found in datagrams of the default
+	// protocol only, see ProtocolConfig.
+	DelayAcks(addr Address, delay, d time.Duration)
+
+	// Heal ends the faults of the network of the peer at addr
+	Heal(addr Address)
+
+	// ResetPeer drops the connection to peer without telling it, as if the host had
+	// crashed, leaving the peer to time out. No event is returned for it. It must be
+	// called by the goroutine servicing the host of peer, or on a peer of a SafeHost.
+	ResetPeer(peer Peer) error
+
+	// ResetRandomPeer resets a connected peer of host picked at random, see ResetPeer.
+	// Returns nil if host has no connected peer. It must be called by the goroutine
+	// servicing the host, or on a SafeHost.
+	ResetRandomPeer(host Host) (Peer, error)
+}
+
+// simFaults are the faults of the network of a peer, each lasting until its time.
+type simFaults struct {
+	blackholeUntil time.Time
+
+	flapStart time.Time
+	flapUntil time.Time
+	flapUp    time.Duration
+	flapDown  time.Duration
+
+	ackDelayUntil time.Time
+	ackDelay      time.Duration
+}
+
+// drops returns true if the faults drop a datagram at now.
+func (faults *simFaults) drops(now time.Time) bool {
+	if now.Before(faults.blackholeUntil) {
+		return true
+	}
+	if now.Before(faults.flapUntil) {
+		period := faults.flapUp + faults.flapDown
+		return now.Sub(faults.flapStart)%period >= faults.flapUp
+	}
+	return false
+}
+
+// delay returns the time the faults hold a datagram back at now.
+func (faults *simFaults) delay(now time.Time, data []byte) time.Duration {
+	if !now.Before(faults.ackDelayUntil) || !carriesAck(data) {
+		return 0
+	}
+	return faults.ackDelay
+}
+
+// expired returns true once none of the faults last anymore.
+func (faults *simFaults) expired(now time.Time) bool {
+	return !now.Before(faults.blackholeUntil) && !now.Before(faults.flapUntil) && !now.Before(faults.ackDelayUntil)
+}
+
+// carriesAck returns true if a datagram of the default protocol acknowledges commands.
+func carriesAck(data []byte) bool {
+	_, offset, err := decodeWireHeader(data, ProtocolConfig{})
+	if err != nil {
+		return false
+	}
+	for offset < len(data) {
+		command, n, err := decodeWireCommand(data[offset:])
+		if err != nil {
+			return false
+		}
+		if command.Type == CommandAcknowledge {
+			return true
+		}
+		offset += n
+	}
+	return false
+}
+
+type enetChaos struct {
+	sim *netsim
+}
+
+// NewChaos creates a chaos controller injecting faults into the network of sim, which
+// must be a NetworkSimulator of this package. Peers are reset at random with the
+// randomness of sim, so seeded simulations stay reproducible.
+func NewChaos(sim NetworkSimulator) (Chaos, error) {
+	s, ok := sim.(*netsim)
+	if !ok {
+		return nil, errors.New("chaos is only supported on network simulators of this package")
+	}
+	return &enetChaos{sim: s}, nil
+}
+
+func (chaos *enetChaos) Blackhole(addr Address, d time.Duration) {
+	chaos.sim.fault(addr, func(faults *simFaults, now time.Time) {
+		faults.blackholeUntil = now.Add(d)
+	})
+}
+
+func (chaos *enetChaos) Flap(addr Address, up, down, d time.Duration) {
+	if up <= 0 || down <= 0 {
+		return
+	}
+	chaos.sim.fault(addr, func(faults *simFaults, now time.Time) {
+		faults.flapStart = now
+		faults.flapUntil = now.Add(d)
+		faults.flapUp = up
+		faults.flapDown = down
+	})
+}
+
+func (chaos *enetChaos) DelayAcks(addr Address, delay, d time.Duration) {
+	chaos.sim.fault(addr, func(faults *simFaults, now time.Time) {
+		faults.ackDelayUntil = now.Add(d)
+		faults.ackDelay = delay
+	})
+}
+
+func (chaos *enetChaos) Heal(addr Address) {
+	sim := chaos.sim
+	sim.lock.Lock()
+	delete(sim.faults, simAddrKey(peerUDPAddr(addr)))
+	sim.lock.Unlock()
+}
+
+func (chaos *enetChaos) ResetPeer(peer Peer) error {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+		if ok {
+			safe.host.do(p.resetPeer)
+			return nil
+		}
+	}
+	if !ok {
+		return errors.New("resetting is only supported on enet peers")
+	}
+	p.resetPeer()
+	return nil
+}
+
+func (chaos *enetChaos) ResetRandomPeer(host Host) (Peer, error) {
+	var (
+		ret Peer
+		err error
+	)
+	reset := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("resetting is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		peers := h.connectedPeerList()
+		if len(peers) == 0 {
+			return
+		}
+		peer := peers[chaos.sim.random.intN(len(peers))]
+		peer.resetPeer()
+		ret = peer
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(reset)
+		if ret != nil {
+			ret = safePeer{Peer: ret, host: safe}
+		}
+	} else {
+		reset(host)
+	}
+	return ret, err
+}
+
+// fault updates the faults of the network of the peer at addr.
+func (sim *netsim) fault(addr Address, update func(faults *simFaults, now time.Time)) {
+	key := simAddrKey(peerUDPAddr(addr))
+	now := sim.clock.Now()
+
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	if sim.faults == nil {
+		sim.faults = make(map[netip.AddrPort]*simFaults)
+	}
+	faults, ok := sim.faults[key]
+	if !ok {
+		faults = &simFaults{}
+		sim.faults[key] = faults
+	}
+	update(faults, now)
+}
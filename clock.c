@@ -0,0 +1,12 @@
+//go:build !purego && !(js && wasm)
+
+#include "enet.h"
+#include "_cgo_export.h"
+
+static uint32_t goenet_host_time(ENetHost* host) {
+	return goenetHostTime(host);
+}
+
+void goenet_host_set_clock(ENetHost* host) {
+	enet_host_set_time_callback(host, goenet_host_time);
+}
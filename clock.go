@@ -0,0 +1,265 @@
+package enet
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the features that can run off a virtual clock, such as hosts,
+// service loops and the NetworkSimulator, so tests can be reproduced and fast-forwarded
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f once d has elapsed. The system clock calls it on a goroutine
+	// of its own, a VirtualClock while it is advanced past the time f is due.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a call scheduled with Clock.AfterFunc
+type ClockTimer interface {
+	// Stop cancels the call. Returns false if it was already made or stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the system
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// VirtualClock is a Clock that only moves when told to, making the scheduled calls
+// that fall due as it does in the order they are due, on the goroutine moving it
+type VirtualClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers virtualTimers
+	seq    uint64
+}
+
+// NewVirtualClock creates a virtual clock starting at start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (clock *VirtualClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *VirtualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	clock.seq++
+	timer := &virtualTimer{clock: clock, due: clock.now.Add(max(d, 0)), seq: clock.seq, f: f}
+	heap.Push(&clock.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d, making the calls that fall due on the way with
+// the clock set to the time they were due. Calls scheduled by those calls are made
+// too if they fall due before the clock reaches its new time.
+func (clock *VirtualClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	end := clock.now.Add(max(d, 0))
+	clock.lock.Unlock()
+	clock.advanceTo(end)
+}
+
+// AdvanceToNext moves the clock forward to the time the next call is due and makes it,
+// with any other due at the same time. Returns false without moving the clock if no
+// call is scheduled.
+func (clock *VirtualClock) AdvanceToNext() bool {
+	clock.lock.Lock()
+	if len(clock.timers) == 0 {
+		clock.lock.Unlock()
+		return false
+	}
+	due := clock.timers[0].due
+	clock.lock.Unlock()
+	clock.advanceTo(due)
+	return true
+}
+
+func (clock *VirtualClock) advanceTo(end time.Time) {
+	for {
+		clock.lock.Lock()
+		if len(clock.timers) == 0 || clock.timers[0].due.After(end) {
+			if end.After(clock.now) {
+				clock.now = end
+			}
+			clock.lock.Unlock()
+			return
+		}
+		timer := heap.Pop(&clock.timers).(*virtualTimer)
+		timer.index = -1
+		if timer.due.After(clock.now) {
+			clock.now = timer.due
+		}
+		clock.lock.Unlock()
+
+		timer.f()
+	}
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	due   time.Time
+	seq   uint64
+	f     func()
+	index int
+}
+
+func (timer *virtualTimer) Stop() bool {
+	timer.clock.lock.Lock()
+	defer timer.clock.lock.Unlock()
+
+	if timer.index < 0 {
+		return false
+	}
+	heap.Remove(&timer.clock.timers, timer.index)
+	timer.index = -1
+	return true
+}
+
+// virtualTimers is a heap of the calls scheduled on a virtual clock, the first due
+// first and those due together in the order they were scheduled.
+type virtualTimers []*virtualTimer
+
+func (t virtualTimers) Len() int { return len(t) }
+func (t virtualTimers) Less(i, j int) bool {
+	if t[i].due.Equal(t[j].due) {
+		return t[i].seq < t[j].seq
+	}
+	return t[i].due.Before(t[j].due)
+}
+func (t virtualTimers) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+	t[i].index = i
+	t[j].index = j
+}
+func (t *virtualTimers) Push(x any) {
+	timer := x.(*virtualTimer)
+	timer.index = len(*t)
+	*t = append(*t, timer)
+}
+func (t *virtualTimers) Pop() any {
+	old := *t
+	timer := old[len(old)-1]
+	old[len(old)-1] = nil
+	*t = old[:len(old)-1]
+	return timer
+}
+
+// hostClock is the clock of a host running off a Clock, in milliseconds counted on from
+// the time of the host as it was set so its time never goes back.
+type hostClock struct {
+	clock Clock
+	start time.Time
+	base  uint32
+
+	// virtual is set unless clock is the SystemClock.
+	virtual bool
+}
+
+// now returns the time of the host in milliseconds.
+func (clock *hostClock) now() uint32 {
+	return clock.base + uint32(clock.clock.Now().Sub(clock.start)/time.Millisecond)
+}
+
+// SetHostClock makes the timing of host, such as timeouts, retransmissions, pings and
+// throttling, run off clock, so tests can fast-forward it with a VirtualClock instead of
+// sleeping. Service never waits for events on a host running off a virtual clock, as
+// the time it would wait for only passes as the clock is advanced. The clock carries on
+// from the current time of the host. Passing nil or the SystemClock makes the host run
+// off the time of the system again. Hosts in the browser are timed by their bridges and
+// cannot run off a clock.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func SetHostClock(host Host, clock Clock) error {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	var err error
+	set := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("the clock can only be set on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		err = h.setClock(&hostClock{
+			clock:   clock,
+			start:   clock.Now(),
+			base:    h.time(),
+			virtual: clock != SystemClock,
+		})
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(set)
+	} else {
+		set(host)
+	}
+	return err
+}
+
+// clockTicker ticks every interval of a Clock. Like a time.Ticker, it drops ticks for
+// a slow receiver.
+type clockTicker struct {
+	C <-chan struct{}
+
+	clock    Clock
+	interval time.Duration
+	c        chan struct{}
+
+	lock    sync.Mutex
+	timer   ClockTimer
+	stopped bool
+}
+
+func newClockTicker(clock Clock, interval time.Duration) *clockTicker {
+	c := make(chan struct{}, 1)
+	ticker := &clockTicker{C: c, clock: clock, interval: interval, c: c}
+	ticker.arm()
+	return ticker
+}
+
+func (ticker *clockTicker) arm() {
+	ticker.lock.Lock()
+	defer ticker.lock.Unlock()
+	if !ticker.stopped {
+		ticker.timer = ticker.clock.AfterFunc(ticker.interval, ticker.tick)
+	}
+}
+
+func (ticker *clockTicker) tick() {
+	select {
+	case ticker.c <- struct{}{}:
+	default:
+	}
+	ticker.arm()
+}
+
+func (ticker *clockTicker) Stop() {
+	ticker.lock.Lock()
+	defer ticker.lock.Unlock()
+	ticker.stopped = true
+	if ticker.timer != nil {
+		ticker.timer.Stop()
+	}
+}
@@ -0,0 +1,298 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClockSyncInterval is how often the clocks of peers are sampled by default
+	DefaultClockSyncInterval = time.Second
+
+	// clockSyncWindow is the number of samples kept per peer to estimate its drift.
+	clockSyncWindow = 32
+
+	// clockSyncFilter is the number of latest samples the offset is estimated from,
+	// taking the one with the shortest round trip as the least skewed by queuing.
+	clockSyncFilter = 8
+
+	clockSyncRequestSize  = 9
+	clockSyncResponseSize = 25
+)
+
+// Kinds of clock sync messages.
+const (
+	clockSyncRequest byte = iota
+	clockSyncResponse
+)
+
+// ClockSyncConfig configures clock synchronization
+type ClockSyncConfig struct {
+	// Channel is the channel reserved for clock synchronization, whose packets are no
+	// longer returned by Host.Service
+	Channel uint8
+
+	// Interval is how often the clock of every peer is sampled. Defaults to
+	// DefaultClockSyncInterval.
+	Interval time.Duration
+}
+
+// ClockEstimate is the estimated clock of a peer relative to the local one
+type ClockEstimate struct {
+	// Offset is how far ahead of the local clock the clock of the peer is, negative if
+	// it is behind. Adding it to a local time gives the time of the peer.
+	Offset time.Duration
+
+	// Drift is how fast the offset changes, in seconds per second, as clocks run at
+	// slightly different rates. It is 0 until samples span some time.
+	Drift float64
+
+	// RoundTrip is the shortest recent round trip of the samples
+	RoundTrip time.Duration
+
+	// Samples is the number of samples taken
+	Samples int
+}
+
+// clockSample is a sample of the clock of a peer.
+type clockSample struct {
+	at     time.Time
+	offset time.Duration
+	rtt    time.Duration
+}
+
+type peerClock struct {
+	samples  []clockSample
+	estimate ClockEstimate
+	next     time.Time
+}
+
+// clockSyncTable holds the clock estimates of the peers of a host.
+type clockSyncTable struct {
+	lock    sync.Mutex
+	enabled bool
+	config  ClockSyncConfig
+	peers   map[enetPeer]*peerClock
+}
+
+// SyncClocks makes host sample the clocks of its peers NTP-style, answering their
+// samples in turn, so both sides can agree on the time of ticks for lag compensation.
+// The peers must synchronize clocks on the same channel. Estimates are smoothed over
+// samples, see Peer.ClockOffset and PeerClock. Times are taken from the clock of the
+// host, see SetHostClock. Calling it again replaces the configuration.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func SyncClocks(host Host, config ClockSyncConfig) error {
+	if config.Interval < 0 {
+		return errors.New("interval is negative")
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultClockSyncInterval
+	}
+
+	var err error
+	enable := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("clock synchronization is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.clocks
+		table.lock.Lock()
+		defer table.lock.Unlock()
+		table.enabled = true
+		table.config = config
+		if table.peers == nil {
+			table.peers = make(map[enetPeer]*peerClock)
+		}
+		h.features.join(featureClocks)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(enable)
+	} else {
+		enable(host)
+	}
+	return err
+}
+
+// PeerClock returns the estimated clock of peer, whose host synchronizes clocks with
+// SyncClocks. Returns false if no sample has been taken yet. It is safe to call from
+// any goroutine.
+func PeerClock(peer Peer) (ClockEstimate, bool) {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return ClockEstimate{}, false
+	}
+	return p.clockEstimate()
+}
+
+func (peer enetPeer) ClockOffset() time.Duration {
+	estimate, _ := peer.clockEstimate()
+	return estimate.Offset
+}
+
+func (peer enetPeer) clockEstimate() (ClockEstimate, bool) {
+	host := peer.host()
+	if host == nil {
+		return ClockEstimate{}, false
+	}
+
+	table := &host.clocks
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	clock, ok := table.peers[peer]
+	if !ok || clock.estimate.Samples == 0 {
+		return ClockEstimate{}, false
+	}
+	return clock.estimate, true
+}
+
+// syncTime returns the time of the clock of host.
+func (host *enetHost) syncTime() time.Time {
+	if host.clock != nil {
+		return host.clock.clock.Now()
+	}
+	return time.Now()
+}
+
+// send samples the clocks of the peers of host when due.
+func (table *clockSyncTable) send(host *enetHost) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if !table.enabled || len(table.peers) == 0 {
+		return
+	}
+
+	now := host.syncTime()
+	for peer, clock := range table.peers {
+		if now.Before(clock.next) {
+			continue
+		}
+		clock.next = now.Add(table.config.Interval)
+		request := make([]byte, clockSyncRequestSize)
+		request[0] = clockSyncRequest
+		binary.BigEndian.PutUint64(request[1:], uint64(now.UnixNano()))
+		// Lost like any unreliable packet if it can't be sent, the next one may be luckier.
+		peer.SendBytes(request, table.config.Channel, 0)
+	}
+}
+
+// intercept tracks the peers of the host, answers the samples of peers and records the
+// answers to those of the host. Returns true if the event has been consumed.
+func (table *clockSyncTable) intercept(host *enetHost, event *enetEvent) bool {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if !table.enabled {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+
+	switch event.GetType() {
+	case EventConnect:
+		table.peers[peer] = &peerClock{}
+		return false
+	case EventDisconnect, EventDisconnectTimeout:
+		delete(table.peers, peer)
+		return false
+	case EventReceive:
+		if event.GetChannelID() != table.config.Channel {
+			return false
+		}
+		// Peers connected before clocks were synchronized are sampled once they are
+		// heard from.
+		if _, ok := table.peers[peer]; !ok {
+			table.peers[peer] = &peerClock{}
+		}
+	default:
+		return false
+	}
+
+	now := host.syncTime()
+	data := event.GetPacketDataUnsafe()
+	switch {
+	case len(data) == clockSyncRequestSize && data[0] == clockSyncRequest:
+		response := make([]byte, clockSyncResponseSize)
+		response[0] = clockSyncResponse
+		copy(response[1:], data[1:])
+		binary.BigEndian.PutUint64(response[9:], uint64(now.UnixNano()))
+		binary.BigEndian.PutUint64(response[17:], uint64(host.syncTime().UnixNano()))
+		peer.SendBytes(response, table.config.Channel, 0)
+	case len(data) == clockSyncResponseSize && data[0] == clockSyncResponse:
+		if clock, ok := table.peers[peer]; ok {
+			t0 := int64(binary.BigEndian.Uint64(data[1:]))
+			t1 := int64(binary.BigEndian.Uint64(data[9:]))
+			t2 := int64(binary.BigEndian.Uint64(data[17:]))
+			t3 := now.UnixNano()
+			if rtt := (t3 - t0) - (t2 - t1); rtt >= 0 {
+				clock.record(clockSample{
+					at:     now,
+					offset: time.Duration(((t1 - t0) + (t2 - t3)) / 2),
+					rtt:    time.Duration(rtt),
+				})
+			}
+		}
+	}
+	event.GetPacket().Destroy()
+	return true
+}
+
+// record adds a sample and updates the estimate of the clock of the peer.
+func (clock *peerClock) record(sample clockSample) {
+	if len(clock.samples) == clockSyncWindow {
+		clock.samples = slices.Delete(clock.samples, 0, 1)
+	}
+	clock.samples = append(clock.samples, sample)
+
+	recent := clock.samples[max(len(clock.samples)-clockSyncFilter, 0):]
+	best := slices.MinFunc(recent, func(a, b clockSample) int { return int(a.rtt - b.rtt) })
+	estimate := &clock.estimate
+	if estimate.Samples == 0 {
+		estimate.Offset = best.offset
+	} else {
+		// Smoothed so a single skewed sample barely moves it.
+		estimate.Offset += (best.offset - estimate.Offset) / 4
+	}
+	estimate.RoundTrip = best.rtt
+	estimate.Samples++
+	estimate.Drift = clock.drift(best.rtt)
+}
+
+// drift fits a line through the offsets of the samples with round trips close to the
+// shortest one, returning its slope.
+func (clock *peerClock) drift(shortest time.Duration) float64 {
+	limit := 2*shortest + time.Millisecond
+	var n, sumX, sumY, sumXY, sumXX float64
+	origin := clock.samples[0].at
+	for _, sample := range clock.samples {
+		if sample.rtt > limit {
+			continue
+		}
+		x := sample.at.Sub(origin).Seconds()
+		y := sample.offset.Seconds()
+		n++
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if n < 2 || denominator < 1e-9 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
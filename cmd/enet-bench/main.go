@@ -0,0 +1,280 @@
+// Command enet-bench puts a host under load with the loadtest package and reports how
+// it held up:
+//
+//	enet-bench -target 127.0.0.1:7777 -clients 500 -rate 20 -duration 1m -echo
+//
+// Every client sends -rate messages per second, drawn from the mix given by -msg, which
+// may be repeated. A message kind is written size:mode:channel:weight, mode being
+// reliable, unreliable, unsequenced or fragment, and everything but the size optional:
+//
+//	enet-bench -target 127.0.0.1:7777 -msg 64:reliable:0:3 -msg 1200:unreliable:1:1
+//
+// Latency and message loss need the target to echo every message to its sender, as
+// cmd/enet-echod does, which -echo tells. The report is printed as text, or as JSON
+// with -json. Interrupting the test reports what was measured so far.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/loadtest"
+)
+
+// messageFlags are the message kinds by the names of their mode.
+var messageFlags = map[string]enet.PacketFlags{
+	"reliable":    enet.PacketFlagReliable,
+	"unreliable":  0,
+	"unsequenced": enet.PacketFlagUnsequenced,
+	"fragment":    enet.PacketFlagUnreliableFragment,
+}
+
+// messageList is the -msg flag.
+type messageList []loadtest.Message
+
+func (list *messageList) String() string {
+	kinds := make([]string, len(*list))
+	for i, message := range *list {
+		mode := "unreliable"
+		for name, flags := range messageFlags {
+			if flags == message.Flags {
+				mode = name
+			}
+		}
+		kinds[i] = fmt.Sprintf("%d:%s:%d:%d", message.Size, mode, message.Channel, message.Weight)
+	}
+	return strings.Join(kinds, ",")
+}
+
+func (list *messageList) Set(value string) error {
+	fields := strings.Split(value, ":")
+	if len(fields) > 4 {
+		return errors.New("expected size:mode:channel:weight")
+	}
+	size, err := strconv.Atoi(fields[0])
+	if err != nil || size < 8 {
+		return fmt.Errorf("invalid size %q, messages are at least 8 bytes", fields[0])
+	}
+	message := loadtest.Message{Size: size, Flags: enet.PacketFlagReliable}
+	if len(fields) > 1 {
+		flags, ok := messageFlags[fields[1]]
+		if !ok {
+			return fmt.Errorf("unknown mode %q", fields[1])
+		}
+		message.Flags = flags
+	}
+	if len(fields) > 2 {
+		channel, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid channel %q", fields[2])
+		}
+		message.Channel = uint8(channel)
+	}
+	if len(fields) > 3 {
+		weight, err := strconv.Atoi(fields[3])
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("invalid weight %q", fields[3])
+		}
+		message.Weight = weight
+	}
+	*list = append(*list, message)
+	return nil
+}
+
+// distribution is a loadtest.Distribution in milliseconds, for JSON.
+type distribution struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min_ms"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// report is a loadtest.Report with the rates derived from it, for JSON.
+type report struct {
+	Target          string  `json:"target"`
+	Elapsed         float64 `json:"elapsed_s"`
+	Clients         int     `json:"clients"`
+	Connected       int     `json:"connected"`
+	ConnectFailures int     `json:"connect_failures"`
+	Dropped         int     `json:"dropped"`
+
+	Sent           uint64  `json:"sent"`
+	Echoed         uint64  `json:"echoed,omitempty"`
+	BytesSent      uint64  `json:"bytes_sent"`
+	BytesEchoed    uint64  `json:"bytes_echoed,omitempty"`
+	MessagesPerSec float64 `json:"messages_per_s"`
+	BytesPerSec    float64 `json:"bytes_per_s"`
+	MessageLoss    float64 `json:"message_loss,omitempty"`
+
+	PacketsSent uint64  `json:"packets_sent"`
+	PacketsLost uint64  `json:"packets_lost"`
+	PacketLoss  float64 `json:"packet_loss"`
+
+	ConnectTime   distribution  `json:"connect_time"`
+	Latency       *distribution `json:"latency,omitempty"`
+	RoundTripTime distribution  `json:"round_trip_time"`
+}
+
+func main() {
+	target := flag.String("target", "", "host:port of the host under test")
+	clients := flag.Int("clients", 100, "number of clients")
+	connectRate := flag.Float64("connect-rate", 0, "clients starting to connect per second, all at once if 0")
+	connectTimeout := flag.Duration("connect-timeout", 5*time.Second, "time each client waits to connect")
+	connectData := flag.Uint("data", 0, "data clients pass on connect")
+	duration := flag.Duration("duration", 10*time.Second, "time each client sends for")
+	rate := flag.Float64("rate", 10, "messages per second sent by each client")
+	echo := flag.Bool("echo", false, "the target echoes messages, measure latency and message loss")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	var messages messageList
+	flag.Var(&messages, "msg", "kind of message to send, size:mode:channel:weight, may be repeated (default 64:reliable:0:1)")
+	flag.Parse()
+
+	if *target == "" || *clients <= 0 || *duration <= 0 || *rate < 0 || *connectRate < 0 || *connectData > 1<<32-1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	enet.Initialize()
+	defer enet.Deinitialize()
+
+	remote, err := resolve(*target)
+	if err != nil {
+		fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !*asJSON {
+		fmt.Printf("running %d clients against %s for %v\n", *clients, *target, *duration)
+	}
+	result, err := loadtest.Run(ctx, loadtest.Config{
+		Target:         remote,
+		Clients:        *clients,
+		ConnectRate:    *connectRate,
+		ConnectTimeout: *connectTimeout,
+		ConnectData:    uint32(*connectData),
+		Duration:       *duration,
+		Rate:           *rate,
+		Messages:       messages,
+		Echo:           *echo,
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	r := newReport(*target, result, *echo)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(r); err != nil {
+			fatal(err)
+		}
+	} else {
+		r.print()
+	}
+	if result.Connected == 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-bench:", err)
+	os.Exit(1)
+}
+
+// resolve returns the address of addr, given as host:port.
+func resolve(addr string) (enet.Address, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portString)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("unable to resolve %s: %v", host, err)
+	}
+	return enet.NewAddress(ips[0].String(), uint16(port)), nil
+}
+
+func newDistribution(d loadtest.Distribution) distribution {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return distribution{
+		Count: d.Count,
+		Min:   ms(d.Min),
+		Mean:  ms(d.Mean),
+		P50:   ms(d.P50),
+		P90:   ms(d.P90),
+		P99:   ms(d.P99),
+		Max:   ms(d.Max),
+	}
+}
+
+func newReport(target string, result *loadtest.Report, echo bool) *report {
+	r := &report{
+		Target:          target,
+		Elapsed:         result.Elapsed.Seconds(),
+		Clients:         result.Clients,
+		Connected:       result.Connected,
+		ConnectFailures: result.ConnectFailures,
+		Dropped:         result.Dropped,
+		Sent:            result.Sent,
+		Echoed:          result.Echoed,
+		BytesSent:       result.BytesSent,
+		BytesEchoed:     result.BytesEchoed,
+		PacketsSent:     result.PacketsSent,
+		PacketsLost:     result.PacketsLost,
+		PacketLoss:      result.Loss(),
+		ConnectTime:     newDistribution(result.ConnectTime),
+		RoundTripTime:   newDistribution(result.RoundTripTime),
+	}
+	if seconds := result.Elapsed.Seconds(); seconds > 0 {
+		r.MessagesPerSec = float64(result.Sent) / seconds
+		r.BytesPerSec = float64(result.BytesSent) / seconds
+	}
+	if echo {
+		latency := newDistribution(result.Latency)
+		r.Latency = &latency
+		r.MessageLoss = result.MessageLoss()
+	}
+	return r
+}
+
+func (r *report) print() {
+	fmt.Printf("\n--- %s enet-bench report ---\n", r.Target)
+	fmt.Printf("elapsed      %.1fs\n", r.Elapsed)
+	fmt.Printf("clients      %d started, %d connected, %d failed to connect, %d dropped\n",
+		r.Clients, r.Connected, r.ConnectFailures, r.Dropped)
+	fmt.Printf("sent         %d messages, %d bytes\n", r.Sent, r.BytesSent)
+	fmt.Printf("throughput   %.1f messages/s, %.1f KiB/s\n", r.MessagesPerSec, r.BytesPerSec/1024)
+	if r.Latency != nil {
+		fmt.Printf("echoed       %d messages, %d bytes, %.2f%% lost\n", r.Echoed, r.BytesEchoed, 100*r.MessageLoss)
+	}
+	fmt.Printf("packets      %d sent, %d lost, %.2f%% loss\n", r.PacketsSent, r.PacketsLost, 100*r.PacketLoss)
+	fmt.Println()
+	fmt.Printf("%-12s %8s %9s %9s %9s %9s %9s %9s\n", "ms", "count", "min", "mean", "p50", "p90", "p99", "max")
+	r.ConnectTime.print("connect")
+	if r.Latency != nil {
+		r.Latency.print("latency")
+	}
+	r.RoundTripTime.print("enet rtt")
+}
+
+func (d distribution) print(name string) {
+	fmt.Printf("%-12s %8d %9.2f %9.2f %9.2f %9.2f %9.2f %9.2f\n", name, d.Count, d.Min, d.Mean, d.P50, d.P90, d.P99, d.Max)
+}
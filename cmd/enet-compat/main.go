@@ -0,0 +1,176 @@
+// Command enet-compat finds out which wire format a remote enet host talks, by
+// connecting to it with each protocol configuration in turn:
+//
+//	enet-compat -addr game.example.com:7777
+//
+// It prints the configurations the host accepted, the one to pass to enet.SetProtocol
+// to talk to it. With -listen it runs an echo server instead, to test other
+// implementations against:
+//
+//	enet-compat -listen 7777 -protocol stock -checksum
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+type attempt struct {
+	name   string
+	config enet.ProtocolConfig
+}
+
+var attempts = []attempt{
+	{"fork", enet.ProtocolConfig{Protocol: enet.ProtocolFork}},
+	{"fork+checksum", enet.ProtocolConfig{Protocol: enet.ProtocolFork, Checksum: true}},
+	{"stock", enet.ProtocolConfig{Protocol: enet.ProtocolStock}},
+	{"stock+checksum", enet.ProtocolConfig{Protocol: enet.ProtocolStock, Checksum: true}},
+}
+
+func main() {
+	addr := flag.String("addr", "", "host:port of the remote host to test")
+	timeout := flag.Duration("timeout", 2*time.Second, "time to wait for each connection")
+	channels := flag.Int("channels", 2, "channel count to connect with")
+	listen := flag.Int("listen", 0, "port to run an echo server on instead")
+	protocol := flag.String("protocol", "fork", "protocol of the echo server, fork or stock")
+	checksum := flag.Bool("checksum", false, "enable checksums on the echo server")
+	flag.Parse()
+
+	enet.Initialize()
+	defer enet.Deinitialize()
+
+	switch {
+	case *listen != 0:
+		config := enet.ProtocolConfig{Checksum: *checksum}
+		switch *protocol {
+		case "fork":
+		case "stock":
+			config.Protocol = enet.ProtocolStock
+		default:
+			fatal(fmt.Errorf("unknown protocol %q", *protocol))
+		}
+		if err := serve(uint16(*listen), config); err != nil {
+			fatal(err)
+		}
+	case *addr != "":
+		if !probe(*addr, *channels, *timeout) {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-compat:", err)
+	os.Exit(1)
+}
+
+// probe connects to addr with every configuration and reports which ones worked.
+func probe(addr string, channels int, timeout time.Duration) bool {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		fatal(err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		fatal(fmt.Errorf("invalid port %q", portString))
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		fatal(fmt.Errorf("unable to resolve %s: %v", host, err))
+	}
+	remote := enet.NewAddress(ips[0].String(), uint16(port))
+
+	compatible := false
+	for _, a := range attempts {
+		rtt, err := connect(remote, channels, a.config, timeout)
+		if err != nil {
+			fmt.Printf("%-16s %v\n", a.name, err)
+			continue
+		}
+		compatible = true
+		fmt.Printf("%-16s connected in %v\n", a.name, rtt.Round(time.Microsecond))
+	}
+	if !compatible {
+		fmt.Println("no configuration connected, the host may not be up or not talk enet")
+	}
+	return compatible
+}
+
+// connect connects to remote with a fresh host using config, and disconnects again
+// once connected.
+func connect(remote enet.Address, channels int, config enet.ProtocolConfig, timeout time.Duration) (time.Duration, error) {
+	host, err := enet.NewHost(nil, 1, uint64(channels), 0, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer host.Destroy()
+
+	if err := enet.SetProtocol(host, config); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	peer, err := host.Connect(remote, channels, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var rtt time.Duration
+	for time.Since(start) < timeout {
+		event := host.Service(10)
+		switch event.GetType() {
+		case enet.EventConnect:
+			rtt = time.Since(start)
+			peer.Disconnect(0)
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			if rtt == 0 {
+				return 0, errors.New("refused")
+			}
+			return rtt, nil
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+	if rtt != 0 {
+		// Connected but the disconnect went unacknowledged, which doesn't matter here.
+		return rtt, nil
+	}
+	return 0, errors.New("no answer")
+}
+
+// serve echoes every packet back to its sender until interrupted.
+func serve(port uint16, config enet.ProtocolConfig) error {
+	host, err := enet.NewHost(enet.NewListenAddress(port), 32, 0, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer host.Destroy()
+
+	if err := enet.SetProtocol(host, config); err != nil {
+		return err
+	}
+	fmt.Printf("echoing on port %d\n", port)
+
+	for {
+		event := host.Service(100)
+		switch event.GetType() {
+		case enet.EventConnect:
+			fmt.Printf("%s connected\n", event.GetPeer().GetAddress())
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			fmt.Printf("%s disconnected\n", event.GetPeer().GetAddress())
+		case enet.EventReceive:
+			packet := event.GetPacket()
+			event.GetPeer().SendBytes(packet.GetData(), event.GetChannelID(), enet.PacketFlagReliable)
+			packet.Destroy()
+		}
+	}
+}
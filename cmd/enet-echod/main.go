@@ -0,0 +1,212 @@
+// Command enet-echod is an echo server, the reference target to test ENet clients and
+// other implementations against, and the one cmd/enet-ping and cmd/enet-bench expect:
+//
+//	enet-echod -port 7777
+//
+// Every packet is sent back to its sender as is, on the channel and with the flags it
+// came with, unless -channel or -flags override them:
+//
+//	enet-echod -port 7777 -flags unreliable -channel 1
+//
+// Statistics are printed every -stats interval, and with -http served as expvars on
+// /debug/vars, under "enet" for those of the host and "echod" for those of the server.
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// keptFlags are the flags of received packets kept as they are echoed.
+const keptFlags = enet.PacketFlagReliable | enet.PacketFlagUnsequenced | enet.PacketFlagUnreliableFragment
+
+// echoFlags are the flags packets can be echoed with, by name.
+var echoFlags = map[string]enet.PacketFlags{
+	"reliable":    enet.PacketFlagReliable,
+	"unreliable":  0,
+	"unsequenced": enet.PacketFlagUnsequenced,
+	"fragment":    enet.PacketFlagUnreliableFragment,
+}
+
+// counters are the statistics of the server.
+type counters struct {
+	connects    atomic.Uint64
+	disconnects atomic.Uint64
+	timeouts    atomic.Uint64
+	echoed      atomic.Uint64
+	echoedBytes atomic.Uint64
+	echoErrors  atomic.Uint64
+}
+
+func (c *counters) snapshot() map[string]uint64 {
+	return map[string]uint64{
+		"connects":     c.connects.Load(),
+		"disconnects":  c.disconnects.Load(),
+		"timeouts":     c.timeouts.Load(),
+		"echoed":       c.echoed.Load(),
+		"echoed_bytes": c.echoedBytes.Load(),
+		"echo_errors":  c.echoErrors.Load(),
+	}
+}
+
+type server struct {
+	host    enet.Host
+	verbose bool
+
+	// channel and flags override those packets are echoed with if set.
+	channel    int
+	flags      enet.PacketFlags
+	forceFlags bool
+
+	stats counters
+}
+
+func main() {
+	port := flag.Int("port", 7777, "port to listen on")
+	peers := flag.Int("peers", 1024, "maximum number of peers")
+	channels := flag.Int("channels", 0, "channel limit, the maximum if 0")
+	protocol := flag.String("protocol", "fork", "wire format, fork or stock")
+	checksum := flag.Bool("checksum", false, "checksum datagrams")
+	channel := flag.Int("channel", -1, "channel to echo on, that of each packet if negative")
+	flagName := flag.String("flags", "", "reliable, unreliable, unsequenced or fragment to echo with, those of each packet if empty")
+	statsInterval := flag.Duration("stats", time.Minute, "interval to print statistics at, never if 0")
+	httpAddr := flag.String("http", "", "address to serve expvars on, such as localhost:8080")
+	verbose := flag.Bool("v", false, "log peers connecting and disconnecting")
+	flag.Parse()
+
+	if *port <= 0 || *port > 65535 || *peers <= 0 || *channels < 0 || *channels > 255 || *channel > 254 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	config := enet.ProtocolConfig{Checksum: *checksum}
+	switch *protocol {
+	case "fork":
+	case "stock":
+		config.Protocol = enet.ProtocolStock
+	default:
+		fatal(fmt.Errorf("unknown protocol %q", *protocol))
+	}
+	s := &server{verbose: *verbose, channel: *channel}
+	if *flagName != "" {
+		flags, ok := echoFlags[*flagName]
+		if !ok {
+			fatal(fmt.Errorf("unknown flags %q", *flagName))
+		}
+		s.flags, s.forceFlags = flags, true
+	}
+
+	enet.Initialize()
+	defer enet.Deinitialize()
+
+	host, err := enet.NewHost(enet.NewListenAddress(uint16(*port)), uint64(*peers), uint64(*channels), 0, 0, 0)
+	if err != nil {
+		fatal(err)
+	}
+	defer host.Destroy()
+	s.host = host
+	if err := enet.SetProtocol(host, config); err != nil {
+		fatal(err)
+	}
+	if err := enet.TrackPeerStats(host); err != nil {
+		fatal(err)
+	}
+
+	if *httpAddr != "" {
+		if _, err := enet.PublishExpvars(host); err != nil {
+			fatal(err)
+		}
+		expvar.Publish("echod", expvar.Func(func() any { return s.stats.snapshot() }))
+		listener, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			fatal(err)
+		}
+		go func() {
+			fatal(http.Serve(listener, nil))
+		}()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	var ticks <-chan time.Time
+	if *statsInterval > 0 {
+		ticker := time.NewTicker(*statsInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	fmt.Printf("echoing on port %d\n", *port)
+	for {
+		select {
+		case <-interrupt:
+			s.printStats()
+			return
+		case <-ticks:
+			s.printStats()
+		default:
+		}
+		s.handle(host.Service(10))
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-echod:", err)
+	os.Exit(1)
+}
+
+// handle echoes received packets and counts the other events.
+func (s *server) handle(event enet.Event) {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect:
+		s.stats.connects.Add(1)
+		s.logf("%s connected with data %d", peer.GetAddress(), event.GetData())
+	case enet.EventDisconnect:
+		s.stats.disconnects.Add(1)
+		s.logf("%s disconnected with data %d", peer.GetAddress(), event.GetData())
+	case enet.EventDisconnectTimeout:
+		s.stats.timeouts.Add(1)
+		s.logf("%s timed out", peer.GetAddress())
+	case enet.EventReceive:
+		packet := event.GetPacket()
+		defer packet.Destroy()
+
+		channel, flags := event.GetChannelID(), packet.GetFlags()&keptFlags
+		if s.channel >= 0 {
+			channel = uint8(s.channel)
+		}
+		if s.forceFlags {
+			flags = s.flags
+		}
+		data := packet.GetData()
+		if err := peer.SendBytes(data, channel, flags); err != nil {
+			s.stats.echoErrors.Add(1)
+			s.logf("%s: echo failed: %v", peer.GetAddress(), err)
+			return
+		}
+		s.stats.echoed.Add(1)
+		s.stats.echoedBytes.Add(uint64(len(data)))
+	}
+}
+
+func (s *server) logf(format string, args ...any) {
+	if s.verbose {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+func (s *server) printStats() {
+	stats := s.host.GetStats()
+	fmt.Printf("%s peers=%d connects=%d disconnects=%d timeouts=%d echoed=%d echoed_bytes=%d echo_errors=%d in=%.0fB/s out=%.0fB/s\n",
+		time.Now().Format(time.TimeOnly), stats.ConnectedPeers,
+		s.stats.connects.Load(), s.stats.disconnects.Load(), s.stats.timeouts.Load(),
+		s.stats.echoed.Load(), s.stats.echoedBytes.Load(), s.stats.echoErrors.Load(),
+		stats.PerSecond.BytesReceived, stats.PerSecond.BytesSent)
+}
@@ -0,0 +1,289 @@
+// Command enet-ping is ping for enet hosts. It connects to a host, sends it probes at a
+// steady rate and measures the time they take to come back:
+//
+//	enet-ping -c 20 -i 100ms game.example.com:7777
+//
+// The host must echo every message it receives to its sender, as cmd/enet-echod and
+// enet-compat -listen do. Probes are sent unsequenced, so that each one is lost or not
+// on its own. Once done, or when interrupted, it prints the time taken to connect, the
+// share of probes lost and the distribution of their round trip times, along with the
+// round trip time and packet loss enet measured itself.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// probeHeader is the size of the sequence number heading probes.
+const probeHeader = 8
+
+type summary struct {
+	connect    time.Duration
+	sent       int
+	received   int
+	duplicates int
+	rtts       []time.Duration
+
+	enetRTT     time.Duration
+	packetsSent uint64
+	packetsLost uint64
+}
+
+func main() {
+	count := flag.Int("c", 0, "number of probes to send, 0 to send until interrupted")
+	interval := flag.Duration("i", time.Second, "interval between probes")
+	size := flag.Int("s", 64, "size of the probes in bytes, at least 8")
+	channel := flag.Int("channel", 0, "channel to send the probes on")
+	channels := flag.Int("channels", 2, "channel count to connect with")
+	timeout := flag.Duration("timeout", 5*time.Second, "time to wait for the connection")
+	wait := flag.Duration("w", 2*time.Second, "time to wait for the last replies")
+	quiet := flag.Bool("q", false, "only print the summary")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: enet-ping [flags] host:port")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *interval <= 0 || *count < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *size < probeHeader {
+		fatal(fmt.Errorf("probes must be at least %d bytes", probeHeader))
+	}
+	if *channel < 0 || *channel >= *channels {
+		fatal(fmt.Errorf("channel %d is out of the %d channels", *channel, *channels))
+	}
+
+	enet.Initialize()
+	defer enet.Deinitialize()
+
+	remote, err := resolve(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	s, err := ping(remote, pingConfig{
+		name:     flag.Arg(0),
+		count:    *count,
+		interval: *interval,
+		size:     *size,
+		channel:  uint8(*channel),
+		channels: *channels,
+		timeout:  *timeout,
+		wait:     *wait,
+		quiet:    *quiet,
+	}, interrupt)
+	if err != nil {
+		fatal(err)
+	}
+	s.print(flag.Arg(0))
+	if s.received == 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-ping:", err)
+	os.Exit(1)
+}
+
+// resolve returns the address of addr, given as host:port.
+func resolve(addr string) (enet.Address, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portString)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("unable to resolve %s: %v", host, err)
+	}
+	return enet.NewAddress(ips[0].String(), uint16(port)), nil
+}
+
+type pingConfig struct {
+	name     string
+	count    int
+	interval time.Duration
+	size     int
+	channel  uint8
+	channels int
+	timeout  time.Duration
+	wait     time.Duration
+	quiet    bool
+}
+
+// ping connects to remote and probes it until config.count probes have been sent or
+// interrupt fires.
+func ping(remote enet.Address, config pingConfig, interrupt <-chan os.Signal) (*summary, error) {
+	host, err := enet.NewHost(nil, 1, uint64(config.channels), 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer host.Destroy()
+	if err := enet.TrackPeerStats(host); err != nil {
+		return nil, err
+	}
+
+	s := &summary{}
+	start := time.Now()
+	peer, err := host.Connect(remote, config.channels, 0)
+	if err != nil {
+		return nil, err
+	}
+	for s.connect == 0 {
+		if time.Since(start) > config.timeout {
+			return nil, errors.New("no answer")
+		}
+		select {
+		case <-interrupt:
+			return nil, errors.New("interrupted while connecting")
+		default:
+		}
+		event := host.Service(10)
+		switch event.GetType() {
+		case enet.EventConnect:
+			s.connect = time.Since(start)
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			return nil, errors.New("refused")
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+	if !config.quiet {
+		fmt.Printf("connected to %s in %v\n", config.name, s.connect.Round(time.Microsecond))
+	}
+
+	// sentAt holds the time each probe was sent at, by sequence number, until it is
+	// answered.
+	sentAt := make(map[uint64]time.Time)
+	probe := make([]byte, config.size)
+	next := time.Now()
+	var done time.Time
+	for {
+		now := time.Now()
+		if done.IsZero() {
+			select {
+			case <-interrupt:
+				done = now
+			default:
+			}
+		}
+		if done.IsZero() && !now.Before(next) {
+			binary.BigEndian.PutUint64(probe, uint64(s.sent))
+			if err := peer.SendBytes(probe, config.channel, enet.PacketFlagUnsequenced); err != nil {
+				return nil, err
+			}
+			sentAt[uint64(s.sent)] = now
+			s.sent++
+			next = next.Add(config.interval)
+			if config.count != 0 && s.sent == config.count {
+				done = now
+			}
+		}
+		if !done.IsZero() && (len(sentAt) == 0 || now.Sub(done) > config.wait) {
+			break
+		}
+
+		event := host.Service(1)
+		switch event.GetType() {
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			if !config.quiet {
+				fmt.Println("disconnected by the host")
+			}
+			return s, nil
+		case enet.EventReceive:
+			data := event.GetPacketDataUnsafe()
+			if len(data) >= probeHeader {
+				seq := binary.BigEndian.Uint64(data)
+				if at, ok := sentAt[seq]; ok {
+					rtt := time.Since(at)
+					delete(sentAt, seq)
+					s.received++
+					s.rtts = append(s.rtts, rtt)
+					if !config.quiet {
+						fmt.Printf("%d bytes from %s: seq=%d time=%v\n", len(data), config.name, seq, rtt.Round(time.Microsecond))
+					}
+				} else if seq < uint64(s.sent) {
+					// Answered twice.
+					s.duplicates++
+				}
+			}
+			event.GetPacket().Destroy()
+		}
+	}
+
+	for _, stats := range enet.GetPeerStats(host) {
+		s.enetRTT = stats.RoundTripTime
+		s.packetsSent = stats.PacketsSent
+		s.packetsLost = stats.PacketsLost
+	}
+	// Give the disconnect a moment to go out, the host doesn't need to acknowledge it.
+	peer.Disconnect(0)
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		if event := host.Service(10); event.GetType() == enet.EventDisconnect {
+			break
+		} else if event.GetType() == enet.EventReceive {
+			event.GetPacket().Destroy()
+		}
+	}
+	return s, nil
+}
+
+func (s *summary) print(addr string) {
+	fmt.Printf("\n--- %s enet ping statistics ---\n", addr)
+	fmt.Printf("connected in %v\n", s.connect.Round(time.Microsecond))
+
+	loss := 0.0
+	if s.sent > 0 {
+		loss = 100 * float64(s.sent-s.received) / float64(s.sent)
+	}
+	fmt.Printf("%d probes sent, %d received, %.1f%% lost", s.sent, s.received, loss)
+	if s.duplicates > 0 {
+		fmt.Printf(", %d duplicates", s.duplicates)
+	}
+	fmt.Println()
+
+	if len(s.rtts) > 0 {
+		slices.Sort(s.rtts)
+		var sum time.Duration
+		for _, rtt := range s.rtts {
+			sum += rtt
+		}
+		mean := sum / time.Duration(len(s.rtts))
+		var variance float64
+		for _, rtt := range s.rtts {
+			d := float64(rtt - mean)
+			variance += d * d
+		}
+		stddev := time.Duration(math.Sqrt(variance / float64(len(s.rtts))))
+		percentile := func(p float64) time.Duration {
+			return s.rtts[min(int(p*float64(len(s.rtts))), len(s.rtts)-1)]
+		}
+		round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+		fmt.Printf("rtt min/mean/max/stddev = %v/%v/%v/%v\n",
+			round(s.rtts[0]), round(mean), round(s.rtts[len(s.rtts)-1]), round(stddev))
+		fmt.Printf("rtt p50/p90/p99 = %v/%v/%v\n", round(percentile(0.5)), round(percentile(0.9)), round(percentile(0.99)))
+	}
+	if s.packetsSent > 0 {
+		fmt.Printf("enet rtt %v, %d packets sent, %d lost\n", s.enetRTT, s.packetsSent, s.packetsLost)
+	}
+}
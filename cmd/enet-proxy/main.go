@@ -0,0 +1,251 @@
+// Command enet-proxy forwards enet traffic to another host. It accepts clients on a
+// port of its own and connects to the target on behalf of each, forwarding every packet
+// both ways on the same channel and with the same flags:
+//
+//	enet-proxy -listen 7777 -target game.internal:7777
+//
+// Clients only ever talk to the proxy, so it can front a server whose address must stay
+// private, absorb floods with a bandwidth limit per client, or stand between a client
+// and a server under test to degrade the connection:
+//
+//	enet-proxy -listen 7777 -target 127.0.0.1:7778 -latency 80ms -jitter 20ms -loss 0.02
+//
+// Latency and jitter delay the datagrams sent to clients, and loss drops datagrams both
+// ways. The connect data of clients is passed on to the target. Forwarding is done by a
+// relay.Relay pairing each client with its connection to the target.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/relay"
+)
+
+// maxHeld bounds the packets held for a client while the proxy connects to the target
+// for it, beyond which the client is disconnected.
+const maxHeld = 256
+
+// forwardedFlags are the flags of the packets of clients kept as they are passed on.
+const forwardedFlags = enet.PacketFlagReliable | enet.PacketFlagUnsequenced | enet.PacketFlagUnreliableFragment
+
+// heldPacket is a packet of a client received before its connection to the target.
+type heldPacket struct {
+	data    []byte
+	channel uint8
+	flags   enet.PacketFlags
+}
+
+// client is a client waiting for the connection to the target made for it.
+type client struct {
+	upstream enet.Peer
+	held     []heldPacket
+}
+
+type proxy struct {
+	host     enet.Host
+	target   enet.Address
+	channels int
+	verbose  bool
+
+	latency time.Duration
+	jitter  time.Duration
+	loss    float64
+
+	relay *relay.Relay
+
+	// clients are the clients not yet paired, and upstreams the clients the connections
+	// to the target not yet paired are made for.
+	clients   map[enet.Peer]*client
+	upstreams map[enet.Peer]enet.Peer
+}
+
+func main() {
+	listen := flag.Int("listen", 0, "port to accept clients on")
+	target := flag.String("target", "", "host:port of the host to forward to")
+	peers := flag.Int("peers", 256, "maximum number of clients")
+	channels := flag.Int("channels", 255, "channel count to connect to the target with")
+	bandwidth := flag.Int("bandwidth", 0, "bytes per second forwarded per client both ways, unlimited if 0")
+	connectTimeout := flag.Duration("connect-timeout", 5*time.Second, "time to connect to the target for a client")
+	latency := flag.Duration("latency", 0, "latency added to the datagrams sent to clients")
+	jitter := flag.Duration("jitter", 0, "random latency added on top of -latency")
+	loss := flag.Float64("loss", 0, "share of the datagrams to and from clients dropped, from 0 to 1")
+	verbose := flag.Bool("v", false, "log clients connecting and disconnecting")
+	flag.Parse()
+
+	if *listen <= 0 || *listen > 65535 || *target == "" || *peers <= 0 || *channels <= 0 || *channels > 255 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *latency < 0 || *jitter < 0 || *loss < 0 || *loss > 1 {
+		fatal(fmt.Errorf("invalid network conditions"))
+	}
+
+	enet.Initialize()
+	defer enet.Deinitialize()
+
+	remote, err := resolve(*target)
+	if err != nil {
+		fatal(err)
+	}
+	// Every client takes a peer of its own and one to the target.
+	host, err := enet.NewHost(enet.NewListenAddress(uint16(*listen)), uint64(2**peers), 0, 0, 0, 0)
+	if err != nil {
+		fatal(err)
+	}
+	defer host.Destroy()
+
+	p := &proxy{
+		host:      host,
+		target:    remote,
+		channels:  *channels,
+		verbose:   *verbose,
+		latency:   *latency,
+		jitter:    *jitter,
+		loss:      *loss,
+		clients:   make(map[enet.Peer]*client),
+		upstreams: make(map[enet.Peer]enet.Peer),
+	}
+	p.relay = relay.New(relay.Config{
+		BandwidthLimit: *bandwidth,
+		JoinTimeout:    *connectTimeout,
+		OnClose:        p.closed,
+	})
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	fmt.Printf("forwarding port %d to %s\n", *listen, *target)
+	for {
+		select {
+		case <-interrupt:
+			return
+		default:
+		}
+		p.handle(host.Service(10))
+		p.relay.Process()
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-proxy:", err)
+	os.Exit(1)
+}
+
+// resolve returns the address of addr, given as host:port.
+func resolve(addr string) (enet.Address, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portString)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("unable to resolve %s: %v", host, err)
+	}
+	return enet.NewAddress(ips[0].String(), uint16(port)), nil
+}
+
+func (p *proxy) logf(format string, args ...any) {
+	if p.verbose {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// handle handles an event of the host, pairing clients with their connection to the
+// target once it is up.
+func (p *proxy) handle(event enet.Event) {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect:
+		if downstream, ok := p.upstreams[peer]; ok {
+			p.paired(downstream, peer)
+			return
+		}
+		p.accept(peer, event.GetData())
+		// The relay times the connection to the target out.
+		p.relay.Handle(event)
+
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		if downstream, ok := p.upstreams[peer]; ok {
+			// The target could not be reached.
+			delete(p.upstreams, peer)
+			delete(p.clients, downstream)
+			downstream.Disconnect(event.GetData())
+			p.logf("%s: unable to reach the target", downstream.GetAddress())
+			return
+		}
+		if c, ok := p.clients[peer]; ok {
+			delete(p.clients, peer)
+			delete(p.upstreams, c.upstream)
+			c.upstream.DisconnectNow(0)
+			p.logf("%s disconnected", peer.GetAddress())
+		}
+		p.relay.Handle(event)
+
+	case enet.EventReceive:
+		packet := event.GetPacket()
+		if c, ok := p.clients[peer]; ok {
+			if len(c.held) == maxHeld {
+				peer.Disconnect(0)
+			} else {
+				c.held = append(c.held, heldPacket{
+					data:    packet.GetData(),
+					channel: event.GetChannelID(),
+					flags:   packet.GetFlags(),
+				})
+			}
+		} else {
+			p.relay.Handle(event)
+		}
+		packet.Destroy()
+	}
+}
+
+// accept connects to the target on behalf of a client that connected.
+func (p *proxy) accept(peer enet.Peer, data uint32) {
+	if p.latency != 0 || p.jitter != 0 || p.loss != 0 {
+		if err := peer.SimulateConditions(p.latency, p.jitter, p.loss); err != nil {
+			p.logf("%s: %v", peer.GetAddress(), err)
+		}
+	}
+	upstream, err := p.host.Connect(p.target, p.channels, data)
+	if err != nil {
+		p.logf("%s: unable to connect to the target: %v", peer.GetAddress(), err)
+		peer.Disconnect(0)
+		return
+	}
+	p.clients[peer] = &client{upstream: upstream}
+	p.upstreams[upstream] = peer
+	p.logf("%s connected", peer.GetAddress())
+}
+
+// paired pairs a client with its connection to the target, passing on the packets it
+// sent meanwhile.
+func (p *proxy) paired(peer, upstream enet.Peer) {
+	c := p.clients[peer]
+	delete(p.clients, peer)
+	delete(p.upstreams, upstream)
+	if _, err := p.relay.Pair(peer, upstream); err != nil {
+		peer.Disconnect(0)
+		upstream.Disconnect(0)
+		return
+	}
+	for _, held := range c.held {
+		upstream.SendBytes(held.data, held.channel, held.flags&forwardedFlags)
+	}
+}
+
+// closed logs the end of the session of a client.
+func (p *proxy) closed(session *relay.Session) {
+	downstream, _ := session.Peers()
+	p.logf("%s: session closed after forwarding %d bytes, %d packets dropped", downstream.GetAddress(), session.Forwarded(), session.Dropped())
+}
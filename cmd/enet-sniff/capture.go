@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// The link types frames are captured with, see https://www.tcpdump.org/linktypes.html.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+const (
+	pcapMagic     = 0xa1b2c3d4
+	pcapMagicNano = 0xa1b23c4d
+
+	pcapngBlockSection   = 0x0a0d0d0a
+	pcapngBlockInterface = 0x00000001
+	pcapngBlockSimple    = 0x00000003
+	pcapngBlockEnhanced  = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+
+	pcapngOptionResolution = 9
+
+	// maxBlock bounds the blocks and records read, against corrupt captures.
+	maxBlock = 1 << 24
+)
+
+// frame is a frame captured off an interface.
+type frame struct {
+	time     time.Time
+	linkType uint32
+	data     []byte
+}
+
+// source is where frames are captured from. next returns io.EOF once there are no
+// more.
+type source interface {
+	next() (frame, error)
+}
+
+// datagram is a UDP datagram found in a frame.
+type datagram struct {
+	time     time.Time
+	src, dst *net.UDPAddr
+	payload  []byte
+}
+
+// openCapture returns the source of the frames of a pcap or pcapng capture.
+func openCapture(r io.Reader) (source, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading capture: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngBlockSection {
+		return &pcapngReader{r: br}, nil
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading capture header: %w", err)
+	}
+	reader := &pcapReader{r: br}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header) {
+		case pcapMagic:
+			reader.order, reader.unit = order, time.Microsecond
+		case pcapMagicNano:
+			reader.order, reader.unit = order, time.Nanosecond
+		default:
+			continue
+		}
+		reader.linkType = reader.order.Uint32(header[20:]) & 0xffff
+		return reader, nil
+	}
+	return nil, errors.New("not a pcap or pcapng capture")
+}
+
+// pcapReader reads the classic pcap format.
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	unit     time.Duration
+	linkType uint32
+}
+
+func (p *pcapReader) next() (frame, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated capture")
+		}
+		return frame{}, err
+	}
+	captured := p.order.Uint32(header[8:])
+	if captured > maxBlock {
+		return frame{}, fmt.Errorf("record of %d bytes is too large", captured)
+	}
+	data := make([]byte, captured)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return frame{}, errors.New("truncated capture")
+	}
+	t := time.Unix(int64(p.order.Uint32(header)), int64(p.order.Uint32(header[4:]))*int64(p.unit))
+	return frame{time: t, linkType: p.linkType, data: data}, nil
+}
+
+// pcapngInterface is an interface of a pcapng section.
+type pcapngInterface struct {
+	linkType uint32
+
+	// unit is the resolution of timestamps.
+	unit time.Duration
+}
+
+// pcapngReader reads the pcapng format, as written by enet.PcapWriter and Wireshark.
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+func (p *pcapngReader) next() (frame, error) {
+	for {
+		blockType, body, err := p.block()
+		if err != nil {
+			return frame{}, err
+		}
+		switch blockType {
+		case pcapngBlockInterface:
+			if len(body) < 8 {
+				return frame{}, errors.New("truncated interface block")
+			}
+			p.interfaces = append(p.interfaces, pcapngInterface{
+				linkType: uint32(p.order.Uint16(body)),
+				unit:     p.resolution(body[8:]),
+			})
+
+		case pcapngBlockEnhanced:
+			if len(body) < 20 {
+				return frame{}, errors.New("truncated packet block")
+			}
+			id := p.order.Uint32(body)
+			if int(id) >= len(p.interfaces) {
+				return frame{}, fmt.Errorf("packet of unknown interface %d", id)
+			}
+			iface := p.interfaces[id]
+			captured := p.order.Uint32(body[12:])
+			if uint64(captured) > uint64(len(body)-20) {
+				return frame{}, errors.New("truncated packet block")
+			}
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			return frame{
+				time:     timestamp(ts, iface.unit),
+				linkType: iface.linkType,
+				data:     body[20 : 20+captured],
+			}, nil
+
+		case pcapngBlockSimple:
+			// Simple packets have no timestamp and belong to the first interface.
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return frame{}, errors.New("invalid simple packet block")
+			}
+			captured := min(p.order.Uint32(body), uint32(len(body)-4))
+			return frame{linkType: p.interfaces[0].linkType, data: body[4 : 4+captured]}, nil
+		}
+	}
+}
+
+// block reads the next block, returning its type and body. Section headers are handled
+// as they come.
+func (p *pcapngReader) block() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated capture")
+		}
+		return 0, nil, err
+	}
+
+	if binary.LittleEndian.Uint32(header) == pcapngBlockSection {
+		// The byte order magic follows, telling the order of the whole section.
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(p.r, magic); err != nil {
+			return 0, nil, errors.New("truncated section header")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == pcapngByteOrderMagic:
+			p.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == pcapngByteOrderMagic:
+			p.order = binary.BigEndian
+		default:
+			return 0, nil, errors.New("invalid section header")
+		}
+		length := p.order.Uint32(header[4:])
+		if length < 16 || length > maxBlock {
+			return 0, nil, errors.New("invalid section header")
+		}
+		if _, err := io.CopyN(io.Discard, p.r, int64(length)-12); err != nil {
+			return 0, nil, errors.New("truncated section header")
+		}
+		p.interfaces = p.interfaces[:0]
+		return pcapngBlockSection, nil, nil
+	}
+	if p.order == nil {
+		return 0, nil, errors.New("capture does not start with a section header")
+	}
+
+	length := p.order.Uint32(header[4:])
+	if length < 12 || length%4 != 0 || length > maxBlock {
+		return 0, nil, fmt.Errorf("invalid block length %d", length)
+	}
+	block := make([]byte, length-8)
+	if _, err := io.ReadFull(p.r, block); err != nil {
+		return 0, nil, errors.New("truncated capture")
+	}
+	return p.order.Uint32(header), block[:len(block)-4], nil
+}
+
+// resolution returns the resolution of the timestamps of an interface from its
+// options, microseconds by default.
+func (p *pcapngReader) resolution(options []byte) time.Duration {
+	for len(options) >= 4 {
+		code, length := p.order.Uint16(options), int(p.order.Uint16(options[2:]))
+		if 4+length > len(options) {
+			break
+		}
+		if code == pcapngOptionResolution && length >= 1 {
+			value := options[4]
+			if value&0x80 != 0 {
+				// Negative power of 2, rounded to the nanosecond.
+				return max(time.Second>>(value&0x7f), time.Nanosecond)
+			}
+			unit := time.Second
+			for range value {
+				unit /= 10
+			}
+			return max(unit, time.Nanosecond)
+		}
+		options = options[4+(length+3)&^3:]
+	}
+	return time.Microsecond
+}
+
+// timestamp converts a timestamp counted in unit to a time.
+func timestamp(ts uint64, unit time.Duration) time.Time {
+	perSecond := uint64(time.Second / unit)
+	return time.Unix(int64(ts/perSecond), int64(ts%perSecond)*int64(unit))
+}
+
+// decodeFrame returns the UDP datagram carried by a frame. Returns false for anything
+// else, including fragments of IP packets.
+func decodeFrame(f frame) (datagram, bool) {
+	data := f.data
+	var version int
+	switch f.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return datagram{}, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		version = etherVersion(etherType)
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return datagram{}, false
+		}
+		// The address family, in the byte order of the capturing host for NULL.
+		family := binary.BigEndian.Uint32(data)
+		if f.linkType == linkTypeNull && family > 0xffff {
+			family = binary.LittleEndian.Uint32(data)
+		}
+		data = data[4:]
+		switch family {
+		case 2:
+			version = 4
+		case 10, 24, 28, 30:
+			version = 6
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return datagram{}, false
+		}
+		version = etherVersion(binary.BigEndian.Uint16(data[14:]))
+		data = data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return datagram{}, false
+		}
+		version = etherVersion(binary.BigEndian.Uint16(data))
+		data = data[20:]
+	case linkTypeRaw:
+		if len(data) > 0 {
+			version = int(data[0] >> 4)
+		}
+	case linkTypeIPv4:
+		version = 4
+	case linkTypeIPv6:
+		version = 6
+	}
+
+	var src, dst net.IP
+	switch version {
+	case 4:
+		if len(data) < 20 || data[9] != 17 {
+			return datagram{}, false
+		}
+		headerLength := int(data[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(data[2:]))
+		if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 {
+			// A fragment.
+			return datagram{}, false
+		}
+		if headerLength < 20 || totalLength < headerLength || len(data) < headerLength {
+			return datagram{}, false
+		}
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[headerLength:min(totalLength, len(data))]
+	case 6:
+		if len(data) < 40 {
+			return datagram{}, false
+		}
+		next := data[6]
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:]
+		// Skip the hop-by-hop, routing and destination options headers.
+		for next == 0 || next == 43 || next == 60 {
+			if len(data) < 8 || len(data) < 8+int(data[1])*8 {
+				return datagram{}, false
+			}
+			next, data = data[0], data[8+int(data[1])*8:]
+		}
+		if next != 17 {
+			return datagram{}, false
+		}
+	default:
+		return datagram{}, false
+	}
+
+	if len(data) < 8 {
+		return datagram{}, false
+	}
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length < 8 {
+		return datagram{}, false
+	}
+	return datagram{
+		time:    f.time,
+		src:     &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(data))},
+		dst:     &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(data[2:]))},
+		payload: data[8:min(length, len(data))],
+	}, true
+}
+
+// etherVersion returns the IP version of an EtherType, 0 if it isn't IP.
+func etherVersion(etherType uint16) int {
+	switch etherType {
+	case 0x0800:
+		return 4
+	case 0x86dd:
+		return 6
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// liveSource captures the frames of an interface with a packet socket, which takes
+// CAP_NET_RAW. Frames come without their link layer header, as raw IP packets.
+type liveSource struct {
+	fd       int
+	loopback bool
+	buffer   []byte
+
+	// stopped is polled between reads, which time out so it is seen.
+	stopped func() bool
+}
+
+func openLive(name string, stopped func() bool) (source, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	protocol := htons(syscall.ETH_P_ALL)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(protocol))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: iface.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	timeout := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &liveSource{
+		fd:       fd,
+		loopback: iface.Flags&net.FlagLoopback != 0,
+		buffer:   make([]byte, 1<<16),
+		stopped:  stopped,
+	}, nil
+}
+
+func (s *liveSource) next() (frame, error) {
+	for !s.stopped() {
+		n, from, err := syscall.Recvfrom(s.fd, s.buffer, 0)
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return frame{}, err
+		}
+		// Loopback interfaces hand over every packet both as sent and as received.
+		if addr, ok := from.(*syscall.SockaddrLinklayer); ok && s.loopback && addr.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		return frame{time: time.Now(), linkType: linkTypeRaw, data: append([]byte(nil), s.buffer[:n]...)}, nil
+	}
+	syscall.Close(s.fd)
+	return frame{}, errStopped
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
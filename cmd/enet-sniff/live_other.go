@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openLive(name string, stopped func() bool) (source, error) {
+	return nil, errors.New("live capture is only supported on Linux, capture with tcpdump -w and read the capture with -r")
+}
@@ -0,0 +1,160 @@
+// Command enet-sniff decodes the ENet protocol off the wire, to debug what hosts
+// actually send, such as retransmission storms. It reads pcap or pcapng captures, as
+// written by tcpdump, Wireshark or enet.PcapWriter, or captures live on Linux:
+//
+//	enet-sniff -r capture.pcapng -port 7777
+//	enet-sniff -i eth0 -port 7777
+//
+// Every datagram is printed with its commands, decoded with enet.DecodeDatagram, along
+// with notes following the traffic of each direction: retransmissions of reliable
+// commands, the time they took to be acknowledged, and the fragments of packets seen
+// so far. Once the capture ends, or when interrupted, a summary of each direction
+// follows, which is all that is printed with -q.
+//
+// Live capture takes a packet socket, so root or CAP_NET_RAW.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// errStopped is returned by live sources once interrupted.
+var errStopped = errors.New("stopped")
+
+type filter struct {
+	port int
+	peer string
+}
+
+// match returns true if the datagram is to or from the port and peer of the filter.
+func (f filter) match(d datagram) bool {
+	if f.port != 0 && d.src.Port != f.port && d.dst.Port != f.port {
+		return false
+	}
+	if f.peer == "" {
+		return true
+	}
+	for _, addr := range []*net.UDPAddr{d.src, d.dst} {
+		if addr.String() == f.peer || addr.IP.String() == f.peer {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	file := flag.String("r", "", "pcap or pcapng capture to read, - for stdin")
+	iface := flag.String("i", "", "interface to capture on live, Linux only")
+	port := flag.Int("port", 0, "only decode datagrams to or from this UDP port")
+	peer := flag.String("peer", "", "only decode datagrams to or from this ip or ip:port")
+	protocol := flag.String("protocol", "fork", "wire format of the hosts, fork or stock")
+	checksum := flag.Bool("checksum", false, "the hosts checksum their datagrams")
+	quiet := flag.Bool("q", false, "only print the summary")
+	flag.Parse()
+
+	if (*file == "") == (*iface == "") || *port < 0 || *port > 65535 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	config := enet.ProtocolConfig{Checksum: *checksum}
+	switch *protocol {
+	case "fork":
+	case "stock":
+		config.Protocol = enet.ProtocolStock
+	default:
+		fatal(fmt.Errorf("unknown protocol %q", *protocol))
+	}
+
+	var stopped atomic.Bool
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		stopped.Store(true)
+		signal.Stop(interrupt)
+	}()
+
+	var src source
+	var err error
+	switch {
+	case *file == "-":
+		src, err = openCapture(os.Stdin)
+	case *file != "":
+		var f *os.File
+		if f, err = os.Open(*file); err == nil {
+			defer f.Close()
+			src, err = openCapture(f)
+		}
+	default:
+		src, err = openLive(*iface, stopped.Load)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	t := newTimeline()
+	err = sniff(src, filter{port: *port, peer: *peer}, config, t, stopped.Load, *quiet)
+	if !*quiet {
+		fmt.Println()
+	}
+	t.summarize(os.Stdout)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "enet-sniff:", err)
+	os.Exit(1)
+}
+
+// sniff decodes the datagrams of src until it runs out or stopped returns true.
+func sniff(src source, f filter, config enet.ProtocolConfig, t *timeline, stopped func() bool, quiet bool) error {
+	var start time.Time
+	for !stopped() {
+		fr, err := src.next()
+		if err == io.EOF || err == errStopped {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dg, ok := decodeFrame(fr)
+		if !ok || !f.match(dg) {
+			continue
+		}
+
+		decoded, err := enet.DecodeDatagram(dg.payload, config)
+		notes := t.observe(dg, decoded, err)
+		if quiet {
+			continue
+		}
+		if start.IsZero() {
+			start = dg.time
+		}
+		header, _, _ := strings.Cut(decoded.String(), "\n")
+		fmt.Printf("%11.6f %s > %s %s\n", dg.time.Sub(start).Seconds(), dg.src, dg.dst, header)
+		for i, c := range decoded.Commands {
+			if notes[i] != "" {
+				fmt.Printf("    %s (%s)\n", c, notes[i])
+			} else {
+				fmt.Printf("    %s\n", c)
+			}
+		}
+		if err != nil {
+			fmt.Printf("    %v\n", err)
+		}
+	}
+	return nil
+}
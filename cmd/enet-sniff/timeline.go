@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// forgetAfter is how long acknowledged commands and complete packets are remembered,
+// to tell their retransmissions apart from new commands reusing their sequence
+// numbers once they wrapped around.
+const forgetAfter = 10 * time.Second
+
+// reliableSend is a reliable command, waiting for its acknowledgement until acked is
+// set.
+type reliableSend struct {
+	first, last time.Time
+	sends       int
+	acked       time.Time
+}
+
+// fragmentedPacket is a packet whose fragments are being sent.
+type fragmentedPacket struct {
+	first    time.Time
+	complete time.Time
+	count    uint32
+	length   uint32
+	received map[uint32]struct{}
+}
+
+// direction is the traffic from one endpoint to another.
+type direction struct {
+	name string
+
+	datagrams    int
+	bytes        int
+	decodeErrors int
+
+	reliable       map[uint32]*reliableSend
+	sends          int
+	retransmits    int
+	maxRetransmits int
+
+	acked    int
+	ackTotal time.Duration
+	ackMax   time.Duration
+
+	fragments         map[uint32]*fragmentedPacket
+	fragmentsComplete int
+}
+
+// timeline follows the sequence numbers, acknowledgements and fragments of every
+// direction of the captured traffic.
+type timeline struct {
+	directions map[string]*direction
+	order      []*direction
+}
+
+func newTimeline() *timeline {
+	return &timeline{directions: make(map[string]*direction)}
+}
+
+func (t *timeline) direction(src, dst string) *direction {
+	name := src + " > " + dst
+	d := t.directions[name]
+	if d == nil {
+		d = &direction{
+			name:      name,
+			reliable:  make(map[uint32]*reliableSend),
+			fragments: make(map[uint32]*fragmentedPacket),
+		}
+		t.directions[name] = d
+		t.order = append(t.order, d)
+	}
+	return d
+}
+
+// observe follows a datagram, returning a note on each of its commands, empty if
+// there's nothing to tell.
+func (t *timeline) observe(dg datagram, decoded enet.Datagram, err error) []string {
+	src, dst := dg.src.String(), dg.dst.String()
+	forward := t.direction(src, dst)
+	forward.datagrams++
+	forward.bytes += len(dg.payload)
+	if err != nil {
+		forward.decodeErrors++
+	}
+
+	notes := make([]string, len(decoded.Commands))
+	for i, c := range decoded.Commands {
+		if c.Acknowledge {
+			notes[i] = forward.send(dg.time, c)
+		}
+		switch c.Type {
+		case enet.CommandAcknowledge:
+			notes[i] = t.direction(dst, src).acknowledge(dg.time, c)
+		case enet.CommandSendFragment, enet.CommandSendUnreliableFragment:
+			if note := forward.fragment(dg.time, c); notes[i] == "" {
+				notes[i] = note
+			} else if note != "" {
+				notes[i] += ", " + note
+			}
+		}
+	}
+	return notes
+}
+
+// send follows a reliable command, telling if it is a retransmission.
+func (d *direction) send(now time.Time, c enet.Command) string {
+	key := uint32(c.ChannelID)<<16 | uint32(c.ReliableSequenceNumber)
+	send := d.reliable[key]
+	if send != nil && !send.acked.IsZero() && now.Sub(send.acked) > forgetAfter {
+		send = nil
+	}
+	if send == nil {
+		d.sends++
+		d.reliable[key] = &reliableSend{first: now, last: now, sends: 1}
+		return ""
+	}
+	send.sends++
+	send.last = now
+	d.retransmits++
+	d.maxRetransmits = max(d.maxRetransmits, send.sends-1)
+	if !send.acked.IsZero() {
+		return fmt.Sprintf("retransmission %d, %v after the acknowledgement, which was likely lost",
+			send.sends-1, round(now.Sub(send.acked)))
+	}
+	return fmt.Sprintf("retransmission %d, %v after the first send", send.sends-1, round(now.Sub(send.first)))
+}
+
+// acknowledge follows an acknowledgement of a reliable command sent in the direction.
+func (d *direction) acknowledge(now time.Time, c enet.Command) string {
+	key := uint32(c.ChannelID)<<16 | uint32(c.ReceivedReliableSequenceNumber)
+	send := d.reliable[key]
+	if send == nil || !send.acked.IsZero() {
+		return "acknowledges a command already acknowledged or not captured"
+	}
+	send.acked = now
+	elapsed := now.Sub(send.last)
+	d.acked++
+	d.ackTotal += elapsed
+	d.ackMax = max(d.ackMax, elapsed)
+	if send.sends == 1 {
+		return fmt.Sprintf("acknowledged after %v", round(elapsed))
+	}
+	return fmt.Sprintf("acknowledged %v after the last of %d sends", round(elapsed), send.sends)
+}
+
+// fragment follows a fragment, telling how much of its packet has been seen.
+func (d *direction) fragment(now time.Time, c enet.Command) string {
+	key := uint32(c.ChannelID)<<16 | uint32(c.StartSequenceNumber)
+	if c.Type == enet.CommandSendUnreliableFragment {
+		key |= 1 << 31
+	}
+	packet := d.fragments[key]
+	if packet != nil && !packet.complete.IsZero() && now.Sub(packet.complete) > forgetAfter {
+		packet = nil
+	}
+	if packet == nil || packet.count != c.FragmentCount || packet.length != c.TotalLength {
+		packet = &fragmentedPacket{
+			first:    now,
+			count:    c.FragmentCount,
+			length:   c.TotalLength,
+			received: make(map[uint32]struct{}),
+		}
+		d.fragments[key] = packet
+	}
+	if !packet.complete.IsZero() {
+		return "fragment of a packet already complete"
+	}
+	packet.received[c.FragmentNumber] = struct{}{}
+	if uint32(len(packet.received)) < packet.count {
+		return fmt.Sprintf("%d of %d fragments seen", len(packet.received), packet.count)
+	}
+	packet.complete = now
+	d.fragmentsComplete++
+	return fmt.Sprintf("packet of %d bytes complete after %v", packet.length, round(now.Sub(packet.first)))
+}
+
+// summarize writes a summary of every direction to w.
+func (t *timeline) summarize(w io.Writer) {
+	for _, d := range t.order {
+		fmt.Fprintf(w, "%s\n", d.name)
+		fmt.Fprintf(w, "  %d datagrams, %d bytes", d.datagrams, d.bytes)
+		if d.decodeErrors > 0 {
+			fmt.Fprintf(w, ", %d could not be decoded", d.decodeErrors)
+		}
+		fmt.Fprintln(w)
+		if d.sends > 0 {
+			fmt.Fprintf(w, "  %d reliable commands, %d retransmissions", d.sends, d.retransmits)
+			if d.retransmits > 0 {
+				fmt.Fprintf(w, ", at most %d of one command", d.maxRetransmits)
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintf(w, "  %d acknowledged", d.acked)
+			if d.acked > 0 {
+				fmt.Fprintf(w, " after %v on average, %v at most", round(d.ackTotal/time.Duration(d.acked)), round(d.ackMax))
+			}
+			fmt.Fprintf(w, ", %d unacknowledged\n", d.sends-d.acked)
+		}
+		if len(d.fragments) > 0 {
+			incomplete := 0
+			for _, packet := range d.fragments {
+				if packet.complete.IsZero() {
+					incomplete++
+				}
+			}
+			fmt.Fprintf(w, "  %d fragmented packets complete, %d incomplete\n", d.fragmentsComplete, incomplete)
+		}
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,435 @@
+// Command enetrpc-gen generates client stubs and server glue for Go interfaces served
+// over enetrpc, in the spirit of net/rpc. It is meant to be run by go generate, next to
+// the interface:
+//
+//	//go:generate go run github.com/TubbyStubby/go-enet-sharp/cmd/enetrpc-gen -type Lobby
+//
+//	type Lobby interface {
+//		Join(ctx context.Context, req JoinRequest) (JoinResponse, error)
+//
+//		//enetrpc:channel 2
+//		//enetrpc:unsequenced
+//		Ping(ctx context.Context, req Ping) (Pong, error)
+//
+//		Leave(ctx context.Context, req LeaveRequest) error
+//	}
+//
+// Methods take a context and a request, and return a response and an error or only an
+// error. For the interface above it writes lobby_enetrpc.go, declaring:
+//
+//   - LobbyClient, implementing Lobby by calling the methods on a peer
+//   - RegisterLobby, registering an implementation of Lobby on an endpoint, whose
+//     methods can get the calling peer with enetrpc.PeerFromContext
+//   - LobbyChannels, the channels chosen by the methods, which must be listed in
+//     enetrpc.Config.Channels on both sides
+//
+// Calls are sent reliably on the channel of the endpoint, unless their method chooses
+// otherwise with directives in its doc comment:
+//
+//	//enetrpc:channel N       sends calls on channel N
+//	//enetrpc:unreliable      sends calls unreliably
+//	//enetrpc:unsequenced     sends calls unreliably and unsequenced
+//	//enetrpc:fragment        sends calls unreliably, fragmenting them unreliably too
+//
+// Responses are sent back the way their call was.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	enetPath    = "github.com/TubbyStubby/go-enet-sharp"
+	enetrpcPath = enetPath + "/enetrpc"
+	contextPath = "context"
+)
+
+// method is a method of an interface to generate for.
+type method struct {
+	name    string
+	req     string
+	resp    string // Empty if the method only returns an error.
+	channel int    // -1 for the channel of the endpoint.
+	flags   string
+}
+
+// service is an interface to generate for.
+type service struct {
+	name    string
+	methods []method
+}
+
+func main() {
+	fatal := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "enetrpc-gen: "+format+"\n", args...)
+		os.Exit(1)
+	}
+
+	types := flag.String("type", "", "comma separated names of the interfaces to generate for")
+	output := flag.String("output", "", "output file, defaults to <type>_enetrpc.go next to the input")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: enetrpc-gen -type Name[,Name...] [-output file] [file.go]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *types == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	if input == "" {
+		input = os.Getenv("GOFILE")
+	}
+	if input == "" {
+		fatal("no input file, pass one or run from go generate")
+	}
+
+	names := strings.Split(*types, ",")
+	src, err := generate(input, names)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(input), snakeCase(names[0])+"_enetrpc.go")
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		fatal("%v", err)
+	}
+}
+
+// generate returns the source generated for the named interfaces of the file.
+func generate(filename string, names []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Imports of the file by the name they are referred to with.
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := importName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+
+	g := &generator{fset: fset, imports: imports, used: make(map[string]string)}
+	var services []service
+	for _, name := range names {
+		iface := findInterface(file, name)
+		if iface == nil {
+			return nil, fmt.Errorf("%s: no interface %s", filename, name)
+		}
+		svc, err := g.service(name, iface)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return g.write(file.Name.Name, services)
+}
+
+func findInterface(file *ast.File, name string) *ast.InterfaceType {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if spec.Name.Name != name {
+				continue
+			}
+			iface, _ := spec.Type.(*ast.InterfaceType)
+			return iface
+		}
+	}
+	return nil
+}
+
+type generator struct {
+	fset    *token.FileSet
+	imports map[string]string
+	// used are the imports of the file used by the generated code, by name.
+	used map[string]string
+}
+
+func (g *generator) service(name string, iface *ast.InterfaceType) (service, error) {
+	svc := service{name: name}
+	for _, field := range iface.Methods.List {
+		pos := g.fset.Position(field.Pos())
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) != 1 {
+			return svc, fmt.Errorf("%s: embedded interfaces are not supported", pos)
+		}
+
+		m := method{name: field.Names[0].Name, channel: -1}
+		params := flatten(fn.Params)
+		results := flatten(fn.Results)
+		if len(params) != 2 || !g.isContext(params[0]) {
+			return svc, fmt.Errorf("%s: %s.%s must take a context.Context and a request", pos, name, m.name)
+		}
+		if len(results) < 1 || len(results) > 2 || !isError(results[len(results)-1]) {
+			return svc, fmt.Errorf("%s: %s.%s must return a response and an error, or only an error", pos, name, m.name)
+		}
+
+		m.req = g.expr(params[1])
+		if len(results) == 2 {
+			m.resp = g.expr(results[0])
+		}
+		if err := parseDirectives(field.Doc, &m); err != nil {
+			return svc, fmt.Errorf("%s: %w", pos, err)
+		}
+		svc.methods = append(svc.methods, m)
+	}
+	return svc, nil
+}
+
+// flatten returns the type of every parameter of a list, repeating the type of
+// parameters declared together.
+func flatten(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, field := range list.List {
+		n := max(len(field.Names), 1)
+		for range n {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+func (g *generator) isContext(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Context" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && g.imports[pkg.Name] == contextPath
+}
+
+func isError(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// expr prints a type expression, noting the imports it refers to.
+func (g *generator) expr(expr ast.Expr) string {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok {
+				if path, ok := g.imports[pkg.Name]; ok {
+					g.used[pkg.Name] = path
+				}
+			}
+		}
+		return true
+	})
+
+	var buf bytes.Buffer
+	printer.Fprint(&buf, g.fset, expr)
+	return buf.String()
+}
+
+func parseDirectives(doc *ast.CommentGroup, m *method) error {
+	if doc == nil {
+		return nil
+	}
+	for _, comment := range doc.List {
+		directive, ok := strings.CutPrefix(comment.Text, "//enetrpc:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			return errors.New("empty enetrpc directive")
+		}
+
+		switch fields[0] {
+		case "channel":
+			if len(fields) != 2 {
+				return errors.New("enetrpc:channel takes a channel")
+			}
+			channel, err := strconv.ParseUint(fields[1], 10, 8)
+			if err != nil {
+				return fmt.Errorf("invalid channel %q", fields[1])
+			}
+			m.channel = int(channel)
+			continue
+		case "unreliable":
+			m.flags = "0"
+		case "unsequenced":
+			m.flags = "enet.PacketFlagUnsequenced"
+		case "fragment":
+			m.flags = "enet.PacketFlagUnreliableFragment"
+		default:
+			return fmt.Errorf("unknown directive enetrpc:%s", fields[0])
+		}
+		if len(fields) != 1 {
+			return fmt.Errorf("enetrpc:%s takes no arguments", fields[0])
+		}
+	}
+	return nil
+}
+
+func (g *generator) write(pkg string, services []service) ([]byte, error) {
+	var buf bytes.Buffer
+	p := func(format string, args ...any) {
+		fmt.Fprintf(&buf, format+"\n", args...)
+	}
+
+	p("// Code generated by enetrpc-gen. DO NOT EDIT.")
+	p("")
+	p("package %s", pkg)
+	p("")
+	p("import (")
+	imports := map[string]string{"context": contextPath, "enet": enetPath, "enetrpc": enetrpcPath}
+	for name, path := range g.used {
+		if existing, ok := imports[name]; ok && existing != path {
+			return nil, fmt.Errorf("import %q conflicts with %q", path, existing)
+		}
+		imports[name] = path
+	}
+	var names []string
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == importName(imports[name]) {
+			p("\t%q", imports[name])
+		} else {
+			p("\t%s %q", name, imports[name])
+		}
+	}
+	p(")")
+
+	for _, svc := range services {
+		client := svc.name + "Client"
+
+		var channels []string
+		seen := make(map[int]bool)
+		for _, m := range svc.methods {
+			if m.channel >= 0 && !seen[m.channel] {
+				seen[m.channel] = true
+				channels = append(channels, strconv.Itoa(m.channel))
+			}
+		}
+		p("")
+		p("// %sChannels are the channels chosen by the methods of %s, to be listed in", svc.name, svc.name)
+		p("// enetrpc.Config.Channels on both sides.")
+		p("var %sChannels = []uint8{%s}", svc.name, strings.Join(channels, ", "))
+
+		p("")
+		p("// %s calls the methods of %s on a peer", client, svc.name)
+		p("type %s struct {", client)
+		p("\tendpoint *enetrpc.Endpoint")
+		p("\tpeer     enet.Peer")
+		p("}")
+		p("")
+		p("var _ %s = (*%s)(nil)", svc.name, client)
+		p("")
+		p("// New%s creates a client calling the methods of %s on peer through endpoint", client, svc.name)
+		p("func New%s(endpoint *enetrpc.Endpoint, peer enet.Peer) *%s {", client, client)
+		p("\treturn &%s{endpoint: endpoint, peer: peer}", client)
+		p("}")
+
+		for _, m := range svc.methods {
+			channel := "c.endpoint.Channel()"
+			if m.channel >= 0 {
+				channel = strconv.Itoa(m.channel)
+			}
+			flags := m.flags
+			if flags == "" {
+				flags = "enet.PacketFlagReliable"
+			}
+			options := fmt.Sprintf("enetrpc.CallOptions{Channel: %s, Flags: %s}", channel, flags)
+			wire := strconv.Quote(svc.name + "." + m.name)
+
+			p("")
+			if m.resp == "" {
+				p("func (c *%s) %s(ctx context.Context, req %s) error {", client, m.name, m.req)
+				p("\treturn c.endpoint.CallWith(ctx, c.peer, %s, %s, req, nil)", options, wire)
+			} else {
+				p("func (c *%s) %s(ctx context.Context, req %s) (%s, error) {", client, m.name, m.req, m.resp)
+				p("\tvar resp %s", m.resp)
+				p("\terr := c.endpoint.CallWith(ctx, c.peer, %s, %s, req, &resp)", options, wire)
+				p("\treturn resp, err")
+			}
+			p("}")
+		}
+
+		p("")
+		p("// Register%s registers the methods of impl on endpoint", svc.name)
+		p("func Register%s(endpoint *enetrpc.Endpoint, impl %s) {", svc.name, svc.name)
+		for _, m := range svc.methods {
+			wire := strconv.Quote(svc.name + "." + m.name)
+			if m.resp == "" {
+				p("\tenetrpc.Register(endpoint, %s, func(ctx context.Context, peer enet.Peer, req %s) (struct{}, error) {", wire, m.req)
+				p("\t\treturn struct{}{}, impl.%s(ctx, req)", m.name)
+			} else {
+				p("\tenetrpc.Register(endpoint, %s, func(ctx context.Context, peer enet.Peer, req %s) (%s, error) {", wire, m.req, m.resp)
+				p("\t\treturn impl.%s(ctx, req)", m.name)
+			}
+			p("\t})")
+		}
+		p("}")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// importName guesses the name of the package imported as path, which is usually the
+// last element, without major version suffixes and go- prefixes.
+func importName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	name, _, _ = strings.Cut(name, ".")
+	name = strings.TrimPrefix(name, "go-")
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// snakeCase turns a type name into a file name, for example LobbyService into
+// lobby_service.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// DefaultCoalesceSize is the size coalescers fill packets up to by default, leaving
+// room for the headers of enet in a datagram of the default MTU
+const DefaultCoalesceSize = 1200
+
+var errMalformedCoalesced = errors.New("malformed coalesced packet")
+
+// CoalescerConfig configures a Coalescer
+type CoalescerConfig struct {
+	// MaxSize is the size packets are filled up to. Messages larger than it are sent
+	// in packets of their own. Defaults to DefaultCoalesceSize.
+	MaxSize int
+
+	// AutoFlush flushes the coalescer every time the host is serviced, so the
+	// application doesn't need to call Flush at the end of each tick
+	AutoFlush bool
+}
+
+// Coalescer packs small messages sent to the same peer on the same channel with the
+// same flags into single packets, each message prefixed with its length, saving the
+// overhead of a packet per message for chatty protocols. Packed messages are sent as
+// the coalescer is flushed, so they are delayed relative to packets sent directly on
+// the same channel. Receivers split the packets with Uncoalesce. It is safe for
+// concurrent use.
+type Coalescer interface {
+	// Send queues data for peer on channel, to be packed with the other messages for
+	// peer on channel with the same flags
+	Send(peer Peer, data []byte, channel uint8, flags PacketFlags) error
+
+	// Flush sends the messages queued, as few packets as possible
+	Flush() error
+
+	// Close flushes the coalescer and stops automatic flushing
+	Close() error
+}
+
+// coalesceKey identifies the messages packed together.
+type coalesceKey struct {
+	peer    Peer
+	channel uint8
+	flags   PacketFlags
+}
+
+type coalescer struct {
+	config CoalescerConfig
+	host   *enetHost
+
+	lock    sync.Mutex
+	batches map[coalesceKey][]byte
+	order   []coalesceKey
+	closed  bool
+}
+
+// NewCoalescer creates a coalescer for messages sent to the peers of host. host is only
+// used for automatic flushing.
+func NewCoalescer(host Host, config CoalescerConfig) (Coalescer, error) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultCoalesceSize
+	}
+	c := &coalescer{config: config, batches: make(map[coalesceKey][]byte)}
+	if !config.AutoFlush {
+		return c, nil
+	}
+
+	var err error
+	register := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("automatic flushing is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		c.host = h
+		h.flushers.add(c)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(register)
+	} else {
+		register(host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *coalescer) Send(peer Peer, data []byte, channel uint8, flags PacketFlags) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return errors.New("coalescer is closed")
+	}
+
+	key := coalesceKey{peer, channel, flags}
+	batch, ok := c.batches[key]
+	if !ok {
+		c.order = append(c.order, key)
+	}
+	size := binary.MaxVarintLen32 + len(data)
+	if len(batch) > 0 && len(batch)+size > c.config.MaxSize {
+		if err := peer.SendAsync(batch, channel, flags); err != nil {
+			return err
+		}
+		batch = batch[:0]
+	}
+	batch = binary.AppendUvarint(batch, uint64(len(data)))
+	c.batches[key] = append(batch, data...)
+	return nil
+}
+
+func (c *coalescer) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.flush()
+}
+
+func (c *coalescer) flush() error {
+	var errs []error
+	for _, key := range c.order {
+		if batch := c.batches[key]; len(batch) > 0 {
+			// Errors mostly mean the peer is gone, dropping its messages.
+			if err := key.peer.SendAsync(batch, key.channel, key.flags); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	clear(c.batches)
+	c.order = c.order[:0]
+	return errors.Join(errs...)
+}
+
+func (c *coalescer) Close() error {
+	// Stop automatic flushing first, the host holding its flushers as it flushes them.
+	if c.host != nil {
+		c.host.flushers.remove(c)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.flush()
+}
+
+// Uncoalesce splits a packet of a Coalescer into its messages, which are slices of
+// data
+func Uncoalesce(data []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, errMalformedCoalesced
+		}
+		messages = append(messages, data[n:n+int(size)])
+		data = data[n+int(size):]
+	}
+	return messages, nil
+}
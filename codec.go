@@ -0,0 +1,35 @@
+package enet
+
+import "errors"
+
+// Codec encodes values into packet payloads and back, for example with MessagePack
+// for clients that have no schema compiler. Implementations must be safe for
+// concurrent use.
+type Codec interface {
+	// Marshal returns the encoding of v. The returned slice is not retained by the
+	// codec.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data into v, which is usually a pointer. It must not retain
+	// data.
+	Unmarshal(data []byte, v any) error
+}
+
+// SendValue encodes v with codec and sends it to peer, prefixed with the opcode in the
+// given format so a Dispatcher can route it.
+func SendValue(peer Peer, channel uint8, flags PacketFlags, format OpcodeFormat, opcode uint64, codec Codec, v any) error {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data := AppendOpcode(make([]byte, 0, 10+len(payload)), format, opcode)
+	return peer.SendBytes(append(data, payload...), channel, flags)
+}
+
+// Decode decodes the payload of the message with codec into v
+func (msg *Message) Decode(codec Codec, v any) error {
+	if codec == nil {
+		return errors.New("no codec")
+	}
+	return codec.Unmarshal(msg.Payload, v)
+}
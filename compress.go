@@ -0,0 +1,524 @@
+package enet
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCompressionTimeout is how long peers are given by default to advertise
+	// their compressors
+	DefaultCompressionTimeout = 5 * time.Second
+
+	// DefaultCompressionMinSize is the size below which payloads are sent uncompressed
+	// by default
+	DefaultCompressionMinSize = 64
+
+	// DefaultCompressionMaxSize bounds by default the size of decompressed payloads
+	DefaultCompressionMaxSize = 4 << 20
+
+	// compressionMaxHeld bounds the packets held for a peer until it advertises its
+	// compressors, beyond which it is disconnected.
+	compressionMaxHeld = 64
+
+	// compressionOffer heads the message advertising the compressors of a host.
+	compressionOffer byte = 0
+)
+
+// Compressor compresses packet payloads, see NegotiateCompression. Implementations
+// must be safe for concurrent use.
+type Compressor interface {
+	// Name identifies the algorithm to peers, which must name it the same, for example
+	// "zstd". At most 255 bytes.
+	Name() string
+
+	// Compress appends the compression of src to dst
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompression of src to dst, failing if it is larger than
+	// limit bytes
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+// DeflateCompressor is a Compressor with the deflate of compress/flate, available
+// everywhere. See the enetzstd package for zstd.
+type DeflateCompressor struct {
+	// Level is the compression level of compress/flate, 0 meaning
+	// flate.DefaultCompression
+	Level int
+}
+
+func (c DeflateCompressor) Name() string { return "deflate" }
+
+func (c DeflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return dst, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c DeflateCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	buf := bytes.NewBuffer(dst)
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return dst, err
+	}
+	if n > int64(limit) {
+		return dst, errors.New("decompressed payload is too large")
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressionConfig configures the compression negotiated by a host, see
+// NegotiateCompression
+type CompressionConfig struct {
+	// Channel is the channel reserved for advertising compressors, whose packets are
+	// no longer returned by Host.Service
+	Channel uint8
+
+	// Compressors are the compressors of the host, by order of preference
+	Compressors []Compressor
+
+	// Channels are the channels whose payloads are compressed. Defaults to every
+	// channel but Channel.
+	Channels []uint8
+
+	// Timeout is how long peers are given to advertise their compressors, after which
+	// they are taken for peers that don't negotiate. Defaults to
+	// DefaultCompressionTimeout.
+	Timeout time.Duration
+
+	// MinSize is the size below which payloads are sent uncompressed. Defaults to
+	// DefaultCompressionMinSize.
+	MinSize int
+
+	// MaxSize bounds the size of decompressed payloads, larger ones are dropped.
+	// Defaults to DefaultCompressionMaxSize.
+	MaxSize int
+}
+
+// compressionPeer is the state of the negotiation with a peer.
+type compressionPeer struct {
+	started     time.Time
+	connectData uint32
+
+	// negotiated is set once the peer advertised its compressors, legacy once it was
+	// given up on.
+	negotiated bool
+	legacy     bool
+
+	// send is the compressor payloads sent to the peer are compressed with, and index
+	// its position in the offer of the peer, nil if they have none in common.
+	send  Compressor
+	index byte
+
+	// receive are the compressors offered to the peer, which its payloads are
+	// decompressed with.
+	receive []Compressor
+
+	held []heldPacket
+}
+
+// compressionTable holds the compression negotiated with the peers of a host.
+type compressionTable struct {
+	enabled atomic.Bool
+
+	lock   sync.Mutex
+	config CompressionConfig
+	offer  []byte
+	peers  map[enetPeer]*compressionPeer
+
+	// ready holds the events released by a negotiation, returned by the next Service
+	// calls.
+	ready []Event
+}
+
+// NegotiateCompression makes host advertise its compressors on config.Channel to every
+// peer that connects from then on, and pick for each one the compressor it prefers
+// among those they have in common. Connect events are held back until the peer
+// advertised its own, or until the timeout for peers that don't negotiate, which keep
+// working uncompressed and must ignore the packet advertising the compressors. Calling
+// it again replaces the configuration for the peers connecting from then on.
+//
+// Payloads sent with SendCompressed to a peer that negotiated are compressed, and
+// decompressed before Host.Service returns them, so once negotiated both sides must
+// send with SendCompressed on config.Channels. Payloads are headed with a byte telling
+// the compressor apart, which is all it costs when they have none in common. Peers
+// encrypting with Noise don't negotiate, encrypted payloads don't compress.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func NegotiateCompression(host Host, config CompressionConfig) error {
+	if len(config.Compressors) > 255 {
+		return errors.New("too many compressors")
+	}
+	offer := []byte{compressionOffer, byte(len(config.Compressors))}
+	for _, compressor := range config.Compressors {
+		name := compressor.Name()
+		if name == "" || len(name) > 255 {
+			return errors.New("invalid compressor name")
+		}
+		offer = append(offer, byte(len(name)))
+		offer = append(offer, name...)
+	}
+	if config.Timeout < 0 || config.MinSize < 0 || config.MaxSize < 0 {
+		return errors.New("limits can't be negative")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultCompressionTimeout
+	}
+	if config.MinSize == 0 {
+		config.MinSize = DefaultCompressionMinSize
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = DefaultCompressionMaxSize
+	}
+	config.Compressors = slices.Clone(config.Compressors)
+	config.Channels = slices.Clone(config.Channels)
+
+	var err error
+	enable := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("compression is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.compression
+		table.lock.Lock()
+		table.config = config
+		table.offer = offer
+		if table.peers == nil {
+			table.peers = make(map[enetPeer]*compressionPeer)
+		}
+		table.lock.Unlock()
+		table.enabled.Store(true)
+		h.features.join(featureCompression)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(enable)
+	} else {
+		enable(host)
+	}
+	return err
+}
+
+// SendCompressed sends data to peer, compressed with the compressor negotiated with it,
+// see NegotiateCompression. Data is sent as is to peers that did not negotiate.
+func SendCompressed(peer Peer, data []byte, channel uint8, flags PacketFlags) error {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return errors.New("compression is only supported on enet peers")
+	}
+	host := p.host()
+	if host == nil {
+		return errors.New("peer has no host")
+	}
+
+	payload, err := host.compression.compress(p, channel, data)
+	if err != nil {
+		return err
+	}
+	return peer.SendBytes(payload, channel, flags)
+}
+
+// PeerCompressor returns the name of the compressor negotiated with peer, whose host
+// negotiates compression with NegotiateCompression. Returns false if the peer did not
+// negotiate yet, or has no compressor in common with the host. It is safe to call from
+// any goroutine.
+func PeerCompressor(peer Peer) (string, bool) {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return "", false
+	}
+	host := p.host()
+	if host == nil || !host.compression.enabled.Load() {
+		return "", false
+	}
+
+	table := &host.compression
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	state := table.peers[p]
+	if state == nil || state.send == nil {
+		return "", false
+	}
+	return state.send.Name(), true
+}
+
+// compresses returns true if the payloads of channel are compressed. Must be called
+// with the lock held.
+func (table *compressionTable) compresses(channel uint8) bool {
+	if channel == table.config.Channel {
+		return false
+	}
+	return len(table.config.Channels) == 0 || slices.Contains(table.config.Channels, channel)
+}
+
+// compress returns the payload sending data to peer on channel.
+func (table *compressionTable) compress(peer enetPeer, channel uint8, data []byte) ([]byte, error) {
+	if !table.enabled.Load() {
+		return data, nil
+	}
+	table.lock.Lock()
+	state := table.peers[peer]
+	if state == nil || !state.negotiated || !table.compresses(channel) {
+		table.lock.Unlock()
+		return data, nil
+	}
+	compressor, index, minSize := state.send, state.index, table.config.MinSize
+	table.lock.Unlock()
+
+	payload := make([]byte, 1, 1+len(data))
+	if compressor != nil && len(data) >= minSize {
+		compressed, err := compressor.Compress(payload, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < 1+len(data) {
+			compressed[0] = index + 1
+			return compressed, nil
+		}
+	}
+	return append(payload, data...), nil
+}
+
+// decompress returns the data of a payload received from a peer that negotiated. Must
+// be called with the lock held.
+func (table *compressionTable) decompress(state *compressionPeer, payload []byte) ([]byte, bool) {
+	if len(payload) == 0 {
+		return nil, false
+	}
+	if payload[0] == 0 {
+		return bytes.Clone(payload[1:]), true
+	}
+	index := int(payload[0]) - 1
+	if index >= len(state.receive) {
+		return nil, false
+	}
+	data, err := state.receive[index].Decompress(nil, payload[1:], table.config.MaxSize)
+	return data, err == nil
+}
+
+// next sets event to the next event released by a negotiation. Returns false if there
+// is none.
+func (table *compressionTable) next(event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if len(table.ready) == 0 {
+		return false
+	}
+	event.goEvent = table.ready[0]
+	event.timestamp = time.Now()
+	table.ready[0] = nil
+	table.ready = table.ready[1:]
+	return true
+}
+
+// expire gives up on the peers that take too long to advertise their compressors,
+// releasing their events uncompressed.
+func (table *compressionTable) expire() {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	now := time.Now()
+	for peer, state := range table.peers {
+		if !state.negotiated && !state.legacy && now.Sub(state.started) > table.config.Timeout {
+			state.legacy = true
+			table.release(peer, state)
+		}
+	}
+}
+
+// release queues the connect event of peer followed by the packets held until now.
+// Must be called with the lock held.
+func (table *compressionTable) release(peer enetPeer, state *compressionPeer) {
+	table.ready = append(table.ready, &noiseEvent{
+		eventType: EventConnect,
+		peer:      peer,
+		data:      state.connectData,
+		timestamp: time.Now(),
+	})
+	for _, held := range state.held {
+		data := held.data
+		if state.negotiated && table.compresses(held.channel) {
+			var ok bool
+			if data, ok = table.decompress(state, data); !ok {
+				continue
+			}
+		}
+		if received := newNoiseReceiveEvent(peer, held.channel, held.flags, data); received != nil {
+			table.ready = append(table.ready, received)
+		}
+	}
+	state.held = nil
+}
+
+// intercept runs the negotiations and decompresses payloads. Returns true if the event
+// has been consumed and must not be returned to the application. Decompressed packets
+// replace the event.
+func (table *compressionTable) intercept(host *enetHost, event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	state := table.peers[peer]
+	switch event.GetType() {
+	case EventConnect:
+		if host.noise.enabled.Load() {
+			// Sealed payloads don't compress, and are not headed.
+			return false
+		}
+		state = &compressionPeer{
+			started:     time.Now(),
+			connectData: event.GetData(),
+			receive:     table.config.Compressors,
+		}
+		table.peers[peer] = state
+		if peer.SendBytes(table.offer, table.config.Channel, PacketFlagReliable) != nil {
+			state.legacy = true
+			return false
+		}
+		return true
+
+	case EventDisconnect, EventDisconnectTimeout:
+		if state == nil {
+			return false
+		}
+		delete(table.peers, peer)
+		// The application only knows of the peers released to it.
+		return !state.negotiated && !state.legacy
+
+	case EventReceive:
+		if state == nil {
+			// The peer connected before compression was negotiated.
+			return false
+		}
+	default:
+		return false
+	}
+
+	packet := event.GetPacket()
+	channel := event.GetChannelID()
+	if channel == table.config.Channel {
+		defer packet.Destroy()
+		if state.negotiated {
+			return true
+		}
+		if state.legacy {
+			// Its payloads can't be told apart from those sent before it negotiated.
+			peer.Disconnect(0)
+			return true
+		}
+		if !table.negotiate(state, event.GetPacketDataUnsafe()) {
+			peer.Disconnect(0)
+			state.held = nil
+			return true
+		}
+		table.release(peer, state)
+		return true
+	}
+
+	if !state.negotiated && !state.legacy {
+		if len(state.held) == compressionMaxHeld {
+			peer.Disconnect(0)
+			state.held = nil
+			return true
+		}
+		state.held = append(state.held, heldPacket{
+			channel: channel,
+			flags:   packet.GetFlags(),
+			data:    packet.GetData(),
+		})
+		packet.Destroy()
+		return true
+	}
+	if state.legacy || !table.compresses(channel) {
+		return false
+	}
+
+	defer packet.Destroy()
+	data, ok := table.decompress(state, event.GetPacketDataUnsafe())
+	if !ok {
+		return true
+	}
+	received := newNoiseReceiveEvent(peer, channel, packet.GetFlags(), data)
+	if received == nil {
+		return true
+	}
+	received.timestamp = event.timestamp
+	event.goEvent = received
+	return false
+}
+
+// negotiate reads the offer of a peer, picking the compressor payloads are sent to it
+// with. Returns false if the offer is malformed. Must be called with the lock held.
+func (table *compressionTable) negotiate(state *compressionPeer, offer []byte) bool {
+	if len(offer) < 2 || offer[0] != compressionOffer {
+		return false
+	}
+	count := int(offer[1])
+	offer = offer[2:]
+	for i := range count {
+		if len(offer) == 0 || len(offer) < 1+int(offer[0]) {
+			return false
+		}
+		name := string(offer[1 : 1+offer[0]])
+		offer = offer[1+offer[0]:]
+		if state.send != nil {
+			continue
+		}
+		for _, compressor := range table.config.Compressors {
+			if compressor.Name() == name {
+				state.send = compressor
+				state.index = byte(i)
+				break
+			}
+		}
+	}
+	state.negotiated = true
+	return true
+}
@@ -0,0 +1,137 @@
+package enet
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// peerConditions are the network conditions simulated for a peer.
+type peerConditions struct {
+	latency time.Duration
+	jitter  time.Duration
+	loss    float64
+}
+
+// conditionTable holds the conditions simulated for the peers of a host, by the address
+// of the peers, and the datagrams held back on their way to them. Must only be used by
+// the goroutine servicing the host.
+type conditionTable struct {
+	installed bool
+	peers     map[netip.AddrPort]peerConditions
+	held      simQueue
+	seq       uint64
+}
+
+// simulateConditions degrades the connection to the peer, see Peer.SimulateConditions.
+func (peer enetPeer) simulateConditions(latency, jitter time.Duration, loss float64) error {
+	if latency < 0 || jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if loss < 0 || loss > 1 {
+		return errors.New("loss must be between 0 and 1")
+	}
+	host := peer.host()
+	if host == nil || host.destroyed {
+		return errHostDestroyed
+	}
+
+	table := &host.conditions
+	key := simAddrKey(udpAddrOf(peer.address()))
+	if latency == 0 && jitter == 0 && loss == 0 {
+		delete(table.peers, key)
+		return nil
+	}
+	if !table.installed {
+		host.installSendHook()
+		host.intercepts.add(host, table.intercept)
+		host.features.join(featureConditions)
+		table.installed = true
+	}
+	if table.peers == nil {
+		table.peers = make(map[netip.AddrPort]peerConditions)
+	}
+	table.peers[key] = peerConditions{latency: latency, jitter: jitter, loss: loss}
+	return nil
+}
+
+// shaped returns true if conditions are simulated for the peer at addr.
+func (table *conditionTable) shaped(addr *net.UDPAddr) bool {
+	_, ok := table.peers[simAddrKey(addr)]
+	return ok
+}
+
+// send drops or holds back a datagram enet sends to the peer at addr, which the table
+// then owns. Returns false if the datagram must be sent as is.
+func (table *conditionTable) send(addr *net.UDPAddr, data []byte) bool {
+	conditions, ok := table.peers[simAddrKey(addr)]
+	if !ok {
+		return false
+	}
+	if conditions.loss > 0 && rand.Float64() < conditions.loss {
+		return true
+	}
+	delay := conditions.latency
+	if conditions.jitter > 0 {
+		delay += rand.N(conditions.jitter + 1)
+	}
+	if delay <= 0 {
+		return false
+	}
+
+	table.seq++
+	heap.Push(&table.held, &simDatagram{
+		due:       time.Now().Add(delay),
+		seq:       table.seq,
+		direction: simOutgoing,
+		addr:      addr,
+		data:      data,
+	})
+	return true
+}
+
+// intercept drops datagrams received from the peers conditions are simulated for, as
+// they are lost.
+func (table *conditionTable) intercept(addr rawAddress, data []byte) bool {
+	conditions, ok := table.peers[simAddrKey(udpAddrOf(addr))]
+	return ok && conditions.loss > 0 && rand.Float64() < conditions.loss
+}
+
+// release sends the datagrams held back that are due.
+func (table *conditionTable) release(host *enetHost) {
+	now := time.Now()
+	for len(table.held) > 0 && !table.held[0].due.After(now) {
+		datagram := heap.Pop(&table.held).(*simDatagram)
+		host.socketSend(datagram.addr.(*net.UDPAddr), datagram.data)
+	}
+}
+
+// timeout shortens the timeout of a Service call so it returns once the next datagram
+// held back is due.
+func (table *conditionTable) timeout(timeout uint32) uint32 {
+	if len(table.held) == 0 {
+		return timeout
+	}
+	wait := time.Until(table.held[0].due)
+	if wait <= 0 {
+		return 0
+	}
+	return min(timeout, uint32((wait+time.Millisecond-1)/time.Millisecond))
+}
+
+// forget ends the conditions of a peer that disconnected, as its address may be reused.
+func (table *conditionTable) forget(event *enetEvent) {
+	if len(table.peers) == 0 {
+		return
+	}
+	eventType := event.GetType()
+	if eventType != EventDisconnect && eventType != EventDisconnectTimeout {
+		return
+	}
+	if peer, ok := event.GetPeer().(enetPeer); ok {
+		delete(table.peers, simAddrKey(udpAddrOf(peer.address())))
+	}
+}
@@ -0,0 +1,444 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	connFrameData  = 0
+	connFrameClose = 1
+
+	// connFrameWindow tells the other side how many bytes were read since the last
+	// one, as a uvarint, making room in its window.
+	connFrameWindow = 2
+
+	// connChunkSize is the largest amount of stream data put into a single packet.
+	connChunkSize = 16 * 1024
+
+	// connWindow is the most data written to a connection that the other side hasn't
+	// read yet, which bounds the receive buffer. Writes block once it is reached.
+	connWindow = 256 * 1024
+)
+
+var errConnWindowExceeded = errors.New("peer sent more than the receive window")
+
+type streamKey struct {
+	peer    enetPeer
+	channel uint8
+}
+
+// streamRegistry holds the connections receiving the packets of a host.
+type streamRegistry struct {
+	count atomic.Int32
+
+	lock  sync.Mutex
+	conns map[streamKey]*peerConn
+}
+
+func (reg *streamRegistry) add(conn *peerConn) error {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if reg.conns == nil {
+		reg.conns = make(map[streamKey]*peerConn)
+	}
+	if _, ok := reg.conns[conn.key]; ok {
+		return fmt.Errorf("channel %d of this peer is already used by a connection", conn.key.channel)
+	}
+	reg.conns[conn.key] = conn
+	reg.count.Add(1)
+	return nil
+}
+
+func (reg *streamRegistry) remove(conn *peerConn) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if reg.conns[conn.key] == conn {
+		delete(reg.conns, conn.key)
+		reg.count.Add(-1)
+	}
+}
+
+// intercept hands received packets to their connection. Returns true if the event has
+// been consumed and must not be returned to the application.
+func (reg *streamRegistry) intercept(event *enetEvent) bool {
+	if reg.count.Load() == 0 {
+		return false
+	}
+
+	peer, _ := event.GetPeer().(enetPeer)
+	switch event.GetType() {
+	case EventReceive:
+		reg.lock.Lock()
+		conn := reg.conns[streamKey{peer: peer, channel: event.GetChannelID()}]
+		reg.lock.Unlock()
+		if conn == nil {
+			return false
+		}
+
+		conn.receive(event.GetPacketDataUnsafe())
+		event.GetPacket().Destroy()
+		return true
+
+	case EventDisconnect, EventDisconnectTimeout:
+		reg.lock.Lock()
+		var broken []*peerConn
+		for key, conn := range reg.conns {
+			if key.peer == peer {
+				broken = append(broken, conn)
+				delete(reg.conns, key)
+				reg.count.Add(-1)
+			}
+		}
+		reg.lock.Unlock()
+
+		for _, conn := range broken {
+			conn.disconnected()
+		}
+	}
+	return false
+}
+
+// PeerConn presents a channel of a peer as a byte stream, so stream based protocols can
+// run over enet unchanged. The channel is used exclusively by the connection: packets
+// received on it are no longer returned by Host.Service. Data is sent reliably, so both
+// sides must use the same channel and the channel must be ordered.
+//
+// The connection is safe for concurrent use and may be used from other goroutines
+// than the one servicing the host, which must keep servicing it for data to flow.
+// Writes block while the other side has 256 KiB written to it that it hasn't read yet,
+// so a slow reader slows writers down rather than data piling up in memory. Closing
+// the connection makes reads on the other side return io.EOF but does not disconnect
+// the peer.
+func PeerConn(peer Peer, channel uint8) (net.Conn, error) {
+	if safe, ok := peer.(safePeer); ok {
+		peer = safe.Peer
+	}
+	p, ok := peer.(enetPeer)
+	if !ok {
+		return nil, errors.New("connections are only supported on enet peers")
+	}
+	host := p.host()
+	if host == nil {
+		return nil, errors.New("peer has no host")
+	}
+
+	conn := &peerConn{
+		peer:     p,
+		host:     host,
+		key:      streamKey{peer: p, channel: channel},
+		notify:   make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		local:    host.localUDPAddr(),
+		remote:   udpAddrOf(p.address()),
+		deadline: newConnDeadline(),
+	}
+	if err := host.streams.add(conn); err != nil {
+		return nil, err
+	}
+	host.features.join(featureStreams)
+	return conn, nil
+}
+
+type peerConn struct {
+	peer   enetPeer
+	host   *enetHost
+	key    streamKey
+	local  net.Addr
+	remote net.Addr
+
+	lock         sync.Mutex
+	buffer       []byte
+	remoteClosed bool
+	broken       bool
+	closed       bool
+	notify       chan struct{}
+
+	// unacked is the data written that the other side hasn't reported read, and
+	// writable wakes writers waiting for it to go down.
+	unacked  int
+	writable chan struct{}
+
+	// read is the data read that hasn't been reported to the other side yet, and
+	// overflowed is set once the other side sent more than the window.
+	read       int
+	overflowed bool
+
+	deadline *connDeadline
+}
+
+// receive is called by the goroutine servicing the host with the payload of a packet.
+func (conn *peerConn) receive(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	conn.lock.Lock()
+	switch data[0] {
+	case connFrameData:
+		if conn.overflowed {
+			break
+		}
+		if len(conn.buffer)+len(data)-1 > connWindow {
+			// The other side doesn't respect the window, drop what it sends.
+			conn.overflowed = true
+			conn.buffer = nil
+			break
+		}
+		conn.buffer = append(conn.buffer, data[1:]...)
+	case connFrameClose:
+		conn.remoteClosed = true
+	case connFrameWindow:
+		if n, size := binary.Uvarint(data[1:]); size > 0 {
+			conn.unacked = max(conn.unacked-int(min(n, connWindow)), 0)
+			signal(conn.writable)
+		}
+	}
+	conn.lock.Unlock()
+
+	conn.wake()
+}
+
+func (conn *peerConn) disconnected() {
+	conn.lock.Lock()
+	conn.broken = true
+	conn.lock.Unlock()
+
+	conn.wake()
+}
+
+func (conn *peerConn) wake() {
+	signal(conn.notify)
+	signal(conn.writable)
+}
+
+// signal wakes whoever waits on c, unless it's already signalled.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (conn *peerConn) Read(b []byte) (int, error) {
+	for {
+		conn.lock.Lock()
+		if conn.closed {
+			conn.lock.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(conn.buffer) > 0 {
+			n := copy(b, conn.buffer)
+			conn.buffer = conn.buffer[n:]
+			if len(conn.buffer) == 0 {
+				conn.buffer = nil
+			}
+			// Report what was read in batches, a quarter of the window at a time, so
+			// writers blocked on a full window are woken up.
+			conn.read += n
+			read := 0
+			if conn.read >= connWindow/4 && !conn.broken {
+				read, conn.read = conn.read, 0
+			}
+			conn.lock.Unlock()
+
+			if read > 0 {
+				conn.send(connFrameWindow, binary.AppendUvarint(nil, uint64(read)))
+			}
+			return n, nil
+		}
+		if conn.overflowed {
+			conn.lock.Unlock()
+			return 0, errConnWindowExceeded
+		}
+		if conn.remoteClosed || conn.broken {
+			conn.lock.Unlock()
+			return 0, io.EOF
+		}
+		conn.lock.Unlock()
+
+		select {
+		case <-conn.notify:
+		case <-conn.deadline.read.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (conn *peerConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > connChunkSize {
+			chunk = chunk[:connChunkSize]
+		}
+		if err := conn.reserve(len(chunk)); err != nil {
+			return written, err
+		}
+		if err := conn.send(connFrameData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// reserve waits for room for size bytes in the window of the other side, and takes it.
+func (conn *peerConn) reserve(size int) error {
+	for {
+		if conn.deadline.write.exceeded() {
+			return os.ErrDeadlineExceeded
+		}
+		conn.lock.Lock()
+		closed, broken := conn.closed, conn.broken
+		room := conn.unacked+size <= connWindow
+		if room && !closed && !broken {
+			conn.unacked += size
+			if conn.unacked < connWindow {
+				// Pass the wakeup on to other writers, there may be room left.
+				signal(conn.writable)
+			}
+		}
+		conn.lock.Unlock()
+
+		if closed {
+			return net.ErrClosed
+		}
+		if broken {
+			return errors.New("peer disconnected")
+		}
+		if room {
+			return nil
+		}
+
+		select {
+		case <-conn.writable:
+		case <-conn.deadline.write.wait():
+			return os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (conn *peerConn) send(frame byte, data []byte) error {
+	packet := make([]byte, 1+len(data))
+	packet[0] = frame
+	copy(packet[1:], data)
+	return conn.peer.SendAsync(packet, conn.key.channel, PacketFlagReliable)
+}
+
+func (conn *peerConn) Close() error {
+	conn.lock.Lock()
+	if conn.closed {
+		conn.lock.Unlock()
+		return net.ErrClosed
+	}
+	conn.closed = true
+	broken := conn.broken
+	conn.lock.Unlock()
+
+	conn.host.streams.remove(conn)
+	conn.wake()
+
+	if broken {
+		return nil
+	}
+	return conn.send(connFrameClose, nil)
+}
+
+func (conn *peerConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (conn *peerConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func (conn *peerConn) SetDeadline(t time.Time) error {
+	conn.deadline.read.set(t)
+	conn.deadline.write.set(t)
+	return nil
+}
+
+func (conn *peerConn) SetReadDeadline(t time.Time) error {
+	conn.deadline.read.set(t)
+	return nil
+}
+
+func (conn *peerConn) SetWriteDeadline(t time.Time) error {
+	conn.deadline.write.set(t)
+	return nil
+}
+
+type connDeadline struct {
+	read  deadline
+	write deadline
+}
+
+func newConnDeadline() *connDeadline {
+	return &connDeadline{
+		read:  deadline{cancel: make(chan struct{})},
+		write: deadline{cancel: make(chan struct{})},
+	}
+}
+
+// deadline implements the deadline semantics of net.Conn: a channel that is closed
+// once the deadline passes, replaced whenever the deadline changes.
+type deadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer fired already, the old channel is closed.
+		d.cancel = make(chan struct{})
+	}
+	d.timer = nil
+
+	select {
+	case <-d.cancel:
+		d.cancel = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+
+	if dur := time.Until(t); dur <= 0 {
+		close(d.cancel)
+	} else {
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+	}
+}
+
+func (d *deadline) wait() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cancel
+}
+
+func (d *deadline) exceeded() bool {
+	select {
+	case <-d.wait():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,402 @@
+package enet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	connectTokenMagic = "ENETTOK\x01"
+
+	connectTokenHMAC    byte = 0
+	connectTokenEd25519 byte = 1
+
+	// connectTokenHeaderSize is the size of a token before its user data: the magic,
+	// the kind of signature, the expiry, the client ID and the length of the user data.
+	connectTokenHeaderSize = len(connectTokenMagic) + 1 + 8 + 8 + 2
+
+	// MaxConnectTokenUserData is the most user data a connect token carries
+	MaxConnectTokenUserData = 1024
+
+	// connectTokenResendInterval is how often the token of a connection attempt is
+	// sent again until the connection is established, in case it was lost.
+	connectTokenResendInterval = 250 * time.Millisecond
+
+	// connectingPeerID is the peer ID in the header of datagrams from peers that are
+	// connecting and have no peer on the host yet.
+	connectingPeerID = 0xFFF
+)
+
+// ConnectToken is what a connect token vouches for, typically issued by a matchmaker
+// or login service to the clients it sends to a server
+type ConnectToken struct {
+	ClientID uint64
+	Expires  time.Time
+	UserData []byte
+}
+
+// NewConnectToken signs token with the HMAC key shared with the servers, see
+// RequireConnectTokens. The token is opaque to the client, which connects with it
+// through ConnectWithToken.
+func NewConnectToken(token ConnectToken, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a key is required")
+	}
+	b, err := appendConnectToken(nil, connectTokenHMAC, token)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return mac.Sum(b), nil
+}
+
+// NewConnectTokenEd25519 is like NewConnectToken, signing with an Ed25519 key so the
+// servers only need its public key
+func NewConnectTokenEd25519(token ConnectToken, key ed25519.PrivateKey) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 key")
+	}
+	b, err := appendConnectToken(nil, connectTokenEd25519, token)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, ed25519.Sign(key, b)...), nil
+}
+
+func appendConnectToken(b []byte, kind byte, token ConnectToken) ([]byte, error) {
+	if len(token.UserData) > MaxConnectTokenUserData {
+		return nil, errors.New("user data is too long")
+	}
+	b = append(b, connectTokenMagic...)
+	b = append(b, kind)
+	b = binary.BigEndian.AppendUint64(b, uint64(token.Expires.UnixNano()))
+	b = binary.BigEndian.AppendUint64(b, token.ClientID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(token.UserData)))
+	return append(b, token.UserData...), nil
+}
+
+// ConnectTokenConfig holds the keys a host verifies connect tokens with. Tokens signed
+// with a kind of key that isn't set are rejected.
+type ConnectTokenConfig struct {
+	// HMACKey verifies the tokens of NewConnectToken
+	HMACKey []byte
+
+	// PublicKey verifies the tokens of NewConnectTokenEd25519
+	PublicKey ed25519.PublicKey
+}
+
+// RequireConnectTokens makes host only accept connections from clients that presented
+// a valid connect token, see ConnectWithToken. Tokens are checked when they arrive,
+// before enet sees the connection attempt, so attempts without a valid token are
+// dropped without allocating a peer. A token is bound to the address it was first
+// presented from until it expires. ConnectTokenOf returns the token a peer connected
+// with.
+//
+// Tokens are signed but not encrypted, and nothing binds them to the client they were
+// issued to. Anyone on the path who sees a token, in plaintext in the datagrams
+// ConnectWithToken sends, can present it from their own address first, connecting as
+// the client and locking the client out until the token expires. Keep expiries short,
+// and don't rely on tokens alone where the path can't be trusted: secure the
+// connection itself, or authenticate the client again once connected.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func RequireConnectTokens(host Host, config ConnectTokenConfig) error {
+	if len(config.HMACKey) == 0 && config.PublicKey == nil {
+		return errors.New("a key is required")
+	}
+	if config.PublicKey != nil && len(config.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 key")
+	}
+
+	var err error
+	require := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("connect tokens are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.tokens
+		table.lock.Lock()
+		table.config = ConnectTokenConfig{
+			HMACKey:   append([]byte(nil), config.HMACKey...),
+			PublicKey: append(ed25519.PublicKey(nil), config.PublicKey...),
+		}
+		install := table.admitted == nil
+		if install {
+			table.admitted = make(map[string]ConnectToken)
+			table.used = make(map[string]usedToken)
+			table.peers = make(map[enetPeer]ConnectToken)
+		}
+		table.lock.Unlock()
+
+		if install {
+			h.intercepts.add(h, table.intercept)
+			h.features.join(featureTokens)
+		}
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(require)
+	} else {
+		require(host)
+	}
+	return err
+}
+
+// ConnectWithToken is like Host.Connect, presenting token to a host that requires
+// connect tokens. The token is sent ahead of the connection attempt, and again until
+// the attempt succeeds or fails. It is sent in plaintext, so anyone seeing it on the
+// path can replay it from their own address, see RequireConnectTokens.
+func ConnectWithToken(host Host, addr Address, channelCount int, data uint32, token []byte) (Peer, error) {
+	if !bytes.HasPrefix(token, []byte(connectTokenMagic)) {
+		return nil, errors.New("not a connect token")
+	}
+	udpAddr := &net.UDPAddr{IP: net.ParseIP(addr.String()), Port: int(addr.GetPort())}
+	if udpAddr.IP == nil {
+		return nil, errors.New("address has no IP")
+	}
+
+	var peer Peer
+	var err error
+	connect := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("connect tokens are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		if err = h.socketSend(udpAddr, token); err != nil {
+			return
+		}
+		if peer, err = h.Connect(addr, channelCount, data); err != nil {
+			return
+		}
+		h.tokens.presenting(peer.(enetPeer), udpAddr, token)
+		h.features.join(featureTokens)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(connect)
+		if err == nil {
+			peer = safePeer{Peer: peer, host: safe}
+		}
+	} else {
+		connect(host)
+	}
+	return peer, err
+}
+
+// ConnectTokenOf returns the token peer connected with, if its host requires them
+func ConnectTokenOf(peer Peer) (ConnectToken, bool) {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return ConnectToken{}, false
+	}
+	host := p.host()
+	if host == nil {
+		return ConnectToken{}, false
+	}
+
+	table := &host.tokens
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	token, ok := table.peers[p]
+	return token, ok
+}
+
+// usedToken is the address a token was first presented from.
+type usedToken struct {
+	addr    string
+	expires time.Time
+}
+
+// presentedToken is a token presented by a connection attempt of the host.
+type presentedToken struct {
+	addr    *net.UDPAddr
+	token   []byte
+	expires time.Time
+	next    time.Time
+}
+
+// tokenTable holds the connect tokens of a host: those it verified, by the address
+// they came from, and those it presents to the hosts it connects to.
+type tokenTable struct {
+	lock     sync.Mutex
+	config   ConnectTokenConfig
+	admitted map[string]ConnectToken
+	used     map[string]usedToken
+	peers    map[enetPeer]ConnectToken
+	pruned   time.Time
+
+	presented map[enetPeer]*presentedToken
+}
+
+func (table *tokenTable) intercept(addr rawAddress, data []byte) bool {
+	if bytes.HasPrefix(data, []byte(connectTokenMagic)) {
+		table.verify(udpAddrOf(addr).String(), data)
+		return true
+	}
+	if len(data) < 2 || binary.BigEndian.Uint16(data)&connectingPeerID != connectingPeerID {
+		return false
+	}
+
+	// A connection attempt, dropped unless its token has been verified.
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	token, ok := table.admitted[udpAddrOf(addr).String()]
+	return !ok || time.Now().After(token.Expires)
+}
+
+// verify admits the address presenting data if it is a valid token.
+func (table *tokenTable) verify(addr string, data []byte) {
+	if len(data) < connectTokenHeaderSize {
+		return
+	}
+	header := data[len(connectTokenMagic):]
+	kind := header[0]
+	token := ConnectToken{
+		Expires:  time.Unix(0, int64(binary.BigEndian.Uint64(header[1:]))),
+		ClientID: binary.BigEndian.Uint64(header[9:]),
+	}
+	length := int(binary.BigEndian.Uint16(header[17:]))
+	if length > MaxConnectTokenUserData || len(data) < connectTokenHeaderSize+length {
+		return
+	}
+	signed := data[:connectTokenHeaderSize+length]
+	signature := data[len(signed):]
+	token.UserData = append([]byte(nil), signed[connectTokenHeaderSize:]...)
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	switch kind {
+	case connectTokenHMAC:
+		if len(table.config.HMACKey) == 0 {
+			return
+		}
+		mac := hmac.New(sha256.New, table.config.HMACKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return
+		}
+	case connectTokenEd25519:
+		if table.config.PublicKey == nil || !ed25519.Verify(table.config.PublicKey, signed, signature) {
+			return
+		}
+	default:
+		return
+	}
+
+	now := time.Now()
+	if now.After(token.Expires) {
+		return
+	}
+	if used, ok := table.used[string(signature)]; ok && used.addr != addr {
+		// Captured and replayed from elsewhere.
+		return
+	}
+	table.used[string(signature)] = usedToken{addr: addr, expires: token.Expires}
+	table.admitted[addr] = token
+
+	if now.Sub(table.pruned) > time.Second {
+		table.pruned = now
+		for addr, token := range table.admitted {
+			if now.After(token.Expires) {
+				delete(table.admitted, addr)
+			}
+		}
+		for signature, used := range table.used {
+			if now.After(used.expires) {
+				delete(table.used, signature)
+			}
+		}
+	}
+}
+
+// presenting remembers the token of a connection attempt of the host to send it again
+// until the attempt is over.
+func (table *tokenTable) presenting(peer enetPeer, addr *net.UDPAddr, token []byte) {
+	expires := time.Now().Add(time.Minute)
+	if len(token) >= connectTokenHeaderSize {
+		expires = time.Unix(0, int64(binary.BigEndian.Uint64(token[len(connectTokenMagic)+1:])))
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if table.presented == nil {
+		table.presented = make(map[enetPeer]*presentedToken)
+	}
+	table.presented[peer] = &presentedToken{
+		addr:    addr,
+		token:   append([]byte(nil), token...),
+		expires: expires,
+		next:    time.Now().Add(connectTokenResendInterval),
+	}
+}
+
+// resend sends the tokens of the connection attempts of host again when due.
+func (table *tokenTable) resend(host *enetHost) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if len(table.presented) == 0 {
+		return
+	}
+	now := time.Now()
+	for peer, presented := range table.presented {
+		if now.After(presented.expires) {
+			delete(table.presented, peer)
+			continue
+		}
+		if now.Before(presented.next) {
+			continue
+		}
+		presented.next = now.Add(connectTokenResendInterval)
+		// Lost like any datagram if it can't be sent, the next one may be luckier.
+		host.socketSend(presented.addr, presented.token)
+	}
+}
+
+// observe tracks the tokens of the peers of the host as they connect and disconnect.
+func (table *tokenTable) observe(event *enetEvent) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if table.admitted == nil && len(table.presented) == 0 {
+		return
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return
+	}
+
+	switch event.GetType() {
+	case EventConnect:
+		delete(table.presented, peer)
+		if token, ok := table.admitted[udpAddrOf(peer.address()).String()]; ok {
+			table.peers[peer] = token
+		}
+	case EventDisconnect, EventDisconnectTimeout:
+		delete(table.presented, peer)
+		delete(table.peers, peer)
+	}
+}
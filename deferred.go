@@ -0,0 +1,143 @@
+package enet
+
+import "errors"
+
+// deferredSend is a packet sent to a peer of a host deferring its sends, waiting to be
+// handed to enet.
+type deferredSend struct {
+	peer    enetPeer
+	channel uint8
+	packet  rawPacket
+
+	// sent is set once enet took the packet
+	sent bool
+}
+
+// deferredQueue holds the sends of a host deferring them, see DeferSends.
+type deferredQueue struct {
+	enabled bool
+	sends   []deferredSend
+}
+
+// DeferSends makes the Send methods of the peers of host, SendBytes, SendString and
+// SendPacket, queue their packets instead of handing them to enet one by one. The
+// packets queued are submitted all at once, in a single call into C when built with
+// cgo, the next time the host is serviced or as FlushSends is called, so servers
+// sending many packets per tick save the cost of a call per send. enet packs the
+// commands of the packets submitted together into as few datagrams as it can.
+//
+// Deferred sends only fail if the packet was already sent or destroyed. Packets enet
+// refuses once submitted, for peers gone in the meantime or channels they don't have,
+// are dropped and logged, as with SendAsync. Disabling deferral submits the packets
+// still queued.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func DeferSends(host Host, enabled bool) error {
+	var err error
+	deferSends := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("deferred sends are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		if !enabled {
+			h.submitDeferred(false)
+		}
+		h.deferred.enabled = enabled
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(deferSends)
+	} else {
+		deferSends(host)
+	}
+	return err
+}
+
+// FlushSends submits the packets queued on a host deferring its sends, see DeferSends,
+// and flushes the host, sending them out without waiting for it to be serviced. Call
+// it at the end of each tick of a loop that doesn't service the host right after.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func FlushSends(host Host) error {
+	var err error
+	flush := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("deferred sends are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		h.submitDeferred(true)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(flush)
+	} else {
+		flush(host)
+	}
+	return err
+}
+
+// deferSend queues packet for peer if its host defers its sends. Returns false if it
+// doesn't, leaving the packet to be sent right away.
+func deferSend(peer enetPeer, packet Packet, channel uint8) (bool, error) {
+	host := peer.host()
+	if host == nil || !host.deferred.enabled {
+		return false, nil
+	}
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return true, err
+	}
+	raw, err := p.handOver()
+	if err != nil {
+		return true, err
+	}
+	host.deferred.sends = append(host.deferred.sends, deferredSend{
+		peer:    peer,
+		channel: channel,
+		packet:  raw,
+	})
+	return true, nil
+}
+
+// submitDeferred hands the packets queued to enet, flushing the host if flush is set.
+func (host *enetHost) submitDeferred(flush bool) {
+	sends := host.deferred.sends
+	if len(sends) == 0 && !flush {
+		return
+	}
+	// Dumping reads packets enet may free as soon as it flushes them, so flush after.
+	dumping := packetDumpers.Load() != 0
+	host.submitSends(sends, flush && !dumping)
+	for _, send := range sends {
+		if send.sent {
+			dumpSent(send.peer, send.channel, send.packet)
+			continue
+		}
+		// Peer went away in the meantime, the packet is still ours to free.
+		destroyRaw(send.packet)
+		logSendError(send.peer, send.channel, errors.New("unable to send deferred packet"))
+	}
+	if flush && dumping {
+		host.submitSends(nil, true)
+	}
+	clear(sends)
+	host.deferred.sends = sends[:0]
+}
+
+// discard frees all queued packets without sending them.
+func (q *deferredQueue) discard() {
+	for _, send := range q.sends {
+		destroyRaw(send.packet)
+	}
+	q.sends = nil
+}
@@ -0,0 +1,254 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OpcodeFormat describes how a message opcode is encoded at the start of a packet payload
+type OpcodeFormat int
+
+const (
+	// OpcodeByte means the first byte of the payload is the opcode
+	OpcodeByte OpcodeFormat = iota
+
+	// OpcodeUvarint means the payload starts with an unsigned varint opcode, as written
+	// by binary.AppendUvarint
+	OpcodeUvarint
+)
+
+// ErrDispatcherClosed is returned by Dispatch once the dispatcher is closed
+var ErrDispatcherClosed = errors.New("dispatcher is closed")
+
+// Message is a received packet decoded by a Dispatcher
+type Message struct {
+	Peer      Peer
+	ChannelID uint8
+	Opcode    uint64
+	Timestamp time.Time
+
+	// Payload is the packet data following the opcode. It is a copy and may be
+	// retained by the handler.
+	Payload []byte
+}
+
+// MessageHandler handles a single decoded message
+type MessageHandler func(msg *Message)
+
+// Dispatcher routes received packets to handlers registered per message opcode
+type Dispatcher interface {
+	// Handle registers the handler for an opcode, replacing any previous one.
+	// Passing a nil handler removes the registration.
+	Handle(opcode uint64, handler MessageHandler)
+
+	// HandleDefault registers the handler used for opcodes without a handler of their own
+	HandleDefault(handler MessageHandler)
+
+	// OnPanic makes the dispatcher recover panics in handlers and report them to the
+	// given handler instead of crashing. When running handlers on workers, the panic
+	// handler is called from the worker goroutine.
+	OnPanic(handler PanicHandler)
+
+	// Dispatch decodes a receive event and routes it to its handler. Events other than
+	// EventReceive are ignored. The event packet is not destroyed, this is still up to
+	// the caller and can be done as soon as Dispatch returns.
+	Dispatch(event Event) error
+
+	// Close waits for queued messages to be handled and stops the workers. Dispatch
+	// fails with ErrDispatcherClosed from then on. Closing again does nothing.
+	Close()
+}
+
+type enetDispatcher struct {
+	format OpcodeFormat
+
+	lock           sync.RWMutex
+	handlers       map[uint64]MessageHandler
+	defaultHandler MessageHandler
+	panicHandler   PanicHandler
+
+	// closeLock guards closed and the queue being closed. It is apart from lock, which
+	// workers take, so Dispatch can hold it while waiting for room in the queue.
+	closeLock sync.RWMutex
+	closed    bool
+	queue     chan *Message
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher decoding opcodes in the given format. If workers
+// is greater than 0, handlers are run on a pool of that many goroutines instead of
+// on the goroutine calling Dispatch, in which case no ordering between messages is
+// guaranteed.
+func NewDispatcher(format OpcodeFormat, workers int) Dispatcher {
+	d := &enetDispatcher{
+		format:   format,
+		handlers: make(map[uint64]MessageHandler),
+	}
+
+	if workers > 0 {
+		d.queue = make(chan *Message, workers*64)
+		d.wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go d.work()
+		}
+	}
+
+	return d
+}
+
+func (d *enetDispatcher) Handle(opcode uint64, handler MessageHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if handler == nil {
+		delete(d.handlers, opcode)
+		return
+	}
+	d.handlers[opcode] = handler
+}
+
+func (d *enetDispatcher) HandleDefault(handler MessageHandler) {
+	d.lock.Lock()
+	d.defaultHandler = handler
+	d.lock.Unlock()
+}
+
+func (d *enetDispatcher) OnPanic(handler PanicHandler) {
+	d.lock.Lock()
+	d.panicHandler = handler
+	d.lock.Unlock()
+}
+
+func (d *enetDispatcher) Dispatch(event Event) error {
+	if event.GetType() != EventReceive {
+		return nil
+	}
+
+	opcode, n, err := DecodeOpcode(d.format, event.GetPacketDataUnsafe())
+	if err != nil {
+		countDispatchError(event)
+		return err
+	}
+
+	handler := d.handler(opcode)
+	if handler == nil {
+		countDispatchError(event)
+		return fmt.Errorf("no handler for opcode %d", opcode)
+	}
+
+	msg := &Message{
+		Peer:      event.GetPeer(),
+		ChannelID: event.GetChannelID(),
+		Opcode:    opcode,
+		Timestamp: event.GetTimestamp(),
+		Payload:   append([]byte(nil), event.GetPacketDataUnsafe()[n:]...),
+	}
+
+	d.closeLock.RLock()
+	if d.closed {
+		d.closeLock.RUnlock()
+		return ErrDispatcherClosed
+	}
+	if d.queue != nil {
+		d.queue <- msg
+		d.closeLock.RUnlock()
+		return nil
+	}
+	d.closeLock.RUnlock()
+
+	d.call(handler, msg)
+	return nil
+}
+
+// countDispatchError counts a receive event that failed to be dispatched in the stats
+// of its host.
+func countDispatchError(event Event) {
+	if stats := eventStats(event); stats != nil {
+		stats.countDispatchError(event.GetChannelID())
+	}
+}
+
+func (d *enetDispatcher) Close() {
+	d.closeLock.Lock()
+	if d.closed {
+		d.closeLock.Unlock()
+		return
+	}
+	d.closed = true
+	if d.queue != nil {
+		close(d.queue)
+	}
+	d.closeLock.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *enetDispatcher) handler(opcode uint64) MessageHandler {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if handler, ok := d.handlers[opcode]; ok {
+		return handler
+	}
+	return d.defaultHandler
+}
+
+func (d *enetDispatcher) call(handler MessageHandler, msg *Message) {
+	d.lock.RLock()
+	panicHandler := d.panicHandler
+	d.lock.RUnlock()
+
+	if panicHandler == nil {
+		handler(msg)
+		return
+	}
+
+	if err := callRecover(msg.Peer, func() { handler(msg) }); err != nil {
+		panicHandler(err)
+	}
+}
+
+func (d *enetDispatcher) work() {
+	defer d.wg.Done()
+
+	for msg := range d.queue {
+		// Look the handler up again, it might have been changed while queued.
+		if handler := d.handler(msg.Opcode); handler != nil {
+			d.call(handler, msg)
+		}
+	}
+}
+
+// DecodeOpcode reads the opcode at the start of data, returning it together with the
+// number of bytes it occupied.
+func DecodeOpcode(format OpcodeFormat, data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("message is empty")
+	}
+
+	switch format {
+	case OpcodeByte:
+		return uint64(data[0]), 1, nil
+
+	case OpcodeUvarint:
+		opcode, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, 0, errors.New("malformed message opcode")
+		}
+		return opcode, n, nil
+	}
+
+	return 0, 0, fmt.Errorf("unknown opcode format %d", format)
+}
+
+// AppendOpcode appends the opcode encoded in the given format to buf. The message
+// payload is expected to be appended after it.
+func AppendOpcode(buf []byte, format OpcodeFormat, opcode uint64) []byte {
+	if format == OpcodeUvarint {
+		return binary.AppendUvarint(buf, opcode)
+	}
+	return append(buf, byte(opcode))
+}
@@ -1,2 +1,4 @@
+//go:build !purego && !(js && wasm)
+
 #define ENET_IMPLEMENTATION
 #include "enet.h"
@@ -263,7 +263,9 @@ extern "C" {
 		ENET_PROTOCOL_HEADER_FLAG_SENT_TIME    = (1 << 14),
 		ENET_PROTOCOL_HEADER_FLAG_MASK         = ENET_PROTOCOL_HEADER_FLAG_SENT_TIME,
 		ENET_PROTOCOL_HEADER_SESSION_MASK      = (3 << 12),
-		ENET_PROTOCOL_HEADER_SESSION_SHIFT     = 12
+		ENET_PROTOCOL_HEADER_SESSION_SHIFT     = 12,
+		ENET_STOCK_PROTOCOL_HEADER_FLAG_COMPRESSED = (1 << 14),
+		ENET_STOCK_PROTOCOL_HEADER_FLAG_SENT_TIME  = (1 << 15)
 	} ENetProtocolFlag;
 
 	#ifdef _MSC_VER
@@ -631,6 +633,21 @@ extern "C" {
 
 	typedef int (ENET_CALLBACK *ENetInterceptCallback)(ENetEvent* event, ENetAddress* address, uint8_t* receivedData, int receivedDataLength);
 
+	struct _ENetHost;
+
+	/* Replaces enet_time_get as the clock of a host */
+	typedef uint32_t (ENET_CALLBACK *ENetTimeCallback)(struct _ENetHost* host);
+
+	/* Offered every datagram a host sends, consumes it by returning 1 */
+	typedef int (ENET_CALLBACK *ENetSendCallback)(struct _ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount);
+
+	/* Replaces the socket of a host, with the semantics of the socket functions */
+	typedef struct _ENetTransport {
+		int (ENET_CALLBACK *send)(struct _ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount);
+		int (ENET_CALLBACK *receive)(struct _ENetHost* host, ENetAddress* address, ENetBuffer* buffers, size_t bufferCount);
+		int (ENET_CALLBACK *wait)(struct _ENetHost* host, uint32_t* condition, uint64_t timeout);
+	} ENetTransport;
+
 	typedef struct _ENetHost {
 		ENetSocket socket;
 		ENetAddress address;
@@ -658,11 +675,15 @@ extern "C" {
 		ENetBuffer buffers[ENET_BUFFER_MAXIMUM];
 		size_t bufferCount;
 		ENetChecksumCallback checksumCallback;
+		uint8_t stockProtocol;
 		uint8_t packetData[2][ENET_PROTOCOL_MAXIMUM_MTU];
 		ENetAddress receivedAddress;
 		uint8_t* receivedData;
 		size_t receivedDataLength;
 		ENetInterceptCallback interceptCallback;
+		const ENetTransport* transport;
+		ENetTimeCallback timeCallback;
+		ENetSendCallback sendCallback;
 		size_t connectedPeers;
 		size_t bandwidthLimitedPeers;
 		size_t duplicatePeers;
@@ -685,6 +706,7 @@ extern "C" {
 	ENET_API int enet_array_is_zeroed(const uint8_t*, int);
 	ENET_API uint32_t enet_time_get(void);
 	ENET_API uint64_t enet_crc64(const ENetBuffer*, int);
+	ENET_API uint64_t enet_crc32(const ENetBuffer*, int);
 
 	ENET_API ENetPacket* enet_packet_create(const void*, size_t, uint32_t);
 	ENET_API ENetPacket* enet_packet_create_offset(const void*, size_t, size_t, uint32_t);
@@ -751,6 +773,13 @@ extern "C" {
 	ENET_API void enet_host_set_max_duplicate_peers(ENetHost*, uint16_t);
 	ENET_API void enet_host_set_intercept_callback(ENetHost*, ENetInterceptCallback);
 	ENET_API void enet_host_set_checksum_callback(ENetHost*, ENetChecksumCallback);
+	ENET_API void enet_host_set_transport(ENetHost*, const ENetTransport*, const ENetAddress*);
+	ENET_API void enet_host_set_socket(ENetHost*, ENetSocket);
+	ENET_API void enet_host_set_time_callback(ENetHost*, ENetTimeCallback);
+	ENET_API void enet_host_set_send_callback(ENetHost*, ENetSendCallback);
+	ENET_API uint32_t enet_host_time(ENetHost*);
+	ENET_API int enet_host_socket_send(ENetHost*, const ENetAddress*, const ENetBuffer*, size_t);
+	ENET_API int enet_host_raw_send(ENetHost*, const ENetAddress*, const ENetBuffer*, size_t);
 
 	ENET_API uint32_t enet_peer_get_id(const ENetPeer*);
 	ENET_API int enet_peer_get_ip(const ENetPeer*, char*, size_t);
@@ -1397,6 +1426,33 @@ extern "C" {
 		return ENET_HOST_TO_NET_64(~crc);
 	}
 
+	/* The checksum of stock ENet, a CRC-32 in network byte order */
+	uint64_t enet_crc32(const ENetBuffer* buffers, int bufferCount) {
+		uint32_t crc = 0xFFFFFFFF;
+		int bit;
+
+		while (bufferCount-- > 0) {
+			const uint8_t* data = (const uint8_t*)buffers->data;
+			const uint8_t* dataEnd = &data[buffers->dataLength];
+
+			while (data < dataEnd) {
+				crc ^= *data++;
+
+				for (bit = 0; bit < 8; bit++)
+					crc = (crc >> 1) ^ (0xEDB88320 & (0 - (crc & 1)));
+			}
+
+			buffers++;
+		}
+
+		return ENET_HOST_TO_NET_32(~crc);
+	}
+
+	/* Stock ENet sends 32-bit checksums instead of 64-bit ones */
+	static size_t enet_host_checksum_size(ENetHost* host) {
+		return host->stockProtocol ? sizeof(uint32_t) : sizeof(enet_checksum);
+	}
+
 /*
 =======================================================================
 
@@ -2353,13 +2409,22 @@ extern "C" {
 
 		header = (ENetProtocolHeader*)host->receivedData;
 		peerID = ENET_NET_TO_HOST_16(header->peerID);
+
+		if (host->stockProtocol) {
+			if (peerID & ENET_STOCK_PROTOCOL_HEADER_FLAG_COMPRESSED)
+				return 0;
+
+			if (peerID & ENET_STOCK_PROTOCOL_HEADER_FLAG_SENT_TIME)
+				peerID = (peerID & ~ENET_STOCK_PROTOCOL_HEADER_FLAG_SENT_TIME) | ENET_PROTOCOL_HEADER_FLAG_SENT_TIME;
+		}
+
 		sessionID = (peerID & ENET_PROTOCOL_HEADER_SESSION_MASK) >> ENET_PROTOCOL_HEADER_SESSION_SHIFT;
 		flags = peerID & ENET_PROTOCOL_HEADER_FLAG_MASK;
 		peerID &= ~(ENET_PROTOCOL_HEADER_FLAG_MASK | ENET_PROTOCOL_HEADER_SESSION_MASK);
 		headerSize = (flags & ENET_PROTOCOL_HEADER_FLAG_SENT_TIME ? sizeof(ENetProtocolHeader) : (size_t)&((ENetProtocolHeader*)0)->sentTime);
 
 		if (host->checksumCallback != NULL)
-			headerSize += sizeof(enet_checksum);
+			headerSize += enet_host_checksum_size(host);
 
 		if (peerID == ENET_PROTOCOL_MAXIMUM_PEER_ID) {
 			peer = NULL;
@@ -2372,7 +2437,17 @@ extern "C" {
 				return 0;
 		}
 
-		if (host->checksumCallback != NULL) {
+		if (host->checksumCallback != NULL && host->stockProtocol) {
+			uint32_t* checksum = (uint32_t*)&host->receivedData[headerSize - sizeof(uint32_t)];
+			uint32_t desiredChecksum = *checksum;
+			ENetBuffer buffer;
+			*checksum = peer != NULL ? peer->connectID : 0;
+			buffer.data = host->receivedData;
+			buffer.dataLength = host->receivedDataLength;
+
+			if ((uint32_t)host->checksumCallback(&buffer, 1) != desiredChecksum)
+				return 0;
+		} else if (host->checksumCallback != NULL) {
 			enet_checksum* checksum = (enet_checksum*)&host->receivedData[headerSize - sizeof(enet_checksum)];
 			enet_checksum desiredChecksum = *checksum;
 			ENetBuffer buffer;
@@ -2547,7 +2622,10 @@ extern "C" {
 			ENetBuffer buffer;
 			buffer.data = host->packetData[0];
 			buffer.dataLength = host->mtu;
-			receivedLength = enet_socket_receive(host->socket, &host->receivedAddress, &buffer, 1);
+			if (host->transport != NULL)
+				receivedLength = host->transport->receive(host, &host->receivedAddress, &buffer, 1);
+			else
+				receivedLength = enet_socket_receive(host->socket, &host->receivedAddress, &buffer, 1);
 
 			if (receivedLength == -2)
 				continue;
@@ -2840,7 +2918,7 @@ extern "C" {
 				host->packetSize = sizeof(ENetProtocolHeader);
 
 				if (host->checksumCallback != NULL)
-					host->packetSize += sizeof(enet_checksum);
+					host->packetSize += enet_host_checksum_size(host);
 
 				if (!enet_list_empty(&currentPeer->acknowledgements))
 					enet_protocol_send_acknowledgements(host, currentPeer);
@@ -2872,9 +2950,17 @@ extern "C" {
 				if (currentPeer->outgoingPeerID < ENET_PROTOCOL_MAXIMUM_PEER_ID)
 					host->headerFlags |= currentPeer->outgoingSessionID << ENET_PROTOCOL_HEADER_SESSION_SHIFT;
 
+				if (host->stockProtocol && (host->headerFlags & ENET_PROTOCOL_HEADER_FLAG_SENT_TIME))
+					host->headerFlags = (host->headerFlags & ~ENET_PROTOCOL_HEADER_FLAG_SENT_TIME) | ENET_STOCK_PROTOCOL_HEADER_FLAG_SENT_TIME;
+
 				header->peerID = ENET_HOST_TO_NET_16(currentPeer->outgoingPeerID | host->headerFlags);
 
-				if (host->checksumCallback != NULL) {
+				if (host->checksumCallback != NULL && host->stockProtocol) {
+					uint32_t* checksum = (uint32_t*)&headerData[host->buffers->dataLength];
+					*checksum = currentPeer->outgoingPeerID < ENET_PROTOCOL_MAXIMUM_PEER_ID ? currentPeer->connectID : 0;
+					host->buffers->dataLength += sizeof(uint32_t);
+					*checksum = (uint32_t)host->checksumCallback(host->buffers, host->bufferCount);
+				} else if (host->checksumCallback != NULL) {
 					enet_checksum* checksum = (enet_checksum*)&headerData[host->buffers->dataLength];
 					*checksum = currentPeer->outgoingPeerID < ENET_PROTOCOL_MAXIMUM_PEER_ID ? currentPeer->connectID : 0;
 					host->buffers->dataLength += sizeof(enet_checksum);
@@ -2882,7 +2968,7 @@ extern "C" {
 				}
 
 				currentPeer->lastSendTime = host->serviceTime;
-				sentLength = enet_socket_send(host->socket, &currentPeer->address, host->buffers, host->bufferCount);
+				sentLength = enet_host_socket_send(host, &currentPeer->address, host->buffers, host->bufferCount);
 
 				enet_protocol_remove_sent_unreliable_commands(currentPeer);
 
@@ -2899,7 +2985,7 @@ extern "C" {
 	}
 
 	void enet_host_flush(ENetHost* host) {
-		host->serviceTime = enet_time_get();
+		host->serviceTime = enet_host_time(host);
 
 		enet_protocol_send_outgoing_commands(host, NULL, 0);
 	}
@@ -2939,7 +3025,7 @@ extern "C" {
 			}
 		}
 
-		host->serviceTime = enet_time_get();
+		host->serviceTime = enet_host_time(host);
 		timeout += host->serviceTime;
 
 		do {
@@ -3012,20 +3098,24 @@ extern "C" {
 				return 0;
 
 			do {
-				host->serviceTime = enet_time_get();
+				host->serviceTime = enet_host_time(host);
 
 				if (ENET_TIME_GREATER_EQUAL(host->serviceTime, timeout))
 					return 0;
 
 				waitCondition = ENET_SOCKET_WAIT_RECEIVE | ENET_SOCKET_WAIT_INTERRUPT;
 
-				if (enet_socket_wait(host->socket, &waitCondition, ENET_TIME_DIFFERENCE(timeout, host->serviceTime)) != 0)
+				if (host->transport != NULL) {
+					if (host->transport->wait(host, &waitCondition, ENET_TIME_DIFFERENCE(timeout, host->serviceTime)) != 0)
+						return -1;
+				} else if (enet_socket_wait(host->socket, &waitCondition, ENET_TIME_DIFFERENCE(timeout, host->serviceTime)) != 0) {
 					return -1;
+				}
 			}
 
 			while (waitCondition & ENET_SOCKET_WAIT_INTERRUPT);
 
-			host->serviceTime = enet_time_get();
+			host->serviceTime = enet_host_time(host);
 		}
 
 		while (waitCondition & ENET_SOCKET_WAIT_RECEIVE);
@@ -3090,7 +3180,7 @@ extern "C" {
 		fragmentLength = peer->mtu - sizeof(ENetProtocolHeader) - sizeof(ENetProtocolSendFragment) - sizeof(ENetProtocolAcknowledge);
 
 		if (peer->host->checksumCallback != NULL)
-			fragmentLength -= sizeof(enet_checksum);
+			fragmentLength -= enet_host_checksum_size(peer->host);
 
 		if (packet->dataLength > fragmentLength) {
 			uint32_t fragmentCount = (packet->dataLength + fragmentLength - 1) / fragmentLength, fragmentNumber, fragmentOffset;
@@ -3876,6 +3966,7 @@ extern "C" {
 		host->commandCount = 0;
 		host->bufferCount = 0;
 		host->checksumCallback = NULL;
+		host->stockProtocol = 0;
 		host->receivedAddress.ipv6 = ENET_HOST_ANY;
 		host->receivedAddress.port = 0;
 		host->receivedData = NULL;
@@ -3890,6 +3981,9 @@ extern "C" {
 		host->maximumPacketSize = ENET_HOST_DEFAULT_MAXIMUM_PACKET_SIZE;
 		host->maximumWaitingData = ENET_HOST_DEFAULT_MAXIMUM_WAITING_DATA;
 		host->interceptCallback = NULL;
+		host->transport = NULL;
+		host->timeCallback = NULL;
+		host->sendCallback = NULL;
 
 		enet_list_clear(&host->dispatchQueue);
 
@@ -4088,7 +4182,7 @@ extern "C" {
 	}
 
 	void enet_host_bandwidth_throttle(ENetHost* host) {
-		uint32_t timeCurrent = enet_time_get();
+		uint32_t timeCurrent = enet_host_time(host);
 		uint32_t elapsedTime = timeCurrent - host->bandwidthThrottleEpoch;
 		uint32_t peersRemaining = (uint32_t)host->connectedPeers;
 		uint32_t dataTotal = ~0;
@@ -5025,6 +5119,62 @@ extern "C" {
 		host->checksumCallback = callback;
 	}
 
+	/* Closes the socket of the host, which sends and receives through transport from now on */
+	void enet_host_set_transport(ENetHost* host, const ENetTransport* transport, const ENetAddress* address) {
+		enet_socket_destroy(host->socket);
+		host->socket = ENET_SOCKET_NULL;
+		host->transport = transport;
+
+		if (address != NULL)
+			host->address = *address;
+	}
+
+	/* Closes the socket of the host, which sends and receives through socket from now on */
+	void enet_host_set_socket(ENetHost* host, ENetSocket socket) {
+		enet_socket_destroy(host->socket);
+		host->socket = socket;
+
+		if (enet_socket_get_address(socket, &host->address) < 0)
+			memset(&host->address, 0, sizeof(host->address));
+	}
+
+	void enet_host_set_time_callback(ENetHost* host, ENetTimeCallback callback) {
+		host->timeCallback = callback;
+	}
+
+	uint32_t enet_host_time(ENetHost* host) {
+		if (host->timeCallback != NULL)
+			return host->timeCallback(host);
+
+		return enet_time_get();
+	}
+
+	void enet_host_set_send_callback(ENetHost* host, ENetSendCallback callback) {
+		host->sendCallback = callback;
+	}
+
+	int enet_host_socket_send(ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount) {
+		if (host->sendCallback != NULL && host->sendCallback(host, address, buffers, bufferCount) == 1) {
+			size_t i;
+			int length = 0;
+
+			for (i = 0; i < bufferCount; ++i)
+				length += (int)buffers[i].dataLength;
+
+			return length;
+		}
+
+		return enet_host_raw_send(host, address, buffers, bufferCount);
+	}
+
+	/* Sends a datagram without offering it to the send callback */
+	int enet_host_raw_send(ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount) {
+		if (host->transport != NULL)
+			return host->transport->send(host, address, buffers, bufferCount);
+
+		return enet_socket_send(host->socket, address, buffers, bufferCount);
+	}
+
 	uint32_t enet_peer_get_id(const ENetPeer* peer) {
 		return peer->incomingPeerID;
 	}
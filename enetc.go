@@ -1,3 +1,5 @@
+//go:build !purego && !(js && wasm)
+
 package enet
 
 // #include "enet.h"
@@ -0,0 +1,15 @@
+//go:build js && wasm
+
+package enet
+
+// Initialize enet. The browser implementation needs no initialization.
+func Initialize() {}
+
+// Deinitialize enet
+func Deinitialize() {}
+
+// LinkedVersion returns the version of the enet library the bridges serving browser
+// clients are built with. Returns MAJOR.MINOR.PATCH as a string.
+func LinkedVersion() string {
+	return "2.4.8"
+}
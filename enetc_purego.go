@@ -0,0 +1,15 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+// Initialize enet. The pure Go implementation needs no initialization.
+func Initialize() {}
+
+// Deinitialize enet
+func Deinitialize() {}
+
+// LinkedVersion returns the version of the enet protocol implemented by the pure Go
+// implementation, that of the bundled C library. Returns MAJOR.MINOR.PATCH as a string.
+func LinkedVersion() string {
+	return "2.4.8"
+}
@@ -0,0 +1,49 @@
+// Package enetcbor implements enet.Codec with CBOR, for integrating with CBOR based
+// tooling and embedded clients. Struct fields are named by their cbor tag, falling back
+// to their json tag and then to the field name.
+//
+//	enet.SendValue(peer, 0, enet.PacketFlagReliable, enet.OpcodeUvarint, opReading, enetcbor.Codec{}, reading)
+package enetcbor
+
+import "github.com/fxamacker/cbor/v2"
+
+var (
+	defaultMode       cbor.EncMode
+	deterministicMode cbor.EncMode
+	strictMode        cbor.DecMode
+)
+
+func init() {
+	var err error
+	if defaultMode, err = (cbor.EncOptions{}).EncMode(); err != nil {
+		panic(err)
+	}
+	if deterministicMode, err = cbor.CoreDetEncOptions().EncMode(); err != nil {
+		panic(err)
+	}
+	if strictMode, err = (cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF}).DecMode(); err != nil {
+		panic(err)
+	}
+}
+
+// Codec is a CBOR enet.Codec
+type Codec struct {
+	// Deterministic encodes with the core deterministic encoding of RFC 8949, so equal
+	// values always encode to the same bytes, for example to hash or sign them. It
+	// also rejects maps with duplicate keys when decoding.
+	Deterministic bool
+}
+
+func (c Codec) Marshal(v any) ([]byte, error) {
+	if c.Deterministic {
+		return deterministicMode.Marshal(v)
+	}
+	return defaultMode.Marshal(v)
+}
+
+func (c Codec) Unmarshal(data []byte, v any) error {
+	if c.Deterministic {
+		return strictMode.Unmarshal(data, v)
+	}
+	return cbor.Unmarshal(data, v)
+}
@@ -0,0 +1,400 @@
+// Package enetdtls secures the datagrams of enet hosts with DTLS, for deployments that
+// require standards-based encryption and certificate authentication. A Transport runs
+// a DTLS session per remote address on a single UDP socket, underneath the enet
+// protocol, and is plugged in with enet.NewTransportHost or enet.SetTransportFactory:
+//
+//	transport, err := enetdtls.Listen("udp", ":1234", enetdtls.Config{
+//		Server: &dtls.Config{Certificates: []tls.Certificate{cert}},
+//	})
+//	host, err := enet.NewTransportHost(transport, 32, 2, 0, 0)
+//
+// Sessions are DTLS 1.2, which is what pion/dtls implements. Sending to an address
+// without a session starts the handshake in the background and drops the datagram,
+// which enet retransmits, so hosts are never blocked by handshakes. Both sides must
+// use a Transport, and the overhead of DTLS records adds to the MTU of the hosts.
+package enetdtls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/pion/dtls/v3"
+	"github.com/pion/transport/v3/deadline"
+)
+
+const (
+	// maxRecordSize is the largest plaintext a DTLS record carries.
+	maxRecordSize = 16 * 1024
+
+	// queueSize bounds the datagrams received but not yet read, per session and for
+	// the transport, beyond which they are dropped like a full socket buffer would.
+	queueSize = 256
+
+	// contentTypeHandshake starts the records of handshakes.
+	contentTypeHandshake = 22
+)
+
+// Config configures a Transport
+type Config struct {
+	// Server configures the sessions peers start, with the certificates of the host
+	// and how clients are authenticated. Peers can't connect to the host if nil.
+	Server *dtls.Config
+
+	// Client configures the sessions the host starts when connecting to peers, with
+	// the roots and server name their certificates are verified against. The host
+	// can't connect to peers if nil.
+	Client *dtls.Config
+
+	// HandshakeTimeout bounds how long handshakes may take. Defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+
+	// IdleTimeout closes sessions that received nothing for that long, which covers
+	// peers that disconnected or restarted. It must exceed the ping interval of the
+	// peers. Defaults to 15 seconds.
+	IdleTimeout time.Duration
+}
+
+// Transport is an enet.Transport encrypting the datagrams of a host with DTLS
+type Transport struct {
+	conn   net.PacketConn
+	config Config
+
+	lock     sync.Mutex
+	sessions map[netip.AddrPort]*session
+
+	received  chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type datagram struct {
+	addr net.Addr
+	data []byte
+}
+
+// Listen creates a transport on a UDP socket listening on address, see net.ListenPacket
+func Listen(network, address string, config Config) (*Transport, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, config)
+}
+
+// New creates a transport on conn, which it owns from then on
+func New(conn net.PacketConn, config Config) (*Transport, error) {
+	if config.Server == nil && config.Client == nil {
+		return nil, errors.New("neither server nor client configured")
+	}
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = 10 * time.Second
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 15 * time.Second
+	}
+
+	t := &Transport{
+		conn:     conn,
+		config:   config,
+		sessions: make(map[netip.AddrPort]*session),
+		received: make(chan datagram, queueSize),
+		closed:   make(chan struct{}),
+	}
+	go t.read()
+	return t, nil
+}
+
+// Factory returns a transport factory creating transports on UDP sockets bound to the
+// address of each host, see enet.SetTransportFactory
+func Factory(config Config) enet.TransportFactory {
+	return func(addr enet.Address) (enet.Transport, error) {
+		address := ":0"
+		if addr != nil {
+			address = net.JoinHostPort(addr.String(), strconv.Itoa(int(addr.GetPort())))
+		}
+		return Listen("udp", address, config)
+	}
+}
+
+// ReadFrom implements enet.Transport, returning the decrypted datagrams of all sessions
+func (t *Transport) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case dgram := <-t.received:
+		return copy(p, dgram.data), dgram.addr, nil
+	case <-t.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteTo implements enet.Transport, encrypting p for the session of addr. Without an
+// established session the datagram is dropped, starting the handshake if there's none.
+func (t *Transport) WriteTo(p []byte, addr net.Addr) (int, error) {
+	key, ok := keyOf(addr)
+	if !ok {
+		return 0, errors.New("not an IP address")
+	}
+
+	t.lock.Lock()
+	s, ok := t.sessions[key]
+	if !ok && t.config.Client != nil {
+		s = t.open(key, addr, false)
+	}
+	t.lock.Unlock()
+
+	if s == nil || !s.established() {
+		return len(p), nil
+	}
+	return s.conn.Write(p)
+}
+
+// LocalAddr implements enet.Transport
+func (t *Transport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+// Close implements enet.Transport, closing all sessions and the socket
+func (t *Transport) Close() error {
+	err := net.ErrClosed
+	t.closeOnce.Do(func() {
+		close(t.closed)
+
+		t.lock.Lock()
+		sessions := make([]*session, 0, len(t.sessions))
+		for _, s := range t.sessions {
+			sessions = append(sessions, s)
+		}
+		t.lock.Unlock()
+
+		// Let peers know before the socket goes.
+		for _, s := range sessions {
+			s.close()
+		}
+		err = t.conn.Close()
+	})
+	return err
+}
+
+// ConnectionState returns the state of the established session with addr, such as the
+// certificates the peer presented, typically for the address of an enet peer
+func (t *Transport) ConnectionState(addr enet.Address) (dtls.State, bool) {
+	ip, err := netip.ParseAddr(addr.String())
+	if err != nil {
+		return dtls.State{}, false
+	}
+
+	t.lock.Lock()
+	s, ok := t.sessions[netip.AddrPortFrom(ip.Unmap(), addr.GetPort())]
+	t.lock.Unlock()
+	if !ok || !s.established() {
+		return dtls.State{}, false
+	}
+	return s.conn.ConnectionState()
+}
+
+// read routes the datagrams arriving on the socket to their sessions, starting
+// sessions for handshakes from unknown addresses.
+func (t *Transport) read() {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := t.conn.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				t.Close()
+				return
+			}
+			continue
+		}
+		key, ok := keyOf(addr)
+		if !ok || n == 0 {
+			continue
+		}
+
+		t.lock.Lock()
+		s, ok := t.sessions[key]
+		if !ok && t.config.Server != nil && buffer[0] == contentTypeHandshake {
+			s = t.open(key, addr, true)
+		}
+		t.lock.Unlock()
+		if s == nil {
+			continue
+		}
+
+		select {
+		case s.packets.incoming <- append([]byte(nil), buffer[:n]...):
+		default:
+		}
+	}
+}
+
+// open starts a session with addr, handshaking in the background. Must be called with
+// the lock held.
+func (t *Transport) open(key netip.AddrPort, addr net.Addr, server bool) *session {
+	select {
+	case <-t.closed:
+		return nil
+	default:
+	}
+
+	s := &session{
+		transport: t,
+		key:       key,
+		packets: &sessionConn{
+			transport: t,
+			addr:      addr,
+			incoming:  make(chan []byte, queueSize),
+			deadline:  deadline.New(),
+			closed:    make(chan struct{}),
+		},
+		ready: make(chan struct{}),
+	}
+
+	var err error
+	if server {
+		s.conn, err = dtls.Server(s.packets, addr, t.config.Server)
+	} else {
+		s.conn, err = dtls.Client(s.packets, addr, t.config.Client)
+	}
+	if err != nil {
+		return nil
+	}
+	t.sessions[key] = s
+	go s.run()
+	return s
+}
+
+// forget drops s from the sessions once closed.
+func (t *Transport) forget(s *session) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.sessions[s.key] == s {
+		delete(t.sessions, s.key)
+	}
+}
+
+// session is the DTLS session with a remote address.
+type session struct {
+	transport *Transport
+	key       netip.AddrPort
+	packets   *sessionConn
+	conn      *dtls.Conn
+	ready     chan struct{}
+}
+
+func (s *session) established() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// run handshakes and then reads the datagrams of the session until it fails or idles.
+func (s *session) run() {
+	defer s.close()
+
+	t := s.transport
+	ctx, cancel := context.WithTimeout(context.Background(), t.config.HandshakeTimeout)
+	err := s.conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	close(s.ready)
+
+	buffer := make([]byte, maxRecordSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(t.config.IdleTimeout))
+		n, err := s.conn.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		select {
+		case t.received <- datagram{addr: s.packets.addr, data: append([]byte(nil), buffer[:n]...)}:
+		case <-t.closed:
+			return
+		default:
+		}
+	}
+}
+
+func (s *session) close() {
+	s.transport.forget(s)
+	s.conn.Close()
+}
+
+// sessionConn is the net.PacketConn a session runs on, fed by the socket of the
+// transport with the datagrams of its address.
+type sessionConn struct {
+	transport *Transport
+	addr      net.Addr
+	incoming  chan []byte
+	deadline  *deadline.Deadline
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *sessionConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case data := <-c.incoming:
+		return copy(p, data), c.addr, nil
+	case <-c.deadline.Done():
+		return 0, nil, c.deadline.Err()
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *sessionConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.transport.conn.WriteTo(p, c.addr)
+}
+
+func (c *sessionConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *sessionConn) LocalAddr() net.Addr {
+	return c.transport.conn.LocalAddr()
+}
+
+func (c *sessionConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *sessionConn) SetReadDeadline(t time.Time) error {
+	c.deadline.Set(t)
+	return nil
+}
+
+func (c *sessionConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// keyOf returns the key of the session with addr.
+func keyOf(addr net.Addr) (netip.AddrPort, bool) {
+	var addrPort netip.AddrPort
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		addrPort = udp.AddrPort()
+	} else if parsed, err := netip.ParseAddrPort(addr.String()); err == nil {
+		addrPort = parsed
+	} else {
+		return addrPort, false
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), addrPort.IsValid()
+}
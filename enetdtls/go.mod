@@ -0,0 +1,17 @@
+module github.com/TubbyStubby/go-enet-sharp/enetdtls
+
+go 1.25.0
+
+require (
+	github.com/TubbyStubby/go-enet-sharp v0.0.0
+	github.com/pion/dtls/v3 v3.0.7
+	github.com/pion/transport/v3 v3.0.8
+)
+
+require (
+	github.com/pion/logging v0.2.4 // indirect
+	go.yaml.in/yaml/v3 v3.0.5 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+)
+
+replace github.com/TubbyStubby/go-enet-sharp => ../
@@ -0,0 +1,116 @@
+// Package enetflat sends and receives FlatBuffers over enet. Received buffers are
+// handed to the generated accessors without copying, straight out of the packet, which
+// stays alive until the buffer is released. This suits state messages received at
+// high rates, where copying every packet adds up.
+//
+//	buf, err := enetflat.Receive(event)
+//	if err != nil {
+//		return
+//	}
+//	defer buf.Release()
+//	state := enetflat.Root(buf, game.GetRootAsState)
+package enetflat
+
+import (
+	"errors"
+	"sync"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Buffer is the payload of a received packet, viewed without copying
+type Buffer struct {
+	packet enet.Packet
+
+	// Bytes is the payload, following the opcode if there is one. It points into the
+	// packet and is only valid until Release.
+	Bytes []byte
+}
+
+// Receive takes the packet of a receive event out of the event, see enet.DetachPacket,
+// and returns a view of its payload. The buffer must be released once the accessors
+// reading it are done, instead of destroying the packet of the event.
+func Receive(event enet.Event) (*Buffer, error) {
+	packet, err := enet.DetachPacket(event)
+	if err != nil {
+		return nil, err
+	}
+	return &Buffer{packet: packet, Bytes: event.GetPacketDataUnsafe()}, nil
+}
+
+// ReceiveMessage is like Receive for packets starting with an opcode in the given
+// format, as written by SendMessage. The opcode is not part of the buffer.
+func ReceiveMessage(event enet.Event, format enet.OpcodeFormat) (uint64, *Buffer, error) {
+	opcode, n, err := enet.DecodeOpcode(format, event.GetPacketDataUnsafe())
+	if err != nil {
+		return 0, nil, err
+	}
+	buf, err := Receive(event)
+	if err != nil {
+		return 0, nil, err
+	}
+	buf.Bytes = buf.Bytes[n:]
+	return opcode, buf, nil
+}
+
+// Release destroys the packet of the buffer. The accessors reading it must no longer be
+// used afterwards.
+func (buf *Buffer) Release() error {
+	if buf.packet == nil {
+		return errors.New("buffer has already been released")
+	}
+	packet := buf.packet
+	buf.packet = nil
+	buf.Bytes = nil
+	return packet.Destroy()
+}
+
+// Root returns the root table of the buffer using the generated GetRootAs function of
+// its type, for example Root(buf, game.GetRootAsState).
+func Root[T any](buf *Buffer, getRootAs func(buf []byte, offset flatbuffers.UOffsetT) T) T {
+	return getRootAs(buf.Bytes, 0)
+}
+
+var builders = sync.Pool{
+	New: func() any {
+		return flatbuffers.NewBuilder(1024)
+	},
+}
+
+// GetBuilder returns a reset builder from a pool. Put it back with PutBuilder once the
+// message has been sent.
+func GetBuilder() *flatbuffers.Builder {
+	builder := builders.Get().(*flatbuffers.Builder)
+	builder.Reset()
+	return builder
+}
+
+// PutBuilder returns a builder to the pool. It must no longer be used afterwards.
+func PutBuilder(builder *flatbuffers.Builder) {
+	builders.Put(builder)
+}
+
+// Send sends the finished buffer of builder to peer
+func Send(peer enet.Peer, channel uint8, flags enet.PacketFlags, builder *flatbuffers.Builder) error {
+	return peer.SendBytes(builder.FinishedBytes(), channel, flags)
+}
+
+var buffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// SendMessage sends the finished buffer of builder to peer, prefixed with the opcode in
+// the given format. Use ReceiveMessage to read it.
+func SendMessage(peer enet.Peer, channel uint8, flags enet.PacketFlags, format enet.OpcodeFormat, opcode uint64, builder *flatbuffers.Builder) error {
+	bp := buffers.Get().(*[]byte)
+	defer buffers.Put(bp)
+
+	data := enet.AppendOpcode((*bp)[:0], format, opcode)
+	data = append(data, builder.FinishedBytes()...)
+	*bp = data[:0]
+	return peer.SendBytes(data, channel, flags)
+}
@@ -0,0 +1,649 @@
+// Package enetmock provides fake hosts, peers, events and packets for testing code
+// using enet without a network or the C library. A Host returns the events queued on
+// it from Service and captures everything sent through it, so tests can script a
+// conversation and check the replies.
+//
+//	host := enetmock.NewHost()
+//	peer := host.NewPeer(enet.NewAddress("127.0.0.1", 1234))
+//	host.Connected(peer, 0)
+//	host.Received(peer, 0, []byte("hello"))
+//
+//	serve(host)
+//
+//	if sent := host.Sent(); len(sent) != 1 || string(sent[0].Data) != "world" {
+//		t.Fatal("unexpected reply", sent)
+//	}
+package enetmock
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// Sent is a packet captured by a Host
+type Sent struct {
+	// Peer is the peer the packet was sent to, or nil for broadcasts
+	Peer    *Peer
+	Channel uint8
+	Flags   enet.PacketFlags
+	Data    []byte
+}
+
+// Connect is a call of Host.Connect captured by a Host
+type Connect struct {
+	Address      enet.Address
+	ChannelCount int
+	Data         uint32
+
+	// Peer is the peer returned by the call, nil if it failed
+	Peer *Peer
+}
+
+// Host is a fake enet.Host. It is safe for concurrent use, so tests can queue events
+// while the code under test services the host.
+type Host struct {
+	enet.ServiceV2Func
+
+	// ConnectErr is returned by Connect when set
+	ConnectErr error
+
+	lock      sync.Mutex
+	notify    chan struct{}
+	destroyed bool
+	events    []enet.Event
+	sent      []Sent
+	connects  []Connect
+	peers     []*Peer
+	config    enet.HostConfig
+
+	bytesSent       uint32
+	bytesReceived   uint32
+	packetsSent     uint32
+	packetsReceived uint32
+}
+
+// NewHost creates a host without peers or events
+func NewHost() *Host {
+	host := &Host{notify: make(chan struct{}, 1)}
+	host.ServiceV2Func = host.Service
+	return host
+}
+
+// NewPeer creates a peer of the host with the next ID. The peer counts as connected
+// until it is disconnected, but no connect event is queued for it, see Connected.
+func (host *Host) NewPeer(address enet.Address) *Peer {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.newPeer(address)
+}
+
+func (host *Host) newPeer(address enet.Address) *Peer {
+	peer := &Peer{host: host, id: uint32(len(host.peers)), address: address}
+	host.peers = append(host.peers, peer)
+	return peer
+}
+
+// Queue appends events for Service to return in order. Events created by the helpers
+// of the host have their timestamp set when they are returned.
+func (host *Host) Queue(events ...enet.Event) {
+	host.lock.Lock()
+	host.events = append(host.events, events...)
+	host.lock.Unlock()
+
+	select {
+	case host.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Connected queues a connect event for peer
+func (host *Host) Connected(peer *Peer, data uint32) {
+	host.Queue(&Event{Type: enet.EventConnect, Peer: peer, Data: data})
+}
+
+// Received queues a receive event carrying a reliable packet with data from peer
+func (host *Host) Received(peer *Peer, channel uint8, data []byte) {
+	host.Queue(&Event{
+		Type:      enet.EventReceive,
+		Peer:      peer,
+		ChannelID: channel,
+		Packet:    &Packet{Data: data, Flags: enet.PacketFlagReliable},
+	})
+}
+
+// Disconnected queues a disconnect event for peer
+func (host *Host) Disconnected(peer *Peer, data uint32) {
+	host.Queue(&Event{Type: enet.EventDisconnect, Peer: peer, Data: data})
+}
+
+// Pending returns the number of queued events Service has not returned yet
+func (host *Host) Pending() int {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return len(host.events)
+}
+
+// Sent returns the packets sent through the host and its peers so far, in order
+func (host *Host) Sent() []Sent {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return append([]Sent(nil), host.sent...)
+}
+
+// SentTo returns the packets sent to peer so far, in order. Broadcasts are not
+// included.
+func (host *Host) SentTo(peer *Peer) []Sent {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+
+	var ret []Sent
+	for _, sent := range host.sent {
+		if sent.Peer == peer {
+			ret = append(ret, sent)
+		}
+	}
+	return ret
+}
+
+// ClearSent forgets the packets sent so far
+func (host *Host) ClearSent() {
+	host.lock.Lock()
+	host.sent = nil
+	host.lock.Unlock()
+}
+
+// Connects returns the calls of Connect so far, in order
+func (host *Host) Connects() []Connect {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return append([]Connect(nil), host.connects...)
+}
+
+// Destroyed reports whether Destroy has been called
+func (host *Host) Destroyed() bool {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.destroyed
+}
+
+func (host *Host) Destroy() error {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+
+	if host.destroyed {
+		return errors.New("host has been destroyed")
+	}
+	host.destroyed = true
+	return nil
+}
+
+// Service returns the next queued event, waiting up to timeout milliseconds for one
+// to be queued. Returns an event of type enet.EventNone if there is none.
+func (host *Host) Service(timeout uint32) enet.Event {
+	var timer *time.Timer
+	for {
+		host.lock.Lock()
+		if len(host.events) > 0 && !host.destroyed {
+			event := host.events[0]
+			host.events[0] = nil
+			host.events = host.events[1:]
+			host.received(event)
+			host.lock.Unlock()
+
+			if timer != nil {
+				timer.Stop()
+			}
+			if e, ok := event.(*Event); ok && e.Timestamp.IsZero() {
+				e.Timestamp = time.Now()
+			}
+			return event
+		}
+		host.lock.Unlock()
+
+		if timeout == 0 {
+			return &Event{Timestamp: time.Now()}
+		}
+		if timer == nil {
+			timer = time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		}
+		select {
+		case <-host.notify:
+		case <-timer.C:
+			timeout = 0
+		}
+	}
+}
+
+// received counts the packet of event and updates the state of its peer. Must be
+// called with the lock held.
+func (host *Host) received(event enet.Event) {
+	peer, _ := event.GetPeer().(*Peer)
+	switch event.GetType() {
+	case enet.EventConnect:
+		if peer != nil {
+			peer.disconnected = false
+		}
+	case enet.EventReceive:
+		size := uint32(len(event.GetPacketDataUnsafe()))
+		host.bytesReceived += size
+		host.packetsReceived++
+		if peer != nil {
+			peer.bytesReceived += uint64(size)
+		}
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		if peer != nil {
+			peer.disconnected = true
+		}
+	}
+}
+
+// Connect returns a new peer and captures the call, unless ConnectErr is set. Like
+// peers created with NewPeer, no connect event is queued for it.
+func (host *Host) Connect(addr enet.Address, channelCount int, data uint32) (enet.Peer, error) {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+
+	connect := Connect{Address: addr, ChannelCount: channelCount, Data: data}
+	if host.destroyed || host.ConnectErr != nil {
+		host.connects = append(host.connects, connect)
+		if host.destroyed {
+			return nil, errors.New("host has been destroyed")
+		}
+		return nil, host.ConnectErr
+	}
+
+	connect.Peer = host.newPeer(addr)
+	host.connects = append(host.connects, connect)
+	return connect.Peer, nil
+}
+
+// record captures a packet sent through the host. Must be called with the lock held.
+func (host *Host) record(peer *Peer, data []byte, channel uint8, flags enet.PacketFlags) {
+	host.sent = append(host.sent, Sent{
+		Peer:    peer,
+		Channel: channel,
+		Flags:   flags,
+		Data:    append([]byte(nil), data...),
+	})
+	host.bytesSent += uint32(len(data))
+	host.packetsSent++
+}
+
+func (host *Host) BroadcastBytes(data []byte, channel uint8, flags enet.PacketFlags) error {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+
+	if host.destroyed {
+		return errors.New("host has been destroyed")
+	}
+	host.record(nil, data, channel, flags)
+	return nil
+}
+
+func (host *Host) BroadcastPacket(packet enet.Packet, channel uint8) error {
+	if err := host.BroadcastBytes(packet.GetData(), channel, packet.GetFlags()); err != nil {
+		return err
+	}
+	return packet.Destroy()
+}
+
+func (host *Host) BroadcastString(str string, channel uint8, flags enet.PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
+}
+
+// counter reads a counter of the host under the lock.
+func (host *Host) counter(counter *uint32) uint32 {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return *counter
+}
+
+// reset clears a counter of the host under the lock.
+func (host *Host) reset(counter *uint32) {
+	host.lock.Lock()
+	*counter = 0
+	host.lock.Unlock()
+}
+
+func (host *Host) GetBytesSent() uint32       { return host.counter(&host.bytesSent) }
+func (host *Host) GetBytesReceived() uint32   { return host.counter(&host.bytesReceived) }
+func (host *Host) GetPacketsSent() uint32     { return host.counter(&host.packetsSent) }
+func (host *Host) GetPacketsReceived() uint32 { return host.counter(&host.packetsReceived) }
+func (host *Host) ResetBytesSent()            { host.reset(&host.bytesSent) }
+func (host *Host) ResetBytesReceived()        { host.reset(&host.bytesReceived) }
+func (host *Host) ResetPacketsSent()          { host.reset(&host.packetsSent) }
+func (host *Host) ResetPacketsReceived()      { host.reset(&host.packetsReceived) }
+
+// GetStats returns the counters of the host as of now
+func (host *Host) GetStats() enet.HostStats {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+
+	var connected uint32
+	for _, peer := range host.peers {
+		if !peer.disconnected {
+			connected++
+		}
+	}
+	return enet.HostStats{
+		BytesSent:       host.bytesSent,
+		BytesReceived:   host.bytesReceived,
+		PacketsSent:     host.packetsSent,
+		PacketsReceived: host.packetsReceived,
+		ConnectedPeers:  connected,
+		UpdatedAt:       time.Now(),
+	}
+}
+
+// ApplyConfig records config, see Config
+func (host *Host) ApplyConfig(config enet.HostConfig) error {
+	host.lock.Lock()
+	host.config = config
+	host.lock.Unlock()
+	return nil
+}
+
+// Config returns the configuration last applied to the host
+func (host *Host) Config() enet.HostConfig {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.config
+}
+
+// Event is a fake enet.Event. Queue it on a Host to have Service return it.
+type Event struct {
+	Type      enet.EventType
+	Peer      enet.Peer
+	ChannelID uint8
+	Data      uint32
+	Packet    enet.Packet
+	Timestamp time.Time
+}
+
+func (event *Event) GetType() enet.EventType { return event.Type }
+func (event *Event) GetPeer() enet.Peer      { return event.Peer }
+func (event *Event) GetChannelID() uint8     { return event.ChannelID }
+func (event *Event) GetData() uint32         { return event.Data }
+func (event *Event) GetPacket() enet.Packet  { return event.Packet }
+func (event *Event) GetTimestamp() time.Time { return event.Timestamp }
+
+func (event *Event) GetPacketDataUnsafe() []byte {
+	if event.Packet == nil {
+		return nil
+	}
+	if packet, ok := event.Packet.(*Packet); ok {
+		return packet.Data
+	}
+	return event.Packet.GetData()
+}
+
+func (event *Event) ReadPayload(buf []byte) (int, error) {
+	if event.Packet == nil {
+		return 0, errors.New("event has no packet")
+	}
+	if packet, ok := event.Packet.(*Packet); ok && packet.Destroyed {
+		return 0, errors.New("packet has been destroyed")
+	}
+	data := event.GetPacketDataUnsafe()
+	n := copy(buf, data)
+	if n < len(data) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Packet is a fake enet.Packet that remembers whether it has been destroyed
+type Packet struct {
+	Data      []byte
+	Flags     enet.PacketFlags
+	Destroyed bool
+}
+
+func (packet *Packet) Destroy() error {
+	if packet.Destroyed {
+		return errors.New("packet has already been destroyed or sent")
+	}
+	packet.Destroyed = true
+	return nil
+}
+
+func (packet *Packet) GetData() []byte {
+	return append([]byte(nil), packet.Data...)
+}
+
+func (packet *Packet) GetFlags() enet.PacketFlags {
+	return packet.Flags
+}
+
+// Peer is a fake enet.Peer of a Host. Everything sent to it is captured by its host.
+type Peer struct {
+	enet.PeerTags
+
+	host    *Host
+	id      uint32
+	address enet.Address
+
+	// Guarded by the lock of the host.
+	data           []byte
+	identity       []byte
+	publicKey      ed25519.PublicKey
+	disconnected   bool
+	disconnectData []uint32
+	bytesSent      uint64
+	packetsSent    uint64
+	bytesReceived  uint64
+	bandwidth      uint32
+	conditions     Conditions
+	clockOffset    time.Duration
+}
+
+// Disconnects returns the data of every Disconnect, DisconnectNow and DisconnectLater
+// call on the peer so far, in order
+func (peer *Peer) Disconnects() []uint32 {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return append([]uint32(nil), peer.disconnectData...)
+}
+
+func (peer *Peer) GetAddress() enet.Address {
+	return peer.address
+}
+
+func (peer *Peer) GetID() uint32 {
+	return peer.id
+}
+
+// Disconnect captures the call and, like enet, queues a disconnect event with the
+// data set to 0 on the host.
+func (peer *Peer) Disconnect(data uint32) {
+	if peer.disconnect(data) {
+		peer.host.Disconnected(peer, 0)
+	}
+}
+
+// DisconnectNow captures the call without queueing an event
+func (peer *Peer) DisconnectNow(data uint32) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.disconnectData = append(peer.disconnectData, data)
+	peer.disconnected = true
+}
+
+func (peer *Peer) DisconnectLater(data uint32) {
+	peer.Disconnect(data)
+}
+
+// disconnect captures a disconnect call. Returns false if the peer was already
+// disconnected.
+func (peer *Peer) disconnect(data uint32) bool {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.disconnectData = append(peer.disconnectData, data)
+	return !peer.disconnected
+}
+
+func (peer *Peer) SetTimeout(limit uint32, min uint32, max uint32) {}
+
+func (peer *Peer) PingInterval(interval uint32) {}
+
+func (peer *Peer) SendBytes(data []byte, channel uint8, flags enet.PacketFlags) error {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+
+	if peer.disconnected || peer.host.destroyed {
+		return errors.New("peer is not connected")
+	}
+	peer.host.record(peer, data, channel, flags)
+	peer.bytesSent += uint64(len(data))
+	peer.packetsSent++
+	return nil
+}
+
+func (peer *Peer) SendString(str string, channel uint8, flags enet.PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
+
+// SendPacket captures the data of the packet and destroys it, as enet would once it
+// has been sent.
+func (peer *Peer) SendPacket(packet enet.Packet, channel uint8) error {
+	if err := peer.SendBytes(packet.GetData(), channel, packet.GetFlags()); err != nil {
+		return err
+	}
+	return packet.Destroy()
+}
+
+func (peer *Peer) SendAsync(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return peer.SendBytes(data, channel, flags)
+}
+
+func (peer *Peer) SetData(data []byte) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+
+	if data == nil {
+		peer.data = nil
+		return
+	}
+	peer.data = append([]byte{}, data...)
+}
+
+func (peer *Peer) GetData() []byte {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+
+	if peer.data == nil {
+		return nil
+	}
+	return append([]byte{}, peer.data...)
+}
+
+// SetIdentity sets the key Identity returns, as if the peer had authenticated with it
+func (peer *Peer) SetIdentity(identity []byte) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.identity = append([]byte(nil), identity...)
+}
+
+func (peer *Peer) Identity() []byte {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+
+	if peer.identity == nil {
+		return nil
+	}
+	return append([]byte{}, peer.identity...)
+}
+
+// SetPublicKey sets the key PublicKey returns, as if the peer had proved it
+func (peer *Peer) SetPublicKey(key ed25519.PublicKey) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.publicKey = append(ed25519.PublicKey(nil), key...)
+}
+
+func (peer *Peer) PublicKey() ed25519.PublicKey {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+
+	if peer.publicKey == nil {
+		return nil
+	}
+	return append(ed25519.PublicKey{}, peer.publicKey...)
+}
+
+func (peer *Peer) GetBytesSent() uint64 {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.bytesSent
+}
+
+func (peer *Peer) GetBytesReceived() uint64 {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.bytesReceived
+}
+
+func (peer *Peer) GetPacketsSent() uint64 {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.packetsSent
+}
+
+// GetPacketsLost always returns 0
+func (peer *Peer) GetPacketsLost() uint64 {
+	return 0
+}
+
+// SetEstimatedBandwidth sets the bandwidth EstimatedBandwidth returns, to test how an
+// application adapts to it
+func (peer *Peer) SetEstimatedBandwidth(bandwidth uint32) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.bandwidth = bandwidth
+}
+
+func (peer *Peer) EstimatedBandwidth() uint32 {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.bandwidth
+}
+
+// Conditions are the network conditions simulated for a peer
+type Conditions struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+}
+
+// Conditions returns the conditions last passed to SimulateConditions
+func (peer *Peer) Conditions() Conditions {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.conditions
+}
+
+// SimulateConditions only records the conditions, see Conditions
+func (peer *Peer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.conditions = Conditions{Latency: latency, Jitter: jitter, Loss: loss}
+	return nil
+}
+
+// SetClockOffset sets the offset ClockOffset returns
+func (peer *Peer) SetClockOffset(offset time.Duration) {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	peer.clockOffset = offset
+}
+
+func (peer *Peer) ClockOffset() time.Duration {
+	peer.host.lock.Lock()
+	defer peer.host.lock.Unlock()
+	return peer.clockOffset
+}
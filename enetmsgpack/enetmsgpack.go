@@ -0,0 +1,70 @@
+// Package enetmsgpack implements enet.Codec with MessagePack, so schemaless clients,
+// for example written in JavaScript or Lua, can talk to Go servers without protobuf.
+//
+//	enet.SendValue(peer, 0, enet.PacketFlagReliable, enet.OpcodeUvarint, opMove, enetmsgpack.Codec{}, move)
+//
+//	d.Handle(opMove, func(msg *enet.Message) {
+//		var move Move
+//		if err := msg.Decode(enetmsgpack.Codec{}, &move); err != nil {
+//			return
+//		}
+//		...
+//	})
+package enetmsgpack
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a MessagePack enet.Codec. By default structs are encoded as maps keyed by
+// field name, or by the name in their msgpack tag, which is what most MessagePack
+// libraries of other languages expect.
+type Codec struct {
+	// StructTag is the struct tag naming fields if their msgpack tag is missing, for
+	// example "json" to reuse the tags of types already sent as JSON.
+	StructTag string
+
+	// StructsAsArrays encodes structs as arrays of their fields in order instead of
+	// maps, which is more compact but needs both sides to agree on the field order.
+	StructsAsArrays bool
+
+	// CompactInts encodes integers in the smallest representation holding their value
+	// instead of the one matching their Go type.
+	CompactInts bool
+
+	// SortMapKeys encodes map keys in increasing order, so equal values always encode
+	// to the same bytes.
+	SortMapKeys bool
+}
+
+func (c Codec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+
+	enc.Reset(&buf)
+	if c.StructTag != "" {
+		enc.SetCustomStructTag(c.StructTag)
+	}
+	enc.UseArrayEncodedStructs(c.StructsAsArrays)
+	enc.UseCompactInts(c.CompactInts)
+	enc.SetSortMapKeys(c.SortMapKeys)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Codec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+
+	dec.Reset(bytes.NewReader(data))
+	if c.StructTag != "" {
+		dec.SetCustomStructTag(c.StructTag)
+	}
+	return dec.Decode(v)
+}
@@ -0,0 +1,195 @@
+// Package enetotel traces enet hosts and enetrpc endpoints with OpenTelemetry. A Tracer
+// starts spans around the iterations servicing a host and around sends, and carries
+// trace context in the headers of RPC calls, so traces follow calls across services:
+//
+//	tracer := enetotel.New(enetotel.Config{})
+//	err := tracer.Instrument(host)
+//
+//	rpc := enetrpc.New(enetrpc.Config{
+//		Channel:          7,
+//		CallInterceptor:  tracer.CallInterceptor(),
+//		ServeInterceptor: tracer.ServeInterceptor(),
+//	})
+package enetotel
+
+import (
+	"context"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/enetrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/TubbyStubby/go-enet-sharp/enetotel"
+
+// eventTypes are the names of the types of events in spans.
+var eventTypes = [...]string{
+	enet.EventNone:              "none",
+	enet.EventConnect:           "connect",
+	enet.EventDisconnect:        "disconnect",
+	enet.EventReceive:           "receive",
+	enet.EventDisconnectTimeout: "disconnect_timeout",
+}
+
+// Config configures a Tracer
+type Config struct {
+	// TracerProvider provides the tracer spans are started with. Defaults to the global
+	// provider.
+	TracerProvider trace.TracerProvider
+
+	// Propagator carries trace context in the headers of calls. Defaults to the global
+	// propagator, see otel.SetTextMapPropagator.
+	Propagator propagation.TextMapPropagator
+
+	// TraceIdle also traces the iterations servicing a host that returned no event,
+	// which hosts waiting for events run continuously.
+	TraceIdle bool
+}
+
+// Tracer starts the spans of enet hosts and RPC endpoints
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	traceIdle  bool
+}
+
+// New creates a tracer
+func New(config Config) *Tracer {
+	if config.TracerProvider == nil {
+		config.TracerProvider = otel.GetTracerProvider()
+	}
+	if config.Propagator == nil {
+		config.Propagator = otel.GetTextMapPropagator()
+	}
+	return &Tracer{
+		tracer:     config.TracerProvider.Tracer(instrumentationName),
+		propagator: config.Propagator,
+		traceIdle:  config.TraceIdle,
+	}
+}
+
+// Instrument traces every iteration servicing host with an "enet.Service" span,
+// carrying the type of the event returned and where it comes from.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func (t *Tracer) Instrument(host enet.Host) error {
+	return enet.ObserveService(host, func(enet.Host) func(enet.Event) {
+		start := time.Now()
+		return func(event enet.Event) {
+			eventType := event.GetType()
+			if eventType == enet.EventNone && !t.traceIdle {
+				return
+			}
+
+			attributes := []attribute.KeyValue{attribute.String("enet.event.type", eventTypeName(eventType))}
+			if peer := event.GetPeer(); eventType != enet.EventNone && peer != nil {
+				attributes = append(attributes, attribute.Int64("enet.peer.id", int64(peer.GetID())))
+				if addr := peer.GetAddress(); addr != nil {
+					attributes = append(attributes,
+						attribute.String("network.peer.address", addr.String()),
+						attribute.Int("network.peer.port", int(addr.GetPort())),
+					)
+				}
+			}
+			if eventType == enet.EventReceive {
+				attributes = append(attributes,
+					attribute.Int("enet.channel", int(event.GetChannelID())),
+					attribute.Int("enet.packet.size", len(event.GetPacketDataUnsafe())),
+				)
+			}
+
+			_, span := t.tracer.Start(context.Background(), "enet.Service",
+				trace.WithTimestamp(start),
+				trace.WithAttributes(attributes...),
+			)
+			span.End()
+		}
+	})
+}
+
+// Send sends data to peer like Peer.SendBytes, within an "enet.Send" span that is a
+// child of the span of ctx
+func (t *Tracer) Send(ctx context.Context, peer enet.Peer, data []byte, channel uint8, flags enet.PacketFlags) error {
+	_, span := t.startSend(ctx, "enet.Send", data, channel, flags)
+	defer span.End()
+	return recordError(span, peer.SendBytes(data, channel, flags))
+}
+
+// SendAsync queues data for peer like Peer.SendAsync, within an "enet.SendAsync" span
+// that is a child of the span of ctx. Like Peer.SendAsync it is safe to call from any
+// goroutine.
+func (t *Tracer) SendAsync(ctx context.Context, peer enet.Peer, data []byte, channel uint8, flags enet.PacketFlags) error {
+	_, span := t.startSend(ctx, "enet.SendAsync", data, channel, flags)
+	defer span.End()
+	return recordError(span, peer.SendAsync(data, channel, flags))
+}
+
+func (t *Tracer) startSend(ctx context.Context, name string, data []byte, channel uint8, flags enet.PacketFlags) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int("enet.channel", int(channel)),
+			attribute.Int("enet.packet.size", len(data)),
+			attribute.Bool("enet.packet.reliable", flags&enet.PacketFlagReliable != 0),
+		),
+	)
+}
+
+// CallInterceptor returns an interceptor tracing the calls of an endpoint, carrying
+// their trace context to the peers serving them
+func (t *Tracer) CallInterceptor() enetrpc.CallInterceptor {
+	return func(ctx context.Context, peer enet.Peer, method string, headers enetrpc.Headers, invoke func(ctx context.Context) error) error {
+		ctx, span := t.tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+		defer span.End()
+
+		t.propagator.Inject(ctx, propagation.MapCarrier(headers))
+		return recordError(span, invoke(ctx))
+	}
+}
+
+// ServeInterceptor returns an interceptor tracing the methods serving the calls of an
+// endpoint, as children of the spans of the calls
+func (t *Tracer) ServeInterceptor() enetrpc.ServeInterceptor {
+	return func(ctx context.Context, peer enet.Peer, method string, headers enetrpc.Headers, serve func(ctx context.Context) error) error {
+		if headers != nil {
+			ctx = t.propagator.Extract(ctx, propagation.MapCarrier(headers))
+		}
+		ctx, span := t.tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+		defer span.End()
+		return recordError(span, serve(ctx))
+	}
+}
+
+func rpcAttributes(method string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "enetrpc"),
+		attribute.String("rpc.method", method),
+	}
+}
+
+// recordError records err on span, returning it.
+func recordError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func eventTypeName(eventType enet.EventType) string {
+	if eventType < 0 || int(eventType) >= len(eventTypes) {
+		return "unknown"
+	}
+	return eventTypes[eventType]
+}
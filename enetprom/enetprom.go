@@ -0,0 +1,203 @@
+// Package enetprom exports the statistics of enet hosts as Prometheus metrics. A
+// Collector reads the snapshots a host takes of its statistics as it is serviced, so
+// scrapes never touch the host from the goroutines of the HTTP server:
+//
+//	collector, err := enetprom.NewCollector(host, enetprom.Config{})
+//	prometheus.MustRegister(collector)
+//	loop.Use(collector.Middleware())
+//
+// Events are counted as the collector observes them, through its middleware or
+// Observe.
+package enetprom
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roundTripBuckets are the buckets of the round trip times of peers, in seconds.
+var roundTripBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1}
+
+// eventTypes are the label values of the types of events counted.
+var eventTypes = [...]string{
+	enet.EventConnect:           "connect",
+	enet.EventDisconnect:        "disconnect",
+	enet.EventReceive:           "receive",
+	enet.EventDisconnectTimeout: "disconnect_timeout",
+}
+
+// Config configures a Collector
+type Config struct {
+	// Namespace prefixes the names of the metrics. Defaults to "enet".
+	Namespace string
+
+	// ConstLabels are added to all metrics, for example to tell hosts apart
+	ConstLabels prometheus.Labels
+
+	// PeerMetrics exports the metrics of every connected peer, labelled with its ID and
+	// address. Each peer adds series to every metric, so it is best kept for hosts with
+	// few peers.
+	PeerMetrics bool
+}
+
+// Collector is a prometheus.Collector exporting the metrics of a host
+type Collector struct {
+	host   enet.Host
+	config Config
+	events [len(eventTypes)]atomic.Uint64
+
+	bytesSent       *prometheus.Desc
+	bytesReceived   *prometheus.Desc
+	packetsSent     *prometheus.Desc
+	packetsReceived *prometheus.Desc
+	connectedPeers  *prometheus.Desc
+	queuedPackets   *prometheus.Desc
+	eventsTotal     *prometheus.Desc
+	roundTripTime   *prometheus.Desc
+	queuedCommands  *prometheus.Desc
+	packetsLost     *prometheus.Desc
+
+	peerBytesSent      *prometheus.Desc
+	peerBytesReceived  *prometheus.Desc
+	peerPacketsSent    *prometheus.Desc
+	peerPacketsLost    *prometheus.Desc
+	peerRoundTripTime  *prometheus.Desc
+	peerQueuedCommands *prometheus.Desc
+}
+
+// NewCollector creates a collector exporting the metrics of host, making it track the
+// statistics of its peers, see enet.TrackPeerStats.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func NewCollector(host enet.Host, config Config) (*Collector, error) {
+	if err := enet.TrackPeerStats(host); err != nil {
+		return nil, err
+	}
+	if config.Namespace == "" {
+		config.Namespace = "enet"
+	}
+
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(config.Namespace, "", name), help, labels, config.ConstLabels)
+	}
+	peerLabels := []string{"peer", "address"}
+	return &Collector{
+		host:   host,
+		config: config,
+
+		bytesSent:       desc("bytes_sent_total", "Bytes sent by the host."),
+		bytesReceived:   desc("bytes_received_total", "Bytes received by the host."),
+		packetsSent:     desc("packets_sent_total", "Datagrams sent by the host."),
+		packetsReceived: desc("packets_received_total", "Datagrams received by the host."),
+		connectedPeers:  desc("connected_peers", "Peers connected to the host."),
+		queuedPackets:   desc("queued_packets", "Packets queued with SendAsync the host has yet to take."),
+		eventsTotal:     desc("events_total", "Events observed, by type.", "type"),
+		roundTripTime:   desc("round_trip_time_seconds", "Mean round trip times of the connected peers."),
+		queuedCommands:  desc("queued_commands", "Commands waiting to be sent to the connected peers or to be acknowledged."),
+		packetsLost:     desc("packets_lost", "Reliable packets the connected peers had to be sent again."),
+
+		peerBytesSent:      desc("peer_bytes_sent_total", "Bytes sent to the peer.", peerLabels...),
+		peerBytesReceived:  desc("peer_bytes_received_total", "Bytes received from the peer.", peerLabels...),
+		peerPacketsSent:    desc("peer_packets_sent_total", "Packets sent to the peer.", peerLabels...),
+		peerPacketsLost:    desc("peer_packets_lost_total", "Reliable packets the peer had to be sent again.", peerLabels...),
+		peerRoundTripTime:  desc("peer_round_trip_time_seconds", "Mean round trip time of the peer.", peerLabels...),
+		peerQueuedCommands: desc("peer_queued_commands", "Commands waiting to be sent to the peer or to be acknowledged.", peerLabels...),
+	}, nil
+}
+
+// Observe counts event. It is safe to call from any goroutine.
+func (c *Collector) Observe(event enet.Event) {
+	if t := int(event.GetType()); t > 0 && t < len(c.events) {
+		c.events[t].Add(1)
+	}
+}
+
+// Middleware returns a middleware observing the events of a service loop
+func (c *Collector) Middleware() enet.Middleware {
+	return func(event enet.Event, next enet.EventHandler) {
+		c.Observe(event)
+		next(event)
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytesSent
+	ch <- c.bytesReceived
+	ch <- c.packetsSent
+	ch <- c.packetsReceived
+	ch <- c.connectedPeers
+	ch <- c.queuedPackets
+	ch <- c.eventsTotal
+	ch <- c.roundTripTime
+	ch <- c.queuedCommands
+	ch <- c.packetsLost
+	if c.config.PeerMetrics {
+		ch <- c.peerBytesSent
+		ch <- c.peerBytesReceived
+		ch <- c.peerPacketsSent
+		ch <- c.peerPacketsLost
+		ch <- c.peerRoundTripTime
+		ch <- c.peerQueuedCommands
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.host.GetStats()
+	ch <- prometheus.MustNewConstMetric(c.bytesSent, prometheus.CounterValue, float64(stats.BytesSent))
+	ch <- prometheus.MustNewConstMetric(c.bytesReceived, prometheus.CounterValue, float64(stats.BytesReceived))
+	ch <- prometheus.MustNewConstMetric(c.packetsSent, prometheus.CounterValue, float64(stats.PacketsSent))
+	ch <- prometheus.MustNewConstMetric(c.packetsReceived, prometheus.CounterValue, float64(stats.PacketsReceived))
+	ch <- prometheus.MustNewConstMetric(c.connectedPeers, prometheus.GaugeValue, float64(stats.ConnectedPeers))
+	ch <- prometheus.MustNewConstMetric(c.queuedPackets, prometheus.GaugeValue, float64(stats.QueuedPackets))
+	for t, name := range eventTypes {
+		if name != "" {
+			ch <- prometheus.MustNewConstMetric(c.eventsTotal, prometheus.CounterValue, float64(c.events[t].Load()), name)
+		}
+	}
+
+	peers := enet.GetPeerStats(c.host)
+	buckets := make(map[float64]uint64, len(roundTripBuckets))
+	for _, bucket := range roundTripBuckets {
+		buckets[bucket] = 0
+	}
+	var roundTrips float64
+	var queued, lost uint64
+	for _, peer := range peers {
+		rtt := peer.RoundTripTime.Seconds()
+		roundTrips += rtt
+		for _, bucket := range roundTripBuckets {
+			if rtt <= bucket {
+				buckets[bucket]++
+			}
+		}
+		queued += uint64(peer.QueuedCommands)
+		lost += peer.PacketsLost
+
+		if c.config.PeerMetrics {
+			labels := []string{strconv.FormatUint(uint64(peer.ID), 10), addressOf(peer)}
+			ch <- prometheus.MustNewConstMetric(c.peerBytesSent, prometheus.CounterValue, float64(peer.BytesSent), labels...)
+			ch <- prometheus.MustNewConstMetric(c.peerBytesReceived, prometheus.CounterValue, float64(peer.BytesReceived), labels...)
+			ch <- prometheus.MustNewConstMetric(c.peerPacketsSent, prometheus.CounterValue, float64(peer.PacketsSent), labels...)
+			ch <- prometheus.MustNewConstMetric(c.peerPacketsLost, prometheus.CounterValue, float64(peer.PacketsLost), labels...)
+			ch <- prometheus.MustNewConstMetric(c.peerRoundTripTime, prometheus.GaugeValue, rtt, labels...)
+			ch <- prometheus.MustNewConstMetric(c.peerQueuedCommands, prometheus.GaugeValue, float64(peer.QueuedCommands), labels...)
+		}
+	}
+	ch <- prometheus.MustNewConstHistogram(c.roundTripTime, uint64(len(peers)), roundTrips, buckets)
+	ch <- prometheus.MustNewConstMetric(c.queuedCommands, prometheus.GaugeValue, float64(queued))
+	ch <- prometheus.MustNewConstMetric(c.packetsLost, prometheus.GaugeValue, float64(lost))
+}
+
+// addressOf returns the address of peer as host:port.
+func addressOf(peer enet.PeerStats) string {
+	if peer.Address == nil {
+		return ""
+	}
+	return net.JoinHostPort(peer.Address.String(), strconv.Itoa(int(peer.Address.GetPort())))
+}
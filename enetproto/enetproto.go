@@ -0,0 +1,232 @@
+// Package enetproto sends protobuf messages over enet and routes received ones to
+// handlers by type. Every packet carries one message, prefixed with the opcode its
+// type was registered with, so it can be decoded by an enet.Dispatcher as well.
+//
+//	enetproto.Register(1, (*pb.Move)(nil))
+//
+//	d := enetproto.NewDispatcher(enetproto.DefaultRegistry, 0)
+//	enetproto.Handle(d, func(msg *enet.Message, move *pb.Move) {
+//		...
+//	})
+//
+//	enetproto.SendProto(peer, 0, &pb.Move{X: 1, Y: 2})
+package enetproto
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// bufferSizeMax is the capacity above which marshal buffers are not pooled, so a single
+// large message doesn't pin its buffer forever.
+const bufferSizeMax = 64 * 1024
+
+var buffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// Registry maps protobuf message types to the opcodes identifying them on the wire
+type Registry struct {
+	format enet.OpcodeFormat
+
+	lock    sync.RWMutex
+	types   map[uint64]protoreflect.MessageType
+	opcodes map[protoreflect.FullName]uint64
+}
+
+// DefaultRegistry is the registry used by Register and SendProto. Its opcodes are
+// uvarints.
+var DefaultRegistry = NewRegistry(enet.OpcodeUvarint)
+
+// NewRegistry creates an empty registry writing opcodes in the given format
+func NewRegistry(format enet.OpcodeFormat) *Registry {
+	return &Registry{
+		format:  format,
+		types:   make(map[uint64]protoreflect.MessageType),
+		opcodes: make(map[protoreflect.FullName]uint64),
+	}
+}
+
+// Register registers the type of msg with the default registry, see Registry.Register
+func Register(opcode uint64, msg proto.Message) error {
+	return DefaultRegistry.Register(opcode, msg)
+}
+
+// Register assigns opcode to the type of msg, which may be a nil pointer of the type.
+// Both the opcode and the type can only be registered once.
+func (r *Registry) Register(opcode uint64, msg proto.Message) error {
+	if r.format == enet.OpcodeByte && opcode > 0xFF {
+		return fmt.Errorf("opcode %d does not fit in a byte", opcode)
+	}
+	mt := msg.ProtoReflect().Type()
+	name := mt.Descriptor().FullName()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if registered, ok := r.types[opcode]; ok {
+		return fmt.Errorf("opcode %d is already registered for %s", opcode, registered.Descriptor().FullName())
+	}
+	if registered, ok := r.opcodes[name]; ok {
+		return fmt.Errorf("%s is already registered with opcode %d", name, registered)
+	}
+	r.types[opcode] = mt
+	r.opcodes[name] = opcode
+	return nil
+}
+
+// Opcode returns the opcode the type of msg was registered with
+func (r *Registry) Opcode(msg proto.Message) (uint64, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	opcode, ok := r.opcodes[msg.ProtoReflect().Descriptor().FullName()]
+	return opcode, ok
+}
+
+// Format returns the format of the opcodes written by the registry
+func (r *Registry) Format() enet.OpcodeFormat {
+	return r.format
+}
+
+// Append appends the opcode of msg followed by msg itself to buf
+func (r *Registry) Append(buf []byte, msg proto.Message) ([]byte, error) {
+	opcode, ok := r.Opcode(msg)
+	if !ok {
+		return buf, fmt.Errorf("%s is not registered", msg.ProtoReflect().Descriptor().FullName())
+	}
+	buf = enet.AppendOpcode(buf, r.format, opcode)
+	return proto.MarshalOptions{}.MarshalAppend(buf, msg)
+}
+
+// Decode decodes a packet written by Append into a new message of the registered type
+func (r *Registry) Decode(data []byte) (proto.Message, error) {
+	opcode, n, err := enet.DecodeOpcode(r.format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.RLock()
+	mt, ok := r.types[opcode]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no message registered for opcode %d", opcode)
+	}
+
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(data[n:], msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Send marshals msg into a pooled buffer and sends it to peer
+func (r *Registry) Send(peer enet.Peer, channel uint8, flags enet.PacketFlags, msg proto.Message) error {
+	return r.pooled(msg, func(data []byte) error {
+		return peer.SendBytes(data, channel, flags)
+	})
+}
+
+// Broadcast marshals msg into a pooled buffer and broadcasts it to all peers of host
+func (r *Registry) Broadcast(host enet.Host, channel uint8, flags enet.PacketFlags, msg proto.Message) error {
+	return r.pooled(msg, func(data []byte) error {
+		return host.BroadcastBytes(data, channel, flags)
+	})
+}
+
+// pooled marshals msg into a buffer from the pool and hands it to send, which must not
+// retain it.
+func (r *Registry) pooled(msg proto.Message, send func(data []byte) error) error {
+	bp := buffers.Get().(*[]byte)
+	defer func() {
+		if cap(*bp) <= bufferSizeMax {
+			buffers.Put(bp)
+		}
+	}()
+
+	buf, err := r.Append((*bp)[:0], msg)
+	*bp = buf[:0]
+	if err != nil {
+		return err
+	}
+	return send(buf)
+}
+
+// SendProto sends msg reliably to peer, using the default registry
+func SendProto(peer enet.Peer, channel uint8, msg proto.Message) error {
+	return DefaultRegistry.Send(peer, channel, enet.PacketFlagReliable, msg)
+}
+
+// ErrorHandler is called with messages that failed to decode
+type ErrorHandler func(msg *enet.Message, err error)
+
+// Dispatcher is an enet.Dispatcher routing messages to handlers registered per
+// protobuf message type with Handle
+type Dispatcher struct {
+	enet.Dispatcher
+	registry *Registry
+
+	lock         sync.RWMutex
+	errorHandler ErrorHandler
+}
+
+// NewDispatcher creates a dispatcher for the messages of registry. Workers are passed
+// on to enet.NewDispatcher.
+func NewDispatcher(registry *Registry, workers int) *Dispatcher {
+	return &Dispatcher{
+		Dispatcher: enet.NewDispatcher(registry.format, workers),
+		registry:   registry,
+	}
+}
+
+// OnError registers the handler for messages that fail to unmarshal. Without one they
+// are dropped.
+func (d *Dispatcher) OnError(handler ErrorHandler) {
+	d.lock.Lock()
+	d.errorHandler = handler
+	d.lock.Unlock()
+}
+
+func (d *Dispatcher) fail(msg *enet.Message, err error) {
+	d.lock.RLock()
+	handler := d.errorHandler
+	d.lock.RUnlock()
+
+	if handler != nil {
+		handler(msg, err)
+	}
+}
+
+// Handle registers handler for the messages of type T, which must have been registered
+// with the registry of the dispatcher. The handler gets a new message for every packet
+// and may retain it.
+func Handle[T proto.Message](d *Dispatcher, handler func(msg *enet.Message, m T)) error {
+	var zero T
+	opcode, ok := d.registry.Opcode(zero)
+	if !ok {
+		return fmt.Errorf("%s is not registered", zero.ProtoReflect().Descriptor().FullName())
+	}
+	mt := zero.ProtoReflect().Type()
+
+	d.Handle(opcode, func(msg *enet.Message) {
+		m, ok := mt.New().Interface().(T)
+		if !ok {
+			d.fail(msg, errors.New("message type mismatch"))
+			return
+		}
+		if err := proto.Unmarshal(msg.Payload, m); err != nil {
+			d.fail(msg, err)
+			return
+		}
+		handler(msg, m)
+	})
+	return nil
+}
@@ -0,0 +1,522 @@
+// Package enetrpc implements request/response calls between peers on top of enet. An
+// Endpoint sends calls on a reserved channel, correlating responses by ID so any
+// number of calls can be in flight, and runs the methods registered on it for calls
+// coming in from peers.
+//
+//	rpc := enetrpc.New(enetrpc.Config{Channel: 7})
+//	enetrpc.Register(rpc, "Echo", func(ctx context.Context, peer enet.Peer, req string) (string, error) {
+//		return req, nil
+//	})
+//
+//	// On the goroutine servicing the host, for every event:
+//	if rpc.Handle(event) {
+//		return
+//	}
+//
+//	// From any other goroutine:
+//	var resp string
+//	err := rpc.Call(ctx, peer, "Echo", "hello", &resp)
+package enetrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	frameRequest byte = iota
+	frameResponse
+	frameError
+
+	// frameRequestHeaders is a request carrying headers between its method and its
+	// payload. Requests without headers are sent as frameRequest.
+	frameRequestHeaders
+)
+
+// defaultTimeout bounds calls whose context has no deadline, unless configured.
+const defaultTimeout = 10 * time.Second
+
+var (
+	// ErrClosed is returned by calls on a closed endpoint, and by calls still in
+	// flight when it's closed
+	ErrClosed = errors.New("endpoint is closed")
+
+	// ErrDisconnected is returned by calls in flight when their peer disconnects
+	ErrDisconnected = errors.New("peer disconnected")
+
+	// ErrTimeout is returned by calls that got no response in time
+	ErrTimeout = errors.New("call timed out")
+)
+
+// RemoteError is an error returned by the method of the remote peer
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (err *RemoteError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Method, err.Message)
+}
+
+// Config configures an Endpoint
+type Config struct {
+	// Channel is the channel calls are sent on unless they choose another one. Events
+	// on it are taken to be calls and responses, so the application must not use it
+	// for anything else.
+	Channel uint8
+
+	// Channels are other channels carrying calls, for calls made with CallOptions
+	// choosing them. They are reserved like Channel.
+	Channels []uint8
+
+	// Codec encodes requests and responses. Defaults to JSON.
+	Codec enet.Codec
+
+	// Timeout bounds calls whose context has no deadline. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// CallInterceptor wraps the calls made by the endpoint, if set
+	CallInterceptor CallInterceptor
+
+	// ServeInterceptor wraps the methods serving the calls of peers, if set
+	ServeInterceptor ServeInterceptor
+}
+
+// Headers are carried by a call along with its request, such as trace context. Peers
+// that predate headers can't serve calls carrying some.
+type Headers map[string]string
+
+// CallInterceptor wraps a call made by an endpoint. invoke sends the call with headers,
+// which the interceptor may add to beforehand, and waits for its result.
+type CallInterceptor func(ctx context.Context, peer enet.Peer, method string, headers Headers, invoke func(ctx context.Context) error) error
+
+// ServeInterceptor wraps the method serving a call of peer, which came with headers.
+// serve runs the method and returns its error.
+type ServeInterceptor func(ctx context.Context, peer enet.Peer, method string, headers Headers, serve func(ctx context.Context) error) error
+
+// CallOptions choose how a call is sent. The response is sent back the same way.
+type CallOptions struct {
+	Channel uint8
+	Flags   enet.PacketFlags
+}
+
+type peerKey struct{}
+
+type headersKey struct{}
+
+// WithHeaders returns a context whose calls carry headers, in addition to those added
+// by the call interceptor
+func WithHeaders(ctx context.Context, headers Headers) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// HeadersFromContext returns the headers of the call a method is serving, or those set
+// with WithHeaders
+func HeadersFromContext(ctx context.Context) (Headers, bool) {
+	headers, ok := ctx.Value(headersKey{}).(Headers)
+	return headers, ok
+}
+
+// PeerFromContext returns the peer that made the call a method is serving
+func PeerFromContext(ctx context.Context) (enet.Peer, bool) {
+	peer, ok := ctx.Value(peerKey{}).(enet.Peer)
+	return peer, ok
+}
+
+// MethodFunc is a registered method, taking and returning encoded values
+type MethodFunc func(ctx context.Context, peer enet.Peer, payload []byte) ([]byte, error)
+
+type result struct {
+	payload []byte
+	err     error
+}
+
+type pendingCall struct {
+	peer   enet.Peer
+	method string
+	done   chan result
+}
+
+// Endpoint makes calls to peers and serves theirs. Handle must be called by the
+// goroutine servicing the host, while Call is meant for any other goroutine, since it
+// waits for the host to receive the response.
+type Endpoint struct {
+	config   Config
+	channels [256]bool
+
+	lock    sync.Mutex
+	closed  bool
+	nextID  uint64
+	pending map[uint64]*pendingCall
+	methods map[string]MethodFunc
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	serving sync.WaitGroup
+}
+
+// New creates an endpoint without methods
+func New(config Config) *Endpoint {
+	if config.Codec == nil {
+		config.Codec = jsonCodec{}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	e := &Endpoint{
+		config:  config,
+		pending: make(map[uint64]*pendingCall),
+		methods: make(map[string]MethodFunc),
+	}
+	e.channels[config.Channel] = true
+	for _, channel := range config.Channels {
+		e.channels[channel] = true
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
+}
+
+// Codec returns the codec of the endpoint
+func (e *Endpoint) Codec() enet.Codec {
+	return e.config.Codec
+}
+
+// Channel returns the channel calls are sent on unless they choose another one
+func (e *Endpoint) Channel() uint8 {
+	return e.config.Channel
+}
+
+// RegisterFunc registers fn as the method name, replacing any previous one. Passing
+// nil removes the registration.
+func (e *Endpoint) RegisterFunc(name string, fn MethodFunc) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if fn == nil {
+		delete(e.methods, name)
+		return
+	}
+	e.methods[name] = fn
+}
+
+// Register registers fn as the method name of e, decoding requests into a Req and
+// encoding the returned Resp with the codec of the endpoint. Methods run on their own
+// goroutine, and their context is cancelled once the endpoint is closed. The context
+// also carries the calling peer, see PeerFromContext.
+func Register[Req, Resp any](e *Endpoint, name string, fn func(ctx context.Context, peer enet.Peer, req Req) (Resp, error)) {
+	codec := e.config.Codec
+	e.RegisterFunc(name, func(ctx context.Context, peer enet.Peer, payload []byte) ([]byte, error) {
+		var req Req
+		if err := codec.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("malformed request: %w", err)
+		}
+		resp, err := fn(ctx, peer, req)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(resp)
+	})
+}
+
+// Call calls method on peer with req and decodes the response into resp, which may be
+// nil to ignore it. It is sent reliably on the channel of the endpoint.
+func (e *Endpoint) Call(ctx context.Context, peer enet.Peer, method string, req, resp any) error {
+	return e.CallWith(ctx, peer, CallOptions{Channel: e.config.Channel, Flags: enet.PacketFlagReliable}, method, req, resp)
+}
+
+// CallWith is like Call, sending the call as chosen by options. The channel must be
+// one of the endpoints on both sides.
+func (e *Endpoint) CallWith(ctx context.Context, peer enet.Peer, options CallOptions, method string, req, resp any) error {
+	if !e.channels[options.Channel] {
+		return fmt.Errorf("channel %d does not carry calls", options.Channel)
+	}
+	payload, err := e.config.Codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	headers := make(Headers)
+	if inherited, ok := HeadersFromContext(ctx); ok {
+		for key, value := range inherited {
+			headers[key] = value
+		}
+	}
+	invoke := func(ctx context.Context) error {
+		return e.invoke(ctx, peer, options, method, headers, payload, resp)
+	}
+	if e.config.CallInterceptor != nil {
+		return e.config.CallInterceptor(ctx, peer, method, headers, invoke)
+	}
+	return invoke(ctx)
+}
+
+// invoke sends a call and waits for its response.
+func (e *Endpoint) invoke(ctx context.Context, peer enet.Peer, options CallOptions, method string, headers Headers, payload []byte, resp any) error {
+	call := &pendingCall{peer: peer, method: method, done: make(chan result, 1)}
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return ErrClosed
+	}
+	id := e.nextID
+	e.nextID++
+	e.pending[id] = call
+	e.lock.Unlock()
+
+	forget := func() {
+		e.lock.Lock()
+		delete(e.pending, id)
+		e.lock.Unlock()
+	}
+
+	data := []byte{frameRequest}
+	data = binary.AppendUvarint(data, id)
+	data = appendString(data, method)
+	if len(headers) > 0 {
+		data[0] = frameRequestHeaders
+		data = binary.AppendUvarint(data, uint64(len(headers)))
+		for key, value := range headers {
+			data = appendString(data, key)
+			data = appendString(data, value)
+		}
+	}
+	data = append(data, payload...)
+	if err := peer.SendAsync(data, options.Channel, options.Flags); err != nil {
+		forget()
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.config.Timeout)
+		defer cancel()
+	}
+
+	select {
+	case r := <-call.done:
+		if r.err != nil {
+			return r.err
+		}
+		if resp == nil {
+			return nil
+		}
+		return e.config.Codec.Unmarshal(r.payload, resp)
+	case <-ctx.Done():
+		forget()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+// Handle handles the calls and responses among the events of the host, and fails the
+// calls in flight to peers that disconnect. Returns true if the event was consumed, in
+// which case the application should only destroy its packet. Must be called by the
+// goroutine servicing the host.
+func (e *Endpoint) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		e.disconnected(event.GetPeer())
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if !e.channels[event.GetChannelID()] {
+		return false
+	}
+
+	data := event.GetPacketDataUnsafe()
+	if len(data) == 0 {
+		return true
+	}
+	kind := data[0]
+	id, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return true
+	}
+	data = data[1+n:]
+
+	switch kind {
+	case frameRequest, frameRequestHeaders:
+		method, data, ok := readString(data)
+		if !ok {
+			return true
+		}
+		var headers Headers
+		if kind == frameRequestHeaders {
+			if headers, data, ok = readHeaders(data); !ok {
+				return true
+			}
+		}
+		payload := append([]byte(nil), data...)
+		options := CallOptions{Channel: event.GetChannelID(), Flags: event.GetPacket().GetFlags()}
+		e.serve(event.GetPeer(), options, id, method, headers, payload)
+
+	case frameResponse, frameError:
+		e.lock.Lock()
+		call, ok := e.pending[id]
+		delete(e.pending, id)
+		e.lock.Unlock()
+		if !ok {
+			// The call has timed out already.
+			return true
+		}
+
+		if kind == frameError {
+			call.done <- result{err: &RemoteError{Method: call.method, Message: string(data)}}
+		} else {
+			call.done <- result{payload: append([]byte(nil), data...)}
+		}
+	}
+	return true
+}
+
+// serve runs method for a call from peer on its own goroutine and sends the result
+// back.
+func (e *Endpoint) serve(peer enet.Peer, options CallOptions, id uint64, method string, headers Headers, payload []byte) {
+	e.lock.Lock()
+	fn, ok := e.methods[method]
+	closed := e.closed
+	if !closed {
+		e.serving.Add(1)
+	}
+	e.lock.Unlock()
+	if closed {
+		return
+	}
+
+	go func() {
+		defer e.serving.Done()
+
+		ctx := context.WithValue(e.ctx, peerKey{}, peer)
+		if headers != nil {
+			ctx = context.WithValue(ctx, headersKey{}, headers)
+		}
+
+		var resp []byte
+		run := func(ctx context.Context) (err error) {
+			if !ok {
+				return errors.New("unknown method")
+			}
+			resp, err = e.call(ctx, fn, peer, payload)
+			return err
+		}
+		var err error
+		if e.config.ServeInterceptor != nil {
+			err = e.config.ServeInterceptor(ctx, peer, method, headers, run)
+		} else {
+			err = run(ctx)
+		}
+
+		data := binary.AppendUvarint([]byte{frameResponse}, id)
+		if err != nil {
+			data[0] = frameError
+			data = append(data, err.Error()...)
+		} else {
+			data = append(data, resp...)
+		}
+		// The peer may be gone by now, then there's nobody to tell.
+		peer.SendAsync(data, options.Channel, options.Flags)
+	}()
+}
+
+// call runs fn, turning a panic into an error for the caller.
+func (e *Endpoint) call(ctx context.Context, fn MethodFunc, peer enet.Peer, payload []byte) (resp []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, peer, payload)
+}
+
+// disconnected fails the calls in flight to peer.
+func (e *Endpoint) disconnected(peer enet.Peer) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for id, call := range e.pending {
+		if call.peer == peer {
+			delete(e.pending, id)
+			call.done <- result{err: ErrDisconnected}
+		}
+	}
+}
+
+// Close fails the calls in flight, cancels the context of the methods running and
+// waits for them to return.
+func (e *Endpoint) Close() error {
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return ErrClosed
+	}
+	e.closed = true
+	for id, call := range e.pending {
+		delete(e.pending, id)
+		call.done <- result{err: ErrClosed}
+	}
+	e.lock.Unlock()
+
+	e.cancel()
+	e.serving.Wait()
+	return nil
+}
+
+func appendString(data []byte, str string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(str)))
+	return append(data, str...)
+}
+
+// readString reads a string written by appendString, returning what follows it.
+func readString(data []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return "", nil, false
+	}
+	return string(data[n : n+int(length)]), data[n+int(length):], true
+}
+
+// readHeaders reads the headers of a request, returning what follows them.
+func readHeaders(data []byte) (Headers, []byte, bool) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, nil, false
+	}
+	data = data[n:]
+
+	headers := make(Headers, count)
+	for range count {
+		var key, value string
+		var ok bool
+		if key, data, ok = readString(data); !ok {
+			return nil, nil, false
+		}
+		if value, data, ok = readString(data); !ok {
+			return nil, nil, false
+		}
+		headers[key] = value
+	}
+	return headers, data, true
+}
+
+// jsonCodec is the default codec of endpoints.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,7 @@
+module github.com/TubbyStubby/go-enet-sharp/enetzstd
+
+go 1.25.0
+
+require github.com/klauspost/compress v1.20.1
+
+replace github.com/TubbyStubby/go-enet-sharp => ../
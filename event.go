@@ -1,7 +1,10 @@
 package enet
 
-// #include "enet.h"
-import "C"
+import (
+	"errors"
+	"io"
+	"time"
+)
 
 // EventType is a type of event
 type EventType int
@@ -35,13 +38,50 @@ const (
 type Event interface {
 	GetType() EventType
 	GetPeer() Peer
+
+	// GetChannelID returns the channel the packet was received on. Only meaningful
+	// for EventReceive.
 	GetChannelID() uint8
+
+	// GetData returns the user supplied data word of the event. For EventConnect this
+	// is the data passed to Host.Connect by the remote side, and for EventDisconnect
+	// it is the data passed to Peer.Disconnect. It can be used, for example, for
+	// protocol version negotiation.
 	GetData() uint32
+
 	GetPacket() Packet
+
+	// GetPacketDataUnsafe returns the received packet payload without copying it.
+	// The returned slice points directly into C memory and is only valid until the
+	// packet is destroyed; it must not be retained or modified after that. Use
+	// GetPacket().GetData() for a copy that is safe to keep. Returns nil if the
+	// event carries no packet.
+	GetPacketDataUnsafe() []byte
+
+	// ReadPayload copies the received packet payload into buf, returning its length,
+	// so receivers reading into buffers of their own, such as pooled ones, don't
+	// allocate a slice per packet as GetPacket().GetData() does. If buf is too short,
+	// the start of the payload is copied and io.ErrShortBuffer returned. Fails if the
+	// event carries no packet, or its packet was destroyed or detached.
+	ReadPayload(buf []byte) (int, error)
+
+	// GetTimestamp returns the monotonic time at which the event was taken out of
+	// enet by Host.Service, before any application code had a chance to run. Use it
+	// instead of time.Now() when measuring latency.
+	GetTimestamp() time.Time
 }
 
 type enetEvent struct {
-	cEvent C.ENetEvent
+	eventBackend
+	timestamp time.Time
+
+	// packet wraps the packet of the backend event, created on first use so every
+	// GetPacket call shares the same destroyed state.
+	packet *enetPacket
+
+	// goEvent is set when the event did not come from the backend, for example when
+	// it was produced by a replay host. All accessors defer to it.
+	goEvent Event
 }
 
 func NewEvent() *enetEvent {
@@ -49,25 +89,102 @@ func NewEvent() *enetEvent {
 }
 
 func (event *enetEvent) GetType() EventType {
-	return (EventType)(event.cEvent._type)
+	if event.goEvent != nil {
+		return event.goEvent.GetType()
+	}
+	return event.backendType()
 }
 
 func (event *enetEvent) GetPeer() Peer {
-	return enetPeer{
-		cPeer: event.cEvent.peer,
+	if event.goEvent != nil {
+		return event.goEvent.GetPeer()
 	}
+	return event.backendPeer()
 }
 
 func (event *enetEvent) GetChannelID() uint8 {
-	return (uint8)(event.cEvent.channelID)
+	if event.goEvent != nil {
+		return event.goEvent.GetChannelID()
+	}
+	return event.backendChannelID()
 }
 
 func (event *enetEvent) GetData() uint32 {
-	return (uint32)(event.cEvent.data)
+	if event.goEvent != nil {
+		return event.goEvent.GetData()
+	}
+	return event.backendData()
 }
 
 func (event *enetEvent) GetPacket() Packet {
-	return enetPacket{
-		cPacket: event.cEvent.packet,
+	if event.goEvent != nil {
+		return event.goEvent.GetPacket()
+	}
+	return event.backendPacket()
+}
+
+func (event *enetEvent) GetPacketDataUnsafe() []byte {
+	if event.goEvent != nil {
+		return event.goEvent.GetPacketDataUnsafe()
+	}
+	return event.backendPacketData()
+}
+
+func (event *enetEvent) ReadPayload(buf []byte) (int, error) {
+	if event.goEvent != nil {
+		return event.goEvent.ReadPayload(buf)
+	}
+	if event.backendType() != EventReceive || event.backendPacketReleased() {
+		return 0, errNoPayload
+	}
+	return readPayload(event.backendPacketData(), buf)
+}
+
+func (event *enetEvent) GetTimestamp() time.Time {
+	if event.goEvent != nil {
+		return event.goEvent.GetTimestamp()
+	}
+	return event.timestamp
+}
+
+var errNoPayload = errors.New("event has no packet")
+
+// readPayload copies payload into buf, see Event.ReadPayload.
+func readPayload(payload, buf []byte) (int, error) {
+	n := copy(buf, payload)
+	if n < len(payload) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// DetachPacket takes the packet of a receive event out of the event, so destroying
+// the packet of the event, as a ServiceLoop does once the handler returns, no longer
+// frees it. The data returned by GetPacketDataUnsafe stays valid until the returned
+// packet is destroyed, which is then up to the caller.
+func DetachPacket(event Event) (Packet, error) {
+	switch e := event.(type) {
+	case *enetEvent:
+		if e.goEvent != nil {
+			return DetachPacket(e.goEvent)
+		}
+		if e.GetType() != EventReceive {
+			return nil, errors.New("event has no packet")
+		}
+		return e.GetPacket().(*enetPacket).detach()
+
+	case *safeEvent:
+		packet, err := DetachPacket(e.Event)
+		if err != nil {
+			return nil, err
+		}
+		return safePacket{Packet: packet, host: e.host}, nil
+	}
+
+	// Other events keep their packets in Go memory, which destroying doesn't free.
+	packet := event.GetPacket()
+	if packet == nil {
+		return nil, errors.New("event has no packet")
 	}
+	return packet, nil
 }
@@ -0,0 +1,60 @@
+//go:build js && wasm
+
+package enet
+
+import "github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+
+// browserEvent is an event of a browser peer, queued by its transport.
+type browserEvent struct {
+	eventType  EventType
+	peer       *browserPeer
+	generation uint32
+	channelID  uint8
+	data       uint32
+	packet     *protocol.Packet
+}
+
+type eventBackend struct {
+	jsEvent browserEvent
+}
+
+func (event *enetEvent) backendType() EventType {
+	return event.jsEvent.eventType
+}
+
+func (event *enetEvent) backendPeer() Peer {
+	return enetPeer{
+		jsPeer: event.jsEvent.peer,
+	}
+}
+
+func (event *enetEvent) backendChannelID() uint8 {
+	return event.jsEvent.channelID
+}
+
+func (event *enetEvent) backendData() uint32 {
+	return event.jsEvent.data
+}
+
+func (event *enetEvent) backendPacket() Packet {
+	if event.packet == nil || event.packet.goPacket != event.jsEvent.packet {
+		event.packet = &enetPacket{
+			goPacket: event.jsEvent.packet,
+		}
+	}
+	return event.packet
+}
+
+// backendPacketReleased returns true if the packet of the event was destroyed or
+// detached through its wrapper.
+func (event *enetEvent) backendPacketReleased() bool {
+	return event.packet != nil && event.packet.goPacket == event.jsEvent.packet && event.packet.released.Load()
+}
+
+func (event *enetEvent) backendPacketData() []byte {
+	packet := event.jsEvent.packet
+	if packet == nil || len(packet.Data) == 0 {
+		return nil
+	}
+	return packet.Data
+}
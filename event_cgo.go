@@ -0,0 +1,52 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+import "C"
+import "unsafe"
+
+type eventBackend struct {
+	cEvent C.ENetEvent
+}
+
+func (event *enetEvent) backendType() EventType {
+	return (EventType)(event.cEvent._type)
+}
+
+func (event *enetEvent) backendPeer() Peer {
+	return enetPeer{
+		cPeer: event.cEvent.peer,
+	}
+}
+
+func (event *enetEvent) backendChannelID() uint8 {
+	return (uint8)(event.cEvent.channelID)
+}
+
+func (event *enetEvent) backendData() uint32 {
+	return (uint32)(event.cEvent.data)
+}
+
+func (event *enetEvent) backendPacket() Packet {
+	if event.packet == nil || event.packet.cPacket != event.cEvent.packet {
+		event.packet = &enetPacket{
+			cPacket: event.cEvent.packet,
+		}
+	}
+	return event.packet
+}
+
+// backendPacketReleased returns true if the packet of the event was destroyed or
+// detached through its wrapper.
+func (event *enetEvent) backendPacketReleased() bool {
+	return event.packet != nil && event.packet.cPacket == event.cEvent.packet && event.packet.released.Load()
+}
+
+func (event *enetEvent) backendPacketData() []byte {
+	packet := event.cEvent.packet
+	if packet == nil || packet.dataLength == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(packet.data)), int(packet.dataLength))
+}
@@ -0,0 +1,50 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+import "github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+
+type eventBackend struct {
+	goHostEvent protocol.Event
+}
+
+func (event *enetEvent) backendType() EventType {
+	return EventType(event.goHostEvent.Type)
+}
+
+func (event *enetEvent) backendPeer() Peer {
+	return enetPeer{
+		goPeer: event.goHostEvent.Peer,
+	}
+}
+
+func (event *enetEvent) backendChannelID() uint8 {
+	return event.goHostEvent.ChannelID
+}
+
+func (event *enetEvent) backendData() uint32 {
+	return event.goHostEvent.Data
+}
+
+func (event *enetEvent) backendPacket() Packet {
+	if event.packet == nil || event.packet.goPacket != event.goHostEvent.Packet {
+		event.packet = &enetPacket{
+			goPacket: event.goHostEvent.Packet,
+		}
+	}
+	return event.packet
+}
+
+// backendPacketReleased returns true if the packet of the event was destroyed or
+// detached through its wrapper.
+func (event *enetEvent) backendPacketReleased() bool {
+	return event.packet != nil && event.packet.goPacket == event.goHostEvent.Packet && event.packet.released.Load()
+}
+
+func (event *enetEvent) backendPacketData() []byte {
+	packet := event.goHostEvent.Packet
+	if packet == nil || len(packet.Data) == 0 {
+		return nil
+	}
+	return packet.Data
+}
@@ -0,0 +1,98 @@
+package enet
+
+import (
+	"expvar"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// expvarName is the name of the expvar map hosts are published in.
+const expvarName = "enet"
+
+var (
+	expvarLock  sync.Mutex
+	expvarHosts *expvar.Map
+	expvarNext  int
+)
+
+// expvarHost is how the statistics of a host are published.
+type expvarHost struct {
+	BytesSent       uint32       `json:"bytes_sent"`
+	BytesReceived   uint32       `json:"bytes_received"`
+	PacketsSent     uint32       `json:"packets_sent"`
+	PacketsReceived uint32       `json:"packets_received"`
+	ConnectedPeers  uint32       `json:"connected_peers"`
+	QueuedPackets   uint32       `json:"queued_packets"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	Peers           []expvarPeer `json:"peers"`
+}
+
+type expvarPeer struct {
+	ID              uint32  `json:"id"`
+	Address         string  `json:"address"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	PacketsSent     uint64  `json:"packets_sent"`
+	PacketsLost     uint64  `json:"packets_lost"`
+	RoundTripTimeMs float64 `json:"round_trip_time_ms"`
+	QueuedCommands  int     `json:"queued_commands"`
+}
+
+// PublishExpvars publishes the statistics of host and of its peers with expvar, so
+// they are served by its /debug/vars endpoint. Hosts are published in the "enet" map,
+// keyed by the order they were published in from "0" on, and the key of host is
+// returned. The statistics are those of Host.GetStats and GetPeerStats, which this
+// starts tracking, and stay published once the host is destroyed.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func PublishExpvars(host Host) (string, error) {
+	if err := TrackPeerStats(host); err != nil {
+		return "", err
+	}
+
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+
+	if expvarHosts == nil {
+		expvarHosts = expvar.NewMap(expvarName)
+	}
+	key := strconv.Itoa(expvarNext)
+	expvarNext++
+	expvarHosts.Set(key, expvar.Func(func() any {
+		return newExpvarHost(host)
+	}))
+	return key, nil
+}
+
+func newExpvarHost(host Host) expvarHost {
+	stats := host.GetStats()
+	ret := expvarHost{
+		BytesSent:       stats.BytesSent,
+		BytesReceived:   stats.BytesReceived,
+		PacketsSent:     stats.PacketsSent,
+		PacketsReceived: stats.PacketsReceived,
+		ConnectedPeers:  stats.ConnectedPeers,
+		QueuedPackets:   stats.QueuedPackets,
+		UpdatedAt:       stats.UpdatedAt,
+		Peers:           []expvarPeer{},
+	}
+	for _, peer := range GetPeerStats(host) {
+		var addr string
+		if peer.Address != nil {
+			addr = net.JoinHostPort(peer.Address.String(), strconv.Itoa(int(peer.Address.GetPort())))
+		}
+		ret.Peers = append(ret.Peers, expvarPeer{
+			ID:              peer.ID,
+			Address:         addr,
+			BytesSent:       peer.BytesSent,
+			BytesReceived:   peer.BytesReceived,
+			PacketsSent:     peer.PacketsSent,
+			PacketsLost:     peer.PacketsLost,
+			RoundTripTimeMs: float64(peer.RoundTripTime) / float64(time.Millisecond),
+			QueuedCommands:  peer.QueuedCommands,
+		})
+	}
+	return ret
+}
@@ -0,0 +1,217 @@
+package enet
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// hostFeature is a feature of a host hooking into its service path. Features join the
+// host as they are first enabled, so servicing a host only walks the features in use.
+type hostFeature int
+
+// The features, in the order their hooks of each kind run.
+const (
+	featureConditions hostFeature = iota
+	featureBans
+	featureConfig
+	featureTokens
+	featurePunches
+	featureNoise
+	featureClocks
+	featureStreams
+	featureVersions
+	featureCompression
+	featureLatency
+	featureTags
+	featureBandwidth
+)
+
+// featureHooks are the hooks of a feature in the service path, any of which may be nil.
+type featureHooks struct {
+	feature hostFeature
+
+	// send runs before the backend is serviced, sending what is due.
+	send func(host *enetHost)
+	// release runs before the backend is serviced too, after every send hook, as it
+	// may cut the service short. Returns true if it filled event with one it held
+	// back, to be returned without servicing the backend.
+	release func(host *enetHost, event *enetEvent) bool
+	// intercept is offered the events of the backend. Returns true if it consumed the
+	// event, which must not be returned to the application.
+	intercept func(host *enetHost, event *enetEvent) bool
+	// serviced sees every event returned to the application.
+	serviced func(host *enetHost, event *enetEvent)
+}
+
+// hooks returns the hooks of the feature.
+func (feature hostFeature) hooks() featureHooks {
+	hooks := featureHooks{feature: feature}
+	switch feature {
+	case featureConditions:
+		hooks.send = func(host *enetHost) {
+			host.conditions.release(host)
+		}
+		hooks.serviced = func(host *enetHost, event *enetEvent) {
+			host.conditions.forget(event)
+		}
+	case featureBans:
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.bans.admit(host, event)
+		}
+	case featureConfig:
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.config.admit(host, event)
+		}
+	case featureTokens:
+		hooks.send = func(host *enetHost) {
+			host.tokens.resend(host)
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			host.tokens.observe(event)
+			return false
+		}
+	case featurePunches:
+		hooks.send = func(host *enetHost) {
+			host.punches.resend(host)
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			host.punches.observe(event)
+			return false
+		}
+	case featureNoise:
+		hooks.release = func(host *enetHost, event *enetEvent) bool {
+			host.noise.expire()
+			for host.noise.next(event) {
+				// Identities are only known once handshakes complete.
+				if !host.bans.admit(host, event) {
+					return true
+				}
+			}
+			return false
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.noise.intercept(event)
+		}
+	case featureClocks:
+		hooks.send = func(host *enetHost) {
+			host.clocks.send(host)
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.clocks.intercept(host, event)
+		}
+	case featureStreams:
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.streams.intercept(event)
+		}
+	case featureVersions:
+		hooks.release = func(host *enetHost, event *enetEvent) bool {
+			host.versions.expire()
+			for host.versions.next(event) {
+				// Peers accepted go on to negotiate compression.
+				if !host.compression.intercept(host, event) {
+					return true
+				}
+			}
+			return false
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.versions.intercept(event)
+		}
+	case featureCompression:
+		hooks.release = func(host *enetHost, event *enetEvent) bool {
+			host.compression.expire()
+			return host.compression.next(event)
+		}
+		hooks.intercept = func(host *enetHost, event *enetEvent) bool {
+			return host.compression.intercept(host, event)
+		}
+	case featureLatency:
+		hooks.serviced = func(host *enetHost, event *enetEvent) {
+			host.latency.forget(event)
+		}
+	case featureTags:
+		hooks.serviced = func(host *enetHost, event *enetEvent) {
+			host.tags.forget(event)
+		}
+	case featureBandwidth:
+		hooks.serviced = func(host *enetHost, event *enetEvent) {
+			host.bandwidth.forget(event)
+		}
+	}
+	return hooks
+}
+
+// featureList holds the features a host joined, sorted in the order their hooks run.
+// Features can join from any goroutine, servicing the host reads the list without
+// locking.
+type featureList struct {
+	lock   sync.Mutex
+	mask   atomic.Uint32
+	joined atomic.Pointer[[]featureHooks]
+}
+
+// join adds feature to the list if it isn't in it yet. It is cheap once the feature
+// joined, for features enabled as they are used.
+func (list *featureList) join(feature hostFeature) {
+	if list.mask.Load()&(1<<feature) != 0 {
+		return
+	}
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	current := list.hooks()
+	i, found := slices.BinarySearchFunc(current, feature, func(hooks featureHooks, feature hostFeature) int {
+		return int(hooks.feature - feature)
+	})
+	if found {
+		return
+	}
+	joined := slices.Insert(slices.Clone(current), i, feature.hooks())
+	list.joined.Store(&joined)
+	list.mask.Or(1 << feature)
+}
+
+// hooks returns the hooks of the features joined, which must not be modified.
+func (list *featureList) hooks() []featureHooks {
+	if joined := list.joined.Load(); joined != nil {
+		return *joined
+	}
+	return nil
+}
+
+// prepare runs the send then the release hooks of the features joined, see
+// featureHooks. Returns true if a release hook filled event.
+func (list *featureList) prepare(host *enetHost, event *enetEvent) bool {
+	joined := list.hooks()
+	for _, hooks := range joined {
+		if hooks.send != nil {
+			hooks.send(host)
+		}
+	}
+	for _, hooks := range joined {
+		if hooks.release != nil && hooks.release(host, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// intercept runs the intercept hooks of the features joined, see featureHooks.
+func (list *featureList) intercept(host *enetHost, event *enetEvent) bool {
+	for _, hooks := range list.hooks() {
+		if hooks.intercept != nil && hooks.intercept(host, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviced runs the serviced hooks of the features joined, see featureHooks.
+func (list *featureList) serviced(host *enetHost, event *enetEvent) {
+	for _, hooks := range list.hooks() {
+		if hooks.serviced != nil {
+			hooks.serviced(host, event)
+		}
+	}
+}
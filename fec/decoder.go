@@ -0,0 +1,196 @@
+package fec
+
+import (
+	"encoding/binary"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// decoderGroup is a group of packets received from a peer on a channel.
+type decoderGroup struct {
+	// data holds the shards of the data packets received or recovered, by index.
+	data map[int][]byte
+
+	// parity holds the parity packets received, by index, k and m being set once the
+	// first one arrives.
+	parity map[int][]byte
+	k, m   int
+}
+
+// decoderStream holds the groups received from a peer on a channel.
+type decoderStream struct {
+	groups map[uint16]*decoderGroup
+	newest uint16
+}
+
+// Decoder decodes packets sent by an Encoder, recovering those lost, see the package
+// documentation. It is not safe for concurrent use, and must be used by the goroutine
+// servicing the host of its peers.
+type Decoder struct {
+	config    Config
+	streams   map[groupKey]*decoderStream
+	recovered uint64
+}
+
+// NewDecoder creates a decoder
+func NewDecoder(config Config) (*Decoder, error) {
+	config, err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{config: config, streams: make(map[groupKey]*decoderStream)}, nil
+}
+
+// Recovered returns the number of lost packets recovered
+func (d *Decoder) Recovered() uint64 {
+	return d.recovered
+}
+
+// Handle decodes the packets received on protected channels, returning the packets to
+// deliver and true if the event carried one: the packet of the event unless it is a
+// parity packet, and the packets recovered thanks to it. Peers are forgotten once
+// they disconnect. The packet of the event is left to the caller.
+func (d *Decoder) Handle(event enet.Event) ([][]byte, bool) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		for key := range d.streams {
+			if key.peer == event.GetPeer() {
+				delete(d.streams, key)
+			}
+		}
+		return nil, false
+	case enet.EventReceive:
+	default:
+		return nil, false
+	}
+	if _, ok := d.config.Channels[event.GetChannelID()]; !ok {
+		return nil, false
+	}
+	packets, _ := d.Receive(event.GetPeer(), event.GetChannelID(), event.GetPacket().GetData())
+	return packets, true
+}
+
+// Receive decodes a packet received from peer on a protected channel as Handle does,
+// for applications receiving them themselves
+func (d *Decoder) Receive(peer enet.Peer, channel uint8, data []byte) ([][]byte, error) {
+	if len(data) < dataHeaderSize {
+		return nil, errMalformed
+	}
+	key := groupKey{peer, channel}
+	s, ok := d.streams[key]
+	if !ok {
+		s = &decoderStream{groups: make(map[uint16]*decoderGroup)}
+		d.streams[key] = s
+	}
+
+	group := binary.BigEndian.Uint16(data)
+	if int16(s.newest-group) >= window {
+		return nil, nil
+	}
+	if int16(group-s.newest) > 0 || len(s.groups) == 0 {
+		s.newest = group
+		for old := range s.groups {
+			if int16(s.newest-old) >= window {
+				delete(s.groups, old)
+			}
+		}
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		g = &decoderGroup{data: make(map[int][]byte), parity: make(map[int][]byte)}
+		s.groups[group] = g
+	}
+
+	index := int(data[2])
+	var packets [][]byte
+	if data[3] == 0 {
+		if _, ok := g.data[index]; ok {
+			return nil, nil
+		}
+		packet := data[dataHeaderSize:]
+		shard := binary.BigEndian.AppendUint16(make([]byte, 0, lengthSize+len(packet)), uint16(len(packet)))
+		g.data[index] = append(shard, packet...)
+		packets = append(packets, append([]byte(nil), packet...))
+	} else {
+		if len(data) < parityHeaderSize+lengthSize {
+			return nil, errMalformed
+		}
+		k, m := int(data[3]), int(data[4])
+		if index >= m || (g.k != 0 && (k != g.k || m != g.m)) {
+			return nil, errMalformed
+		}
+		g.k, g.m = k, m
+		g.parity[index] = append([]byte(nil), data[parityHeaderSize:]...)
+	}
+
+	recovered, err := g.recover()
+	if err != nil {
+		return packets, err
+	}
+	d.recovered += uint64(len(recovered))
+	return append(packets, recovered...), nil
+}
+
+// recover recovers the data packets of g that are missing, if enough packets of it
+// arrived, and returns them.
+func (g *decoderGroup) recover() ([][]byte, error) {
+	if g.k == 0 {
+		return nil, nil
+	}
+	var missing []int
+	for i := 0; i < g.k; i++ {
+		if _, ok := g.data[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 || len(missing) > len(g.parity) {
+		return nil, nil
+	}
+
+	// Take as many parity packets as packets are missing, subtract the packets that
+	// arrived from them, and solve for the missing ones.
+	var size int
+	rows := make([]int, 0, len(missing))
+	for j, parity := range g.parity {
+		if len(rows) < len(missing) {
+			rows = append(rows, j)
+			size = len(parity)
+		}
+	}
+	matrix := make([][]byte, len(rows))
+	sums := make([][]byte, len(rows))
+	for r, j := range rows {
+		if len(g.parity[j]) != size {
+			return nil, errMalformed
+		}
+		matrix[r] = make([]byte, len(missing))
+		for c, i := range missing {
+			matrix[r][c] = coefficient(j, i, g.m)
+		}
+		sums[r] = append([]byte(nil), g.parity[j]...)
+		for i, shard := range g.data {
+			if i >= g.k || len(shard) > size {
+				return nil, errMalformed
+			}
+			mulAdd(sums[r], shard, coefficient(j, i, g.m))
+		}
+	}
+	if err := invert(matrix); err != nil {
+		return nil, err
+	}
+
+	packets := make([][]byte, 0, len(missing))
+	for c, i := range missing {
+		shard := make([]byte, size)
+		for r := range rows {
+			mulAdd(shard, sums[r], matrix[c][r])
+		}
+		n := int(binary.BigEndian.Uint16(shard))
+		if n > size-lengthSize {
+			return nil, errMalformed
+		}
+		g.data[i] = shard[:lengthSize+n]
+		packets = append(packets, append([]byte(nil), shard[lengthSize:lengthSize+n]...))
+	}
+	return packets, nil
+}
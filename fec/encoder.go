@@ -0,0 +1,117 @@
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// encoderGroup is the group of packets being sent to a peer on a channel.
+type encoderGroup struct {
+	group  uint16
+	shards [][]byte
+}
+
+// Encoder sends packets with forward error correction, see the package documentation.
+// It is not safe for concurrent use, and must be used by the goroutine servicing the
+// host of its peers.
+type Encoder struct {
+	config Config
+	groups map[groupKey]*encoderGroup
+}
+
+// NewEncoder creates an encoder
+func NewEncoder(config Config) (*Encoder, error) {
+	config, err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{config: config, groups: make(map[groupKey]*encoderGroup)}, nil
+}
+
+// Send sends data to peer on channel, followed by the parity packets of its group if it
+// completes it. Packets on channels not protected are sent as they are, unreliably.
+func (e *Encoder) Send(peer enet.Peer, data []byte, channel uint8) error {
+	c, ok := e.config.Channels[channel]
+	if !ok {
+		return peer.SendBytes(data, channel, 0)
+	}
+	if len(data) > MaxPacketSize {
+		return ErrPacketSize
+	}
+
+	key := groupKey{peer, channel}
+	g, ok := e.groups[key]
+	if !ok {
+		g = &encoderGroup{}
+		e.groups[key] = g
+	}
+	packet := appendDataHeader(make([]byte, 0, dataHeaderSize+len(data)), g.group, len(g.shards))
+	err := peer.SendBytes(append(packet, data...), channel, c.Flags)
+
+	shard := binary.BigEndian.AppendUint16(make([]byte, 0, lengthSize+len(data)), uint16(len(data)))
+	g.shards = append(g.shards, append(shard, data...))
+	if len(g.shards) == c.GroupSize {
+		err = errors.Join(err, e.sendParity(key, g))
+	}
+	return err
+}
+
+// Flush sends the parity packets of the groups not yet complete, so their packets can
+// be recovered without waiting for more to be sent. It is best called once per tick,
+// after the packets of the tick have been sent.
+func (e *Encoder) Flush() error {
+	var errs []error
+	for key, g := range e.groups {
+		if len(g.shards) > 0 {
+			errs = append(errs, e.sendParity(key, g))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemovePeer forgets the groups of peer. Handle does it for the disconnect events it
+// sees.
+func (e *Encoder) RemovePeer(peer enet.Peer) {
+	for key := range e.groups {
+		if key.peer == peer {
+			delete(e.groups, key)
+		}
+	}
+}
+
+// Handle forgets peers once they disconnect. It can be called for every event
+// serviced.
+func (e *Encoder) Handle(event enet.Event) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		e.RemovePeer(event.GetPeer())
+	}
+}
+
+// sendParity sends the parity packets of g and starts the next group.
+func (e *Encoder) sendParity(key groupKey, g *encoderGroup) error {
+	c := e.config.Channels[key.channel]
+	k := len(g.shards)
+	size := 0
+	for _, shard := range g.shards {
+		size = max(size, len(shard))
+	}
+
+	var errs []error
+	for j := 0; j < c.Parity; j++ {
+		packet := make([]byte, parityHeaderSize+size)
+		appendParityHeader(packet[:0], g.group, j, k, c.Parity)
+		for i, shard := range g.shards {
+			mulAdd(packet[parityHeaderSize:], shard, coefficient(j, i, c.Parity))
+		}
+		if err := key.peer.SendBytes(packet, key.channel, c.Flags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	g.group++
+	g.shards = g.shards[:0]
+	return errors.Join(errs...)
+}
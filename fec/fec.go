@@ -0,0 +1,132 @@
+// Package fec adds forward error correction to unreliable channels. Packets are sent in
+// groups of K, each followed by parity packets computed from them, so receivers recover
+// lost packets of a group from the packets that did arrive, instead of waiting for a
+// retransmission that unreliable packets never get. With one parity packet per group
+// it is the XOR of the group, which recovers any single loss; with M parity packets,
+// Reed-Solomon codes recover up to M losses:
+//
+//	config := fec.Config{Channels: map[uint8]fec.ChannelConfig{
+//		0: {GroupSize: 4, Parity: 1},
+//		1: {GroupSize: 8, Parity: 2},
+//	}}
+//	encoder, err := fec.NewEncoder(config)
+//
+//	// On the sender, every tick:
+//	encoder.Send(peer, state, 0)
+//	encoder.Flush()
+//
+// The receiver decodes the packets of the protected channels, getting the packets that
+// arrived and those recovered:
+//
+//	decoder, err := fec.NewDecoder(config)
+//
+//	// For every event:
+//	if packets, ok := decoder.Handle(event); ok {
+//		for _, data := range packets {
+//			apply(data)
+//		}
+//		return
+//	}
+//
+// Packets are delivered as soon as they arrive, and recovered ones as soon as enough of
+// their group did, so recovered packets may be delivered after packets sent after
+// them.
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultGroupSize is the number of packets of a group by default
+	DefaultGroupSize = 4
+
+	// MaxPacketSize is the size of the largest packet sent on protected channels
+	MaxPacketSize = 1<<16 - 1
+
+	// dataHeaderSize is the size of the group and index leading data packets, followed
+	// by a zero.
+	dataHeaderSize = 4
+
+	// parityHeaderSize is the size of the group, index, number of data packets and
+	// number of parity packets leading parity packets.
+	parityHeaderSize = 5
+
+	// lengthSize is the size of the length of data packets, leading them in their
+	// shards, which parity packets are computed from.
+	lengthSize = 2
+
+	// window is the number of groups decoders keep, older ones being given up on.
+	window = 32
+)
+
+var (
+	// ErrPacketSize is returned for packets larger than MaxPacketSize sent on protected
+	// channels
+	ErrPacketSize = errors.New("packet is too large for forward error correction")
+
+	errMalformed = errors.New("malformed fec packet")
+)
+
+// ChannelConfig configures forward error correction on a channel
+type ChannelConfig struct {
+	// GroupSize is the number of packets of a group, which parity packets are sent
+	// for. The larger it is, the less bandwidth parity takes, and the longer lost
+	// packets take to recover. Defaults to DefaultGroupSize.
+	GroupSize int
+
+	// Parity is the number of parity packets sent for every group, the number of lost
+	// packets of a group that can be recovered. Defaults to 1. GroupSize and Parity
+	// add up to at most 256.
+	Parity int
+
+	// Flags are the flags packets are sent with, which must not make them reliable
+	Flags enet.PacketFlags
+}
+
+// Config configures an Encoder or a Decoder
+type Config struct {
+	// Channels maps the channels protected by forward error correction to their
+	// configuration. Encoders and decoders must agree on them.
+	Channels map[uint8]ChannelConfig
+}
+
+// validate applies the defaults of config and checks it.
+func (config Config) validate() (Config, error) {
+	channels := make(map[uint8]ChannelConfig, len(config.Channels))
+	for channel, c := range config.Channels {
+		if c.GroupSize == 0 {
+			c.GroupSize = DefaultGroupSize
+		}
+		if c.Parity == 0 {
+			c.Parity = 1
+		}
+		if c.GroupSize < 1 || c.Parity < 1 || c.GroupSize+c.Parity > 256 {
+			return Config{}, errors.New("invalid group size or parity")
+		}
+		if c.Flags&enet.PacketFlagReliable != 0 {
+			return Config{}, errors.New("reliable channels cannot be protected")
+		}
+		channels[channel] = c
+	}
+	return Config{Channels: channels}, nil
+}
+
+// groupKey identifies the groups of packets of a peer on a channel.
+type groupKey struct {
+	peer    enet.Peer
+	channel uint8
+}
+
+func appendDataHeader(data []byte, group uint16, index int) []byte {
+	data = binary.BigEndian.AppendUint16(data, group)
+	return append(data, byte(index), 0)
+}
+
+func appendParityHeader(data []byte, group uint16, index, k, m int) []byte {
+	data = binary.BigEndian.AppendUint16(data, group)
+	return append(data, byte(index), byte(k), byte(m))
+}
@@ -0,0 +1,99 @@
+package fec
+
+import "errors"
+
+// Arithmetic in GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1, where addition
+// is XOR.
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// mulAdd adds c times src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	switch c {
+	case 0:
+	case 1:
+		for i, b := range src {
+			dst[i] ^= b
+		}
+	default:
+		logC := int(gfLog[c])
+		for i, b := range src {
+			if b != 0 {
+				dst[i] ^= gfExp[int(gfLog[b])+logC]
+			}
+		}
+	}
+}
+
+// coefficient returns the coefficient of data packet i in parity packet j of a group
+// with m parity packets. They form a Cauchy matrix, so any k packets of a group are
+// enough to recover its k data packets, with its columns scaled for the first parity
+// packet to be the XOR of the data packets.
+func coefficient(j, i, m int) byte {
+	y := byte(m + i)
+	return gfMul(gfInv(byte(j)^y), y)
+}
+
+var errSingular = errors.New("singular matrix")
+
+// invert inverts the square matrix a in place.
+func invert(a [][]byte) error {
+	n := len(a)
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = make([]byte, n)
+		inverse[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return errSingular
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inverse[col], inverse[pivot] = inverse[pivot], inverse[col]
+
+		scale := gfInv(a[col][col])
+		for i := range n {
+			a[col][i] = gfMul(a[col][i], scale)
+			inverse[col][i] = gfMul(inverse[col][i], scale)
+		}
+		for row := 0; row < n; row++ {
+			if row != col && a[row][col] != 0 {
+				c := a[row][col]
+				mulAdd(a[row], a[col], c)
+				mulAdd(inverse[row], inverse[col], c)
+			}
+		}
+	}
+	copy(a, inverse)
+	return nil
+}
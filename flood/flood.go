@@ -0,0 +1,308 @@
+// Package flood defends servers from peers flooding them with packets or garbage. A
+// Guard measures the rate every peer sends at, and the malformed messages the
+// application reports, and acts on peers exceeding its thresholds: warn, throttle them
+// by dropping their packets for a while, disconnect them or ban them:
+//
+//	guard := flood.New(flood.Config{
+//		Thresholds: []flood.Threshold{
+//			{PacketsPerSecond: 200, Action: flood.ActionWarn},
+//			{PacketsPerSecond: 500, BytesPerSecond: 256 << 10, Action: flood.ActionThrottle},
+//			{PacketsPerSecond: 2000, Malformed: 20, Action: flood.ActionDisconnect},
+//		},
+//		OnViolation: func(v flood.Violation) { log.Println(v.Peer.GetAddress(), v.Action, v.Rate) },
+//	})
+//
+//	// For every event, on the goroutine servicing the host:
+//	if guard.Handle(event) {
+//		if event.GetType() == enet.EventReceive {
+//			event.GetPacket().Destroy()
+//		}
+//		return
+//	}
+//	msg, err := decode(event.GetPacket().GetData())
+//	if err != nil {
+//		guard.ReportMalformed(event.GetPeer())
+//	}
+package flood
+
+import (
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultWindow is the span rates are measured over by default
+	DefaultWindow = time.Second
+
+	// DefaultThrottleDuration is how long the packets of throttled peers are dropped
+	// for by default
+	DefaultThrottleDuration = 5 * time.Second
+)
+
+// Action is what a Guard does to a peer exceeding a threshold
+type Action int
+
+const (
+	// ActionWarn only reports the violation
+	ActionWarn Action = iota
+
+	// ActionThrottle drops the packets of the peer for the throttle duration
+	ActionThrottle
+
+	// ActionDisconnect disconnects the peer, dropping its packets until it is
+	ActionDisconnect
+
+	// ActionBan bans the peer, see Config.Ban, then disconnects it
+	ActionBan
+)
+
+func (action Action) String() string {
+	switch action {
+	case ActionWarn:
+		return "warn"
+	case ActionThrottle:
+		return "throttle"
+	case ActionDisconnect:
+		return "disconnect"
+	case ActionBan:
+		return "ban"
+	}
+	return "unknown"
+}
+
+// Rate is the rate a peer sends at
+type Rate struct {
+	PacketsPerSecond float64
+	BytesPerSecond   float64
+
+	// Malformed is the number of malformed messages reported over the window
+	Malformed float64
+}
+
+// Threshold is a limit on the rate of peers, and what to do to those exceeding it. A
+// limit left 0 is not checked.
+type Threshold struct {
+	PacketsPerSecond float64
+	BytesPerSecond   float64
+	Malformed        float64
+
+	Action Action
+}
+
+// exceeded returns true if rate exceeds a limit of threshold.
+func (threshold Threshold) exceeded(rate Rate) bool {
+	return (threshold.PacketsPerSecond > 0 && rate.PacketsPerSecond > threshold.PacketsPerSecond) ||
+		(threshold.BytesPerSecond > 0 && rate.BytesPerSecond > threshold.BytesPerSecond) ||
+		(threshold.Malformed > 0 && rate.Malformed > threshold.Malformed)
+}
+
+// Violation is a threshold exceeded by a peer
+type Violation struct {
+	Peer      enet.Peer
+	Rate      Rate
+	Threshold Threshold
+
+	// Action is the action taken, the most severe of the thresholds exceeded
+	Action Action
+}
+
+// Config configures a Guard
+type Config struct {
+	// Thresholds are the thresholds checked on every packet
+	Thresholds []Threshold
+
+	// Window is the span rates are measured over. Defaults to DefaultWindow.
+	Window time.Duration
+
+	// ThrottleDuration is how long the packets of throttled peers are dropped for.
+	// Defaults to DefaultThrottleDuration.
+	ThrottleDuration time.Duration
+
+	// OnViolation is called as a peer exceeds a threshold, at most once per window and
+	// threshold. It may be nil.
+	OnViolation func(Violation)
+
+	// Ban bans a peer for ActionBan, before it is disconnected, for example by adding its
+	// address to an enet.BanList. It may be nil, ActionBan then only disconnecting peers.
+	Ban func(peer enet.Peer, violation Violation)
+
+	// Clock tells the time rates are measured at. Defaults to the SystemClock.
+	Clock enet.Clock
+}
+
+// counts are the packets, bytes and malformed messages of a peer over a window.
+type counts struct {
+	packets, bytes, malformed float64
+}
+
+// peerState is the rate and standing of a peer.
+type peerState struct {
+	start    time.Time
+	current  counts
+	previous counts
+
+	// fired holds when every threshold was last reported.
+	fired []time.Time
+
+	throttled    time.Time
+	disconnected bool
+}
+
+// Guard guards a host from flooding peers, see the package documentation. It is not
+// safe for concurrent use, and must be used by the goroutine servicing the host.
+type Guard struct {
+	config Config
+	peers  map[enet.Peer]*peerState
+}
+
+// New creates a guard
+func New(config Config) *Guard {
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.ThrottleDuration <= 0 {
+		config.ThrottleDuration = DefaultThrottleDuration
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Guard{config: config, peers: make(map[enet.Peer]*peerState)}
+}
+
+// Handle counts the packets received, checks the thresholds and returns true if the
+// event must be dropped, as its peer is throttled or being disconnected. Peers are
+// forgotten once they disconnect. The packet of the event is left to the caller.
+func (g *Guard) Handle(event enet.Event) bool {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect, enet.EventDisconnect, enet.EventDisconnectTimeout:
+		// A connect event on a peer replaces a connection that might have been dropped
+		// without a disconnect event.
+		delete(g.peers, peer)
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+
+	now := g.config.Clock.Now()
+	p := g.peer(peer, now)
+	p.current.packets++
+	p.current.bytes += float64(len(event.GetPacket().GetData()))
+	g.check(peer, p, now)
+	return p.disconnected || now.Before(p.throttled)
+}
+
+// ReportMalformed reports a malformed message received from peer, counting towards the
+// Malformed limits of the thresholds
+func (g *Guard) ReportMalformed(peer enet.Peer) {
+	now := g.config.Clock.Now()
+	p := g.peer(peer, now)
+	p.current.malformed++
+	g.check(peer, p, now)
+}
+
+// Rate returns the rate peer sends at
+func (g *Guard) Rate(peer enet.Peer) Rate {
+	p, ok := g.peers[peer]
+	if !ok {
+		return Rate{}
+	}
+	now := g.config.Clock.Now()
+	g.roll(p, now)
+	return g.rate(p, now)
+}
+
+// Throttled returns true if the packets of peer are being dropped
+func (g *Guard) Throttled(peer enet.Peer) bool {
+	p, ok := g.peers[peer]
+	return ok && (p.disconnected || g.config.Clock.Now().Before(p.throttled))
+}
+
+func (g *Guard) peer(peer enet.Peer, now time.Time) *peerState {
+	p, ok := g.peers[peer]
+	if !ok {
+		p = &peerState{start: now, fired: make([]time.Time, len(g.config.Thresholds))}
+		g.peers[peer] = p
+	}
+	g.roll(p, now)
+	return p
+}
+
+// roll starts a new window for p once the current one is over.
+func (g *Guard) roll(p *peerState, now time.Time) {
+	elapsed := now.Sub(p.start)
+	if elapsed < g.config.Window {
+		return
+	}
+	if elapsed < 2*g.config.Window {
+		p.previous = p.current
+		p.start = p.start.Add(g.config.Window)
+	} else {
+		p.previous = counts{}
+		p.start = now
+	}
+	p.current = counts{}
+}
+
+// rate estimates the rate of p over the last window, weighing the previous window by
+// how much of it the last window still covers.
+func (g *Guard) rate(p *peerState, now time.Time) Rate {
+	weight := 1 - float64(now.Sub(p.start))/float64(g.config.Window)
+	seconds := g.config.Window.Seconds()
+	return Rate{
+		PacketsPerSecond: (p.previous.packets*weight + p.current.packets) / seconds,
+		BytesPerSecond:   (p.previous.bytes*weight + p.current.bytes) / seconds,
+		Malformed:        p.previous.malformed*weight + p.current.malformed,
+	}
+}
+
+// check acts on the thresholds p exceeds.
+func (g *Guard) check(peer enet.Peer, p *peerState, now time.Time) {
+	if p.disconnected {
+		return
+	}
+	rate := g.rate(p, now)
+
+	var violations []Violation
+	var worst *Threshold
+	for i, threshold := range g.config.Thresholds {
+		if !threshold.exceeded(rate) {
+			continue
+		}
+		if worst == nil || threshold.Action > worst.Action {
+			worst = &g.config.Thresholds[i]
+		}
+		if p.fired[i].IsZero() || now.Sub(p.fired[i]) >= g.config.Window {
+			p.fired[i] = now
+			violations = append(violations, Violation{Peer: peer, Rate: rate, Threshold: threshold})
+		}
+	}
+	if worst == nil {
+		return
+	}
+
+	action := worst.Action
+	switch action {
+	case ActionThrottle:
+		if !now.Before(p.throttled) {
+			p.throttled = now.Add(g.config.ThrottleDuration)
+		}
+	case ActionBan:
+		if g.config.Ban != nil {
+			g.config.Ban(peer, Violation{Peer: peer, Rate: rate, Threshold: *worst, Action: action})
+		}
+		fallthrough
+	case ActionDisconnect:
+		p.disconnected = true
+		peer.Disconnect(0)
+	}
+
+	if g.config.OnViolation != nil {
+		for _, v := range violations {
+			v.Action = action
+			g.config.OnViolation(v)
+		}
+	}
+}
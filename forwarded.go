@@ -0,0 +1,174 @@
+package enet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	forwardedMagic = "ENETFWD\x01"
+
+	// forwardedSize is the size of a forwarded header: the magic, the IPv6 address
+	// and port of the client, a timestamp and the signature.
+	forwardedSize = len(forwardedMagic) + net.IPv6len + 2 + 8 + sha256.Size
+
+	// ForwardedHeaderInterval is how often a relay should repeat the forwarded header
+	// of a client, as servers forget the original address of clients whose header
+	// hasn't been seen for twice as long.
+	ForwardedHeaderInterval = 30 * time.Second
+
+	// forwardedMaxSkew is how far the timestamp of a header may be off, bounding how
+	// long a captured header can be replayed.
+	forwardedMaxSkew = 30 * time.Second
+)
+
+// ForwardedHeader returns the datagram a relay sends to a server to announce the
+// original address of the client whose traffic it forwards. It must be sent from the
+// socket the traffic of that client is forwarded from, before the first datagram and
+// then every ForwardedHeaderInterval. The header is signed with key, which the server
+// must trust with TrustForwardedHeaders.
+func ForwardedHeader(key []byte, client *net.UDPAddr) []byte {
+	b := make([]byte, 0, forwardedSize)
+	b = append(b, forwardedMagic...)
+	b = append(b, client.IP.To16()...)
+	b = binary.BigEndian.AppendUint16(b, uint16(client.Port))
+	b = binary.BigEndian.AppendUint64(b, uint64(time.Now().UnixNano()))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return mac.Sum(b)
+}
+
+// TrustForwardedHeaders makes host accept the headers of relays signing them with key,
+// so RealAddress returns the original address of the clients behind them. Headers are
+// consumed and never reach enet, while headers with a bad signature or timestamp are
+// dropped.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func TrustForwardedHeaders(host Host, key []byte) error {
+	if len(key) == 0 {
+		return errors.New("a key is required")
+	}
+
+	var err error
+	trust := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("forwarded headers are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.forwarded
+		table.lock.Lock()
+		table.key = append([]byte(nil), key...)
+		install := table.addresses == nil
+		if install {
+			table.addresses = make(map[string]forwardedAddress)
+		}
+		table.lock.Unlock()
+
+		if install {
+			h.intercepts.add(h, table.intercept)
+		}
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(trust)
+	} else {
+		trust(host)
+	}
+	return err
+}
+
+// RealAddress returns the original address of the client connected as peer through a
+// trusted relay, or the address of the peer itself if it is not behind one.
+func RealAddress(peer Peer) Address {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if ok {
+		if host := p.host(); host != nil {
+			if addr := host.forwarded.lookup(p.address()); addr != nil {
+				return addr
+			}
+		}
+	}
+	return peer.GetAddress()
+}
+
+type forwardedAddress struct {
+	addr *net.UDPAddr
+	seen time.Time
+}
+
+// forwardedTable maps the addresses of relays to the clients they forward.
+type forwardedTable struct {
+	lock      sync.Mutex
+	key       []byte
+	addresses map[string]forwardedAddress
+	pruned    time.Time
+}
+
+func (table *forwardedTable) intercept(addr rawAddress, data []byte) bool {
+	if len(data) != forwardedSize || !bytes.HasPrefix(data, []byte(forwardedMagic)) {
+		return false
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	signed := data[:forwardedSize-sha256.Size]
+	mac := hmac.New(sha256.New, table.key)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), data[len(signed):]) {
+		return true
+	}
+
+	now := time.Now()
+	header := signed[len(forwardedMagic):]
+	stamp := time.Unix(0, int64(binary.BigEndian.Uint64(header[net.IPv6len+2:])))
+	if skew := now.Sub(stamp); skew > forwardedMaxSkew || skew < -forwardedMaxSkew {
+		return true
+	}
+
+	client := &net.UDPAddr{
+		IP:   append(net.IP(nil), header[:net.IPv6len]...),
+		Port: int(binary.BigEndian.Uint16(header[net.IPv6len:])),
+	}
+	if ip4 := client.IP.To4(); ip4 != nil {
+		client.IP = ip4
+	}
+	table.addresses[udpAddrOf(addr).String()] = forwardedAddress{addr: client, seen: now}
+
+	if now.Sub(table.pruned) > ForwardedHeaderInterval {
+		table.pruned = now
+		for relay, fwd := range table.addresses {
+			if now.Sub(fwd.seen) > 2*ForwardedHeaderInterval {
+				delete(table.addresses, relay)
+			}
+		}
+	}
+	return true
+}
+
+func (table *forwardedTable) lookup(addr rawAddress) Address {
+	table.lock.Lock()
+	fwd, ok := table.addresses[udpAddrOf(addr).String()]
+	table.lock.Unlock()
+
+	if !ok || time.Since(fwd.seen) > 2*ForwardedHeaderInterval {
+		return nil
+	}
+	return NewAddress(fwd.addr.IP.String(), uint16(fwd.addr.Port))
+}
@@ -0,0 +1,155 @@
+package enet
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// framedType is a message type registered with Register
+type framedType struct {
+	opcode uint16
+	codec  Codec
+	typ    reflect.Type
+}
+
+var framing = struct {
+	lock    sync.RWMutex
+	types   map[reflect.Type]framedType
+	opcodes map[uint16]framedType
+}{
+	types:   make(map[reflect.Type]framedType),
+	opcodes: make(map[uint16]framedType),
+}
+
+var framingBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// framingBufferSizeMax is the capacity above which buffers are not pooled.
+const framingBufferSizeMax = 64 * 1024
+
+// Register assigns an opcode and the codec encoding it to the message type T. Send
+// frames messages of the type as the opcode in uvarint format followed by their
+// encoding, so a Dispatcher created with OpcodeUvarint can route them, for example to
+// handlers registered with Handle. Both the opcode and the type can only be
+// registered once.
+func Register[T any](opcode uint16, codec Codec) error {
+	if codec == nil {
+		return fmt.Errorf("no codec for opcode %d", opcode)
+	}
+	typ := reflect.TypeFor[T]()
+
+	framing.lock.Lock()
+	defer framing.lock.Unlock()
+
+	if registered, ok := framing.opcodes[opcode]; ok {
+		return fmt.Errorf("opcode %d is already registered for %s", opcode, registered.typ)
+	}
+	if registered, ok := framing.types[typ]; ok {
+		return fmt.Errorf("%s is already registered with opcode %d", typ, registered.opcode)
+	}
+	registered := framedType{opcode: opcode, codec: codec, typ: typ}
+	framing.types[typ] = registered
+	framing.opcodes[opcode] = registered
+	return nil
+}
+
+func framedTypeOf[T any]() (framedType, error) {
+	typ := reflect.TypeFor[T]()
+
+	framing.lock.RLock()
+	registered, ok := framing.types[typ]
+	framing.lock.RUnlock()
+	if !ok {
+		return framedType{}, fmt.Errorf("%s is not registered", typ)
+	}
+	return registered, nil
+}
+
+// Send sends v reliably to peer, framed with the opcode of its type. The type must
+// have been registered with Register.
+func Send[T any](peer Peer, channel uint8, v T) error {
+	return SendFlags(peer, channel, PacketFlagReliable, v)
+}
+
+// SendFlags is like Send with the given packet flags
+func SendFlags[T any](peer Peer, channel uint8, flags PacketFlags, v T) error {
+	data, release, err := frame(v)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return peer.SendBytes(data, channel, flags)
+}
+
+// Broadcast broadcasts v to all peers of host, framed with the opcode of its type
+func Broadcast[T any](host Host, channel uint8, flags PacketFlags, v T) error {
+	data, release, err := frame(v)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return host.BroadcastBytes(data, channel, flags)
+}
+
+// frame encodes v after its opcode in a pooled buffer, which is returned to the pool
+// by release.
+func frame[T any](v T) ([]byte, func(), error) {
+	registered, err := framedTypeOf[T]()
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := registered.codec.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bp := framingBuffers.Get().(*[]byte)
+	data := AppendOpcode((*bp)[:0], OpcodeUvarint, uint64(registered.opcode))
+	data = append(data, payload...)
+	release := func() {
+		if cap(data) <= framingBufferSizeMax {
+			*bp = data[:0]
+			framingBuffers.Put(bp)
+		}
+	}
+	return data, release, nil
+}
+
+// Decode decodes the payload of a message framed by Send into a T, using the codec T
+// was registered with.
+func Decode[T any](msg *Message) (T, error) {
+	var v T
+	registered, err := framedTypeOf[T]()
+	if err != nil {
+		return v, err
+	}
+	if msg.Opcode != uint64(registered.opcode) {
+		return v, fmt.Errorf("message has opcode %d, not %d of %s", msg.Opcode, registered.opcode, registered.typ)
+	}
+	err = registered.codec.Unmarshal(msg.Payload, &v)
+	return v, err
+}
+
+// Handle registers handler on d for the messages of type T, decoded with the codec T
+// was registered with. The dispatcher must decode opcodes as OpcodeUvarint. Messages
+// that fail to decode are dropped, use Decode in a plain handler to see the error.
+func Handle[T any](d Dispatcher, handler func(msg *Message, v T)) error {
+	registered, err := framedTypeOf[T]()
+	if err != nil {
+		return err
+	}
+
+	d.Handle(uint64(registered.opcode), func(msg *Message) {
+		var v T
+		if err := registered.codec.Unmarshal(msg.Payload, &v); err != nil {
+			return
+		}
+		handler(msg, v)
+	})
+	return nil
+}
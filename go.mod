@@ -1,3 +1,17 @@
 module github.com/TubbyStubby/go-enet-sharp
 
-go 1.21.0
+go 1.24.0
+
+require (
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/google/flatbuffers v25.12.19+incompatible
+	github.com/gorilla/websocket v1.5.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.12
+)
+
+require (
+	github.com/stretchr/testify v1.12.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
@@ -0,0 +1,306 @@
+//go:build unix
+
+package enet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// DefaultHandoverTimeout is how long a handover waits by default for the peers to go
+// quiet, and for the new process to take over
+const DefaultHandoverTimeout = 5 * time.Second
+
+// handoverVersion is the version of the state handed over, both processes must agree
+// on it.
+const handoverVersion = 1
+
+// HandoverConfig configures a Handover
+type HandoverConfig struct {
+	// Timeout bounds how long the peers are given to go quiet, and the new process to
+	// take over. Defaults to DefaultHandoverTimeout.
+	Timeout time.Duration
+
+	// OnEvent is handed the events serviced while waiting for the peers to go quiet. It
+	// may be nil, events are then dropped.
+	OnEvent func(Event)
+
+	// Data is handed to the new process along with the host, for example the state of
+	// the application keyed by peer ID
+	Data []byte
+}
+
+// HandedOver is a host taken over with AcceptHandover
+type HandedOver struct {
+	Host Host
+
+	// Peers are the peers handed over, connected, with the IDs they had
+	Peers []Peer
+
+	// Data is the data of the HandoverConfig of the old process
+	Data []byte
+}
+
+// handoverChannel is the state of a channel of a peer handed over.
+type handoverChannel struct {
+	OutgoingReliable   uint16
+	OutgoingUnreliable uint16
+	IncomingReliable   uint16
+	IncomingUnreliable uint16
+}
+
+// handoverPeer is the state of a peer handed over, enough for the new host to carry on
+// with its connection as long as nothing was in flight.
+type handoverPeer struct {
+	ID                             uint16
+	OutgoingID                     uint16
+	ConnectID                      uint32
+	IncomingSessionID              uint8
+	OutgoingSessionID              uint8
+	Address                        netip.AddrPort
+	IncomingBandwidth              uint32
+	OutgoingBandwidth              uint32
+	MTU                            uint32
+	WindowSize                     uint32
+	RoundTripTime                  uint32
+	RoundTripTimeVariance          uint32
+	PingInterval                   uint32
+	TimeoutLimit                   uint32
+	TimeoutMinimum                 uint32
+	TimeoutMaximum                 uint32
+	OutgoingReliableSequenceNumber uint16
+	IncomingUnsequencedGroup       uint16
+	OutgoingUnsequencedGroup       uint16
+	UnsequencedWindow              [32]uint32
+	Channels                       []handoverChannel
+}
+
+// handoverState is what the old process hands over besides the socket.
+type handoverState struct {
+	Version  int
+	Protocol ProtocolConfig
+	Peers    []handoverPeer
+	Data     []byte
+}
+
+// Handover hands host over to another process, typically a new version of the server
+// binary, which takes it over with AcceptHandover on the other end of conn. The socket
+// of the host is passed along, so it keeps its port and no datagram is lost, and so are
+// its peers, which only see a pause rather than a disconnect.
+//
+// First the host is serviced until every peer is quiet, with every reliable packet
+// acknowledged both ways, handing the events to config.OnEvent. Peers still busy when
+// the timeout expires are left behind, and time out. Peers connecting or disconnecting
+// are left behind too. Then the host is handed over and destroyed once the new process
+// took it over, without its peers noticing. If the new process fails to, the host is
+// left as it was and can be serviced again.
+//
+// Only the connections are handed over: the state of the features of the library, such
+// as streams or synchronized clocks, is not, and hosts encrypting with Noise can't be
+// handed over. Available on Unix, with hosts on UDP sockets.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func Handover(host Host, conn *net.UnixConn, config HandoverConfig) error {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultHandoverTimeout
+	}
+
+	var err error
+	handover := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("only enet hosts can be handed over")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		if h.noise.enabled.Load() {
+			err = errors.New("hosts encrypting with noise can't be handed over")
+			return
+		}
+		err = h.handover(conn, config)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(handover)
+	} else {
+		handover(host)
+	}
+	return err
+}
+
+func (host *enetHost) handover(conn *net.UnixConn, config HandoverConfig) error {
+	deadline := time.Now().Add(config.Timeout)
+	peers, busy := host.handoverPeers()
+	for busy > 0 && time.Now().Before(deadline) {
+		event := host.Service(10)
+		switch {
+		case event.GetType() == EventNone:
+		case config.OnEvent != nil:
+			config.OnEvent(event)
+		case event.GetType() == EventReceive:
+			event.GetPacket().Destroy()
+		}
+		peers, busy = host.handoverPeers()
+	}
+
+	state, err := json.Marshal(handoverState{
+		Version:  handoverVersion,
+		Protocol: host.wireProtocol(),
+		Peers:    peers,
+		Data:     config.Data,
+	})
+	if err != nil {
+		return err
+	}
+	socket, err := host.socketFD()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(socket)
+
+	conn.SetDeadline(deadline.Add(config.Timeout))
+	defer conn.SetDeadline(time.Time{})
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(state)))
+	if _, _, err := conn.WriteMsgUnix(header, syscall.UnixRights(socket), nil); err != nil {
+		return err
+	}
+	if _, err := conn.Write(state); err != nil {
+		return err
+	}
+
+	// The new process acknowledges once it took over, before it services the host.
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+	if ack[0] != 1 {
+		return errors.New("the new process failed to take over the host")
+	}
+	return host.Destroy()
+}
+
+// AcceptHandover takes over the host handed over with Handover on the other end of
+// conn, returning it with the peers handed over. The other parameters are those of
+// NewHost, and peerCount must be at least that of the old host.
+func AcceptHandover(conn *net.UnixConn, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (*HandedOver, error) {
+	header := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(header, oob)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := handoverSocket(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, header[n:]); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	var state handoverState
+	if err := json.Unmarshal(data, &state); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if state.Version != handoverVersion {
+		syscall.Close(fd)
+		return nil, errors.New("unsupported handover version")
+	}
+
+	handed, err := takeOver(fd, state, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		conn.Write([]byte{0})
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{1}); err != nil {
+		// The old process is still servicing the host.
+		handed.Host.Destroy()
+		return nil, err
+	}
+	return handed, nil
+}
+
+// dupSocket duplicates the socket of conn, without touching its blocking mode.
+func dupSocket(conn any) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("the host has no socket to hand over")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	dup, dupErr := -1, error(nil)
+	err = raw.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err != nil {
+		return -1, err
+	}
+	return dup, dupErr
+}
+
+// handoverSocket returns the socket passed along with the state.
+func handoverSocket(oob []byte) (int, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1, err
+	}
+	var fds []int
+	for _, message := range messages {
+		rights, err := syscall.ParseUnixRights(&message)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return -1, errors.New("no socket was handed over")
+	}
+	return fds[0], nil
+}
+
+// takeOver creates a host on the socket fd, which it owns, and restores the peers of
+// state.
+func takeOver(fd int, state handoverState, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (*HandedOver, error) {
+	host, err := newSocketHost(fd, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	if err := host.setProtocol(state.Protocol); err != nil {
+		host.Destroy()
+		return nil, err
+	}
+
+	handed := &HandedOver{Host: host, Data: state.Data}
+	for _, snapshot := range state.Peers {
+		peer, err := host.restorePeer(snapshot)
+		if err != nil {
+			host.Destroy()
+			return nil, err
+		}
+		handed.Peers = append(handed.Peers, peer)
+	}
+	return handed, nil
+}
@@ -0,0 +1,186 @@
+//go:build unix && !purego
+
+package enet
+
+// #include "enet.h"
+import "C"
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// listEmpty returns true if an enet list holds nothing.
+func listEmpty(list *C.ENetList) bool {
+	return list.sentinel.next == &list.sentinel
+}
+
+// quiet returns true if the peer is connected with nothing in flight nor waiting to be
+// dispatched.
+func (peer enetPeer) quiet() bool {
+	p := peer.cPeer
+	if p.state != C.ENET_PEER_STATE_CONNECTED || p.needsDispatch != 0 {
+		return false
+	}
+	if !listEmpty(&p.sentReliableCommands) || !listEmpty(&p.outgoingCommands) || !listEmpty(&p.dispatchedCommands) {
+		return false
+	}
+	channels := unsafe.Slice(p.channels, p.channelCount)
+	for i := range channels {
+		if !listEmpty(&channels[i].incomingReliableCommands) {
+			return false
+		}
+	}
+	return true
+}
+
+// handoverPeers returns the state of the quiet peers of the host, and the number of
+// connected peers that are not.
+func (host *enetHost) handoverPeers() ([]handoverPeer, int) {
+	var peers []handoverPeer
+	busy := 0
+	for _, peer := range host.connectedPeerList() {
+		if !peer.quiet() {
+			busy++
+			continue
+		}
+		p := peer.cPeer
+		snapshot := handoverPeer{
+			ID:                             uint16(p.incomingPeerID),
+			OutgoingID:                     uint16(p.outgoingPeerID),
+			ConnectID:                      uint32(p.connectID),
+			IncomingSessionID:              uint8(p.incomingSessionID),
+			OutgoingSessionID:              uint8(p.outgoingSessionID),
+			Address:                        simAddrKey(udpAddrOf(&p.address)),
+			IncomingBandwidth:              uint32(p.incomingBandwidth),
+			OutgoingBandwidth:              uint32(p.outgoingBandwidth),
+			MTU:                            uint32(p.mtu),
+			WindowSize:                     uint32(p.windowSize),
+			RoundTripTime:                  uint32(p.roundTripTime),
+			RoundTripTimeVariance:          uint32(p.roundTripTimeVariance),
+			PingInterval:                   uint32(p.pingInterval),
+			TimeoutLimit:                   uint32(p.timeoutLimit),
+			TimeoutMinimum:                 uint32(p.timeoutMinimum),
+			TimeoutMaximum:                 uint32(p.timeoutMaximum),
+			OutgoingReliableSequenceNumber: uint16(p.outgoingReliableSequenceNumber),
+			IncomingUnsequencedGroup:       uint16(p.incomingUnsequencedGroup),
+			OutgoingUnsequencedGroup:       uint16(p.outgoingUnsequencedGroup),
+		}
+		for i, word := range p.unsequencedWindow {
+			snapshot.UnsequencedWindow[i] = uint32(word)
+		}
+		for _, channel := range unsafe.Slice(p.channels, p.channelCount) {
+			snapshot.Channels = append(snapshot.Channels, handoverChannel{
+				OutgoingReliable:   uint16(channel.outgoingReliableSequenceNumber),
+				OutgoingUnreliable: uint16(channel.outgoingUnreliableSequenceNumber),
+				IncomingReliable:   uint16(channel.incomingReliableSequenceNumber),
+				IncomingUnreliable: uint16(channel.incomingUnreliableSequenceNumber),
+			})
+		}
+		peers = append(peers, snapshot)
+	}
+	return peers, busy
+}
+
+// socketFD returns a duplicate of the socket of the host.
+func (host *enetHost) socketFD() (int, error) {
+	if host.transport != nil {
+		return dupSocket(host.transport.transport)
+	}
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fd, err := syscall.Dup(int(host.cHost.socket))
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fd)
+	return fd, nil
+}
+
+// newSocketHost creates a host on the socket fd, which it owns.
+func newSocketHost(fd int, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (*enetHost, error) {
+	host := C.enet_host_create(
+		nil,
+		(C.size_t)(peerCount),
+		(C.size_t)(channelLimit),
+		(C.uint32_t)(incomingBandwidth),
+		(C.uint32_t)(outgoingBandwidth),
+		0,
+	)
+	if host == nil {
+		syscall.Close(fd)
+		return nil, errors.New("unable to create host")
+	}
+	C.enet_host_set_socket(host, C.ENetSocket(fd))
+
+	ret := &enetHost{
+		hostBackend: hostBackend{cHost: host},
+	}
+	registerHost(host, ret)
+	leakTrack(unsafe.Pointer(host), leakHost)
+	return ret, nil
+}
+
+// restorePeer connects the peer of the host with the ID of snapshot, carrying on with
+// the connection of the snapshot.
+func (host *enetHost) restorePeer(snapshot handoverPeer) (enetPeer, error) {
+	if uint64(snapshot.ID) >= uint64(host.cHost.peerCount) {
+		return enetPeer{}, errors.New("peer ID out of range")
+	}
+	if len(snapshot.Channels) < C.ENET_PROTOCOL_MINIMUM_CHANNEL_COUNT || len(snapshot.Channels) > C.ENET_PROTOCOL_MAXIMUM_CHANNEL_COUNT {
+		return enetPeer{}, errors.New("invalid channel count")
+	}
+	p := &unsafe.Slice(host.cHost.peers, host.cHost.peerCount)[snapshot.ID]
+	if p.state != C.ENET_PEER_STATE_DISCONNECTED {
+		return enetPeer{}, errors.New("peer is in use")
+	}
+
+	p.channels = (*C.ENetChannel)(C.enet_malloc(C.size_t(len(snapshot.Channels)) * C.sizeof_ENetChannel))
+	if p.channels == nil {
+		return enetPeer{}, errors.New("out of memory")
+	}
+	p.channelCount = C.size_t(len(snapshot.Channels))
+	channels := unsafe.Slice(p.channels, p.channelCount)
+	for i := range channels {
+		c := &channels[i]
+		*c = C.ENetChannel{}
+		c.outgoingReliableSequenceNumber = C.uint16_t(snapshot.Channels[i].OutgoingReliable)
+		c.outgoingUnreliableSequenceNumber = C.uint16_t(snapshot.Channels[i].OutgoingUnreliable)
+		c.incomingReliableSequenceNumber = C.uint16_t(snapshot.Channels[i].IncomingReliable)
+		c.incomingUnreliableSequenceNumber = C.uint16_t(snapshot.Channels[i].IncomingUnreliable)
+		C.enet_list_clear(&c.incomingReliableCommands)
+		C.enet_list_clear(&c.incomingUnreliableCommands)
+	}
+
+	p.outgoingPeerID = C.uint16_t(snapshot.OutgoingID)
+	p.connectID = C.uint32_t(snapshot.ConnectID)
+	p.incomingSessionID = C.uint8_t(snapshot.IncomingSessionID)
+	p.outgoingSessionID = C.uint8_t(snapshot.OutgoingSessionID)
+	p.address = enetAddressOf(net.UDPAddrFromAddrPort(snapshot.Address))
+	p.incomingBandwidth = C.uint32_t(snapshot.IncomingBandwidth)
+	p.outgoingBandwidth = C.uint32_t(snapshot.OutgoingBandwidth)
+	p.mtu = C.uint32_t(snapshot.MTU)
+	p.windowSize = C.uint32_t(snapshot.WindowSize)
+	p.roundTripTime = C.uint32_t(snapshot.RoundTripTime)
+	p.roundTripTimeVariance = C.uint32_t(snapshot.RoundTripTimeVariance)
+	p.lastRoundTripTime = C.uint32_t(snapshot.RoundTripTime)
+	p.lowestRoundTripTime = C.uint32_t(snapshot.RoundTripTime)
+	p.pingInterval = C.uint32_t(snapshot.PingInterval)
+	p.timeoutLimit = C.uint32_t(snapshot.TimeoutLimit)
+	p.timeoutMinimum = C.uint32_t(snapshot.TimeoutMinimum)
+	p.timeoutMaximum = C.uint32_t(snapshot.TimeoutMaximum)
+	p.outgoingReliableSequenceNumber = C.uint16_t(snapshot.OutgoingReliableSequenceNumber)
+	p.incomingUnsequencedGroup = C.uint16_t(snapshot.IncomingUnsequencedGroup)
+	p.outgoingUnsequencedGroup = C.uint16_t(snapshot.OutgoingUnsequencedGroup)
+	for i, word := range snapshot.UnsequencedWindow {
+		p.unsequencedWindow[i] = C.uint32_t(word)
+	}
+
+	now := C.enet_host_time(host.cHost)
+	p.lastSendTime = now
+	p.lastReceiveTime = now
+	C.enet_peer_on_connect(p)
+	p.state = C.ENET_PEER_STATE_CONNECTED
+	return enetPeer{cPeer: p}, nil
+}
@@ -0,0 +1,117 @@
+//go:build unix && purego
+
+package enet
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+// handoverPeers returns the state of the quiet peers of the host, and the number of
+// connected peers that are not.
+func (host *enetHost) handoverPeers() ([]handoverPeer, int) {
+	var peers []handoverPeer
+	busy := 0
+	for _, peer := range host.connectedPeerList() {
+		if !peer.goPeer.Quiet() {
+			busy++
+			continue
+		}
+		p := peer.goPeer.Snapshot()
+		snapshot := handoverPeer{
+			ID:                             p.ID,
+			OutgoingID:                     p.OutgoingID,
+			ConnectID:                      p.ConnectID,
+			IncomingSessionID:              p.IncomingSessionID,
+			OutgoingSessionID:              p.OutgoingSessionID,
+			Address:                        simAddrKey(p.Address.UDPAddr()),
+			IncomingBandwidth:              p.IncomingBandwidth,
+			OutgoingBandwidth:              p.OutgoingBandwidth,
+			MTU:                            p.MTU,
+			WindowSize:                     p.WindowSize,
+			RoundTripTime:                  p.RoundTripTime,
+			RoundTripTimeVariance:          p.RoundTripTimeVariance,
+			PingInterval:                   p.PingInterval,
+			TimeoutLimit:                   p.TimeoutLimit,
+			TimeoutMinimum:                 p.TimeoutMinimum,
+			TimeoutMaximum:                 p.TimeoutMaximum,
+			OutgoingReliableSequenceNumber: p.OutgoingReliableSequenceNumber,
+			IncomingUnsequencedGroup:       p.IncomingUnsequencedGroup,
+			OutgoingUnsequencedGroup:       p.OutgoingUnsequencedGroup,
+			UnsequencedWindow:              p.UnsequencedWindow,
+		}
+		for _, channel := range p.Channels {
+			snapshot.Channels = append(snapshot.Channels, handoverChannel(channel))
+		}
+		peers = append(peers, snapshot)
+	}
+	return peers, busy
+}
+
+// socketFD returns a duplicate of the socket of the host.
+func (host *enetHost) socketFD() (int, error) {
+	return dupSocket(host.goHost.Conn())
+}
+
+// newSocketHost creates a host on the socket fd, which it owns.
+func newSocketHost(fd int, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (*enetHost, error) {
+	file := os.NewFile(uintptr(fd), "handover")
+	conn, err := net.FilePacketConn(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := conn.(*net.UDPConn); !ok {
+		conn.Close()
+		return nil, errors.New("the socket handed over is not a UDP socket")
+	}
+
+	host, err := protocol.NewHost(conn, int(peerCount), int(channelLimit), incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New("unable to create host")
+	}
+	ret := &enetHost{
+		hostBackend: hostBackend{goHost: host},
+	}
+	registerHost(host, ret)
+	return ret, nil
+}
+
+// restorePeer connects the peer of the host with the ID of snapshot, carrying on with
+// the connection of the snapshot.
+func (host *enetHost) restorePeer(snapshot handoverPeer) (enetPeer, error) {
+	p := protocol.PeerSnapshot{
+		ID:                             snapshot.ID,
+		OutgoingID:                     snapshot.OutgoingID,
+		ConnectID:                      snapshot.ConnectID,
+		IncomingSessionID:              snapshot.IncomingSessionID,
+		OutgoingSessionID:              snapshot.OutgoingSessionID,
+		Address:                        protocol.AddressOf(net.UDPAddrFromAddrPort(snapshot.Address)),
+		IncomingBandwidth:              snapshot.IncomingBandwidth,
+		OutgoingBandwidth:              snapshot.OutgoingBandwidth,
+		MTU:                            snapshot.MTU,
+		WindowSize:                     snapshot.WindowSize,
+		RoundTripTime:                  snapshot.RoundTripTime,
+		RoundTripTimeVariance:          snapshot.RoundTripTimeVariance,
+		PingInterval:                   snapshot.PingInterval,
+		TimeoutLimit:                   snapshot.TimeoutLimit,
+		TimeoutMinimum:                 snapshot.TimeoutMinimum,
+		TimeoutMaximum:                 snapshot.TimeoutMaximum,
+		OutgoingReliableSequenceNumber: snapshot.OutgoingReliableSequenceNumber,
+		IncomingUnsequencedGroup:       snapshot.IncomingUnsequencedGroup,
+		OutgoingUnsequencedGroup:       snapshot.OutgoingUnsequencedGroup,
+		UnsequencedWindow:              snapshot.UnsequencedWindow,
+	}
+	for _, channel := range snapshot.Channels {
+		p.Channels = append(p.Channels, protocol.ChannelSnapshot(channel))
+	}
+	peer, err := host.goHost.RestorePeer(p)
+	if err != nil {
+		return enetPeer{}, err
+	}
+	return enetPeer{goPeer: peer}, nil
+}
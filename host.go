@@ -1,17 +1,27 @@
 package enet
 
-// #include "enet.h"
-import "C"
 import (
 	"errors"
+	"sync/atomic"
 )
 
 // Host for communicating with peers
 type Host interface {
-	Destroy()
+	// Destroy destroys the host and all resources associated with it. Returns an error
+	// if the host has already been destroyed.
+	Destroy() error
+
 	Service(timeout uint32) Event
 	ServiceV2(event *enetEvent, timeout uint32) int
 
+	// ServiceBatch services the host like ServiceV2, filling events with up to
+	// len(events) events, the first waited for up to timeout and the others those
+	// already pending. Returns the number of events filled, negative on failure. The
+	// events of the slice are reused by the next call, like the event of ServiceV2, and
+	// nil ones are allocated. Built with cgo, enet hosts drain the events in a single
+	// call into C, which busy servers benefit from.
+	ServiceBatch(events []Event, timeout uint32) int
+
 	Connect(addr Address, channelCount int, data uint32) (Peer, error)
 
 	BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error
@@ -27,75 +37,127 @@ type Host interface {
 	ResetBytesReceived()
 	ResetPacketsSent()
 	ResetPacketsReceived()
-}
 
-type enetHost struct {
-	cHost *C.ENetHost
-}
+	// GetStats returns the statistics of the host as of the last Service call. Unlike
+	// the other getters it does not touch the C host and is safe to call from any
+	// goroutine, for example from a metrics exporter.
+	GetStats() HostStats
 
-func (host *enetHost) Destroy() {
-	C.enet_host_destroy(host.cHost)
+	// ApplyConfig changes the settings of the host while it runs, all at once. The
+	// configuration replaces the one applied before, zero fields taking their defaults,
+	// and settings for new peers leave the peers already connected as they are.
+	ApplyConfig(config HostConfig) error
 }
 
+type enetHost struct {
+	hostBackend
+	destroyed bool
+
+	outbox      outbox
+	deferred    deferredQueue
+	stats       hostStats
+	streams     streamRegistry
+	intercepts  interceptChain
+	features    featureList
+	flushers    flusherSet
+	forwarded   forwardedTable
+	noise       noiseTable
+	versions    versionTable
+	compression compressionTable
+	tokens      tokenTable
+	punches     punchTable
+	clocks      clockSyncTable
+	bans        banTable
+	tags        tagTable
+	config      hostConfigTable
+	observers   []ServiceObserver
+	log         hostLog
+	dumper      atomic.Pointer[packetDumper]
+	latency     latencyTable
+	bandwidth   bandwidthTable
+	conditions  conditionTable
+	clock       *hostClock
+}
+
+var errHostDestroyed = errors.New("host has been destroyed")
+
 func (host *enetHost) Service(timeout uint32) Event {
 	ret := &enetEvent{}
-	C.enet_host_service(
-		host.cHost,
-		&ret.cEvent,
-		(C.uint32_t)(timeout),
-	)
+	host.ServiceV2(ret, timeout)
 	return ret
 }
 
 func (host *enetHost) ServiceV2(event *enetEvent, timeout uint32) int {
-	ret := C.enet_host_service(
-		host.cHost,
-		&event.cEvent,
-		(C.uint32_t)(timeout),
-	)
-	return int(ret)
-}
-
-func (host *enetHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
-	peer := C.enet_host_connect(
-		host.cHost,
-		&(addr.(*enetAddress)).cAddr,
-		(C.size_t)(channelCount),
-		(C.uint32_t)(data),
-	)
-
-	if peer == nil {
-		return nil, errors.New("couldn't connect to foreign peer")
+	if host.clock != nil && host.clock.virtual {
+		timeout = 0
+	}
+	timeout = host.conditions.timeout(timeout)
+	if len(host.observers) == 0 {
+		ret := host.service(event, timeout)
+		host.serviced(event)
+		return ret
 	}
 
-	return enetPeer{
-		cPeer: peer,
-	}, nil
+	done := make([]func(Event), len(host.observers))
+	for i, observer := range host.observers {
+		done[i] = observer(host)
+	}
+	ret := host.service(event, timeout)
+	host.serviced(event)
+	for i := len(done) - 1; i >= 0; i-- {
+		if done[i] != nil {
+			done[i](event)
+		}
+	}
+	return ret
 }
 
-// NewHost creats a host for communicating to peers
-func NewHost(addr Address, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32, bufferLimit int) (Host, error) {
-	var cAddr *C.ENetAddress
-	if addr != nil {
-		cAddr = &(addr.(*enetAddress)).cAddr
+func (host *enetHost) ServiceBatch(events []Event, timeout uint32) int {
+	if len(events) == 0 {
+		return 0
 	}
+	if len(host.observers) != 0 {
+		// Observers follow the host one event at a time.
+		return serviceEach(host.ServiceV2, events, timeout)
+	}
+	if host.clock != nil && host.clock.virtual {
+		timeout = 0
+	}
+	timeout = host.conditions.timeout(timeout)
+	n := host.serviceBatch(events, timeout)
+	for _, event := range events[:max(n, 0)] {
+		host.serviced(event.(*enetEvent))
+	}
+	return n
+}
 
-	host := C.enet_host_create(
-		cAddr,
-		(C.size_t)(peerCount),
-		(C.size_t)(channelLimit),
-		(C.uint32_t)(incomingBandwidth),
-		(C.uint32_t)(outgoingBandwidth),
-		(C.int)(bufferLimit),
-	)
-
-	if host == nil {
-		return nil, errors.New("unable to create host")
+// serviced lets the features of the host that follow its events see event.
+func (host *enetHost) serviced(event *enetEvent) {
+	host.stats.countEvent(event)
+	host.dumpReceived(event)
+	if event.GetType() == EventConnect {
+		if peer, ok := event.GetPeer().(enetPeer); ok {
+			host.outbox.track(peer)
+		}
 	}
+	host.features.serviced(host, event)
+	host.logService(event)
+}
+
+// prepareService runs the work due before the host is serviced. Returns true if it set
+// event to an event of its own, which Service must return without servicing the host.
+func (host *enetHost) prepareService(event *enetEvent) bool {
+	host.flushers.flush()
+	host.outbox.flush()
+	host.submitDeferred(false)
+	return host.features.prepare(host, event)
+}
 
-	return &enetHost{
-		cHost: host,
-	}, nil
+// interceptEvent offers an event of the backend to the features of the host that
+// consume or replace events. Returns true if the event has been consumed and must not
+// be returned to the application.
+func (host *enetHost) interceptEvent(event *enetEvent) bool {
+	return host.features.intercept(host, event)
 }
 
 func (host *enetHost) BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error {
@@ -103,54 +165,81 @@ func (host *enetHost) BroadcastBytes(data []byte, channel uint8, flags PacketFla
 	if err != nil {
 		return err
 	}
-	return host.BroadcastPacket(packet, channel)
-}
-
-func (host *enetHost) BroadcastPacket(packet Packet, channel uint8) error {
-	C.enet_host_broadcast(
-		host.cHost,
-		(C.uint8_t)(channel),
-		packet.(enetPacket).cPacket,
-	)
-	return nil
-}
-
-func (host *enetHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
-	packet, err := NewPacket([]byte(str), flags)
-	if err != nil {
+	if err := host.BroadcastPacket(packet, channel); err != nil {
+		packet.Destroy()
 		return err
 	}
-	return host.BroadcastPacket(packet, channel)
-}
-
-func (host *enetHost) GetBytesSent() uint32 {
-	return uint32(C.enet_host_get_bytes_sent(host.cHost))
-}
-
-func (host *enetHost) GetPacketsSent() uint32 {
-	return uint32(C.enet_host_get_packets_sent(host.cHost))
-}
-
-func (host *enetHost) GetBytesReceived() uint32 {
-	return uint32(C.enet_host_get_bytes_received(host.cHost))
+	return nil
 }
 
-func (host *enetHost) GetPacketsReceived() uint32 {
-	return uint32(C.enet_host_get_packets_received(host.cHost))
+func (host *enetHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
 }
 
-func (host *enetHost) ResetBytesSent() {
-	host.cHost.totalSentData = 0
+func (host *enetHost) GetStats() HostStats {
+	stats := host.stats.snapshot()
+	stats.QueuedPackets = uint32(host.outbox.queued.Load())
+	return stats
 }
 
-func (host *enetHost) ResetBytesReceived() {
-	host.cHost.totalReceivedData = 0
+// batchEvent returns the event of events at i to fill, reusing it if it is one of the
+// package.
+func batchEvent(events []Event, i int) *enetEvent {
+	if event, ok := events[i].(*enetEvent); ok && event != nil {
+		return event
+	}
+	event := &enetEvent{}
+	events[i] = event
+	return event
+}
+
+// serviceEach implements Host.ServiceBatch on top of a ServiceV2 function, calling it
+// until it has no event left without waiting.
+func serviceEach(service func(event *enetEvent, timeout uint32) int, events []Event, timeout uint32) int {
+	n := 0
+	for n < len(events) {
+		ret := service(batchEvent(events, n), timeout)
+		if ret < 0 && n == 0 {
+			return ret
+		}
+		if ret <= 0 {
+			break
+		}
+		n++
+		timeout = 0
+	}
+	return n
 }
 
-func (host *enetHost) ResetPacketsSent() {
-	host.cHost.totalSentPackets = 0
-}
+// ServiceV2Func implements Host.ServiceV2 and Host.ServiceBatch on top of a Service
+// function. Host implementations outside this package, such as fakes for tests, can't
+// name the event type ServiceV2 takes, so they embed it instead.
+type ServiceV2Func func(timeout uint32) Event
 
-func (host *enetHost) ResetPacketsReceived() {
-	host.cHost.totalReceivedPackets = 0
+func (service ServiceV2Func) ServiceV2(event *enetEvent, timeout uint32) int {
+	*event = enetEvent{}
+	received := service(timeout)
+	if received == nil {
+		return 0
+	}
+	event.goEvent = received
+	event.timestamp = received.GetTimestamp()
+	if received.GetType() == EventNone {
+		return 0
+	}
+	return 1
+}
+
+func (service ServiceV2Func) ServiceBatch(events []Event, timeout uint32) int {
+	n := 0
+	for n < len(events) {
+		received := service(timeout)
+		if received == nil || received.GetType() == EventNone {
+			break
+		}
+		events[n] = received
+		n++
+		timeout = 0
+	}
+	return n
 }
@@ -0,0 +1,420 @@
+//go:build js && wasm
+
+package enet
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	browserPeerCountMax    = 0xFFF
+	browserChannelLimitMax = 255
+)
+
+// rawHost identifies the host of the backend in the shared code, for example in the
+// thread checks.
+type rawHost = *browserHost
+
+type hostBackend struct {
+	jsHost *browserHost
+}
+
+// browserHost is a client host whose peers are connections to bridges. The transports
+// queue events from JavaScript callbacks, which must never block, and Service takes
+// them out.
+type browserHost struct {
+	peers        []*browserPeer
+	channelLimit int
+
+	lock   sync.Mutex
+	queue  []browserEvent
+	notify chan struct{}
+
+	totalSentData        uint32
+	totalSentPackets     uint32
+	totalReceivedData    uint32
+	totalReceivedPackets uint32
+}
+
+// post queues an event for Service. Safe to call from JavaScript callbacks.
+func (h *browserHost) post(event browserEvent) {
+	h.lock.Lock()
+	h.queue = append(h.queue, event)
+	h.lock.Unlock()
+
+	select {
+	case h.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the next event that is still current out of the queue and applies it to
+// its peer. Events of connections that have since been dropped are skipped.
+func (h *browserHost) next() (browserEvent, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for len(h.queue) > 0 {
+		event := h.queue[0]
+		h.queue[0] = browserEvent{}
+		h.queue = h.queue[1:]
+
+		peer := event.peer
+		if event.generation != peer.generation {
+			continue
+		}
+		switch event.eventType {
+		case EventConnect:
+			if peer.state != browserPeerConnecting {
+				continue
+			}
+			peer.state = browserPeerConnected
+		case EventReceive:
+			if peer.state != browserPeerConnected {
+				continue
+			}
+			h.totalReceivedData += uint32(len(event.packet.Data))
+			h.totalReceivedPackets++
+			peer.bytesReceived += uint64(len(event.packet.Data))
+		case EventDisconnect, EventDisconnectTimeout:
+			peer.reset()
+		}
+		return event, true
+	}
+	return browserEvent{}, false
+}
+
+func (host *enetHost) raw() rawHost {
+	return host.jsHost
+}
+
+func (host *enetHost) Destroy() error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.jsHost, "Host.Destroy")
+	threadCheckForget(host.jsHost)
+	host.destroyed = true
+	unregisterHost(host.jsHost, host)
+	host.outbox.discard()
+	host.deferred.discard()
+	for _, peer := range host.jsHost.peers {
+		peer.drop(0)
+	}
+	return nil
+}
+
+// submitSends hands deferred sends to the peers. Bridges get packets as they are sent,
+// so there is nothing to flush.
+func (host *enetHost) submitSends(sends []deferredSend, flush bool) {
+	for i := range sends {
+		sends[i].sent = sends[i].peer.jsPeer.send(sends[i].channel, sends[i].packet)
+	}
+}
+
+// serviceBatch services the host into events, see Host.ServiceBatch.
+func (host *enetHost) serviceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.service, events, timeout)
+}
+
+// service services the host, see Host.ServiceV2.
+func (host *enetHost) service(event *enetEvent, timeout uint32) int {
+	event.goEvent = nil
+	event.packet = nil
+	if host.destroyed {
+		event.jsEvent = browserEvent{}
+		return -1
+	}
+	threadCheckService(host.jsHost)
+	if host.prepareService(event) {
+		return 1
+	}
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		next, ok := host.jsHost.next()
+		for !ok && timeout > 0 {
+			if timer == nil {
+				timer = time.NewTimer(time.Duration(timeout) * time.Millisecond)
+			}
+			select {
+			case <-host.jsHost.notify:
+				next, ok = host.jsHost.next()
+			case <-timer.C:
+				timeout = 0
+			}
+		}
+		event.jsEvent = next
+		event.timestamp = time.Now()
+		host.updateStats()
+		if !ok {
+			return 0
+		}
+
+		if !host.interceptEvent(event) {
+			return 1
+		}
+
+		// The event went to a connection or a handshake, look for another one without
+		// waiting.
+		event.goEvent = nil
+		event.packet = nil
+		timeout = 0
+	}
+}
+
+// Connect dials the bridge serving addr, see SetBrowserURL.
+func (host *enetHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	if host.destroyed {
+		return nil, errHostDestroyed
+	}
+	threadCheck(host.jsHost, "Host.Connect")
+
+	var peer *browserPeer
+	for _, p := range host.jsHost.peers {
+		if p.state == browserPeerDisconnected {
+			peer = p
+			break
+		}
+	}
+	if peer == nil {
+		return nil, errors.New("couldn't connect to foreign peer")
+	}
+
+	if channelCount <= 0 || channelCount > host.jsHost.channelLimit {
+		channelCount = host.jsHost.channelLimit
+	}
+	a := addr.(*enetAddress)
+	transport, err := dialBrowser(bridgeURL(a, data), peer)
+	if err != nil {
+		return nil, errors.New("couldn't connect to foreign peer")
+	}
+
+	host.jsHost.lock.Lock()
+	peer.generation++
+	peer.state = browserPeerConnecting
+	peer.addr = *a
+	peer.channelCount = channelCount
+	peer.transport = transport
+	host.jsHost.lock.Unlock()
+
+	transport.start(peer.generation)
+	ret := enetPeer{
+		jsPeer: peer,
+	}
+	host.noise.connecting(ret)
+	host.config.connecting(ret)
+	host.outbox.track(ret)
+	host.versions.connecting(ret)
+	return ret, nil
+}
+
+// bridgeURL returns the URL to dial for addr, carrying the connect data in the query.
+func bridgeURL(addr *enetAddress, data uint32) string {
+	var raw string
+	if f := browserURL.Load(); f != nil {
+		raw = (*f)(addr)
+	} else {
+		scheme := "ws"
+		if js.Global().Get("location").Get("protocol").String() == "https:" {
+			scheme = "wss"
+		}
+		raw = scheme + "://" + net.JoinHostPort(addr.host, strconv.Itoa(int(addr.port))) + "/"
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	query.Set("data", strconv.FormatUint(uint64(data), 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// NewHost creats a host for communicating to peers. Browsers can't listen, so addr
+// must be nil. The buffer limit is ignored.
+func NewHost(addr Address, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32, bufferLimit int) (Host, error) {
+	if addr != nil {
+		return nil, errors.New("listening is not supported in the browser")
+	}
+	if peerCount == 0 || peerCount > browserPeerCountMax {
+		return nil, errors.New("unable to create host")
+	}
+	if channelLimit == 0 || channelLimit > browserChannelLimitMax {
+		channelLimit = browserChannelLimitMax
+	}
+
+	h := &browserHost{
+		peers:        make([]*browserPeer, peerCount),
+		channelLimit: int(channelLimit),
+		notify:       make(chan struct{}, 1),
+	}
+	for i := range h.peers {
+		h.peers[i] = &browserPeer{host: h, id: uint32(i)}
+	}
+
+	ret := &enetHost{
+		hostBackend: hostBackend{jsHost: h},
+	}
+	registerHost(h, ret)
+	return ret, nil
+}
+
+// newTransportHost fails, browser hosts reach peers through bridges.
+func newTransportHost(transport Transport, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (Host, error) {
+	return nil, errors.New("transports are not supported in the browser")
+}
+
+// setProtocol fails, the bridge talks enet on behalf of the browser.
+func (host *enetHost) setProtocol(config ProtocolConfig) error {
+	return errors.New("the protocol is up to the bridge in the browser")
+}
+
+// BroadcastPacket sends the packet to all connected peers.
+func (host *enetHost) BroadcastPacket(packet Packet, channel uint8) error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.jsHost, "Host.BroadcastPacket")
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	jsPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+
+	host.dumpBroadcast(channel, jsPacket)
+	for _, peer := range host.jsHost.peers {
+		peer.send(channel, jsPacket)
+	}
+	return nil
+}
+
+func (host *enetHost) GetBytesSent() uint32 {
+	threadCheck(host.jsHost, "Host.GetBytesSent")
+	return host.jsHost.totalSentData
+}
+
+func (host *enetHost) GetPacketsSent() uint32 {
+	threadCheck(host.jsHost, "Host.GetPacketsSent")
+	return host.jsHost.totalSentPackets
+}
+
+func (host *enetHost) GetBytesReceived() uint32 {
+	threadCheck(host.jsHost, "Host.GetBytesReceived")
+	return host.jsHost.totalReceivedData
+}
+
+func (host *enetHost) GetPacketsReceived() uint32 {
+	threadCheck(host.jsHost, "Host.GetPacketsReceived")
+	return host.jsHost.totalReceivedPackets
+}
+
+func (host *enetHost) ResetBytesSent() {
+	threadCheck(host.jsHost, "Host.ResetBytesSent")
+	host.jsHost.totalSentData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetBytesReceived() {
+	threadCheck(host.jsHost, "Host.ResetBytesReceived")
+	host.jsHost.totalReceivedData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsSent() {
+	threadCheck(host.jsHost, "Host.ResetPacketsSent")
+	host.jsHost.totalSentPackets = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsReceived() {
+	threadCheck(host.jsHost, "Host.ResetPacketsReceived")
+	host.jsHost.totalReceivedPackets = 0
+	host.updateStats()
+}
+
+// updateStats copies the counters of the host to the stats.
+func (host *enetHost) updateStats() {
+	var connected uint32
+	for _, peer := range host.jsHost.peers {
+		if peer.state == browserPeerConnected {
+			connected++
+		}
+	}
+	host.stats.update(
+		host.jsHost.totalSentData,
+		host.jsHost.totalReceivedData,
+		host.jsHost.totalSentPackets,
+		host.jsHost.totalReceivedPackets,
+		connected,
+	)
+	host.updatePeerStats()
+}
+
+// connectedPeerList returns the peers of the host that are connected.
+func (host *enetHost) connectedPeerList() []enetPeer {
+	var peers []enetPeer
+	for _, peer := range host.jsHost.peers {
+		if peer.state == browserPeerConnected {
+			peers = append(peers, enetPeer{jsPeer: peer})
+		}
+	}
+	return peers
+}
+
+// wireProtocol returns the default wire format, the bridge talks enet for the host.
+func (host *enetHost) wireProtocol() ProtocolConfig {
+	return ProtocolConfig{}
+}
+
+// serviceClock returns 0, browser hosts have no enet clock.
+func (host *enetHost) serviceClock() uint32 {
+	return 0
+}
+
+// datagramPeer finds no peer, browser hosts receive no datagrams.
+func (host *enetHost) datagramPeer(addr rawAddress, peerID uint16) (enetPeer, bool) {
+	return enetPeer{}, false
+}
+
+// seedRandom does nothing, connect IDs are left to the bridge.
+func (host *enetHost) seedRandom(seed uint32) {}
+
+// setBandwidthLimit fails, the bridge throttles the connections of the browser.
+func (host *enetHost) setBandwidthLimit(incoming, outgoing uint32) error {
+	return errors.New("bandwidth is up to the bridge in the browser")
+}
+
+// applyConfig fails if config sets what is up to the bridge in the browser.
+func (host *enetHost) applyConfig(config HostConfig) error {
+	if config.IncomingBandwidth != 0 || config.OutgoingBandwidth != 0 || config.ChannelLimit != 0 || config.MaxPeersPerIP != 0 {
+		return errors.New("bandwidth and limits are up to the bridge in the browser")
+	}
+	return nil
+}
+
+// time returns 0, hosts in the browser have no clock of their own.
+func (host *enetHost) time() uint32 {
+	return 0
+}
+
+// setClock fails, the bridge times the connections of the browser.
+func (host *enetHost) setClock(clock *hostClock) error {
+	return errors.New("the clock is up to the bridge in the browser")
+}
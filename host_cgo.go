@@ -0,0 +1,430 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+// int goenet_host_service(ENetHost* host, ENetEvent* event, uint32_t timeout);
+// typedef struct {
+// 	ENetPeer* peer;
+// 	ENetPacket* packet;
+// 	uint8_t channel;
+// 	uint8_t sent;
+// } goenet_deferred_send;
+// void goenet_peer_send_batch(ENetHost* host, goenet_deferred_send* sends, int count, int flush);
+// int goenet_host_service_batch(ENetHost* host, ENetEvent* events, int count, uint32_t timeout);
+// void goenet_host_set_clock(ENetHost* host);
+import "C"
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// rawHost identifies the host of the backend in the shared code, for example in the
+// thread checks.
+type rawHost = *C.ENetHost
+
+type hostBackend struct {
+	cHost *C.ENetHost
+
+	// transport is set for hosts created on a Transport
+	transport *transportConn
+
+	// batch holds the events of ServiceBatch as C fills them in.
+	batch []C.ENetEvent
+
+	// sends holds the deferred sends as they are submitted to C.
+	sends []C.goenet_deferred_send
+}
+
+func (host *enetHost) raw() rawHost {
+	return host.cHost
+}
+
+func (host *enetHost) Destroy() error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.cHost, "Host.Destroy")
+	threadCheckForget(host.cHost)
+	host.destroyed = true
+	unregisterHost(host.cHost, host)
+	leakUntrack(unsafe.Pointer(host.cHost))
+	host.outbox.discard()
+	host.deferred.discard()
+	C.enet_host_destroy(host.cHost)
+	if host.transport != nil {
+		host.transport.close()
+	}
+	return nil
+}
+
+// service services the host, see Host.ServiceV2.
+func (host *enetHost) service(event *enetEvent, timeout uint32) int {
+	event.goEvent = nil
+	event.packet = nil
+	if host.destroyed {
+		event.cEvent = C.ENetEvent{}
+		return -1
+	}
+	threadCheckService(host.cHost)
+	if host.prepareService(event) {
+		return 1
+	}
+
+	for {
+		ret := C.goenet_host_service(
+			host.cHost,
+			&event.cEvent,
+			(C.uint32_t)(timeout),
+		)
+		event.timestamp = time.Now()
+		host.updateStats()
+		if ret <= 0 {
+			return int(ret)
+		}
+
+		if event.cEvent._type == C.ENET_EVENT_TYPE_RECEIVE {
+			leakTrack(unsafe.Pointer(event.cEvent.packet), leakPacket)
+		}
+		if !host.interceptEvent(event) {
+			return int(ret)
+		}
+
+		// The event went to a connection or a handshake, look for another one without
+		// waiting.
+		event.goEvent = nil
+		event.packet = nil
+		timeout = 0
+	}
+}
+
+// serviceBatch services the host into events, see Host.ServiceBatch. The events are
+// drained by one call into C, then offered to the host one by one.
+func (host *enetHost) serviceBatch(events []Event, timeout uint32) int {
+	first := batchEvent(events, 0)
+	first.goEvent = nil
+	first.packet = nil
+	if host.destroyed {
+		first.cEvent = C.ENetEvent{}
+		return -1
+	}
+	threadCheckService(host.cHost)
+	if host.prepareService(first) {
+		return 1
+	}
+
+	if len(host.batch) < len(events) {
+		host.batch = make([]C.ENetEvent, len(events))
+	}
+	batch := host.batch[:len(events)]
+	for {
+		ret := C.goenet_host_service_batch(
+			host.cHost,
+			&batch[0],
+			(C.int)(len(batch)),
+			(C.uint32_t)(timeout),
+		)
+		now := time.Now()
+		host.updateStats()
+		if ret < 0 {
+			first.cEvent = C.ENetEvent{}
+			return int(ret)
+		}
+
+		n := 0
+		for i := range int(ret) {
+			event := batchEvent(events, n)
+			event.goEvent = nil
+			event.packet = nil
+			event.cEvent = batch[i]
+			event.timestamp = now
+			if event.cEvent._type == C.ENET_EVENT_TYPE_RECEIVE {
+				leakTrack(unsafe.Pointer(event.cEvent.packet), leakPacket)
+			}
+			if !host.interceptEvent(event) {
+				n++
+			}
+		}
+		if n > 0 || ret == 0 {
+			return n
+		}
+
+		// Every event went to a connection or a handshake, look for others without
+		// waiting.
+		timeout = 0
+	}
+}
+
+// submitSends hands deferred sends to enet in a single call into C, flushing the host
+// if flush is set.
+func (host *enetHost) submitSends(sends []deferredSend, flush bool) {
+	if cap(host.sends) < len(sends) {
+		host.sends = make([]C.goenet_deferred_send, len(sends))
+	}
+	batch := host.sends[:len(sends)]
+	for i, send := range sends {
+		batch[i] = C.goenet_deferred_send{
+			peer:    send.peer.cPeer,
+			packet:  send.packet,
+			channel: C.uint8_t(send.channel),
+		}
+	}
+	var first *C.goenet_deferred_send
+	if len(batch) > 0 {
+		first = &batch[0]
+	}
+	var cFlush C.int
+	if flush {
+		cFlush = 1
+	}
+	C.goenet_peer_send_batch(host.cHost, first, C.int(len(batch)), cFlush)
+	for i := range sends {
+		sends[i].sent = batch[i].sent != 0
+	}
+	clear(batch)
+}
+
+func (host *enetHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	if host.destroyed {
+		return nil, errHostDestroyed
+	}
+	threadCheck(host.cHost, "Host.Connect")
+	peer := C.enet_host_connect(
+		host.cHost,
+		&(addr.(*enetAddress)).cAddr,
+		(C.size_t)(channelCount),
+		(C.uint32_t)(data),
+	)
+
+	if peer == nil {
+		return nil, errors.New("couldn't connect to foreign peer")
+	}
+
+	ret := enetPeer{
+		cPeer: peer,
+	}
+	host.noise.connecting(ret)
+	host.config.connecting(ret)
+	host.outbox.track(ret)
+	host.versions.connecting(ret)
+	return ret, nil
+}
+
+// NewHost creats a host for communicating to peers
+func NewHost(addr Address, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32, bufferLimit int) (Host, error) {
+	if host, ok, err := factoryHost(addr, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth); ok {
+		return host, err
+	}
+
+	var cAddr *C.ENetAddress
+	if addr != nil {
+		cAddr = &(addr.(*enetAddress)).cAddr
+	}
+
+	host := C.enet_host_create(
+		cAddr,
+		(C.size_t)(peerCount),
+		(C.size_t)(channelLimit),
+		(C.uint32_t)(incomingBandwidth),
+		(C.uint32_t)(outgoingBandwidth),
+		(C.int)(bufferLimit),
+	)
+
+	if host == nil {
+		return nil, errors.New("unable to create host")
+	}
+
+	ret := &enetHost{
+		hostBackend: hostBackend{cHost: host},
+	}
+	registerHost(host, ret)
+	leakTrack(unsafe.Pointer(host), leakHost)
+	return ret, nil
+}
+
+func (host *enetHost) setProtocol(config ProtocolConfig) error {
+	threadCheck(host.cHost, "SetProtocol")
+	host.cHost.stockProtocol = 0
+	if config.Protocol == ProtocolStock {
+		host.cHost.stockProtocol = 1
+	}
+
+	switch {
+	case !config.Checksum:
+		C.enet_host_set_checksum_callback(host.cHost, nil)
+	case config.Protocol == ProtocolStock:
+		C.enet_host_set_checksum_callback(host.cHost, C.ENetChecksumCallback(C.enet_crc32))
+	default:
+		C.enet_host_set_checksum_callback(host.cHost, C.ENetChecksumCallback(C.enet_crc64))
+	}
+	return nil
+}
+
+// BroadcastPacket hands the packet over to enet, which frees it once it has been sent
+// to all peers.
+func (host *enetHost) BroadcastPacket(packet Packet, channel uint8) error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.cHost, "Host.BroadcastPacket")
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	cPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+	leakUntrack(unsafe.Pointer(cPacket))
+	host.dumpBroadcast(channel, cPacket)
+
+	C.enet_host_broadcast(
+		host.cHost,
+		(C.uint8_t)(channel),
+		cPacket,
+	)
+	return nil
+}
+
+func (host *enetHost) GetBytesSent() uint32 {
+	threadCheck(host.cHost, "Host.GetBytesSent")
+	return uint32(C.enet_host_get_bytes_sent(host.cHost))
+}
+
+func (host *enetHost) GetPacketsSent() uint32 {
+	threadCheck(host.cHost, "Host.GetPacketsSent")
+	return uint32(C.enet_host_get_packets_sent(host.cHost))
+}
+
+func (host *enetHost) GetBytesReceived() uint32 {
+	threadCheck(host.cHost, "Host.GetBytesReceived")
+	return uint32(C.enet_host_get_bytes_received(host.cHost))
+}
+
+func (host *enetHost) GetPacketsReceived() uint32 {
+	threadCheck(host.cHost, "Host.GetPacketsReceived")
+	return uint32(C.enet_host_get_packets_received(host.cHost))
+}
+
+func (host *enetHost) ResetBytesSent() {
+	threadCheck(host.cHost, "Host.ResetBytesSent")
+	host.cHost.totalSentData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetBytesReceived() {
+	threadCheck(host.cHost, "Host.ResetBytesReceived")
+	host.cHost.totalReceivedData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsSent() {
+	threadCheck(host.cHost, "Host.ResetPacketsSent")
+	host.cHost.totalSentPackets = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsReceived() {
+	threadCheck(host.cHost, "Host.ResetPacketsReceived")
+	host.cHost.totalReceivedPackets = 0
+	host.updateStats()
+}
+
+// updateStats copies the counters of the C host to the stats.
+func (host *enetHost) updateStats() {
+	host.stats.update(
+		uint32(host.cHost.totalSentData),
+		uint32(host.cHost.totalReceivedData),
+		uint32(host.cHost.totalSentPackets),
+		uint32(host.cHost.totalReceivedPackets),
+		uint32(host.cHost.connectedPeers),
+	)
+	host.updatePeerStats()
+}
+
+// connectedPeerList returns the peers of the host that are connected.
+func (host *enetHost) connectedPeerList() []enetPeer {
+	var peers []enetPeer
+	all := unsafe.Slice(host.cHost.peers, host.cHost.peerCount)
+	for i := range all {
+		if all[i].state == C.ENET_PEER_STATE_CONNECTED {
+			peers = append(peers, enetPeer{cPeer: &all[i]})
+		}
+	}
+	return peers
+}
+
+// wireProtocol returns the wire format the host talks, to decode its datagrams with.
+func (host *enetHost) wireProtocol() ProtocolConfig {
+	config := ProtocolConfig{Checksum: host.cHost.checksumCallback != nil}
+	if host.cHost.stockProtocol != 0 {
+		config.Protocol = ProtocolStock
+	}
+	return config
+}
+
+// serviceClock returns the clock of the host in milliseconds as of the service call in
+// progress, which enet times acknowledgements against.
+func (host *enetHost) serviceClock() uint32 {
+	return uint32(host.cHost.serviceTime)
+}
+
+// datagramPeer returns the connected peer a datagram received from addr with peerID in
+// its header comes from.
+func (host *enetHost) datagramPeer(addr rawAddress, peerID uint16) (enetPeer, bool) {
+	if uint64(peerID) >= uint64(host.cHost.peerCount) {
+		return enetPeer{}, false
+	}
+	peer := &unsafe.Slice(host.cHost.peers, host.cHost.peerCount)[peerID]
+	if peer.state != C.ENET_PEER_STATE_CONNECTED || peer.address != *addr {
+		return enetPeer{}, false
+	}
+	return enetPeer{cPeer: peer}, true
+}
+
+// seedRandom seeds the connect IDs of the host.
+func (host *enetHost) seedRandom(seed uint32) {
+	host.cHost.randomSeed = C.uint32_t(seed)
+}
+
+// setBandwidthLimit sets the bandwidth of the host, told to its peers.
+func (host *enetHost) setBandwidthLimit(incoming, outgoing uint32) error {
+	C.enet_host_bandwidth_limit(host.cHost, C.uint32_t(incoming), C.uint32_t(outgoing))
+	return nil
+}
+
+// applyConfig applies the settings of config that are up to enet.
+func (host *enetHost) applyConfig(config HostConfig) error {
+	threadCheck(host.cHost, "Host.ApplyConfig")
+	C.enet_host_bandwidth_limit(host.cHost, C.uint32_t(config.IncomingBandwidth), C.uint32_t(config.OutgoingBandwidth))
+	C.enet_host_channel_limit(host.cHost, C.size_t(config.ChannelLimit))
+	duplicates := C.ENET_PROTOCOL_MAXIMUM_PEER_ID
+	if config.MaxPeersPerIP > 0 {
+		duplicates = min(config.MaxPeersPerIP, C.ENET_PROTOCOL_MAXIMUM_PEER_ID)
+	}
+	C.enet_host_set_max_duplicate_peers(host.cHost, C.uint16_t(duplicates))
+	return nil
+}
+
+// time returns the time of the host in milliseconds.
+func (host *enetHost) time() uint32 {
+	threadCheck(host.cHost, "SetHostClock")
+	return uint32(C.enet_host_time(host.cHost))
+}
+
+// setClock makes enet ask the host for the time.
+func (host *enetHost) setClock(clock *hostClock) error {
+	host.clock = clock
+	C.goenet_host_set_clock(host.cHost)
+	return nil
+}
+
+//export goenetHostTime
+func goenetHostTime(cHost *C.ENetHost) C.uint32_t {
+	host := hostOf(cHost)
+	if host == nil || host.clock == nil {
+		return C.uint32_t(C.enet_time_get())
+	}
+	return C.uint32_t(host.clock.now())
+}
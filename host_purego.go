@@ -0,0 +1,337 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+import (
+	"errors"
+	"math"
+	"net"
+	"time"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+const (
+	hostBufferSizeMin = 256 * 1024
+	hostBufferSizeMax = 1024 * 1024
+)
+
+// rawHost identifies the host of the backend in the shared code, for example in the
+// thread checks.
+type rawHost = *protocol.Host
+
+type hostBackend struct {
+	goHost *protocol.Host
+}
+
+func (host *enetHost) raw() rawHost {
+	return host.goHost
+}
+
+func (host *enetHost) Destroy() error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.goHost, "Host.Destroy")
+	threadCheckForget(host.goHost)
+	host.destroyed = true
+	unregisterHost(host.goHost, host)
+	host.outbox.discard()
+	host.deferred.discard()
+	host.goHost.Destroy()
+	return nil
+}
+
+// submitSends hands deferred sends to the protocol, flushing the host if flush is set.
+func (host *enetHost) submitSends(sends []deferredSend, flush bool) {
+	for i := range sends {
+		sends[i].sent = sends[i].peer.goPeer.Send(sends[i].channel, sends[i].packet) == nil
+	}
+	if flush {
+		host.goHost.Flush()
+	}
+}
+
+// serviceBatch services the host into events, see Host.ServiceBatch.
+func (host *enetHost) serviceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.service, events, timeout)
+}
+
+// service services the host, see Host.ServiceV2.
+func (host *enetHost) service(event *enetEvent, timeout uint32) int {
+	event.goEvent = nil
+	event.packet = nil
+	if host.destroyed {
+		event.goHostEvent = protocol.Event{}
+		return -1
+	}
+	threadCheckService(host.goHost)
+	if host.prepareService(event) {
+		return 1
+	}
+
+	for {
+		ret := host.goHost.Service(&event.goHostEvent, timeout)
+		event.timestamp = time.Now()
+		host.updateStats()
+		if ret <= 0 {
+			return ret
+		}
+
+		if !host.interceptEvent(event) {
+			return ret
+		}
+
+		// The event went to a connection or a handshake, look for another one without
+		// waiting.
+		event.goEvent = nil
+		event.packet = nil
+		timeout = 0
+	}
+}
+
+func (host *enetHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	if host.destroyed {
+		return nil, errHostDestroyed
+	}
+	threadCheck(host.goHost, "Host.Connect")
+	peer := host.goHost.Connect((addr.(*enetAddress)).addr, channelCount, data)
+
+	if peer == nil {
+		return nil, errors.New("couldn't connect to foreign peer")
+	}
+
+	ret := enetPeer{
+		goPeer: peer,
+	}
+	host.noise.connecting(ret)
+	host.config.connecting(ret)
+	host.outbox.track(ret)
+	host.versions.connecting(ret)
+	return ret, nil
+}
+
+// NewHost creats a host for communicating to peers. Like the C library, the socket
+// is dual stack and only bound to a port when addr is set.
+func NewHost(addr Address, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32, bufferLimit int) (Host, error) {
+	if host, ok, err := factoryHost(addr, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth); ok {
+		return host, err
+	}
+
+	local := &net.UDPAddr{IP: net.IPv6unspecified}
+	if addr != nil {
+		local = (addr.(*enetAddress)).addr.UDPAddr()
+	}
+
+	conn, err := net.ListenUDP("udp", local)
+	if err != nil {
+		return nil, errors.New("unable to create host")
+	}
+
+	bufferLimit = min(max(bufferLimit, hostBufferSizeMin), hostBufferSizeMax)
+	conn.SetReadBuffer(bufferLimit)
+	conn.SetWriteBuffer(bufferLimit)
+
+	host, err := protocol.NewHost(conn, int(peerCount), int(channelLimit), incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New("unable to create host")
+	}
+
+	ret := &enetHost{
+		hostBackend: hostBackend{goHost: host},
+	}
+	registerHost(host, ret)
+	return ret, nil
+}
+
+func newTransportHost(transport Transport, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (Host, error) {
+	var conn protocol.Conn = transport
+	if polling, ok := transport.(pollingTransport); ok && polling.polling() {
+		conn = polledTransport{polling}
+	}
+	host, err := protocol.NewHost(conn, int(peerCount), int(channelLimit), incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		return nil, errors.New("unable to create host")
+	}
+
+	ret := &enetHost{
+		hostBackend: hostBackend{goHost: host},
+	}
+	registerHost(host, ret)
+	return ret, nil
+}
+
+// setProtocol switches the header format. Checksums are not implemented by the Go
+// protocol.
+func (host *enetHost) setProtocol(config ProtocolConfig) error {
+	threadCheck(host.goHost, "SetProtocol")
+	if config.Checksum {
+		return errors.New("checksums are not supported without cgo")
+	}
+	host.goHost.StockProtocol = config.Protocol == ProtocolStock
+	return nil
+}
+
+// BroadcastPacket hands the packet over to the host, which drops it once it has been
+// sent to all peers.
+func (host *enetHost) BroadcastPacket(packet Packet, channel uint8) error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	threadCheck(host.goHost, "Host.BroadcastPacket")
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	goPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+
+	host.dumpBroadcast(channel, goPacket)
+	host.goHost.Broadcast(channel, goPacket)
+	return nil
+}
+
+func (host *enetHost) GetBytesSent() uint32 {
+	threadCheck(host.goHost, "Host.GetBytesSent")
+	return host.goHost.TotalSentData
+}
+
+func (host *enetHost) GetPacketsSent() uint32 {
+	threadCheck(host.goHost, "Host.GetPacketsSent")
+	return host.goHost.TotalSentPackets
+}
+
+func (host *enetHost) GetBytesReceived() uint32 {
+	threadCheck(host.goHost, "Host.GetBytesReceived")
+	return host.goHost.TotalReceivedData
+}
+
+func (host *enetHost) GetPacketsReceived() uint32 {
+	threadCheck(host.goHost, "Host.GetPacketsReceived")
+	return host.goHost.TotalReceivedPackets
+}
+
+func (host *enetHost) ResetBytesSent() {
+	threadCheck(host.goHost, "Host.ResetBytesSent")
+	host.goHost.TotalSentData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetBytesReceived() {
+	threadCheck(host.goHost, "Host.ResetBytesReceived")
+	host.goHost.TotalReceivedData = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsSent() {
+	threadCheck(host.goHost, "Host.ResetPacketsSent")
+	host.goHost.TotalSentPackets = 0
+	host.updateStats()
+}
+
+func (host *enetHost) ResetPacketsReceived() {
+	threadCheck(host.goHost, "Host.ResetPacketsReceived")
+	host.goHost.TotalReceivedPackets = 0
+	host.updateStats()
+}
+
+// updateStats copies the counters of the host to the stats.
+func (host *enetHost) updateStats() {
+	host.stats.update(
+		host.goHost.TotalSentData,
+		host.goHost.TotalReceivedData,
+		host.goHost.TotalSentPackets,
+		host.goHost.TotalReceivedPackets,
+		uint32(host.goHost.ConnectedPeers()),
+	)
+	host.updatePeerStats()
+}
+
+// connectedPeerList returns the peers of the host that are connected.
+func (host *enetHost) connectedPeerList() []enetPeer {
+	var peers []enetPeer
+	all := host.goHost.Peers()
+	for i := range all {
+		if all[i].State() == protocol.PeerStateConnected {
+			peers = append(peers, enetPeer{goPeer: &all[i]})
+		}
+	}
+	return peers
+}
+
+// wireProtocol returns the wire format the host talks, to decode its datagrams with.
+func (host *enetHost) wireProtocol() ProtocolConfig {
+	if host.goHost.StockProtocol {
+		return ProtocolConfig{Protocol: ProtocolStock}
+	}
+	return ProtocolConfig{}
+}
+
+// serviceClock returns the clock of the host in milliseconds as of the service call in
+// progress, which the host times acknowledgements against.
+func (host *enetHost) serviceClock() uint32 {
+	return host.goHost.ServiceTime()
+}
+
+// datagramPeer returns the connected peer a datagram received from addr with peerID in
+// its header comes from.
+func (host *enetHost) datagramPeer(addr rawAddress, peerID uint16) (enetPeer, bool) {
+	all := host.goHost.Peers()
+	if int(peerID) >= len(all) {
+		return enetPeer{}, false
+	}
+	peer := &all[peerID]
+	if peer.State() != protocol.PeerStateConnected || peer.Address() != addr {
+		return enetPeer{}, false
+	}
+	return enetPeer{goPeer: peer}, true
+}
+
+// seedRandom seeds the connect IDs of the host.
+func (host *enetHost) seedRandom(seed uint32) {
+	host.goHost.SeedRandom(seed)
+}
+
+// setBandwidthLimit sets the bandwidth of the host, told to its peers.
+func (host *enetHost) setBandwidthLimit(incoming, outgoing uint32) error {
+	host.goHost.BandwidthLimit(incoming, outgoing)
+	return nil
+}
+
+// applyConfig applies the settings of config that are up to the protocol.
+func (host *enetHost) applyConfig(config HostConfig) error {
+	threadCheck(host.goHost, "Host.ApplyConfig")
+	host.goHost.BandwidthLimit(config.IncomingBandwidth, config.OutgoingBandwidth)
+	host.goHost.ChannelLimit(int(min(config.ChannelLimit, math.MaxInt32)))
+	duplicates := math.MaxInt32
+	if config.MaxPeersPerIP > 0 {
+		duplicates = config.MaxPeersPerIP
+	}
+	host.goHost.SetMaxDuplicatePeers(duplicates)
+	return nil
+}
+
+// polledTransport makes a polling transport a protocol.Poller.
+type polledTransport struct {
+	pollingTransport
+}
+
+func (t polledTransport) Poll(p []byte) (int, net.Addr, bool) {
+	return t.poll(p)
+}
+
+// time returns the time of the host in milliseconds.
+func (host *enetHost) time() uint32 {
+	threadCheck(host.goHost, "SetHostClock")
+	return host.goHost.Time()
+}
+
+// setClock makes the host run off clock.
+func (host *enetHost) setClock(clock *hostClock) error {
+	host.clock = clock
+	host.goHost.Clock = clock.now
+	return nil
+}
@@ -0,0 +1,169 @@
+package enet
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// HostConfig holds the settings of a host that can be changed while it runs, for
+// example as a configuration file is reloaded, see Host.ApplyConfig. Zero fields take
+// the defaults of NewHost and enet.
+type HostConfig struct {
+	// IncomingBandwidth and OutgoingBandwidth are the bandwidth of the host in bytes per
+	// second, 0 meaning unlimited, see SetBandwidthLimit
+	IncomingBandwidth uint32
+	OutgoingBandwidth uint32
+
+	// ChannelLimit limits the channels of the peers connecting from now on. Defaults to
+	// the maximum.
+	ChannelLimit uint64
+
+	// MaxPeersPerIP limits the peers connected from the same IP address. Defaults to no
+	// limit.
+	MaxPeersPerIP int
+
+	// ConnectRate limits the connections accepted per second. Peers connecting beyond
+	// it are disconnected before Service returns them. Defaults to no limit.
+	ConnectRate float64
+
+	// ConnectBurst is the number of connections accepted at once before ConnectRate
+	// applies. Defaults to ConnectRate, and at least 1.
+	ConnectBurst int
+
+	// TimeoutLimit, TimeoutMinimum and TimeoutMaximum are the timeout of the peers
+	// connecting from now on, see Peer.SetTimeout. Defaults to those of enet.
+	TimeoutLimit   uint32
+	TimeoutMinimum time.Duration
+	TimeoutMaximum time.Duration
+
+	// PingInterval is how often the peers connecting from now on are pinged while
+	// nothing else is sent to them. Defaults to that of enet.
+	PingInterval time.Duration
+
+	// Logger logs the decisions of the host: peers connecting and disconnecting and
+	// why, throttling of peers engaging and releasing, sends failing and stalls caught
+	// by a Watchdog at info level and above, and datagrams consumed by its interceptors
+	// at debug level. Defaults to slog.Default, which hosts log with until configured,
+	// and slog.New(slog.DiscardHandler) silences the host.
+	Logger *slog.Logger
+}
+
+// validate checks config, returning it with the defaults applied.
+func (config HostConfig) validate() (HostConfig, error) {
+	if config.MaxPeersPerIP < 0 || config.ConnectBurst < 0 {
+		return config, errors.New("limits can't be negative")
+	}
+	if config.ConnectRate < 0 || math.IsNaN(config.ConnectRate) {
+		return config, errors.New("connect rate can't be negative")
+	}
+	if config.TimeoutMinimum < 0 || config.TimeoutMaximum < 0 || config.PingInterval < 0 {
+		return config, errors.New("durations can't be negative")
+	}
+	if config.ConnectRate > 0 && config.ConnectBurst == 0 {
+		config.ConnectBurst = max(int(math.Ceil(config.ConnectRate)), 1)
+	}
+	return config, nil
+}
+
+// hostConfigTable applies the settings of a HostConfig to the peers connecting to a
+// host.
+type hostConfigTable struct {
+	lock    sync.Mutex
+	applied bool
+	config  HostConfig
+
+	// tokens is the number of connections that can be accepted as of last.
+	tokens float64
+	last   time.Time
+
+	// initiated holds the peers the host connects to, which the connect rate does not
+	// apply to.
+	initiated map[enetPeer]struct{}
+}
+
+func (host *enetHost) ApplyConfig(config HostConfig) error {
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	config, err := config.validate()
+	if err != nil {
+		return err
+	}
+	if err := host.applyConfig(config); err != nil {
+		return err
+	}
+
+	table := &host.config
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if !table.applied || table.config.ConnectBurst != config.ConnectBurst {
+		table.tokens = float64(config.ConnectBurst)
+	}
+	table.applied = true
+	table.config = config
+	if table.initiated == nil {
+		table.initiated = make(map[enetPeer]struct{})
+	}
+	host.log.logger.Store(config.Logger)
+	host.features.join(featureConfig)
+	return nil
+}
+
+// connecting remembers that the host connects to peer.
+func (table *hostConfigTable) connecting(peer enetPeer) {
+	table.lock.Lock()
+	if table.applied && table.config.ConnectRate > 0 {
+		table.initiated[peer] = struct{}{}
+	}
+	table.lock.Unlock()
+}
+
+// admit disconnects the peers connecting past the connect rate of the host, and sets up
+// the others. Returns true if the event has been consumed.
+func (table *hostConfigTable) admit(host *enetHost, event *enetEvent) bool {
+	switch event.GetType() {
+	case EventConnect:
+	case EventDisconnect, EventDisconnectTimeout:
+		table.lock.Lock()
+		if peer, ok := event.GetPeer().(enetPeer); ok {
+			delete(table.initiated, peer)
+		}
+		table.lock.Unlock()
+		return false
+	default:
+		return false
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if !table.applied {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+	_, initiated := table.initiated[peer]
+	delete(table.initiated, peer)
+
+	config := &table.config
+	if config.ConnectRate > 0 && !initiated {
+		now := host.syncTime()
+		if !table.last.IsZero() {
+			refill := now.Sub(table.last).Seconds() * config.ConnectRate
+			table.tokens = min(table.tokens+refill, float64(config.ConnectBurst))
+		}
+		table.last = now
+		if table.tokens < 1 {
+			peer.DisconnectNow(0)
+			return true
+		}
+		table.tokens--
+	}
+
+	peer.SetTimeout(config.TimeoutLimit, uint32(config.TimeoutMinimum.Milliseconds()), uint32(config.TimeoutMaximum.Milliseconds()))
+	peer.setPingInterval(uint32(config.PingInterval.Milliseconds()))
+	return false
+}
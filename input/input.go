@@ -0,0 +1,198 @@
+// Package input sends the inputs of players the way action games do: unreliably, so a
+// lost packet never holds the following ones back, with every packet carrying the
+// last few inputs again, so an input is only lost if as many packets in a row are:
+//
+//	sender, err := input.NewSender(input.Config{Channel: 1, Redundancy: 4})
+//
+//	// Every tick, on the client:
+//	sender.Send(server, encodeInput(buttons, aim))
+//
+// The server receives every input once and in order, whichever packets carried it:
+//
+//	receiver := input.NewReceiver(input.Config{Channel: 1})
+//
+//	// For every event:
+//	if inputs, ok := receiver.Handle(event); ok {
+//		for _, in := range inputs {
+//			apply(event.GetPeer(), in.Data)
+//		}
+//	}
+package input
+
+import (
+	"encoding/binary"
+	"errors"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// DefaultRedundancy is the number of inputs every packet carries by default
+const DefaultRedundancy = 3
+
+// headerSize is the size of the sequence number of the newest input and the number of
+// inputs leading every packet.
+const headerSize = 5
+
+var errMalformed = errors.New("malformed input packet")
+
+// Input is an input of a player
+type Input struct {
+	// Seq is the sequence number of the input, counting from 1. Inputs lost for good
+	// leave gaps between the sequence numbers of the inputs received.
+	Seq  uint32
+	Data []byte
+}
+
+// Config configures a Sender or a Receiver
+type Config struct {
+	// Channel is the channel inputs are sent on
+	Channel uint8
+
+	// Redundancy is the number of inputs every packet carries, the newest and those
+	// before it. Defaults to DefaultRedundancy, and is at most 255.
+	Redundancy int
+}
+
+// Sender sends inputs to a peer, see the package documentation. It is not safe for
+// concurrent use.
+type Sender struct {
+	config Config
+	seq    uint32
+	recent [][]byte
+}
+
+// NewSender creates a sender
+func NewSender(config Config) (*Sender, error) {
+	if config.Redundancy == 0 {
+		config.Redundancy = DefaultRedundancy
+	}
+	if config.Redundancy < 1 || config.Redundancy > 255 {
+		return nil, errors.New("redundancy must be between 1 and 255")
+	}
+	return &Sender{config: config}, nil
+}
+
+// Send sends input to peer, along with the inputs sent before it, and returns its
+// sequence number
+func (s *Sender) Send(peer enet.Peer, input []byte) (uint32, error) {
+	return s.seq + 1, peer.SendBytes(s.Encode(input), s.config.Channel, 0)
+}
+
+// Encode numbers input and encodes it along with the inputs before it as Send does,
+// for applications sending it themselves
+func (s *Sender) Encode(input []byte) []byte {
+	s.seq++
+	if len(s.recent) == s.config.Redundancy {
+		copy(s.recent, s.recent[1:])
+		s.recent = s.recent[:len(s.recent)-1]
+	}
+	s.recent = append(s.recent, append([]byte(nil), input...))
+
+	data := binary.BigEndian.AppendUint32(nil, s.seq)
+	data = append(data, byte(len(s.recent)))
+	for i := len(s.recent) - 1; i >= 0; i-- {
+		data = binary.AppendUvarint(data, uint64(len(s.recent[i])))
+		data = append(data, s.recent[i]...)
+	}
+	return data
+}
+
+// Seq returns the sequence number of the last input sent
+func (s *Sender) Seq() uint32 {
+	return s.seq
+}
+
+// receiverPeer is the state of the inputs received from a peer.
+type receiverPeer struct {
+	last uint32
+	lost uint64
+}
+
+// Receiver receives the inputs of peers, see the package documentation. It is not safe
+// for concurrent use, and must be used by the goroutine servicing the host of its
+// peers.
+type Receiver struct {
+	config Config
+	peers  map[enet.Peer]*receiverPeer
+}
+
+// NewReceiver creates a receiver
+func NewReceiver(config Config) *Receiver {
+	return &Receiver{config: config, peers: make(map[enet.Peer]*receiverPeer)}
+}
+
+// Handle returns the inputs received on the input channel that had not been received
+// before, oldest first, and true if the event carried inputs. Inputs older than the
+// newest one received, arriving late, are dropped. Peers are forgotten once they
+// disconnect. The packet of the event is left to the caller.
+func (r *Receiver) Handle(event enet.Event) ([]Input, bool) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		delete(r.peers, event.GetPeer())
+		return nil, false
+	case enet.EventReceive:
+	default:
+		return nil, false
+	}
+	if event.GetChannelID() != r.config.Channel {
+		return nil, false
+	}
+	inputs, _ := r.Receive(event.GetPeer(), event.GetPacket().GetData())
+	return inputs, true
+}
+
+// Receive decodes a packet of inputs of peer as Handle does, for applications receiving
+// them themselves
+func (r *Receiver) Receive(peer enet.Peer, data []byte) ([]Input, error) {
+	if len(data) < headerSize {
+		return nil, errMalformed
+	}
+	newest := binary.BigEndian.Uint32(data)
+	count := int(data[4])
+	data = data[headerSize:]
+
+	p, ok := r.peers[peer]
+	if !ok {
+		p = &receiverPeer{}
+		r.peers[peer] = p
+	}
+	if int32(newest-p.last) <= 0 {
+		return nil, nil
+	}
+
+	// Inputs come newest first, and are returned oldest first.
+	fresh := min(count, int(newest-p.last))
+	inputs := make([]Input, fresh)
+	for i := 0; i < fresh; i++ {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, errMalformed
+		}
+		inputs[fresh-1-i] = Input{
+			Seq:  newest - uint32(i),
+			Data: append([]byte(nil), data[n:n+int(size)]...),
+		}
+		data = data[n+int(size):]
+	}
+
+	p.lost += uint64(newest - p.last - uint32(fresh))
+	p.last = newest
+	return inputs, nil
+}
+
+// Last returns the sequence number of the newest input received from peer
+func (r *Receiver) Last(peer enet.Peer) uint32 {
+	if p, ok := r.peers[peer]; ok {
+		return p.last
+	}
+	return 0
+}
+
+// Lost returns the number of inputs of peer lost for good, as all the packets carrying
+// them were
+func (r *Receiver) Lost(peer enet.Peer) uint64 {
+	if p, ok := r.peers[peer]; ok {
+		return p.lost
+	}
+	return 0
+}
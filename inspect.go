@@ -0,0 +1,373 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CommandType is the type of a protocol command, with the values of the C library
+type CommandType uint8
+
+// The types of commands, see the ENet protocol for what each does
+const (
+	CommandAcknowledge            CommandType = 1
+	CommandConnect                CommandType = 2
+	CommandVerifyConnect          CommandType = 3
+	CommandDisconnect             CommandType = 4
+	CommandPing                   CommandType = 5
+	CommandSendReliable           CommandType = 6
+	CommandSendUnreliable         CommandType = 7
+	CommandSendFragment           CommandType = 8
+	CommandSendUnsequenced        CommandType = 9
+	CommandBandwidthLimit         CommandType = 10
+	CommandThrottleConfigure      CommandType = 11
+	CommandSendUnreliableFragment CommandType = 12
+)
+
+const (
+	// wirePeerIDNone is the peer ID of datagrams sent before a peer has one.
+	wirePeerIDNone = 0xFFF
+
+	wireCommandMask            = 0x0F
+	wireCommandFlagAcknowledge = 1 << 7
+	wireCommandFlagUnsequenced = 1 << 6
+
+	wireHeaderFlagSentTime      = 1 << 14
+	wireStockHeaderFlagCompress = 1 << 14
+	wireStockHeaderFlagSentTime = 1 << 15
+	wireHeaderSessionMask       = 3 << 12
+	wireHeaderSessionShift      = 12
+)
+
+var commandNames = [...]string{
+	CommandAcknowledge:            "acknowledge",
+	CommandConnect:                "connect",
+	CommandVerifyConnect:          "verify_connect",
+	CommandDisconnect:             "disconnect",
+	CommandPing:                   "ping",
+	CommandSendReliable:           "send_reliable",
+	CommandSendUnreliable:         "send_unreliable",
+	CommandSendFragment:           "send_fragment",
+	CommandSendUnsequenced:        "send_unsequenced",
+	CommandBandwidthLimit:         "bandwidth_limit",
+	CommandThrottleConfigure:      "throttle_configure",
+	CommandSendUnreliableFragment: "send_unreliable_fragment",
+}
+
+// wireCommandSizes are the sizes of the commands on the wire, not counting their
+// payload.
+var wireCommandSizes = [...]int{
+	CommandAcknowledge:            8,
+	CommandConnect:                48,
+	CommandVerifyConnect:          44,
+	CommandDisconnect:             8,
+	CommandPing:                   4,
+	CommandSendReliable:           6,
+	CommandSendUnreliable:         8,
+	CommandSendFragment:           24,
+	CommandSendUnsequenced:        8,
+	CommandBandwidthLimit:         12,
+	CommandThrottleConfigure:      16,
+	CommandSendUnreliableFragment: 24,
+}
+
+func (t CommandType) String() string {
+	if int(t) < len(commandNames) && commandNames[t] != "" {
+		return commandNames[t]
+	}
+	return fmt.Sprintf("CommandType(%d)", uint8(t))
+}
+
+// Datagram is a datagram of the ENet protocol, as decoded by DecodeDatagram
+type Datagram struct {
+	// PeerID is the ID the receiving host knows the sending peer by, 0xFFF until the
+	// peer has one
+	PeerID    uint16
+	SessionID uint8
+
+	// SentTime is the low 16 bits of the clock of the sender in milliseconds, sent
+	// with datagrams carrying commands to acknowledge
+	SentTime    uint16
+	HasSentTime bool
+
+	// Compressed is set on compressed datagrams of ProtocolStock, which can't be
+	// decoded any further
+	Compressed bool
+
+	// Checksum of the datagram, if the protocol it was decoded with has checksums
+	Checksum uint64
+
+	Commands []Command
+}
+
+// Command is a command of a datagram. Besides the header common to all commands, only
+// the fields of its type are set.
+type Command struct {
+	Type CommandType
+
+	// Acknowledge is set on commands the receiver must acknowledge
+	Acknowledge bool
+
+	// Unsequenced is set on unsequenced sends
+	Unsequenced bool
+
+	ChannelID              uint8
+	ReliableSequenceNumber uint16
+
+	// Acknowledge
+	ReceivedReliableSequenceNumber uint16
+	ReceivedSentTime               uint16
+
+	// Connect and VerifyConnect
+	OutgoingPeerID             uint16
+	IncomingSessionID          uint8
+	OutgoingSessionID          uint8
+	MTU                        uint32
+	WindowSize                 uint32
+	ChannelCount               uint32
+	IncomingBandwidth          uint32 // also BandwidthLimit
+	OutgoingBandwidth          uint32 // also BandwidthLimit
+	PacketThrottleInterval     uint32 // also ThrottleConfigure
+	PacketThrottleAcceleration uint32 // also ThrottleConfigure
+	PacketThrottleDeceleration uint32 // also ThrottleConfigure
+	ConnectID                  uint32
+
+	// Data is the user data of Connect and Disconnect
+	Data uint32
+
+	// Sends
+	UnreliableSequenceNumber uint16
+	UnsequencedGroup         uint16
+
+	// Fragments
+	StartSequenceNumber uint16
+	FragmentCount       uint32
+	FragmentNumber      uint32
+	TotalLength         uint32
+	FragmentOffset      uint32
+
+	// Payload of sends, pointing into the decoded datagram
+	Payload []byte
+}
+
+// DecodeDatagram decodes the protocol headers and commands of a datagram of the wire
+// format of config, for example one offered to HostPacketConn or captured off the
+// network. Payloads are not copied and point into data. Datagrams that can't be
+// decoded completely return an error along with what could be decoded.
+func DecodeDatagram(data []byte, config ProtocolConfig) (Datagram, error) {
+	ret, offset, err := decodeWireHeader(data, config)
+	if err != nil {
+		return ret, err
+	}
+
+	for offset < len(data) {
+		command, n, err := decodeWireCommand(data[offset:])
+		if err != nil {
+			return ret, fmt.Errorf("command %d at offset %d: %w", len(ret.Commands), offset, err)
+		}
+		ret.Commands = append(ret.Commands, command)
+		offset += n
+	}
+	return ret, nil
+}
+
+// decodeWireHeader decodes the header of a datagram, returning the offset of its first
+// command.
+func decodeWireHeader(data []byte, config ProtocolConfig) (Datagram, int, error) {
+	var ret Datagram
+	if len(data) < 2 {
+		return ret, 0, errors.New("datagram too short for its header")
+	}
+
+	header := binary.BigEndian.Uint16(data)
+	hasSentTime := header&wireHeaderFlagSentTime != 0
+	if config.Protocol == ProtocolStock {
+		ret.Compressed = header&wireStockHeaderFlagCompress != 0
+		hasSentTime = header&wireStockHeaderFlagSentTime != 0
+	}
+	ret.PeerID = header & wirePeerIDNone
+	ret.SessionID = uint8((header & wireHeaderSessionMask) >> wireHeaderSessionShift)
+
+	offset := 2
+	if hasSentTime {
+		if len(data) < 4 {
+			return ret, 0, errors.New("datagram too short for its header")
+		}
+		ret.SentTime = binary.BigEndian.Uint16(data[2:])
+		ret.HasSentTime = true
+		offset = 4
+	}
+	if config.Checksum {
+		size := 8
+		if config.Protocol == ProtocolStock {
+			size = 4
+		}
+		if len(data) < offset+size {
+			return ret, 0, errors.New("datagram too short for its checksum")
+		}
+		if size == 4 {
+			ret.Checksum = uint64(binary.LittleEndian.Uint32(data[offset:]))
+		} else {
+			ret.Checksum = binary.LittleEndian.Uint64(data[offset:])
+		}
+		offset += size
+	}
+	if ret.Compressed {
+		return ret, 0, errors.New("compressed datagrams can't be decoded")
+	}
+	return ret, offset, nil
+}
+
+// decodeWireCommand decodes the command at the start of b, returning its length
+// including its payload.
+func decodeWireCommand(b []byte) (Command, int, error) {
+	if len(b) < 4 {
+		return Command{}, 0, errors.New("truncated command header")
+	}
+	be := binary.BigEndian
+	c := Command{
+		Type:                   CommandType(b[0] & wireCommandMask),
+		Acknowledge:            b[0]&wireCommandFlagAcknowledge != 0,
+		Unsequenced:            b[0]&wireCommandFlagUnsequenced != 0,
+		ChannelID:              b[1],
+		ReliableSequenceNumber: be.Uint16(b[2:]),
+	}
+	if int(c.Type) >= len(wireCommandSizes) || wireCommandSizes[c.Type] == 0 {
+		return c, 0, fmt.Errorf("unknown command type %d", uint8(c.Type))
+	}
+	size := wireCommandSizes[c.Type]
+	if len(b) < size {
+		return c, 0, fmt.Errorf("truncated %s command", c.Type)
+	}
+
+	var dataLength int
+	switch c.Type {
+	case CommandAcknowledge:
+		c.ReceivedReliableSequenceNumber = be.Uint16(b[4:])
+		c.ReceivedSentTime = be.Uint16(b[6:])
+
+	case CommandConnect, CommandVerifyConnect:
+		c.OutgoingPeerID = be.Uint16(b[4:])
+		c.IncomingSessionID = b[6]
+		c.OutgoingSessionID = b[7]
+		c.MTU = be.Uint32(b[8:])
+		c.WindowSize = be.Uint32(b[12:])
+		c.ChannelCount = be.Uint32(b[16:])
+		c.IncomingBandwidth = be.Uint32(b[20:])
+		c.OutgoingBandwidth = be.Uint32(b[24:])
+		c.PacketThrottleInterval = be.Uint32(b[28:])
+		c.PacketThrottleAcceleration = be.Uint32(b[32:])
+		c.PacketThrottleDeceleration = be.Uint32(b[36:])
+		c.ConnectID = be.Uint32(b[40:])
+		if c.Type == CommandConnect {
+			c.Data = be.Uint32(b[44:])
+		}
+
+	case CommandDisconnect:
+		c.Data = be.Uint32(b[4:])
+
+	case CommandSendReliable:
+		dataLength = int(be.Uint16(b[4:]))
+
+	case CommandSendUnreliable:
+		c.UnreliableSequenceNumber = be.Uint16(b[4:])
+		dataLength = int(be.Uint16(b[6:]))
+
+	case CommandSendUnsequenced:
+		c.UnsequencedGroup = be.Uint16(b[4:])
+		dataLength = int(be.Uint16(b[6:]))
+
+	case CommandSendFragment, CommandSendUnreliableFragment:
+		c.StartSequenceNumber = be.Uint16(b[4:])
+		dataLength = int(be.Uint16(b[6:]))
+		c.FragmentCount = be.Uint32(b[8:])
+		c.FragmentNumber = be.Uint32(b[12:])
+		c.TotalLength = be.Uint32(b[16:])
+		c.FragmentOffset = be.Uint32(b[20:])
+
+	case CommandBandwidthLimit:
+		c.IncomingBandwidth = be.Uint32(b[4:])
+		c.OutgoingBandwidth = be.Uint32(b[8:])
+
+	case CommandThrottleConfigure:
+		c.PacketThrottleInterval = be.Uint32(b[4:])
+		c.PacketThrottleAcceleration = be.Uint32(b[8:])
+		c.PacketThrottleDeceleration = be.Uint32(b[12:])
+	}
+
+	if len(b) < size+dataLength {
+		return c, 0, fmt.Errorf("truncated payload of %s command", c.Type)
+	}
+	if dataLength > 0 {
+		c.Payload = b[size : size+dataLength : size+dataLength]
+	}
+	return c, size + dataLength, nil
+}
+
+// String formats the datagram as one line per command after a line for its header
+func (d Datagram) String() string {
+	var b strings.Builder
+	if d.PeerID == wirePeerIDNone {
+		b.WriteString("peer=none")
+	} else {
+		fmt.Fprintf(&b, "peer=%d", d.PeerID)
+	}
+	fmt.Fprintf(&b, " session=%d", d.SessionID)
+	if d.HasSentTime {
+		fmt.Fprintf(&b, " sent_time=%d", d.SentTime)
+	}
+	if d.Checksum != 0 {
+		fmt.Fprintf(&b, " checksum=%#x", d.Checksum)
+	}
+	if d.Compressed {
+		b.WriteString(" compressed")
+	}
+	for _, c := range d.Commands {
+		b.WriteString("\n  ")
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+// String formats the command and the fields of its type on one line
+func (c Command) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s channel=%d reliable_seq=%d", c.Type, c.ChannelID, c.ReliableSequenceNumber)
+	if c.Acknowledge {
+		b.WriteString(" ack")
+	}
+	if c.Unsequenced {
+		b.WriteString(" unsequenced")
+	}
+
+	switch c.Type {
+	case CommandAcknowledge:
+		fmt.Fprintf(&b, " received_reliable_seq=%d received_sent_time=%d", c.ReceivedReliableSequenceNumber, c.ReceivedSentTime)
+	case CommandConnect, CommandVerifyConnect:
+		fmt.Fprintf(&b, " outgoing_peer=%d sessions=%d/%d mtu=%d window=%d channels=%d connect_id=%#x",
+			c.OutgoingPeerID, c.IncomingSessionID, c.OutgoingSessionID, c.MTU, c.WindowSize, c.ChannelCount, c.ConnectID)
+		if c.Type == CommandConnect {
+			fmt.Fprintf(&b, " data=%d", c.Data)
+		}
+	case CommandDisconnect:
+		fmt.Fprintf(&b, " data=%d", c.Data)
+	case CommandSendUnreliable:
+		fmt.Fprintf(&b, " unreliable_seq=%d", c.UnreliableSequenceNumber)
+	case CommandSendUnsequenced:
+		fmt.Fprintf(&b, " group=%d", c.UnsequencedGroup)
+	case CommandSendFragment, CommandSendUnreliableFragment:
+		fmt.Fprintf(&b, " start_seq=%d fragment=%d/%d offset=%d total=%d",
+			c.StartSequenceNumber, c.FragmentNumber, c.FragmentCount, c.FragmentOffset, c.TotalLength)
+	case CommandBandwidthLimit:
+		fmt.Fprintf(&b, " incoming=%d outgoing=%d", c.IncomingBandwidth, c.OutgoingBandwidth)
+	case CommandThrottleConfigure:
+		fmt.Fprintf(&b, " interval=%d acceleration=%d deceleration=%d",
+			c.PacketThrottleInterval, c.PacketThrottleAcceleration, c.PacketThrottleDeceleration)
+	}
+	if c.Payload != nil {
+		fmt.Fprintf(&b, " size=%d", len(c.Payload))
+	}
+	return b.String()
+}
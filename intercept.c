@@ -0,0 +1,70 @@
+//go:build !purego && !(js && wasm)
+
+#include "enet.h"
+#include "_cgo_export.h"
+
+// The intercept callback is not told which host it is called for, so the host being
+// serviced is remembered per thread for the duration of the service call.
+static __thread ENetHost* goenet_current_host;
+
+static int goenet_intercept(ENetEvent* event, ENetAddress* address, uint8_t* data, int length) {
+	return goenetIntercept(goenet_current_host, address, data, length);
+}
+
+int goenet_host_service(ENetHost* host, ENetEvent* event, uint32_t timeout) {
+	ENetHost* previous = goenet_current_host;
+	int ret;
+
+	goenet_current_host = host;
+	ret = enet_host_service(host, event, timeout);
+	goenet_current_host = previous;
+
+	return ret;
+}
+
+void goenet_peer_send_batch(ENetHost* host, goenet_deferred_send* sends, int count, int flush) {
+	int i;
+
+	for (i = 0; i < count; i++) {
+		sends[i].sent = enet_peer_send(sends[i].peer, sends[i].channel, sends[i].packet) == 0;
+	}
+	if (flush) {
+		enet_host_flush(host);
+	}
+}
+
+int goenet_host_service_batch(ENetHost* host, ENetEvent* events, int count, uint32_t timeout) {
+	ENetHost* previous = goenet_current_host;
+	int ret, n = 0;
+
+	goenet_current_host = host;
+	ret = enet_host_service(host, &events[0], timeout);
+	if (ret > 0) {
+		for (n = 1; n < count && enet_host_check_events(host, &events[n]) > 0; n++) {
+		}
+	}
+	goenet_current_host = previous;
+
+	return ret > 0 ? n : ret;
+}
+
+void goenet_host_set_intercept(ENetHost* host, int enabled) {
+	enet_host_set_intercept_callback(host, enabled ? goenet_intercept : NULL);
+}
+
+int goenet_socket_send(ENetHost* host, const ENetAddress* address, void* data, size_t length) {
+	ENetBuffer buffer;
+
+	buffer.data = data;
+	buffer.dataLength = length;
+
+	return enet_host_raw_send(host, address, &buffer, 1);
+}
+
+static int goenet_send(ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount) {
+	return goenetSend(host, (ENetAddress*)address, (ENetBuffer*)buffers, bufferCount);
+}
+
+void goenet_host_set_send_hook(ENetHost* host) {
+	enet_host_set_send_callback(host, goenet_send);
+}
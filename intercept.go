@@ -0,0 +1,56 @@
+package enet
+
+import "sync"
+
+// interceptor is offered every datagram received by a host before enet processes it.
+// It returns true if it consumed the datagram, in which case enet never sees it. The
+// data is only valid for the duration of the call.
+type interceptor func(addr rawAddress, data []byte) bool
+
+// interceptChain holds the interceptors of a host.
+type interceptChain struct {
+	lock         sync.RWMutex
+	host         *enetHost
+	installed    bool
+	nextID       int
+	interceptors map[int]interceptor
+}
+
+// add registers an interceptor and returns the id to remove it with. The chain is
+// installed on the host on first use and stays installed, so interceptors can be
+// added and removed without touching the host again.
+func (chain *interceptChain) add(host *enetHost, fn interceptor) int {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if chain.interceptors == nil {
+		chain.interceptors = make(map[int]interceptor)
+	}
+	chain.nextID++
+	chain.interceptors[chain.nextID] = fn
+	if !chain.installed {
+		host.installIntercept()
+		chain.host = host
+		chain.installed = true
+	}
+	return chain.nextID
+}
+
+func (chain *interceptChain) remove(id int) {
+	chain.lock.Lock()
+	delete(chain.interceptors, id)
+	chain.lock.Unlock()
+}
+
+func (chain *interceptChain) intercept(addr rawAddress, data []byte) bool {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, fn := range chain.interceptors {
+		if fn(addr, data) {
+			logIntercepted(chain.host, addr, len(data))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+//go:build js && wasm
+
+package enet
+
+import (
+	"errors"
+	"net"
+)
+
+// rawAddress is the address of a datagram as the backend hands it to interceptors.
+type rawAddress = *net.UDPAddr
+
+// installIntercept does nothing, browser hosts have no socket to intercept.
+func (host *enetHost) installIntercept() {}
+
+// installSendHook does nothing, conditions are not simulated in the browser.
+func (host *enetHost) installSendHook() {}
+
+// socketSend fails, browsers can't send raw datagrams.
+func (host *enetHost) socketSend(addr *net.UDPAddr, data []byte) error {
+	return errors.New("raw datagrams are not supported in the browser")
+}
+
+// localUDPAddr returns an empty address, browser hosts are not bound to a port.
+func (host *enetHost) localUDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{}
+}
+
+// udpAddrOf converts an enet address to a net.UDPAddr.
+func udpAddrOf(addr *net.UDPAddr) *net.UDPAddr {
+	return addr
+}
@@ -0,0 +1,102 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+// void goenet_host_set_intercept(ENetHost* host, int enabled);
+// int goenet_socket_send(ENetHost* host, const ENetAddress* address, void* data, size_t length);
+// void goenet_host_set_send_hook(ENetHost* host);
+import "C"
+import (
+	"errors"
+	"net"
+	"unsafe"
+)
+
+// rawAddress is the address of a datagram as the backend hands it to interceptors.
+type rawAddress = *C.ENetAddress
+
+// installIntercept makes the C host offer every datagram to the intercept chain.
+func (host *enetHost) installIntercept() {
+	C.goenet_host_set_intercept(host.cHost, 1)
+}
+
+//export goenetIntercept
+func goenetIntercept(cHost *C.ENetHost, address *C.ENetAddress, data *C.uint8_t, length C.int) C.int {
+	host := hostOf(cHost)
+	if host == nil {
+		return 0
+	}
+	if host.intercepts.intercept(address, unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length))) {
+		return 1
+	}
+	return 0
+}
+
+// installSendHook makes the C host offer every datagram it sends to the conditions
+// simulated for its peers.
+func (host *enetHost) installSendHook() {
+	C.goenet_host_set_send_hook(host.cHost)
+}
+
+//export goenetSend
+func goenetSend(cHost *C.ENetHost, address *C.ENetAddress, buffers *C.ENetBuffer, bufferCount C.size_t) C.int {
+	host := hostOf(cHost)
+	if host == nil {
+		return 0
+	}
+	addr := udpAddrOf(address)
+	if !host.conditions.shaped(addr) {
+		return 0
+	}
+	var data []byte
+	for _, buffer := range unsafe.Slice(buffers, int(bufferCount)) {
+		data = append(data, unsafe.Slice((*byte)(buffer.data), int(buffer.dataLength))...)
+	}
+	if host.conditions.send(addr, data) {
+		return 1
+	}
+	return 0
+}
+
+// socketSend sends a raw datagram through the socket of the host, bypassing enet.
+func (host *enetHost) socketSend(addr *net.UDPAddr, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	buffer := C.CBytes(data)
+	defer C.free(buffer)
+
+	cAddr := enetAddressOf(addr)
+	if C.goenet_socket_send(host.cHost, &cAddr, buffer, C.size_t(len(data))) < 0 {
+		return errors.New("unable to send datagram")
+	}
+	return nil
+}
+
+// localUDPAddr returns the address the socket of the host is bound to.
+func (host *enetHost) localUDPAddr() *net.UDPAddr {
+	return udpAddrOf(&host.cHost.address)
+}
+
+// udpAddrOf converts an enet address to a net.UDPAddr.
+func udpAddrOf(addr *C.ENetAddress) *net.UDPAddr {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, (*[net.IPv6len]byte)(unsafe.Pointer(addr))[:])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.UDPAddr{IP: ip, Port: int(addr.port)}
+}
+
+// enetAddressOf converts a net.UDPAddr to an enet address.
+func enetAddressOf(addr *net.UDPAddr) C.ENetAddress {
+	var ret C.ENetAddress
+	ip := addr.IP.To16()
+	if ip == nil {
+		ip = net.IPv6unspecified
+	}
+	copy((*[net.IPv6len]byte)(unsafe.Pointer(&ret))[:], ip)
+	ret.port = C.uint16_t(addr.Port)
+	return ret
+}
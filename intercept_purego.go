@@ -0,0 +1,49 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+// rawAddress is the address of a datagram as the backend hands it to interceptors.
+type rawAddress = protocol.Address
+
+// installIntercept makes the host offer every datagram to the intercept chain.
+func (host *enetHost) installIntercept() {
+	host.goHost.Intercept = host.intercepts.intercept
+}
+
+// installSendHook makes the host offer every datagram it sends to the conditions
+// simulated for its peers.
+func (host *enetHost) installSendHook() {
+	host.goHost.Outgoing = func(addr protocol.Address, data []byte) bool {
+		udpAddr := addr.UDPAddr()
+		return host.conditions.shaped(udpAddr) && host.conditions.send(udpAddr, bytes.Clone(data))
+	}
+}
+
+// socketSend sends a raw datagram through the socket of the host, bypassing enet.
+func (host *enetHost) socketSend(addr *net.UDPAddr, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := host.goHost.SendTo(protocol.AddressOf(addr), data); err != nil {
+		return errors.New("unable to send datagram")
+	}
+	return nil
+}
+
+// localUDPAddr returns the address the socket of the host is bound to.
+func (host *enetHost) localUDPAddr() *net.UDPAddr {
+	return host.goHost.Address().UDPAddr()
+}
+
+// udpAddrOf converts an enet address to a net.UDPAddr.
+func udpAddrOf(addr protocol.Address) *net.UDPAddr {
+	return addr.UDPAddr()
+}
@@ -0,0 +1,139 @@
+package interest
+
+import (
+	"math"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// gridMaxQueryCells bounds the cells a query looks into, queries covering more cells
+// look at every peer instead.
+const gridMaxQueryCells = 4096
+
+type gridCell [3]int64
+
+type gridRange struct {
+	min, max gridCell
+}
+
+func (r gridRange) cells() int {
+	n := 1
+	for i := range r.min {
+		span := r.max[i] - r.min[i] + 1
+		if span > gridMaxQueryCells || n*int(span) > gridMaxQueryCells {
+			return gridMaxQueryCells + 1
+		}
+		n *= int(span)
+	}
+	return n
+}
+
+func (r gridRange) each(fn func(cell gridCell)) {
+	for x := r.min[0]; x <= r.max[0]; x++ {
+		for y := r.min[1]; y <= r.max[1]; y++ {
+			for z := r.min[2]; z <= r.max[2]; z++ {
+				fn(gridCell{x, y, z})
+			}
+		}
+	}
+}
+
+// grid is a uniform grid, indexing areas in every cell their bounding box covers.
+type grid struct {
+	size  float64
+	cells map[gridCell]map[enet.Peer]struct{}
+	peers map[enet.Peer]gridRange
+
+	// large holds the peers whose areas cover too many cells to be indexed in each.
+	large map[enet.Peer]struct{}
+}
+
+// NewGrid creates a uniform grid index with cells of size, which is best around the
+// usual radius of the areas of interest
+func NewGrid(size float64) Index {
+	if size <= 0 {
+		size = DefaultCellSize
+	}
+	return &grid{
+		size:  size,
+		cells: make(map[gridCell]map[enet.Peer]struct{}),
+		peers: make(map[enet.Peer]gridRange),
+		large: make(map[enet.Peer]struct{}),
+	}
+}
+
+func (g *grid) cellOf(x float64) int64 {
+	return int64(math.Floor(x / g.size))
+}
+
+func (g *grid) rangeOf(area Area) gridRange {
+	c, r := area.Center, area.Radius
+	return gridRange{
+		min: gridCell{g.cellOf(c.X - r), g.cellOf(c.Y - r), g.cellOf(c.Z - r)},
+		max: gridCell{g.cellOf(c.X + r), g.cellOf(c.Y + r), g.cellOf(c.Z + r)},
+	}
+}
+
+func (g *grid) Insert(peer enet.Peer, area Area) {
+	r := g.rangeOf(area)
+	if old, ok := g.peers[peer]; ok && old == r {
+		return
+	}
+	g.Remove(peer)
+	g.peers[peer] = r
+	if r.cells() > gridMaxQueryCells {
+		g.large[peer] = struct{}{}
+		return
+	}
+	r.each(func(cell gridCell) {
+		peers, ok := g.cells[cell]
+		if !ok {
+			peers = make(map[enet.Peer]struct{})
+			g.cells[cell] = peers
+		}
+		peers[peer] = struct{}{}
+	})
+}
+
+func (g *grid) Remove(peer enet.Peer) {
+	r, ok := g.peers[peer]
+	if !ok {
+		return
+	}
+	delete(g.peers, peer)
+	if _, ok := g.large[peer]; ok {
+		delete(g.large, peer)
+		return
+	}
+	r.each(func(cell gridCell) {
+		if peers, ok := g.cells[cell]; ok {
+			delete(peers, peer)
+			if len(peers) == 0 {
+				delete(g.cells, cell)
+			}
+		}
+	})
+}
+
+func (g *grid) Query(area Area, fn func(peer enet.Peer)) {
+	r := g.rangeOf(area)
+	if r.cells() > gridMaxQueryCells {
+		for peer := range g.peers {
+			fn(peer)
+		}
+		return
+	}
+
+	seen := make(map[enet.Peer]struct{})
+	r.each(func(cell gridCell) {
+		for peer := range g.cells[cell] {
+			if _, ok := seen[peer]; !ok {
+				seen[peer] = struct{}{}
+				fn(peer)
+			}
+		}
+	})
+	for peer := range g.large {
+		fn(peer)
+	}
+}
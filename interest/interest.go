@@ -0,0 +1,153 @@
+// Package interest sends messages only to the peers they matter to, the interest
+// management of open world and battle royale servers. Every peer registers its area of
+// interest, the sphere around its position it wants to hear about, and messages about
+// something happening somewhere reach the peers whose areas intersect it:
+//
+//	manager := interest.NewManager(interest.Config{})
+//	manager.Update(peer, interest.Vec{X: 10, Y: 4}, 50)
+//
+//	// On the goroutine servicing the host:
+//	manager.BroadcastSpatial(explosion, 20, data, 0, enet.PacketFlagReliable)
+//	manager.Handle(event)
+//
+// Areas are found through a spatial index, a uniform grid by default.
+package interest
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// DefaultCellSize is the size of the cells of the grid of a Manager by default
+const DefaultCellSize = 64
+
+// Vec is a position. 2D worlds leave Z at 0.
+type Vec struct {
+	X, Y, Z float64
+}
+
+// Distance returns the distance between v and w
+func (v Vec) Distance(w Vec) float64 {
+	return math.Sqrt((v.X-w.X)*(v.X-w.X) + (v.Y-w.Y)*(v.Y-w.Y) + (v.Z-w.Z)*(v.Z-w.Z))
+}
+
+// Area is the sphere of Radius around Center
+type Area struct {
+	Center Vec
+	Radius float64
+}
+
+// Intersects returns true if a and b overlap
+func (a Area) Intersects(b Area) bool {
+	return a.Center.Distance(b.Center) <= a.Radius+b.Radius
+}
+
+// Index is a spatial index of the areas of interest of peers. It is called with the
+// lock of its Manager held, so it need not be safe for concurrent use.
+type Index interface {
+	// Insert indexes the area of peer, replacing the one it had
+	Insert(peer enet.Peer, area Area)
+
+	// Remove removes peer from the index
+	Remove(peer enet.Peer)
+
+	// Query calls fn for every peer whose area may intersect area. It may call it for
+	// peers whose area does not, which the Manager filters out, but must call it once
+	// at most per peer.
+	Query(area Area, fn func(peer enet.Peer))
+}
+
+// Config configures a Manager
+type Config struct {
+	// Index indexes the areas of interest. Defaults to a grid with cells of
+	// DefaultCellSize.
+	Index Index
+}
+
+// Manager holds the areas of interest of peers. Its methods are safe to call from any
+// goroutine, apart from Handle and broadcasting, which must be done by the goroutine
+// servicing the host of the peers.
+type Manager struct {
+	lock  sync.Mutex
+	index Index
+	areas map[enet.Peer]Area
+}
+
+// NewManager creates a manager without peers
+func NewManager(config Config) *Manager {
+	if config.Index == nil {
+		config.Index = NewGrid(DefaultCellSize)
+	}
+	return &Manager{
+		index: config.Index,
+		areas: make(map[enet.Peer]Area),
+	}
+}
+
+// Update sets the area of interest of peer to radius around pos, typically as the
+// player moves
+func (m *Manager) Update(peer enet.Peer, pos Vec, radius float64) {
+	area := Area{Center: pos, Radius: radius}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.areas[peer] = area
+	m.index.Insert(peer, area)
+}
+
+// Remove removes the area of interest of peer, which no longer gets spatial broadcasts
+func (m *Manager) Remove(peer enet.Peer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.areas[peer]; ok {
+		delete(m.areas, peer)
+		m.index.Remove(peer)
+	}
+}
+
+// Area returns the area of interest of peer
+func (m *Manager) Area(peer enet.Peer) (Area, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	area, ok := m.areas[peer]
+	return area, ok
+}
+
+// Interested returns the peers whose area of interest intersects radius around pos
+func (m *Manager) Interested(pos Vec, radius float64) []enet.Peer {
+	area := Area{Center: pos, Radius: radius}
+	var peers []enet.Peer
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.index.Query(area, func(peer enet.Peer) {
+		if a, ok := m.areas[peer]; ok && a.Intersects(area) {
+			peers = append(peers, peer)
+		}
+	})
+	return peers
+}
+
+// BroadcastSpatial sends data to the peers whose area of interest intersects radius
+// around pos, a single packet being shared by all of them. Peers that can't be sent
+// to, for example because they are disconnecting, are skipped.
+func (m *Manager) BroadcastSpatial(pos Vec, radius float64, data []byte, channel uint8, flags enet.PacketFlags) error {
+	if radius < 0 {
+		return errors.New("radius is negative")
+	}
+	peers := m.Interested(pos, radius)
+	if len(peers) == 0 {
+		return nil
+	}
+	return enet.NewGroup(peers...).Broadcast(data, channel, flags)
+}
+
+// Handle removes the area of interest of peers as they disconnect, since their IDs are
+// reused. It can be called for every event serviced.
+func (m *Manager) Handle(event enet.Event) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		m.Remove(event.GetPeer())
+	}
+}
@@ -0,0 +1,376 @@
+// Package bridge implements the virtual peers shared by the bridges connecting non-enet
+// clients to a host.
+package bridge
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// firstID is the ID of the first virtual peer, well above the IDs enet gives its own
+// peers so both never collide.
+const firstID = 1 << 31
+
+// ids hands out peer IDs across all bridges of the process.
+var ids atomic.Uint32
+
+// eventQueueSize is the number of events that can be waiting for the host before the
+// connections of a bridge stop being read.
+const eventQueueSize = 256
+
+// Transport carries the packets of a single virtual peer
+type Transport interface {
+	// Send transmits data to the remote side. It is safe for concurrent use.
+	Send(channel uint8, flags enet.PacketFlags, data []byte) error
+
+	// Close ends the connection, passing data on to the remote side if possible
+	Close(data uint32) error
+
+	RemoteAddr() net.Addr
+}
+
+// Hub keeps track of the peers of a bridge and implements enet.Bridge for it
+type Hub struct {
+	events chan enet.Event
+	done   chan struct{}
+	posts  sync.WaitGroup
+
+	lock   sync.Mutex
+	closed bool
+	peers  map[uint32]*Peer
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{
+		events: make(chan enet.Event, eventQueueSize),
+		done:   make(chan struct{}),
+		peers:  make(map[uint32]*Peer),
+	}
+}
+
+// Events implements enet.Bridge
+func (hub *Hub) Events() <-chan enet.Event {
+	return hub.events
+}
+
+// Connect adds a peer communicating through transport and posts its connect event
+func (hub *Hub) Connect(transport Transport, data uint32) (*Peer, error) {
+	peer := &Peer{
+		hub:       hub,
+		transport: transport,
+		id:        firstID + ids.Add(1) - 1,
+		address:   addressOf(transport.RemoteAddr()),
+	}
+
+	hub.lock.Lock()
+	if hub.closed {
+		hub.lock.Unlock()
+		return nil, errors.New("bridge is closed")
+	}
+	hub.peers[peer.id] = peer
+	hub.lock.Unlock()
+
+	hub.post(&Event{eventType: enet.EventConnect, peer: peer, data: data})
+	return peer, nil
+}
+
+// Broadcast implements enet.Bridge
+func (hub *Hub) Broadcast(data []byte, channel uint8, flags enet.PacketFlags) error {
+	hub.lock.Lock()
+	peers := make([]*Peer, 0, len(hub.peers))
+	for _, peer := range hub.peers {
+		peers = append(peers, peer)
+	}
+	hub.lock.Unlock()
+
+	var errs []error
+	for _, peer := range peers {
+		errs = append(errs, peer.SendBytes(data, channel, flags))
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements enet.Bridge
+func (hub *Hub) Close() error {
+	hub.lock.Lock()
+	if hub.closed {
+		hub.lock.Unlock()
+		return errors.New("bridge is closed")
+	}
+	hub.closed = true
+	peers := hub.peers
+	hub.peers = nil
+	hub.lock.Unlock()
+
+	close(hub.done)
+	hub.posts.Wait()
+	close(hub.events)
+
+	var errs []error
+	for _, peer := range peers {
+		if peer.state.Swap(peerClosed) != peerClosed {
+			errs = append(errs, peer.transport.Close(0))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post queues an event for the host, unless the hub is closed.
+func (hub *Hub) post(event *Event) {
+	hub.lock.Lock()
+	if hub.closed {
+		hub.lock.Unlock()
+		return
+	}
+	hub.posts.Add(1)
+	hub.lock.Unlock()
+	defer hub.posts.Done()
+
+	event.timestamp = time.Now()
+	select {
+	case hub.events <- event:
+	case <-hub.done:
+	}
+}
+
+func (hub *Hub) remove(peer *Peer) {
+	hub.lock.Lock()
+	delete(hub.peers, peer.id)
+	hub.lock.Unlock()
+}
+
+// addressOf converts a net.Addr to an enet.Address, falling back to the unspecified
+// address for anything that isn't an IP address.
+func addressOf(addr net.Addr) enet.Address {
+	host, port := "::", 0
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		host, port = a.IP.String(), a.Port
+	case *net.UDPAddr:
+		host, port = a.IP.String(), a.Port
+	}
+	return enet.NewAddress(host, uint16(port))
+}
+
+// Event is an event of a virtual peer
+type Event struct {
+	eventType enet.EventType
+	peer      *Peer
+	channelID uint8
+	data      uint32
+	packet    *Packet
+	timestamp time.Time
+}
+
+func (event *Event) GetType() enet.EventType { return event.eventType }
+func (event *Event) GetPeer() enet.Peer      { return event.peer }
+func (event *Event) GetChannelID() uint8     { return event.channelID }
+func (event *Event) GetData() uint32         { return event.data }
+func (event *Event) GetTimestamp() time.Time { return event.timestamp }
+
+func (event *Event) GetPacket() enet.Packet {
+	if event.packet == nil {
+		return nil
+	}
+	return event.packet
+}
+
+func (event *Event) GetPacketDataUnsafe() []byte {
+	if event.packet == nil {
+		return nil
+	}
+	return event.packet.data
+}
+
+func (event *Event) ReadPayload(buf []byte) (int, error) {
+	if event.packet == nil {
+		return 0, errors.New("event has no packet")
+	}
+	n := copy(buf, event.packet.data)
+	if n < len(event.packet.data) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Packet is a packet received from a virtual peer. Its data lives in Go memory, so
+// destroying it does nothing.
+type Packet struct {
+	data  []byte
+	flags enet.PacketFlags
+}
+
+func (packet *Packet) Destroy() error {
+	return nil
+}
+
+func (packet *Packet) GetData() []byte {
+	return packet.data
+}
+
+func (packet *Packet) GetFlags() enet.PacketFlags {
+	return packet.flags
+}
+
+const (
+	peerConnected int32 = iota
+	peerClosed
+)
+
+// Peer is a client connected through a bridge. It is safe for concurrent use.
+type Peer struct {
+	enet.PeerTags
+
+	hub       *Hub
+	transport Transport
+	id        uint32
+	address   enet.Address
+	state     atomic.Int32
+
+	lock sync.Mutex
+	data []byte
+
+	bytesSent       atomic.Uint64
+	bytesReceived   atomic.Uint64
+	packetsSent     atomic.Uint64
+	packetsReceived atomic.Uint64
+}
+
+// Receive posts a receive event for data received from the remote side
+func (peer *Peer) Receive(channel uint8, flags enet.PacketFlags, data []byte) {
+	if peer.state.Load() == peerClosed {
+		return
+	}
+	peer.bytesReceived.Add(uint64(len(data)))
+	peer.packetsReceived.Add(1)
+	peer.hub.post(&Event{
+		eventType: enet.EventReceive,
+		peer:      peer,
+		channelID: channel,
+		packet:    &Packet{data: data, flags: flags},
+	})
+}
+
+// Disconnected posts the disconnect event of a connection closed by the remote side
+// or lost, unless it was closed locally.
+func (peer *Peer) Disconnected(eventType enet.EventType, data uint32) {
+	if peer.state.Swap(peerClosed) == peerClosed {
+		return
+	}
+	peer.hub.remove(peer)
+	peer.hub.post(&Event{eventType: eventType, peer: peer, data: data})
+}
+
+func (peer *Peer) GetAddress() enet.Address {
+	return peer.address
+}
+
+func (peer *Peer) GetID() uint32 {
+	return peer.id
+}
+
+// Identity returns nil, bridged clients don't authenticate with a static key
+func (peer *Peer) Identity() []byte {
+	return nil
+}
+
+// PublicKey returns nil, bridged clients have no identity key
+func (peer *Peer) PublicKey() ed25519.PublicKey {
+	return nil
+}
+
+func (peer *Peer) Disconnect(data uint32) {
+	if peer.state.Swap(peerClosed) == peerClosed {
+		return
+	}
+	peer.hub.remove(peer)
+	peer.transport.Close(data)
+	// Like enet, confirm a graceful disconnect with an event.
+	peer.hub.post(&Event{eventType: enet.EventDisconnect, peer: peer, data: data})
+}
+
+func (peer *Peer) DisconnectNow(data uint32) {
+	if peer.state.Swap(peerClosed) == peerClosed {
+		return
+	}
+	peer.hub.remove(peer)
+	peer.transport.Close(data)
+}
+
+func (peer *Peer) DisconnectLater(data uint32) {
+	// Transports flush their queued data before closing anyway.
+	peer.Disconnect(data)
+}
+
+// SetTimeout does nothing, transports detect broken connections on their own.
+func (peer *Peer) SetTimeout(limit uint32, min uint32, max uint32) {}
+
+// PingInterval does nothing, transports keep their connections alive on their own.
+func (peer *Peer) PingInterval(interval uint32) {}
+
+func (peer *Peer) SendBytes(data []byte, channel uint8, flags enet.PacketFlags) error {
+	if peer.state.Load() == peerClosed {
+		return errors.New("peer is disconnected")
+	}
+	if err := peer.transport.Send(channel, flags, data); err != nil {
+		return err
+	}
+	peer.bytesSent.Add(uint64(len(data)))
+	peer.packetsSent.Add(1)
+	return nil
+}
+
+func (peer *Peer) SendString(str string, channel uint8, flags enet.PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
+
+// SendPacket sends the data of the packet and destroys it, as enet would once it has
+// been sent.
+func (peer *Peer) SendPacket(packet enet.Packet, channel uint8) error {
+	if err := peer.SendBytes(packet.GetData(), channel, packet.GetFlags()); err != nil {
+		return err
+	}
+	return packet.Destroy()
+}
+
+func (peer *Peer) SendAsync(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return peer.SendBytes(data, channel, flags)
+}
+
+func (peer *Peer) SetData(data []byte) {
+	peer.lock.Lock()
+	peer.data = data
+	peer.lock.Unlock()
+}
+
+func (peer *Peer) GetData() []byte {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.data
+}
+
+func (peer *Peer) GetBytesSent() uint64     { return peer.bytesSent.Load() }
+func (peer *Peer) GetBytesReceived() uint64 { return peer.bytesReceived.Load() }
+func (peer *Peer) GetPacketsSent() uint64   { return peer.packetsSent.Load() }
+
+// GetPacketsLost always returns 0, transports either deliver packets or fail.
+func (peer *Peer) GetPacketsLost() uint64 { return 0 }
+
+// EstimatedBandwidth returns 0, congestion control is left to the transports.
+func (peer *Peer) EstimatedBandwidth() uint32 { return 0 }
+
+// SimulateConditions fails, the transports carry the connection.
+func (peer *Peer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	return errors.New("conditions cannot be simulated on bridged connections")
+}
+
+// ClockOffset returns 0, clocks are not synchronized over bridged connections.
+func (peer *Peer) ClockOffset() time.Duration { return 0 }
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Address is an IPv6 address and port. IPv4 addresses are stored IPv4-mapped, like
+// the dual-stack sockets of enet see them.
+type Address struct {
+	IP   [net.IPv6len]byte
+	Port uint16
+}
+
+// AddressOf converts a UDP address to an Address.
+func AddressOf(addr net.Addr) Address {
+	var ret Address
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		if ip := a.IP.To16(); ip != nil {
+			copy(ret.IP[:], ip)
+		}
+		ret.Port = uint16(a.Port)
+	default:
+		if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+			ret.IP = ap.Addr().As16()
+			ret.Port = ap.Port()
+		}
+	}
+	return ret
+}
+
+// UDPAddr converts the address to a net.UDPAddr, with IPv4 addresses in their short
+// form.
+func (addr Address) UDPAddr() *net.UDPAddr {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, addr.IP[:])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.UDPAddr{IP: ip, Port: int(addr.Port)}
+}
+
+// String returns the IP of the address, like enet_address_get_ip.
+func (addr Address) String() string {
+	ip := netip.AddrFrom16(addr.IP)
+	if ip.Is4In6() {
+		ip = ip.Unmap()
+	}
+	return ip.String()
+}
+
+// HostPort returns the address in the host:port form.
+func (addr Address) HostPort() string {
+	return net.JoinHostPort(addr.String(), strconv.Itoa(int(addr.Port)))
+}
+
+// isBroadcast reports whether the IPv4 part of the address is the broadcast address,
+// which enet accepts replies to from any address.
+func (addr Address) isBroadcast() bool {
+	return addr.IP[12] == 0xFF && addr.IP[13] == 0xFF && addr.IP[14] == 0xFF && addr.IP[15] == 0xFF
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import "errors"
+
+// ChannelSnapshot holds the sequence numbers of a channel of a peer.
+type ChannelSnapshot struct {
+	OutgoingReliable   uint16
+	OutgoingUnreliable uint16
+	IncomingReliable   uint16
+	IncomingUnreliable uint16
+}
+
+// PeerSnapshot holds what a connected peer is restored from on another host, which
+// takes over its connection. It does not hold commands in flight, so it is only taken
+// of quiet peers.
+type PeerSnapshot struct {
+	ID                             uint16
+	OutgoingID                     uint16
+	ConnectID                      uint32
+	IncomingSessionID              uint8
+	OutgoingSessionID              uint8
+	Address                        Address
+	IncomingBandwidth              uint32
+	OutgoingBandwidth              uint32
+	MTU                            uint32
+	WindowSize                     uint32
+	RoundTripTime                  uint32
+	RoundTripTimeVariance          uint32
+	PingInterval                   uint32
+	TimeoutLimit                   uint32
+	TimeoutMinimum                 uint32
+	TimeoutMaximum                 uint32
+	OutgoingReliableSequenceNumber uint16
+	IncomingUnsequencedGroup       uint16
+	OutgoingUnsequencedGroup       uint16
+	UnsequencedWindow              [peerUnsequencedWindowSize / 32]uint32
+	Channels                       []ChannelSnapshot
+}
+
+// Quiet returns true if the peer is connected with nothing in flight nor waiting to be
+// dispatched, every reliable command it sent acknowledged and every one it received
+// delivered.
+func (peer *Peer) Quiet() bool {
+	if peer.state != PeerStateConnected || peer.needsDispatch {
+		return false
+	}
+	if !peer.sentReliableCommands.empty() || !peer.outgoingCommands.empty() || !peer.dispatchedCommands.empty() {
+		return false
+	}
+	for i := range peer.channelCount {
+		if !peer.channels[i].incomingReliableCommands.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns the state of the peer, see PeerSnapshot.
+func (peer *Peer) Snapshot() PeerSnapshot {
+	snapshot := PeerSnapshot{
+		ID:                             peer.incomingPeerID,
+		OutgoingID:                     peer.outgoingPeerID,
+		ConnectID:                      peer.connectID.Load(),
+		IncomingSessionID:              peer.incomingSessionID,
+		OutgoingSessionID:              peer.outgoingSessionID,
+		Address:                        peer.address,
+		IncomingBandwidth:              peer.incomingBandwidth,
+		OutgoingBandwidth:              peer.outgoingBandwidth,
+		MTU:                            peer.mtu,
+		WindowSize:                     peer.windowSize,
+		RoundTripTime:                  peer.roundTripTime,
+		RoundTripTimeVariance:          peer.roundTripTimeVariance,
+		PingInterval:                   peer.pingInterval,
+		TimeoutLimit:                   peer.timeoutLimit,
+		TimeoutMinimum:                 peer.timeoutMinimum,
+		TimeoutMaximum:                 peer.timeoutMaximum,
+		OutgoingReliableSequenceNumber: peer.outgoingReliableSequenceNumber,
+		IncomingUnsequencedGroup:       peer.incomingUnsequencedGroup,
+		OutgoingUnsequencedGroup:       peer.outgoingUnsequencedGroup,
+		UnsequencedWindow:              peer.unsequencedWindow,
+		Channels:                       make([]ChannelSnapshot, peer.channelCount),
+	}
+	for i := range peer.channelCount {
+		channel := &peer.channels[i]
+		snapshot.Channels[i] = ChannelSnapshot{
+			OutgoingReliable:   channel.outgoingReliableSequenceNumber,
+			OutgoingUnreliable: channel.outgoingUnreliableSequenceNumber,
+			IncomingReliable:   channel.incomingReliableSequenceNumber,
+			IncomingUnreliable: channel.incomingUnreliableSequenceNumber,
+		}
+	}
+	return snapshot
+}
+
+// RestorePeer connects the peer of the host with the ID of snapshot, taking over the
+// connection the snapshot was taken of without the remote host noticing.
+func (host *Host) RestorePeer(snapshot PeerSnapshot) (*Peer, error) {
+	if int(snapshot.ID) >= len(host.peers) {
+		return nil, errors.New("peer ID out of range")
+	}
+	if len(snapshot.Channels) < minimumChannelCount || len(snapshot.Channels) > maximumChannelCount {
+		return nil, errors.New("invalid channel count")
+	}
+	peer := &host.peers[snapshot.ID]
+	if peer.state != PeerStateDisconnected {
+		return nil, errors.New("peer is in use")
+	}
+
+	peer.setupChannels(len(snapshot.Channels))
+	for i, state := range snapshot.Channels {
+		channel := &peer.channels[i]
+		channel.outgoingReliableSequenceNumber = state.OutgoingReliable
+		channel.outgoingUnreliableSequenceNumber = state.OutgoingUnreliable
+		channel.incomingReliableSequenceNumber = state.IncomingReliable
+		channel.incomingUnreliableSequenceNumber = state.IncomingUnreliable
+	}
+	peer.outgoingPeerID = snapshot.OutgoingID
+	peer.connectID.Store(snapshot.ConnectID)
+	peer.incomingSessionID = snapshot.IncomingSessionID
+	peer.outgoingSessionID = snapshot.OutgoingSessionID
+	peer.address = snapshot.Address
+	peer.incomingBandwidth = snapshot.IncomingBandwidth
+	peer.outgoingBandwidth = snapshot.OutgoingBandwidth
+	peer.mtu = snapshot.MTU
+	peer.windowSize = snapshot.WindowSize
+	peer.roundTripTime = snapshot.RoundTripTime
+	peer.roundTripTimeVariance = snapshot.RoundTripTimeVariance
+	peer.lastRoundTripTime = snapshot.RoundTripTime
+	peer.lowestRoundTripTime = snapshot.RoundTripTime
+	peer.pingInterval = snapshot.PingInterval
+	peer.timeoutLimit = snapshot.TimeoutLimit
+	peer.timeoutMinimum = snapshot.TimeoutMinimum
+	peer.timeoutMaximum = snapshot.TimeoutMaximum
+	peer.outgoingReliableSequenceNumber = snapshot.OutgoingReliableSequenceNumber
+	peer.incomingUnsequencedGroup = snapshot.IncomingUnsequencedGroup
+	peer.outgoingUnsequencedGroup = snapshot.OutgoingUnsequencedGroup
+	peer.unsequencedWindow = snapshot.UnsequencedWindow
+
+	now := host.timeGet()
+	peer.lastSendTime = now
+	peer.lastReceiveTime = now
+	peer.onConnect()
+	peer.state = PeerStateConnected
+	return peer, nil
+}
+
+// Conn returns the connection the host sends and receives through.
+func (host *Host) Conn() Conn {
+	return host.conn
+}
@@ -0,0 +1,652 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	EventNone EventType = iota
+	EventConnect
+	EventDisconnect
+	EventReceive
+	EventDisconnectTimeout
+)
+
+// Event is an event returned by Host.Service.
+type Event struct {
+	Type      EventType
+	Peer      *Peer
+	ChannelID uint8
+	Data      uint32
+	Packet    *Packet
+}
+
+// receivedQueueSize is the number of datagrams read ahead of Service. Reading stops
+// once it is full, leaving the rest to the socket buffer.
+const receivedQueueSize = 256
+
+type datagram struct {
+	addr Address
+	data []byte
+}
+
+// Host is a pure Go enet host.
+type Host struct {
+	conn    Conn
+	address Address
+
+	incomingBandwidth          uint32
+	outgoingBandwidth          uint32
+	bandwidthThrottleEpoch     uint32
+	mtu                        uint32
+	randomSeed                 uint32
+	recalculateBandwidthLimits bool
+	preventConnections         bool
+	peers                      []Peer
+	channelLimit               int
+	serviceTime                uint32
+	dispatchQueue              list[Peer]
+	continueSending            bool
+	packetSize                 int
+	headerFlags                uint16
+
+	// TotalSentData and the other totals count the datagrams going through the socket,
+	// they may be reset by the application.
+	TotalSentData        uint32
+	TotalSentPackets     uint32
+	TotalReceivedData    uint32
+	TotalReceivedPackets uint32
+
+	// commandCount and bufferCount bound the datagram being assembled in packetData
+	// like the command and buffer arrays of enet do.
+	commandCount int
+	bufferCount  int
+	packetData   []byte
+
+	receivedAddress       Address
+	receivedData          []byte
+	connectedPeers        int
+	bandwidthLimitedPeers int
+	duplicatePeers        int
+	maximumPacketSize     int
+	maximumWaitingData    int
+
+	// Intercept is offered every received datagram before enet processes it, and
+	// consumes it by returning true.
+	Intercept func(addr Address, data []byte) bool
+
+	// Outgoing is offered every datagram enet sends, and consumes it by returning
+	// true. The data is only valid for the duration of the call.
+	Outgoing func(addr Address, data []byte) bool
+
+	// Clock replaces the enet clock of the host if set, returning milliseconds like
+	// timeGet.
+	Clock func() uint32
+
+	// StockProtocol makes the host talk the header format of stock ENet instead of the
+	// one of ENet-CSharp, which moved the sent time flag. Compressed datagrams of stock
+	// ENet are dropped.
+	StockProtocol bool
+
+	received chan datagram
+	pending  *datagram
+	closed   chan struct{}
+	timer    *time.Timer
+
+	// poller is the conn if the host polls it, with the buffer it polls into.
+	poller     Poller
+	pollBuffer []byte
+}
+
+// Conn carries the datagrams of a host, a net.PacketConn usually.
+type Conn interface {
+	ReadFrom(p []byte) (n int, addr net.Addr, err error)
+	WriteTo(p []byte, addr net.Addr) (n int, err error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Poller is a Conn the host polls for datagrams as it is serviced, instead of reading
+// them on a goroutine of its own.
+type Poller interface {
+	Conn
+
+	// Poll returns the next datagram received, if any, without waiting.
+	Poll(p []byte) (n int, addr net.Addr, ok bool)
+}
+
+// NewHost creates a host communicating through conn, which it owns from now on.
+// peerCount is the maximum number of peers, channelLimit the maximum number of
+// channels per peer (0 for the maximum) and the bandwidths are in bytes per second,
+// 0 meaning unlimited.
+func NewHost(conn Conn, peerCount, channelLimit int, incomingBandwidth, outgoingBandwidth uint32) (*Host, error) {
+	if peerCount > maximumPeerID {
+		return nil, errors.New("too many peers")
+	}
+
+	if channelLimit == 0 || channelLimit > maximumChannelCount {
+		channelLimit = maximumChannelCount
+	} else if channelLimit < minimumChannelCount {
+		channelLimit = minimumChannelCount
+	}
+
+	seed := uint32(time.Now().UnixNano() / int64(time.Millisecond))
+	host := &Host{
+		conn:               conn,
+		address:            AddressOf(conn.LocalAddr()),
+		randomSeed:         seed<<16 | seed>>16,
+		channelLimit:       channelLimit,
+		incomingBandwidth:  incomingBandwidth,
+		outgoingBandwidth:  outgoingBandwidth,
+		mtu:                hostDefaultMTU,
+		peers:              make([]Peer, peerCount),
+		duplicatePeers:     maximumPeerID,
+		maximumPacketSize:  hostDefaultMaximumPacketSize,
+		maximumWaitingData: hostDefaultMaximumWaitingData,
+		packetData:         make([]byte, 0, maximumMTU),
+		received:           make(chan datagram, receivedQueueSize),
+		closed:             make(chan struct{}),
+	}
+	host.dispatchQueue.clear()
+
+	for i := range host.peers {
+		peer := &host.peers[i]
+		peer.host = host
+		peer.incomingPeerID = uint16(i)
+		peer.outgoingSessionID = 0xFF
+		peer.incomingSessionID = 0xFF
+		peer.dispatchList.value = peer
+
+		peer.acknowledgements.clear()
+		peer.sentReliableCommands.clear()
+		peer.sentUnreliableCommands.clear()
+		peer.outgoingCommands.clear()
+		peer.dispatchedCommands.clear()
+		peer.Reset()
+	}
+
+	if poller, ok := conn.(Poller); ok {
+		host.poller = poller
+	} else {
+		go host.read()
+	}
+	return host, nil
+}
+
+// read moves the datagrams arriving on the socket to the received queue.
+func (host *Host) read() {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := host.conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case <-host.closed:
+				return
+			default:
+				continue
+			}
+		}
+
+		dgram := datagram{addr: AddressOf(addr), data: append([]byte(nil), buffer[:n]...)}
+		select {
+		case host.received <- dgram:
+		case <-host.closed:
+			return
+		}
+	}
+}
+
+// Destroy closes the socket and resets all peers without notifying them.
+func (host *Host) Destroy() {
+	close(host.closed)
+	host.conn.Close()
+
+	for i := range host.peers {
+		host.peers[i].Reset()
+	}
+}
+
+// Address returns the local address of the host.
+func (host *Host) Address() Address {
+	return host.address
+}
+
+// ServiceTime returns the clock of the host in milliseconds as of the last time it
+// was serviced.
+func (host *Host) ServiceTime() uint32 {
+	return host.serviceTime
+}
+
+// OutgoingBandwidth returns the upstream bandwidth of the host in bytes per second, 0
+// if unlimited.
+func (host *Host) OutgoingBandwidth() uint32 {
+	return host.outgoingBandwidth
+}
+
+// SeedRandom seeds the connect IDs of the host.
+func (host *Host) SeedRandom(seed uint32) {
+	host.randomSeed = seed
+}
+
+// Time returns the time of the host in milliseconds.
+func (host *Host) Time() uint32 {
+	return host.timeGet()
+}
+
+// timeGet returns the time of the host in milliseconds.
+func (host *Host) timeGet() uint32 {
+	if host.Clock != nil {
+		return host.Clock()
+	}
+	return timeGet()
+}
+
+// ConnectedPeers returns the number of connected peers.
+func (host *Host) ConnectedPeers() int {
+	return host.connectedPeers
+}
+
+// Peers returns the peers of the host, connected or not.
+func (host *Host) Peers() []Peer {
+	return host.peers
+}
+
+// PreventConnections makes the host refuse incoming connections.
+func (host *Host) PreventConnections(prevent bool) {
+	host.preventConnections = prevent
+}
+
+// SetMaxDuplicatePeers limits the number of peers connected from the same IP.
+func (host *Host) SetMaxDuplicatePeers(number int) {
+	host.duplicatePeers = min(max(number, 1), maximumPeerID)
+}
+
+// SendTo sends a raw datagram through the socket of the host, bypassing enet.
+func (host *Host) SendTo(addr Address, data []byte) error {
+	_, err := host.conn.WriteTo(data, addr.UDPAddr())
+	return err
+}
+
+// Connect initiates a connection to a foreign host. Returns nil if no peer is
+// available. The connection completes with an EventConnect.
+func (host *Host) Connect(address Address, channelCount int, data uint32) *Peer {
+	if channelCount < minimumChannelCount {
+		channelCount = minimumChannelCount
+	} else if channelCount > maximumChannelCount {
+		channelCount = maximumChannelCount
+	}
+
+	var peer *Peer
+	for i := range host.peers {
+		if host.peers[i].state == PeerStateDisconnected {
+			peer = &host.peers[i]
+			break
+		}
+	}
+	if peer == nil {
+		return nil
+	}
+
+	peer.setupChannels(channelCount)
+	peer.state = PeerStateConnecting
+	peer.address = address
+	host.randomSeed++
+	peer.connectID.Store(host.randomSeed)
+
+	if host.outgoingBandwidth == 0 {
+		peer.windowSize = maximumWindowSize
+	} else {
+		peer.windowSize = (host.outgoingBandwidth / peerWindowSizeScale) * minimumWindowSize
+	}
+	peer.windowSize = min(max(peer.windowSize, minimumWindowSize), maximumWindowSize)
+
+	peer.queueOutgoingCommand(&command{
+		command:                    commandConnect | commandFlagAcknowledge,
+		channelID:                  0xFF,
+		outgoingPeerID:             peer.incomingPeerID,
+		incomingSessionID:          peer.incomingSessionID,
+		outgoingSessionID:          peer.outgoingSessionID,
+		mtu:                        peer.mtu,
+		windowSize:                 peer.windowSize,
+		channelCount:               uint32(channelCount),
+		incomingBandwidth:          host.incomingBandwidth,
+		outgoingBandwidth:          host.outgoingBandwidth,
+		packetThrottleInterval:     peer.packetThrottleInterval,
+		packetThrottleAcceleration: peer.packetThrottleAcceleration,
+		packetThrottleDeceleration: peer.packetThrottleDeceleration,
+		connectID:                  peer.connectID.Load(),
+		data:                       data,
+	}, nil, 0, 0)
+
+	return peer
+}
+
+func (peer *Peer) setupChannels(channelCount int) {
+	peer.channels = make([]channel, channelCount)
+	peer.channelCount = channelCount
+	for i := range peer.channels {
+		peer.channels[i].incomingReliableCommands.clear()
+		peer.channels[i].incomingUnreliableCommands.clear()
+	}
+}
+
+// Broadcast queues packet to be sent to all connected peers.
+func (host *Host) Broadcast(channelID uint8, packet *Packet) {
+	if packet.Flags&PacketFlagInstant != 0 {
+		packet.referenceCount++
+	}
+
+	for i := range host.peers {
+		if host.peers[i].state != PeerStateConnected {
+			continue
+		}
+		host.peers[i].Send(channelID, packet)
+	}
+
+	if packet.Flags&PacketFlagInstant != 0 {
+		packet.referenceCount--
+	}
+}
+
+// ChannelLimit limits the channels of future incoming connections.
+func (host *Host) ChannelLimit(channelLimit int) {
+	if channelLimit == 0 || channelLimit > maximumChannelCount {
+		channelLimit = maximumChannelCount
+	} else if channelLimit < minimumChannelCount {
+		channelLimit = minimumChannelCount
+	}
+	host.channelLimit = channelLimit
+}
+
+// BandwidthLimit changes the bandwidth limits of the host, in bytes per second.
+func (host *Host) BandwidthLimit(incomingBandwidth, outgoingBandwidth uint32) {
+	host.incomingBandwidth = incomingBandwidth
+	host.outgoingBandwidth = outgoingBandwidth
+	host.recalculateBandwidthLimits = true
+}
+
+func (host *Host) bandwidthThrottle() {
+	timeCurrent := host.timeGet()
+	elapsedTime := timeCurrent - host.bandwidthThrottleEpoch
+	peersRemaining := uint32(host.connectedPeers)
+	dataTotal := ^uint32(0)
+	bandwidth := ^uint32(0)
+	throttle := uint32(0)
+	bandwidthLimit := uint32(0)
+	needsAdjustment := host.bandwidthLimitedPeers > 0
+
+	if elapsedTime < hostBandwidthThrottleInterval {
+		return
+	}
+	if host.outgoingBandwidth == 0 && host.incomingBandwidth == 0 {
+		return
+	}
+
+	host.bandwidthThrottleEpoch = timeCurrent
+
+	if peersRemaining == 0 {
+		return
+	}
+
+	active := func(peer *Peer) bool {
+		return peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater
+	}
+
+	if host.outgoingBandwidth != 0 {
+		dataTotal = 0
+		bandwidth = (host.outgoingBandwidth * elapsedTime) / 1000
+
+		for i := range host.peers {
+			if active(&host.peers[i]) {
+				dataTotal += host.peers[i].outgoingDataTotal
+			}
+		}
+	}
+
+	for peersRemaining > 0 && needsAdjustment {
+		needsAdjustment = false
+
+		if dataTotal <= bandwidth {
+			throttle = peerPacketThrottleScale
+		} else {
+			throttle = (bandwidth * peerPacketThrottleScale) / dataTotal
+		}
+
+		for i := range host.peers {
+			peer := &host.peers[i]
+			if !active(peer) || peer.incomingBandwidth == 0 || peer.outgoingBandwidthThrottleEpoch == timeCurrent {
+				continue
+			}
+
+			peerBandwidth := (peer.incomingBandwidth * elapsedTime) / 1000
+			if (throttle*peer.outgoingDataTotal)/peerPacketThrottleScale <= peerBandwidth {
+				continue
+			}
+
+			peer.packetThrottleLimit = (peerBandwidth * peerPacketThrottleScale) / peer.outgoingDataTotal
+			if peer.packetThrottleLimit == 0 {
+				peer.packetThrottleLimit = 1
+			}
+			if peer.packetThrottle > peer.packetThrottleLimit {
+				peer.packetThrottle = peer.packetThrottleLimit
+			}
+
+			peer.outgoingBandwidthThrottleEpoch = timeCurrent
+			peer.incomingDataTotal = 0
+			peer.outgoingDataTotal = 0
+			needsAdjustment = true
+			peersRemaining--
+			bandwidth -= peerBandwidth
+			dataTotal -= peerBandwidth
+		}
+	}
+
+	if peersRemaining > 0 {
+		if dataTotal <= bandwidth {
+			throttle = peerPacketThrottleScale
+		} else {
+			throttle = (bandwidth * peerPacketThrottleScale) / dataTotal
+		}
+
+		for i := range host.peers {
+			peer := &host.peers[i]
+			if !active(peer) || peer.outgoingBandwidthThrottleEpoch == timeCurrent {
+				continue
+			}
+
+			peer.packetThrottleLimit = throttle
+			if peer.packetThrottle > peer.packetThrottleLimit {
+				peer.packetThrottle = peer.packetThrottleLimit
+			}
+			peer.incomingDataTotal = 0
+			peer.outgoingDataTotal = 0
+		}
+	}
+
+	if host.recalculateBandwidthLimits {
+		host.recalculateBandwidthLimits = false
+		peersRemaining = uint32(host.connectedPeers)
+		bandwidth = host.incomingBandwidth
+		needsAdjustment = true
+
+		if bandwidth == 0 {
+			bandwidthLimit = 0
+		} else {
+			for peersRemaining > 0 && needsAdjustment {
+				needsAdjustment = false
+				bandwidthLimit = bandwidth / peersRemaining
+
+				for i := range host.peers {
+					peer := &host.peers[i]
+					if !active(peer) || peer.incomingBandwidthThrottleEpoch == timeCurrent {
+						continue
+					}
+					if peer.outgoingBandwidth > 0 && peer.outgoingBandwidth >= bandwidthLimit {
+						continue
+					}
+
+					peer.incomingBandwidthThrottleEpoch = timeCurrent
+					needsAdjustment = true
+					peersRemaining--
+					bandwidth -= peer.outgoingBandwidth
+				}
+			}
+		}
+
+		for i := range host.peers {
+			peer := &host.peers[i]
+			if !active(peer) {
+				continue
+			}
+
+			cmd := command{
+				command:           commandBandwidthLimit | commandFlagAcknowledge,
+				channelID:         0xFF,
+				outgoingBandwidth: host.outgoingBandwidth,
+			}
+			if peer.incomingBandwidthThrottleEpoch == timeCurrent {
+				cmd.incomingBandwidth = peer.outgoingBandwidth
+			} else {
+				cmd.incomingBandwidth = bandwidthLimit
+			}
+			peer.queueOutgoingCommand(&cmd, nil, 0, 0)
+		}
+	}
+}
+
+// Flush sends all queued commands without servicing the host.
+func (host *Host) Flush() {
+	host.serviceTime = host.timeGet()
+	host.sendOutgoingCommands(nil, false)
+}
+
+// CheckEvents returns a queued event without sending or receiving anything.
+func (host *Host) CheckEvents(event *Event) int {
+	*event = Event{}
+	return host.dispatchIncomingCommands(event)
+}
+
+// Service sends queued commands, receives datagrams and returns the next event,
+// waiting up to timeout milliseconds for one. Returns 1 if an event was returned, 0
+// if not and -1 on errors.
+func (host *Host) Service(event *Event, timeout uint32) int {
+	if event != nil {
+		*event = Event{}
+		if host.dispatchIncomingCommands(event) == 1 {
+			return 1
+		}
+	}
+
+	host.serviceTime = host.timeGet()
+	timeout += host.serviceTime
+
+	for {
+		if timeDifference(host.serviceTime, host.bandwidthThrottleEpoch) >= hostBandwidthThrottleInterval {
+			host.bandwidthThrottle()
+		}
+
+		if ret := host.sendOutgoingCommands(event, true); ret != 0 {
+			return ret
+		}
+		if ret := host.receiveIncomingCommands(event); ret != 0 {
+			return ret
+		}
+		if ret := host.sendOutgoingCommands(event, true); ret != 0 {
+			return ret
+		}
+		if event != nil {
+			if host.dispatchIncomingCommands(event) == 1 {
+				return 1
+			}
+		}
+
+		if timeGreaterEqual(host.serviceTime, timeout) {
+			return 0
+		}
+
+		host.serviceTime = host.timeGet()
+		if timeGreaterEqual(host.serviceTime, timeout) {
+			return 0
+		}
+		if !host.wait(timeDifference(timeout, host.serviceTime)) {
+			return 0
+		}
+		host.serviceTime = host.timeGet()
+	}
+}
+
+// wait waits up to timeout milliseconds for a datagram. Returns false if none arrived.
+func (host *Host) wait(timeout uint32) bool {
+	if host.pending != nil || len(host.received) > 0 {
+		return true
+	}
+	if host.poller != nil {
+		// Time stands still for polled hosts, there is nothing to wait for.
+		dgram, ok := host.nextDatagram()
+		if ok {
+			host.pending = &dgram
+		}
+		return ok
+	}
+
+	if host.timer == nil {
+		host.timer = time.NewTimer(time.Duration(timeout) * time.Millisecond)
+	} else {
+		host.timer.Reset(time.Duration(timeout) * time.Millisecond)
+	}
+
+	select {
+	case dgram := <-host.received:
+		host.timer.Stop()
+		host.pending = &dgram
+		return true
+	case <-host.timer.C:
+		return false
+	}
+}
+
+// nextDatagram returns a received datagram without waiting.
+func (host *Host) nextDatagram() (datagram, bool) {
+	if host.pending != nil {
+		dgram := *host.pending
+		host.pending = nil
+		return dgram, true
+	}
+
+	if host.poller != nil {
+		if host.pollBuffer == nil {
+			host.pollBuffer = make([]byte, 64*1024)
+		}
+		n, addr, ok := host.poller.Poll(host.pollBuffer)
+		if !ok {
+			return datagram{}, false
+		}
+		return datagram{addr: AddressOf(addr), data: append([]byte(nil), host.pollBuffer[:n]...)}, true
+	}
+
+	select {
+	case dgram := <-host.received:
+		return dgram, true
+	default:
+		return datagram{}, false
+	}
+}
+
+func (host *Host) socketSend(addr Address, data []byte) int {
+	if host.Outgoing != nil && host.Outgoing(addr, data) {
+		return len(data)
+	}
+	n, err := host.conn.WriteTo(data, addr.UDPAddr())
+	if err != nil {
+		return -1
+	}
+	return n
+}
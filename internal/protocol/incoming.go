@@ -0,0 +1,912 @@
+package protocol
+
+import "encoding/binary"
+
+func (host *Host) changeState(peer *Peer, state PeerState) {
+	if state == PeerStateConnected || state == PeerStateDisconnectLater {
+		peer.onConnect()
+	} else {
+		peer.onDisconnect()
+	}
+	peer.state = state
+}
+
+func (host *Host) dispatchState(peer *Peer, state PeerState) {
+	host.changeState(peer, state)
+	peer.markDispatch()
+}
+
+func (host *Host) dispatchIncomingCommands(event *Event) int {
+	for !host.dispatchQueue.empty() {
+		peer := listRemove(host.dispatchQueue.begin()).value
+		peer.needsDispatch = false
+
+		switch peer.state {
+		case PeerStateConnectionPending, PeerStateConnectionSucceeded:
+			host.changeState(peer, PeerStateConnected)
+
+			event.Type = EventConnect
+			event.Peer = peer
+			event.Data = peer.eventData
+			return 1
+
+		case PeerStateZombie:
+			host.recalculateBandwidthLimits = true
+
+			event.Type = EventDisconnect
+			event.Peer = peer
+			event.Data = peer.eventData
+
+			peer.Reset()
+			return 1
+
+		case PeerStateConnected:
+			if peer.dispatchedCommands.empty() {
+				continue
+			}
+
+			event.Packet, event.ChannelID = peer.receive()
+			if event.Packet == nil {
+				continue
+			}
+
+			event.Type = EventReceive
+			event.Peer = peer
+
+			if !peer.dispatchedCommands.empty() {
+				peer.markDispatch()
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (host *Host) notifyConnect(peer *Peer, event *Event) {
+	host.recalculateBandwidthLimits = true
+
+	if event != nil {
+		host.changeState(peer, PeerStateConnected)
+
+		peer.totalDataSent = 0
+		peer.totalDataReceived = 0
+		peer.totalPacketsSent = 0
+		peer.totalPacketsLost = 0
+
+		event.Type = EventConnect
+		event.Peer = peer
+		event.Data = peer.eventData
+	} else {
+		state := PeerStateConnectionPending
+		if peer.state == PeerStateConnecting {
+			state = PeerStateConnectionSucceeded
+		}
+		host.dispatchState(peer, state)
+	}
+}
+
+func (host *Host) notifyDisconnect(peer *Peer, event *Event, eventType EventType) {
+	if peer.state >= PeerStateConnectionPending {
+		host.recalculateBandwidthLimits = true
+	}
+
+	if peer.state != PeerStateConnecting && peer.state < PeerStateConnectionSucceeded {
+		peer.Reset()
+	} else if event != nil {
+		event.Type = eventType
+		event.Peer = peer
+		event.Data = 0
+
+		peer.Reset()
+	} else {
+		peer.eventData = 0
+		host.dispatchState(peer, PeerStateZombie)
+	}
+}
+
+func (host *Host) removeSentUnreliableCommands(peer *Peer) {
+	if peer.sentUnreliableCommands.empty() {
+		return
+	}
+
+	for !peer.sentUnreliableCommands.empty() {
+		outgoing := listRemove(peer.sentUnreliableCommands.begin()).value
+		if outgoing.packet != nil {
+			outgoing.packet.release(true)
+		}
+	}
+
+	if peer.state == PeerStateDisconnectLater && peer.outgoingCommands.empty() && peer.sentReliableCommands.empty() {
+		peer.Disconnect(peer.eventData)
+	}
+}
+
+func (host *Host) removeSentReliableCommand(peer *Peer, reliableSequenceNumber uint16, channelID uint8) uint8 {
+	var outgoing *outgoingCommand
+	wasSent := true
+
+	current := peer.sentReliableCommands.begin()
+	for ; current != peer.sentReliableCommands.end(); current = current.next {
+		outgoing = current.value
+		if outgoing.reliableSequenceNumber == reliableSequenceNumber && outgoing.command.channelID == channelID {
+			break
+		}
+	}
+
+	if current == peer.sentReliableCommands.end() {
+		for current = peer.outgoingCommands.begin(); current != peer.outgoingCommands.end(); current = current.next {
+			outgoing = current.value
+			if outgoing.sendAttempts < 1 {
+				return commandNone
+			}
+			if outgoing.reliableSequenceNumber == reliableSequenceNumber && outgoing.command.channelID == channelID {
+				break
+			}
+		}
+
+		if current == peer.outgoingCommands.end() {
+			return commandNone
+		}
+		wasSent = false
+	}
+
+	if outgoing == nil {
+		return commandNone
+	}
+
+	if int(channelID) < peer.channelCount {
+		ch := &peer.channels[channelID]
+		reliableWindow := reliableSequenceNumber / peerReliableWindowSize
+		if ch.reliableWindows[reliableWindow] > 0 {
+			ch.reliableWindows[reliableWindow]--
+			if ch.reliableWindows[reliableWindow] == 0 {
+				ch.usedReliableWindows &^= 1 << reliableWindow
+			}
+		}
+	}
+
+	commandNumber := outgoing.command.command & commandMask
+	listRemove(&outgoing.node)
+
+	if outgoing.packet != nil {
+		if wasSent {
+			peer.reliableDataInTransit -= uint32(outgoing.fragmentLength)
+		}
+		outgoing.packet.release(true)
+	}
+
+	if peer.sentReliableCommands.empty() {
+		return commandNumber
+	}
+
+	outgoing = peer.sentReliableCommands.front()
+	peer.nextTimeout = outgoing.sentTime + outgoing.roundTripTimeout
+	return commandNumber
+}
+
+func (host *Host) handleConnect(cmd *command) *Peer {
+	var peer *Peer
+	duplicatePeers := 0
+	channelCount := int(cmd.channelCount)
+
+	if cmd.channelCount < minimumChannelCount || cmd.channelCount > maximumChannelCount {
+		return nil
+	}
+
+	for i := range host.peers {
+		current := &host.peers[i]
+		if current.state == PeerStateDisconnected {
+			if peer == nil {
+				peer = current
+			}
+		} else if current.state != PeerStateConnecting && current.address.IP == host.receivedAddress.IP {
+			if current.address.Port == host.receivedAddress.Port && current.connectID.Load() == cmd.connectID {
+				return nil
+			}
+			duplicatePeers++
+		}
+	}
+
+	if peer == nil || duplicatePeers >= host.duplicatePeers {
+		return nil
+	}
+
+	if channelCount > host.channelLimit {
+		channelCount = host.channelLimit
+	}
+
+	peer.setupChannels(channelCount)
+	peer.state = PeerStateAcknowledgingConnect
+	peer.connectID.Store(cmd.connectID)
+	peer.address = host.receivedAddress
+	peer.outgoingPeerID = cmd.outgoingPeerID
+	peer.incomingBandwidth = cmd.incomingBandwidth
+	peer.outgoingBandwidth = cmd.outgoingBandwidth
+	peer.packetThrottleInterval = cmd.packetThrottleInterval
+	peer.packetThrottleAcceleration = cmd.packetThrottleAcceleration
+	peer.packetThrottleDeceleration = cmd.packetThrottleDeceleration
+	peer.eventData = cmd.data
+
+	const sessionMask = headerSessionMask >> headerSessionShift
+
+	incomingSessionID := cmd.incomingSessionID
+	if incomingSessionID == 0xFF {
+		incomingSessionID = peer.outgoingSessionID
+	}
+	incomingSessionID = (incomingSessionID + 1) & sessionMask
+	if incomingSessionID == peer.outgoingSessionID {
+		incomingSessionID = (incomingSessionID + 1) & sessionMask
+	}
+	peer.outgoingSessionID = incomingSessionID
+
+	outgoingSessionID := cmd.outgoingSessionID
+	if outgoingSessionID == 0xFF {
+		outgoingSessionID = peer.incomingSessionID
+	}
+	outgoingSessionID = (outgoingSessionID + 1) & sessionMask
+	if outgoingSessionID == peer.incomingSessionID {
+		outgoingSessionID = (outgoingSessionID + 1) & sessionMask
+	}
+	peer.incomingSessionID = outgoingSessionID
+
+	peer.mtu = min(max(cmd.mtu, minimumMTU), maximumMTU)
+
+	if host.outgoingBandwidth == 0 && peer.incomingBandwidth == 0 {
+		peer.windowSize = maximumWindowSize
+	} else if host.outgoingBandwidth == 0 || peer.incomingBandwidth == 0 {
+		peer.windowSize = (max(host.outgoingBandwidth, peer.incomingBandwidth) / peerWindowSizeScale) * minimumWindowSize
+	} else {
+		peer.windowSize = (min(host.outgoingBandwidth, peer.incomingBandwidth) / peerWindowSizeScale) * minimumWindowSize
+	}
+	peer.windowSize = min(max(peer.windowSize, minimumWindowSize), maximumWindowSize)
+
+	var windowSize uint32
+	if host.incomingBandwidth == 0 {
+		windowSize = maximumWindowSize
+	} else {
+		windowSize = (host.incomingBandwidth / peerWindowSizeScale) * minimumWindowSize
+	}
+	windowSize = min(windowSize, cmd.windowSize)
+	windowSize = min(max(windowSize, minimumWindowSize), maximumWindowSize)
+
+	peer.queueOutgoingCommand(&command{
+		command:                    commandVerifyConnect | commandFlagAcknowledge,
+		channelID:                  0xFF,
+		outgoingPeerID:             peer.incomingPeerID,
+		incomingSessionID:          incomingSessionID,
+		outgoingSessionID:          outgoingSessionID,
+		mtu:                        peer.mtu,
+		windowSize:                 windowSize,
+		channelCount:               uint32(channelCount),
+		incomingBandwidth:          host.incomingBandwidth,
+		outgoingBandwidth:          host.outgoingBandwidth,
+		packetThrottleInterval:     peer.packetThrottleInterval,
+		packetThrottleAcceleration: peer.packetThrottleAcceleration,
+		packetThrottleDeceleration: peer.packetThrottleDeceleration,
+		connectID:                  peer.connectID.Load(),
+	}, nil, 0, 0)
+
+	return peer
+}
+
+// payload returns the data following a send command at offset, or false if the
+// command claims more data than the datagram holds.
+func (host *Host) payload(cmd *command, offset *int) ([]byte, bool) {
+	start := *offset
+	*offset += int(cmd.dataLength)
+	if int(cmd.dataLength) > host.maximumPacketSize || *offset > len(host.receivedData) {
+		return nil, false
+	}
+	return host.receivedData[start:*offset], true
+}
+
+func (peer *Peer) receiving(cmd *command) bool {
+	return int(cmd.channelID) < peer.channelCount && (peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater)
+}
+
+func (host *Host) handleSendReliable(peer *Peer, cmd *command, offset *int) int {
+	if !peer.receiving(cmd) {
+		return -1
+	}
+	data, ok := host.payload(cmd, offset)
+	if !ok {
+		return -1
+	}
+	if peer.queueIncomingCommand(cmd, data, len(data), PacketFlagReliable, 0) == nil {
+		return -1
+	}
+	return 0
+}
+
+func (host *Host) handleSendUnsequenced(peer *Peer, cmd *command, offset *int) int {
+	if !peer.receiving(cmd) {
+		return -1
+	}
+	data, ok := host.payload(cmd, offset)
+	if !ok {
+		return -1
+	}
+
+	unsequencedGroup := uint32(cmd.unsequencedGroup)
+	index := unsequencedGroup % peerUnsequencedWindowSize
+
+	if unsequencedGroup < uint32(peer.incomingUnsequencedGroup) {
+		unsequencedGroup += 0x10000
+	}
+	if unsequencedGroup >= uint32(peer.incomingUnsequencedGroup)+peerFreeUnsequencedWindows*peerUnsequencedWindowSize {
+		return 0
+	}
+
+	unsequencedGroup &= 0xFFFF
+
+	if unsequencedGroup-index != uint32(peer.incomingUnsequencedGroup) {
+		peer.incomingUnsequencedGroup = uint16(unsequencedGroup - index)
+		peer.unsequencedWindow = [peerUnsequencedWindowSize / 32]uint32{}
+	} else if peer.unsequencedWindow[index/32]&(1<<(index%32)) != 0 {
+		return 0
+	}
+
+	if peer.queueIncomingCommand(cmd, data, len(data), PacketFlagUnsequenced, 0) == nil {
+		return -1
+	}
+
+	peer.unsequencedWindow[index/32] |= 1 << (index % 32)
+	return 0
+}
+
+func (host *Host) handleSendUnreliable(peer *Peer, cmd *command, offset *int) int {
+	if !peer.receiving(cmd) {
+		return -1
+	}
+	data, ok := host.payload(cmd, offset)
+	if !ok {
+		return -1
+	}
+	if peer.queueIncomingCommand(cmd, data, len(data), 0, 0) == nil {
+		return -1
+	}
+	return 0
+}
+
+// validFragment checks the fragment fields of cmd, mirroring enet.
+func (host *Host) validFragment(cmd *command, fragmentLength uint32) bool {
+	return cmd.fragmentCount <= maximumFragmentCount && cmd.fragmentNumber < cmd.fragmentCount &&
+		cmd.totalLength <= uint32(host.maximumPacketSize) && cmd.fragmentOffset < cmd.totalLength &&
+		fragmentLength <= cmd.totalLength-cmd.fragmentOffset
+}
+
+// addFragment copies a fragment into the packet being reassembled by start. Returns
+// true once all fragments arrived.
+func addFragment(start *incomingCommand, cmd *command, data []byte) bool {
+	fragmentNumber := cmd.fragmentNumber
+	if start.fragments[fragmentNumber/32]&(1<<(fragmentNumber%32)) != 0 {
+		return false
+	}
+
+	start.fragmentsRemaining--
+	start.fragments[fragmentNumber/32] |= 1 << (fragmentNumber % 32)
+
+	fragmentLength := uint32(len(data))
+	if cmd.fragmentOffset+fragmentLength > uint32(len(start.packet.Data)) {
+		fragmentLength = uint32(len(start.packet.Data)) - cmd.fragmentOffset
+	}
+	copy(start.packet.Data[cmd.fragmentOffset:], data[:fragmentLength])
+
+	return start.fragmentsRemaining == 0
+}
+
+func (host *Host) handleSendFragment(peer *Peer, cmd *command, offset *int) int {
+	if !peer.receiving(cmd) {
+		return -1
+	}
+	data, ok := host.payload(cmd, offset)
+	if !ok {
+		return -1
+	}
+
+	ch := &peer.channels[cmd.channelID]
+	startSequenceNumber := uint32(cmd.startSequenceNumber)
+	startWindow := uint16(startSequenceNumber / peerReliableWindowSize)
+	currentWindow := ch.incomingReliableSequenceNumber / peerReliableWindowSize
+
+	if startSequenceNumber < uint32(ch.incomingReliableSequenceNumber) {
+		startWindow += peerReliableWindows
+	}
+	if startWindow < currentWindow || startWindow >= currentWindow+peerFreeReliableWindows-1 {
+		return 0
+	}
+
+	if !host.validFragment(cmd, uint32(len(data))) {
+		return -1
+	}
+
+	var start *incomingCommand
+	for current := ch.incomingReliableCommands.end().prev; current != ch.incomingReliableCommands.end(); current = current.prev {
+		incoming := current.value
+
+		if startSequenceNumber >= uint32(ch.incomingReliableSequenceNumber) {
+			if incoming.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+				continue
+			}
+		} else if incoming.reliableSequenceNumber >= ch.incomingReliableSequenceNumber {
+			break
+		}
+
+		if uint32(incoming.reliableSequenceNumber) <= startSequenceNumber {
+			if uint32(incoming.reliableSequenceNumber) < startSequenceNumber {
+				break
+			}
+
+			if incoming.command.command&commandMask != commandSendFragment || int(cmd.totalLength) != len(incoming.packet.Data) || cmd.fragmentCount != incoming.fragmentCount {
+				return -1
+			}
+			start = incoming
+			break
+		}
+	}
+
+	if start == nil {
+		hostCommand := *cmd
+		hostCommand.reliableSequenceNumber = uint16(startSequenceNumber)
+		start = peer.queueIncomingCommand(&hostCommand, nil, int(cmd.totalLength), PacketFlagReliable, cmd.fragmentCount)
+		if start == nil {
+			return -1
+		}
+	}
+
+	if addFragment(start, cmd, data) {
+		peer.dispatchIncomingReliableCommands(ch, nil)
+	}
+	return 0
+}
+
+func (host *Host) handleSendUnreliableFragment(peer *Peer, cmd *command, offset *int) int {
+	if !peer.receiving(cmd) {
+		return -1
+	}
+	data, ok := host.payload(cmd, offset)
+	if !ok {
+		return -1
+	}
+
+	ch := &peer.channels[cmd.channelID]
+	reliableSequenceNumber := uint32(cmd.reliableSequenceNumber)
+	startSequenceNumber := uint32(cmd.startSequenceNumber)
+	reliableWindow := uint16(reliableSequenceNumber / peerReliableWindowSize)
+	currentWindow := ch.incomingReliableSequenceNumber / peerReliableWindowSize
+
+	if reliableSequenceNumber < uint32(ch.incomingReliableSequenceNumber) {
+		reliableWindow += peerReliableWindows
+	}
+	if reliableWindow < currentWindow || reliableWindow >= currentWindow+peerFreeReliableWindows-1 {
+		return 0
+	}
+
+	if reliableSequenceNumber == uint32(ch.incomingReliableSequenceNumber) && startSequenceNumber <= uint32(ch.incomingUnreliableSequenceNumber) {
+		return 0
+	}
+
+	if !host.validFragment(cmd, uint32(len(data))) {
+		return -1
+	}
+
+	var start *incomingCommand
+	for current := ch.incomingUnreliableCommands.end().prev; current != ch.incomingUnreliableCommands.end(); current = current.prev {
+		incoming := current.value
+
+		if reliableSequenceNumber >= uint32(ch.incomingReliableSequenceNumber) {
+			if incoming.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+				continue
+			}
+		} else if incoming.reliableSequenceNumber >= ch.incomingReliableSequenceNumber {
+			break
+		}
+
+		if uint32(incoming.reliableSequenceNumber) < reliableSequenceNumber {
+			break
+		}
+		if uint32(incoming.reliableSequenceNumber) > reliableSequenceNumber {
+			continue
+		}
+
+		if uint32(incoming.unreliableSequenceNumber) <= startSequenceNumber {
+			if uint32(incoming.unreliableSequenceNumber) < startSequenceNumber {
+				break
+			}
+
+			if incoming.command.command&commandMask != commandSendUnreliableFragment || int(cmd.totalLength) != len(incoming.packet.Data) || cmd.fragmentCount != incoming.fragmentCount {
+				return -1
+			}
+			start = incoming
+			break
+		}
+	}
+
+	if start == nil {
+		start = peer.queueIncomingCommand(cmd, nil, int(cmd.totalLength), PacketFlagUnreliableFragmented, cmd.fragmentCount)
+		if start == nil {
+			return -1
+		}
+	}
+
+	if addFragment(start, cmd, data) {
+		peer.dispatchIncomingUnreliableCommands(ch, nil)
+	}
+	return 0
+}
+
+func (host *Host) handlePing(peer *Peer) int {
+	if peer.state != PeerStateConnected && peer.state != PeerStateDisconnectLater {
+		return -1
+	}
+	return 0
+}
+
+func (host *Host) handleBandwidthLimit(peer *Peer, cmd *command) int {
+	if peer.state != PeerStateConnected && peer.state != PeerStateDisconnectLater {
+		return -1
+	}
+
+	if peer.incomingBandwidth != 0 {
+		host.bandwidthLimitedPeers--
+	}
+
+	peer.incomingBandwidth = cmd.incomingBandwidth
+	peer.outgoingBandwidth = cmd.outgoingBandwidth
+
+	if peer.incomingBandwidth != 0 {
+		host.bandwidthLimitedPeers++
+	}
+
+	if peer.incomingBandwidth == 0 && host.outgoingBandwidth == 0 {
+		peer.windowSize = maximumWindowSize
+	} else if peer.incomingBandwidth == 0 || host.outgoingBandwidth == 0 {
+		peer.windowSize = (max(peer.incomingBandwidth, host.outgoingBandwidth) / peerWindowSizeScale) * minimumWindowSize
+	} else {
+		peer.windowSize = (min(peer.incomingBandwidth, host.outgoingBandwidth) / peerWindowSizeScale) * minimumWindowSize
+	}
+	peer.windowSize = min(max(peer.windowSize, minimumWindowSize), maximumWindowSize)
+	return 0
+}
+
+func (host *Host) handleThrottleConfigure(peer *Peer, cmd *command) int {
+	if peer.state != PeerStateConnected && peer.state != PeerStateDisconnectLater {
+		return -1
+	}
+
+	peer.packetThrottleInterval = cmd.packetThrottleInterval
+	peer.packetThrottleAcceleration = cmd.packetThrottleAcceleration
+	peer.packetThrottleDeceleration = cmd.packetThrottleDeceleration
+	return 0
+}
+
+func (host *Host) handleDisconnect(peer *Peer, cmd *command) int {
+	if peer.state == PeerStateDisconnected || peer.state == PeerStateZombie || peer.state == PeerStateAcknowledgingDisconnect {
+		return 0
+	}
+
+	peer.resetQueues()
+
+	if peer.state == PeerStateConnectionSucceeded || peer.state == PeerStateDisconnecting || peer.state == PeerStateConnecting {
+		host.dispatchState(peer, PeerStateZombie)
+	} else if peer.state != PeerStateConnected && peer.state != PeerStateDisconnectLater {
+		if peer.state == PeerStateConnectionPending {
+			host.recalculateBandwidthLimits = true
+		}
+		peer.Reset()
+	} else if cmd.command&commandFlagAcknowledge != 0 {
+		host.changeState(peer, PeerStateAcknowledgingDisconnect)
+	} else {
+		host.dispatchState(peer, PeerStateZombie)
+	}
+
+	if peer.state != PeerStateDisconnected {
+		peer.eventData = cmd.data
+	}
+	return 0
+}
+
+func (host *Host) handleAcknowledge(event *Event, peer *Peer, cmd *command) int {
+	if peer.state == PeerStateDisconnected || peer.state == PeerStateZombie {
+		return 0
+	}
+
+	receivedSentTime := uint32(cmd.receivedSentTime)
+	receivedSentTime |= host.serviceTime & 0xFFFF0000
+	if receivedSentTime&0x8000 > host.serviceTime&0x8000 {
+		receivedSentTime -= 0x10000
+	}
+
+	if timeLess(host.serviceTime, receivedSentTime) {
+		return 0
+	}
+
+	roundTripTime := timeDifference(host.serviceTime, receivedSentTime)
+	if roundTripTime == 0 {
+		roundTripTime = 1
+	}
+
+	peer.throttle(roundTripTime)
+
+	if peer.lastReceiveTime > 0 {
+		if roundTripTime >= peer.roundTripTime {
+			diff := roundTripTime - peer.roundTripTime
+			peer.roundTripTimeVariance -= peer.roundTripTimeVariance / 4
+			peer.roundTripTimeVariance += diff / 4
+			peer.roundTripTime += diff / 8
+		} else {
+			diff := peer.roundTripTime - roundTripTime
+			if diff <= peer.roundTripTimeVariance {
+				peer.roundTripTimeVariance -= peer.roundTripTimeVariance / 4
+				peer.roundTripTimeVariance += diff / 4
+			} else {
+				peer.roundTripTimeVariance -= peer.roundTripTimeVariance / 32
+				peer.roundTripTimeVariance += diff / 32
+			}
+			peer.roundTripTime -= diff / 8
+		}
+	} else {
+		peer.roundTripTime = roundTripTime
+		peer.roundTripTimeVariance = roundTripTime / 2
+	}
+
+	if peer.roundTripTime < peer.lowestRoundTripTime {
+		peer.lowestRoundTripTime = peer.roundTripTime
+	}
+	if peer.roundTripTimeVariance > peer.highestRoundTripTimeVariance {
+		peer.highestRoundTripTimeVariance = peer.roundTripTimeVariance
+	}
+
+	if peer.packetThrottleEpoch == 0 || timeDifference(host.serviceTime, peer.packetThrottleEpoch) >= peer.packetThrottleInterval {
+		peer.lastRoundTripTime = peer.lowestRoundTripTime
+		peer.lastRoundTripTimeVariance = peer.highestRoundTripTimeVariance
+		peer.lowestRoundTripTime = peer.roundTripTime
+		peer.highestRoundTripTimeVariance = peer.roundTripTimeVariance
+		peer.packetThrottleEpoch = host.serviceTime
+	}
+
+	peer.lastReceiveTime = max(host.serviceTime, 1)
+	peer.earliestTimeout = 0
+
+	commandNumber := host.removeSentReliableCommand(peer, cmd.receivedReliableSequenceNumber, cmd.channelID)
+
+	switch peer.state {
+	case PeerStateAcknowledgingConnect:
+		if commandNumber != commandVerifyConnect {
+			return -1
+		}
+		host.notifyConnect(peer, event)
+
+	case PeerStateDisconnecting:
+		if commandNumber != commandDisconnect {
+			return -1
+		}
+		host.notifyDisconnect(peer, event, EventDisconnect)
+
+	case PeerStateDisconnectLater:
+		if peer.outgoingCommands.empty() && peer.sentReliableCommands.empty() {
+			peer.Disconnect(peer.eventData)
+		}
+	}
+	return 0
+}
+
+func (host *Host) handleVerifyConnect(event *Event, peer *Peer, cmd *command) int {
+	if peer.state != PeerStateConnecting {
+		return 0
+	}
+
+	if cmd.channelCount < minimumChannelCount || cmd.channelCount > maximumChannelCount ||
+		cmd.packetThrottleInterval != peer.packetThrottleInterval ||
+		cmd.packetThrottleAcceleration != peer.packetThrottleAcceleration ||
+		cmd.packetThrottleDeceleration != peer.packetThrottleDeceleration ||
+		cmd.connectID != peer.connectID.Load() {
+		peer.eventData = 0
+		host.dispatchState(peer, PeerStateZombie)
+		return -1
+	}
+
+	host.removeSentReliableCommand(peer, 1, 0xFF)
+
+	if int(cmd.channelCount) < peer.channelCount {
+		peer.channelCount = int(cmd.channelCount)
+	}
+
+	peer.outgoingPeerID = cmd.outgoingPeerID
+	peer.incomingSessionID = cmd.incomingSessionID
+	peer.outgoingSessionID = cmd.outgoingSessionID
+
+	mtu := min(max(cmd.mtu, minimumMTU), maximumMTU)
+	if mtu < peer.mtu {
+		peer.mtu = mtu
+	}
+
+	windowSize := min(max(cmd.windowSize, minimumWindowSize), maximumWindowSize)
+	if windowSize < peer.windowSize {
+		peer.windowSize = windowSize
+	}
+
+	peer.incomingBandwidth = cmd.incomingBandwidth
+	peer.outgoingBandwidth = cmd.outgoingBandwidth
+
+	host.notifyConnect(peer, event)
+	return 0
+}
+
+func (host *Host) handleIncomingCommands(event *Event) int {
+	data := host.receivedData
+	if len(data) < protocolHeaderSizeWithoutSentTime {
+		return 0
+	}
+
+	peerID := binary.BigEndian.Uint16(data)
+	if host.StockProtocol {
+		if peerID&stockHeaderFlagCompressed != 0 {
+			return 0
+		}
+		if peerID&stockHeaderFlagSentTime != 0 {
+			peerID = peerID&^stockHeaderFlagSentTime | headerFlagSentTime
+		}
+	}
+	sessionID := uint8((peerID & headerSessionMask) >> headerSessionShift)
+	flags := peerID & headerFlagMask
+	peerID &^= headerFlagMask | headerSessionMask
+
+	headerSize := protocolHeaderSizeWithoutSentTime
+	if flags&headerFlagSentTime != 0 {
+		headerSize = protocolHeaderSize
+	}
+
+	var peer *Peer
+	if peerID == maximumPeerID {
+		peer = nil
+	} else if int(peerID) >= len(host.peers) {
+		return 0
+	} else {
+		peer = &host.peers[peerID]
+
+		if peer.state == PeerStateDisconnected || peer.state == PeerStateZombie ||
+			((host.receivedAddress != peer.address) && !peer.address.isBroadcast()) ||
+			(peer.outgoingPeerID < maximumPeerID && sessionID != peer.incomingSessionID) {
+			return 0
+		}
+	}
+
+	if peer != nil {
+		peer.address = host.receivedAddress
+		peer.incomingDataTotal += uint32(len(data))
+		peer.totalDataReceived += uint64(len(data))
+	}
+
+	offset := headerSize
+	for offset < len(data) {
+		if offset+4 > len(data) {
+			break
+		}
+
+		commandNumber := data[offset] & commandMask
+		if commandNumber >= commandCount {
+			break
+		}
+
+		size := commandSizes[commandNumber]
+		if size == 0 || offset+size > len(data) {
+			break
+		}
+
+		cmd := decodeCommand(data[offset:])
+		offset += size
+
+		if peer == nil && (commandNumber != commandConnect || offset < len(data)) {
+			break
+		}
+
+		var ret int
+		switch commandNumber {
+		case commandAcknowledge:
+			ret = host.handleAcknowledge(event, peer, &cmd)
+
+		case commandConnect:
+			if peer != nil {
+				ret = -1
+				break
+			}
+			if !host.preventConnections {
+				peer = host.handleConnect(&cmd)
+				if peer == nil {
+					ret = -1
+				}
+			}
+
+		case commandVerifyConnect:
+			ret = host.handleVerifyConnect(event, peer, &cmd)
+
+		case commandDisconnect:
+			ret = host.handleDisconnect(peer, &cmd)
+
+		case commandPing:
+			ret = host.handlePing(peer)
+
+		case commandSendReliable:
+			ret = host.handleSendReliable(peer, &cmd, &offset)
+
+		case commandSendUnreliable:
+			ret = host.handleSendUnreliable(peer, &cmd, &offset)
+
+		case commandSendUnsequenced:
+			ret = host.handleSendUnsequenced(peer, &cmd, &offset)
+
+		case commandSendFragment:
+			ret = host.handleSendFragment(peer, &cmd, &offset)
+
+		case commandBandwidthLimit:
+			ret = host.handleBandwidthLimit(peer, &cmd)
+
+		case commandThrottleConfigure:
+			ret = host.handleThrottleConfigure(peer, &cmd)
+
+		case commandSendUnreliableFragment:
+			ret = host.handleSendUnreliableFragment(peer, &cmd, &offset)
+
+		default:
+			ret = -1
+		}
+		if ret != 0 {
+			break
+		}
+
+		if peer != nil && cmd.command&commandFlagAcknowledge != 0 {
+			if flags&headerFlagSentTime == 0 {
+				break
+			}
+			sentTime := binary.BigEndian.Uint16(data[2:])
+
+			switch peer.state {
+			case PeerStateDisconnecting, PeerStateAcknowledgingConnect, PeerStateDisconnected, PeerStateZombie:
+			case PeerStateAcknowledgingDisconnect:
+				if cmd.command&commandMask == commandDisconnect {
+					peer.queueAcknowledgement(&cmd, sentTime)
+				}
+			default:
+				peer.queueAcknowledgement(&cmd, sentTime)
+			}
+		}
+	}
+
+	if event != nil && event.Type != EventNone {
+		return 1
+	}
+	return 0
+}
+
+func (host *Host) receiveIncomingCommands(event *Event) int {
+	for range 256 {
+		dgram, ok := host.nextDatagram()
+		if !ok {
+			return 0
+		}
+
+		// enet receives into a buffer of the size of the host MTU and drops
+		// truncated datagrams.
+		if len(dgram.data) == 0 || len(dgram.data) > int(host.mtu) {
+			continue
+		}
+
+		host.receivedAddress = dgram.addr
+		host.receivedData = dgram.data
+		host.TotalReceivedData += uint32(len(dgram.data))
+		host.TotalReceivedPackets++
+
+		if host.Intercept != nil && host.Intercept(dgram.addr, dgram.data) {
+			if event != nil && event.Type != EventNone {
+				return 1
+			}
+			continue
+		}
+
+		if host.handleIncomingCommands(event) == 1 {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package protocol
+
+// listNode links a value into a list. Lists are circular around a sentinel node like
+// the lists of enet, so the iterator arithmetic of the ported code carries over as is.
+type listNode[T any] struct {
+	next, prev *listNode[T]
+	value      *T
+}
+
+type list[T any] struct {
+	sentinel listNode[T]
+}
+
+func (l *list[T]) clear() {
+	l.sentinel.next = &l.sentinel
+	l.sentinel.prev = &l.sentinel
+}
+
+func (l *list[T]) begin() *listNode[T] {
+	return l.sentinel.next
+}
+
+func (l *list[T]) end() *listNode[T] {
+	return &l.sentinel
+}
+
+func (l *list[T]) empty() bool {
+	return l.begin() == l.end()
+}
+
+func (l *list[T]) size() int {
+	size := 0
+	for node := l.begin(); node != l.end(); node = node.next {
+		size++
+	}
+	return size
+}
+
+func (l *list[T]) front() *T {
+	return l.sentinel.next.value
+}
+
+// listInsert inserts node before position.
+func listInsert[T any](position, node *listNode[T]) *listNode[T] {
+	node.prev = position.prev
+	node.next = position
+	node.prev.next = node
+	position.prev = node
+	return node
+}
+
+func listRemove[T any](node *listNode[T]) *listNode[T] {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	return node
+}
+
+// listMove moves the nodes from first to last, both included, before position.
+func listMove[T any](position, first, last *listNode[T]) *listNode[T] {
+	first.prev.next = last.next
+	last.next.prev = first.prev
+
+	first.prev = position.prev
+	last.next = position
+
+	first.prev.next = first
+	position.prev = last
+	return first
+}
@@ -0,0 +1,290 @@
+package protocol
+
+import "encoding/binary"
+
+// full reports whether the datagram being assembled has no room for another command
+// of size bytes with a payload of length bytes, taking buffers more buffers.
+func (host *Host) full(peer *Peer, size int, buffers int, payload bool, length uint16) bool {
+	return host.commandCount >= maximumPacketCommands || host.bufferCount+buffers-1 >= maximumBuffers ||
+		uint(peer.mtu)-uint(host.packetSize) < uint(size) ||
+		(payload && uint16(peer.mtu-uint32(host.packetSize)) < uint16(size)+length)
+}
+
+func (host *Host) sendAcknowledgements(peer *Peer) {
+	size := commandSizes[commandAcknowledge]
+
+	for current := peer.acknowledgements.begin(); current != peer.acknowledgements.end(); {
+		if host.full(peer, size, 1, false, 0) {
+			host.continueSending = true
+			break
+		}
+
+		ack := current.value
+		current = current.next
+
+		host.packetSize += size
+		host.packetData = (&command{
+			command:                        commandAcknowledge,
+			channelID:                      ack.command.channelID,
+			reliableSequenceNumber:         ack.command.reliableSequenceNumber,
+			receivedReliableSequenceNumber: ack.command.reliableSequenceNumber,
+			receivedSentTime:               uint16(ack.sentTime),
+		}).encode(host.packetData)
+
+		if ack.command.command&commandMask == commandDisconnect {
+			host.dispatchState(peer, PeerStateZombie)
+		}
+
+		listRemove(&ack.node)
+
+		host.commandCount++
+		host.bufferCount++
+	}
+}
+
+func (host *Host) checkTimeouts(peer *Peer, event *Event) int {
+	insertPosition := peer.outgoingCommands.begin()
+
+	for current := peer.sentReliableCommands.begin(); current != peer.sentReliableCommands.end(); {
+		outgoing := current.value
+		current = current.next
+
+		if timeDifference(host.serviceTime, outgoing.sentTime) < outgoing.roundTripTimeout {
+			continue
+		}
+
+		if peer.earliestTimeout == 0 || timeLess(outgoing.sentTime, peer.earliestTimeout) {
+			peer.earliestTimeout = outgoing.sentTime
+		}
+
+		if peer.earliestTimeout != 0 && (timeDifference(host.serviceTime, peer.earliestTimeout) >= peer.timeoutMaximum ||
+			(outgoing.roundTripTimeout >= outgoing.roundTripTimeoutLimit && timeDifference(host.serviceTime, peer.earliestTimeout) >= peer.timeoutMinimum)) {
+			host.notifyDisconnect(peer, event, EventDisconnectTimeout)
+			return 1
+		}
+
+		if outgoing.packet != nil {
+			peer.reliableDataInTransit -= uint32(outgoing.fragmentLength)
+		}
+
+		peer.totalPacketsLost++
+		outgoing.roundTripTimeout = peer.roundTripTime + 4*peer.roundTripTimeVariance
+		outgoing.roundTripTimeoutLimit = peer.timeoutLimit * outgoing.roundTripTimeout
+
+		listInsert(insertPosition, listRemove(&outgoing.node))
+
+		if current == peer.sentReliableCommands.begin() && !peer.sentReliableCommands.empty() {
+			outgoing = current.value
+			peer.nextTimeout = outgoing.sentTime + outgoing.roundTripTimeout
+		}
+	}
+	return 0
+}
+
+func (host *Host) checkOutgoingCommands(peer *Peer) bool {
+	var ch *channel
+	var reliableWindow uint16
+	windowExceeded, windowWrap, canPing := false, false, true
+
+	for current := peer.outgoingCommands.begin(); current != peer.outgoingCommands.end(); {
+		outgoing := current.value
+
+		if outgoing.command.command&commandFlagAcknowledge != 0 {
+			ch = nil
+			if int(outgoing.command.channelID) < peer.channelCount {
+				ch = &peer.channels[outgoing.command.channelID]
+			}
+			reliableWindow = outgoing.reliableSequenceNumber / peerReliableWindowSize
+
+			if ch != nil {
+				const freeWindowsMask = 1<<(peerFreeReliableWindows+2) - 1
+				if !windowWrap && outgoing.sendAttempts < 1 && outgoing.reliableSequenceNumber%peerReliableWindowSize == 0 &&
+					(ch.reliableWindows[(reliableWindow+peerReliableWindows-1)%peerReliableWindows] >= peerReliableWindowSize ||
+						uint32(ch.usedReliableWindows)&(freeWindowsMask<<reliableWindow|freeWindowsMask>>(peerReliableWindows-reliableWindow)) != 0) {
+					windowWrap = true
+				}
+
+				if windowWrap {
+					current = current.next
+					continue
+				}
+			}
+
+			if outgoing.packet != nil {
+				if !windowExceeded {
+					windowSize := peer.packetThrottle * peer.windowSize / peerPacketThrottleScale
+					if peer.reliableDataInTransit+uint32(outgoing.fragmentLength) > max(windowSize, peer.mtu) {
+						windowExceeded = true
+					}
+				}
+
+				if windowExceeded {
+					current = current.next
+					continue
+				}
+			}
+
+			canPing = false
+		}
+
+		size := commandSize(outgoing.command.command)
+		if host.full(peer, size, 2, outgoing.packet != nil, outgoing.fragmentLength) {
+			host.continueSending = true
+			break
+		}
+
+		current = current.next
+
+		if outgoing.command.command&commandFlagAcknowledge != 0 {
+			if ch != nil && outgoing.sendAttempts < 1 {
+				ch.usedReliableWindows |= 1 << reliableWindow
+				ch.reliableWindows[reliableWindow]++
+			}
+
+			outgoing.sendAttempts++
+
+			if outgoing.roundTripTimeout == 0 {
+				outgoing.roundTripTimeout = peer.roundTripTime + 4*peer.roundTripTimeVariance
+				outgoing.roundTripTimeoutLimit = peer.timeoutLimit * outgoing.roundTripTimeout
+			}
+
+			if peer.sentReliableCommands.empty() {
+				peer.nextTimeout = host.serviceTime + outgoing.roundTripTimeout
+			}
+
+			listInsert(peer.sentReliableCommands.end(), listRemove(&outgoing.node))
+
+			outgoing.sentTime = host.serviceTime
+			host.headerFlags |= headerFlagSentTime
+			peer.reliableDataInTransit += uint32(outgoing.fragmentLength)
+		} else {
+			if outgoing.packet != nil && outgoing.fragmentOffset == 0 && outgoing.packet.Flags&PacketFlagUnthrottled == 0 {
+				peer.packetThrottleCounter += peerPacketThrottleCounter
+				peer.packetThrottleCounter %= peerPacketThrottleScale
+
+				if peer.packetThrottleCounter > peer.packetThrottle {
+					// Drop the command along with the other fragments of its packet.
+					reliableSequenceNumber := outgoing.reliableSequenceNumber
+					unreliableSequenceNumber := outgoing.unreliableSequenceNumber
+
+					for {
+						outgoing.packet.release(false)
+						listRemove(&outgoing.node)
+
+						if current == peer.outgoingCommands.end() {
+							break
+						}
+
+						outgoing = current.value
+						if outgoing.reliableSequenceNumber != reliableSequenceNumber || outgoing.unreliableSequenceNumber != unreliableSequenceNumber {
+							break
+						}
+
+						current = current.next
+					}
+					continue
+				}
+			}
+
+			listRemove(&outgoing.node)
+
+			if outgoing.packet != nil {
+				listInsert(peer.sentUnreliableCommands.end(), &outgoing.node)
+			}
+		}
+
+		host.packetSize += size
+		host.packetData = outgoing.command.encode(host.packetData)
+
+		if outgoing.packet != nil {
+			host.bufferCount++
+			host.packetSize += int(outgoing.fragmentLength)
+			host.packetData = append(host.packetData, outgoing.packet.Data[outgoing.fragmentOffset:outgoing.fragmentOffset+uint32(outgoing.fragmentLength)]...)
+		}
+
+		peer.totalPacketsSent++
+		host.commandCount++
+		host.bufferCount++
+	}
+
+	if peer.state == PeerStateDisconnectLater && peer.outgoingCommands.empty() && peer.sentReliableCommands.empty() && peer.sentUnreliableCommands.empty() {
+		peer.Disconnect(peer.eventData)
+	}
+
+	return canPing
+}
+
+func (host *Host) sendOutgoingCommands(event *Event, checkForTimeouts bool) int {
+	host.continueSending = true
+
+	for host.continueSending {
+		host.continueSending = false
+
+		for i := range host.peers {
+			peer := &host.peers[i]
+			if peer.state == PeerStateDisconnected || peer.state == PeerStateZombie {
+				continue
+			}
+
+			host.headerFlags = 0
+			host.commandCount = 0
+			host.bufferCount = 1
+			host.packetSize = protocolHeaderSize
+			host.packetData = host.packetData[:protocolHeaderSize]
+
+			if !peer.acknowledgements.empty() {
+				host.sendAcknowledgements(peer)
+			}
+
+			if checkForTimeouts && !peer.sentReliableCommands.empty() && timeGreaterEqual(host.serviceTime, peer.nextTimeout) && host.checkTimeouts(peer, event) == 1 {
+				if event != nil && event.Type != EventNone {
+					return 1
+				}
+				continue
+			}
+
+			if (peer.outgoingCommands.empty() || host.checkOutgoingCommands(peer)) && peer.sentReliableCommands.empty() &&
+				timeDifference(host.serviceTime, peer.lastReceiveTime) >= peer.pingInterval && uint(peer.mtu)-uint(host.packetSize) >= uint(commandSizes[commandPing]) {
+				peer.Ping()
+				host.checkOutgoingCommands(peer)
+			}
+
+			if host.commandCount == 0 {
+				continue
+			}
+
+			if peer.outgoingPeerID < maximumPeerID {
+				host.headerFlags |= uint16(peer.outgoingSessionID) << headerSessionShift
+			}
+			sentTime := host.headerFlags&headerFlagSentTime != 0
+			if sentTime && host.StockProtocol {
+				host.headerFlags = host.headerFlags&^headerFlagSentTime | stockHeaderFlagSentTime
+			}
+
+			// The header is written right before the commands, leaving out the sent time
+			// unless a command needs it.
+			data := host.packetData
+			if sentTime {
+				binary.BigEndian.PutUint16(data, peer.outgoingPeerID|host.headerFlags)
+				binary.BigEndian.PutUint16(data[2:], uint16(host.serviceTime))
+			} else {
+				data = data[protocolHeaderSize-protocolHeaderSizeWithoutSentTime:]
+				binary.BigEndian.PutUint16(data, peer.outgoingPeerID|host.headerFlags)
+			}
+
+			peer.lastSendTime = host.serviceTime
+			sentLength := host.socketSend(peer.address, data)
+
+			host.removeSentUnreliableCommands(peer)
+
+			if sentLength < 0 {
+				return -1
+			}
+
+			host.TotalSentData += uint32(sentLength)
+			peer.totalDataSent += uint64(sentLength)
+			host.TotalSentPackets++
+		}
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package protocol
+
+// Packet flags, with the values of the C library.
+const (
+	PacketFlagReliable             = 1 << 0
+	PacketFlagUnsequenced          = 1 << 1
+	PacketFlagNoAllocate           = 1 << 2
+	PacketFlagUnreliableFragmented = 1 << 3
+	PacketFlagInstant              = 1 << 4
+	PacketFlagUnthrottled          = 1 << 5
+	PacketFlagSent                 = 1 << 8
+)
+
+// Packet is a packet sent to or received from a peer. Once handed to a peer or host
+// for sending it belongs to the host, which sets PacketFlagSent when done with it.
+type Packet struct {
+	Data  []byte
+	Flags uint32
+
+	referenceCount uint32
+}
+
+// NewPacket creates a packet holding a copy of data, or data itself with
+// PacketFlagNoAllocate.
+func NewPacket(data []byte, flags uint32) *Packet {
+	return newPacket(data, len(data), flags)
+}
+
+// newPacket mirrors enet_packet_create, a nil data allocates a zeroed payload.
+func newPacket(data []byte, length int, flags uint32) *Packet {
+	packet := &Packet{Flags: flags}
+	if flags&PacketFlagNoAllocate != 0 {
+		packet.Data = data
+	} else {
+		packet.Data = make([]byte, length)
+		copy(packet.Data, data)
+	}
+	return packet
+}
+
+// References returns how many queued commands still refer to the packet.
+func (packet *Packet) References() int {
+	return int(packet.referenceCount)
+}
+
+// release drops a reference taken by a queued command.
+func (packet *Packet) release(sent bool) {
+	packet.referenceCount--
+	if packet.referenceCount == 0 && sent {
+		packet.Flags |= PacketFlagSent
+	}
+}
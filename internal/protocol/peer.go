@@ -0,0 +1,852 @@
+package protocol
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// PeerState is the connection state of a peer.
+type PeerState int
+
+const (
+	PeerStateDisconnected PeerState = iota
+	PeerStateConnecting
+	PeerStateAcknowledgingConnect
+	PeerStateConnectionPending
+	PeerStateConnectionSucceeded
+	PeerStateConnected
+	PeerStateDisconnectLater
+	PeerStateDisconnecting
+	PeerStateAcknowledgingDisconnect
+	PeerStateZombie
+)
+
+type acknowledgement struct {
+	node     listNode[acknowledgement]
+	sentTime uint32
+	command  command
+}
+
+type outgoingCommand struct {
+	node                     listNode[outgoingCommand]
+	reliableSequenceNumber   uint16
+	unreliableSequenceNumber uint16
+	sentTime                 uint32
+	roundTripTimeout         uint32
+	roundTripTimeoutLimit    uint32
+	fragmentOffset           uint32
+	fragmentLength           uint16
+	sendAttempts             uint16
+	command                  command
+	packet                   *Packet
+}
+
+type incomingCommand struct {
+	node                     listNode[incomingCommand]
+	reliableSequenceNumber   uint16
+	unreliableSequenceNumber uint16
+	command                  command
+	fragmentCount            uint32
+	fragmentsRemaining       uint32
+	fragments                []uint32
+	packet                   *Packet
+}
+
+// dummyCommand is returned for commands that are dropped without an error.
+var dummyCommand incomingCommand
+
+type channel struct {
+	outgoingReliableSequenceNumber   uint16
+	outgoingUnreliableSequenceNumber uint16
+	usedReliableWindows              uint16
+	reliableWindows                  [peerReliableWindows]uint16
+	incomingReliableSequenceNumber   uint16
+	incomingUnreliableSequenceNumber uint16
+	incomingReliableCommands         list[incomingCommand]
+	incomingUnreliableCommands       list[incomingCommand]
+}
+
+// Peer is a remote host a Host communicates with.
+type Peer struct {
+	dispatchList      listNode[Peer]
+	host              *Host
+	outgoingPeerID    uint16
+	incomingPeerID    uint16
+	connectID         atomic.Uint32
+	outgoingSessionID uint8
+	incomingSessionID uint8
+	address           Address
+
+	// Data is left to the application. It survives the peer being reset.
+	Data any
+
+	state                          PeerState
+	channels                       []channel
+	channelCount                   int
+	incomingBandwidth              uint32
+	outgoingBandwidth              uint32
+	incomingBandwidthThrottleEpoch uint32
+	outgoingBandwidthThrottleEpoch uint32
+	incomingDataTotal              uint32
+	totalDataReceived              uint64
+	outgoingDataTotal              uint32
+	totalDataSent                  uint64
+	lastSendTime                   uint32
+	lastReceiveTime                uint32
+	nextTimeout                    uint32
+	earliestTimeout                uint32
+	totalPacketsSent               uint64
+	totalPacketsLost               uint64
+	packetThrottle                 uint32
+	packetThrottleThreshold        uint32
+	packetThrottleLimit            uint32
+	packetThrottleCounter          uint32
+	packetThrottleEpoch            uint32
+	packetThrottleAcceleration     uint32
+	packetThrottleDeceleration     uint32
+	packetThrottleInterval         uint32
+	pingInterval                   uint32
+	timeoutLimit                   uint32
+	timeoutMinimum                 uint32
+	timeoutMaximum                 uint32
+	lastRoundTripTime              uint32
+	lowestRoundTripTime            uint32
+	lastRoundTripTimeVariance      uint32
+	highestRoundTripTimeVariance   uint32
+	roundTripTime                  uint32
+	roundTripTimeVariance          uint32
+	mtu                            uint32
+	windowSize                     uint32
+	reliableDataInTransit          uint32
+	outgoingReliableSequenceNumber uint16
+	acknowledgements               list[acknowledgement]
+	sentReliableCommands           list[outgoingCommand]
+	sentUnreliableCommands         list[outgoingCommand]
+	outgoingCommands               list[outgoingCommand]
+	dispatchedCommands             list[incomingCommand]
+	needsDispatch                  bool
+	incomingUnsequencedGroup       uint16
+	outgoingUnsequencedGroup       uint16
+	unsequencedWindow              [peerUnsequencedWindowSize / 32]uint32
+	eventData                      uint32
+	totalWaitingData               int
+}
+
+// Host returns the host the peer belongs to.
+func (peer *Peer) Host() *Host { return peer.host }
+
+// ID returns the index of the peer within its host.
+func (peer *Peer) ID() uint32 { return uint32(peer.incomingPeerID) }
+
+// Address returns the address of the remote host.
+func (peer *Peer) Address() Address { return peer.address }
+
+// ConnectID returns the ID of the connection of the peer, which tells connections
+// reusing the same slot apart. Unlike the other getters it is safe to call from any
+// goroutine.
+func (peer *Peer) ConnectID() uint32 { return peer.connectID.Load() }
+
+// State returns the connection state of the peer.
+func (peer *Peer) State() PeerState { return peer.state }
+
+// MTU returns the maximum transmission unit negotiated with the peer.
+func (peer *Peer) MTU() uint32 { return peer.mtu }
+
+// WindowSize returns the most reliable data in bytes that may be in transit to the peer.
+func (peer *Peer) WindowSize() uint32 { return peer.windowSize }
+
+// IncomingBandwidth returns the downstream bandwidth of the peer in bytes per second,
+// 0 if unlimited.
+func (peer *Peer) IncomingBandwidth() uint32 { return peer.incomingBandwidth }
+
+// RoundTripTime returns the mean round trip time in milliseconds.
+func (peer *Peer) RoundTripTime() uint32 { return peer.roundTripTime }
+
+// LastRoundTripTime returns the round trip time of the last throttle interval.
+func (peer *Peer) LastRoundTripTime() uint32 { return peer.lastRoundTripTime }
+
+// LastSendTime returns the time of the last datagram sent to the peer.
+func (peer *Peer) LastSendTime() uint32 { return peer.lastSendTime }
+
+// LastReceiveTime returns the time of the last acknowledgement from the peer.
+func (peer *Peer) LastReceiveTime() uint32 { return peer.lastReceiveTime }
+
+// PacketsSent returns the number of commands sent to the peer.
+func (peer *Peer) PacketsSent() uint64 { return peer.totalPacketsSent }
+
+// PacketsLost returns the number of reliable commands that had to be resent.
+func (peer *Peer) PacketsLost() uint64 { return peer.totalPacketsLost }
+
+// BytesSent returns the number of bytes sent to the peer.
+func (peer *Peer) BytesSent() uint64 { return peer.totalDataSent }
+
+// BytesReceived returns the number of bytes received from the peer.
+func (peer *Peer) BytesReceived() uint64 { return peer.totalDataReceived }
+
+// QueuedCommands returns the number of commands waiting to be sent to the peer or to be
+// acknowledged by it.
+func (peer *Peer) QueuedCommands() int {
+	return peer.outgoingCommands.size() + peer.sentReliableCommands.size()
+}
+
+// PacketThrottle returns the throttle of unreliable packets in percent.
+func (peer *Peer) PacketThrottle() float32 {
+	return float32(peer.packetThrottle) / peerPacketThrottleScale * 100
+}
+
+// ThrottleConfigure configures the throttle of unreliable packets and tells the peer.
+func (peer *Peer) ThrottleConfigure(interval, acceleration, deceleration, threshold uint32) {
+	peer.packetThrottleThreshold = threshold
+	peer.packetThrottleInterval = interval
+	peer.packetThrottleAcceleration = acceleration
+	peer.packetThrottleDeceleration = deceleration
+
+	peer.queueOutgoingCommand(&command{
+		command:                    commandThrottleConfigure | commandFlagAcknowledge,
+		channelID:                  0xFF,
+		packetThrottleInterval:     interval,
+		packetThrottleAcceleration: acceleration,
+		packetThrottleDeceleration: deceleration,
+	}, nil, 0, 0)
+}
+
+func (peer *Peer) throttle(rtt uint32) int {
+	if peer.lastRoundTripTime <= peer.lastRoundTripTimeVariance {
+		peer.packetThrottle = peer.packetThrottleLimit
+	} else if rtt < peer.lastRoundTripTime+(peer.lastRoundTripTimeVariance+1)/2 {
+		peer.packetThrottle += peer.packetThrottleAcceleration
+		if peer.packetThrottle > peer.packetThrottleLimit {
+			peer.packetThrottle = peer.packetThrottleLimit
+		}
+		return 1
+	} else if rtt > peer.lastRoundTripTime+peer.packetThrottleThreshold+2*peer.lastRoundTripTimeVariance {
+		if peer.packetThrottle > peer.packetThrottleDeceleration {
+			peer.packetThrottle -= peer.packetThrottleDeceleration
+		} else {
+			peer.packetThrottle = 0
+		}
+		return -1
+	}
+	return 0
+}
+
+var errCannotSend = errors.New("peer is not connected or the packet is too large")
+
+// Send queues packet to be sent to the peer on channelID. The packet belongs to the
+// peer afterwards unless an error is returned.
+func (peer *Peer) Send(channelID uint8, packet *Packet) error {
+	if peer.state != PeerStateConnected || int(channelID) >= peer.channelCount || len(packet.Data) > peer.host.maximumPacketSize {
+		return errCannotSend
+	}
+
+	ch := &peer.channels[channelID]
+	dataLength := uint32(len(packet.Data))
+	fragmentLength := peer.mtu - protocolHeaderSize - uint32(commandSizes[commandSendFragment]) - uint32(commandSizes[commandAcknowledge])
+
+	if dataLength > fragmentLength {
+		fragmentCount := (dataLength + fragmentLength - 1) / fragmentLength
+		if fragmentCount > maximumFragmentCount {
+			return errCannotSend
+		}
+
+		var commandNumber uint8
+		var startSequenceNumber uint16
+		if packet.Flags&(PacketFlagReliable|PacketFlagUnreliableFragmented) == PacketFlagUnreliableFragmented && ch.outgoingUnreliableSequenceNumber < 0xFFFF {
+			commandNumber = commandSendUnreliableFragment
+			startSequenceNumber = ch.outgoingUnreliableSequenceNumber + 1
+		} else {
+			commandNumber = commandSendFragment | commandFlagAcknowledge
+			startSequenceNumber = ch.outgoingReliableSequenceNumber + 1
+		}
+
+		var fragments []*outgoingCommand
+		fragmentNumber, fragmentOffset := uint32(0), uint32(0)
+		for ; fragmentOffset < dataLength; fragmentNumber, fragmentOffset = fragmentNumber+1, fragmentOffset+fragmentLength {
+			if dataLength-fragmentOffset < fragmentLength {
+				fragmentLength = dataLength - fragmentOffset
+			}
+
+			fragment := &outgoingCommand{
+				fragmentOffset: fragmentOffset,
+				fragmentLength: uint16(fragmentLength),
+				packet:         packet,
+				command: command{
+					command:             commandNumber,
+					channelID:           channelID,
+					startSequenceNumber: startSequenceNumber,
+					dataLength:          uint16(fragmentLength),
+					fragmentCount:       fragmentCount,
+					fragmentNumber:      fragmentNumber,
+					totalLength:         dataLength,
+					fragmentOffset:      fragmentOffset,
+				},
+			}
+			fragment.node.value = fragment
+			fragments = append(fragments, fragment)
+		}
+
+		packet.referenceCount += fragmentNumber
+		for _, fragment := range fragments {
+			peer.setupOutgoingCommand(fragment)
+		}
+		return nil
+	}
+
+	cmd := command{channelID: channelID, dataLength: uint16(dataLength)}
+	if packet.Flags&(PacketFlagReliable|PacketFlagUnsequenced) == PacketFlagUnsequenced {
+		cmd.command = commandSendUnsequenced | commandFlagUnsequenced
+	} else if packet.Flags&PacketFlagReliable != 0 || ch.outgoingUnreliableSequenceNumber >= 0xFFFF {
+		cmd.command = commandSendReliable | commandFlagAcknowledge
+	} else {
+		cmd.command = commandSendUnreliable
+	}
+
+	peer.queueOutgoingCommand(&cmd, packet, 0, uint16(dataLength))
+
+	if packet.Flags&PacketFlagInstant != 0 {
+		peer.host.Flush()
+	}
+	return nil
+}
+
+// receive takes the next dispatched packet out of the queue of the peer.
+func (peer *Peer) receive() (*Packet, uint8) {
+	if peer.dispatchedCommands.empty() {
+		return nil, 0
+	}
+
+	incoming := listRemove(peer.dispatchedCommands.begin()).value
+	packet := incoming.packet
+	packet.referenceCount--
+	peer.totalWaitingData -= len(packet.Data)
+	return packet, incoming.command.channelID
+}
+
+func resetOutgoingCommands(queue *list[outgoingCommand]) {
+	for !queue.empty() {
+		outgoing := listRemove(queue.begin()).value
+		if outgoing.packet != nil {
+			outgoing.packet.release(false)
+		}
+	}
+}
+
+func removeIncomingCommands(start, end *listNode[incomingCommand], exclude *incomingCommand) {
+	for current := start; current != end; {
+		incoming := current.value
+		current = current.next
+
+		if incoming == exclude {
+			continue
+		}
+
+		listRemove(&incoming.node)
+		if incoming.packet != nil {
+			incoming.packet.release(false)
+		}
+	}
+}
+
+func resetIncomingCommands(queue *list[incomingCommand]) {
+	removeIncomingCommands(queue.begin(), queue.end(), nil)
+}
+
+func (peer *Peer) resetQueues() {
+	if peer.needsDispatch {
+		listRemove(&peer.dispatchList)
+		peer.needsDispatch = false
+	}
+
+	for !peer.acknowledgements.empty() {
+		listRemove(peer.acknowledgements.begin())
+	}
+
+	resetOutgoingCommands(&peer.sentReliableCommands)
+	resetOutgoingCommands(&peer.sentUnreliableCommands)
+	resetOutgoingCommands(&peer.outgoingCommands)
+	resetIncomingCommands(&peer.dispatchedCommands)
+
+	for i := range peer.channelCount {
+		resetIncomingCommands(&peer.channels[i].incomingReliableCommands)
+		resetIncomingCommands(&peer.channels[i].incomingUnreliableCommands)
+	}
+
+	peer.channels = nil
+	peer.channelCount = 0
+}
+
+func (peer *Peer) onConnect() {
+	if peer.state != PeerStateConnected && peer.state != PeerStateDisconnectLater {
+		if peer.incomingBandwidth != 0 {
+			peer.host.bandwidthLimitedPeers++
+		}
+		peer.host.connectedPeers++
+	}
+}
+
+func (peer *Peer) onDisconnect() {
+	if peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater {
+		if peer.incomingBandwidth != 0 {
+			peer.host.bandwidthLimitedPeers--
+		}
+		peer.host.connectedPeers--
+	}
+}
+
+// Reset forcefully disconnects the peer without notifying it.
+func (peer *Peer) Reset() {
+	peer.onDisconnect()
+
+	peer.outgoingPeerID = maximumPeerID
+	peer.state = PeerStateDisconnected
+	peer.incomingBandwidth = 0
+	peer.outgoingBandwidth = 0
+	peer.incomingBandwidthThrottleEpoch = 0
+	peer.outgoingBandwidthThrottleEpoch = 0
+	peer.incomingDataTotal = 0
+	peer.totalDataReceived = 0
+	peer.outgoingDataTotal = 0
+	peer.totalDataSent = 0
+	peer.lastSendTime = 0
+	peer.lastReceiveTime = 0
+	peer.nextTimeout = 0
+	peer.earliestTimeout = 0
+	peer.totalPacketsSent = 0
+	peer.totalPacketsLost = 0
+	peer.packetThrottle = peerDefaultPacketThrottle
+	peer.packetThrottleThreshold = peerPacketThrottleThreshold
+	peer.packetThrottleLimit = peerPacketThrottleScale
+	peer.packetThrottleCounter = 0
+	peer.packetThrottleEpoch = 0
+	peer.packetThrottleAcceleration = peerPacketThrottleAcceleration
+	peer.packetThrottleDeceleration = peerPacketThrottleDeceleration
+	peer.packetThrottleInterval = peerPacketThrottleInterval
+	peer.pingInterval = peerPingInterval
+	peer.timeoutLimit = peerTimeoutLimit
+	peer.timeoutMinimum = peerTimeoutMinimum
+	peer.timeoutMaximum = peerTimeoutMaximum
+	peer.lastRoundTripTime = peerDefaultRoundTripTime
+	peer.lowestRoundTripTime = peerDefaultRoundTripTime
+	peer.lastRoundTripTimeVariance = 0
+	peer.highestRoundTripTimeVariance = 0
+	peer.roundTripTime = 1
+	peer.roundTripTimeVariance = 0
+	peer.mtu = peer.host.mtu
+	peer.reliableDataInTransit = 0
+	peer.outgoingReliableSequenceNumber = 0
+	peer.windowSize = maximumWindowSize
+	peer.incomingUnsequencedGroup = 0
+	peer.outgoingUnsequencedGroup = 0
+	peer.eventData = 0
+	peer.totalWaitingData = 0
+	peer.unsequencedWindow = [peerUnsequencedWindowSize / 32]uint32{}
+
+	peer.resetQueues()
+}
+
+// Ping queues a ping, which is otherwise sent automatically every ping interval.
+func (peer *Peer) Ping() {
+	if peer.state != PeerStateConnected {
+		return
+	}
+	peer.queueOutgoingCommand(&command{
+		command:   commandPing | commandFlagAcknowledge,
+		channelID: 0xFF,
+	}, nil, 0, 0)
+}
+
+// PingInterval sets the interval at which pings are sent, 0 restores the default.
+func (peer *Peer) PingInterval(interval uint32) {
+	if interval == 0 {
+		interval = peerPingInterval
+	}
+	peer.pingInterval = interval
+}
+
+// Timeout sets the timeout parameters of the peer, 0 restores a default.
+func (peer *Peer) Timeout(limit, minimum, maximum uint32) {
+	if limit == 0 {
+		limit = peerTimeoutLimit
+	}
+	if minimum == 0 {
+		minimum = peerTimeoutMinimum
+	}
+	if maximum == 0 {
+		maximum = peerTimeoutMaximum
+	}
+	peer.timeoutLimit = limit
+	peer.timeoutMinimum = minimum
+	peer.timeoutMaximum = maximum
+}
+
+// DisconnectNow disconnects the peer immediately, without waiting for it to
+// acknowledge and without generating an event.
+func (peer *Peer) DisconnectNow(data uint32) {
+	if peer.state == PeerStateDisconnected {
+		return
+	}
+
+	if peer.state != PeerStateZombie && peer.state != PeerStateDisconnecting {
+		peer.resetQueues()
+
+		peer.queueOutgoingCommand(&command{
+			command:   commandDisconnect | commandFlagUnsequenced,
+			channelID: 0xFF,
+			data:      data,
+		}, nil, 0, 0)
+		peer.host.Flush()
+	}
+
+	peer.Reset()
+}
+
+// Disconnect requests a disconnection, an EventDisconnect is generated once the peer
+// acknowledged it.
+func (peer *Peer) Disconnect(data uint32) {
+	if peer.state == PeerStateDisconnecting || peer.state == PeerStateDisconnected || peer.state == PeerStateAcknowledgingDisconnect || peer.state == PeerStateZombie {
+		return
+	}
+
+	peer.resetQueues()
+
+	cmd := command{
+		command:   commandDisconnect,
+		channelID: 0xFF,
+		data:      data,
+	}
+	if peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater {
+		cmd.command |= commandFlagAcknowledge
+	} else {
+		cmd.command |= commandFlagUnsequenced
+	}
+
+	peer.queueOutgoingCommand(&cmd, nil, 0, 0)
+
+	if peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater {
+		peer.onDisconnect()
+		peer.state = PeerStateDisconnecting
+	} else {
+		peer.host.Flush()
+		peer.Reset()
+	}
+}
+
+// DisconnectLater disconnects the peer once all queued packets have been sent.
+func (peer *Peer) DisconnectLater(data uint32) {
+	if (peer.state == PeerStateConnected || peer.state == PeerStateDisconnectLater) && !(peer.outgoingCommands.empty() && peer.sentReliableCommands.empty()) {
+		peer.state = PeerStateDisconnectLater
+		peer.eventData = data
+	} else {
+		peer.Disconnect(data)
+	}
+}
+
+func (peer *Peer) queueAcknowledgement(cmd *command, sentTime uint16) *acknowledgement {
+	if int(cmd.channelID) < peer.channelCount {
+		ch := &peer.channels[cmd.channelID]
+		reliableWindow := cmd.reliableSequenceNumber / peerReliableWindowSize
+		currentWindow := ch.incomingReliableSequenceNumber / peerReliableWindowSize
+
+		if cmd.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+			reliableWindow += peerReliableWindows
+		}
+		if reliableWindow >= currentWindow+peerFreeReliableWindows-1 && reliableWindow <= currentWindow+peerFreeReliableWindows {
+			return nil
+		}
+	}
+
+	ack := &acknowledgement{sentTime: uint32(sentTime), command: *cmd}
+	ack.node.value = ack
+	peer.outgoingDataTotal += uint32(commandSizes[commandAcknowledge])
+	listInsert(peer.acknowledgements.end(), &ack.node)
+	return ack
+}
+
+func (peer *Peer) setupOutgoingCommand(outgoing *outgoingCommand) {
+	peer.outgoingDataTotal += uint32(commandSize(outgoing.command.command)) + uint32(outgoing.fragmentLength)
+
+	if outgoing.command.channelID == 0xFF {
+		peer.outgoingReliableSequenceNumber++
+		outgoing.reliableSequenceNumber = peer.outgoingReliableSequenceNumber
+		outgoing.unreliableSequenceNumber = 0
+	} else if outgoing.command.command&commandFlagAcknowledge != 0 {
+		ch := &peer.channels[outgoing.command.channelID]
+		ch.outgoingReliableSequenceNumber++
+		ch.outgoingUnreliableSequenceNumber = 0
+		outgoing.reliableSequenceNumber = ch.outgoingReliableSequenceNumber
+		outgoing.unreliableSequenceNumber = 0
+	} else if outgoing.command.command&commandFlagUnsequenced != 0 {
+		peer.outgoingUnsequencedGroup++
+		outgoing.reliableSequenceNumber = 0
+		outgoing.unreliableSequenceNumber = 0
+	} else {
+		ch := &peer.channels[outgoing.command.channelID]
+		if outgoing.fragmentOffset == 0 {
+			ch.outgoingUnreliableSequenceNumber++
+		}
+		outgoing.reliableSequenceNumber = ch.outgoingReliableSequenceNumber
+		outgoing.unreliableSequenceNumber = ch.outgoingUnreliableSequenceNumber
+	}
+
+	outgoing.sendAttempts = 0
+	outgoing.sentTime = 0
+	outgoing.roundTripTimeout = 0
+	outgoing.roundTripTimeoutLimit = 0
+	outgoing.command.reliableSequenceNumber = outgoing.reliableSequenceNumber
+
+	switch outgoing.command.command & commandMask {
+	case commandSendUnreliable:
+		outgoing.command.unreliableSequenceNumber = outgoing.unreliableSequenceNumber
+	case commandSendUnsequenced:
+		outgoing.command.unsequencedGroup = peer.outgoingUnsequencedGroup
+	}
+
+	listInsert(peer.outgoingCommands.end(), &outgoing.node)
+}
+
+func (peer *Peer) queueOutgoingCommand(cmd *command, packet *Packet, offset uint32, length uint16) *outgoingCommand {
+	outgoing := &outgoingCommand{
+		command:        *cmd,
+		fragmentOffset: offset,
+		fragmentLength: length,
+		packet:         packet,
+	}
+	outgoing.node.value = outgoing
+
+	if packet != nil {
+		packet.referenceCount++
+	}
+
+	peer.setupOutgoingCommand(outgoing)
+	return outgoing
+}
+
+// markDispatch queues the peer on the dispatch queue of its host.
+func (peer *Peer) markDispatch() {
+	if !peer.needsDispatch {
+		listInsert(peer.host.dispatchQueue.end(), &peer.dispatchList)
+		peer.needsDispatch = true
+	}
+}
+
+func (peer *Peer) dispatchIncomingUnreliableCommands(ch *channel, queued *incomingCommand) {
+	var dropped, start, current *listNode[incomingCommand]
+	dropped = ch.incomingUnreliableCommands.begin()
+	start = dropped
+
+	for current = start; current != ch.incomingUnreliableCommands.end(); current = current.next {
+		incoming := current.value
+
+		if incoming.command.command&commandMask == commandSendUnsequenced {
+			continue
+		}
+
+		if incoming.reliableSequenceNumber == ch.incomingReliableSequenceNumber {
+			if incoming.fragmentsRemaining == 0 {
+				ch.incomingUnreliableSequenceNumber = incoming.unreliableSequenceNumber
+				continue
+			}
+
+			if start != current {
+				listMove(peer.dispatchedCommands.end(), start, current.prev)
+				peer.markDispatch()
+				dropped = current
+			} else if dropped != current {
+				dropped = current.prev
+			}
+		} else {
+			reliableWindow := incoming.reliableSequenceNumber / peerReliableWindowSize
+			currentWindow := ch.incomingReliableSequenceNumber / peerReliableWindowSize
+
+			if incoming.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+				reliableWindow += peerReliableWindows
+			}
+			if reliableWindow >= currentWindow && reliableWindow < currentWindow+peerFreeReliableWindows-1 {
+				break
+			}
+
+			dropped = current.next
+
+			if start != current {
+				listMove(peer.dispatchedCommands.end(), start, current.prev)
+				peer.markDispatch()
+			}
+		}
+
+		start = current.next
+	}
+
+	if start != current {
+		listMove(peer.dispatchedCommands.end(), start, current.prev)
+		peer.markDispatch()
+		dropped = current
+	}
+
+	removeIncomingCommands(ch.incomingUnreliableCommands.begin(), dropped, queued)
+}
+
+func (peer *Peer) dispatchIncomingReliableCommands(ch *channel, queued *incomingCommand) {
+	var current *listNode[incomingCommand]
+	for current = ch.incomingReliableCommands.begin(); current != ch.incomingReliableCommands.end(); current = current.next {
+		incoming := current.value
+
+		if incoming.fragmentsRemaining > 0 || incoming.reliableSequenceNumber != ch.incomingReliableSequenceNumber+1 {
+			break
+		}
+
+		ch.incomingReliableSequenceNumber = incoming.reliableSequenceNumber
+		if incoming.fragmentCount > 0 {
+			ch.incomingReliableSequenceNumber += uint16(incoming.fragmentCount - 1)
+		}
+	}
+
+	if current == ch.incomingReliableCommands.begin() {
+		return
+	}
+
+	ch.incomingUnreliableSequenceNumber = 0
+	listMove(peer.dispatchedCommands.end(), ch.incomingReliableCommands.begin(), current.prev)
+	peer.markDispatch()
+
+	if !ch.incomingUnreliableCommands.empty() {
+		peer.dispatchIncomingUnreliableCommands(ch, queued)
+	}
+}
+
+// queueIncomingCommand queues a received command for dispatch. Returns nil on errors
+// and dummyCommand for commands that are dropped.
+func (peer *Peer) queueIncomingCommand(cmd *command, data []byte, dataLength int, flags uint32, fragmentCount uint32) *incomingCommand {
+	ch := &peer.channels[cmd.channelID]
+	var unreliableSequenceNumber, reliableSequenceNumber uint32
+	var current *listNode[incomingCommand]
+
+	discard := func() *incomingCommand {
+		if fragmentCount > 0 {
+			return nil
+		}
+		return &dummyCommand
+	}
+
+	if peer.state == PeerStateDisconnectLater {
+		return discard()
+	}
+
+	if cmd.command&commandMask != commandSendUnsequenced {
+		reliableSequenceNumber = uint32(cmd.reliableSequenceNumber)
+		reliableWindow := uint16(reliableSequenceNumber / peerReliableWindowSize)
+		currentWindow := ch.incomingReliableSequenceNumber / peerReliableWindowSize
+
+		if reliableSequenceNumber < uint32(ch.incomingReliableSequenceNumber) {
+			reliableWindow += peerReliableWindows
+		}
+		if reliableWindow < currentWindow || reliableWindow >= currentWindow+peerFreeReliableWindows-1 {
+			return discard()
+		}
+	}
+
+	switch cmd.command & commandMask {
+	case commandSendFragment, commandSendReliable:
+		if reliableSequenceNumber == uint32(ch.incomingReliableSequenceNumber) {
+			return discard()
+		}
+
+		for current = ch.incomingReliableCommands.end().prev; current != ch.incomingReliableCommands.end(); current = current.prev {
+			incoming := current.value
+
+			if reliableSequenceNumber >= uint32(ch.incomingReliableSequenceNumber) {
+				if incoming.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+					continue
+				}
+			} else if incoming.reliableSequenceNumber >= ch.incomingReliableSequenceNumber {
+				break
+			}
+
+			if uint32(incoming.reliableSequenceNumber) <= reliableSequenceNumber {
+				if uint32(incoming.reliableSequenceNumber) < reliableSequenceNumber {
+					break
+				}
+				return discard()
+			}
+		}
+
+	case commandSendUnreliable, commandSendUnreliableFragment:
+		// The start sequence number of unreliable fragments sits where unreliable
+		// sends keep their sequence number.
+		if cmd.command&commandMask == commandSendUnreliable {
+			unreliableSequenceNumber = uint32(cmd.unreliableSequenceNumber)
+		} else {
+			unreliableSequenceNumber = uint32(cmd.startSequenceNumber)
+		}
+
+		if reliableSequenceNumber == uint32(ch.incomingReliableSequenceNumber) && unreliableSequenceNumber <= uint32(ch.incomingUnreliableSequenceNumber) {
+			return discard()
+		}
+
+		for current = ch.incomingUnreliableCommands.end().prev; current != ch.incomingUnreliableCommands.end(); current = current.prev {
+			incoming := current.value
+
+			if reliableSequenceNumber >= uint32(ch.incomingReliableSequenceNumber) {
+				if incoming.reliableSequenceNumber < ch.incomingReliableSequenceNumber {
+					continue
+				}
+			} else if incoming.reliableSequenceNumber >= ch.incomingReliableSequenceNumber {
+				break
+			}
+
+			if uint32(incoming.reliableSequenceNumber) < reliableSequenceNumber {
+				break
+			}
+			if uint32(incoming.reliableSequenceNumber) > reliableSequenceNumber {
+				continue
+			}
+
+			if uint32(incoming.unreliableSequenceNumber) <= unreliableSequenceNumber {
+				if uint32(incoming.unreliableSequenceNumber) < unreliableSequenceNumber {
+					break
+				}
+				return discard()
+			}
+		}
+
+	case commandSendUnsequenced:
+		current = ch.incomingUnreliableCommands.end()
+
+	default:
+		return discard()
+	}
+
+	if peer.totalWaitingData >= peer.host.maximumWaitingData {
+		return nil
+	}
+
+	packet := newPacket(data, dataLength, flags)
+	incoming := &incomingCommand{
+		reliableSequenceNumber:   cmd.reliableSequenceNumber,
+		unreliableSequenceNumber: uint16(unreliableSequenceNumber),
+		command:                  *cmd,
+		fragmentCount:            fragmentCount,
+		fragmentsRemaining:       fragmentCount,
+		packet:                   packet,
+	}
+	incoming.node.value = incoming
+
+	if fragmentCount > 0 {
+		if fragmentCount > maximumFragmentCount {
+			return nil
+		}
+		incoming.fragments = make([]uint32, (fragmentCount+31)/32)
+	}
+
+	packet.referenceCount++
+	peer.totalWaitingData += len(packet.Data)
+
+	listInsert(current.next, &incoming.node)
+
+	switch cmd.command & commandMask {
+	case commandSendFragment, commandSendReliable:
+		peer.dispatchIncomingReliableCommands(ch, incoming)
+	default:
+		peer.dispatchIncomingUnreliableCommands(ch, incoming)
+	}
+	return incoming
+}
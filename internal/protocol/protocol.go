@@ -0,0 +1,298 @@
+// Package protocol is a pure Go implementation of the ENet-CSharp wire protocol. It is
+// a port of the protocol code of the bundled enet.h and talks to hosts using the C
+// library. The root package uses it instead of cgo when built with the purego tag.
+//
+// Like enet, hosts are not safe for concurrent use: a host and its peers must only be
+// used by the goroutine servicing the host.
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	minimumMTU            = 576
+	maximumMTU            = 4096
+	maximumPacketCommands = 32
+	minimumWindowSize     = 4096
+	maximumWindowSize     = 65536
+	minimumChannelCount   = 1
+	maximumChannelCount   = 255
+	maximumPeerID         = 0xFFF
+	maximumFragmentCount  = 1024 * 1024
+
+	// maximumBuffers mirrors ENET_BUFFER_MAXIMUM, which bounds the pieces a datagram
+	// is assembled from: the header, then a command and its payload per command.
+	maximumBuffers = 1 + 2*maximumPacketCommands
+)
+
+const (
+	commandNone                   = 0
+	commandAcknowledge            = 1
+	commandConnect                = 2
+	commandVerifyConnect          = 3
+	commandDisconnect             = 4
+	commandPing                   = 5
+	commandSendReliable           = 6
+	commandSendUnreliable         = 7
+	commandSendFragment           = 8
+	commandSendUnsequenced        = 9
+	commandBandwidthLimit         = 10
+	commandThrottleConfigure      = 11
+	commandSendUnreliableFragment = 12
+	commandCount                  = 13
+	commandMask                   = 0x0F
+
+	commandFlagAcknowledge = 1 << 7
+	commandFlagUnsequenced = 1 << 6
+
+	headerFlagSentTime = 1 << 14
+	headerFlagMask     = headerFlagSentTime
+	headerSessionMask  = 3 << 12
+	headerSessionShift = 12
+
+	stockHeaderFlagCompressed = 1 << 14
+	stockHeaderFlagSentTime   = 1 << 15
+)
+
+const (
+	hostBandwidthThrottleInterval     = 1000
+	hostDefaultMTU                    = 1280
+	hostDefaultMaximumPacketSize      = 32 * 1024 * 1024
+	hostDefaultMaximumWaitingData     = 32 * 1024 * 1024
+	peerDefaultRoundTripTime          = 1
+	peerDefaultPacketThrottle         = 32
+	peerPacketThrottleThreshold       = 40
+	peerPacketThrottleScale           = 32
+	peerPacketThrottleCounter         = 7
+	peerPacketThrottleAcceleration    = 2
+	peerPacketThrottleDeceleration    = 2
+	peerPacketThrottleInterval        = 5000
+	peerWindowSizeScale               = 64 * 1024
+	peerTimeoutLimit                  = 32
+	peerTimeoutMinimum                = 5000
+	peerTimeoutMaximum                = 30000
+	peerPingInterval                  = 250
+	peerUnsequencedWindowSize         = 1024
+	peerFreeUnsequencedWindows        = 32
+	peerReliableWindows               = 16
+	peerReliableWindowSize            = 0x1000
+	peerFreeReliableWindows           = 8
+	protocolHeaderSize                = 4
+	protocolHeaderSizeWithoutSentTime = 2
+)
+
+// commandSizes are the sizes of the commands on the wire, not counting their payload.
+var commandSizes = [commandCount]int{
+	commandNone:                   0,
+	commandAcknowledge:            8,
+	commandConnect:                48,
+	commandVerifyConnect:          44,
+	commandDisconnect:             8,
+	commandPing:                   4,
+	commandSendReliable:           6,
+	commandSendUnreliable:         8,
+	commandSendFragment:           24,
+	commandSendUnsequenced:        8,
+	commandBandwidthLimit:         12,
+	commandThrottleConfigure:      16,
+	commandSendUnreliableFragment: 24,
+}
+
+func commandSize(command uint8) int {
+	return commandSizes[command&commandMask]
+}
+
+// command is a decoded protocol command. Only the fields of its kind are used, all in
+// host byte order except connectID, which enet treats as opaque.
+type command struct {
+	command                uint8
+	channelID              uint8
+	reliableSequenceNumber uint16
+
+	// acknowledge
+	receivedReliableSequenceNumber uint16
+	receivedSentTime               uint16
+
+	// connect and verify connect
+	outgoingPeerID             uint16
+	incomingSessionID          uint8
+	outgoingSessionID          uint8
+	mtu                        uint32
+	windowSize                 uint32
+	channelCount               uint32
+	incomingBandwidth          uint32
+	outgoingBandwidth          uint32
+	packetThrottleInterval     uint32
+	packetThrottleAcceleration uint32
+	packetThrottleDeceleration uint32
+	connectID                  uint32
+
+	// connect and disconnect
+	data uint32
+
+	// sends
+	unreliableSequenceNumber uint16
+	unsequencedGroup         uint16
+	startSequenceNumber      uint16
+	dataLength               uint16
+	fragmentCount            uint32
+	fragmentNumber           uint32
+	totalLength              uint32
+	fragmentOffset           uint32
+}
+
+// encode appends the command as it is sent on the wire to b.
+func (c *command) encode(b []byte) []byte {
+	be := binary.BigEndian
+	b = append(b, c.command, c.channelID)
+	b = be.AppendUint16(b, c.reliableSequenceNumber)
+
+	switch c.command & commandMask {
+	case commandAcknowledge:
+		b = be.AppendUint16(b, c.receivedReliableSequenceNumber)
+		b = be.AppendUint16(b, c.receivedSentTime)
+
+	case commandConnect, commandVerifyConnect:
+		b = be.AppendUint16(b, c.outgoingPeerID)
+		b = append(b, c.incomingSessionID, c.outgoingSessionID)
+		b = be.AppendUint32(b, c.mtu)
+		b = be.AppendUint32(b, c.windowSize)
+		b = be.AppendUint32(b, c.channelCount)
+		b = be.AppendUint32(b, c.incomingBandwidth)
+		b = be.AppendUint32(b, c.outgoingBandwidth)
+		b = be.AppendUint32(b, c.packetThrottleInterval)
+		b = be.AppendUint32(b, c.packetThrottleAcceleration)
+		b = be.AppendUint32(b, c.packetThrottleDeceleration)
+		b = be.AppendUint32(b, c.connectID)
+		if c.command&commandMask == commandConnect {
+			b = be.AppendUint32(b, c.data)
+		}
+
+	case commandDisconnect:
+		b = be.AppendUint32(b, c.data)
+
+	case commandSendReliable:
+		b = be.AppendUint16(b, c.dataLength)
+
+	case commandSendUnreliable:
+		b = be.AppendUint16(b, c.unreliableSequenceNumber)
+		b = be.AppendUint16(b, c.dataLength)
+
+	case commandSendUnsequenced:
+		b = be.AppendUint16(b, c.unsequencedGroup)
+		b = be.AppendUint16(b, c.dataLength)
+
+	case commandSendFragment, commandSendUnreliableFragment:
+		b = be.AppendUint16(b, c.startSequenceNumber)
+		b = be.AppendUint16(b, c.dataLength)
+		b = be.AppendUint32(b, c.fragmentCount)
+		b = be.AppendUint32(b, c.fragmentNumber)
+		b = be.AppendUint32(b, c.totalLength)
+		b = be.AppendUint32(b, c.fragmentOffset)
+
+	case commandBandwidthLimit:
+		b = be.AppendUint32(b, c.incomingBandwidth)
+		b = be.AppendUint32(b, c.outgoingBandwidth)
+
+	case commandThrottleConfigure:
+		b = be.AppendUint32(b, c.packetThrottleInterval)
+		b = be.AppendUint32(b, c.packetThrottleAcceleration)
+		b = be.AppendUint32(b, c.packetThrottleDeceleration)
+	}
+	return b
+}
+
+// decodeCommand decodes a command from b, which holds at least commandSize bytes of
+// the command.
+func decodeCommand(b []byte) command {
+	be := binary.BigEndian
+	c := command{
+		command:                b[0],
+		channelID:              b[1],
+		reliableSequenceNumber: be.Uint16(b[2:]),
+	}
+
+	switch c.command & commandMask {
+	case commandAcknowledge:
+		c.receivedReliableSequenceNumber = be.Uint16(b[4:])
+		c.receivedSentTime = be.Uint16(b[6:])
+
+	case commandConnect, commandVerifyConnect:
+		c.outgoingPeerID = be.Uint16(b[4:])
+		c.incomingSessionID = b[6]
+		c.outgoingSessionID = b[7]
+		c.mtu = be.Uint32(b[8:])
+		c.windowSize = be.Uint32(b[12:])
+		c.channelCount = be.Uint32(b[16:])
+		c.incomingBandwidth = be.Uint32(b[20:])
+		c.outgoingBandwidth = be.Uint32(b[24:])
+		c.packetThrottleInterval = be.Uint32(b[28:])
+		c.packetThrottleAcceleration = be.Uint32(b[32:])
+		c.packetThrottleDeceleration = be.Uint32(b[36:])
+		c.connectID = be.Uint32(b[40:])
+		if c.command&commandMask == commandConnect {
+			c.data = be.Uint32(b[44:])
+		}
+
+	case commandDisconnect:
+		c.data = be.Uint32(b[4:])
+
+	case commandSendReliable:
+		c.dataLength = be.Uint16(b[4:])
+
+	case commandSendUnreliable:
+		c.unreliableSequenceNumber = be.Uint16(b[4:])
+		c.dataLength = be.Uint16(b[6:])
+
+	case commandSendUnsequenced:
+		c.unsequencedGroup = be.Uint16(b[4:])
+		c.dataLength = be.Uint16(b[6:])
+
+	case commandSendFragment, commandSendUnreliableFragment:
+		c.startSequenceNumber = be.Uint16(b[4:])
+		c.dataLength = be.Uint16(b[6:])
+		c.fragmentCount = be.Uint32(b[8:])
+		c.fragmentNumber = be.Uint32(b[12:])
+		c.totalLength = be.Uint32(b[16:])
+		c.fragmentOffset = be.Uint32(b[20:])
+
+	case commandBandwidthLimit:
+		c.incomingBandwidth = be.Uint32(b[4:])
+		c.outgoingBandwidth = be.Uint32(b[8:])
+
+	case commandThrottleConfigure:
+		c.packetThrottleInterval = be.Uint32(b[4:])
+		c.packetThrottleAcceleration = be.Uint32(b[8:])
+		c.packetThrottleDeceleration = be.Uint32(b[12:])
+	}
+	return c
+}
+
+// timeOverflow is how far apart two times may be before the difference is taken as a
+// wrap around of the millisecond clock.
+const timeOverflow = 86400000
+
+func timeLess(a, b uint32) bool {
+	return a-b >= timeOverflow
+}
+
+func timeGreaterEqual(a, b uint32) bool {
+	return !timeLess(a, b)
+}
+
+func timeDifference(a, b uint32) uint32 {
+	if a-b >= timeOverflow {
+		return b - a
+	}
+	return a - b
+}
+
+var timeBase = time.Now().Add(-time.Millisecond)
+
+// timeGet returns the enet clock: milliseconds since the package was loaded, starting
+// at 1 as 0 means unset in a few places.
+func timeGet() uint32 {
+	return uint32(time.Since(timeBase) / time.Millisecond)
+}
@@ -0,0 +1,287 @@
+package enet
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySubBuckets is the number of buckets per power of two of the histograms,
+	// keeping their values within 1/64 of the round trips they stand for.
+	latencySubBuckets = 128
+
+	// latencyBuckets covers round trips up to 65535 milliseconds, the longest enet
+	// can time with the 16 bits of sent time it acknowledges.
+	latencyBuckets = latencySubBuckets + 9*latencySubBuckets/2
+
+	latencyMax = math.MaxUint16
+)
+
+// LatencyHistogram is a histogram of round trip times in milliseconds, with buckets
+// growing with the round trips so every bucket is within 1/64 of its values, like an
+// HDR histogram with two significant digits
+type LatencyHistogram struct {
+	counts [latencyBuckets]uint64
+	count  uint64
+	sum    uint64
+	min    uint32
+	max    uint32
+}
+
+// latencyBucket returns the bucket of a round trip of ms milliseconds.
+func latencyBucket(ms uint32) int {
+	if ms < latencySubBuckets {
+		return int(ms)
+	}
+	shift := bits.Len32(ms) - 7
+	return latencySubBuckets + (shift-1)*latencySubBuckets/2 + int(ms>>shift) - latencySubBuckets/2
+}
+
+// latencyBucketMax returns the longest round trip of a bucket in milliseconds.
+func latencyBucketMax(bucket int) uint32 {
+	if bucket < latencySubBuckets {
+		return uint32(bucket)
+	}
+	bucket -= latencySubBuckets
+	shift := bucket/(latencySubBuckets/2) + 1
+	sub := uint32(bucket%(latencySubBuckets/2) + latencySubBuckets/2)
+	return (sub+1)<<shift - 1
+}
+
+// Record adds a round trip to the histogram
+func (h *LatencyHistogram) Record(rtt time.Duration) {
+	ms := uint32(min(max(rtt.Milliseconds(), 0), latencyMax))
+	h.counts[latencyBucket(ms)]++
+	if h.count == 0 || ms < h.min {
+		h.min = ms
+	}
+	if ms > h.max {
+		h.max = ms
+	}
+	h.count++
+	h.sum += uint64(ms)
+}
+
+// Count returns the number of round trips recorded
+func (h *LatencyHistogram) Count() uint64 {
+	return h.count
+}
+
+// Mean returns the mean of the round trips recorded
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum) * time.Millisecond / time.Duration(h.count)
+}
+
+// Min returns the shortest round trip recorded
+func (h *LatencyHistogram) Min() time.Duration {
+	return time.Duration(h.min) * time.Millisecond
+}
+
+// Max returns the longest round trip recorded
+func (h *LatencyHistogram) Max() time.Duration {
+	return time.Duration(h.max) * time.Millisecond
+}
+
+// Percentile returns the round trip p percent of the round trips recorded are shorter
+// than or equal to, p ranging from 0 to 100. Returns 0 if none was recorded.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(min(max(p, 0), 100) / 100 * float64(h.count)))
+	rank = max(rank, 1)
+
+	var seen uint64
+	for bucket, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			ms := min(max(latencyBucketMax(bucket), h.min), h.max)
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return h.Max()
+}
+
+// Reset forgets the round trips recorded
+func (h *LatencyHistogram) Reset() {
+	*h = LatencyHistogram{}
+}
+
+// LatencyConfig configures how the latencies of peers are tracked
+type LatencyConfig struct {
+	// ResetInterval resets the histograms of peers once they are older, so they show
+	// recent round trips only. 0 never resets them.
+	ResetInterval time.Duration
+}
+
+type peerLatency struct {
+	histogram LatencyHistogram
+	reset     time.Time
+}
+
+// latencyTable holds the latency histograms of the peers of a host.
+type latencyTable struct {
+	lock   sync.Mutex
+	config LatencyConfig
+	peers  map[enetPeer]*peerLatency
+}
+
+// TrackLatency makes host record the round trip of every acknowledgement its peers
+// send into a histogram per peer, see PeerLatency. Unlike the mean round trip time
+// kept by enet, histograms show the spikes averages hide. Calling it again replaces
+// the configuration. Hosts in the browser record nothing, as the bridge acknowledges
+// their traffic.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func TrackLatency(host Host, config LatencyConfig) error {
+	if config.ResetInterval < 0 {
+		return errors.New("reset interval is negative")
+	}
+
+	var err error
+	track := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("latency tracking is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.latency
+		table.lock.Lock()
+		table.config = config
+		install := table.peers == nil
+		if install {
+			table.peers = make(map[enetPeer]*peerLatency)
+		}
+		table.lock.Unlock()
+
+		if install {
+			h.intercepts.add(h, func(addr rawAddress, data []byte) bool {
+				table.intercept(h, addr, data)
+				return false
+			})
+			h.features.join(featureLatency)
+		}
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(track)
+	} else {
+		track(host)
+	}
+	return err
+}
+
+// PeerLatency returns a copy of the latency histogram of peer, whose host tracks
+// latencies with TrackLatency. Returns false if no round trip has been recorded for the
+// peer. It is safe to call from any goroutine.
+func PeerLatency(peer Peer) (LatencyHistogram, bool) {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return LatencyHistogram{}, false
+	}
+	host := p.host()
+	if host == nil {
+		return LatencyHistogram{}, false
+	}
+
+	table := &host.latency
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	latency, ok := table.peers[p]
+	if !ok {
+		return LatencyHistogram{}, false
+	}
+	return latency.histogram, true
+}
+
+// intercept records the round trips of the acknowledgements of a datagram.
+func (table *latencyTable) intercept(host *enetHost, addr rawAddress, data []byte) {
+	datagram, offset, err := decodeWireHeader(data, host.wireProtocol())
+	if err != nil {
+		return
+	}
+	peer, ok := host.datagramPeer(addr, datagram.PeerID)
+	if !ok {
+		return
+	}
+
+	clock := host.serviceClock()
+	var latency *peerLatency
+	for offset < len(data) {
+		command, n, err := decodeWireCommand(data[offset:])
+		if err != nil {
+			return
+		}
+		offset += n
+		if command.Type != CommandAcknowledge {
+			continue
+		}
+
+		// Like enet, take the sent time as the latest one its 16 bits fit.
+		sentTime := uint32(command.ReceivedSentTime) | clock&0xFFFF0000
+		if sentTime&0x8000 > clock&0x8000 {
+			sentTime -= 0x10000
+		}
+		rtt := max(clock-sentTime, 1)
+		if rtt > latencyMax {
+			continue
+		}
+
+		if latency == nil {
+			latency = table.peer(peer)
+		}
+		table.lock.Lock()
+		latency.histogram.Record(time.Duration(rtt) * time.Millisecond)
+		table.lock.Unlock()
+	}
+}
+
+// peer returns the latency of peer, resetting it if due.
+func (table *latencyTable) peer(peer enetPeer) *peerLatency {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	now := time.Now()
+	latency, ok := table.peers[peer]
+	if !ok {
+		latency = &peerLatency{reset: now}
+		table.peers[peer] = latency
+	}
+	if interval := table.config.ResetInterval; interval > 0 && now.Sub(latency.reset) >= interval {
+		latency.histogram.Reset()
+		latency.reset = now
+	}
+	return latency
+}
+
+// forget drops the histogram of a peer that disconnected, as its slot is reused by the
+// next peer to connect.
+func (table *latencyTable) forget(event *enetEvent) {
+	eventType := event.GetType()
+	if eventType != EventDisconnect && eventType != EventDisconnectTimeout {
+		return
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return
+	}
+
+	table.lock.Lock()
+	delete(table.peers, peer)
+	table.lock.Unlock()
+}
@@ -0,0 +1,134 @@
+package enet
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// LeakCounts are the numbers of live C allocations seen by the leak tracker
+type LeakCounts struct {
+	Packets  int
+	Hosts    int
+	PeerData int
+}
+
+const (
+	leakPacket = "packet"
+	leakHost   = "host"
+	leakData   = "peer data"
+)
+
+type leakRecord struct {
+	kind  string
+	stack string
+}
+
+var (
+	leakTracking atomic.Bool
+	leakLock     sync.Mutex
+	leakRecords  = make(map[unsafe.Pointer]leakRecord)
+)
+
+// EnableLeakTracking turns the tracking of packets, hosts and peer data allocated in C
+// memory on or off. This memory is invisible to the Go tooling. While enabled, every
+// allocation records the stack trace that created it until it is freed or handed over
+// to enet, see DumpLeaks. Only allocations made while tracking is enabled are seen.
+// Tracking is expensive and meant for debugging only. Builds with the purego tag
+// allocate no C memory and track nothing.
+func EnableLeakTracking(enabled bool) {
+	leakTracking.Store(enabled)
+	if !enabled {
+		leakLock.Lock()
+		leakRecords = make(map[unsafe.Pointer]leakRecord)
+		leakLock.Unlock()
+	}
+}
+
+// GetLeakCounts returns the number of tracked allocations still alive
+func GetLeakCounts() LeakCounts {
+	leakLock.Lock()
+	defer leakLock.Unlock()
+
+	var counts LeakCounts
+	for _, record := range leakRecords {
+		switch record.kind {
+		case leakPacket:
+			counts.Packets++
+		case leakHost:
+			counts.Hosts++
+		case leakData:
+			counts.PeerData++
+		}
+	}
+	return counts
+}
+
+// DumpLeaks writes a report of all tracked allocations still alive to w, grouped by the
+// stack trace that created them.
+func DumpLeaks(w io.Writer) error {
+	leakLock.Lock()
+	groups := make(map[leakRecord]int)
+	for _, record := range leakRecords {
+		groups[record]++
+	}
+	leakLock.Unlock()
+
+	records := make([]leakRecord, 0, len(groups))
+	for record := range groups {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if groups[records[i]] != groups[records[j]] {
+			return groups[records[i]] > groups[records[j]]
+		}
+		return records[i].kind < records[j].kind
+	})
+
+	for _, record := range records {
+		_, err := fmt.Fprintf(w, "%d live %s allocation(s) created at:\n%s\n", groups[record], record.kind, record.stack)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func leakTrack(ptr unsafe.Pointer, kind string) {
+	if !leakTracking.Load() || ptr == nil {
+		return
+	}
+
+	pc := make([]uintptr, 32)
+	// Skip runtime.Callers, leakTrack and the allocating function of this package.
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	var stack strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&stack, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	leakLock.Lock()
+	leakRecords[ptr] = leakRecord{kind: kind, stack: stack.String()}
+	leakLock.Unlock()
+}
+
+func leakUntrack(ptr unsafe.Pointer) {
+	if !leakTracking.Load() || ptr == nil {
+		return
+	}
+
+	leakLock.Lock()
+	delete(leakRecords, ptr)
+	leakLock.Unlock()
+}
@@ -0,0 +1,446 @@
+// Package loadtest puts a host under load from many clients running in process, each a
+// host of its own, and reports how it held up:
+//
+//	report, err := loadtest.Run(ctx, loadtest.Config{
+//		Target:   enet.NewAddress("127.0.0.1", 7777),
+//		Clients:  500,
+//		Rate:     20,
+//		Duration: time.Minute,
+//		Echo:     true,
+//	})
+//
+// Latency is measured on messages the target sends back, which requires it to echo
+// every message it receives to its sender as is, like cmd/enet-echod does. The round
+// trip times enet measures are reported either way.
+package loadtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// timestampSize is the size of the send time leading every message.
+const timestampSize = 8
+
+// Message is a kind of message the clients send
+type Message struct {
+	// Size is the size of the message in bytes, at least 8 as it carries its send time
+	Size int
+
+	Channel uint8
+	Flags   enet.PacketFlags
+
+	// Weight is the share of the messages of this kind among all kinds, 1 if 0
+	Weight int
+}
+
+// Config configures a load test
+type Config struct {
+	// Target is the address of the host under test
+	Target enet.Address
+
+	// Clients is the number of clients connecting to the target
+	Clients int
+
+	// ConnectRate is the number of clients starting to connect per second, all at once
+	// if 0
+	ConnectRate float64
+
+	// ConnectTimeout is how long a client waits to connect. Defaults to 5 seconds.
+	ConnectTimeout time.Duration
+
+	// ChannelCount is the number of channels clients connect with. Defaults to one more
+	// than the highest channel of the messages.
+	ChannelCount int
+
+	// ConnectData is the data clients pass on connect
+	ConnectData uint32
+
+	// Duration is how long each connected client sends messages for
+	Duration time.Duration
+
+	// Rate is the number of messages each client sends per second
+	Rate float64
+
+	// Messages is the mix of messages the clients send. Defaults to 64 bytes sent
+	// reliably on channel 0.
+	Messages []Message
+
+	// Echo tells that the target echoes every message, see the package documentation
+	Echo bool
+
+	// NewHost creates the host of a client, for example on a NetworkSimulator. Defaults
+	// to a host of its own UDP socket with a single peer.
+	NewHost func(channelCount int) (enet.Host, error)
+}
+
+// Distribution summarizes durations measured over a load test
+type Distribution struct {
+	Count int
+
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// Report is the outcome of a load test
+type Report struct {
+	// Clients is the number of clients started, fewer than configured if the test was
+	// cancelled while they were starting
+	Clients int
+
+	// Connected counts the clients that connected, and ConnectFailures those that
+	// timed out or were refused
+	Connected       int
+	ConnectFailures int
+
+	// Dropped counts the clients disconnected by the target or timed out before the
+	// end of the test
+	Dropped int
+
+	// ConnectTime is the time clients took to connect
+	ConnectTime Distribution
+
+	// Sent counts the messages sent and Echoed those that came back, if the target
+	// echoes
+	Sent   uint64
+	Echoed uint64
+
+	// BytesSent and BytesEchoed are the sizes of the messages sent and echoed, added up
+	BytesSent   uint64
+	BytesEchoed uint64
+
+	// Latency is the round trip time of echoed messages, as seen by the application
+	Latency Distribution
+
+	// RoundTripTime is the mean round trip time enet measured to the target, per
+	// client as of the end of the test
+	RoundTripTime Distribution
+
+	// PacketsSent and PacketsLost are the packets enet sent and deemed lost, over all
+	// clients
+	PacketsSent uint64
+	PacketsLost uint64
+
+	// Elapsed is the time the test took
+	Elapsed time.Duration
+}
+
+// Loss returns the share of the packets sent that enet deemed lost
+func (report *Report) Loss() float64 {
+	if report.PacketsSent == 0 {
+		return 0
+	}
+	return float64(report.PacketsLost) / float64(report.PacketsSent)
+}
+
+// MessageLoss returns the share of the messages sent that did not come back, if the
+// target echoes
+func (report *Report) MessageLoss() float64 {
+	if report.Sent == 0 {
+		return 0
+	}
+	return 1 - float64(report.Echoed)/float64(report.Sent)
+}
+
+// clientResult is what a client measured.
+type clientResult struct {
+	connected   bool
+	dropped     bool
+	connectTime time.Duration
+	sent        uint64
+	echoed      uint64
+	bytesSent   uint64
+	bytesEchoed uint64
+	latencies   []time.Duration
+	rtt         time.Duration
+	packetsSent uint64
+	packetsLost uint64
+}
+
+// Run runs a load test until every client is done or ctx is cancelled, in which case
+// the report covers what was measured so far. Fails only if the config is invalid or
+// no client host could be created.
+func Run(ctx context.Context, config Config) (*Report, error) {
+	if config.Target == nil {
+		return nil, errors.New("no target")
+	}
+	if config.Clients <= 0 {
+		return nil, errors.New("clients must be positive")
+	}
+	if config.Rate < 0 || config.ConnectRate < 0 {
+		return nil, errors.New("rates must not be negative")
+	}
+	config.Messages = slices.Clone(config.Messages)
+	if len(config.Messages) == 0 {
+		config.Messages = []Message{{Size: 64, Flags: enet.PacketFlagReliable}}
+	}
+	for i, message := range config.Messages {
+		if message.Size < timestampSize {
+			return nil, errors.New("messages must be at least 8 bytes")
+		}
+		if message.Weight < 0 {
+			return nil, errors.New("message weights must not be negative")
+		}
+		if message.Weight == 0 {
+			config.Messages[i].Weight = 1
+		}
+		config.ChannelCount = max(config.ChannelCount, int(message.Channel)+1)
+	}
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = 5 * time.Second
+	}
+	if config.NewHost == nil {
+		config.NewHost = func(channelCount int) (enet.Host, error) {
+			return enet.NewHost(nil, 1, uint64(channelCount), 0, 0, 0)
+		}
+	}
+
+	start := time.Now()
+	results := make([]clientResult, config.Clients)
+	errs := make([]error, config.Clients)
+	var (
+		wg      sync.WaitGroup
+		started int
+	)
+	for ; started < config.Clients; started++ {
+		if config.ConnectRate > 0 && started > 0 {
+			due := start.Add(time.Duration(float64(started) / config.ConnectRate * float64(time.Second)))
+			if !sleepUntil(ctx, due) {
+				break
+			}
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = runClient(ctx, config, uint64(i), &results[i])
+		}(started)
+	}
+	wg.Wait()
+
+	results, errs = results[:started], errs[:started]
+	if started > 0 && !slices.ContainsFunc(errs, func(err error) bool { return err == nil }) {
+		return nil, errs[0]
+	}
+
+	report := summarize(results, errs)
+	report.Clients = started
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// runClient connects a client to the target and sends messages until the end of the
+// test, filling result as it goes.
+func runClient(ctx context.Context, config Config, seed uint64, result *clientResult) error {
+	host, err := config.NewHost(config.ChannelCount)
+	if err != nil {
+		return err
+	}
+	defer host.Destroy()
+	if err := enet.TrackPeerStats(host); err != nil {
+		return err
+	}
+
+	connecting := time.Now()
+	peer, err := host.Connect(config.Target, config.ChannelCount, config.ConnectData)
+	if err != nil {
+		// Counted as a failure to connect.
+		return nil
+	}
+
+	event := enet.NewEvent()
+	for !result.connected {
+		wait := time.Until(connecting.Add(config.ConnectTimeout))
+		if wait <= 0 || ctx.Err() != nil {
+			peer.DisconnectNow(0)
+			return nil
+		}
+		if host.ServiceV2(event, serviceTimeout(wait)) <= 0 {
+			continue
+		}
+		switch event.GetType() {
+		case enet.EventConnect:
+			result.connected = true
+			result.connectTime = time.Since(connecting)
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			return nil
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+
+	random := rand.New(rand.NewPCG(seed, uint64(connecting.UnixNano())))
+	var totalWeight int
+	for _, message := range config.Messages {
+		totalWeight += message.Weight
+	}
+
+	end := time.Now().Add(config.Duration)
+	next := time.Now()
+	var interval time.Duration
+	if config.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / config.Rate)
+	}
+	for ctx.Err() == nil {
+		now := time.Now()
+		if !now.Before(end) {
+			break
+		}
+		if interval > 0 && !now.Before(next) {
+			message := pick(config.Messages, random.IntN(totalWeight))
+			data := make([]byte, message.Size)
+			binary.LittleEndian.PutUint64(data, uint64(now.UnixNano()))
+			if peer.SendBytes(data, message.Channel, message.Flags) == nil {
+				result.sent++
+				result.bytesSent += uint64(message.Size)
+			}
+			next = next.Add(interval)
+			continue
+		}
+
+		wait := end.Sub(now)
+		if interval > 0 {
+			wait = min(wait, next.Sub(now))
+		}
+		if host.ServiceV2(event, serviceTimeout(wait)) <= 0 {
+			continue
+		}
+		switch event.GetType() {
+		case enet.EventReceive:
+			packet := event.GetPacket()
+			if data := packet.GetData(); config.Echo && len(data) >= timestampSize {
+				sent := time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+				result.echoed++
+				result.bytesEchoed += uint64(len(data))
+				result.latencies = append(result.latencies, time.Since(sent))
+			}
+			packet.Destroy()
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			result.dropped = true
+			return nil
+		}
+	}
+
+	if stats := enet.GetPeerStats(host); len(stats) > 0 {
+		result.rtt = stats[0].RoundTripTime
+	}
+	result.packetsSent = peer.GetPacketsSent()
+	result.packetsLost = peer.GetPacketsLost()
+	disconnect(host, peer)
+	return nil
+}
+
+// disconnect disconnects gracefully from the target, giving it a moment to
+// acknowledge before dropping the connection.
+func disconnect(host enet.Host, peer enet.Peer) {
+	peer.Disconnect(0)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		event := host.Service(50)
+		switch event.GetType() {
+		case enet.EventDisconnect, enet.EventDisconnectTimeout:
+			return
+		case enet.EventReceive:
+			event.GetPacket().Destroy()
+		}
+	}
+	peer.DisconnectNow(0)
+}
+
+// pick returns the message a weighted draw n falls on.
+func pick(messages []Message, n int) Message {
+	for _, message := range messages {
+		if n < message.Weight {
+			return message
+		}
+		n -= message.Weight
+	}
+	return messages[len(messages)-1]
+}
+
+// serviceTimeout converts a wait to a Service timeout, capped so clients notice the
+// context being cancelled.
+func serviceTimeout(wait time.Duration) uint32 {
+	return uint32(min(max(wait, 0), 100*time.Millisecond) / time.Millisecond)
+}
+
+// sleepUntil waits until t. Returns false if ctx was cancelled first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// summarize adds up the results of the clients that ran.
+func summarize(results []clientResult, errs []error) *Report {
+	report := &Report{}
+	var connectTimes, latencies, rtts []time.Duration
+	for i, result := range results {
+		if errs[i] != nil {
+			continue
+		}
+		if !result.connected {
+			report.ConnectFailures++
+			continue
+		}
+		report.Connected++
+		if result.dropped {
+			report.Dropped++
+		}
+		connectTimes = append(connectTimes, result.connectTime)
+		latencies = append(latencies, result.latencies...)
+		if result.rtt > 0 {
+			rtts = append(rtts, result.rtt)
+		}
+		report.Sent += result.sent
+		report.Echoed += result.echoed
+		report.BytesSent += result.bytesSent
+		report.BytesEchoed += result.bytesEchoed
+		report.PacketsSent += result.packetsSent
+		report.PacketsLost += result.packetsLost
+	}
+	report.ConnectTime = distribution(connectTimes)
+	report.Latency = distribution(latencies)
+	report.RoundTripTime = distribution(rtts)
+	return report
+}
+
+// distribution summarizes samples, sorting them.
+func distribution(samples []time.Duration) Distribution {
+	if len(samples) == 0 {
+		return Distribution{}
+	}
+	slices.Sort(samples)
+
+	var sum time.Duration
+	for _, sample := range samples {
+		sum += sample
+	}
+	percentile := func(p float64) time.Duration {
+		return samples[min(int(p*float64(len(samples))), len(samples)-1)]
+	}
+	return Distribution{
+		Count: len(samples),
+		Min:   samples[0],
+		Mean:  sum / time.Duration(len(samples)),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
@@ -0,0 +1,360 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultLockstepInputDelay is the number of ticks inputs are scheduled ahead by
+	// default
+	DefaultLockstepInputDelay = 2
+
+	// DefaultLockstepStallTimeout is how long a lockstep waits for the inputs of a tick
+	// by default before reporting a stall
+	DefaultLockstepStallTimeout = time.Second
+
+	// lockstepHeaderSize is the size of the kind and tick leading lockstep messages.
+	lockstepHeaderSize = 5
+
+	// lockstepMaxAhead bounds how far ahead of the local tick inputs and hashes of peers
+	// are buffered, beyond which they are dropped.
+	lockstepMaxAhead = 1024
+
+	// lockstepHashHistory is how many ticks back the hashes of the local state are
+	// kept to compare with those of peers.
+	lockstepHashHistory = 256
+)
+
+// Kinds of lockstep messages.
+const (
+	lockstepInput byte = iota
+	lockstepHash
+)
+
+// LockstepInput is the input of a participant for a tick
+type LockstepInput struct {
+	// Peer is the participant the input is from, nil for the local one
+	Peer Peer
+
+	Input []byte
+}
+
+// LockstepStall describes a tick a lockstep has been waiting on for longer than its
+// stall timeout
+type LockstepStall struct {
+	Tick uint32
+
+	// Missing are the peers whose input for the tick has not arrived
+	Missing []Peer
+
+	// Duration is how long the tick has been waited on
+	Duration time.Duration
+}
+
+// LockstepConfig configures a Lockstep
+type LockstepConfig struct {
+	// Channel is the channel inputs and hashes are exchanged on, reliably
+	Channel uint8
+
+	// InputDelay is the number of ticks inputs are scheduled ahead, hiding the latency
+	// of exchanging them. Defaults to DefaultLockstepInputDelay.
+	InputDelay int
+
+	// StallTimeout is how long the lockstep waits for the inputs of a tick before
+	// reporting a stall. Defaults to DefaultLockstepStallTimeout.
+	StallTimeout time.Duration
+
+	// OnStall is called once per stalled tick, for example to show who the game is
+	// waiting for or to drop them. It may be nil.
+	OnStall func(LockstepStall)
+
+	// OnDesync is called when the hash of the state after a tick reported by a peer
+	// differs from the local one, see Lockstep.ReportHash. It may be nil.
+	OnDesync func(tick uint32, peer Peer, local, remote uint64)
+
+	// Clock times stalls. Defaults to the SystemClock.
+	Clock Clock
+}
+
+// Lockstep runs a deterministic simulation in lockstep with peers, the standard pattern
+// of RTS games: every participant submits its input for every tick, inputs are
+// exchanged reliably, and a tick only runs once the inputs of all participants for it
+// are in. Each participant simulates every tick with the same inputs, so the states
+// stay identical without ever being sent, which hashes of the state verify.
+//
+// Participants exchange inputs directly, so every participant must be connected to
+// every other. A Lockstep is not safe for concurrent use, and must be used by the
+// goroutine servicing the host of its peers.
+type Lockstep interface {
+	// AddPeer adds peer as a participant, whose input the next tick to run then waits
+	// for
+	AddPeer(peer Peer)
+
+	// RemovePeer removes peer from the participants, so ticks no longer wait on it.
+	// Handle does it for the disconnect events it sees.
+	RemovePeer(peer Peer)
+
+	// Peers returns the participants other than the local one
+	Peers() []Peer
+
+	// SubmitInput submits the local input for the next tick it is due for, InputDelay
+	// ticks ahead of the tick to run at first, and sends it to the participants. It
+	// should be called once per tick run.
+	SubmitInput(input []byte) error
+
+	// Advance returns the inputs of all participants for the next tick to run, the local
+	// one first and the others in the order they were added, and moves on to the tick
+	// after. Participants see the inputs in different orders, so the simulation must
+	// sort them the same way on all sides, for example by player ID. Returns false if
+	// inputs are missing, reporting a stall once the tick has been waited on for longer
+	// than the stall timeout. The first InputDelay ticks run with empty inputs.
+	Advance() (tick uint32, inputs []LockstepInput, ok bool)
+
+	// Tick returns the next tick to run
+	Tick() uint32
+
+	// ReportHash reports the hash of the local state after running tick and sends it to
+	// the participants, which compare it with their own. Mismatches on either side are
+	// passed to OnDesync.
+	ReportHash(tick uint32, hash uint64) error
+
+	// Handle consumes the lockstep messages received on the lockstep channel, returning
+	// true if it did, and removes participants once they disconnect. It must be called
+	// for every event serviced.
+	Handle(event Event) bool
+}
+
+type lockstepPeer struct {
+	inputs map[uint32][]byte
+	hashes map[uint32]uint64
+}
+
+type enetLockstep struct {
+	config LockstepConfig
+
+	tick      uint32
+	inputTick uint32
+	local     map[uint32][]byte
+	hashes    map[uint32]uint64
+
+	peers map[Peer]*lockstepPeer
+	order []Peer
+
+	// waiting is when the next tick was first found incomplete, zero if it was not.
+	waiting  time.Time
+	reported bool
+}
+
+// NewLockstep creates a lockstep simulation without peers, at tick 0
+func NewLockstep(config LockstepConfig) (Lockstep, error) {
+	if config.InputDelay < 0 {
+		return nil, errors.New("input delay must not be negative")
+	}
+	if config.InputDelay == 0 {
+		config.InputDelay = DefaultLockstepInputDelay
+	}
+	if config.StallTimeout <= 0 {
+		config.StallTimeout = DefaultLockstepStallTimeout
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	return &enetLockstep{
+		config:    config,
+		inputTick: uint32(config.InputDelay),
+		local:     make(map[uint32][]byte),
+		hashes:    make(map[uint32]uint64),
+		peers:     make(map[Peer]*lockstepPeer),
+	}, nil
+}
+
+func (l *enetLockstep) AddPeer(peer Peer) {
+	if _, ok := l.peers[peer]; ok {
+		return
+	}
+	l.peers[peer] = &lockstepPeer{
+		inputs: make(map[uint32][]byte),
+		hashes: make(map[uint32]uint64),
+	}
+	l.order = append(l.order, peer)
+}
+
+func (l *enetLockstep) RemovePeer(peer Peer) {
+	if _, ok := l.peers[peer]; !ok {
+		return
+	}
+	delete(l.peers, peer)
+	for i, p := range l.order {
+		if p == peer {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (l *enetLockstep) Peers() []Peer {
+	return append([]Peer(nil), l.order...)
+}
+
+func (l *enetLockstep) SubmitInput(input []byte) error {
+	tick := l.inputTick
+	l.local[tick] = append([]byte(nil), input...)
+	l.inputTick++
+	return l.broadcast(lockstepInput, tick, input)
+}
+
+func (l *enetLockstep) Advance() (uint32, []LockstepInput, bool) {
+	tick := l.tick
+	inputs := make([]LockstepInput, 0, len(l.order)+1)
+	if tick < uint32(l.config.InputDelay) {
+		inputs = append(inputs, LockstepInput{})
+		for _, peer := range l.order {
+			inputs = append(inputs, LockstepInput{Peer: peer})
+		}
+		return l.advance(tick, inputs)
+	}
+
+	local, ok := l.local[tick]
+	if !ok {
+		// The local input is missing too, the application submits it as it advances.
+		return tick, nil, false
+	}
+	inputs = append(inputs, LockstepInput{Input: local})
+
+	var missing []Peer
+	for _, peer := range l.order {
+		input, ok := l.peers[peer].inputs[tick]
+		if !ok {
+			missing = append(missing, peer)
+			continue
+		}
+		inputs = append(inputs, LockstepInput{Peer: peer, Input: input})
+	}
+	if len(missing) > 0 {
+		l.stalled(tick, missing)
+		return tick, nil, false
+	}
+
+	delete(l.local, tick)
+	for _, state := range l.peers {
+		delete(state.inputs, tick)
+	}
+	return l.advance(tick, inputs)
+}
+
+// advance moves on to the tick after tick.
+func (l *enetLockstep) advance(tick uint32, inputs []LockstepInput) (uint32, []LockstepInput, bool) {
+	l.tick++
+	l.waiting = time.Time{}
+	l.reported = false
+	return tick, inputs, true
+}
+
+// stalled reports a stall once the next tick has been waited on for long enough.
+func (l *enetLockstep) stalled(tick uint32, missing []Peer) {
+	now := l.config.Clock.Now()
+	if l.waiting.IsZero() {
+		l.waiting = now
+		return
+	}
+	waited := now.Sub(l.waiting)
+	if l.reported || waited < l.config.StallTimeout {
+		return
+	}
+	l.reported = true
+	if l.config.OnStall != nil {
+		l.config.OnStall(LockstepStall{Tick: tick, Missing: missing, Duration: waited})
+	}
+}
+
+func (l *enetLockstep) Tick() uint32 {
+	return l.tick
+}
+
+func (l *enetLockstep) ReportHash(tick uint32, hash uint64) error {
+	l.hashes[tick] = hash
+	delete(l.hashes, tick-lockstepHashHistory)
+	for _, peer := range l.order {
+		state := l.peers[peer]
+		delete(state.hashes, tick-lockstepHashHistory)
+		if remote, ok := state.hashes[tick]; ok {
+			delete(state.hashes, tick)
+			l.compare(tick, peer, remote)
+		}
+	}
+	return l.broadcast(lockstepHash, tick, binary.BigEndian.AppendUint64(nil, hash))
+}
+
+// compare passes the hash of peer for tick to OnDesync if it differs from the local
+// one.
+func (l *enetLockstep) compare(tick uint32, peer Peer, remote uint64) {
+	local := l.hashes[tick]
+	if local != remote && l.config.OnDesync != nil {
+		l.config.OnDesync(tick, peer, local, remote)
+	}
+}
+
+func (l *enetLockstep) Handle(event Event) bool {
+	switch event.GetType() {
+	case EventDisconnect, EventDisconnectTimeout:
+		l.RemovePeer(event.GetPeer())
+		return false
+	case EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != l.config.Channel {
+		return false
+	}
+	state, ok := l.peers[event.GetPeer()]
+	if !ok {
+		return false
+	}
+
+	data := event.GetPacket().GetData()
+	if len(data) < lockstepHeaderSize {
+		return true
+	}
+	tick := binary.BigEndian.Uint32(data[1:])
+	payload := data[lockstepHeaderSize:]
+
+	switch data[0] {
+	case lockstepInput:
+		// Inputs of ticks already run or too far ahead are dropped.
+		if tick >= l.tick && tick-l.tick < lockstepMaxAhead {
+			state.inputs[tick] = append([]byte(nil), payload...)
+		}
+	case lockstepHash:
+		if len(payload) < 8 {
+			break
+		}
+		remote := binary.BigEndian.Uint64(payload)
+		if _, ok := l.hashes[tick]; ok {
+			l.compare(tick, event.GetPeer(), remote)
+		} else if ahead := int32(tick - l.tick); ahead > -lockstepHashHistory && ahead < lockstepMaxAhead {
+			state.hashes[tick] = remote
+		}
+	}
+	return true
+}
+
+// broadcast sends a lockstep message to every participant.
+func (l *enetLockstep) broadcast(kind byte, tick uint32, payload []byte) error {
+	if len(l.order) == 0 {
+		return nil
+	}
+	data := make([]byte, lockstepHeaderSize, lockstepHeaderSize+len(payload))
+	data[0] = kind
+	binary.BigEndian.PutUint32(data[1:], tick)
+	data = append(data, payload...)
+
+	var errs []error
+	for _, peer := range l.order {
+		if err := peer.SendBytes(data, l.config.Channel, PacketFlagReliable); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,163 @@
+package enet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// throttleCheckInterval is how often the throttles of peers are checked for logging.
+const throttleCheckInterval = time.Second
+
+// hostLog is what a host remembers to log changes of its peers.
+type hostLog struct {
+	// logger is that of HostConfig.Logger, nil for slog.Default.
+	logger atomic.Pointer[slog.Logger]
+
+	throttled map[enetPeer]struct{}
+	checked   time.Time
+}
+
+// loggerAt returns the logger of host if it logs records of level, or nil. Hosts log
+// with slog.Default until configured otherwise, see HostConfig.Logger, as does a nil
+// host.
+func (host *enetHost) loggerAt(level slog.Level) *slog.Logger {
+	var l *slog.Logger
+	if host != nil {
+		l = host.log.logger.Load()
+	}
+	if l == nil {
+		l = slog.Default()
+	}
+	if !l.Enabled(context.Background(), level) {
+		return nil
+	}
+	return l
+}
+
+// logAttr identifies the host in its log records.
+func (host *enetHost) logAttr() slog.Attr {
+	return slog.String("host", fmt.Sprintf("%p", host))
+}
+
+// peerLogAttrs identify peer in log records. Must be called by the goroutine servicing
+// its host.
+func peerLogAttrs(peer enetPeer) []slog.Attr {
+	attrs := []slog.Attr{slog.Uint64("peer", uint64(peer.GetID()))}
+	if addr := udpAddrOf(peer.address()); addr != nil {
+		attrs = append(attrs, slog.String("address", addr.String()))
+	}
+	return attrs
+}
+
+// logService logs the connection or disconnection Service returned, if any, and checks
+// the throttles of the peers when due.
+func (host *enetHost) logService(event *enetEvent) {
+	l := host.loggerAt(slog.LevelWarn)
+	if l == nil {
+		return
+	}
+	host.logThrottles(l)
+
+	eventType := event.GetType()
+	if eventType != EventConnect && eventType != EventDisconnect && eventType != EventDisconnectTimeout {
+		return
+	}
+	if !l.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return
+	}
+	attrs := append([]slog.Attr{host.logAttr()}, peerLogAttrs(peer)...)
+	attrs = append(attrs, slog.Uint64("data", uint64(event.GetData())))
+
+	switch eventType {
+	case EventConnect:
+		l.LogAttrs(context.Background(), slog.LevelInfo, "peer connected", attrs...)
+	case EventDisconnect:
+		delete(host.log.throttled, peer)
+		l.LogAttrs(context.Background(), slog.LevelInfo, "peer disconnected", append(attrs, slog.String("reason", "disconnect"))...)
+	case EventDisconnectTimeout:
+		delete(host.log.throttled, peer)
+		l.LogAttrs(context.Background(), slog.LevelInfo, "peer disconnected", append(attrs, slog.String("reason", "timeout"))...)
+	}
+}
+
+// logThrottles logs the peers whose throttle engaged or released since the last check.
+func (host *enetHost) logThrottles(l *slog.Logger) {
+	now := time.Now()
+	if now.Sub(host.log.checked) < throttleCheckInterval {
+		return
+	}
+	host.log.checked = now
+
+	for _, peer := range host.connectedPeerList() {
+		throttle := peer.packetThrottle()
+		_, throttled := host.log.throttled[peer]
+		switch {
+		case throttle < 100 && !throttled:
+			if host.log.throttled == nil {
+				host.log.throttled = make(map[enetPeer]struct{})
+			}
+			host.log.throttled[peer] = struct{}{}
+			attrs := append([]slog.Attr{host.logAttr()}, peerLogAttrs(peer)...)
+			l.LogAttrs(context.Background(), slog.LevelWarn, "peer throttling engaged", append(attrs, slog.Float64("throttle", float64(throttle)))...)
+		case throttle >= 100 && throttled:
+			delete(host.log.throttled, peer)
+			attrs := append([]slog.Attr{host.logAttr()}, peerLogAttrs(peer)...)
+			l.LogAttrs(context.Background(), slog.LevelInfo, "peer throttling released", attrs...)
+		}
+	}
+}
+
+// logHost logs the creation or destruction of host.
+func logHost(host *enetHost, msg string) {
+	if l := host.loggerAt(slog.LevelInfo); l != nil {
+		l.LogAttrs(context.Background(), slog.LevelInfo, msg, host.logAttr())
+	}
+}
+
+// logSendError logs a packet that could not be sent to peer.
+func logSendError(peer enetPeer, channel uint8, err error) {
+	host := peer.host()
+	l := host.loggerAt(slog.LevelWarn)
+	if l == nil {
+		return
+	}
+	attrs := peerLogAttrs(peer)
+	if host != nil {
+		attrs = append([]slog.Attr{host.logAttr()}, attrs...)
+	}
+	attrs = append(attrs, slog.Int("channel", int(channel)), slog.String("error", err.Error()))
+	l.LogAttrs(context.Background(), slog.LevelWarn, "send failed", attrs...)
+}
+
+// logIntercepted logs a datagram consumed by an interceptor of host.
+func logIntercepted(host *enetHost, addr rawAddress, size int) {
+	l := host.loggerAt(slog.LevelDebug)
+	if l == nil {
+		return
+	}
+	var from string
+	if udpAddr := udpAddrOf(addr); udpAddr != nil {
+		from = udpAddr.String()
+	}
+	l.LogAttrs(context.Background(), slog.LevelDebug, "datagram intercepted", host.logAttr(), slog.String("address", from), slog.Int("size", size))
+}
+
+// logBanned logs a peer refused as it connected while banned.
+func logBanned(host *enetHost, peer enetPeer, ban Ban) {
+	l := host.loggerAt(slog.LevelInfo)
+	if l == nil {
+		return
+	}
+	attrs := append([]slog.Attr{host.logAttr()}, peerLogAttrs(peer)...)
+	if ban.Reason != "" {
+		attrs = append(attrs, slog.String("reason", ban.Reason))
+	}
+	l.LogAttrs(context.Background(), slog.LevelInfo, "banned peer refused", attrs...)
+}
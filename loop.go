@@ -0,0 +1,347 @@
+package enet
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// EventHandler handles an event produced by a ServiceLoop
+type EventHandler func(event Event)
+
+// Middleware is invoked for every event before it reaches the handler of a ServiceLoop.
+// Calling next passes the event on down the chain, returning without calling it drops
+// the event.
+type Middleware func(event Event, next EventHandler)
+
+// ServiceLoop repeatedly services a host and hands every event to a handler. Packets
+// of receive events are owned by the loop and destroyed once the handler returns, so
+// handlers must not keep references to them.
+type ServiceLoop interface {
+	// Use appends a middleware to the chain. Middlewares run in the order they were
+	// added, the first one added being the outermost.
+	Use(middleware Middleware)
+
+	// Run services the host until the context is cancelled or servicing fails
+	Run(ctx context.Context) error
+}
+
+// ServiceMode selects how a ServiceLoop waits for events
+type ServiceMode int
+
+const (
+	// ServiceModeWait blocks in Host.Service for up to the configured timeout until an
+	// event arrives
+	ServiceModeWait ServiceMode = iota
+
+	// ServiceModeBusyPoll services the host without ever blocking, trading a fully used
+	// CPU core for the lowest possible latency
+	ServiceModeBusyPoll
+
+	// ServiceModeTick services the host a fixed number of times per second, handling all
+	// pending events at once each time
+	ServiceModeTick
+)
+
+// ServiceLoopConfig configures a ServiceLoop
+type ServiceLoopConfig struct {
+	// Mode selects how the loop waits for events
+	Mode ServiceMode
+
+	// Timeout in milliseconds to wait for events on every Host.Service call in
+	// ServiceModeWait. This is also the longest time it takes for the loop to notice a
+	// cancelled context.
+	Timeout uint32
+
+	// TickRate is the number of times per second the host is serviced in
+	// ServiceModeTick
+	TickRate int
+
+	// Clock runs the host and the loop off a clock other than the one of the system if
+	// set, see SetHostClock. On a VirtualClock the loop services the host once a tick
+	// or the timeout elapsed on the clock, so advancing it drives the loop; busy
+	// polling spins regardless.
+	Clock Clock
+
+	// LockOSThread locks the goroutine calling Run to its OS thread for as long as the
+	// loop runs. This avoids the cost and jitter of cgo calls migrating between
+	// threads under load.
+	LockOSThread bool
+
+	// OnPanic is called when the handler chain panics while handling an event. The
+	// panic is recovered and the loop keeps running. If neither OnPanic nor
+	// DisconnectOnPanic are set, panics are not recovered.
+	OnPanic PanicHandler
+
+	// DisconnectOnPanic disconnects the peer whose event caused a panic
+	DisconnectOnPanic bool
+
+	// Workers is the number of goroutines events are handled on. If 0, events are
+	// handled on the goroutine calling Run. Events of the same peer are always handled
+	// by the same worker, so they are seen in order. Handlers running on workers must
+	// not call host or peer methods directly, as enet is not thread safe; use
+	// Peer.SendAsync or a SafeHost instead.
+	Workers int
+}
+
+// workerQueueSize is the number of events that can be waiting for each worker before
+// the service loop blocks.
+const workerQueueSize = 256
+
+type enetServiceLoop struct {
+	host    Host
+	handler EventHandler
+	config  ServiceLoopConfig
+
+	lock        sync.RWMutex
+	middlewares []Middleware
+	chain       EventHandler
+
+	// deferred holds calls workers need to be made on the service goroutine.
+	deferred chan func()
+
+	// workers are the queues of the worker goroutines, while running.
+	workers []chan Event
+	wg      sync.WaitGroup
+}
+
+// NewServiceLoop creates a managed loop servicing host and passing events to handler
+func NewServiceLoop(host Host, handler EventHandler, config ServiceLoopConfig) ServiceLoop {
+	return &enetServiceLoop{
+		host:     host,
+		handler:  handler,
+		config:   config,
+		chain:    handler,
+		deferred: make(chan func(), workerQueueSize),
+	}
+}
+
+func (loop *enetServiceLoop) Use(middleware Middleware) {
+	loop.lock.Lock()
+	defer loop.lock.Unlock()
+
+	loop.middlewares = append(loop.middlewares, middleware)
+
+	// Rebuild the chain from the inside out so the first middleware runs first.
+	chain := loop.handler
+	for i := len(loop.middlewares) - 1; i >= 0; i-- {
+		middleware, next := loop.middlewares[i], chain
+		chain = func(event Event) {
+			middleware(event, next)
+		}
+	}
+	loop.chain = chain
+}
+
+func (loop *enetServiceLoop) Run(ctx context.Context) error {
+	if loop.config.Mode == ServiceModeTick && loop.config.TickRate <= 0 {
+		return errors.New("tick rate must be positive")
+	}
+
+	clock := loop.config.Clock
+	if clock != nil {
+		if err := SetHostClock(loop.host, clock); err != nil {
+			return err
+		}
+	}
+
+	if loop.config.LockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	if loop.config.Workers > 0 {
+		loop.startWorkers()
+		defer loop.stopWorkers()
+	}
+
+	switch loop.config.Mode {
+	case ServiceModeBusyPoll:
+		for ctx.Err() == nil {
+			handled, err := loop.serviceOnce(0)
+			if err != nil {
+				return err
+			}
+			if !handled {
+				// Let the runtime preempt us, we might be spinning for a long time.
+				runtime.Gosched()
+			}
+		}
+
+	case ServiceModeTick:
+		interval := time.Second / time.Duration(loop.config.TickRate)
+		var (
+			ticks      <-chan time.Time
+			clockTicks <-chan struct{}
+		)
+		if clock != nil {
+			ticker := newClockTicker(clock, interval)
+			defer ticker.Stop()
+			clockTicks = ticker.C
+		} else {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		for {
+			for {
+				handled, err := loop.serviceOnce(0)
+				if err != nil {
+					return err
+				}
+				if !handled {
+					break
+				}
+			}
+
+			select {
+			case <-ticks:
+			case <-clockTicks:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+	default:
+		for ctx.Err() == nil {
+			handled, err := loop.serviceOnce(loop.config.Timeout)
+			if err != nil {
+				return err
+			}
+			if !handled && clock != nil && clock != SystemClock {
+				// Service does not wait on a virtual clock, so wait for it to move.
+				waitClock(ctx, clock, time.Duration(loop.config.Timeout)*time.Millisecond)
+			}
+		}
+	}
+	return nil
+}
+
+// waitClock waits until d elapsed on clock or ctx is cancelled.
+func waitClock(ctx context.Context, clock Clock, d time.Duration) {
+	elapsed := make(chan struct{})
+	timer := clock.AfterFunc(d, func() { close(elapsed) })
+	defer timer.Stop()
+
+	select {
+	case <-elapsed:
+	case <-ctx.Done():
+	}
+}
+
+// serviceOnce services the host and handles the event, if any. Returns whether there
+// was an event.
+func (loop *enetServiceLoop) serviceOnce(timeout uint32) (bool, error) {
+	loop.runDeferred()
+
+	event := NewEvent()
+	ret := loop.host.ServiceV2(event, timeout)
+	if ret < 0 {
+		return false, errors.New("servicing host failed")
+	}
+	if ret == 0 {
+		return false, nil
+	}
+
+	if loop.workers == nil {
+		loop.handle(event, false)
+		return true, nil
+	}
+
+	queue := loop.workers[event.GetPeer().GetID()%uint32(len(loop.workers))]
+	for {
+		select {
+		case queue <- event:
+			return true, nil
+		case fn := <-loop.deferred:
+			// The worker might be waiting for us to make room.
+			fn()
+		}
+	}
+}
+
+func (loop *enetServiceLoop) startWorkers() {
+	loop.workers = make([]chan Event, loop.config.Workers)
+	for i := range loop.workers {
+		queue := make(chan Event, workerQueueSize)
+		loop.workers[i] = queue
+
+		loop.wg.Add(1)
+		go func() {
+			defer loop.wg.Done()
+			for event := range queue {
+				loop.handle(event, true)
+			}
+		}()
+	}
+}
+
+func (loop *enetServiceLoop) stopWorkers() {
+	for _, queue := range loop.workers {
+		close(queue)
+	}
+	loop.workers = nil
+
+	// Keep serving deferred calls until the workers are done with their queues.
+	done := make(chan struct{})
+	go func() {
+		loop.wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			loop.runDeferred()
+			return
+		case fn := <-loop.deferred:
+			fn()
+		}
+	}
+}
+
+func (loop *enetServiceLoop) runDeferred() {
+	for {
+		select {
+		case fn := <-loop.deferred:
+			fn()
+		default:
+			return
+		}
+	}
+}
+
+func (loop *enetServiceLoop) handle(event Event, onWorker bool) {
+	if event.GetType() == EventReceive {
+		defer event.GetPacket().Destroy()
+	}
+
+	loop.lock.RLock()
+	chain := loop.chain
+	loop.lock.RUnlock()
+
+	if loop.config.OnPanic == nil && !loop.config.DisconnectOnPanic {
+		chain(event)
+		return
+	}
+
+	err := callRecover(event.GetPeer(), func() {
+		chain(event)
+	})
+	if err == nil {
+		return
+	}
+
+	if loop.config.DisconnectOnPanic {
+		if onWorker {
+			loop.deferred <- func() { err.Peer.Disconnect(0) }
+		} else {
+			err.Peer.Disconnect(0)
+		}
+	}
+	if loop.config.OnPanic != nil {
+		loop.config.OnPanic(err)
+	}
+}
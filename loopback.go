@@ -0,0 +1,575 @@
+package enet
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// loopbackChannelLimit is the most channels a loopback connection can have, like enet.
+const loopbackChannelLimit = 255
+
+// loopbackPair is the state shared by the two hosts of a pair. A single lock guards
+// both, so peers can be handed events by the other side from any goroutine.
+type loopbackPair struct {
+	lock    sync.Mutex
+	latency time.Duration
+	hosts   [2]*loopbackHost
+}
+
+// NewLoopbackPair creates two hosts connected in memory, for testing code using hosts
+// without sockets. Connecting either of them to any address reaches the other one, and
+// every event arrives latency after the action causing it, in order. Nothing is ever
+// lost, and timeouts only happen when a host is destroyed without disconnecting its
+// peers first.
+func NewLoopbackPair(latency time.Duration) (Host, Host) {
+	pair := &loopbackPair{latency: latency}
+	for i := range pair.hosts {
+		pair.hosts[i] = &loopbackHost{
+			pair:    pair,
+			address: NewAddress("127.0.0.1", uint16(i+1)),
+			notify:  make(chan struct{}, 1),
+		}
+	}
+	return pair.hosts[0], pair.hosts[1]
+}
+
+type loopbackDelivery struct {
+	due   time.Time
+	event *loopbackEvent
+}
+
+type loopbackHost struct {
+	pair      *loopbackPair
+	address   Address
+	destroyed bool
+
+	// peers are indexed by ID, slots are reused once their peer is gone.
+	peers  []*loopbackPeer
+	queue  []loopbackDelivery
+	notify chan struct{}
+
+	bytesSent       uint32
+	bytesReceived   uint32
+	packetsSent     uint32
+	packetsReceived uint32
+	stats           hostStats
+}
+
+// other returns the host on the other side of the pair.
+func (host *loopbackHost) other() *loopbackHost {
+	if host.pair.hosts[0] == host {
+		return host.pair.hosts[1]
+	}
+	return host.pair.hosts[0]
+}
+
+// deliver queues event for Service after delay. Must be called with the lock held.
+func (host *loopbackHost) deliver(event *loopbackEvent, delay time.Duration) {
+	if host.destroyed {
+		return
+	}
+	delivery := loopbackDelivery{due: time.Now().Add(delay), event: event}
+
+	// Keep the queue sorted by due time, after the deliveries due at the same time.
+	i := len(host.queue)
+	for i > 0 && host.queue[i-1].due.After(delivery.due) {
+		i--
+	}
+	host.queue = append(host.queue, loopbackDelivery{})
+	copy(host.queue[i+1:], host.queue[i:])
+	host.queue[i] = delivery
+
+	select {
+	case host.notify <- struct{}{}:
+	default:
+	}
+}
+
+// addPeer puts peer in the first free slot. Must be called with the lock held.
+func (host *loopbackHost) addPeer(peer *loopbackPeer) {
+	peer.host = host
+	for i, p := range host.peers {
+		if p == nil {
+			peer.id = uint32(i)
+			host.peers[i] = peer
+			return
+		}
+	}
+	peer.id = uint32(len(host.peers))
+	host.peers = append(host.peers, peer)
+}
+
+// removePeer frees the slot of peer. Must be called with the lock held.
+func (host *loopbackHost) removePeer(peer *loopbackPeer) {
+	peer.state = loopbackDisconnected
+	if int(peer.id) < len(host.peers) && host.peers[peer.id] == peer {
+		host.peers[peer.id] = nil
+	}
+}
+
+// next takes the next event that is due and still applies out of the queue. Returns
+// when the one after it is due if there is none yet. Must be called with the lock
+// held.
+func (host *loopbackHost) next(now time.Time) (*loopbackEvent, time.Time) {
+	for len(host.queue) > 0 {
+		if host.queue[0].due.After(now) {
+			return nil, host.queue[0].due
+		}
+		event := host.queue[0].event
+		host.queue[0] = loopbackDelivery{}
+		host.queue = host.queue[1:]
+
+		peer := event.peer
+		switch event.eventType {
+		case EventConnect:
+			if peer.state != loopbackConnecting {
+				continue
+			}
+			peer.state = loopbackConnected
+		case EventReceive:
+			if peer.state != loopbackConnected {
+				continue
+			}
+			host.bytesReceived += uint32(len(event.packet.data))
+			host.packetsReceived++
+			peer.bytesReceived += uint64(len(event.packet.data))
+		case EventDisconnect, EventDisconnectTimeout:
+			if peer.state == loopbackDisconnected {
+				continue
+			}
+			host.removePeer(peer)
+		}
+		return event, time.Time{}
+	}
+	return nil, time.Time{}
+}
+
+func (host *loopbackHost) Destroy() error {
+	host.pair.lock.Lock()
+	defer host.pair.lock.Unlock()
+
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	// The other side only notices once it stops hearing from the peers.
+	for _, peer := range host.peers {
+		if peer != nil && peer.state != loopbackDisconnected {
+			peer.remote.host.deliver(&loopbackEvent{eventType: EventDisconnectTimeout, peer: peer.remote}, host.pair.latency)
+			host.removePeer(peer)
+		}
+	}
+	host.destroyed = true
+	host.queue = nil
+	return nil
+}
+
+func (host *loopbackHost) Service(timeout uint32) Event {
+	event := &enetEvent{}
+	host.ServiceV2(event, timeout)
+	return event
+}
+
+func (host *loopbackHost) ServiceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.ServiceV2, events, timeout)
+}
+
+func (host *loopbackHost) ServiceV2(event *enetEvent, timeout uint32) int {
+	*event = enetEvent{}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+
+	for {
+		host.pair.lock.Lock()
+		if host.destroyed {
+			host.pair.lock.Unlock()
+			event.goEvent = &loopbackEvent{}
+			return -1
+		}
+		now := time.Now()
+		next, due := host.next(now)
+		if next != nil {
+			host.stats.countEvent(next)
+		}
+		host.updateStats()
+		host.pair.lock.Unlock()
+
+		if next != nil {
+			next.timestamp = now
+			event.goEvent = next
+			event.timestamp = now
+			return 1
+		}
+		if !now.Before(deadline) {
+			event.goEvent = &loopbackEvent{}
+			return 0
+		}
+
+		wait := deadline.Sub(now)
+		if !due.IsZero() && due.Sub(now) < wait {
+			wait = due.Sub(now)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-host.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Connect connects to the other host of the pair, wherever addr points to.
+func (host *loopbackHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	host.pair.lock.Lock()
+	defer host.pair.lock.Unlock()
+
+	if host.destroyed {
+		return nil, errHostDestroyed
+	}
+	other := host.other()
+	if other.destroyed {
+		return nil, errors.New("couldn't connect to foreign peer")
+	}
+	channelCount = min(max(channelCount, 1), loopbackChannelLimit)
+
+	local := &loopbackPeer{state: loopbackConnecting, channelCount: channelCount}
+	remote := &loopbackPeer{state: loopbackConnecting, channelCount: channelCount}
+	local.remote, remote.remote = remote, local
+	host.addPeer(local)
+	other.addPeer(remote)
+
+	// The connect takes a round trip to be confirmed.
+	latency := host.pair.latency
+	other.deliver(&loopbackEvent{eventType: EventConnect, peer: remote, data: data}, latency)
+	host.deliver(&loopbackEvent{eventType: EventConnect, peer: local}, 2*latency)
+	return local, nil
+}
+
+func (host *loopbackHost) BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error {
+	host.pair.lock.Lock()
+	defer host.pair.lock.Unlock()
+
+	if host.destroyed {
+		return errHostDestroyed
+	}
+	for _, peer := range host.peers {
+		if peer != nil && peer.state == loopbackConnected {
+			peer.send(data, channel, flags)
+		}
+	}
+	return nil
+}
+
+func (host *loopbackHost) BroadcastPacket(packet Packet, channel uint8) error {
+	if err := host.BroadcastBytes(packet.GetData(), channel, packet.GetFlags()); err != nil {
+		return err
+	}
+	return packet.Destroy()
+}
+
+func (host *loopbackHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
+}
+
+// updateStats copies the counters of the host to the stats. Must be called with the
+// lock held.
+func (host *loopbackHost) updateStats() {
+	var connected uint32
+	for _, peer := range host.peers {
+		if peer != nil && peer.state == loopbackConnected {
+			connected++
+		}
+	}
+	host.stats.update(host.bytesSent, host.bytesReceived, host.packetsSent, host.packetsReceived, connected)
+}
+
+// counter reads a counter of the host under the lock.
+func (host *loopbackHost) counter(counter *uint32) uint32 {
+	host.pair.lock.Lock()
+	defer host.pair.lock.Unlock()
+	return *counter
+}
+
+// reset clears a counter of the host under the lock.
+func (host *loopbackHost) reset(counter *uint32) {
+	host.pair.lock.Lock()
+	defer host.pair.lock.Unlock()
+	*counter = 0
+	host.updateStats()
+}
+
+func (host *loopbackHost) GetBytesSent() uint32       { return host.counter(&host.bytesSent) }
+func (host *loopbackHost) GetBytesReceived() uint32   { return host.counter(&host.bytesReceived) }
+func (host *loopbackHost) GetPacketsSent() uint32     { return host.counter(&host.packetsSent) }
+func (host *loopbackHost) GetPacketsReceived() uint32 { return host.counter(&host.packetsReceived) }
+func (host *loopbackHost) ResetBytesSent()            { host.reset(&host.bytesSent) }
+func (host *loopbackHost) ResetBytesReceived()        { host.reset(&host.bytesReceived) }
+func (host *loopbackHost) ResetPacketsSent()          { host.reset(&host.packetsSent) }
+func (host *loopbackHost) ResetPacketsReceived()      { host.reset(&host.packetsReceived) }
+
+func (host *loopbackHost) GetStats() HostStats {
+	return host.stats.snapshot()
+}
+
+// ApplyConfig only validates config, the peers of a loopback pair are not limited.
+func (host *loopbackHost) ApplyConfig(config HostConfig) error {
+	_, err := config.validate()
+	return err
+}
+
+type loopbackEvent struct {
+	eventType EventType
+	peer      *loopbackPeer
+	channelID uint8
+	data      uint32
+	packet    *loopbackPacket
+	timestamp time.Time
+}
+
+func (event *loopbackEvent) GetType() EventType      { return event.eventType }
+func (event *loopbackEvent) GetChannelID() uint8     { return event.channelID }
+func (event *loopbackEvent) GetData() uint32         { return event.data }
+func (event *loopbackEvent) GetTimestamp() time.Time { return event.timestamp }
+
+func (event *loopbackEvent) GetPeer() Peer {
+	if event.peer == nil {
+		return nil
+	}
+	return event.peer
+}
+
+func (event *loopbackEvent) GetPacket() Packet {
+	if event.packet == nil {
+		return nil
+	}
+	return event.packet
+}
+
+func (event *loopbackEvent) GetPacketDataUnsafe() []byte {
+	if event.packet == nil {
+		return nil
+	}
+	return event.packet.data
+}
+
+func (event *loopbackEvent) ReadPayload(buf []byte) (int, error) {
+	if event.packet == nil {
+		return 0, errNoPayload
+	}
+	return readPayload(event.packet.data, buf)
+}
+
+// loopbackPacket is a packet received through a loopback pair. Its data lives in Go
+// memory, so destroying it does nothing.
+type loopbackPacket struct {
+	data  []byte
+	flags PacketFlags
+}
+
+func (packet *loopbackPacket) Destroy() error {
+	return nil
+}
+
+func (packet *loopbackPacket) GetData() []byte {
+	return append([]byte(nil), packet.data...)
+}
+
+func (packet *loopbackPacket) GetFlags() PacketFlags {
+	return packet.flags
+}
+
+const (
+	loopbackDisconnected = iota
+	loopbackConnecting
+	loopbackConnected
+	loopbackDisconnecting
+)
+
+// loopbackPeer is one end of a loopback connection. It is safe for concurrent use.
+type loopbackPeer struct {
+	PeerTags
+
+	host         *loopbackHost
+	remote       *loopbackPeer
+	id           uint32
+	state        int
+	channelCount int
+	data         []byte
+
+	bytesSent     uint64
+	packetsSent   uint64
+	bytesReceived uint64
+}
+
+// send delivers a copy of data to the remote side. Must be called with the lock held.
+func (peer *loopbackPeer) send(data []byte, channel uint8, flags PacketFlags) {
+	peer.remote.host.deliver(&loopbackEvent{
+		eventType: EventReceive,
+		peer:      peer.remote,
+		channelID: channel,
+		packet:    &loopbackPacket{data: append([]byte(nil), data...), flags: flags},
+	}, peer.host.pair.latency)
+
+	peer.host.bytesSent += uint32(len(data))
+	peer.host.packetsSent++
+	peer.bytesSent += uint64(len(data))
+	peer.packetsSent++
+}
+
+func (peer *loopbackPeer) GetAddress() Address {
+	return peer.remote.host.address
+}
+
+func (peer *loopbackPeer) GetID() uint32 {
+	return peer.id
+}
+
+func (peer *loopbackPeer) Identity() []byte {
+	return nil
+}
+
+func (peer *loopbackPeer) PublicKey() ed25519.PublicKey {
+	return nil
+}
+
+// Disconnect tells the other side, and like enet confirms with an event of its own
+// with the data set to 0 after a round trip.
+func (peer *loopbackPeer) Disconnect(data uint32) {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+
+	switch peer.state {
+	case loopbackConnected:
+		latency := peer.host.pair.latency
+		peer.state = loopbackDisconnecting
+		peer.remote.host.deliver(&loopbackEvent{eventType: EventDisconnect, peer: peer.remote, data: data}, latency)
+		peer.host.deliver(&loopbackEvent{eventType: EventDisconnect, peer: peer}, 2*latency)
+	case loopbackConnecting:
+		peer.disconnectNow(data)
+	}
+}
+
+func (peer *loopbackPeer) DisconnectNow(data uint32) {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+	peer.disconnectNow(data)
+}
+
+// disconnectNow tells the other side and drops the peer without an event. Must be
+// called with the lock held.
+func (peer *loopbackPeer) disconnectNow(data uint32) {
+	if peer.state == loopbackDisconnected {
+		return
+	}
+	peer.remote.host.deliver(&loopbackEvent{eventType: EventDisconnect, peer: peer.remote, data: data}, peer.host.pair.latency)
+	peer.host.removePeer(peer)
+}
+
+// DisconnectLater is the same as Disconnect, loopback packets are never waiting to be
+// sent.
+func (peer *loopbackPeer) DisconnectLater(data uint32) {
+	peer.Disconnect(data)
+}
+
+// SetTimeout does nothing, loopback connections never time out.
+func (peer *loopbackPeer) SetTimeout(limit uint32, min uint32, max uint32) {}
+
+// PingInterval does nothing, loopback connections need no keep-alive.
+func (peer *loopbackPeer) PingInterval(interval uint32) {}
+
+func (peer *loopbackPeer) SendBytes(data []byte, channel uint8, flags PacketFlags) error {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+
+	if peer.state != loopbackConnected {
+		return errors.New("peer is not connected")
+	}
+	if int(channel) >= peer.channelCount {
+		return errors.New("unable to send packet")
+	}
+	peer.send(data, channel, flags)
+	return nil
+}
+
+func (peer *loopbackPeer) SendString(str string, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
+
+// SendPacket sends the data of the packet and destroys it, as enet would once it has
+// been sent.
+func (peer *loopbackPeer) SendPacket(packet Packet, channel uint8) error {
+	if err := peer.SendBytes(packet.GetData(), channel, packet.GetFlags()); err != nil {
+		return err
+	}
+	return packet.Destroy()
+}
+
+// SendAsync sends right away, loopback peers are safe for concurrent use anyway.
+func (peer *loopbackPeer) SendAsync(data []byte, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes(data, channel, flags)
+}
+
+func (peer *loopbackPeer) SetData(data []byte) {
+	if len(data) > math.MaxUint32 {
+		panic(fmt.Sprintf("maximum peer data length is uint32 (%d)", math.MaxUint32))
+	}
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+
+	if data == nil {
+		peer.data = nil
+		return
+	}
+	peer.data = append([]byte{}, data...)
+}
+
+func (peer *loopbackPeer) GetData() []byte {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+
+	if peer.data == nil {
+		return nil
+	}
+	return append([]byte{}, peer.data...)
+}
+
+func (peer *loopbackPeer) GetBytesSent() uint64 {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+	return peer.bytesSent
+}
+
+func (peer *loopbackPeer) GetBytesReceived() uint64 {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+	return peer.bytesReceived
+}
+
+func (peer *loopbackPeer) GetPacketsSent() uint64 {
+	peer.host.pair.lock.Lock()
+	defer peer.host.pair.lock.Unlock()
+	return peer.packetsSent
+}
+
+// GetPacketsLost always returns 0, loopback connections never lose packets.
+func (peer *loopbackPeer) GetPacketsLost() uint64 {
+	return 0
+}
+
+// EstimatedBandwidth always returns math.MaxUint32, loopback connections are only
+// limited by the goroutines servicing them.
+func (peer *loopbackPeer) EstimatedBandwidth() uint32 {
+	return math.MaxUint32
+}
+
+// SimulateConditions fails, loopback connections have no network to degrade. Use a
+// NetworkSimulator instead.
+func (peer *loopbackPeer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	return errors.New("conditions cannot be simulated on loopback connections")
+}
+
+// ClockOffset returns 0, both ends of loopback connections share the clock of the
+// process.
+func (peer *loopbackPeer) ClockOffset() time.Duration { return 0 }
@@ -0,0 +1,284 @@
+// Package matchmaking groups waiting players into matches. Players queue tickets with
+// attributes such as their region or skill, a match function groups waiting tickets
+// into matches, and every match gets a room of its own its players join and are told
+// about:
+//
+//	manager := rooms.NewManager(rooms.Config{})
+//	queue, err := matchmaking.NewQueue(matchmaking.Config{
+//		Rooms:   manager,
+//		Match:   matchmaking.ByAttribute("region", 4),
+//		Channel: 3,
+//	})
+//	queue.Enqueue(peer, map[string]string{"region": "eu"})
+//
+//	// On the goroutine servicing the host, every tick:
+//	queue.Process()
+//
+//	// And for every event:
+//	queue.Handle(event)
+package matchmaking
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/rooms"
+)
+
+// Ticket is a player waiting for a match
+type Ticket struct {
+	Peer       enet.Peer
+	Attributes map[string]string
+
+	// Enqueued is the time the ticket was queued
+	Enqueued time.Time
+}
+
+// MatchFunc groups waiting tickets, oldest first, into matches. A ticket may be part of
+// one match at most, and those not part of any keep waiting.
+type MatchFunc func(tickets []*Ticket) [][]*Ticket
+
+// BySize matches the oldest tickets in groups of size
+func BySize(size int) MatchFunc {
+	return func(tickets []*Ticket) [][]*Ticket {
+		var matches [][]*Ticket
+		for len(tickets) >= size {
+			matches = append(matches, tickets[:size])
+			tickets = tickets[size:]
+		}
+		return matches
+	}
+}
+
+// ByAttribute matches the oldest tickets in groups of size among those with the same
+// value of the attribute key, such as a region or game mode
+func ByAttribute(key string, size int) MatchFunc {
+	return func(tickets []*Ticket) [][]*Ticket {
+		groups := make(map[string][]*Ticket)
+		var matches [][]*Ticket
+		for _, ticket := range tickets {
+			value := ticket.Attributes[key]
+			group := append(groups[value], ticket)
+			if len(group) == size {
+				matches = append(matches, group)
+				group = nil
+			}
+			groups[value] = group
+		}
+		return matches
+	}
+}
+
+// Match is a group of players matched together
+type Match struct {
+	Room    *rooms.Room
+	Tickets []*Ticket
+}
+
+// Config configures a Queue
+type Config struct {
+	// Rooms creates the rooms of matches
+	Rooms *rooms.Manager
+
+	// Match groups tickets into matches
+	Match MatchFunc
+
+	// RoomOptions returns the options of the room of a match. Defaults to a room as
+	// large as the match.
+	RoomOptions func(tickets []*Ticket) rooms.Options
+
+	// Channel is the channel players are told about their match on, reliably
+	Channel uint8
+
+	// Notification returns the message telling a player about their match. Defaults
+	// to the ID of the room.
+	Notification func(match *Match, ticket *Ticket) []byte
+
+	// OnMatch is called once the players of a match joined its room and were told
+	// about it. It may be nil.
+	OnMatch func(match *Match)
+
+	// Timeout is how long a ticket may wait before it is dropped, forever if 0
+	Timeout time.Duration
+
+	// OnTimeout is called for tickets dropped after waiting for too long. It may be
+	// nil.
+	OnTimeout func(ticket *Ticket)
+
+	// Clock tells the time tickets are queued and time out at. Defaults to the
+	// SystemClock.
+	Clock enet.Clock
+}
+
+// Queue is a matchmaking queue, see the package documentation. Tickets may be queued
+// and cancelled from any goroutine.
+type Queue struct {
+	config Config
+
+	lock    sync.Mutex
+	tickets []*Ticket
+	peers   map[enet.Peer]*Ticket
+}
+
+// NewQueue creates an empty queue
+func NewQueue(config Config) (*Queue, error) {
+	if config.Rooms == nil {
+		return nil, errors.New("no room manager")
+	}
+	if config.Match == nil {
+		return nil, errors.New("no match function")
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Queue{
+		config: config,
+		peers:  make(map[enet.Peer]*Ticket),
+	}, nil
+}
+
+// Enqueue queues a ticket for peer, replacing the ticket it already had if any
+func (q *Queue) Enqueue(peer enet.Peer, attributes map[string]string) *Ticket {
+	ticket := &Ticket{
+		Peer:       peer,
+		Attributes: maps.Clone(attributes),
+		Enqueued:   q.config.Clock.Now(),
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.remove(peer)
+	q.tickets = append(q.tickets, ticket)
+	q.peers[peer] = ticket
+	return ticket
+}
+
+// Cancel drops the ticket of peer. Returns false if it had none.
+func (q *Queue) Cancel(peer enet.Peer) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.remove(peer)
+}
+
+// remove drops the ticket of peer. Must be called with the lock held.
+func (q *Queue) remove(peer enet.Peer) bool {
+	ticket, ok := q.peers[peer]
+	if !ok {
+		return false
+	}
+	delete(q.peers, peer)
+	q.tickets = slices.DeleteFunc(q.tickets, func(t *Ticket) bool { return t == ticket })
+	return true
+}
+
+// Len returns the number of tickets waiting
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.tickets)
+}
+
+// Tickets returns the tickets waiting, oldest first
+func (q *Queue) Tickets() []*Ticket {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return slices.Clone(q.tickets)
+}
+
+// Process drops the tickets that timed out, then matches the others, creating a room
+// for every match found, making its players join it and telling them about it. Players
+// failing to join their room are left out of the match. It must be called by the
+// goroutine servicing the host of the players, typically once per tick.
+func (q *Queue) Process() []*Match {
+	now := q.config.Clock.Now()
+
+	q.lock.Lock()
+	var expired []*Ticket
+	if q.config.Timeout > 0 {
+		for _, ticket := range q.tickets {
+			if now.Sub(ticket.Enqueued) >= q.config.Timeout {
+				expired = append(expired, ticket)
+			}
+		}
+		for _, ticket := range expired {
+			q.remove(ticket.Peer)
+		}
+	}
+
+	var found [][]*Ticket
+	for _, tickets := range q.config.Match(slices.Clone(q.tickets)) {
+		// Tickets the match function returned twice or made up are skipped.
+		tickets = slices.DeleteFunc(slices.Clone(tickets), func(ticket *Ticket) bool {
+			return q.peers[ticket.Peer] != ticket
+		})
+		for _, ticket := range tickets {
+			q.remove(ticket.Peer)
+		}
+		if len(tickets) > 0 {
+			found = append(found, tickets)
+		}
+	}
+	q.lock.Unlock()
+
+	if q.config.OnTimeout != nil {
+		for _, ticket := range expired {
+			q.config.OnTimeout(ticket)
+		}
+	}
+
+	matches := make([]*Match, 0, len(found))
+	for _, tickets := range found {
+		if match := q.start(tickets); match != nil {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// start creates the room of a match and makes its players join it.
+func (q *Queue) start(tickets []*Ticket) *Match {
+	options := rooms.Options{MaxMembers: len(tickets)}
+	if q.config.RoomOptions != nil {
+		options = q.config.RoomOptions(tickets)
+	}
+	room, err := q.config.Rooms.Create("", options)
+	if err != nil {
+		return nil
+	}
+
+	match := &Match{Room: room}
+	for _, ticket := range tickets {
+		if _, err := q.config.Rooms.Join(ticket.Peer, room.ID()); err == nil {
+			match.Tickets = append(match.Tickets, ticket)
+		}
+	}
+	if len(match.Tickets) == 0 {
+		q.config.Rooms.Close(room.ID())
+		return nil
+	}
+
+	for _, ticket := range match.Tickets {
+		message := []byte(room.ID())
+		if q.config.Notification != nil {
+			message = q.config.Notification(match, ticket)
+		}
+		ticket.Peer.SendBytes(message, q.config.Channel, enet.PacketFlagReliable)
+	}
+	if q.config.OnMatch != nil {
+		q.config.OnMatch(match)
+	}
+	return match
+}
+
+// Handle drops the tickets of peers once they disconnect. It can be called for every
+// event serviced.
+func (q *Queue) Handle(event enet.Event) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		q.Cancel(event.GetPeer())
+	}
+}
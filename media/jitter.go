@@ -0,0 +1,177 @@
+package media
+
+import (
+	"encoding/binary"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// maxAhead bounds how far from the last frame played out frames are taken to belong to
+// the same stream, beyond which the stream is restarted from them.
+const maxAhead = 256
+
+// Stats are the statistics of the frames received from a peer
+type Stats struct {
+	// Played is the number of frames played out
+	Played uint64
+
+	// Lost is the number of frames played out as lost
+	Lost uint64
+
+	// Late is the number of frames dropped as they arrived after they were due
+	Late uint64
+
+	// Duplicate is the number of frames dropped as they arrived more than once
+	Duplicate uint64
+}
+
+// stream is the jitter buffer of a peer.
+type stream struct {
+	frames map[uint32][]byte
+
+	// playing is set while frames are played out, next being the sequence number of
+	// the frame due at due. last is the sequence number of the last frame played out,
+	// once started.
+	playing bool
+	started bool
+	next    uint32
+	last    uint32
+	due     time.Time
+
+	stats Stats
+}
+
+// Receiver buffers the frames of peers and plays them out, see the package
+// documentation. It is not safe for concurrent use, and must be used by the goroutine
+// servicing the host of its peers.
+type Receiver struct {
+	config  Config
+	streams map[enet.Peer]*stream
+}
+
+// NewReceiver creates a receiver
+func NewReceiver(config Config) *Receiver {
+	if config.FrameDuration <= 0 {
+		config.FrameDuration = DefaultFrameDuration
+	}
+	if config.Delay < 0 {
+		config.Delay = 0
+	} else if config.Delay == 0 {
+		config.Delay = DefaultDelay
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Receiver{config: config, streams: make(map[enet.Peer]*stream)}
+}
+
+// Handle buffers the frames received on the media channel, returning true if the event
+// carried one, and forgets peers once they disconnect. The packet of the event is left
+// to the caller.
+func (r *Receiver) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		delete(r.streams, event.GetPeer())
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != r.config.Channel {
+		return false
+	}
+	r.Receive(event.GetPeer(), event.GetPacket().GetData())
+	return true
+}
+
+// Receive buffers a frame of peer as Handle does, for applications receiving them
+// themselves. Frames arriving after they were due, or more than once, are dropped.
+func (r *Receiver) Receive(peer enet.Peer, data []byte) error {
+	if len(data) < headerSize {
+		return errMalformed
+	}
+	seq := binary.BigEndian.Uint32(data)
+
+	s, ok := r.streams[peer]
+	if !ok {
+		s = &stream{frames: make(map[uint32][]byte)}
+		r.streams[peer] = s
+	}
+	if s.started {
+		switch ahead := int32(seq - s.last); {
+		case ahead <= 0 && ahead > -maxAhead:
+			s.stats.Late++
+			return nil
+		case ahead <= -maxAhead || ahead > maxAhead:
+			// The peer restarted or was gone for long, start over from this frame.
+			clear(s.frames)
+			s.playing, s.started = false, false
+		}
+	}
+	if _, ok := s.frames[seq]; ok {
+		s.stats.Duplicate++
+		return nil
+	}
+	s.frames[seq] = append([]byte(nil), data[headerSize:]...)
+
+	if !s.playing {
+		// Buffering: play out from the oldest frame, a delay after the first one.
+		if len(s.frames) == 1 {
+			s.due = r.config.Clock.Now().Add(r.config.Delay)
+			s.next = seq
+		} else if int32(seq-s.next) < 0 {
+			s.next = seq
+		}
+	}
+	return nil
+}
+
+// Process plays out the frames due, calling OnPlayout for each in order. Frames
+// missing as they are due are played out as lost, as long as later ones are
+// buffered; once the buffer runs dry the stream pauses, and resumes a delay after the
+// next frame arrives. It must be called at least once per frame duration.
+func (r *Receiver) Process() {
+	now := r.config.Clock.Now()
+	for peer, s := range r.streams {
+		for !s.due.After(now) {
+			if len(s.frames) == 0 {
+				s.playing = false
+				break
+			}
+			s.playing, s.started = true, true
+
+			frame := Frame{Seq: s.next}
+			if data, ok := s.frames[s.next]; ok {
+				delete(s.frames, s.next)
+				frame.Data = data
+				s.stats.Played++
+			} else {
+				frame.Lost = true
+				s.stats.Lost++
+			}
+			s.last = s.next
+			s.next++
+			s.due = s.due.Add(r.config.FrameDuration)
+			if r.config.OnPlayout != nil {
+				r.config.OnPlayout(peer, frame)
+			}
+		}
+	}
+}
+
+// Buffered returns the number of frames of peer waiting to be played out
+func (r *Receiver) Buffered(peer enet.Peer) int {
+	if s, ok := r.streams[peer]; ok {
+		return len(s.frames)
+	}
+	return 0
+}
+
+// Stats returns the statistics of the frames received from peer
+func (r *Receiver) Stats(peer enet.Peer) Stats {
+	if s, ok := r.streams[peer]; ok {
+		return s.stats
+	}
+	return Stats{}
+}
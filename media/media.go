@@ -0,0 +1,132 @@
+// Package media carries real-time media such as voice as a stream of small frames sent
+// every few milliseconds. Frames are sent unreliably and unsequenced, as a frame
+// arriving late is as good as lost, and numbered so receivers can put them back in
+// order:
+//
+//	sender := media.NewSender(media.Config{Channel: 4})
+//
+//	// Every 20ms of audio captured:
+//	sender.Send(encodeOpus(samples), peers...)
+//
+// Receivers hold the frames of every peer in a jitter buffer for a little while,
+// absorbing the variation in their delay, and play them out at a steady pace:
+//
+//	receiver := media.NewReceiver(media.Config{
+//		Channel: 4,
+//		OnPlayout: func(peer enet.Peer, frame media.Frame) {
+//			if frame.Lost {
+//				play(peer, concealLoss())
+//			} else {
+//				play(peer, decodeOpus(frame.Data))
+//			}
+//		},
+//	})
+//
+//	// For every event:
+//	if receiver.Handle(event) {
+//		return
+//	}
+//
+//	// Every few milliseconds:
+//	receiver.Process()
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultFrameDuration is the duration of the media in a frame by default
+	DefaultFrameDuration = 20 * time.Millisecond
+
+	// DefaultDelay is how long frames are buffered before they are played out by
+	// default
+	DefaultDelay = 60 * time.Millisecond
+
+	// headerSize is the size of the sequence number leading frames.
+	headerSize = 4
+
+	// flags are the flags frames are sent with, unreliable fragments keeping large
+	// frames unreliable.
+	flags = enet.PacketFlagUnsequenced | enet.PacketFlagUnreliableFragment
+)
+
+var errMalformed = errors.New("malformed media frame")
+
+// Frame is a frame of media played out by a Receiver
+type Frame struct {
+	// Seq is the sequence number of the frame
+	Seq uint32
+
+	// Data is the frame as it was sent, nil if it was lost
+	Data []byte
+
+	// Lost is set for frames that did not arrive in time to be played out, so
+	// codecs can conceal their loss
+	Lost bool
+}
+
+// Config configures a Sender or a Receiver
+type Config struct {
+	// Channel is the channel frames are sent on
+	Channel uint8
+
+	// FrameDuration is the duration of the media in a frame, the pace frames are played
+	// out at. Defaults to DefaultFrameDuration.
+	FrameDuration time.Duration
+
+	// Delay is how long frames are buffered before they are played out. The longer it
+	// is, the later frames may arrive, at the cost of latency. Defaults to DefaultDelay.
+	Delay time.Duration
+
+	// OnPlayout is called with every frame of a peer as it is due to be played out,
+	// in order. It may be nil for senders.
+	OnPlayout func(peer enet.Peer, frame Frame)
+
+	// Clock tells the time frames arrive and are played out at. Defaults to the
+	// SystemClock.
+	Clock enet.Clock
+}
+
+// Sender sends frames, see the package documentation. It is not safe for concurrent
+// use.
+type Sender struct {
+	config Config
+	seq    uint32
+}
+
+// NewSender creates a sender
+func NewSender(config Config) *Sender {
+	return &Sender{config: config}
+}
+
+// Send numbers frame and sends it to peers, the same frame having the same sequence
+// number for all of them
+func (s *Sender) Send(frame []byte, peers ...enet.Peer) error {
+	data := s.Encode(frame)
+	var errs []error
+	for _, peer := range peers {
+		if err := peer.SendBytes(data, s.config.Channel, flags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Encode numbers frame as Send does, for applications sending it themselves. It should
+// be sent unsequenced.
+func (s *Sender) Encode(frame []byte) []byte {
+	s.seq++
+	data := make([]byte, headerSize, headerSize+len(frame))
+	binary.BigEndian.PutUint32(data, s.seq)
+	return append(data, frame...)
+}
+
+// Seq returns the sequence number of the last frame sent
+func (s *Sender) Seq() uint32 {
+	return s.seq
+}
@@ -0,0 +1,564 @@
+package enet
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// simulatorQueueSize bounds the datagrams delivered by a simulator but not yet read,
+// beyond which they are dropped like a full socket buffer would.
+const simulatorQueueSize = 256
+
+// NetworkConditions are the conditions a NetworkSimulator applies to the datagrams
+// going one way
+type NetworkConditions struct {
+	// Latency delays every datagram
+	Latency time.Duration
+
+	// Jitter adds up to that much to the latency of every datagram, at random, which
+	// reorders datagrams sent closer together than the jitter
+	Jitter time.Duration
+
+	// Loss is the probability of a datagram being dropped, from 0 to 1
+	Loss float64
+
+	// Duplicate is the probability of a datagram being delivered twice, from 0 to 1
+	Duplicate float64
+
+	// Reorder is the probability of a datagram being held back by ReorderDelay on top
+	// of its latency, from 0 to 1, so the datagrams sent after it overtake it
+	Reorder float64
+
+	// ReorderDelay defaults to 20 milliseconds
+	ReorderDelay time.Duration
+}
+
+// SimulatorConfig configures a NetworkSimulator
+type SimulatorConfig struct {
+	// Outgoing are the conditions of the datagrams the host sends, and Incoming of
+	// those it receives, unless overridden for a peer
+	Outgoing NetworkConditions
+	Incoming NetworkConditions
+
+	// Seed seeds the randomness of the conditions, so datagrams meet the same fate run
+	// after run. 0 picks a random seed.
+	Seed uint64
+
+	// Clock times the datagrams held back, defaulting to SystemClock. Off the system
+	// clock, datagrams are delivered as the clock is advanced, and hosts take them as
+	// they are serviced instead of on a goroutine of their own.
+	Clock Clock
+}
+
+// NetworkSimulator is a Transport degrading the network underneath a host, so
+// applications can be tried under bad networks locally
+type NetworkSimulator interface {
+	Transport
+
+	// SetConditions replaces the conditions of the peers without conditions of their
+	// own
+	SetConditions(outgoing, incoming NetworkConditions)
+
+	// SetPeerConditions replaces the conditions of the datagrams exchanged with the
+	// peer at addr, as returned by Peer.GetAddress or created with NewAddress
+	SetPeerConditions(addr Address, outgoing, incoming NetworkConditions)
+
+	// ResetPeerConditions makes the peer at addr use the conditions of SetConditions
+	// again
+	ResetPeerConditions(addr Address)
+}
+
+// SimulatedNetwork is an in-memory network of NetworkSimulators sharing a clock and a
+// seeded source of randomness. With a VirtualClock, the fate and timing of every
+// datagram only depend on the datagrams sent and the order they were sent in, and hosts
+// take them as they are serviced, so tests servicing their hosts in a fixed order and
+// seeding them with SeedHost see the same network run after run:
+//
+//	clock := enet.NewVirtualClock(time.Unix(0, 0))
+//	network := enet.NewSimulatedNetwork(enet.SimulatorConfig{Seed: 1, Clock: clock})
+//	enet.SetTransportFactory(func(addr enet.Address) (enet.Transport, error) {
+//		return network.Listen(addr)
+//	})
+type SimulatedNetwork interface {
+	// Listen creates the transport of a host at addr on the network, with the
+	// conditions of the network. A nil addr or port 0 picks a free port, and hosts at
+	// unspecified addresses are at 127.0.0.1.
+	Listen(addr Address) (NetworkSimulator, error)
+}
+
+// pollingTransport is a Transport the host servicing it polls for datagrams instead of
+// reading them on a goroutine, so they arrive in a reproducible order.
+type pollingTransport interface {
+	Transport
+
+	// polling returns true if the transport must be polled.
+	polling() bool
+
+	// poll returns the next datagram received, if any, without waiting.
+	poll(p []byte) (n int, addr net.Addr, ok bool)
+}
+
+// simDirection tells whether a datagram was sent or received through a simulator.
+type simDirection int
+
+const (
+	simOutgoing simDirection = iota
+	simIncoming
+)
+
+type simDatagram struct {
+	due       time.Time
+	seq       uint64
+	direction simDirection
+	addr      net.Addr
+	data      []byte
+}
+
+// simQueue is a heap of the datagrams held by a simulator, the first due first and
+// those due together in the order they were scheduled.
+type simQueue []*simDatagram
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x any)   { *q = append(*q, x.(*simDatagram)) }
+func (q *simQueue) Pop() any {
+	old := *q
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return d
+}
+
+// simRandom is the randomness of simulators, shared by those of a network.
+type simRandom struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newSimRandom(seed uint64) *simRandom {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &simRandom{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// chance returns true with probability p.
+func (r *simRandom) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.Float64() < p
+}
+
+// intN returns a number from 0 to n-1.
+func (r *simRandom) intN(n int) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.IntN(n)
+}
+
+// duration returns a duration from 0 to d.
+func (r *simRandom) duration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return time.Duration(r.rand.Int64N(int64(d) + 1))
+}
+
+type netsim struct {
+	Transport
+	clock  Clock
+	random *simRandom
+	polled bool
+
+	lock       sync.Mutex
+	conditions [2]NetworkConditions
+	peers      map[netip.AddrPort][2]NetworkConditions
+	faults     map[netip.AddrPort]*simFaults
+	queue      simQueue
+	seq        uint64
+	timer      ClockTimer
+	timerDue   time.Time
+
+	// releaseLock keeps the datagrams falling due in order when the timers of the
+	// system clock fire concurrently.
+	releaseLock sync.Mutex
+
+	received  chan *simDatagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewNetworkSimulator wraps transport in a simulator delaying, dropping, duplicating and
+// reordering the datagrams it carries both ways. Hosts run on it with
+// NewTransportHost, or with NewHost through SetTransportFactory:
+//
+//	enet.SetTransportFactory(func(addr enet.Address) (enet.Transport, error) {
+//		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(addr.GetPort())})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return enet.NewNetworkSimulator(conn, config), nil
+//	})
+//
+// Conditions can be changed at any time from any goroutine, for all peers or a single
+// one. The simulator owns transport and closes it once closed.
+func NewNetworkSimulator(transport Transport, config SimulatorConfig) NetworkSimulator {
+	sim := newNetsim(transport, config, newSimRandom(config.Seed))
+	go sim.read()
+	return sim
+}
+
+func newNetsim(transport Transport, config SimulatorConfig, random *simRandom) *netsim {
+	clock := config.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &netsim{
+		Transport:  transport,
+		clock:      clock,
+		random:     random,
+		polled:     clock != SystemClock,
+		conditions: [2]NetworkConditions{config.Outgoing, config.Incoming},
+		peers:      make(map[netip.AddrPort][2]NetworkConditions),
+		received:   make(chan *simDatagram, simulatorQueueSize),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (sim *netsim) SetConditions(outgoing, incoming NetworkConditions) {
+	sim.lock.Lock()
+	sim.conditions = [2]NetworkConditions{outgoing, incoming}
+	sim.lock.Unlock()
+}
+
+func (sim *netsim) SetPeerConditions(addr Address, outgoing, incoming NetworkConditions) {
+	sim.lock.Lock()
+	sim.peers[simAddrKey(peerUDPAddr(addr))] = [2]NetworkConditions{outgoing, incoming}
+	sim.lock.Unlock()
+}
+
+func (sim *netsim) ResetPeerConditions(addr Address) {
+	sim.lock.Lock()
+	delete(sim.peers, simAddrKey(peerUDPAddr(addr)))
+	sim.lock.Unlock()
+}
+
+func (sim *netsim) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-sim.received:
+		return copy(p, d.data), d.addr, nil
+	case <-sim.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (sim *netsim) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-sim.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	sim.schedule(simOutgoing, addr, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (sim *netsim) Close() error {
+	err := net.ErrClosed
+	sim.closeOnce.Do(func() {
+		close(sim.closed)
+		sim.lock.Lock()
+		if sim.timer != nil {
+			sim.timer.Stop()
+		}
+		sim.lock.Unlock()
+		err = sim.Transport.Close()
+	})
+	return err
+}
+
+func (sim *netsim) polling() bool {
+	return sim.polled
+}
+
+func (sim *netsim) poll(p []byte) (int, net.Addr, bool) {
+	select {
+	case d := <-sim.received:
+		return copy(p, d.data), d.addr, true
+	default:
+		return 0, nil, false
+	}
+}
+
+// read schedules the datagrams arriving on the transport for delivery.
+func (sim *netsim) read() {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := sim.Transport.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-sim.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				sim.Close()
+				return
+			}
+			continue
+		}
+		sim.schedule(simIncoming, addr, append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// schedule applies the conditions of its direction and peer to a datagram.
+func (sim *netsim) schedule(direction simDirection, addr net.Addr, data []byte) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	key := simAddrKey(addr)
+	conditions := sim.conditions[direction]
+	if peer, ok := sim.peers[key]; ok {
+		conditions = peer[direction]
+	}
+
+	now := sim.clock.Now()
+	var faultDelay time.Duration
+	if faults, ok := sim.faults[key]; ok {
+		if faults.expired(now) {
+			delete(sim.faults, key)
+		} else if faults.drops(now) {
+			return
+		} else {
+			faultDelay = faults.delay(now, data)
+		}
+	}
+	if sim.random.chance(conditions.Loss) {
+		return
+	}
+
+	copies := 1
+	if sim.random.chance(conditions.Duplicate) {
+		copies = 2
+	}
+	for range copies {
+		delay := conditions.Latency + sim.random.duration(conditions.Jitter) + faultDelay
+		if sim.random.chance(conditions.Reorder) {
+			if conditions.ReorderDelay > 0 {
+				delay += conditions.ReorderDelay
+			} else {
+				delay += 20 * time.Millisecond
+			}
+		}
+		sim.seq++
+		heap.Push(&sim.queue, &simDatagram{due: now.Add(delay), seq: sim.seq, direction: direction, addr: addr, data: data})
+	}
+	sim.arm(now)
+}
+
+// arm makes the clock call release as the first datagram held falls due. Must be
+// called with the lock held.
+func (sim *netsim) arm(now time.Time) {
+	if len(sim.queue) == 0 {
+		return
+	}
+	due := sim.queue[0].due
+	if sim.timer != nil {
+		if !due.Before(sim.timerDue) {
+			return
+		}
+		sim.timer.Stop()
+	}
+	sim.timer = sim.clock.AfterFunc(due.Sub(now), sim.release)
+	sim.timerDue = due
+}
+
+// release sends and receives the datagrams that fell due.
+func (sim *netsim) release() {
+	sim.releaseLock.Lock()
+	defer sim.releaseLock.Unlock()
+
+	select {
+	case <-sim.closed:
+		return
+	default:
+	}
+
+	sim.lock.Lock()
+	now := sim.clock.Now()
+	var due []*simDatagram
+	for len(sim.queue) > 0 && !sim.queue[0].due.After(now) {
+		due = append(due, heap.Pop(&sim.queue).(*simDatagram))
+	}
+	sim.timer = nil
+	sim.arm(now)
+	sim.lock.Unlock()
+
+	for _, d := range due {
+		if d.direction == simOutgoing {
+			// Like a socket that could not send, the datagram is lost.
+			sim.Transport.WriteTo(d.data, d.addr)
+			continue
+		}
+		select {
+		case sim.received <- d:
+		default:
+		}
+	}
+}
+
+// simAddrKey returns the key of the conditions of a peer at addr.
+func simAddrKey(addr net.Addr) netip.AddrPort {
+	key := transportUDPAddr(addr).AddrPort()
+	return netip.AddrPortFrom(key.Addr().Unmap(), key.Port())
+}
+
+// simNetworkFirstPort is the first port handed out by a simulated network.
+const simNetworkFirstPort = 49152
+
+type simNetwork struct {
+	config SimulatorConfig
+	random *simRandom
+
+	lock      sync.Mutex
+	endpoints map[netip.AddrPort]*netsim
+	nextPort  int
+}
+
+// NewSimulatedNetwork creates an in-memory network, whose hosts start with the
+// conditions of config
+func NewSimulatedNetwork(config SimulatorConfig) SimulatedNetwork {
+	return &simNetwork{
+		config:    config,
+		random:    newSimRandom(config.Seed),
+		endpoints: make(map[netip.AddrPort]*netsim),
+		nextPort:  simNetworkFirstPort,
+	}
+}
+
+func (network *simNetwork) Listen(addr Address) (NetworkSimulator, error) {
+	key := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+	if addr != nil {
+		key = simAddrKey(peerUDPAddr(addr))
+		if key.Addr().IsUnspecified() {
+			key = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), key.Port())
+		}
+	}
+
+	network.lock.Lock()
+	defer network.lock.Unlock()
+
+	if key.Port() == 0 {
+		for {
+			if network.nextPort > 0xFFFF {
+				network.nextPort = simNetworkFirstPort
+			}
+			candidate := netip.AddrPortFrom(key.Addr(), uint16(network.nextPort))
+			network.nextPort++
+			if _, taken := network.endpoints[candidate]; !taken {
+				key = candidate
+				break
+			}
+			if len(network.endpoints) > 0xFFFF-simNetworkFirstPort {
+				return nil, errors.New("no free port on the simulated network")
+			}
+		}
+	} else if _, taken := network.endpoints[key]; taken {
+		return nil, errors.New("address already in use on the simulated network")
+	}
+
+	endpoint := &simEndpoint{network: network, key: key, addr: net.UDPAddrFromAddrPort(key), closed: make(chan struct{})}
+	sim := newNetsim(endpoint, network.config, network.random)
+	network.endpoints[key] = sim
+	return sim, nil
+}
+
+// route hands a datagram sent on the network to the simulator at to, if any.
+func (network *simNetwork) route(from *net.UDPAddr, to net.Addr, data []byte) {
+	network.lock.Lock()
+	sim := network.endpoints[simAddrKey(to)]
+	network.lock.Unlock()
+
+	if sim != nil {
+		sim.schedule(simIncoming, from, data)
+	}
+}
+
+// simEndpoint is the transport of a simulator on a simulated network.
+type simEndpoint struct {
+	network   *simNetwork
+	key       netip.AddrPort
+	addr      *net.UDPAddr
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (endpoint *simEndpoint) ReadFrom(p []byte) (int, net.Addr, error) {
+	// Datagrams are routed straight to the simulator.
+	<-endpoint.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (endpoint *simEndpoint) WriteTo(p []byte, addr net.Addr) (int, error) {
+	endpoint.network.route(endpoint.addr, addr, p)
+	return len(p), nil
+}
+
+func (endpoint *simEndpoint) LocalAddr() net.Addr {
+	return endpoint.addr
+}
+
+func (endpoint *simEndpoint) Close() error {
+	endpoint.closeOnce.Do(func() {
+		close(endpoint.closed)
+		network := endpoint.network
+		network.lock.Lock()
+		delete(network.endpoints, endpoint.key)
+		network.lock.Unlock()
+	})
+	return nil
+}
+
+// SeedHost seeds the connect IDs of host, which are otherwise derived from the time
+// it was created, so runs on a SimulatedNetwork are reproducible. Cryptographic keys,
+// such as those of Noise handshakes, are never seeded. Hosts in the browser have no
+// connect IDs of their own.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func SeedHost(host Host, seed uint32) error {
+	var err error
+	seedHost := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("seeding is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		h.seedRandom(seed)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(seedHost)
+	} else {
+		seedHost(host)
+	}
+	return err
+}
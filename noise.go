@@ -0,0 +1,655 @@
+package enet
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NoisePattern is the Noise handshake pattern peers authenticate with
+type NoisePattern int
+
+const (
+	// NoiseXX exchanges the static keys of both sides during the handshake, for peers
+	// that don't know each other in advance. It takes one and a half round trips.
+	NoiseXX NoisePattern = iota
+
+	// NoiseIK is for hosts connecting to peers whose static key they know in advance,
+	// see NoiseConfig.RemoteKey. It takes one round trip.
+	NoiseIK
+)
+
+const (
+	// noiseMaxHeld bounds the packets held for a peer until its handshake finishes,
+	// beyond which the peer is disconnected.
+	noiseMaxHeld = 64
+
+	// noiseNonceSize is the size of the nonce heading sealed packets.
+	noiseNonceSize = 8
+
+	// noiseIdentityContext prefixes what identity keys sign, the static key of the
+	// host they vouch for.
+	noiseIdentityContext = "enet noise identity\x00"
+)
+
+// NoiseConfig configures the Noise handshake of a host, see EnableNoise
+type NoiseConfig struct {
+	// Channel carries the handshakes, so the application must not use it for
+	// anything else. Peers must connect with enough channels to include it.
+	Channel uint8
+
+	Pattern NoisePattern
+
+	// PrivateKey is the static X25519 key of the host, see GenerateNoiseKey
+	PrivateKey []byte
+
+	// RemoteKey is the static public key expected of the peers the host connects to.
+	// Hosts connecting with NoiseIK require it, and with NoiseXX handshakes fail if
+	// the peer presents another key. Peers connecting to the host are not affected.
+	RemoteKey []byte
+
+	// IdentityKey is the long-term Ed25519 key of the host, if it has one. The host
+	// signs its static key with it during handshakes, so peers see it as the
+	// Peer.PublicKey of the host. Unlike the static key it can be kept offline and
+	// used to certify new static keys, and it's what peers pin.
+	IdentityKey ed25519.PrivateKey
+
+	// PinnedKey is the identity key expected of the peers the host connects to, such
+	// as the key of a game service. Handshakes with peers that don't prove they hold
+	// it fail, which keeps reconnects and connections through relays from reaching
+	// impostors. Peers connecting to the host are not affected.
+	PinnedKey ed25519.PublicKey
+
+	// Authorize decides whether the peer may connect once its handshake proved it
+	// holds the private key of identity, by returning nil. Its identity key, if any,
+	// is available from Peer.PublicKey by then. Nil accepts every peer.
+	Authorize func(peer Peer, identity []byte) error
+
+	// HandshakeTimeout bounds how long handshakes may take. Defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+}
+
+// GenerateNoiseKey generates a static X25519 private key for NoiseConfig.PrivateKey
+func GenerateNoiseKey() ([]byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return key.Bytes(), nil
+}
+
+// NoisePublicKey returns the public key of a static private key, which is the
+// identity peers see the host as
+func NoisePublicKey(privateKey []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey().Bytes(), nil
+}
+
+// EnableNoise makes host run a Noise handshake on config.Channel with every peer that
+// connects from then on, authenticating both sides by their static keys and deriving
+// session keys per peer. Connect events are held back until the handshake succeeds and
+// the peer is authorized, and peers that fail are disconnected without the application
+// ever seeing them. Peer.Identity returns the key a peer authenticated with.
+//
+// Packets sent with SendSealed are encrypted with the session keys and decrypted before
+// Host.Service returns them. Once the handshake succeeded, packets from the peer that
+// are not sealed are dropped, so both sides must send with SendSealed. Sealed packets
+// are dropped if replayed, or if they arrive more than DefaultReplayWindow packets late,
+// see ReplayGuard. Both hosts must enable Noise with the same channel and pattern.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func EnableNoise(host Host, config NoiseConfig) error {
+	key, err := ecdh.X25519().NewPrivateKey(config.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var remote *ecdh.PublicKey
+	if config.RemoteKey != nil {
+		if remote, err = ecdh.X25519().NewPublicKey(config.RemoteKey); err != nil {
+			return err
+		}
+	}
+	if config.IdentityKey != nil && len(config.IdentityKey) != ed25519.PrivateKeySize {
+		return errors.New("invalid ed25519 identity key")
+	}
+	if config.PinnedKey != nil && len(config.PinnedKey) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 pinned key")
+	}
+	var proof []byte
+	if config.IdentityKey != nil {
+		proof = append(proof, config.IdentityKey.Public().(ed25519.PublicKey)...)
+		proof = append(proof, ed25519.Sign(config.IdentityKey, noiseIdentitySigned(key.PublicKey()))...)
+	}
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = 10 * time.Second
+	}
+
+	enable := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("noise is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.noise
+		table.lock.Lock()
+		table.config = config
+		table.key = key
+		table.remote = remote
+		table.proof = proof
+		if table.peers == nil {
+			table.peers = make(map[enetPeer]*noisePeer)
+			table.initiated = make(map[enetPeer]struct{})
+		}
+		table.lock.Unlock()
+		table.enabled.Store(true)
+		h.features.join(featureNoise)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(enable)
+	} else {
+		enable(host)
+	}
+	return err
+}
+
+// SendSealed encrypts data with the session keys of peer and sends it, see EnableNoise.
+// Fails if the peer has not finished its handshake.
+func SendSealed(peer Peer, data []byte, channel uint8, flags PacketFlags) error {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return errors.New("sealing is only supported on enet peers")
+	}
+	host := p.host()
+	if host == nil {
+		return errors.New("peer has no host")
+	}
+
+	sealed, err := host.noise.seal(p, channel, data)
+	if err != nil {
+		return err
+	}
+	return peer.SendBytes(sealed, channel, flags)
+}
+
+func (peer enetPeer) Identity() []byte {
+	host := peer.host()
+	if host == nil {
+		return nil
+	}
+	return host.noise.identity(peer)
+}
+
+func (peer enetPeer) PublicKey() ed25519.PublicKey {
+	host := peer.host()
+	if host == nil {
+		return nil
+	}
+	return host.noise.publicKey(peer)
+}
+
+// noiseIdentitySigned returns what an identity key signs to vouch for static.
+func noiseIdentitySigned(static *ecdh.PublicKey) []byte {
+	return append([]byte(noiseIdentityContext), static.Bytes()...)
+}
+
+// noisePeer is the handshake and session state of a peer.
+type noisePeer struct {
+	handshake   *noiseHandshake
+	initiator   bool
+	started     time.Time
+	connectData uint32
+
+	// publicKey is the identity key the peer proved during the handshake.
+	publicKey ed25519.PublicKey
+
+	// Set once the handshake finished.
+	send, receive cipher.AEAD
+	sendNonce     uint64
+	identity      []byte
+
+	// replay holds the nonces of the packets received.
+	replay replayWindow
+
+	connected bool
+	failed    bool
+	held      []heldPacket
+}
+
+// finished returns true once the handshake of the peer succeeded, which may be before
+// it's authorized.
+func (state *noisePeer) finished() bool {
+	return state.send != nil && !state.failed
+}
+
+// heldPacket is a sealed packet received before the handshake of its peer finished.
+type heldPacket struct {
+	channel uint8
+	flags   PacketFlags
+	data    []byte
+}
+
+// noiseTable holds the Noise state of the peers of a host.
+type noiseTable struct {
+	enabled atomic.Bool
+
+	lock      sync.Mutex
+	config    NoiseConfig
+	key       *ecdh.PrivateKey
+	remote    *ecdh.PublicKey
+	proof     []byte
+	peers     map[enetPeer]*noisePeer
+	initiated map[enetPeer]struct{}
+
+	// ready holds the events released by a finished handshake, returned by the next
+	// Service calls.
+	ready []Event
+}
+
+// connecting remembers that the host connects to peer, making it the initiator of the
+// handshake.
+func (table *noiseTable) connecting(peer enetPeer) {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	table.initiated[peer] = struct{}{}
+	table.lock.Unlock()
+}
+
+func (table *noiseTable) identity(peer enetPeer) []byte {
+	if !table.enabled.Load() {
+		return nil
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	state := table.peers[peer]
+	if state == nil || !state.finished() {
+		return nil
+	}
+	return append([]byte(nil), state.identity...)
+}
+
+func (table *noiseTable) publicKey(peer enetPeer) ed25519.PublicKey {
+	if !table.enabled.Load() {
+		return nil
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	state := table.peers[peer]
+	if state == nil || !state.finished() || state.publicKey == nil {
+		return nil
+	}
+	return append(ed25519.PublicKey(nil), state.publicKey...)
+}
+
+// write writes the next handshake message, with the proof of the identity key of the
+// host if it's due. Must be called with the lock held.
+func (table *noiseTable) write(hs *noiseHandshake) ([]byte, error) {
+	var payload []byte
+	if hs.proves() {
+		payload = table.proof
+	}
+	return hs.write(payload)
+}
+
+// read reads the next handshake message of state, verifying the proof of the identity
+// key of the peer if it's due. Must be called with the lock held.
+func (table *noiseTable) read(state *noisePeer, msg []byte) error {
+	hs := state.handshake
+	proves := hs.proves()
+	payload, err := hs.read(msg)
+	if err != nil || !proves || len(payload) == 0 {
+		return err
+	}
+
+	if len(payload) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return errNoiseHandshake
+	}
+	publicKey := ed25519.PublicKey(payload[:ed25519.PublicKeySize])
+	if !ed25519.Verify(publicKey, noiseIdentitySigned(hs.rs), payload[ed25519.PublicKeySize:]) {
+		return errNoiseHandshake
+	}
+	state.publicKey = append(ed25519.PublicKey(nil), publicKey...)
+	return nil
+}
+
+// seal encrypts data for peer, heading it with the nonce.
+func (table *noiseTable) seal(peer enetPeer, channel uint8, data []byte) ([]byte, error) {
+	if !table.enabled.Load() {
+		return nil, errors.New("noise is not enabled on the host of the peer")
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	state := table.peers[peer]
+	if state == nil || !state.connected {
+		return nil, errors.New("peer has not finished its handshake")
+	}
+	if state.sendNonce == math.MaxUint64 {
+		return nil, errors.New("session keys of the peer are exhausted")
+	}
+	nonce := state.sendNonce
+	state.sendNonce++
+
+	sealed := binary.BigEndian.AppendUint64(make([]byte, 0, noiseNonceSize+len(data)+state.send.Overhead()), nonce)
+	return state.send.Seal(sealed, noiseNonce(nonce), data, []byte{channel}), nil
+}
+
+// open decrypts a sealed packet of peer. Packets are dropped if their nonce was seen
+// before, whatever flags the packet claims, as they are not authenticated.
+func (state *noisePeer) open(channel uint8, data []byte) ([]byte, bool) {
+	if len(data) < noiseNonceSize {
+		return nil, false
+	}
+	nonce := binary.BigEndian.Uint64(data)
+	plaintext, err := state.receive.Open(nil, noiseNonce(nonce), data[noiseNonceSize:], []byte{channel})
+	if err != nil {
+		return nil, false
+	}
+	if !state.replay.accept(nonce) {
+		return nil, false
+	}
+	return plaintext, true
+}
+
+// next sets event to the next event released by a handshake. Returns false if there
+// is none.
+func (table *noiseTable) next(event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if len(table.ready) == 0 {
+		return false
+	}
+	event.goEvent = table.ready[0]
+	event.timestamp = time.Now()
+	table.ready[0] = nil
+	table.ready = table.ready[1:]
+	return true
+}
+
+// forget drops the state of peer, disconnected without an event.
+func (table *noiseTable) forget(peer enetPeer) {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	delete(table.peers, peer)
+	delete(table.initiated, peer)
+}
+
+// expire fails the handshakes that take too long.
+func (table *noiseTable) expire() {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	now := time.Now()
+	for peer, state := range table.peers {
+		if !state.connected && !state.failed && now.Sub(state.started) > table.config.HandshakeTimeout {
+			table.fail(peer, state)
+		}
+	}
+}
+
+// fail disconnects peer, keeping its state to swallow whatever it still sends. Must be
+// called with the lock held.
+func (table *noiseTable) fail(peer enetPeer, state *noisePeer) {
+	state.failed = true
+	state.handshake = nil
+	state.held = nil
+	peer.Disconnect(0)
+}
+
+// intercept runs the handshakes and decrypts sealed packets. Returns true if the event
+// has been consumed and must not be returned to the application. Decrypted packets and
+// connect events held back until now replace the event.
+func (table *noiseTable) intercept(event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+
+	switch event.GetType() {
+	case EventConnect:
+		table.start(peer, event.GetData())
+		return true
+
+	case EventReceive:
+		table.lock.Lock()
+		state := table.peers[peer]
+		table.lock.Unlock()
+		if state == nil {
+			// The peer connected before Noise was enabled.
+			return false
+		}
+
+		if event.GetChannelID() == table.config.Channel && state.handshake != nil {
+			data := event.GetPacket().GetData()
+			event.GetPacket().Destroy()
+			return !table.handshake(peer, state, data, event)
+		}
+		return !table.receive(state, event)
+
+	case EventDisconnect, EventDisconnectTimeout:
+		table.lock.Lock()
+		defer table.lock.Unlock()
+
+		_, initiated := table.initiated[peer]
+		delete(table.initiated, peer)
+		state := table.peers[peer]
+		if state == nil {
+			return false
+		}
+		delete(table.peers, peer)
+		// The application knows of the peers it connected to or was told about.
+		return !state.connected && !state.initiator && !initiated
+	}
+	return false
+}
+
+// start starts the handshake of a peer that just connected, writing the first message
+// if the host connected to it.
+func (table *noiseTable) start(peer enetPeer, data uint32) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	_, initiator := table.initiated[peer]
+	delete(table.initiated, peer)
+
+	state := &noisePeer{initiator: initiator, started: time.Now(), connectData: data}
+	table.peers[peer] = state
+
+	var remote *ecdh.PublicKey
+	if initiator {
+		remote = table.remote
+	}
+	hs, err := newNoiseHandshake(table.config.Pattern, initiator, table.key, remote)
+	if err != nil {
+		table.fail(peer, state)
+		return
+	}
+	state.handshake = hs
+
+	if hs.writing() {
+		msg, err := table.write(hs)
+		if err != nil || peer.SendBytes(msg, table.config.Channel, PacketFlagReliable) != nil {
+			table.fail(peer, state)
+		}
+	}
+}
+
+// handshake reads a handshake message of peer and writes the answer. Once the handshake
+// finishes, the connect event replaces event and true is returned.
+func (table *noiseTable) handshake(peer enetPeer, state *noisePeer, msg []byte, event *enetEvent) bool {
+	table.lock.Lock()
+	hs := state.handshake
+	err := table.read(state, msg)
+	if err == nil && !hs.finished() && hs.writing() {
+		var answer []byte
+		if answer, err = table.write(hs); err == nil {
+			err = peer.SendBytes(answer, table.config.Channel, PacketFlagReliable)
+		}
+	}
+	if err == nil && hs.finished() && state.initiator {
+		if table.remote != nil && !hs.rs.Equal(table.remote) {
+			err = errNoiseHandshake
+		}
+		if table.config.PinnedKey != nil && !table.config.PinnedKey.Equal(state.publicKey) {
+			err = errNoiseHandshake
+		}
+	}
+	if err != nil {
+		table.fail(peer, state)
+	}
+	if err != nil || !hs.finished() {
+		table.lock.Unlock()
+		return false
+	}
+
+	state.handshake = nil
+	state.identity = hs.rs.Bytes()
+	state.send, state.receive, err = hs.split()
+	state.replay = newReplayWindow(DefaultReplayWindow)
+	authorize := table.config.Authorize
+	table.lock.Unlock()
+
+	if err == nil && authorize != nil {
+		err = authorize(peer, state.identity)
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if err != nil {
+		table.fail(peer, state)
+		return false
+	}
+	state.connected = true
+
+	event.goEvent = &noiseEvent{
+		eventType: EventConnect,
+		peer:      peer,
+		data:      state.connectData,
+		timestamp: event.timestamp,
+	}
+	for _, held := range state.held {
+		if plaintext, ok := state.open(held.channel, held.data); ok {
+			if received := newNoiseReceiveEvent(peer, held.channel, held.flags, plaintext); received != nil {
+				table.ready = append(table.ready, received)
+			}
+		}
+	}
+	state.held = nil
+	return true
+}
+
+// receive decrypts the packet of event, holding it back if the handshake of its peer
+// hasn't finished yet. Returns true if the decrypted packet replaced event.
+func (table *noiseTable) receive(state *noisePeer, event *enetEvent) bool {
+	packet := event.GetPacket()
+	defer packet.Destroy()
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	switch {
+	case state.connected:
+	case state.failed:
+		return false
+	default:
+		if len(state.held) == noiseMaxHeld {
+			table.fail(event.GetPeer().(enetPeer), state)
+			return false
+		}
+		state.held = append(state.held, heldPacket{
+			channel: event.GetChannelID(),
+			flags:   packet.GetFlags(),
+			data:    packet.GetData(),
+		})
+		return false
+	}
+
+	plaintext, ok := state.open(event.GetChannelID(), event.GetPacketDataUnsafe())
+	if !ok {
+		return false
+	}
+	received := newNoiseReceiveEvent(event.GetPeer(), event.GetChannelID(), packet.GetFlags(), plaintext)
+	if received == nil {
+		return false
+	}
+	received.timestamp = event.timestamp
+	event.goEvent = received
+	return true
+}
+
+// noiseEvent is an event produced by the handshakes, with decrypted packets.
+type noiseEvent struct {
+	eventType EventType
+	peer      Peer
+	channelID uint8
+	data      uint32
+	packet    Packet
+	payload   []byte
+	timestamp time.Time
+}
+
+func newNoiseReceiveEvent(peer Peer, channel uint8, flags PacketFlags, plaintext []byte) *noiseEvent {
+	packet, err := NewPacket(plaintext, flags&(PacketFlagReliable|PacketFlagUnsequenced|PacketFlagUnreliableFragment))
+	if err != nil {
+		return nil
+	}
+	return &noiseEvent{
+		eventType: EventReceive,
+		peer:      peer,
+		channelID: channel,
+		packet:    packet,
+		payload:   plaintext,
+		timestamp: time.Now(),
+	}
+}
+
+func (event *noiseEvent) GetType() EventType          { return event.eventType }
+func (event *noiseEvent) GetPeer() Peer               { return event.peer }
+func (event *noiseEvent) GetChannelID() uint8         { return event.channelID }
+func (event *noiseEvent) GetData() uint32             { return event.data }
+func (event *noiseEvent) GetPacket() Packet           { return event.packet }
+func (event *noiseEvent) GetPacketDataUnsafe() []byte { return event.payload }
+func (event *noiseEvent) GetTimestamp() time.Time     { return event.timestamp }
+
+func (event *noiseEvent) ReadPayload(buf []byte) (int, error) {
+	if event.packet == nil {
+		return 0, errNoPayload
+	}
+	return readPayload(event.payload, buf)
+}
@@ -0,0 +1,283 @@
+package enet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// noiseKeySize is the size of X25519 keys.
+const noiseKeySize = 32
+
+var errNoiseHandshake = errors.New("noise handshake failed")
+
+// noiseSymmetric is the SymmetricState of the Noise specification, for SHA256 and
+// AESGCM.
+type noiseSymmetric struct {
+	ck, h [sha256.Size]byte
+	aead  cipher.AEAD
+	n     uint64
+}
+
+func (s *noiseSymmetric) init(name string) {
+	if len(name) <= sha256.Size {
+		copy(s.h[:], name)
+	} else {
+		s.h = sha256.Sum256([]byte(name))
+	}
+	s.ck = s.h
+}
+
+func (s *noiseSymmetric) mixHash(data []byte) {
+	s.h = sha256.Sum256(append(s.h[:], data...))
+}
+
+func (s *noiseSymmetric) mixKey(ikm []byte) error {
+	keys, err := noiseHKDF(s.ck[:], ikm)
+	if err != nil {
+		return err
+	}
+	copy(s.ck[:], keys[:sha256.Size])
+	s.aead, err = newNoiseAEAD(keys[sha256.Size:])
+	s.n = 0
+	return err
+}
+
+func (s *noiseSymmetric) encryptAndHash(plaintext []byte) []byte {
+	if s.aead == nil {
+		s.mixHash(plaintext)
+		return plaintext
+	}
+	ciphertext := s.aead.Seal(nil, noiseNonce(s.n), plaintext, s.h[:])
+	s.n++
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *noiseSymmetric) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if s.aead == nil {
+		s.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+	plaintext, err := s.aead.Open(nil, noiseNonce(s.n), ciphertext, s.h[:])
+	if err != nil {
+		return nil, errNoiseHandshake
+	}
+	s.n++
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the keys of the initiator and of the responder.
+func (s *noiseSymmetric) split() (cipher.AEAD, cipher.AEAD, error) {
+	keys, err := noiseHKDF(s.ck[:], nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	initiator, err := newNoiseAEAD(keys[:sha256.Size])
+	if err != nil {
+		return nil, nil, err
+	}
+	responder, err := newNoiseAEAD(keys[sha256.Size:])
+	return initiator, responder, err
+}
+
+// noiseHKDF derives two keys from ck and ikm.
+func noiseHKDF(ck, ikm []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, ikm, ck, "", 2*sha256.Size)
+}
+
+func newNoiseAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// noiseNonce encodes n as an AESGCM nonce of the Noise specification.
+func noiseNonce(n uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// noiseHandshake is the HandshakeState of the Noise specification, running the XX or
+// IK pattern.
+type noiseHandshake struct {
+	symmetric noiseSymmetric
+	initiator bool
+	messages  [][]string
+	step      int
+
+	// proving tells which messages carry the proof of the identity key of their
+	// sender: the first one it writes once the other side knows its static key.
+	proving []bool
+
+	s, e   *ecdh.PrivateKey
+	rs, re *ecdh.PublicKey
+}
+
+// newNoiseHandshake starts a handshake with the static key s. Initiators of IK must
+// know the static key rs of the responder.
+func newNoiseHandshake(pattern NoisePattern, initiator bool, s *ecdh.PrivateKey, rs *ecdh.PublicKey) (*noiseHandshake, error) {
+	hs := &noiseHandshake{initiator: initiator, s: s}
+	switch pattern {
+	case NoiseXX:
+		hs.symmetric.init("Noise_XX_25519_AESGCM_SHA256")
+		hs.messages = [][]string{{"e"}, {"e", "ee", "s", "es"}, {"s", "se"}}
+		hs.proving = []bool{false, true, true}
+		hs.symmetric.mixHash(nil)
+	case NoiseIK:
+		hs.symmetric.init("Noise_IK_25519_AESGCM_SHA256")
+		hs.messages = [][]string{{"e", "es", "s", "ss"}, {"e", "ee", "se"}}
+		hs.proving = []bool{true, true}
+		hs.symmetric.mixHash(nil)
+		if initiator {
+			if rs == nil {
+				return nil, errors.New("the IK pattern requires the key of the remote peer")
+			}
+			hs.rs = rs
+			hs.symmetric.mixHash(rs.Bytes())
+		} else {
+			hs.symmetric.mixHash(s.PublicKey().Bytes())
+		}
+	default:
+		return nil, errors.New("unknown noise pattern")
+	}
+	return hs, nil
+}
+
+// writing returns true if the next message is to be written rather than read.
+func (hs *noiseHandshake) writing() bool {
+	return (hs.step%2 == 0) == hs.initiator
+}
+
+func (hs *noiseHandshake) finished() bool {
+	return hs.step == len(hs.messages)
+}
+
+// proves returns true if the next message carries the proof of the identity key of its
+// sender.
+func (hs *noiseHandshake) proves() bool {
+	return hs.proving[hs.step]
+}
+
+func (hs *noiseHandshake) write(payload []byte) ([]byte, error) {
+	var msg []byte
+	for _, token := range hs.messages[hs.step] {
+		switch token {
+		case "e":
+			e, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			hs.e = e
+			msg = append(msg, e.PublicKey().Bytes()...)
+			hs.symmetric.mixHash(e.PublicKey().Bytes())
+		case "s":
+			msg = append(msg, hs.symmetric.encryptAndHash(hs.s.PublicKey().Bytes())...)
+		default:
+			if err := hs.dh(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	msg = append(msg, hs.symmetric.encryptAndHash(payload)...)
+	hs.step++
+	return msg, nil
+}
+
+// read reads a message, returning its payload.
+func (hs *noiseHandshake) read(msg []byte) ([]byte, error) {
+	for _, token := range hs.messages[hs.step] {
+		switch token {
+		case "e":
+			if len(msg) < noiseKeySize {
+				return nil, errNoiseHandshake
+			}
+			re, err := ecdh.X25519().NewPublicKey(msg[:noiseKeySize])
+			if err != nil {
+				return nil, errNoiseHandshake
+			}
+			hs.re = re
+			hs.symmetric.mixHash(msg[:noiseKeySize])
+			msg = msg[noiseKeySize:]
+		case "s":
+			size := noiseKeySize
+			if hs.symmetric.aead != nil {
+				size += hs.symmetric.aead.Overhead()
+			}
+			if len(msg) < size {
+				return nil, errNoiseHandshake
+			}
+			key, err := hs.symmetric.decryptAndHash(msg[:size])
+			if err != nil {
+				return nil, err
+			}
+			rs, err := ecdh.X25519().NewPublicKey(key)
+			if err != nil {
+				return nil, errNoiseHandshake
+			}
+			hs.rs = rs
+			msg = msg[size:]
+		default:
+			if err := hs.dh(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	payload, err := hs.symmetric.decryptAndHash(msg)
+	if err != nil {
+		return nil, err
+	}
+	hs.step++
+	return payload, nil
+}
+
+// dh mixes the Diffie-Hellman of token into the key, seen from the local side.
+func (hs *noiseHandshake) dh(token string) error {
+	var local *ecdh.PrivateKey
+	var remote *ecdh.PublicKey
+	switch token {
+	case "ee":
+		local, remote = hs.e, hs.re
+	case "ss":
+		local, remote = hs.s, hs.rs
+	case "es":
+		if hs.initiator {
+			local, remote = hs.e, hs.rs
+		} else {
+			local, remote = hs.s, hs.re
+		}
+	case "se":
+		if hs.initiator {
+			local, remote = hs.s, hs.re
+		} else {
+			local, remote = hs.e, hs.rs
+		}
+	}
+	if local == nil || remote == nil {
+		return errNoiseHandshake
+	}
+
+	secret, err := local.ECDH(remote)
+	if err != nil {
+		return errNoiseHandshake
+	}
+	return hs.symmetric.mixKey(secret)
+}
+
+// split returns the keys to send and receive with once the handshake has finished.
+func (hs *noiseHandshake) split() (send, receive cipher.AEAD, err error) {
+	initiator, responder, err := hs.symmetric.split()
+	if hs.initiator {
+		return initiator, responder, err
+	}
+	return responder, initiator, err
+}
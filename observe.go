@@ -0,0 +1,40 @@
+package enet
+
+import "errors"
+
+// ServiceObserver is told whenever a host is serviced, for instrumentation such as
+// tracing. It is called as servicing starts, and the function it returns, if any, once
+// servicing returns, with the event returned or one of type EventNone. The event must
+// not be kept beyond that call.
+type ServiceObserver func(host Host) func(event Event)
+
+// ObserveService adds observer to the observers of host. Observers are called in the
+// order they were added as servicing starts, and in reverse order as it returns.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func ObserveService(host Host, observer ServiceObserver) error {
+	if observer == nil {
+		return errors.New("observer is nil")
+	}
+
+	var err error
+	observe := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("observing is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		h.observers = append(h.observers, observer)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(observe)
+	} else {
+		observe(host)
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package enet
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// hosts maps the rawHost of a host to the *enetHost wrapping it, so peers can find the
+// Go side state of their host.
+var hosts sync.Map
+
+// registerHost makes host the Go side of raw.
+func registerHost(raw rawHost, host *enetHost) {
+	hosts.Store(raw, host)
+	logHost(host, "host created")
+}
+
+// unregisterHost forgets the Go side of raw as its host is destroyed.
+func unregisterHost(raw rawHost, host *enetHost) {
+	hosts.Delete(raw)
+	host.setPacketDumper(nil)
+	logHost(host, "host destroyed")
+}
+
+func hostOf(raw rawHost) *enetHost {
+	host, ok := hosts.Load(raw)
+	if !ok {
+		return nil
+	}
+	return host.(*enetHost)
+}
+
+type outboxEntry struct {
+	next    *outboxEntry
+	peer    enetPeer
+	channel uint8
+	packet  rawPacket
+
+	// session is that of the peer as last tracked when the packet was queued, see
+	// outbox.track, if tracked is set.
+	session uint32
+	tracked bool
+}
+
+// outbox is a lock-free queue of packets waiting to be handed to enet by the goroutine
+// servicing the host. Any goroutine may push.
+type outbox struct {
+	head   atomic.Pointer[outboxEntry]
+	queued atomic.Int64
+
+	// closed is set as the host is destroyed, refusing packets from then on.
+	closed atomic.Bool
+
+	// sessions holds the session of the peers of the host as they connected, for
+	// packets to be queued with without reading the state of enet, which only the
+	// goroutine servicing the host may.
+	sessions sync.Map
+}
+
+// track records the session of peer, see enetPeer.session, as it connects. Must only be
+// called by the goroutine servicing the host.
+func (o *outbox) track(peer enetPeer) {
+	o.sessions.Store(peer, peer.session())
+}
+
+// session returns the session of peer as last tracked, false if it never was.
+func (o *outbox) session(peer enetPeer) (uint32, bool) {
+	session, ok := o.sessions.Load(peer)
+	if !ok {
+		return 0, false
+	}
+	return session.(uint32), true
+}
+
+// push queues entry. Returns false if the outbox is closed, leaving the packet to the
+// caller.
+func (o *outbox) push(entry *outboxEntry) bool {
+	if o.closed.Load() {
+		return false
+	}
+	o.queued.Add(1)
+	for {
+		head := o.head.Load()
+		entry.next = head
+		if o.head.CompareAndSwap(head, entry) {
+			break
+		}
+	}
+	if o.closed.Load() {
+		// The outbox closed as the entry was pushed, and may have been drained before
+		// it was, so drain it again.
+		o.drain()
+	}
+	return true
+}
+
+// flush sends all queued packets in the order they were pushed. Must only be called
+// by the goroutine servicing the host.
+func (o *outbox) flush() {
+	entry := o.head.Swap(nil)
+
+	// The list is newest first, reverse it to keep the send order.
+	var ordered *outboxEntry
+	for entry != nil {
+		next := entry.next
+		entry.next = ordered
+		ordered = entry
+		entry = next
+	}
+
+	for entry = ordered; entry != nil; entry = entry.next {
+		o.queued.Add(-1)
+		if entry.tracked && entry.peer.session() != entry.session {
+			// The peer disconnected and its slot went to another connection, which
+			// must not get the packet.
+			destroyRaw(entry.packet)
+			logSendError(entry.peer, entry.channel, errors.New("peer of queued packet disconnected"))
+			continue
+		}
+		if !entry.peer.sendRaw(entry.channel, entry.packet) {
+			// Peer went away in the meantime, the packet is still ours to free.
+			destroyRaw(entry.packet)
+			logSendError(entry.peer, entry.channel, errors.New("unable to send queued packet"))
+		}
+	}
+}
+
+// discard closes the outbox as the host is destroyed, freeing all queued packets
+// without sending them.
+func (o *outbox) discard() {
+	o.closed.Store(true)
+	o.drain()
+}
+
+// drain frees all queued packets without sending them.
+func (o *outbox) drain() {
+	for entry := o.head.Swap(nil); entry != nil; entry = entry.next {
+		o.queued.Add(-1)
+		destroyRaw(entry.packet)
+	}
+}
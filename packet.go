@@ -1,77 +1,54 @@
 package enet
 
-// #include "enet.h"
-import "C"
-import (
-	"errors"
-	"unsafe"
-)
-
-// PacketFlags are bit constants
+// PacketFlags are bit constants, with the values of the C library
 type PacketFlags uint32
 
 const (
 	// PacketFlagReliable packets must be received by the target peer and resend attempts
 	// should be made until the packet is delivered
-	PacketFlagReliable PacketFlags = C.ENET_PACKET_FLAG_RELIABLE
+	PacketFlagReliable PacketFlags = 1 << 0
 
 	// PacketFlagUnsequenced packets will not be sequenced with other packets not supported
 	// for reliable packets
-	PacketFlagUnsequenced = C.ENET_PACKET_FLAG_UNSEQUENCED
+	PacketFlagUnsequenced = 1 << 1
 
 	// PacketFlagNoAllocate packets will not allocate data, and user must supply it instead
-	PacketFlagNoAllocate = C.ENET_PACKET_FLAG_NO_ALLOCATE
+	PacketFlagNoAllocate = 1 << 2
 
 	// PacketFlagUnreliableFragment packets will be fragmented using unreliable (instead of
 	// reliable) sends if it exceeds the MTU
-	PacketFlagUnreliableFragment = C.ENET_PACKET_FLAG_UNRELIABLE_FRAGMENTED
+	PacketFlagUnreliableFragment = 1 << 3
 
 	// PacketFlagSent specifies whether the packet has been sent from all queues it has been
 	// entered into
-	PacketFlagSent = C.ENET_PACKET_FLAG_SENT
+	PacketFlagSent = 1 << 8
 )
 
 // Packet may be sent to or received from a peer
 type Packet interface {
-	Destroy()
+	// Destroy frees the packet. Packets that have been sent or broadcast are owned by
+	// enet and must not be destroyed. Returns an error if the packet has already been
+	// destroyed or handed over to enet.
+	Destroy() error
+
 	GetData() []byte
 	GetFlags() PacketFlags
 }
 
-type enetPacket struct {
-	cPacket *C.ENetPacket
-}
-
-func (packet enetPacket) Destroy() {
-	C.enet_packet_destroy(packet.cPacket)
-}
-
-func (packet enetPacket) GetData() []byte {
-	return C.GoBytes(
-		unsafe.Pointer(packet.cPacket.data),
-		(C.int)(packet.cPacket.dataLength),
-	)
-}
-
-func (packet enetPacket) GetFlags() PacketFlags {
-	return (PacketFlags)(packet.cPacket.flags)
-}
-
-// NewPacket creates a new packet to send to peers
-func NewPacket(data []byte, flags PacketFlags) (Packet, error) {
-	buffer := C.CBytes(data)
-	packet := C.enet_packet_create(
-		buffer,
-		(C.size_t)(len(data)),
-		(C.uint32_t)(flags),
-	)
-	C.free(buffer)
-
-	if packet == nil {
-		return nil, errors.New("unable to create packet")
+// toEnetPacket returns packet as a packet of the backend, copying packets of other
+// implementations. The source packet is destroyed in that case.
+func toEnetPacket(packet Packet) (*enetPacket, error) {
+	switch p := packet.(type) {
+	case *enetPacket:
+		return p, nil
+	case safePacket:
+		return toEnetPacket(p.Packet)
 	}
 
-	return enetPacket{
-		cPacket: packet,
-	}, nil
+	ret, err := NewPacket(packet.GetData(), packet.GetFlags())
+	if err != nil {
+		return nil, err
+	}
+	packet.Destroy()
+	return ret.(*enetPacket), nil
 }
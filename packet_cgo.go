@@ -0,0 +1,128 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+import "C"
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+// rawPacket is a packet of the backend, once taken out of its enetPacket.
+type rawPacket = *C.ENetPacket
+
+type enetPacket struct {
+	cPacket *C.ENetPacket
+
+	// released is set once the packet has been destroyed or handed over to enet, after
+	// which the C packet must no longer be touched.
+	released atomic.Bool
+}
+
+func (packet *enetPacket) Destroy() error {
+	cPacket, err := packet.take()
+	if err != nil {
+		return err
+	}
+	leakUntrack(unsafe.Pointer(cPacket))
+	C.enet_packet_destroy(cPacket)
+	return nil
+}
+
+func (packet *enetPacket) GetData() []byte {
+	if packet.cPacket == nil || packet.released.Load() {
+		return nil
+	}
+	return C.GoBytes(
+		unsafe.Pointer(packet.cPacket.data),
+		(C.int)(packet.cPacket.dataLength),
+	)
+}
+
+func (packet *enetPacket) GetFlags() PacketFlags {
+	if packet.cPacket == nil || packet.released.Load() {
+		return 0
+	}
+	return (PacketFlags)(packet.cPacket.flags)
+}
+
+// take hands the ownership of the C packet to the caller, which is about to pass it
+// to enet. Fails if the packet has already been released.
+func (packet *enetPacket) take() (*C.ENetPacket, error) {
+	if packet.cPacket == nil {
+		return nil, errors.New("no packet")
+	}
+	if !packet.released.CompareAndSwap(false, true) {
+		return nil, errors.New("packet has already been destroyed or sent")
+	}
+	return packet.cPacket, nil
+}
+
+// untake gives back the ownership of a packet enet refused to take.
+func (packet *enetPacket) untake() {
+	packet.released.Store(false)
+}
+
+// detach moves the ownership of the C packet to a new wrapper, leaving packet
+// released.
+func (packet *enetPacket) detach() (*enetPacket, error) {
+	cPacket, err := packet.take()
+	if err != nil {
+		return nil, err
+	}
+	return &enetPacket{cPacket: cPacket}, nil
+}
+
+// handOver takes the C packet for a queue that hands it to enet later, see outbox.
+func (packet *enetPacket) handOver() (rawPacket, error) {
+	cPacket, err := packet.take()
+	if err != nil {
+		return nil, err
+	}
+	leakUntrack(unsafe.Pointer(cPacket))
+	return cPacket, nil
+}
+
+// rawPacketContents returns the data and flags of a packet taken from an enetPacket.
+func rawPacketContents(packet rawPacket) ([]byte, PacketFlags) {
+	var data []byte
+	if packet.dataLength > 0 {
+		data = unsafe.Slice((*byte)(unsafe.Pointer(packet.data)), int(packet.dataLength))
+	}
+	return data, PacketFlags(packet.flags)
+}
+
+// destroyRaw frees a packet that was handed over but never reached enet.
+func destroyRaw(packet rawPacket) {
+	C.enet_packet_destroy(packet)
+}
+
+// destroyUnreferenced frees a packet that was handed over to several peers, in case
+// none of them took it.
+func destroyUnreferenced(packet rawPacket) {
+	if packet.referenceCount == 0 {
+		C.enet_packet_destroy(packet)
+	}
+}
+
+// NewPacket creates a new packet to send to peers
+func NewPacket(data []byte, flags PacketFlags) (Packet, error) {
+	buffer := C.CBytes(data)
+	packet := C.enet_packet_create(
+		buffer,
+		(C.size_t)(len(data)),
+		(C.uint32_t)(flags),
+	)
+	C.free(buffer)
+
+	if packet == nil {
+		return nil, errors.New("unable to create packet")
+	}
+	leakTrack(unsafe.Pointer(packet), leakPacket)
+
+	return &enetPacket{
+		cPacket: packet,
+	}, nil
+}
@@ -0,0 +1,90 @@
+//go:build purego || (js && wasm)
+
+package enet
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+// rawPacket is a packet of the backend, once taken out of its enetPacket.
+type rawPacket = *protocol.Packet
+
+type enetPacket struct {
+	goPacket *protocol.Packet
+
+	// released is set once the packet has been destroyed or handed over to enet, after
+	// which the packet must no longer be touched.
+	released atomic.Bool
+}
+
+func (packet *enetPacket) Destroy() error {
+	_, err := packet.take()
+	return err
+}
+
+func (packet *enetPacket) GetData() []byte {
+	if packet.goPacket == nil || packet.released.Load() {
+		return nil
+	}
+	return append([]byte(nil), packet.goPacket.Data...)
+}
+
+func (packet *enetPacket) GetFlags() PacketFlags {
+	if packet.goPacket == nil || packet.released.Load() {
+		return 0
+	}
+	return PacketFlags(packet.goPacket.Flags)
+}
+
+// take hands the ownership of the packet to the caller, which is about to pass it to
+// a host. Fails if the packet has already been released.
+func (packet *enetPacket) take() (*protocol.Packet, error) {
+	if packet.goPacket == nil {
+		return nil, errors.New("no packet")
+	}
+	if !packet.released.CompareAndSwap(false, true) {
+		return nil, errors.New("packet has already been destroyed or sent")
+	}
+	return packet.goPacket, nil
+}
+
+// untake gives back the ownership of a packet the host refused to take.
+func (packet *enetPacket) untake() {
+	packet.released.Store(false)
+}
+
+// detach moves the ownership of the packet to a new wrapper, leaving packet released.
+func (packet *enetPacket) detach() (*enetPacket, error) {
+	goPacket, err := packet.take()
+	if err != nil {
+		return nil, err
+	}
+	return &enetPacket{goPacket: goPacket}, nil
+}
+
+// handOver takes the packet for a queue that hands it to the host later, see outbox.
+func (packet *enetPacket) handOver() (rawPacket, error) {
+	return packet.take()
+}
+
+// rawPacketContents returns the data and flags of a packet taken from an enetPacket.
+func rawPacketContents(packet rawPacket) ([]byte, PacketFlags) {
+	return packet.Data, PacketFlags(packet.Flags)
+}
+
+// destroyRaw drops a packet that was handed over but never reached the host.
+func destroyRaw(packet rawPacket) {}
+
+// destroyUnreferenced drops a packet that was handed over to several peers, in case
+// none of them took it.
+func destroyUnreferenced(packet rawPacket) {}
+
+// NewPacket creates a new packet to send to peers
+func NewPacket(data []byte, flags PacketFlags) (Packet, error) {
+	return &enetPacket{
+		goPacket: protocol.NewPacket(data, uint32(flags)),
+	}, nil
+}
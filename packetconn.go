@@ -0,0 +1,162 @@
+package enet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// packetConnQueueSize is the number of datagrams a packet connection buffers before it
+// starts dropping them.
+const packetConnQueueSize = 256
+
+type datagram struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// HostPacketConn returns a net.PacketConn sharing the UDP socket of host, so libraries
+// expecting one, such as STUN clients, can be used alongside enet. Received datagrams
+// for which accept returns true are handed to the connection instead of enet, all
+// others are processed by enet as usual. The accept function is called by the
+// goroutine servicing the host and must not retain the data.
+//
+// The connection must be created by the goroutine servicing the host, or with a
+// SafeHost. It may be used from any goroutine afterwards, but only receives
+// datagrams while the host is being serviced. Datagrams are dropped if they are not
+// read fast enough.
+func HostPacketConn(host Host, accept func(data []byte) bool) (net.PacketConn, error) {
+	if accept == nil {
+		return nil, errors.New("an accept function is required")
+	}
+
+	var conn *hostPacketConn
+	var err error
+	create := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("packet connections are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		conn = &hostPacketConn{
+			host:     h,
+			accept:   accept,
+			queue:    make(chan datagram, packetConnQueueSize),
+			closed:   make(chan struct{}),
+			local:    h.localUDPAddr(),
+			deadline: newConnDeadline(),
+		}
+		conn.id = h.intercepts.add(h, conn.intercept)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(create)
+	} else {
+		create(host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+type hostPacketConn struct {
+	host   *enetHost
+	id     int
+	accept func(data []byte) bool
+	local  *net.UDPAddr
+
+	queue     chan datagram
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	deadline *connDeadline
+}
+
+func (conn *hostPacketConn) intercept(addr rawAddress, data []byte) bool {
+	if !conn.accept(data) {
+		return false
+	}
+
+	select {
+	case conn.queue <- datagram{data: append([]byte(nil), data...), addr: udpAddrOf(addr)}:
+	default:
+		// Nobody is reading, drop it like a full socket buffer would.
+	}
+	return true
+}
+
+func (conn *hostPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-conn.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+
+	select {
+	case dgram := <-conn.queue:
+		return copy(p, dgram.data), dgram.addr, nil
+	case <-conn.closed:
+		return 0, nil, net.ErrClosed
+	case <-conn.deadline.read.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (conn *hostPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-conn.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if conn.deadline.write.exceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("address is not a UDP address")
+	}
+
+	if err := conn.host.socketSend(udpAddr, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (conn *hostPacketConn) Close() error {
+	err := net.ErrClosed
+	conn.closeOnce.Do(func() {
+		conn.host.intercepts.remove(conn.id)
+		close(conn.closed)
+		err = nil
+	})
+	return err
+}
+
+func (conn *hostPacketConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (conn *hostPacketConn) SetDeadline(t time.Time) error {
+	conn.deadline.read.set(t)
+	conn.deadline.write.set(t)
+	return nil
+}
+
+func (conn *hostPacketConn) SetReadDeadline(t time.Time) error {
+	conn.deadline.read.set(t)
+	return nil
+}
+
+func (conn *hostPacketConn) SetWriteDeadline(t time.Time) error {
+	conn.deadline.write.set(t)
+	return nil
+}
@@ -0,0 +1,185 @@
+package enet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// PacketDirection tells whether a dumped packet was sent or received
+type PacketDirection int
+
+const (
+	// PacketSent is a packet handed to the host to be sent
+	PacketSent PacketDirection = iota
+
+	// PacketReceived is a packet returned by Host.Service
+	PacketReceived
+)
+
+func (d PacketDirection) String() string {
+	switch d {
+	case PacketSent:
+		return "sent"
+	case PacketReceived:
+		return "received"
+	default:
+		return fmt.Sprintf("PacketDirection(%d)", int(d))
+	}
+}
+
+// PacketDump describes a packet sent or received by a host
+type PacketDump struct {
+	Direction PacketDirection
+
+	// Peer the packet was sent to or received from, nil for broadcasts
+	Peer Peer
+
+	Channel uint8
+	Flags   PacketFlags
+
+	// Size is the length of the whole payload, which may be longer than Payload
+	Size int
+
+	// Payload is the payload, truncated to PacketDumpConfig.MaxPayload. It is only
+	// valid during the call to the hook and must be copied to be kept.
+	Payload []byte
+
+	// HexDump is set if the payload should be hex dumped by String
+	HexDump bool
+}
+
+// String formats the dump as one summary line, followed by a hex dump of the payload if
+// configured
+func (d PacketDump) String() string {
+	var b strings.Builder
+	b.WriteString(d.Direction.String())
+	if d.Peer == nil {
+		b.WriteString(" broadcast")
+	} else {
+		fmt.Fprintf(&b, " peer=%d", d.Peer.GetID())
+		if addr := d.Peer.GetAddress(); addr != nil {
+			fmt.Fprintf(&b, " address=%s", net.JoinHostPort(addr.String(), strconv.Itoa(int(addr.GetPort()))))
+		}
+	}
+	fmt.Fprintf(&b, " channel=%d flags=%#x size=%d", d.Channel, uint32(d.Flags), d.Size)
+	if d.HexDump && len(d.Payload) > 0 {
+		b.WriteByte('\n')
+		b.WriteString(strings.TrimSuffix(hex.Dump(d.Payload), "\n"))
+		if len(d.Payload) < d.Size {
+			fmt.Fprintf(&b, "\n... %d more bytes", d.Size-len(d.Payload))
+		}
+	}
+	return b.String()
+}
+
+// PacketDumpConfig configures how packets are dumped
+type PacketDumpConfig struct {
+	// MaxPayload truncates the payloads passed to the hook, 0 passing whole payloads
+	// and a negative value none at all
+	MaxPayload int
+
+	// HexDump makes PacketDump.String hex dump the payload
+	HexDump bool
+}
+
+type packetDumper struct {
+	config PacketDumpConfig
+	fn     func(PacketDump)
+}
+
+// packetDumpers counts the hosts dumping packets, so sends skip looking their host up
+// when none does.
+var packetDumpers atomic.Int32
+
+// DumpPackets calls fn with every packet host sends or receives, for debugging the
+// protocol of an application without capturing its traffic. Packets are dumped as they
+// are handed to the host, including broadcasts and packets queued with SendAsync, and
+// as Service returns them. fn is called by the goroutine servicing the host and must
+// not block it. Passing a nil fn stops dumping.
+//
+// Unlike most host features it is safe to call from any goroutine, so dumping can be
+// toggled at runtime, for example from a debug endpoint.
+func DumpPackets(host Host, config PacketDumpConfig, fn func(PacketDump)) error {
+	if safe, ok := host.(*safeHost); ok {
+		host = safe.host
+	}
+	h, ok := host.(*enetHost)
+	if !ok {
+		return errors.New("packet dumps are only supported on enet hosts")
+	}
+
+	var dumper *packetDumper
+	if fn != nil {
+		dumper = &packetDumper{config: config, fn: fn}
+	}
+	h.setPacketDumper(dumper)
+	return nil
+}
+
+// setPacketDumper replaces the dumper of the host, nil stopping dumps.
+func (host *enetHost) setPacketDumper(dumper *packetDumper) {
+	old := host.dumper.Swap(dumper)
+	switch {
+	case old == nil && dumper != nil:
+		packetDumpers.Add(1)
+	case old != nil && dumper == nil:
+		packetDumpers.Add(-1)
+	}
+}
+
+// dumpPacket passes a packet to the dumper of the host, if any.
+func (host *enetHost) dumpPacket(direction PacketDirection, peer Peer, channel uint8, data []byte, flags PacketFlags) {
+	dumper := host.dumper.Load()
+	if dumper == nil {
+		return
+	}
+
+	payload := data
+	if max := dumper.config.MaxPayload; max < 0 {
+		payload = nil
+	} else if max > 0 && len(payload) > max {
+		payload = payload[:max]
+	}
+	dumper.fn(PacketDump{
+		Direction: direction,
+		Peer:      peer,
+		Channel:   channel,
+		Flags:     flags,
+		Size:      len(data),
+		Payload:   payload,
+		HexDump:   dumper.config.HexDump,
+	})
+}
+
+// dumpSent dumps a packet handed to the host of peer.
+func dumpSent(peer enetPeer, channel uint8, packet rawPacket) {
+	if packetDumpers.Load() == 0 {
+		return
+	}
+	if host := peer.host(); host != nil {
+		data, flags := rawPacketContents(packet)
+		host.dumpPacket(PacketSent, peer, channel, data, flags)
+	}
+}
+
+// dumpBroadcast dumps a packet broadcast by the host.
+func (host *enetHost) dumpBroadcast(channel uint8, packet rawPacket) {
+	if packetDumpers.Load() == 0 {
+		return
+	}
+	data, flags := rawPacketContents(packet)
+	host.dumpPacket(PacketSent, nil, channel, data, flags)
+}
+
+// dumpReceived dumps the packet of event, if it carries one.
+func (host *enetHost) dumpReceived(event *enetEvent) {
+	if packetDumpers.Load() == 0 || event.GetType() != EventReceive {
+		return
+	}
+	host.dumpPacket(PacketReceived, event.GetPeer(), event.GetChannelID(), event.GetPacketDataUnsafe(), event.GetPacket().GetFlags())
+}
@@ -0,0 +1,42 @@
+package enet
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// HandlerPanic is the error reported when an event or message handler panics
+type HandlerPanic struct {
+	// Peer is the peer whose event was being handled
+	Peer Peer
+
+	// Value is the value passed to panic
+	Value any
+
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (err *HandlerPanic) Error() string {
+	return fmt.Sprintf("handler panicked: %v", err.Value)
+}
+
+// PanicHandler is called with panics recovered from handlers
+type PanicHandler func(err *HandlerPanic)
+
+// callRecover runs fn, turning a panic into a HandlerPanic for peer. Returns nil if fn
+// returned normally.
+func callRecover(peer Peer, fn func()) (err *HandlerPanic) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &HandlerPanic{
+				Peer:  peer,
+				Value: value,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	fn()
+	return nil
+}
@@ -0,0 +1,332 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapBlockSection   = 0x0a0d0d0a
+	pcapBlockInterface = 0x00000001
+	pcapBlockPacket    = 0x00000006
+	pcapByteOrderMagic = 0x1a2b3c4d
+
+	pcapOptionEnd        = 0
+	pcapOptionComment    = 1
+	pcapOptionFlags      = 2
+	pcapOptionResolution = 9
+
+	// pcapLinkTypeRaw is LINKTYPE_RAW, packets starting with their IPv4 or IPv6 header.
+	pcapLinkTypeRaw = 101
+
+	// pcapMaxPacket is the longest IP packet written, longer ones are truncated.
+	pcapMaxPacket = 65535
+
+	pcapInbound  = 1
+	pcapOutbound = 2
+)
+
+// PcapWriter writes the traffic of hosts as a pcapng capture, which Wireshark reads.
+// Datagrams are wrapped in fake IP and UDP headers carrying the addresses and ports of
+// the host and its peers, so the ENet dissector of Wireshark picks them up by port.
+//
+// Traffic can be captured before or after ENet: Transport captures the datagrams on
+// the wire, with the protocol headers of ENet, while CapturePackets captures the
+// packets the host sends and receives, as the application sees them.
+type PcapWriter interface {
+	// Transport wraps transport, capturing every datagram a host created on it with
+	// NewTransportHost sends or receives
+	Transport(transport Transport) Transport
+
+	// CapturePackets captures every packet host sends or receives, commented with
+	// their channel and flags. It uses the hook of DumpPackets, replacing any, and
+	// capturing stops with DumpPackets(host, PacketDumpConfig{}, nil).
+	CapturePackets(host Host) error
+
+	// WriteDatagram writes a datagram sent from src to dst
+	WriteDatagram(src, dst *net.UDPAddr, data []byte) error
+
+	// Err returns the first error the writer ran into while capturing
+	Err() error
+}
+
+type pcapWriter struct {
+	lock        sync.Mutex
+	w           io.Writer
+	wroteHeader bool
+	err         error
+	buffer      []byte
+}
+
+// NewPcapWriter creates a writer writing a pcapng capture to w
+func NewPcapWriter(w io.Writer) PcapWriter {
+	return &pcapWriter{
+		w: w,
+	}
+}
+
+func (pw *pcapWriter) WriteDatagram(src, dst *net.UDPAddr, data []byte) error {
+	return pw.write(time.Now(), src, dst, data, 0, "")
+}
+
+func (pw *pcapWriter) Err() error {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	return pw.err
+}
+
+func (pw *pcapWriter) write(t time.Time, src, dst *net.UDPAddr, data []byte, direction uint32, comment string) error {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	b := pw.buffer[:0]
+	if !pw.wroteHeader {
+		b = appendPcapHeader(b)
+	}
+	b = appendPcapPacket(b, t, src, dst, data, direction, comment)
+	pw.buffer = b
+
+	if _, err := pw.w.Write(b); err != nil {
+		if pw.err == nil {
+			pw.err = err
+		}
+		return err
+	}
+	pw.wroteHeader = true
+	return nil
+}
+
+func (pw *pcapWriter) CapturePackets(host Host) error {
+	if safe, ok := host.(*safeHost); ok {
+		host = safe.host
+	}
+	h, ok := host.(*enetHost)
+	if !ok {
+		return errors.New("packet captures are only supported on enet hosts")
+	}
+	local := h.localUDPAddr()
+
+	return DumpPackets(host, PacketDumpConfig{}, func(dump PacketDump) {
+		remote := pcapBroadcastAddr(local)
+		if dump.Peer != nil {
+			remote = peerUDPAddr(dump.Peer.GetAddress())
+		}
+		comment := fmt.Sprintf("channel=%d flags=%#x", dump.Channel, uint32(dump.Flags))
+		if dump.Direction == PacketReceived {
+			pw.write(time.Now(), remote, local, dump.Payload, pcapInbound, comment)
+		} else {
+			pw.write(time.Now(), local, remote, dump.Payload, pcapOutbound, comment)
+		}
+	})
+}
+
+func (pw *pcapWriter) Transport(transport Transport) Transport {
+	return &pcapTransport{
+		Transport: transport,
+		writer:    pw,
+	}
+}
+
+// pcapTransport captures the datagrams of the transport it wraps.
+type pcapTransport struct {
+	Transport
+	writer *pcapWriter
+}
+
+func (t *pcapTransport) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := t.Transport.ReadFrom(p)
+	if err == nil {
+		t.writer.write(time.Now(), transportUDPAddr(addr), transportUDPAddr(t.LocalAddr()), p[:n], pcapInbound, "")
+	}
+	return n, addr, err
+}
+
+func (t *pcapTransport) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := t.Transport.WriteTo(p, addr)
+	if err == nil {
+		t.writer.write(time.Now(), transportUDPAddr(t.LocalAddr()), transportUDPAddr(addr), p[:n], pcapOutbound, "")
+	}
+	return n, err
+}
+
+// pcapBroadcastAddr is the address broadcasts from local are captured as sent to.
+func pcapBroadcastAddr(local *net.UDPAddr) *net.UDPAddr {
+	if local.IP.To4() != nil {
+		return &net.UDPAddr{IP: net.IPv4bcast, Port: local.Port}
+	}
+	return &net.UDPAddr{IP: net.IPv6linklocalallnodes, Port: local.Port}
+}
+
+// appendPcapHeader appends the section header block and the block of the only
+// interface of the capture.
+func appendPcapHeader(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, pcapBlockSection)
+	b = binary.LittleEndian.AppendUint32(b, 28)
+	b = binary.LittleEndian.AppendUint32(b, pcapByteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, ^uint64(0))
+	b = binary.LittleEndian.AppendUint32(b, 28)
+
+	// Timestamps are in nanoseconds.
+	b = binary.LittleEndian.AppendUint32(b, pcapBlockInterface)
+	b = binary.LittleEndian.AppendUint32(b, 32)
+	b = binary.LittleEndian.AppendUint16(b, pcapLinkTypeRaw)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, pcapMaxPacket)
+	b = appendPcapOption(b, pcapOptionResolution, []byte{9})
+	b = appendPcapOption(b, pcapOptionEnd, nil)
+	b = binary.LittleEndian.AppendUint32(b, 32)
+	return b
+}
+
+// appendPcapPacket appends an enhanced packet block holding data in fake IP and UDP
+// headers.
+func appendPcapPacket(b []byte, t time.Time, src, dst *net.UDPAddr, data []byte, direction uint32, comment string) []byte {
+	start := len(b)
+	b = binary.LittleEndian.AppendUint32(b, pcapBlockPacket)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	ts := uint64(t.UnixNano())
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	lengths := len(b)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+
+	packet := len(b)
+	b, original := appendUDPPacket(b, src, dst, data)
+	captured := len(b) - packet
+	binary.LittleEndian.PutUint32(b[lengths:], uint32(captured))
+	binary.LittleEndian.PutUint32(b[lengths+4:], uint32(original))
+	b = appendPcapPadding(b, captured)
+
+	if direction != 0 {
+		b = appendPcapOption(b, pcapOptionFlags, binary.LittleEndian.AppendUint32(nil, direction))
+	}
+	if comment != "" {
+		b = appendPcapOption(b, pcapOptionComment, []byte(comment))
+	}
+	if direction != 0 || comment != "" {
+		b = appendPcapOption(b, pcapOptionEnd, nil)
+	}
+
+	length := uint32(len(b) - start + 4)
+	binary.LittleEndian.PutUint32(b[start+4:], length)
+	return binary.LittleEndian.AppendUint32(b, length)
+}
+
+func appendPcapOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPcapPadding(b, len(value))
+}
+
+// appendPcapPadding pads a field of length bytes to a multiple of 4 bytes.
+func appendPcapPadding(b []byte, length int) []byte {
+	for ; length%4 != 0; length++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// appendUDPPacket appends data wrapped in IP and UDP headers, truncated to
+// pcapMaxPacket bytes, and returns the length of the whole packet. The packet is IPv4
+// if both addresses are, or one is and the other is unspecified, IPv6 otherwise.
+func appendUDPPacket(b []byte, src, dst *net.UDPAddr, data []byte) ([]byte, int) {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	switch {
+	case srcIP == nil && dstIP != nil && src.IP.IsUnspecified():
+		srcIP = net.IPv4zero.To4()
+	case dstIP == nil && srcIP != nil && dst.IP.IsUnspecified():
+		dstIP = net.IPv4zero.To4()
+	}
+	ipv4 := srcIP != nil && dstIP != nil
+	if !ipv4 {
+		srcIP, dstIP = pcapIPv6(src.IP), pcapIPv6(dst.IP)
+	}
+
+	headers := 20 + 8
+	if !ipv4 {
+		headers = 40 + 8
+	}
+	original := headers + len(data)
+	truncated := len(data) > pcapMaxPacket-headers
+	if truncated {
+		data = data[:pcapMaxPacket-headers]
+	}
+	udpLength := uint16(min(original-headers+8, 0xffff))
+
+	udp := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], udpLength)
+	udp = append(udp, data...)
+
+	if ipv4 {
+		header := len(b)
+		b = append(b, 0x45, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(min(original, 0xffff)))
+		b = append(b, 0, 0, 0x40, 0, 64, 17, 0, 0)
+		b = append(b, srcIP...)
+		b = append(b, dstIP...)
+		binary.BigEndian.PutUint16(b[header+10:], internetChecksum(0, b[header:]))
+	} else {
+		b = append(b, 0x60, 0, 0, 0)
+		b = binary.BigEndian.AppendUint16(b, udpLength)
+		b = append(b, 17, 64)
+		b = append(b, srcIP...)
+		b = append(b, dstIP...)
+
+		// The UDP checksum is mandatory over IPv6, but can't be computed over truncated
+		// data.
+		if truncated {
+			return append(b, udp...), original
+		}
+		var pseudo []byte
+		pseudo = append(pseudo, srcIP...)
+		pseudo = append(pseudo, dstIP...)
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(udpLength))
+		pseudo = append(pseudo, 0, 0, 0, 17)
+		sum := internetChecksum(internetSum(0, pseudo), udp)
+		if sum == 0 {
+			sum = 0xffff
+		}
+		binary.BigEndian.PutUint16(udp[6:], sum)
+	}
+	return append(b, udp...), original
+}
+
+func pcapIPv6(ip net.IP) net.IP {
+	if ip16 := ip.To16(); ip16 != nil {
+		return ip16
+	}
+	return net.IPv6unspecified
+}
+
+// internetSum adds data to the one's complement sum of an internet checksum.
+func internetSum(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+// internetChecksum finishes the internet checksum of data, continuing sum.
+func internetChecksum(sum uint32, data []byte) uint16 {
+	sum = internetSum(sum, data)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -1,18 +1,27 @@
 package enet
 
-// #include "enet.h"
-import "C"
 import (
-	"encoding/binary"
-	"fmt"
-	"math"
-	"unsafe"
+	"crypto/ed25519"
+	"errors"
+	"time"
 )
 
 // Peer is a peer which data packets may be sent or received from
 type Peer interface {
 	GetAddress() Address
 
+	// GetID returns the index of the peer within its host. IDs are reused once a
+	// peer disconnects.
+	GetID() uint32
+
+	// Identity returns the static public key the peer authenticated with, see
+	// EnableNoise. Returns nil for peers that have not.
+	Identity() []byte
+
+	// PublicKey returns the long-term identity key the peer proved during its Noise
+	// handshake, see NoiseConfig.IdentityKey. Returns nil for peers that have none.
+	PublicKey() ed25519.PublicKey
+
 	Disconnect(data uint32)
 	DisconnectNow(data uint32)
 	DisconnectLater(data uint32)
@@ -32,6 +41,12 @@ type Peer interface {
 	SendString(str string, channel uint8, flags PacketFlags) error
 	SendPacket(packet Packet, channel uint8) error
 
+	// SendAsync queues data to be sent to the peer the next time its host is serviced.
+	// Unlike the other send methods it is safe to call from any goroutine. Packets
+	// for peers that are no longer connected by then are dropped, as are those for
+	// peers whose slot went to another connection in the meantime.
+	SendAsync(data []byte, channel uint8, flags PacketFlags) error
+
 	// SetData sets an arbitrary value against a peer. This is useful to attach some
 	// application-specific data for future use, such as an identifier.
 	//
@@ -48,6 +63,11 @@ type Peer interface {
 	// GetData returns an application-specific value that's been set
 	// against this peer. This returns nil if no data has been set.
 	//
+	// The returned slice is not a copy: it views the value the peer holds, in C memory
+	// when built with cgo, and is only valid until SetData is next called for the peer.
+	// It must not be modified, and must be copied to be kept longer. Peers of a SafeHost
+	// return a copy.
+	//
 	// http://enet.bespin.org/structENetPeer.html#a1873959810db7ac7a02da90469ee384e
 	GetData() []byte
 
@@ -57,46 +77,34 @@ type Peer interface {
 	GetBytesReceived() uint64
 	GetPacketsSent() uint64
 	GetPacketsLost() uint64
-}
 
-type enetPeer struct {
-	cPeer *C.ENetPeer
-}
+	// EstimatedBandwidth estimates the bandwidth available to send to the peer in bytes
+	// per second, from the throttle and window of the peer, its round trip time and
+	// the loss of reliable packets, capped by the bandwidth limits of both sides. Meant
+	// to adapt send rates, such as of snapshots, to the connection. Returns 0 if it
+	// cannot be estimated.
+	EstimatedBandwidth() uint32
 
-func (peer enetPeer) GetAddress() Address {
-	return &enetAddress{
-		cAddr: peer.cPeer.address,
-	}
-}
-
-func (peer enetPeer) Disconnect(data uint32) {
-	C.enet_peer_disconnect(
-		peer.cPeer,
-		(C.uint32_t)(data),
-	)
-}
+	// SimulateConditions degrades the connection to the peer with artificial latency,
+	// jitter and loss, for example to test how the game plays for one player with a bad
+	// connection during a live playtest. Latency and jitter delay the datagrams sent to
+	// the peer, so round trips grow by them, while loss, from 0 to 1, drops datagrams
+	// both ways. Passing zeros ends the simulation, as does the peer disconnecting.
+	SimulateConditions(latency, jitter time.Duration, loss float64) error
 
-func (peer enetPeer) DisconnectNow(data uint32) {
-	C.enet_peer_disconnect_now(
-		peer.cPeer,
-		(C.uint32_t)(data),
-	)
-}
+	// ClockOffset returns how far ahead of the local clock the clock of the peer is,
+	// negative if it is behind, as estimated by SyncClocks. Returns 0 until the clock of
+	// the peer has been sampled. It is safe to call from any goroutine.
+	ClockOffset() time.Duration
 
-func (peer enetPeer) DisconnectLater(data uint32) {
-	C.enet_peer_disconnect_later(
-		peer.cPeer,
-		(C.uint32_t)(data),
-	)
-}
+	// Tag adds tags to the peer, such as its team or region, to select peers by with
+	// SelectTagged or TaggedPeers. Tags are dropped once the ID of the peer is reused by
+	// another connection. Tag, Untag and Tags are safe to call from any goroutine.
+	Tag(tags ...string)
+	Untag(tags ...string)
 
-func (peer enetPeer) SetTimeout(limit uint32, min uint32, max uint32) {
-	C.enet_peer_timeout(
-		peer.cPeer,
-		(C.uint32_t)(limit),
-		(C.uint32_t)(min),
-		(C.uint32_t)(max),
-	)
+	// Tags returns the tags of the peer, sorted
+	Tags() []string
 }
 
 func (peer enetPeer) SendBytes(data []byte, channel uint8, flags PacketFlags) error {
@@ -104,95 +112,42 @@ func (peer enetPeer) SendBytes(data []byte, channel uint8, flags PacketFlags) er
 	if err != nil {
 		return err
 	}
-	return peer.SendPacket(packet, channel)
-}
-
-func (peer enetPeer) SendString(str string, channel uint8, flags PacketFlags) error {
-	packet, err := NewPacket([]byte(str), flags)
-	if err != nil {
+	if err := peer.SendPacket(packet, channel); err != nil {
+		packet.Destroy()
 		return err
 	}
-	return peer.SendPacket(packet, channel)
-}
-
-func (peer enetPeer) SendPacket(packet Packet, channel uint8) error {
-	C.enet_peer_send(
-		peer.cPeer,
-		(C.uint8_t)(channel),
-		packet.(enetPacket).cPacket,
-	)
 	return nil
 }
 
-func (peer enetPeer) SetData(data []byte) {
-	if len(data) > math.MaxUint32 {
-		panic(fmt.Sprintf("maximum peer data length is uint32 (%d)", math.MaxUint32))
-	}
+func (peer enetPeer) SendString(str string, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
 
-	// Free any data that was previously stored against this peer.
-	existing := unsafe.Pointer(peer.cPeer.data)
-	if existing != nil {
-		C.free(existing)
+func (peer enetPeer) SendAsync(data []byte, channel uint8, flags PacketFlags) error {
+	host := peer.host()
+	if host == nil {
+		return errors.New("peer has no host")
 	}
 
-	// If nil, set this explicitly.
-	if data == nil {
-		peer.cPeer.data = nil
-		return
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
 	}
-
-	// First 4 bytes stores how many bytes we have. This is so we can C.GoBytes when
-	// retrieving which requires a byte length to read.
-	b := make([]byte, len(data)+4)
-	binary.LittleEndian.PutUint32(b, uint32(len(data)))
-	// Join this header + data in to a contiguous slice
-	copy(b[4:], data)
-	// And write it out to C memory, storing our pointer.
-	peer.cPeer.data = unsafe.Pointer(C.CBytes(b))
-}
-
-func (peer enetPeer) GetData() []byte {
-	ptr := unsafe.Pointer(peer.cPeer.data)
-
-	if ptr == nil {
-		return nil
+	raw, err := packet.(*enetPacket).handOver()
+	if err != nil {
+		return err
 	}
 
-	// First 4 bytes are the bytes length.
-	header := []byte{
-		*(*byte)(unsafe.Add(ptr, 0)),
-		*(*byte)(unsafe.Add(ptr, 1)),
-		*(*byte)(unsafe.Add(ptr, 2)),
-		*(*byte)(unsafe.Add(ptr, 3)),
+	session, tracked := host.outbox.session(peer)
+	if !host.outbox.push(&outboxEntry{
+		peer:    peer,
+		channel: channel,
+		packet:  raw,
+		session: session,
+		tracked: tracked,
+	}) {
+		destroyRaw(raw)
+		return errHostDestroyed
 	}
-
-	return []byte(C.GoBytes(
-		// Take from the start of the data.
-		unsafe.Add(ptr, 4),
-		// As many bytes as were indicated in the header.
-		C.int(binary.LittleEndian.Uint32(header)),
-	))
-}
-
-func (peer enetPeer) PingInterval(interval uint32) {
-	C.enet_peer_ping_interval(
-		peer.cPeer,
-		(C.uint32_t)(interval),
-	)
-}
-
-func (peer enetPeer) GetBytesSent() uint64 {
-	return uint64(C.enet_peer_get_bytes_sent(peer.cPeer))
-}
-
-func (peer enetPeer) GetPacketsSent() uint64 {
-	return uint64(C.enet_peer_get_packets_sent(peer.cPeer))
-}
-
-func (peer enetPeer) GetBytesReceived() uint64 {
-	return uint64(C.enet_peer_get_bytes_received(peer.cPeer))
-}
-
-func (peer enetPeer) GetPacketsLost() uint64 {
-	return uint64(C.enet_peer_get_packets_lost(peer.cPeer))
+	return nil
 }
@@ -0,0 +1,268 @@
+//go:build js && wasm
+
+package enet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+type browserPeerState int
+
+const (
+	browserPeerDisconnected browserPeerState = iota
+	browserPeerConnecting
+	browserPeerConnected
+	browserPeerDisconnecting
+)
+
+// browserPeer is a slot of a browser host. The state is only touched by the goroutine
+// servicing the host, the transport only posts events tagged with the generation of
+// the connection it carries.
+type browserPeer struct {
+	host         *browserHost
+	id           uint32
+	generation   uint32
+	state        browserPeerState
+	addr         enetAddress
+	channelCount int
+	transport    browserTransport
+	data         []byte
+
+	bytesSent     uint64
+	packetsSent   uint64
+	bytesReceived uint64
+}
+
+// reset frees the slot once the connection is gone.
+func (p *browserPeer) reset() {
+	p.state = browserPeerDisconnected
+	p.transport = nil
+	p.bytesSent = 0
+	p.packetsSent = 0
+	p.bytesReceived = 0
+}
+
+// drop closes the connection without an event, dropping the ones already queued.
+func (p *browserPeer) drop(data uint32) {
+	if p.state == browserPeerDisconnected {
+		return
+	}
+	p.transport.close(data)
+	p.host.lock.Lock()
+	p.generation++
+	p.host.lock.Unlock()
+	p.reset()
+}
+
+// send hands the packet to the transport. Returns false if the peer can't take it.
+func (p *browserPeer) send(channel uint8, packet *protocol.Packet) bool {
+	if p.state != browserPeerConnected || int(channel) >= p.channelCount {
+		return false
+	}
+	if err := p.transport.send(channel, PacketFlags(packet.Flags), packet.Data); err != nil {
+		return false
+	}
+	p.host.totalSentData += uint32(len(packet.Data))
+	p.host.totalSentPackets++
+	p.bytesSent += uint64(len(packet.Data))
+	p.packetsSent++
+	return true
+}
+
+type enetPeer struct {
+	jsPeer *browserPeer
+}
+
+// host returns the Go side of the host of the peer.
+func (peer enetPeer) host() *enetHost {
+	return hostOf(peer.jsPeer.host)
+}
+
+// session returns the generation of the connection of the peer, which changes as its
+// slot is reused by another one. Must only be called by the goroutine servicing the
+// host.
+func (peer enetPeer) session() uint32 {
+	return peer.jsPeer.generation
+}
+
+func (peer enetPeer) address() rawAddress {
+	return &net.UDPAddr{
+		IP:   net.ParseIP(peer.jsPeer.addr.host),
+		Port: int(peer.jsPeer.addr.port),
+	}
+}
+
+// sendRaw hands a packet taken from an enetPacket to the host. Returns false if the
+// host refused it, leaving the packet to the caller.
+func (peer enetPeer) sendRaw(channel uint8, packet rawPacket) bool {
+	if !peer.jsPeer.send(channel, packet) {
+		return false
+	}
+	dumpSent(peer, channel, packet)
+	return true
+}
+
+func (peer enetPeer) GetAddress() Address {
+	threadCheck(peer.jsPeer.host, "Peer.GetAddress")
+	addr := peer.jsPeer.addr
+	return &addr
+}
+
+func (peer enetPeer) GetID() uint32 {
+	threadCheck(peer.jsPeer.host, "Peer.GetID")
+	return peer.jsPeer.id
+}
+
+// Disconnect closes the connection to the bridge. Like with enet, the disconnect
+// event follows from Service with the data set to 0.
+func (peer enetPeer) Disconnect(data uint32) {
+	threadCheck(peer.jsPeer.host, "Peer.Disconnect")
+	p := peer.jsPeer
+	if p.state != browserPeerConnected {
+		p.drop(data)
+		return
+	}
+
+	p.transport.close(data)
+	p.state = browserPeerDisconnecting
+	p.host.post(browserEvent{
+		eventType:  EventDisconnect,
+		peer:       p,
+		generation: p.generation,
+	})
+}
+
+func (peer enetPeer) DisconnectNow(data uint32) {
+	threadCheck(peer.jsPeer.host, "Peer.DisconnectNow")
+	peer.jsPeer.drop(data)
+}
+
+// DisconnectLater is the same as Disconnect, the transports send in order anyway.
+func (peer enetPeer) DisconnectLater(data uint32) {
+	threadCheck(peer.jsPeer.host, "Peer.DisconnectLater")
+	peer.Disconnect(data)
+}
+
+// SetTimeout does nothing, the browser decides when a connection has timed out.
+func (peer enetPeer) SetTimeout(limit uint32, min uint32, max uint32) {
+	threadCheck(peer.jsPeer.host, "Peer.SetTimeout")
+}
+
+// setPingInterval does nothing, the bridge pings the peers of the browser.
+func (peer enetPeer) setPingInterval(interval uint32) {}
+
+// SendPacket sends the packet to the bridge. If sending fails, the packet is left to
+// the caller to destroy.
+func (peer enetPeer) SendPacket(packet Packet, channel uint8) error {
+	threadCheck(peer.jsPeer.host, "Peer.SendPacket")
+	if deferred, err := deferSend(peer, packet, channel); deferred {
+		return err
+	}
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	jsPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+
+	if !peer.jsPeer.send(channel, jsPacket) {
+		p.untake()
+		err := errors.New("unable to send packet")
+		logSendError(peer, channel, err)
+		return err
+	}
+	dumpSent(peer, channel, jsPacket)
+	return nil
+}
+
+// SetData keeps a copy of data in the peer. Unlike with the C library, the data does
+// not need to be cleared to be freed.
+func (peer enetPeer) SetData(data []byte) {
+	threadCheck(peer.jsPeer.host, "Peer.SetData")
+	if len(data) > math.MaxUint32 {
+		panic(fmt.Sprintf("maximum peer data length is uint32 (%d)", math.MaxUint32))
+	}
+
+	if data == nil {
+		peer.jsPeer.data = nil
+		return
+	}
+	peer.jsPeer.data = append([]byte{}, data...)
+}
+
+func (peer enetPeer) GetData() []byte {
+	threadCheck(peer.jsPeer.host, "Peer.GetData")
+	return peer.jsPeer.data
+}
+
+// PingInterval does nothing, the bridges keep the connections alive.
+func (peer enetPeer) PingInterval(interval uint32) {
+	threadCheck(peer.jsPeer.host, "Peer.PingInterval")
+}
+
+func (peer enetPeer) GetBytesSent() uint64 {
+	threadCheck(peer.jsPeer.host, "Peer.GetBytesSent")
+	return peer.jsPeer.bytesSent
+}
+
+func (peer enetPeer) GetPacketsSent() uint64 {
+	threadCheck(peer.jsPeer.host, "Peer.GetPacketsSent")
+	return peer.jsPeer.packetsSent
+}
+
+func (peer enetPeer) GetBytesReceived() uint64 {
+	threadCheck(peer.jsPeer.host, "Peer.GetBytesReceived")
+	return peer.jsPeer.bytesReceived
+}
+
+// GetPacketsLost always returns 0, the transports don't report losses.
+func (peer enetPeer) GetPacketsLost() uint64 {
+	threadCheck(peer.jsPeer.host, "Peer.GetPacketsLost")
+	return 0
+}
+
+// EstimatedBandwidth returns 0, congestion control is left to the browser.
+func (peer enetPeer) EstimatedBandwidth() uint32 {
+	threadCheck(peer.jsPeer.host, "Peer.EstimatedBandwidth")
+	return 0
+}
+
+// SimulateConditions fails, the browser has no access to the datagrams of peers.
+func (peer enetPeer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	threadCheck(peer.jsPeer.host, "Peer.SimulateConditions")
+	return errors.New("conditions cannot be simulated in the browser")
+}
+
+// roundTripTime returns 0, round trips are left to the browser.
+func (peer enetPeer) roundTripTime() time.Duration {
+	return 0
+}
+
+// queuedCommands returns 0, queues are left to the browser.
+func (peer enetPeer) queuedCommands() int {
+	return 0
+}
+
+// packetThrottle returns 100, throttling is left to the browser.
+func (peer enetPeer) packetThrottle() float32 {
+	return 100
+}
+
+// bandwidthInputs returns no inputs, congestion control is left to the browser.
+func (peer enetPeer) bandwidthInputs() bandwidthInputs {
+	return bandwidthInputs{}
+}
+
+// resetPeer drops the connection to the peer. The bridge notices the stream closing,
+// streams can't be dropped silently.
+func (peer enetPeer) resetPeer() {
+	peer.jsPeer.drop(0)
+}
@@ -0,0 +1,244 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+import "C"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unsafe"
+)
+
+type enetPeer struct {
+	cPeer *C.ENetPeer
+}
+
+// host returns the Go side of the host of the peer.
+func (peer enetPeer) host() *enetHost {
+	return hostOf(peer.cPeer.host)
+}
+
+// session returns the ID of the connection of the peer, which changes as its slot is
+// reused by another one. Must only be called by the goroutine servicing the host.
+func (peer enetPeer) session() uint32 {
+	return uint32(peer.cPeer.connectID)
+}
+
+func (peer enetPeer) address() rawAddress {
+	return &peer.cPeer.address
+}
+
+// sendRaw hands a packet taken from an enetPacket to enet. Returns false if enet
+// refused it, leaving the packet to the caller.
+func (peer enetPeer) sendRaw(channel uint8, packet rawPacket) bool {
+	if C.enet_peer_send(peer.cPeer, (C.uint8_t)(channel), packet) < 0 {
+		return false
+	}
+	dumpSent(peer, channel, packet)
+	return true
+}
+
+func (peer enetPeer) GetAddress() Address {
+	threadCheck(peer.cPeer.host, "Peer.GetAddress")
+	return &enetAddress{
+		cAddr: peer.cPeer.address,
+	}
+}
+
+func (peer enetPeer) GetID() uint32 {
+	threadCheck(peer.cPeer.host, "Peer.GetID")
+	return uint32(C.enet_peer_get_id(peer.cPeer))
+}
+
+func (peer enetPeer) Disconnect(data uint32) {
+	threadCheck(peer.cPeer.host, "Peer.Disconnect")
+	C.enet_peer_disconnect(
+		peer.cPeer,
+		(C.uint32_t)(data),
+	)
+}
+
+func (peer enetPeer) DisconnectNow(data uint32) {
+	threadCheck(peer.cPeer.host, "Peer.DisconnectNow")
+	C.enet_peer_disconnect_now(
+		peer.cPeer,
+		(C.uint32_t)(data),
+	)
+}
+
+func (peer enetPeer) DisconnectLater(data uint32) {
+	threadCheck(peer.cPeer.host, "Peer.DisconnectLater")
+	C.enet_peer_disconnect_later(
+		peer.cPeer,
+		(C.uint32_t)(data),
+	)
+}
+
+func (peer enetPeer) SetTimeout(limit uint32, min uint32, max uint32) {
+	threadCheck(peer.cPeer.host, "Peer.SetTimeout")
+	C.enet_peer_timeout(
+		peer.cPeer,
+		(C.uint32_t)(limit),
+		(C.uint32_t)(min),
+		(C.uint32_t)(max),
+	)
+}
+
+// setPingInterval sets how often the peer is pinged, 0 restoring the default.
+func (peer enetPeer) setPingInterval(interval uint32) {
+	C.enet_peer_ping_interval(peer.cPeer, C.uint32_t(interval))
+}
+
+// SendPacket hands the packet over to enet, which frees it once it has been sent. If
+// sending fails, the packet is left to the caller to destroy.
+func (peer enetPeer) SendPacket(packet Packet, channel uint8) error {
+	threadCheck(peer.cPeer.host, "Peer.SendPacket")
+	if deferred, err := deferSend(peer, packet, channel); deferred {
+		return err
+	}
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	cPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+
+	ret := C.enet_peer_send(
+		peer.cPeer,
+		(C.uint8_t)(channel),
+		cPacket,
+	)
+	if ret < 0 {
+		p.untake()
+		err := errors.New("unable to send packet")
+		logSendError(peer, channel, err)
+		return err
+	}
+	leakUntrack(unsafe.Pointer(cPacket))
+	dumpSent(peer, channel, cPacket)
+	return nil
+}
+
+func (peer enetPeer) SetData(data []byte) {
+	threadCheck(peer.cPeer.host, "Peer.SetData")
+	if len(data) > math.MaxUint32 {
+		panic(fmt.Sprintf("maximum peer data length is uint32 (%d)", math.MaxUint32))
+	}
+
+	// Free any data that was previously stored against this peer.
+	existing := unsafe.Pointer(peer.cPeer.data)
+	if existing != nil {
+		leakUntrack(existing)
+		C.free(existing)
+	}
+
+	// If nil, set this explicitly.
+	if data == nil {
+		peer.cPeer.data = nil
+		return
+	}
+
+	// First 4 bytes stores how many bytes we have. This is so we can C.GoBytes when
+	// retrieving which requires a byte length to read.
+	b := make([]byte, len(data)+4)
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	// Join this header + data in to a contiguous slice
+	copy(b[4:], data)
+	// And write it out to C memory, storing our pointer.
+	peer.cPeer.data = unsafe.Pointer(C.CBytes(b))
+	leakTrack(peer.cPeer.data, leakData)
+}
+
+// GetData returns a view of the data in C memory, which SetData frees, see
+// Peer.GetData.
+func (peer enetPeer) GetData() []byte {
+	threadCheck(peer.cPeer.host, "Peer.GetData")
+	ptr := unsafe.Pointer(peer.cPeer.data)
+
+	if ptr == nil {
+		return nil
+	}
+
+	// First 4 bytes are the bytes length, the data follows.
+	length := binary.LittleEndian.Uint32(unsafe.Slice((*byte)(ptr), 4))
+	return unsafe.Slice((*byte)(unsafe.Add(ptr, 4)), length)
+}
+
+func (peer enetPeer) PingInterval(interval uint32) {
+	threadCheck(peer.cPeer.host, "Peer.PingInterval")
+	C.enet_peer_ping_interval(
+		peer.cPeer,
+		(C.uint32_t)(interval),
+	)
+}
+
+func (peer enetPeer) GetBytesSent() uint64 {
+	threadCheck(peer.cPeer.host, "Peer.GetBytesSent")
+	return uint64(C.enet_peer_get_bytes_sent(peer.cPeer))
+}
+
+func (peer enetPeer) GetPacketsSent() uint64 {
+	threadCheck(peer.cPeer.host, "Peer.GetPacketsSent")
+	return uint64(C.enet_peer_get_packets_sent(peer.cPeer))
+}
+
+func (peer enetPeer) GetBytesReceived() uint64 {
+	threadCheck(peer.cPeer.host, "Peer.GetBytesReceived")
+	return uint64(C.enet_peer_get_bytes_received(peer.cPeer))
+}
+
+func (peer enetPeer) GetPacketsLost() uint64 {
+	threadCheck(peer.cPeer.host, "Peer.GetPacketsLost")
+	return uint64(C.enet_peer_get_packets_lost(peer.cPeer))
+}
+
+func (peer enetPeer) EstimatedBandwidth() uint32 {
+	threadCheck(peer.cPeer.host, "Peer.EstimatedBandwidth")
+	return peer.estimateBandwidth()
+}
+
+func (peer enetPeer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	threadCheck(peer.cPeer.host, "Peer.SimulateConditions")
+	return peer.simulateConditions(latency, jitter, loss)
+}
+
+// roundTripTime returns the mean round trip time to the peer.
+func (peer enetPeer) roundTripTime() time.Duration {
+	return time.Duration(C.enet_peer_get_rtt(peer.cPeer)) * time.Millisecond
+}
+
+// queuedCommands returns the number of commands waiting to be sent to the peer or to be
+// acknowledged by it.
+func (peer enetPeer) queuedCommands() int {
+	return int(C.enet_list_size(&peer.cPeer.outgoingCommands) + C.enet_list_size(&peer.cPeer.sentReliableCommands))
+}
+
+// packetThrottle returns the throttle of unreliable packets to the peer in percent.
+func (peer enetPeer) packetThrottle() float32 {
+	return float32(C.enet_peer_get_packets_throttle(peer.cPeer))
+}
+
+// bandwidthInputs returns what the bandwidth of the peer is estimated from.
+func (peer enetPeer) bandwidthInputs() bandwidthInputs {
+	return bandwidthInputs{
+		mtu:               uint32(peer.cPeer.mtu),
+		windowSize:        uint32(peer.cPeer.windowSize),
+		throttle:          peer.packetThrottle(),
+		roundTripTime:     uint32(peer.cPeer.roundTripTime),
+		packetsSent:       uint64(peer.cPeer.totalPacketsSent),
+		packetsLost:       uint64(peer.cPeer.totalPacketsLost),
+		incomingBandwidth: uint32(peer.cPeer.incomingBandwidth),
+		outgoingBandwidth: uint32(peer.cPeer.host.outgoingBandwidth),
+	}
+}
+
+// resetPeer drops the connection to the peer without telling it.
+func (peer enetPeer) resetPeer() {
+	C.enet_peer_reset(peer.cPeer)
+}
@@ -0,0 +1,195 @@
+//go:build purego && !(js && wasm)
+
+package enet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+type enetPeer struct {
+	goPeer *protocol.Peer
+}
+
+// host returns the Go side of the host of the peer.
+func (peer enetPeer) host() *enetHost {
+	return hostOf(peer.goPeer.Host())
+}
+
+// session returns the ID of the connection of the peer, which changes as its slot is
+// reused by another one. Must only be called by the goroutine servicing the host.
+func (peer enetPeer) session() uint32 {
+	return peer.goPeer.ConnectID()
+}
+
+func (peer enetPeer) address() rawAddress {
+	return peer.goPeer.Address()
+}
+
+// sendRaw hands a packet taken from an enetPacket to the host. Returns false if the
+// host refused it, leaving the packet to the caller.
+func (peer enetPeer) sendRaw(channel uint8, packet rawPacket) bool {
+	if peer.goPeer.Send(channel, packet) != nil {
+		return false
+	}
+	dumpSent(peer, channel, packet)
+	return true
+}
+
+func (peer enetPeer) GetAddress() Address {
+	threadCheck(peer.goPeer.Host(), "Peer.GetAddress")
+	return &enetAddress{
+		addr: peer.goPeer.Address(),
+	}
+}
+
+func (peer enetPeer) GetID() uint32 {
+	threadCheck(peer.goPeer.Host(), "Peer.GetID")
+	return peer.goPeer.ID()
+}
+
+func (peer enetPeer) Disconnect(data uint32) {
+	threadCheck(peer.goPeer.Host(), "Peer.Disconnect")
+	peer.goPeer.Disconnect(data)
+}
+
+func (peer enetPeer) DisconnectNow(data uint32) {
+	threadCheck(peer.goPeer.Host(), "Peer.DisconnectNow")
+	peer.goPeer.DisconnectNow(data)
+}
+
+func (peer enetPeer) DisconnectLater(data uint32) {
+	threadCheck(peer.goPeer.Host(), "Peer.DisconnectLater")
+	peer.goPeer.DisconnectLater(data)
+}
+
+func (peer enetPeer) SetTimeout(limit uint32, min uint32, max uint32) {
+	threadCheck(peer.goPeer.Host(), "Peer.SetTimeout")
+	peer.goPeer.Timeout(limit, min, max)
+}
+
+// setPingInterval sets how often the peer is pinged, 0 restoring the default.
+func (peer enetPeer) setPingInterval(interval uint32) {
+	peer.goPeer.PingInterval(interval)
+}
+
+// SendPacket hands the packet over to the host, which drops it once it has been
+// sent. If sending fails, the packet is left to the caller to destroy.
+func (peer enetPeer) SendPacket(packet Packet, channel uint8) error {
+	threadCheck(peer.goPeer.Host(), "Peer.SendPacket")
+	if deferred, err := deferSend(peer, packet, channel); deferred {
+		return err
+	}
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+	goPacket, err := p.take()
+	if err != nil {
+		return err
+	}
+
+	if err := peer.goPeer.Send(channel, goPacket); err != nil {
+		p.untake()
+		err := errors.New("unable to send packet")
+		logSendError(peer, channel, err)
+		return err
+	}
+	dumpSent(peer, channel, goPacket)
+	return nil
+}
+
+// SetData keeps a copy of data in the peer. Unlike with the C library, the data does
+// not need to be cleared to be freed.
+func (peer enetPeer) SetData(data []byte) {
+	threadCheck(peer.goPeer.Host(), "Peer.SetData")
+	if len(data) > math.MaxUint32 {
+		panic(fmt.Sprintf("maximum peer data length is uint32 (%d)", math.MaxUint32))
+	}
+
+	if data == nil {
+		peer.goPeer.Data = nil
+		return
+	}
+	peer.goPeer.Data = append([]byte{}, data...)
+}
+
+func (peer enetPeer) GetData() []byte {
+	threadCheck(peer.goPeer.Host(), "Peer.GetData")
+	data, _ := peer.goPeer.Data.([]byte)
+	return data
+}
+
+func (peer enetPeer) PingInterval(interval uint32) {
+	threadCheck(peer.goPeer.Host(), "Peer.PingInterval")
+	peer.goPeer.PingInterval(interval)
+}
+
+func (peer enetPeer) GetBytesSent() uint64 {
+	threadCheck(peer.goPeer.Host(), "Peer.GetBytesSent")
+	return peer.goPeer.BytesSent()
+}
+
+func (peer enetPeer) GetPacketsSent() uint64 {
+	threadCheck(peer.goPeer.Host(), "Peer.GetPacketsSent")
+	return peer.goPeer.PacketsSent()
+}
+
+func (peer enetPeer) GetBytesReceived() uint64 {
+	threadCheck(peer.goPeer.Host(), "Peer.GetBytesReceived")
+	return peer.goPeer.BytesReceived()
+}
+
+func (peer enetPeer) GetPacketsLost() uint64 {
+	threadCheck(peer.goPeer.Host(), "Peer.GetPacketsLost")
+	return peer.goPeer.PacketsLost()
+}
+
+func (peer enetPeer) EstimatedBandwidth() uint32 {
+	threadCheck(peer.goPeer.Host(), "Peer.EstimatedBandwidth")
+	return peer.estimateBandwidth()
+}
+
+func (peer enetPeer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	threadCheck(peer.goPeer.Host(), "Peer.SimulateConditions")
+	return peer.simulateConditions(latency, jitter, loss)
+}
+
+// roundTripTime returns the mean round trip time to the peer.
+func (peer enetPeer) roundTripTime() time.Duration {
+	return time.Duration(peer.goPeer.RoundTripTime()) * time.Millisecond
+}
+
+// queuedCommands returns the number of commands waiting to be sent to the peer or to be
+// acknowledged by it.
+func (peer enetPeer) queuedCommands() int {
+	return peer.goPeer.QueuedCommands()
+}
+
+// packetThrottle returns the throttle of unreliable packets to the peer in percent.
+func (peer enetPeer) packetThrottle() float32 {
+	return peer.goPeer.PacketThrottle()
+}
+
+// bandwidthInputs returns what the bandwidth of the peer is estimated from.
+func (peer enetPeer) bandwidthInputs() bandwidthInputs {
+	return bandwidthInputs{
+		mtu:               peer.goPeer.MTU(),
+		windowSize:        peer.goPeer.WindowSize(),
+		throttle:          peer.goPeer.PacketThrottle(),
+		roundTripTime:     peer.goPeer.RoundTripTime(),
+		packetsSent:       peer.goPeer.PacketsSent(),
+		packetsLost:       peer.goPeer.PacketsLost(),
+		incomingBandwidth: peer.goPeer.IncomingBandwidth(),
+		outgoingBandwidth: peer.goPeer.Host().OutgoingBandwidth(),
+	}
+}
+
+// resetPeer drops the connection to the peer without telling it.
+func (peer enetPeer) resetPeer() {
+	peer.goPeer.Reset()
+}
@@ -0,0 +1,59 @@
+package enet
+
+import "errors"
+
+// Protocol selects the wire format a host talks
+type Protocol int
+
+const (
+	// ProtocolFork is the format of ENet-CSharp, the fork of ENet this package is
+	// built on. Hosts talk it by default.
+	ProtocolFork Protocol = iota
+
+	// ProtocolStock is the format of stock ENet 1.3, for talking to servers and clients
+	// built on it. Compressed datagrams are not supported and dropped, so the other
+	// side must not enable a compressor.
+	ProtocolStock
+)
+
+// ProtocolConfig configures the wire format of a host
+type ProtocolConfig struct {
+	Protocol Protocol
+
+	// Checksum adds a checksum to every datagram and drops datagrams with a wrong one.
+	// It's the CRC-64 of ENet-CSharp with ProtocolFork and the CRC-32 of enet_crc32
+	// with ProtocolStock, both sides must enable it. Only supported with cgo.
+	Checksum bool
+}
+
+// SetProtocol makes host talk the wire format of config. Both sides of a connection
+// must agree on it, so it should be set right after creating the host, before it
+// connects or accepts peers. Use cmd/enet-compat to find out what a remote host talks.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func SetProtocol(host Host, config ProtocolConfig) error {
+	if config.Protocol != ProtocolFork && config.Protocol != ProtocolStock {
+		return errors.New("unknown protocol")
+	}
+
+	var err error
+	set := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("the protocol can only be set on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		err = h.setProtocol(config)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(set)
+	} else {
+		set(host)
+	}
+	return err
+}
@@ -0,0 +1,390 @@
+package enet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	punchMagic = "ENETPUN\x01"
+
+	// MaxPunchTokenSize is the size of the longest token peers can meet with
+	MaxPunchTokenSize = 256
+
+	// punchResendInterval is how often registrations and punches are sent again until
+	// the connection is established, in case they were lost.
+	punchResendInterval = 250 * time.Millisecond
+
+	// punchMatchSize is the size of a match before its token: the magic, the kind, the
+	// role and the address of the other peer.
+	punchMatchSize = len(punchMagic) + 1 + 1 + 16 + 2
+)
+
+// Kinds of punch-through datagrams.
+const (
+	punchRegister byte = iota
+	punchMatch
+	punchPunch
+)
+
+// punchResult ends a punch-through attempt.
+type punchResult struct {
+	peer enetPeer
+	err  error
+}
+
+// punchAttempt is a punch-through attempt of a host, waiting to meet the other peer at
+// the rendezvous server, punching holes towards it, then connecting to it.
+type punchAttempt struct {
+	token        []byte
+	rendezvous   *net.UDPAddr
+	channelCount int
+	data         uint32
+
+	// remote is the public address of the other peer, nil until the rendezvous server
+	// matched them.
+	remote    *net.UDPAddr
+	initiator bool
+	peer      enetPeer
+	next      time.Time
+
+	done chan punchResult
+}
+
+// punchTable holds the punch-through attempts of a host, by their token. Must only be
+// used by the goroutine servicing the host.
+type punchTable struct {
+	installed bool
+	attempts  map[string]*punchAttempt
+}
+
+// ConnectViaPunch connects to a peer behind a NAT, meeting it at the rendezvous server
+// listening at rendezvous. Both peers call it with the same token, agreed upon out of
+// band, for example through matchmaking. The rendezvous server tells each the public
+// address the other reached it from, then both punch holes in their NATs by sending
+// datagrams to each other from their enet socket, and one connects to the other.
+//
+// It blocks until the peers are connected or ctx is done, so it must be called on a
+// running SafeHost, from another goroutine than the one running it. The EventConnect of
+// the peer is returned by the host as usual.
+func ConnectViaPunch(ctx context.Context, host SafeHost, rendezvous Address, peerToken []byte, channelCount int, data uint32) (Peer, error) {
+	if len(peerToken) == 0 || len(peerToken) > MaxPunchTokenSize {
+		return nil, errors.New("invalid punch token size")
+	}
+	rendezvousAddr := peerUDPAddr(rendezvous)
+	if rendezvousAddr.IP.IsUnspecified() {
+		return nil, errors.New("address has no IP")
+	}
+
+	attempt := &punchAttempt{
+		token:        append([]byte(nil), peerToken...),
+		rendezvous:   rendezvousAddr,
+		channelCount: channelCount,
+		data:         data,
+		done:         make(chan punchResult, 1),
+	}
+
+	var err error
+	host.Do(func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("punch-through is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		err = h.punches.start(h, attempt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-attempt.done:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return safePeer{Peer: result.peer, host: host.(*safeHost)}, nil
+	case <-ctx.Done():
+	}
+
+	host.Do(func(host Host) {
+		if h, ok := host.(*enetHost); ok {
+			h.punches.cancel(attempt)
+		}
+	})
+	// The attempt may have succeeded while it was being cancelled.
+	select {
+	case result := <-attempt.done:
+		if result.err == nil {
+			return safePeer{Peer: result.peer, host: host.(*safeHost)}, nil
+		}
+	default:
+	}
+	return nil, ctx.Err()
+}
+
+// start adds an attempt, registering it with the rendezvous server on the next service.
+func (table *punchTable) start(host *enetHost, attempt *punchAttempt) error {
+	if _, ok := table.attempts[string(attempt.token)]; ok {
+		return errors.New("punch-through with this token already in progress")
+	}
+	if !table.installed {
+		host.intercepts.add(host, table.intercept)
+		host.features.join(featurePunches)
+		table.installed = true
+	}
+	if table.attempts == nil {
+		table.attempts = make(map[string]*punchAttempt)
+	}
+	table.attempts[string(attempt.token)] = attempt
+	return nil
+}
+
+// cancel drops an attempt, aborting its connection if it is still in progress.
+func (table *punchTable) cancel(attempt *punchAttempt) {
+	if table.attempts[string(attempt.token)] != attempt {
+		return
+	}
+	delete(table.attempts, string(attempt.token))
+	if attempt.peer != (enetPeer{}) {
+		attempt.peer.DisconnectNow(0)
+	}
+}
+
+// finish ends an attempt with its result.
+func (table *punchTable) finish(attempt *punchAttempt, peer enetPeer, err error) {
+	delete(table.attempts, string(attempt.token))
+	attempt.done <- punchResult{peer: peer, err: err}
+}
+
+// intercept consumes the punch-through datagrams received by the host, learning the
+// address of the other peer from the matches of the rendezvous server.
+func (table *punchTable) intercept(addr rawAddress, data []byte) bool {
+	if !bytes.HasPrefix(data, []byte(punchMagic)) {
+		return false
+	}
+	if len(data) < punchMatchSize || data[len(punchMagic)] != punchMatch {
+		// Punches only open the way, there is nothing to them.
+		return true
+	}
+
+	attempt, ok := table.attempts[string(data[punchMatchSize:])]
+	if !ok || attempt.remote != nil || simAddrKey(udpAddrOf(addr)) != simAddrKey(attempt.rendezvous) {
+		return true
+	}
+	match := data[len(punchMagic)+1:]
+	ip := net.IP(bytes.Clone(match[1:17]))
+	attempt.initiator = match[0] == 1
+	attempt.remote = &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(match[17:]))}
+	attempt.next = time.Time{}
+	return true
+}
+
+// resend sends the registrations and punches of the attempts of host again when due,
+// and makes the initiators of those matched connect.
+func (table *punchTable) resend(host *enetHost) {
+	if len(table.attempts) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, attempt := range table.attempts {
+		if now.Before(attempt.next) {
+			continue
+		}
+		attempt.next = now.Add(punchResendInterval)
+
+		// Lost like any datagram if it can't be sent, the next one may be luckier.
+		if attempt.remote == nil {
+			host.socketSend(attempt.rendezvous, punchDatagram(punchRegister, attempt.token))
+			continue
+		}
+		host.socketSend(attempt.remote, punchDatagram(punchPunch, attempt.token))
+
+		if attempt.initiator && attempt.peer == (enetPeer{}) {
+			addr := NewAddress(attempt.remote.IP.String(), uint16(attempt.remote.Port))
+			peer, err := host.Connect(addr, attempt.channelCount, attempt.data)
+			if err != nil {
+				table.finish(attempt, enetPeer{}, err)
+				continue
+			}
+			attempt.peer = peer.(enetPeer)
+		}
+	}
+}
+
+// observe ends the attempts whose peer connected, or failed to.
+func (table *punchTable) observe(event *enetEvent) {
+	if len(table.attempts) == 0 {
+		return
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return
+	}
+
+	switch event.GetType() {
+	case EventConnect:
+		key := simAddrKey(udpAddrOf(peer.address()))
+		for _, attempt := range table.attempts {
+			if attempt.peer == peer || (!attempt.initiator && attempt.remote != nil && simAddrKey(attempt.remote) == key) {
+				table.finish(attempt, peer, nil)
+				return
+			}
+		}
+	case EventDisconnect, EventDisconnectTimeout:
+		for _, attempt := range table.attempts {
+			if attempt.peer == peer {
+				table.finish(attempt, enetPeer{}, errors.New("unable to connect through the NAT"))
+				return
+			}
+		}
+	}
+}
+
+// punchDatagram builds a punch-through datagram of kind carrying token.
+func punchDatagram(kind byte, token []byte) []byte {
+	data := make([]byte, 0, len(punchMagic)+1+len(token))
+	data = append(data, punchMagic...)
+	data = append(data, kind)
+	return append(data, token...)
+}
+
+// DefaultRendezvousTTL is how long a rendezvous server remembers peers by default
+const DefaultRendezvousTTL = 30 * time.Second
+
+// RendezvousConfig configures a rendezvous server
+type RendezvousConfig struct {
+	// TTL is how long a peer waits for the other to register with the same token, and
+	// how long their match is then remembered to answer registrations sent again.
+	// Defaults to DefaultRendezvousTTL.
+	TTL time.Duration
+
+	// Authorize decides whether the peer at addr may register with token. It may be
+	// nil to let every peer register.
+	Authorize func(addr net.Addr, token []byte) bool
+}
+
+// RendezvousServer introduces peers calling ConnectViaPunch with the same token to each
+// other, telling each the public address the other registered from
+type RendezvousServer interface {
+	// Serve answers the registrations received on conn until ctx is done or reading
+	// from conn fails. The connection is not closed.
+	Serve(ctx context.Context, conn net.PacketConn) error
+}
+
+// rendezvousEntry is a peer waiting for the other, or two matched peers.
+type rendezvousEntry struct {
+	first   net.Addr
+	second  net.Addr
+	expires time.Time
+}
+
+type rendezvousServer struct {
+	config RendezvousConfig
+}
+
+// NewRendezvousServer creates a rendezvous server
+func NewRendezvousServer(config RendezvousConfig) RendezvousServer {
+	if config.TTL <= 0 {
+		config.TTL = DefaultRendezvousTTL
+	}
+	return &rendezvousServer{config: config}
+}
+
+func (server *rendezvousServer) Serve(ctx context.Context, conn net.PacketConn) error {
+	entries := make(map[string]*rendezvousEntry)
+	buffer := make([]byte, len(punchMagic)+1+MaxPunchTokenSize)
+	pruned := time.Now()
+
+	for ctx.Err() == nil {
+		// Reads time out now and then to notice ctx is done.
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := conn.ReadFrom(buffer)
+		now := time.Now()
+		if now.Sub(pruned) >= time.Second {
+			for token, entry := range entries {
+				if now.After(entry.expires) {
+					delete(entries, token)
+				}
+			}
+			pruned = now
+		}
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		data := buffer[:n]
+		header := len(punchMagic) + 1
+		if n <= header || !bytes.HasPrefix(data, []byte(punchMagic)) || data[len(punchMagic)] != punchRegister {
+			continue
+		}
+		token := data[header:]
+		if server.config.Authorize != nil && !server.config.Authorize(addr, token) {
+			continue
+		}
+		server.register(conn, entries, addr, string(token), now)
+	}
+	return ctx.Err()
+}
+
+// register registers the peer at addr with token, matching it with the peer waiting
+// with the same token if any.
+func (server *rendezvousServer) register(conn net.PacketConn, entries map[string]*rendezvousEntry, addr net.Addr, token string, now time.Time) {
+	entry, ok := entries[token]
+	if ok && now.After(entry.expires) {
+		delete(entries, token)
+		ok = false
+	}
+	if !ok {
+		entries[token] = &rendezvousEntry{first: addr, expires: now.Add(server.config.TTL)}
+		return
+	}
+
+	key := simAddrKey(addr)
+	switch {
+	case simAddrKey(entry.first) == key:
+		if entry.second == nil {
+			entry.expires = now.Add(server.config.TTL)
+			return
+		}
+		// The match was lost on its way, the peer registered again.
+		server.match(conn, addr, entry.second, token, true)
+	case entry.second == nil:
+		entry.second = addr
+		entry.expires = now.Add(server.config.TTL)
+		server.match(conn, entry.first, addr, token, true)
+		server.match(conn, addr, entry.first, token, false)
+	case simAddrKey(entry.second) == key:
+		server.match(conn, addr, entry.first, token, false)
+	}
+}
+
+// match tells the peer at to about the other peer, and whether it connects to it.
+func (server *rendezvousServer) match(conn net.PacketConn, to, other net.Addr, token string, initiator bool) {
+	remote := simAddrKey(other)
+	data := make([]byte, 0, punchMatchSize+len(token))
+	data = append(data, punchMagic...)
+	data = append(data, punchMatch, 0)
+	if initiator {
+		data[len(data)-1] = 1
+	}
+	ip := remote.Addr().As16()
+	data = append(data, ip[:]...)
+	data = binary.BigEndian.AppendUint16(data, remote.Port())
+	data = append(data, token...)
+	conn.WriteTo(data, to)
+}
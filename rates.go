@@ -0,0 +1,131 @@
+package enet
+
+import "time"
+
+const (
+	// rateBucket is the span of the buckets rates are summed from.
+	rateBucket = 100 * time.Millisecond
+
+	// rateBuckets is the number of buckets kept, a minute's worth.
+	rateBuckets = int(time.Minute / rateBucket)
+
+	// rateSecondBuckets is the number of buckets in a second.
+	rateSecondBuckets = int(time.Second / rateBucket)
+)
+
+// Rates are rates per second of the traffic and events of a host over a window
+type Rates struct {
+	BytesSent       float64
+	BytesReceived   float64
+	PacketsSent     float64
+	PacketsReceived float64
+
+	// Events is the rate of the events Service returned, other than EventNone
+	Events float64
+}
+
+// rateCounts are the counts of a bucket of a rate window.
+type rateCounts struct {
+	bytesSent       uint64
+	bytesReceived   uint64
+	packetsSent     uint64
+	packetsReceived uint64
+	events          uint64
+}
+
+func (c *rateCounts) add(o rateCounts) {
+	c.bytesSent += o.bytesSent
+	c.bytesReceived += o.bytesReceived
+	c.packetsSent += o.packetsSent
+	c.packetsReceived += o.packetsReceived
+	c.events += o.events
+}
+
+func (c rateCounts) rates(window time.Duration) Rates {
+	seconds := window.Seconds()
+	return Rates{
+		BytesSent:       float64(c.bytesSent) / seconds,
+		BytesReceived:   float64(c.bytesReceived) / seconds,
+		PacketsSent:     float64(c.packetsSent) / seconds,
+		PacketsReceived: float64(c.packetsReceived) / seconds,
+		Events:          float64(c.events) / seconds,
+	}
+}
+
+// rateWindow turns the counters of a host into rates over sliding windows of a second
+// and a minute, from buckets of rateBucket. The bucket being filled is left out of
+// the rates until it is complete. Must only be used by the goroutine updating the
+// stats of the host.
+type rateWindow struct {
+	// last are the counters as of the last update, and events the events counted since.
+	last   rateCounts
+	events uint64
+
+	buckets [rateBuckets]rateCounts
+	current rateCounts
+	slot    int64
+	next    int
+	filled  int
+}
+
+// update adds what the counters grew by since the last update to the window. Returns
+// true if a bucket completed, changing the rates.
+func (w *rateWindow) update(now time.Time, bytesSent, bytesReceived, packetsSent, packetsReceived uint32) bool {
+	slot := now.UnixNano() / int64(rateBucket)
+	if w.slot == 0 {
+		w.slot = slot
+	}
+	completed := slot != w.slot
+
+	// Buckets the host wasn't updated in are empty.
+	for skipped := min(slot-w.slot, int64(rateBuckets)); skipped > 0; skipped-- {
+		w.buckets[w.next] = w.current
+		w.current = rateCounts{}
+		w.next = (w.next + 1) % rateBuckets
+		w.filled = min(w.filled+1, rateBuckets)
+	}
+	w.slot = slot
+
+	counters := rateCounts{
+		bytesSent:       uint64(bytesSent),
+		bytesReceived:   uint64(bytesReceived),
+		packetsSent:     uint64(packetsSent),
+		packetsReceived: uint64(packetsReceived),
+	}
+	w.current.add(rateCounts{
+		bytesSent:       rateDelta(w.last.bytesSent, counters.bytesSent),
+		bytesReceived:   rateDelta(w.last.bytesReceived, counters.bytesReceived),
+		packetsSent:     rateDelta(w.last.packetsSent, counters.packetsSent),
+		packetsReceived: rateDelta(w.last.packetsReceived, counters.packetsReceived),
+		events:          w.events,
+	})
+	w.last = counters
+	w.events = 0
+	return completed
+}
+
+// rates returns the rates over the last second and minute.
+func (w *rateWindow) rates() (second, minute Rates) {
+	var secondCounts, minuteCounts rateCounts
+	for i := 0; i < w.filled; i++ {
+		bucket := w.buckets[(w.next-1-i+rateBuckets)%rateBuckets]
+		if i < rateSecondBuckets {
+			secondCounts.add(bucket)
+		}
+		minuteCounts.add(bucket)
+	}
+	if w.filled == 0 {
+		return Rates{}, Rates{}
+	}
+	// Until the window fills up, rates are over the time it covers.
+	return secondCounts.rates(time.Duration(min(w.filled, rateSecondBuckets)) * rateBucket),
+		minuteCounts.rates(time.Duration(w.filled) * rateBucket)
+}
+
+// rateDelta returns what a counter grew by, taking a counter that went down as reset.
+func rateDelta(last, counter uint64) uint64 {
+	if counter < last {
+		return counter
+	}
+	return counter - last
+}
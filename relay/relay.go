@@ -0,0 +1,414 @@
+// Package relay forwards traffic between two peers that cannot connect to each other
+// directly, the fallback when punching through their NATs fails. Both peers connect to
+// the relay and join the same session with a token agreed upon out of band, after which
+// every packet one sends to the relay is forwarded to the other, on the same channel
+// and with the same flags:
+//
+//	r := relay.New(relay.Config{BandwidthLimit: 256 << 10})
+//
+//	// On the goroutine servicing the host of the relay, every tick:
+//	r.Process()
+//
+//	// And for every event:
+//	r.Handle(event)
+//
+// Peers join with Join once connected, and wait for the event Ready reports before
+// sending to each other:
+//
+//	relay.Join(peer, token, 0)
+//	...
+//	if relay.Ready(event) {
+//		// The other peer joined, packets sent to the relay now reach it.
+//	}
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	magic = "ENETRLY\x01"
+
+	// MaxTokenSize is the size of the longest token peers can join sessions with
+	MaxTokenSize = 256
+
+	// forwardedFlags are the flags of received packets kept as they are forwarded.
+	forwardedFlags = enet.PacketFlagReliable | enet.PacketFlagUnsequenced | enet.PacketFlagUnreliableFragment
+)
+
+// ErrTokenSize is returned when joining with a token that is empty or too long
+var ErrTokenSize = errors.New("invalid relay token size")
+
+// Join asks the relay connected to as peer to add the local peer to the session of
+// token, on the channel the relay exchanges joins on
+func Join(peer enet.Peer, token []byte, channel uint8) error {
+	if len(token) == 0 || len(token) > MaxTokenSize {
+		return ErrTokenSize
+	}
+	return peer.SendBytes(append([]byte(magic), token...), channel, enet.PacketFlagReliable)
+}
+
+// Ready returns true if event tells that the other peer joined the session, after
+// which packets sent to the relay are forwarded to it. Once ready, every packet
+// received from the relay comes from the other peer, so it only needs to be called
+// until then.
+func Ready(event enet.Event) bool {
+	return event.GetType() == enet.EventReceive && string(event.GetPacket().GetData()) == magic
+}
+
+// Config configures a Relay
+type Config struct {
+	// Channel is the channel peers join sessions on
+	Channel uint8
+
+	// BandwidthLimit is the number of bytes per second forwarded for a session, in
+	// both directions, unlimited if 0. Unreliable packets over the limit are dropped,
+	// and reliable ones delayed.
+	BandwidthLimit int
+
+	// Burst is the number of bytes a session may forward at once when it has been
+	// under the limit. Defaults to BandwidthLimit. Packets larger than Burst are
+	// forwarded once the session has a full burst, which they overdraw.
+	Burst int
+
+	// MaxQueued is the number of bytes of reliable packets a session may have delayed
+	// before it is closed, as dropping them would break the guarantees of the peers.
+	// Defaults to BandwidthLimit. A single packet is always let wait, however large.
+	MaxQueued int
+
+	// JoinTimeout is how long peers may stay connected without being in a complete
+	// session before they are disconnected, forever if 0
+	JoinTimeout time.Duration
+
+	// Authorize decides whether peer may join the session of token. It may be nil to
+	// let every peer join.
+	Authorize func(peer enet.Peer, token []byte) bool
+
+	// OnSession is called once both peers joined a session, and OnClose once it is
+	// closed. They may be nil.
+	OnSession func(session *Session)
+	OnClose   func(session *Session)
+
+	// Clock tells the time bandwidth is refilled and joins time out at. Defaults to the
+	// SystemClock.
+	Clock enet.Clock
+}
+
+// queued is a reliable packet delayed by the bandwidth limit of its session.
+type queued struct {
+	to      enet.Peer
+	data    []byte
+	channel uint8
+	flags   enet.PacketFlags
+}
+
+// Session is a pair of peers whose traffic is forwarded to each other
+type Session struct {
+	token string
+	peers [2]enet.Peer
+
+	// budget is the number of bytes the session may forward, refilled over time.
+	budget    float64
+	refilled  time.Time
+	queue     []queued
+	queuedLen int
+
+	forwarded uint64
+	dropped   uint64
+}
+
+// Token returns the token the peers joined the session with, empty for sessions of
+// peers paired with Relay.Pair
+func (s *Session) Token() []byte {
+	return []byte(s.token)
+}
+
+// Peers returns the peers of the session, the second nil while it waits for it
+func (s *Session) Peers() (enet.Peer, enet.Peer) {
+	return s.peers[0], s.peers[1]
+}
+
+// Forwarded returns the number of bytes forwarded in the session
+func (s *Session) Forwarded() uint64 {
+	return s.forwarded
+}
+
+// Dropped returns the number of packets dropped for exceeding the bandwidth limit
+func (s *Session) Dropped() uint64 {
+	return s.dropped
+}
+
+// other returns the peer of the session other than peer.
+func (s *Session) other(peer enet.Peer) enet.Peer {
+	if s.peers[0] == peer {
+		return s.peers[1]
+	}
+	return s.peers[0]
+}
+
+// Relay forwards the traffic of the peers of a host, see the package documentation. It
+// is not safe for concurrent use, and must be used by the goroutine servicing the host.
+type Relay struct {
+	config Config
+
+	sessions  map[string]*Session
+	pairs     map[*Session]struct{}
+	peers     map[enet.Peer]*Session
+	connected map[enet.Peer]time.Time
+}
+
+// New creates a relay without sessions
+func New(config Config) *Relay {
+	if config.Burst <= 0 {
+		config.Burst = config.BandwidthLimit
+	}
+	if config.MaxQueued <= 0 {
+		config.MaxQueued = config.BandwidthLimit
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Relay{
+		config:    config,
+		sessions:  make(map[string]*Session),
+		pairs:     make(map[*Session]struct{}),
+		peers:     make(map[enet.Peer]*Session),
+		connected: make(map[enet.Peer]time.Time),
+	}
+}
+
+// Sessions returns the sessions of the relay, including those waiting for a peer
+func (r *Relay) Sessions() []*Session {
+	sessions := make([]*Session, 0, len(r.sessions)+len(r.pairs))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	for session := range r.pairs {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// SessionOf returns the session of peer, nil if it has not joined one
+func (r *Relay) SessionOf(peer enet.Peer) *Session {
+	return r.peers[peer]
+}
+
+// Handle handles the events of the host, forwarding the packets of peers in complete
+// sessions and dropping those of the others. The packets of receive events are owned
+// by the caller. Returns true if it consumed the event.
+func (r *Relay) Handle(event enet.Event) bool {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect:
+		r.connected[peer] = r.config.Clock.Now()
+		return true
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		delete(r.connected, peer)
+		if session, ok := r.peers[peer]; ok {
+			r.close(session, peer)
+		}
+		return true
+	case enet.EventReceive:
+	default:
+		return false
+	}
+
+	packet := event.GetPacket()
+	session, ok := r.peers[peer]
+	if !ok {
+		if event.GetChannelID() == r.config.Channel && bytes.HasPrefix(packet.GetData(), []byte(magic)) {
+			r.join(peer, packet.GetData()[len(magic):])
+		}
+		return true
+	}
+	if session.peers[1] == nil {
+		// Nobody to forward to yet.
+		return true
+	}
+	r.forward(session, session.other(peer), packet.GetData(), event.GetChannelID(), packet.GetFlags()&forwardedFlags)
+	return true
+}
+
+// join adds peer to the session of token.
+func (r *Relay) join(peer enet.Peer, token []byte) {
+	if len(token) == 0 || len(token) > MaxTokenSize {
+		return
+	}
+	if r.config.Authorize != nil && !r.config.Authorize(peer, token) {
+		peer.Disconnect(0)
+		return
+	}
+
+	session, ok := r.sessions[string(token)]
+	if !ok {
+		session = &Session{
+			token:    string(token),
+			peers:    [2]enet.Peer{peer},
+			budget:   float64(r.config.Burst),
+			refilled: r.config.Clock.Now(),
+		}
+		r.sessions[session.token] = session
+		r.peers[peer] = session
+		return
+	}
+	if session.peers[1] != nil {
+		// Sessions are for two.
+		peer.Disconnect(0)
+		return
+	}
+
+	session.peers[1] = peer
+	r.peers[peer] = session
+	for _, p := range session.peers {
+		delete(r.connected, p)
+		p.SendBytes([]byte(magic), r.config.Channel, enet.PacketFlagReliable)
+	}
+	if r.config.OnSession != nil {
+		r.config.OnSession(session)
+	}
+}
+
+// ErrPaired is returned when pairing a peer that already is in a session
+var ErrPaired = errors.New("peer already is in a relay session")
+
+// Pair puts a and b in a session of their own without them joining one, so packets
+// either sends to the relay are forwarded to the other. It is meant for forwarders
+// terminating the connection of a client and originating one to a server on its
+// behalf, like cmd/enet-proxy does. Neither peer is sent the message Ready reports.
+func (r *Relay) Pair(a, b enet.Peer) (*Session, error) {
+	if a == b || r.peers[a] != nil || r.peers[b] != nil {
+		return nil, ErrPaired
+	}
+	session := &Session{
+		peers:    [2]enet.Peer{a, b},
+		budget:   float64(r.config.Burst),
+		refilled: r.config.Clock.Now(),
+	}
+	r.pairs[session] = struct{}{}
+	for _, peer := range session.peers {
+		delete(r.connected, peer)
+		r.peers[peer] = session
+	}
+	if r.config.OnSession != nil {
+		r.config.OnSession(session)
+	}
+	return session, nil
+}
+
+// forward forwards a packet of a session to the peer to, within the bandwidth limit.
+func (r *Relay) forward(session *Session, to enet.Peer, data []byte, channel uint8, flags enet.PacketFlags) {
+	if r.config.BandwidthLimit <= 0 {
+		session.forwarded += uint64(len(data))
+		to.SendBytes(data, channel, flags)
+		return
+	}
+
+	r.refill(session)
+	if len(session.queue) == 0 && r.affords(session, len(data)) {
+		session.budget -= float64(len(data))
+		session.forwarded += uint64(len(data))
+		to.SendBytes(data, channel, flags)
+		return
+	}
+	if flags&enet.PacketFlagReliable == 0 {
+		session.dropped++
+		return
+	}
+	if len(session.queue) > 0 && session.queuedLen+len(data) > r.config.MaxQueued {
+		session.dropped++
+		r.close(session, nil)
+		return
+	}
+	session.queue = append(session.queue, queued{to: to, data: bytes.Clone(data), channel: channel, flags: flags})
+	session.queuedLen += len(data)
+}
+
+// refill adds the budget a session earned since it was last refilled.
+func (r *Relay) refill(session *Session) {
+	now := r.config.Clock.Now()
+	elapsed := now.Sub(session.refilled)
+	session.refilled = now
+	if elapsed > 0 {
+		session.budget = min(session.budget+elapsed.Seconds()*float64(r.config.BandwidthLimit), float64(r.config.Burst))
+	}
+}
+
+// affords returns true if session has the budget to forward size bytes. Packets larger
+// than the burst only wait for a full one, or they could never be forwarded.
+func (r *Relay) affords(session *Session, size int) bool {
+	return session.budget >= float64(min(size, r.config.Burst))
+}
+
+// Process forwards the reliable packets delayed by the bandwidth limit as sessions earn
+// the budget for them, and disconnects the peers that did not complete a session in
+// time. It must be called regularly, typically once per tick.
+func (r *Relay) Process() {
+	if r.config.BandwidthLimit > 0 {
+		for _, session := range r.sessions {
+			r.drain(session)
+		}
+		for session := range r.pairs {
+			r.drain(session)
+		}
+	}
+
+	if r.config.JoinTimeout > 0 {
+		now := r.config.Clock.Now()
+		for peer, connected := range r.connected {
+			if now.Sub(connected) >= r.config.JoinTimeout {
+				delete(r.connected, peer)
+				peer.Disconnect(0)
+			}
+		}
+	}
+}
+
+// drain forwards the delayed packets of a session it earned the budget for.
+func (r *Relay) drain(session *Session) {
+	if len(session.queue) == 0 {
+		return
+	}
+	r.refill(session)
+	sent := 0
+	for _, packet := range session.queue {
+		if !r.affords(session, len(packet.data)) {
+			break
+		}
+		session.budget -= float64(len(packet.data))
+		session.forwarded += uint64(len(packet.data))
+		session.queuedLen -= len(packet.data)
+		packet.to.SendBytes(packet.data, packet.channel, packet.flags)
+		sent++
+	}
+	session.queue = session.queue[sent:]
+}
+
+// close closes a session, disconnecting its peers other than gone.
+func (r *Relay) close(session *Session, gone enet.Peer) {
+	if _, paired := r.pairs[session]; paired {
+		delete(r.pairs, session)
+	} else if r.sessions[session.token] == session {
+		delete(r.sessions, session.token)
+	} else {
+		return
+	}
+	for _, peer := range session.peers {
+		if peer == nil {
+			continue
+		}
+		delete(r.peers, peer)
+		if peer != gone {
+			peer.Disconnect(0)
+		}
+	}
+	session.queue = nil
+	session.queuedLen = 0
+	if r.config.OnClose != nil {
+		r.config.OnClose(session)
+	}
+}
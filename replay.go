@@ -0,0 +1,469 @@
+package enet
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const recordMagic = "ENETREC\x01"
+
+// Recorder serializes events to a stream so they can be played back later by a host
+// created with NewReplayHost, for regression tests or to reproduce bugs from
+// production captures.
+type Recorder interface {
+	// Record writes a single event. EventNone is skipped.
+	Record(event Event) error
+
+	// Middleware returns a ServiceLoop middleware recording every event before passing
+	// it on. Errors are not reported to the loop, see Err.
+	Middleware() Middleware
+
+	// Err returns the first error the middleware ran into while recording
+	Err() error
+}
+
+type enetRecorder struct {
+	lock          sync.Mutex
+	w             io.Writer
+	wroteHeader   bool
+	middlewareErr error
+	buffer        []byte
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) Recorder {
+	return &enetRecorder{
+		w: w,
+	}
+}
+
+func (rec *enetRecorder) Record(event Event) error {
+	if event.GetType() == EventNone {
+		return nil
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	b := rec.buffer[:0]
+	if !rec.wroteHeader {
+		b = append(b, recordMagic...)
+	}
+
+	peer := event.GetPeer()
+	addr := peer.GetAddress()
+	host := addr.String()
+
+	b = append(b, byte(event.GetType()))
+	b = binary.AppendVarint(b, event.GetTimestamp().UnixNano())
+	b = binary.AppendUvarint(b, uint64(peer.GetID()))
+	b = binary.AppendUvarint(b, uint64(len(host)))
+	b = append(b, host...)
+	b = binary.AppendUvarint(b, uint64(addr.GetPort()))
+	b = append(b, event.GetChannelID())
+	b = binary.AppendUvarint(b, uint64(event.GetData()))
+
+	if event.GetType() == EventReceive {
+		payload := event.GetPacketDataUnsafe()
+		b = binary.AppendUvarint(b, uint64(event.GetPacket().GetFlags()))
+		b = binary.AppendUvarint(b, uint64(len(payload)))
+		b = append(b, payload...)
+	}
+
+	rec.buffer = b
+	if _, err := rec.w.Write(b); err != nil {
+		return err
+	}
+	rec.wroteHeader = true
+	return nil
+}
+
+func (rec *enetRecorder) Middleware() Middleware {
+	return func(event Event, next EventHandler) {
+		if err := rec.Record(event); err != nil {
+			rec.lock.Lock()
+			if rec.middlewareErr == nil {
+				rec.middlewareErr = err
+			}
+			rec.lock.Unlock()
+		}
+		next(event)
+	}
+}
+
+func (rec *enetRecorder) Err() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.middlewareErr
+}
+
+// ReplayedPacket is a packet that was sent through a ReplayHost
+type ReplayedPacket struct {
+	// PeerID is the ID of the peer the packet was sent to. It is meaningless for
+	// broadcasts.
+	PeerID    uint32
+	Broadcast bool
+	ChannelID uint8
+	Flags     PacketFlags
+	Data      []byte
+}
+
+// ReplayHost is a Host playing back events written by a Recorder instead of talking to
+// the network. Service returns the recorded events in order without waiting, with
+// their original timestamps. Packets sent to its peers are collected instead of
+// being transmitted.
+type ReplayHost interface {
+	Host
+
+	// Done reports whether all recorded events have been returned
+	Done() bool
+
+	// Err returns the error that stopped the replay early, if any
+	Err() error
+
+	// GetSent returns the packets sent through the host so far
+	GetSent() []ReplayedPacket
+}
+
+type replayHost struct {
+	r         *bufio.Reader
+	done      bool
+	destroyed bool
+	err       error
+	peers     map[uint32]*replayPeer
+
+	lock sync.Mutex
+	sent []ReplayedPacket
+}
+
+// NewReplayHost creates a host replaying the events recorded in r
+func NewReplayHost(r io.Reader) (ReplayHost, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		if err == io.EOF {
+			// Nothing was ever recorded.
+			return &replayHost{done: true, peers: map[uint32]*replayPeer{}}, nil
+		}
+		return nil, err
+	}
+	if string(magic) != recordMagic {
+		return nil, errors.New("not an event recording")
+	}
+
+	return &replayHost{
+		r:     br,
+		peers: make(map[uint32]*replayPeer),
+	}, nil
+}
+
+func (host *replayHost) Done() bool {
+	return host.done
+}
+
+func (host *replayHost) Err() error {
+	return host.err
+}
+
+func (host *replayHost) GetSent() []ReplayedPacket {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return append([]ReplayedPacket(nil), host.sent...)
+}
+
+func (host *replayHost) Destroy() error {
+	if host.destroyed {
+		return errors.New("host has been destroyed")
+	}
+	host.destroyed = true
+	host.done = true
+	return nil
+}
+
+func (host *replayHost) Service(timeout uint32) Event {
+	event := &enetEvent{}
+	host.ServiceV2(event, timeout)
+	return event
+}
+
+func (host *replayHost) ServiceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.ServiceV2, events, timeout)
+}
+
+func (host *replayHost) ServiceV2(event *enetEvent, timeout uint32) int {
+	*event = enetEvent{}
+	if host.done {
+		event.goEvent = &replayEvent{peer: &replayPeer{host: host}}
+		return 0
+	}
+
+	next, err := host.next()
+	if err != nil {
+		host.done = true
+		event.goEvent = &replayEvent{peer: &replayPeer{host: host}}
+		if err == io.EOF {
+			return 0
+		}
+		host.err = err
+		return -1
+	}
+
+	event.goEvent = next
+	event.timestamp = next.timestamp
+	return 1
+}
+
+func (host *replayHost) next() (*replayEvent, error) {
+	eventType, err := host.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// From here on a short read means the recording was cut off.
+	fail := func(err error) (*replayEvent, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading recorded event: %w", err)
+	}
+
+	timestamp, err := binary.ReadVarint(host.r)
+	if err != nil {
+		return fail(err)
+	}
+	id, err := binary.ReadUvarint(host.r)
+	if err != nil {
+		return fail(err)
+	}
+	ip, err := host.readBytes()
+	if err != nil {
+		return fail(err)
+	}
+	port, err := binary.ReadUvarint(host.r)
+	if err != nil {
+		return fail(err)
+	}
+	channel, err := host.r.ReadByte()
+	if err != nil {
+		return fail(err)
+	}
+	data, err := binary.ReadUvarint(host.r)
+	if err != nil {
+		return fail(err)
+	}
+
+	event := &replayEvent{
+		eventType: EventType(eventType),
+		channelID: channel,
+		data:      uint32(data),
+		timestamp: time.Unix(0, timestamp),
+	}
+
+	if event.eventType == EventReceive {
+		flags, err := binary.ReadUvarint(host.r)
+		if err != nil {
+			return fail(err)
+		}
+		payload, err := host.readBytes()
+		if err != nil {
+			return fail(err)
+		}
+		event.packet = replayPacket{data: payload, flags: PacketFlags(flags)}
+	}
+
+	peer, ok := host.peers[uint32(id)]
+	if !ok || event.eventType == EventConnect {
+		// Peer slots are reused by enet, so a connect always starts a fresh peer.
+		peer = &replayPeer{host: host, id: uint32(id)}
+		host.peers[peer.id] = peer
+	}
+	peer.ip, peer.port = string(ip), uint16(port)
+	event.peer = peer
+
+	return event, nil
+}
+
+func (host *replayHost) readBytes() ([]byte, error) {
+	length, err := binary.ReadUvarint(host.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(host.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (host *replayHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	return nil, errors.New("can't connect from a replay host")
+}
+
+func (host *replayHost) BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error {
+	host.record(ReplayedPacket{
+		Broadcast: true,
+		ChannelID: channel,
+		Flags:     flags,
+		Data:      append([]byte(nil), data...),
+	})
+	return nil
+}
+
+func (host *replayHost) BroadcastPacket(packet Packet, channel uint8) error {
+	defer packet.Destroy()
+	return host.BroadcastBytes(packet.GetData(), channel, packet.GetFlags())
+}
+
+func (host *replayHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
+}
+
+func (host *replayHost) record(packet ReplayedPacket) {
+	host.lock.Lock()
+	host.sent = append(host.sent, packet)
+	host.lock.Unlock()
+}
+
+func (host *replayHost) GetBytesSent() uint32       { return 0 }
+func (host *replayHost) GetBytesReceived() uint32   { return 0 }
+func (host *replayHost) GetPacketsSent() uint32     { return 0 }
+func (host *replayHost) GetPacketsReceived() uint32 { return 0 }
+func (host *replayHost) ResetBytesSent()            {}
+func (host *replayHost) ResetBytesReceived()        {}
+func (host *replayHost) ResetPacketsSent()          {}
+func (host *replayHost) ResetPacketsReceived()      {}
+func (host *replayHost) GetStats() HostStats        { return HostStats{} }
+
+// ApplyConfig only validates config, the events replayed are not limited.
+func (host *replayHost) ApplyConfig(config HostConfig) error {
+	_, err := config.validate()
+	return err
+}
+
+type replayEvent struct {
+	eventType EventType
+	peer      *replayPeer
+	channelID uint8
+	data      uint32
+	packet    replayPacket
+	timestamp time.Time
+}
+
+func (event *replayEvent) GetType() EventType          { return event.eventType }
+func (event *replayEvent) GetPeer() Peer               { return event.peer }
+func (event *replayEvent) GetChannelID() uint8         { return event.channelID }
+func (event *replayEvent) GetData() uint32             { return event.data }
+func (event *replayEvent) GetPacket() Packet           { return event.packet }
+func (event *replayEvent) GetPacketDataUnsafe() []byte { return event.packet.data }
+func (event *replayEvent) GetTimestamp() time.Time     { return event.timestamp }
+
+func (event *replayEvent) ReadPayload(buf []byte) (int, error) {
+	if event.eventType != EventReceive {
+		return 0, errNoPayload
+	}
+	return readPayload(event.packet.data, buf)
+}
+
+type replayPacket struct {
+	data  []byte
+	flags PacketFlags
+}
+
+func (packet replayPacket) Destroy() error {
+	return nil
+}
+
+func (packet replayPacket) GetData() []byte {
+	return append([]byte(nil), packet.data...)
+}
+
+func (packet replayPacket) GetFlags() PacketFlags {
+	return packet.flags
+}
+
+type replayPeer struct {
+	PeerTags
+
+	host *replayHost
+	id   uint32
+	ip   string
+	port uint16
+	data []byte
+}
+
+func (peer *replayPeer) GetAddress() Address {
+	return NewAddress(peer.ip, peer.port)
+}
+
+func (peer *replayPeer) GetID() uint32 {
+	return peer.id
+}
+
+func (peer *replayPeer) Identity() []byte {
+	return nil
+}
+
+func (peer *replayPeer) PublicKey() ed25519.PublicKey {
+	return nil
+}
+
+func (peer *replayPeer) Disconnect(data uint32)                          {}
+func (peer *replayPeer) DisconnectNow(data uint32)                       {}
+func (peer *replayPeer) DisconnectLater(data uint32)                     {}
+func (peer *replayPeer) SetTimeout(limit uint32, min uint32, max uint32) {}
+func (peer *replayPeer) PingInterval(interval uint32)                    {}
+
+func (peer *replayPeer) SendBytes(data []byte, channel uint8, flags PacketFlags) error {
+	peer.host.record(ReplayedPacket{
+		PeerID:    peer.id,
+		ChannelID: channel,
+		Flags:     flags,
+		Data:      append([]byte(nil), data...),
+	})
+	return nil
+}
+
+func (peer *replayPeer) SendString(str string, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
+
+func (peer *replayPeer) SendPacket(packet Packet, channel uint8) error {
+	// Sending hands the packet over, so it has to be freed here like enet would.
+	defer packet.Destroy()
+	return peer.SendBytes(packet.GetData(), channel, packet.GetFlags())
+}
+
+func (peer *replayPeer) SendAsync(data []byte, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes(data, channel, flags)
+}
+
+func (peer *replayPeer) SetData(data []byte) {
+	peer.data = append([]byte(nil), data...)
+}
+
+func (peer *replayPeer) GetData() []byte {
+	return append([]byte(nil), peer.data...)
+}
+
+func (peer *replayPeer) GetBytesSent() uint64     { return 0 }
+func (peer *replayPeer) GetBytesReceived() uint64 { return 0 }
+func (peer *replayPeer) GetPacketsSent() uint64   { return 0 }
+func (peer *replayPeer) GetPacketsLost() uint64   { return 0 }
+
+// EstimatedBandwidth returns 0, replayed peers are never sent to.
+func (peer *replayPeer) EstimatedBandwidth() uint32 { return 0 }
+
+// SimulateConditions fails, replayed peers have no connection to degrade.
+func (peer *replayPeer) SimulateConditions(latency, jitter time.Duration, loss float64) error {
+	return errors.New("conditions cannot be simulated for replayed peers")
+}
+
+// ClockOffset returns 0, the clocks of replayed peers are not sampled.
+func (peer *replayPeer) ClockOffset() time.Duration { return 0 }
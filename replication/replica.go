@@ -0,0 +1,168 @@
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+var errMalformed = errors.New("malformed replication message")
+
+// RemoteObject is the replica of an object of a Replicator
+type RemoteObject struct {
+	ID     uint32
+	Type   uint16
+	Fields [][]byte
+}
+
+// ReplicaConfig configures a Replica
+type ReplicaConfig struct {
+	// Channel is the channel the replicator sends changes on
+	Channel uint8
+
+	// OnCreate is called once an object is created, OnUpdate once fields of an object
+	// changed, with a bit set for every field that did, and OnDestroy once an object is
+	// destroyed. They may be nil.
+	OnCreate  func(object *RemoteObject)
+	OnUpdate  func(object *RemoteObject, changed uint64)
+	OnDestroy func(object *RemoteObject)
+}
+
+// Replica mirrors the objects a Replicator replicates, see the package documentation.
+// It is not safe for concurrent use.
+type Replica struct {
+	config  ReplicaConfig
+	tick    uint32
+	objects map[uint32]*RemoteObject
+}
+
+// NewReplica creates a replica without objects
+func NewReplica(config ReplicaConfig) *Replica {
+	return &Replica{
+		config:  config,
+		objects: make(map[uint32]*RemoteObject),
+	}
+}
+
+// Object returns the object with id, nil if there is none
+func (r *Replica) Object(id uint32) *RemoteObject {
+	return r.objects[id]
+}
+
+// Objects returns the objects of the replica
+func (r *Replica) Objects() []*RemoteObject {
+	objects := make([]*RemoteObject, 0, len(r.objects))
+	for _, object := range r.objects {
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// Tick returns the tick of the replicator the replica is up to date with
+func (r *Replica) Tick() uint32 {
+	return r.tick
+}
+
+// Handle applies the changes received on the replication channel, returning true if
+// the event carried some. The packet of the event is left to the caller.
+func (r *Replica) Handle(event enet.Event) bool {
+	if event.GetType() != enet.EventReceive || event.GetChannelID() != r.config.Channel {
+		return false
+	}
+	r.Apply(event.GetPacket().GetData())
+	return true
+}
+
+// Apply applies a message of the replicator to the replica. The records before a
+// malformed one are applied.
+func (r *Replica) Apply(data []byte) error {
+	if len(data) < 4 {
+		return errMalformed
+	}
+	r.tick = binary.BigEndian.Uint32(data)
+	reader := &reader{data: data[4:]}
+	for len(reader.data) > 0 && reader.err == nil {
+		kind := reader.data[0]
+		reader.data = reader.data[1:]
+		id := uint32(reader.uvarint())
+
+		switch kind {
+		case recordCreate:
+			object := &RemoteObject{ID: id, Type: uint16(reader.uvarint())}
+			count := reader.uvarint()
+			if count > MaxFields {
+				return errMalformed
+			}
+			object.Fields = make([][]byte, count)
+			for i := range object.Fields {
+				object.Fields[i] = reader.bytes()
+			}
+			if reader.err != nil {
+				break
+			}
+			r.objects[id] = object
+			if r.config.OnCreate != nil {
+				r.config.OnCreate(object)
+			}
+		case recordUpdate:
+			changed := reader.uvarint()
+			object, ok := r.objects[id]
+			if !ok || bits.Len64(changed) > len(object.Fields) {
+				return errMalformed
+			}
+			for mask := changed; mask != 0 && reader.err == nil; mask &= mask - 1 {
+				object.Fields[bits.TrailingZeros64(mask)] = reader.bytes()
+			}
+			if reader.err == nil && r.config.OnUpdate != nil {
+				r.config.OnUpdate(object, changed)
+			}
+		case recordDestroy:
+			object, ok := r.objects[id]
+			if !ok || reader.err != nil {
+				break
+			}
+			delete(r.objects, id)
+			if r.config.OnDestroy != nil {
+				r.config.OnDestroy(object)
+			}
+		default:
+			return errMalformed
+		}
+	}
+	return reader.err
+}
+
+// reader reads the records of a replication message.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errMalformed
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.err = errMalformed
+		return nil
+	}
+	ret := append([]byte(nil), r.data[:n]...)
+	r.data = r.data[n:]
+	return ret
+}
@@ -0,0 +1,249 @@
+// Package replication replicates the state of objects from a server to its peers. The
+// server registers objects with fields it sets, which mark them dirty, and every tick
+// the replicator sends each peer the fields that changed among the objects it is
+// interested in. Peers joining late, or gaining interest in an object, get it whole:
+//
+//	replicator := replication.NewReplicator(replication.Config{Channel: 2})
+//	player, err := replicator.Register(playerType, 3)
+//	player.Set(0, name)
+//	replicator.AddPeer(peer)
+//
+//	// On the goroutine servicing the host, every tick:
+//	player.Set(1, position)
+//	replicator.Tick()
+//
+// Peers apply the changes to a Replica, which mirrors the objects:
+//
+//	replica := replication.NewReplica(replication.ReplicaConfig{
+//		Channel:  2,
+//		OnCreate: func(object *replication.RemoteObject) { spawn(object) },
+//		OnUpdate: func(object *replication.RemoteObject, changed uint64) { move(object) },
+//	})
+//
+//	// For every event:
+//	if replica.Handle(event) {
+//		return
+//	}
+//
+// Changes are sent reliably, as every one builds on the ones before.
+package replication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// MaxFields is the number of fields objects may have at most
+const MaxFields = 64
+
+// Kinds of records in replication messages.
+const (
+	recordCreate byte = iota
+	recordUpdate
+	recordDestroy
+)
+
+// Object is an object replicated to the peers of a Replicator
+type Object struct {
+	id      uint32
+	kind    uint16
+	fields  [][]byte
+	dirty   uint64
+	removed bool
+}
+
+// ID returns the ID of the object, the same for its replicas
+func (o *Object) ID() uint32 {
+	return o.id
+}
+
+// Type returns the type of the object, as it was registered
+func (o *Object) Type() uint16 {
+	return o.kind
+}
+
+// Get returns the value of a field
+func (o *Object) Get(field int) []byte {
+	return o.fields[field]
+}
+
+// Set sets the value of a field, which is sent to the peers interested in the object
+// on the next tick if it changed. Panics if the field is out of range.
+func (o *Object) Set(field int, value []byte) {
+	if bytes.Equal(o.fields[field], value) && o.fields[field] != nil {
+		return
+	}
+	o.fields[field] = append([]byte{}, value...)
+	o.dirty |= 1 << field
+}
+
+// MarkDirty marks a field as changed, sending it again on the next tick
+func (o *Object) MarkDirty(field int) {
+	o.dirty |= 1 << field
+}
+
+// Config configures a Replicator
+type Config struct {
+	// Channel is the channel changes are sent on
+	Channel uint8
+
+	// Interest decides whether peer is interested in object, and is called on every
+	// tick. Peers get the objects they gain interest in whole, and are told to destroy
+	// those they lose interest in. It may be nil for peers to be interested in every
+	// object.
+	Interest func(peer enet.Peer, object *Object) bool
+}
+
+// replicaPeer is a peer of a replicator, with the objects it knows of.
+type replicaPeer struct {
+	known map[uint32]struct{}
+}
+
+// Replicator replicates objects to peers, see the package documentation. It is not safe
+// for concurrent use, and must be used by the goroutine servicing the host of its
+// peers.
+type Replicator struct {
+	config Config
+
+	tick    uint32
+	nextID  uint32
+	objects map[uint32]*Object
+	order   []*Object
+	peers   map[enet.Peer]*replicaPeer
+}
+
+// NewReplicator creates a replicator without objects nor peers
+func NewReplicator(config Config) *Replicator {
+	return &Replicator{
+		config:  config,
+		objects: make(map[uint32]*Object),
+		peers:   make(map[enet.Peer]*replicaPeer),
+	}
+}
+
+// Register registers an object of type kind with fields fields, all initially empty
+func (r *Replicator) Register(kind uint16, fields int) (*Object, error) {
+	if fields <= 0 || fields > MaxFields {
+		return nil, errors.New("invalid number of fields")
+	}
+	r.nextID++
+	object := &Object{
+		id:     r.nextID,
+		kind:   kind,
+		fields: make([][]byte, fields),
+	}
+	r.objects[object.id] = object
+	r.order = append(r.order, object)
+	return object, nil
+}
+
+// Unregister stops replicating object, whose replicas are destroyed on the next tick
+func (r *Replicator) Unregister(object *Object) {
+	if r.objects[object.id] != object {
+		return
+	}
+	delete(r.objects, object.id)
+	object.removed = true
+}
+
+// Object returns the object with id, nil if there is none
+func (r *Replicator) Object(id uint32) *Object {
+	return r.objects[id]
+}
+
+// AddPeer adds peer to the peers objects are replicated to. It gets the objects it is
+// interested in whole on the next tick.
+func (r *Replicator) AddPeer(peer enet.Peer) {
+	if _, ok := r.peers[peer]; !ok {
+		r.peers[peer] = &replicaPeer{known: make(map[uint32]struct{})}
+	}
+}
+
+// RemovePeer stops replicating objects to peer. Handle does it for the disconnect
+// events it sees.
+func (r *Replicator) RemovePeer(peer enet.Peer) {
+	delete(r.peers, peer)
+}
+
+// Handle removes peers once they disconnect. It can be called for every event
+// serviced.
+func (r *Replicator) Handle(event enet.Event) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		r.RemovePeer(event.GetPeer())
+	}
+}
+
+// Tick sends every peer the changes of the objects it is interested in since the last
+// tick, as a single message. It must be called once per tick, after the objects have
+// been updated.
+func (r *Replicator) Tick() error {
+	r.tick++
+
+	var errs []error
+	for peer, state := range r.peers {
+		data := binary.BigEndian.AppendUint32(nil, r.tick)
+		empty := len(data)
+		for _, object := range r.order {
+			_, known := state.known[object.id]
+			interested := !object.removed && (r.config.Interest == nil || r.config.Interest(peer, object))
+			switch {
+			case interested && !known:
+				data = appendCreate(data, object)
+				state.known[object.id] = struct{}{}
+			case interested && object.dirty != 0:
+				data = appendUpdate(data, object, object.dirty)
+			case !interested && known:
+				data = append(data, recordDestroy)
+				data = binary.AppendUvarint(data, uint64(object.id))
+				delete(state.known, object.id)
+			}
+		}
+		if len(data) == empty {
+			continue
+		}
+		if err := peer.SendBytes(data, r.config.Channel, enet.PacketFlagReliable); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	order := r.order[:0]
+	for _, object := range r.order {
+		object.dirty = 0
+		if !object.removed {
+			order = append(order, object)
+		}
+	}
+	clear(r.order[len(order):])
+	r.order = order
+	return errors.Join(errs...)
+}
+
+func appendCreate(data []byte, object *Object) []byte {
+	data = append(data, recordCreate)
+	data = binary.AppendUvarint(data, uint64(object.id))
+	data = binary.AppendUvarint(data, uint64(object.kind))
+	data = binary.AppendUvarint(data, uint64(len(object.fields)))
+	for _, field := range object.fields {
+		data = binary.AppendUvarint(data, uint64(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
+
+func appendUpdate(data []byte, object *Object, changed uint64) []byte {
+	data = append(data, recordUpdate)
+	data = binary.AppendUvarint(data, uint64(object.id))
+	data = binary.AppendUvarint(data, changed)
+	for changed != 0 {
+		field := object.fields[bits.TrailingZeros64(changed)]
+		data = binary.AppendUvarint(data, uint64(len(field)))
+		data = append(data, field...)
+		changed &= changed - 1
+	}
+	return data
+}
@@ -0,0 +1,470 @@
+// Package rooms groups the peers of a server into rooms, the lobbies and matches nearly
+// every multiplayer server keeps. Peers join and leave rooms, messages are broadcast to
+// a room as a single packet shared by its members, and events of members are routed to
+// the handler of their room:
+//
+//	manager := rooms.NewManager(rooms.Config{MaxMembers: 8})
+//	room, err := manager.Create("", rooms.Options{Metadata: map[string]string{"map": "dust"}})
+//	room.SetHandler(func(room *rooms.Room, event enet.Event) {
+//		room.Broadcast(event.GetPacket().GetData(), 0, enet.PacketFlagReliable)
+//	})
+//	manager.Join(peer, room.ID())
+//
+//	// On the goroutine servicing the host, for every event:
+//	if manager.Handle(event) {
+//		return
+//	}
+//
+// A peer is in at most one room at a time. Every room has an owner, the member with
+// authority over it such as the host of a peer-to-peer match, which migrates to another
+// member when the owner leaves.
+package rooms
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+var (
+	// ErrNotFound is returned for rooms that do not exist
+	ErrNotFound = errors.New("room not found")
+
+	// ErrExists is returned when creating a room with the ID of another
+	ErrExists = errors.New("room already exists")
+
+	// ErrFull is returned when joining a room that has as many members as it may have
+	ErrFull = errors.New("room is full")
+)
+
+// Handler handles the events of the members of a room. It's called by the goroutine
+// servicing the host, and the packets of receive events are owned by the caller of
+// Manager.Handle.
+type Handler func(room *Room, event enet.Event)
+
+// Config configures a Manager
+type Config struct {
+	// MaxMembers is the number of members rooms may have by default, unlimited if 0
+	MaxMembers int
+
+	// KeepEmpty keeps rooms open once their last member left. By default they are
+	// closed.
+	KeepEmpty bool
+
+	// OnJoin and OnLeave are called as peers join and leave rooms, including when they
+	// disconnect. They may be nil.
+	OnJoin  func(room *Room, peer enet.Peer)
+	OnLeave func(room *Room, peer enet.Peer)
+
+	// OnClose is called once a room is closed. It may be nil.
+	OnClose func(room *Room)
+
+	// ChooseOwner picks the new owner of a room among its remaining members, in the
+	// order they joined, when its owner leaves. Defaults to the member that joined
+	// first.
+	ChooseOwner func(room *Room, members []enet.Peer) enet.Peer
+
+	// OnMigrate is called once the owner of a room changed, to hand authority over to
+	// the new owner. It may be nil.
+	OnMigrate func(room *Room, previous, owner enet.Peer)
+}
+
+// Options are the options of a room
+type Options struct {
+	// MaxMembers overrides the default of the manager if not 0
+	MaxMembers int
+
+	// Metadata is the initial metadata of the room, such as its map or mode
+	Metadata map[string]string
+}
+
+// Manager holds the rooms of a server. Its methods are safe to call from any goroutine,
+// apart from Handle and broadcasting, which must be done by the goroutine servicing
+// the host of the members.
+type Manager struct {
+	config Config
+
+	lock    sync.Mutex
+	rooms   map[string]*Room
+	members map[enet.Peer]*Room
+	topics  enet.Topics
+}
+
+// NewManager creates a manager without rooms
+func NewManager(config Config) *Manager {
+	return &Manager{
+		config:  config,
+		rooms:   make(map[string]*Room),
+		members: make(map[enet.Peer]*Room),
+		topics:  enet.NewTopics(),
+	}
+}
+
+// Create creates a room with the ID id, or a random one if empty
+func (m *Manager) Create(id string, options Options) (*Room, error) {
+	if id == "" {
+		id = randomID()
+	}
+	maxMembers := options.MaxMembers
+	if maxMembers == 0 {
+		maxMembers = m.config.MaxMembers
+	}
+	room := &Room{
+		id:         id,
+		manager:    m,
+		maxMembers: maxMembers,
+		metadata:   maps.Clone(options.Metadata),
+	}
+	if room.metadata == nil {
+		room.metadata = make(map[string]string)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.rooms[id]; ok {
+		return nil, ErrExists
+	}
+	m.rooms[id] = room
+	return room, nil
+}
+
+// Room returns the room with the ID id, nil if there is none
+func (m *Manager) Room(id string) *Room {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.rooms[id]
+}
+
+// Rooms returns the open rooms
+func (m *Manager) Rooms() []*Room {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return slices.Collect(maps.Values(m.rooms))
+}
+
+// RoomOf returns the room peer is in, nil if none
+func (m *Manager) RoomOf(peer enet.Peer) *Room {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.members[peer]
+}
+
+// Join makes peer join the room with the ID id, leaving the room it was in if any. The
+// first member of a room becomes its owner.
+func (m *Manager) Join(peer enet.Peer, id string) (*Room, error) {
+	m.lock.Lock()
+	room, ok := m.rooms[id]
+	if !ok {
+		m.lock.Unlock()
+		return nil, ErrNotFound
+	}
+	if m.members[peer] == room {
+		m.lock.Unlock()
+		return room, nil
+	}
+	if room.maxMembers > 0 && len(room.members) >= room.maxMembers {
+		m.lock.Unlock()
+		return nil, ErrFull
+	}
+	left := m.leave(peer)
+	room.members = append(room.members, peer)
+	if room.owner == nil {
+		room.owner = peer
+	}
+	m.members[peer] = room
+	m.topics.Subscribe(peer, id)
+	m.lock.Unlock()
+
+	left.notify(m.config)
+	if m.config.OnJoin != nil {
+		m.config.OnJoin(room, peer)
+	}
+	return room, nil
+}
+
+// Leave makes peer leave the room it is in, if any
+func (m *Manager) Leave(peer enet.Peer) {
+	m.lock.Lock()
+	left := m.leave(peer)
+	m.lock.Unlock()
+	left.notify(m.config)
+}
+
+// Close closes the room with the ID id, making its members leave it
+func (m *Manager) Close(id string) {
+	m.lock.Lock()
+	room, ok := m.rooms[id]
+	if !ok {
+		m.lock.Unlock()
+		return
+	}
+	var departures []departure
+	room.owner = nil
+	for len(room.members) > 0 {
+		departures = append(departures, m.leaveRoom(room, room.members[len(room.members)-1], false))
+	}
+	delete(m.rooms, id)
+	room.closed = true
+	m.lock.Unlock()
+
+	for _, left := range departures {
+		left.notify(m.config)
+	}
+	if m.config.OnClose != nil {
+		m.config.OnClose(room)
+	}
+}
+
+// Handle routes the receive events of members to the handler of their room, returning
+// true if it did, and makes peers leave their room once they disconnect, passing the
+// disconnect event on to the handler of the room they left. It must be called by the
+// goroutine servicing the host for every event serviced.
+func (m *Manager) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		room := m.RoomOf(event.GetPeer())
+		m.Leave(event.GetPeer())
+		if room != nil {
+			if handler := room.Handler(); handler != nil {
+				handler(room, event)
+			}
+		}
+		return false
+	case enet.EventReceive:
+		room := m.RoomOf(event.GetPeer())
+		if room == nil {
+			return false
+		}
+		handler := room.Handler()
+		if handler == nil {
+			return false
+		}
+		handler(room, event)
+		return true
+	}
+	return false
+}
+
+// Middleware returns a middleware routing the events of members to the handler of their
+// room like Handle, passing on the events it did not route.
+func (m *Manager) Middleware() enet.Middleware {
+	return func(event enet.Event, next enet.EventHandler) {
+		if !m.Handle(event) {
+			next(event)
+		}
+	}
+}
+
+// departure is a member leaving a room, to be notified once the lock is released.
+type departure struct {
+	room     *Room
+	peer     enet.Peer
+	previous enet.Peer
+	owner    enet.Peer
+	closed   bool
+}
+
+// leave makes peer leave its room. Must be called with the lock held.
+func (m *Manager) leave(peer enet.Peer) departure {
+	room, ok := m.members[peer]
+	if !ok {
+		return departure{}
+	}
+	return m.leaveRoom(room, peer, !m.config.KeepEmpty)
+}
+
+// leaveRoom makes peer leave room, migrating its ownership and closing it once empty
+// if closeEmpty. Must be called with the lock held.
+func (m *Manager) leaveRoom(room *Room, peer enet.Peer, closeEmpty bool) departure {
+	delete(m.members, peer)
+	m.topics.Unsubscribe(peer, room.id)
+	for i, member := range room.members {
+		if member == peer {
+			room.members = append(room.members[:i], room.members[i+1:]...)
+			break
+		}
+	}
+
+	left := departure{room: room, peer: peer}
+	if room.owner == peer {
+		room.owner = nil
+		if len(room.members) > 0 {
+			owner := room.members[0]
+			if m.config.ChooseOwner != nil {
+				if chosen := m.config.ChooseOwner(room, append([]enet.Peer(nil), room.members...)); chosen != nil {
+					owner = chosen
+				}
+			}
+			room.owner = owner
+			left.previous, left.owner = peer, owner
+		}
+	}
+	if closeEmpty && len(room.members) == 0 {
+		delete(m.rooms, room.id)
+		room.closed = true
+		left.closed = true
+	}
+	return left
+}
+
+// notify calls the callbacks of a departure.
+func (left departure) notify(config Config) {
+	if left.room == nil {
+		return
+	}
+	if config.OnLeave != nil {
+		config.OnLeave(left.room, left.peer)
+	}
+	if left.owner != nil && config.OnMigrate != nil {
+		config.OnMigrate(left.room, left.previous, left.owner)
+	}
+	if left.closed && config.OnClose != nil {
+		config.OnClose(left.room)
+	}
+}
+
+// Room is a group of peers, see Manager
+type Room struct {
+	id      string
+	manager *Manager
+
+	// Guarded by the lock of the manager.
+	members    []enet.Peer
+	owner      enet.Peer
+	maxMembers int
+	metadata   map[string]string
+	handler    Handler
+	closed     bool
+}
+
+// ID returns the ID of the room
+func (room *Room) ID() string {
+	return room.id
+}
+
+// Members returns the members of the room, in the order they joined
+func (room *Room) Members() []enet.Peer {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return append([]enet.Peer(nil), room.members...)
+}
+
+// Len returns the number of members of the room
+func (room *Room) Len() int {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return len(room.members)
+}
+
+// Owner returns the owner of the room, nil if it has no members
+func (room *Room) Owner() enet.Peer {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return room.owner
+}
+
+// SetOwner hands the ownership of the room over to peer, which must be a member
+func (room *Room) SetOwner(peer enet.Peer) error {
+	m := room.manager
+	m.lock.Lock()
+	if m.members[peer] != room {
+		m.lock.Unlock()
+		return errors.New("peer is not a member of the room")
+	}
+	previous := room.owner
+	room.owner = peer
+	m.lock.Unlock()
+
+	if previous != peer && m.config.OnMigrate != nil {
+		m.config.OnMigrate(room, previous, peer)
+	}
+	return nil
+}
+
+// Closed returns true once the room is closed
+func (room *Room) Closed() bool {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return room.closed
+}
+
+// Metadata returns the metadata of the room under key
+func (room *Room) Metadata(key string) (string, bool) {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	value, ok := room.metadata[key]
+	return value, ok
+}
+
+// AllMetadata returns a copy of the metadata of the room
+func (room *Room) AllMetadata() map[string]string {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return maps.Clone(room.metadata)
+}
+
+// SetMetadata sets the metadata of the room under key, deleting it if value is empty
+func (room *Room) SetMetadata(key, value string) {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	if value == "" {
+		delete(room.metadata, key)
+	} else {
+		room.metadata[key] = value
+	}
+}
+
+// Handler returns the handler of the events of the members of the room
+func (room *Room) Handler() Handler {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	return room.handler
+}
+
+// SetHandler sets the handler of the events of the members of the room. Without one,
+// their events are not routed.
+func (room *Room) SetHandler(handler Handler) {
+	room.manager.lock.Lock()
+	defer room.manager.lock.Unlock()
+	room.handler = handler
+}
+
+// Broadcast sends data to the members of the room as a single packet they share. It
+// must be called by the goroutine servicing the host of the members.
+func (room *Room) Broadcast(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return room.manager.topics.Publish(room.id, data, channel, flags)
+}
+
+// BroadcastPacket is like Broadcast, handing the packet over to the members. The packet
+// must not be used afterwards.
+func (room *Room) BroadcastPacket(packet enet.Packet, channel uint8) error {
+	return room.manager.topics.PublishPacket(room.id, packet, channel)
+}
+
+// BroadcastExcept sends data to the members of the room other than except, such as
+// the member it came from. It must be called by the goroutine servicing the host of
+// the members.
+func (room *Room) BroadcastExcept(except enet.Peer, data []byte, channel uint8, flags enet.PacketFlags) error {
+	var errs []error
+	for _, member := range room.Members() {
+		if member == except {
+			continue
+		}
+		if err := member.SendBytes(data, channel, flags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// randomID returns a random room ID.
+func randomID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,249 @@
+// Package rtcbridge lets browser clients connect to an enet host over WebRTC data
+// channels. Each WebRTC connection shows up as a virtual peer in the event stream of a
+// host created with enet.NewBridgedHost, so peer to peer browser clients can join
+// servers without a separate networking stack.
+//
+// Clients open a data channel labelled "reliable" with the default settings and,
+// optionally, one labelled "unreliable" created with ordered set to false and
+// maxRetransmits set to 0 before creating their offer. The peer connects once the
+// reliable channel is open. Every message carries one packet: the channel ID in the
+// first byte, followed by the payload. Reliable packets use the reliable data channel,
+// the others the unreliable one if the client opened it.
+//
+// Signaling is left to the application, see Bridge.Accept, or can be done by posting
+// the offer to the bridge mounted as an http.Handler. WebRTC has no way to pass data
+// along with a disconnect, so disconnect events always carry 0.
+package rtcbridge
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/internal/bridge"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	reliableLabel   = "reliable"
+	unreliableLabel = "unreliable"
+
+	// maxOfferSize bounds the size of offers posted to ServeHTTP.
+	maxOfferSize = 64 * 1024
+)
+
+// Config configures a Bridge
+type Config struct {
+	// WebRTC configures the connections to clients, for example with the ICE servers
+	// to use
+	WebRTC webrtc.Configuration
+
+	// GatherTimeout bounds how long Accept waits for ICE candidates to be gathered.
+	// Defaults to 5 seconds.
+	GatherTimeout time.Duration
+}
+
+// Bridge accepts WebRTC connections and implements enet.Bridge
+type Bridge struct {
+	hub    *bridge.Hub
+	config Config
+}
+
+// New creates a bridge
+func New(config Config) *Bridge {
+	if config.GatherTimeout <= 0 {
+		config.GatherTimeout = 5 * time.Second
+	}
+
+	return &Bridge{
+		hub:    bridge.NewHub(),
+		config: config,
+	}
+}
+
+// Events implements enet.Bridge
+func (b *Bridge) Events() <-chan enet.Event {
+	return b.hub.Events()
+}
+
+// Broadcast implements enet.Bridge
+func (b *Bridge) Broadcast(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return b.hub.Broadcast(data, channel, flags)
+}
+
+// Close implements enet.Bridge. Connections accepted from then on are refused.
+func (b *Bridge) Close() error {
+	return b.hub.Close()
+}
+
+// Accept answers the offer of a client. The client connects as a peer once its
+// reliable data channel is open, with data as the data of the connect event. The
+// answer includes all ICE candidates, so no further signaling is needed.
+func (b *Bridge) Accept(offer webrtc.SessionDescription, data uint32) (webrtc.SessionDescription, error) {
+	pc, err := webrtc.NewPeerConnection(b.config.WebRTC)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	t := &transport{pc: pc}
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		switch dc.Label() {
+		case reliableLabel:
+			dc.OnOpen(func() {
+				t.reliable = dc
+				if err := t.connect(b.hub, data); err != nil {
+					pc.Close()
+				}
+			})
+			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+				t.receive(msg, enet.PacketFlagReliable)
+			})
+			dc.OnClose(func() {
+				t.disconnected(enet.EventDisconnect)
+			})
+
+		case unreliableLabel:
+			dc.OnOpen(func() {
+				t.unreliable.Store(dc)
+			})
+			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+				t.receive(msg, 0)
+			})
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			t.disconnected(enet.EventDisconnectTimeout)
+			pc.Close()
+		case webrtc.PeerConnectionStateClosed:
+			t.disconnected(enet.EventDisconnect)
+		}
+	})
+
+	answer, err := b.answer(pc, offer)
+	if err != nil {
+		pc.Close()
+		return webrtc.SessionDescription{}, err
+	}
+	return answer, nil
+}
+
+func (b *Bridge) answer(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	timer := time.NewTimer(b.config.GatherTimeout)
+	defer timer.Stop()
+	select {
+	case <-gathered:
+	case <-timer.C:
+		return webrtc.SessionDescription{}, errors.New("timed out gathering ICE candidates")
+	}
+	return *pc.LocalDescription(), nil
+}
+
+// ServeHTTP accepts an SDP offer posted as the request body and replies with the
+// answer. The data of the connect event can be passed in the "data" query parameter.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "offers must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data uint32
+	if s := r.URL.Query().Get("data"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "malformed connect data", http.StatusBadRequest)
+			return
+		}
+		data = uint32(n)
+	}
+
+	sdp, err := io.ReadAll(io.LimitReader(r.Body, maxOfferSize))
+	if err != nil {
+		http.Error(w, "unable to read offer", http.StatusBadRequest)
+		return
+	}
+
+	answer, err := b.Accept(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(sdp)}, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer.SDP)
+}
+
+type transport struct {
+	pc         *webrtc.PeerConnection
+	reliable   *webrtc.DataChannel
+	unreliable atomic.Pointer[webrtc.DataChannel]
+
+	// peer is set once the reliable channel is open.
+	peer atomic.Pointer[bridge.Peer]
+}
+
+func (t *transport) connect(hub *bridge.Hub, data uint32) error {
+	peer, err := hub.Connect(t, data)
+	if err != nil {
+		return err
+	}
+	t.peer.Store(peer)
+	return nil
+}
+
+func (t *transport) receive(msg webrtc.DataChannelMessage, flags enet.PacketFlags) {
+	peer := t.peer.Load()
+	if peer == nil || msg.IsString || len(msg.Data) == 0 {
+		return
+	}
+	peer.Receive(msg.Data[0], flags, msg.Data[1:])
+}
+
+func (t *transport) disconnected(eventType enet.EventType) {
+	if peer := t.peer.Load(); peer != nil {
+		peer.Disconnected(eventType, 0)
+	}
+}
+
+func (t *transport) Send(channel uint8, flags enet.PacketFlags, data []byte) error {
+	dc := t.reliable
+	if flags&enet.PacketFlagReliable == 0 {
+		if unreliable := t.unreliable.Load(); unreliable != nil {
+			dc = unreliable
+		}
+	}
+
+	return dc.Send(append([]byte{channel}, data...))
+}
+
+func (t *transport) Close(data uint32) error {
+	return t.pc.Close()
+}
+
+func (t *transport) RemoteAddr() net.Addr {
+	pair, err := t.pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: net.ParseIP(pair.Remote.Address), Port: int(pair.Remote.Port)}
+}
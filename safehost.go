@@ -0,0 +1,411 @@
+package enet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SafeHost is a Host that can be used from any goroutine. Enet hosts are not thread
+// safe, so a SafeHost funnels every operation on the host, its peers and its packets
+// through a queue that is drained by the goroutine calling Run, which is the only
+// one ever touching the wrapped host while it is running.
+//
+// Service and ServiceV2 do not service the wrapped host themselves, they return the
+// events collected by Run.
+type SafeHost interface {
+	Host
+
+	// Run services the wrapped host until the context is cancelled, the host is
+	// destroyed or servicing fails. Queued operations are executed in between.
+	Run(ctx context.Context) error
+
+	// Do runs fn with exclusive access to the wrapped host and waits for it to
+	// return. Neither the host nor peers obtained from it may be kept beyond fn.
+	Do(fn func(host Host))
+}
+
+type safeCommand struct {
+	fn   func()
+	done chan struct{}
+}
+
+type safeHost struct {
+	host    Host
+	timeout uint32
+
+	// exec is held by whoever is currently using the wrapped host.
+	exec sync.Mutex
+
+	lock      sync.Mutex
+	running   bool
+	destroyed bool
+	queue     []safeCommand
+	wake      chan struct{}
+
+	events chan Event
+}
+
+// NewSafeHost wraps host so it can be used from multiple goroutines. The timeout, in
+// milliseconds, is passed to the wrapped Host.Service and bounds how long queued
+// operations may have to wait.
+func NewSafeHost(host Host, timeout uint32) SafeHost {
+	threadCheckExempt(host)
+	return &safeHost{
+		host:    host,
+		timeout: timeout,
+		wake:    make(chan struct{}, 1),
+		events:  make(chan Event, 256),
+	}
+}
+
+func (host *safeHost) do(fn func()) {
+	host.lock.Lock()
+	if !host.running {
+		host.lock.Unlock()
+
+		host.exec.Lock()
+		defer host.exec.Unlock()
+		fn()
+		return
+	}
+
+	cmd := safeCommand{fn: fn, done: make(chan struct{})}
+	host.queue = append(host.queue, cmd)
+	host.lock.Unlock()
+
+	select {
+	case host.wake <- struct{}{}:
+	default:
+	}
+	<-cmd.done
+}
+
+func (host *safeHost) runQueue() {
+	host.lock.Lock()
+	queue := host.queue
+	host.queue = nil
+	host.lock.Unlock()
+
+	for _, cmd := range queue {
+		cmd.fn()
+		close(cmd.done)
+	}
+}
+
+func (host *safeHost) Run(ctx context.Context) error {
+	host.lock.Lock()
+	if host.running {
+		host.lock.Unlock()
+		return errors.New("host is already running")
+	}
+	host.running = true
+	host.lock.Unlock()
+
+	host.exec.Lock()
+	defer host.exec.Unlock()
+
+	defer func() {
+		// Stop queueing and run whatever made it in before that.
+		host.lock.Lock()
+		host.running = false
+		host.lock.Unlock()
+		host.runQueue()
+	}()
+
+	for ctx.Err() == nil {
+		host.runQueue()
+
+		host.lock.Lock()
+		destroyed := host.destroyed
+		host.lock.Unlock()
+		if destroyed {
+			return nil
+		}
+
+		event := NewEvent()
+		ret := host.host.ServiceV2(event, host.timeout)
+		if ret < 0 {
+			return errors.New("servicing host failed")
+		}
+		if ret == 0 {
+			continue
+		}
+
+		wrapped := &safeEvent{Event: event, host: host}
+		for delivered := false; !delivered; {
+			select {
+			case host.events <- wrapped:
+				delivered = true
+			case <-host.wake:
+				// Whoever is supposed to read the events might be waiting on us.
+				host.runQueue()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (host *safeHost) Do(fn func(host Host)) {
+	host.do(func() {
+		fn(host.host)
+	})
+}
+
+func (host *safeHost) Destroy() (err error) {
+	host.do(func() {
+		host.lock.Lock()
+		defer host.lock.Unlock()
+
+		err = host.host.Destroy()
+		host.destroyed = true
+	})
+	return
+}
+
+func (host *safeHost) Service(timeout uint32) Event {
+	event := NewEvent()
+	host.ServiceV2(event, timeout)
+	return event
+}
+
+func (host *safeHost) ServiceBatch(events []Event, timeout uint32) int {
+	return serviceEach(host.ServiceV2, events, timeout)
+}
+
+func (host *safeHost) ServiceV2(event *enetEvent, timeout uint32) int {
+	*event = enetEvent{}
+
+	var received Event
+	if timeout == 0 {
+		select {
+		case received = <-host.events:
+		default:
+		}
+	} else {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+
+		select {
+		case received = <-host.events:
+		case <-timer.C:
+		}
+	}
+
+	if received == nil {
+		return 0
+	}
+	event.goEvent = received
+	event.timestamp = received.GetTimestamp()
+	return 1
+}
+
+func (host *safeHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	var peer Peer
+	var err error
+	host.do(func() {
+		peer, err = host.host.Connect(addr, channelCount, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return safePeer{Peer: peer, host: host}, nil
+}
+
+func (host *safeHost) BroadcastBytes(data []byte, channel uint8, flags PacketFlags) error {
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	return host.BroadcastPacket(packet, channel)
+}
+
+func (host *safeHost) BroadcastPacket(packet Packet, channel uint8) (err error) {
+	host.do(func() {
+		err = host.host.BroadcastPacket(unwrapSafePacket(packet), channel)
+	})
+	return
+}
+
+func (host *safeHost) BroadcastString(str string, channel uint8, flags PacketFlags) error {
+	return host.BroadcastBytes([]byte(str), channel, flags)
+}
+
+func (host *safeHost) GetBytesSent() (ret uint32) {
+	host.do(func() { ret = host.host.GetBytesSent() })
+	return
+}
+
+func (host *safeHost) GetBytesReceived() (ret uint32) {
+	host.do(func() { ret = host.host.GetBytesReceived() })
+	return
+}
+
+func (host *safeHost) GetPacketsSent() (ret uint32) {
+	host.do(func() { ret = host.host.GetPacketsSent() })
+	return
+}
+
+func (host *safeHost) GetPacketsReceived() (ret uint32) {
+	host.do(func() { ret = host.host.GetPacketsReceived() })
+	return
+}
+
+func (host *safeHost) ResetBytesSent() {
+	host.do(host.host.ResetBytesSent)
+}
+
+func (host *safeHost) ResetBytesReceived() {
+	host.do(host.host.ResetBytesReceived)
+}
+
+func (host *safeHost) ResetPacketsSent() {
+	host.do(host.host.ResetPacketsSent)
+}
+
+func (host *safeHost) ResetPacketsReceived() {
+	host.do(host.host.ResetPacketsReceived)
+}
+
+func (host *safeHost) GetStats() HostStats {
+	// Already safe to call from anywhere, no need to queue it.
+	return host.host.GetStats()
+}
+
+func (host *safeHost) ApplyConfig(config HostConfig) (err error) {
+	host.do(func() { err = host.host.ApplyConfig(config) })
+	return
+}
+
+type safeEvent struct {
+	Event
+	host *safeHost
+}
+
+func (event *safeEvent) GetPeer() Peer {
+	return safePeer{Peer: event.Event.GetPeer(), host: event.host}
+}
+
+func (event *safeEvent) GetPacket() Packet {
+	return safePacket{Packet: event.Event.GetPacket(), host: event.host}
+}
+
+type safePacket struct {
+	Packet
+	host *safeHost
+}
+
+func (packet safePacket) Destroy() (err error) {
+	packet.host.do(func() { err = packet.Packet.Destroy() })
+	return
+}
+
+func unwrapSafePacket(packet Packet) Packet {
+	if safe, ok := packet.(safePacket); ok {
+		return safe.Packet
+	}
+	return packet
+}
+
+type safePeer struct {
+	Peer
+	host *safeHost
+}
+
+func (peer safePeer) GetAddress() (ret Address) {
+	peer.host.do(func() { ret = peer.Peer.GetAddress() })
+	return
+}
+
+func (peer safePeer) GetID() (ret uint32) {
+	peer.host.do(func() { ret = peer.Peer.GetID() })
+	return
+}
+
+func (peer safePeer) Disconnect(data uint32) {
+	peer.host.do(func() { peer.Peer.Disconnect(data) })
+}
+
+func (peer safePeer) DisconnectNow(data uint32) {
+	peer.host.do(func() { peer.Peer.DisconnectNow(data) })
+}
+
+func (peer safePeer) DisconnectLater(data uint32) {
+	peer.host.do(func() { peer.Peer.DisconnectLater(data) })
+}
+
+func (peer safePeer) SetTimeout(limit uint32, min uint32, max uint32) {
+	peer.host.do(func() { peer.Peer.SetTimeout(limit, min, max) })
+}
+
+func (peer safePeer) SendBytes(data []byte, channel uint8, flags PacketFlags) error {
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	return peer.SendPacket(packet, channel)
+}
+
+func (peer safePeer) SendString(str string, channel uint8, flags PacketFlags) error {
+	return peer.SendBytes([]byte(str), channel, flags)
+}
+
+func (peer safePeer) SendPacket(packet Packet, channel uint8) (err error) {
+	peer.host.do(func() {
+		err = peer.Peer.SendPacket(unwrapSafePacket(packet), channel)
+	})
+	return
+}
+
+func (peer safePeer) SendAsync(data []byte, channel uint8, flags PacketFlags) error {
+	// Already safe to call from anywhere, no need to queue it.
+	return peer.Peer.SendAsync(data, channel, flags)
+}
+
+func (peer safePeer) SetData(data []byte) {
+	peer.host.do(func() { peer.Peer.SetData(data) })
+}
+
+func (peer safePeer) GetData() (ret []byte) {
+	// The data may be replaced once back on the goroutine running the host.
+	peer.host.do(func() { ret = bytes.Clone(peer.Peer.GetData()) })
+	return
+}
+
+func (peer safePeer) PingInterval(interval uint32) {
+	peer.host.do(func() { peer.Peer.PingInterval(interval) })
+}
+
+func (peer safePeer) GetBytesSent() (ret uint64) {
+	peer.host.do(func() { ret = peer.Peer.GetBytesSent() })
+	return
+}
+
+func (peer safePeer) GetBytesReceived() (ret uint64) {
+	peer.host.do(func() { ret = peer.Peer.GetBytesReceived() })
+	return
+}
+
+func (peer safePeer) GetPacketsSent() (ret uint64) {
+	peer.host.do(func() { ret = peer.Peer.GetPacketsSent() })
+	return
+}
+
+func (peer safePeer) GetPacketsLost() (ret uint64) {
+	peer.host.do(func() { ret = peer.Peer.GetPacketsLost() })
+	return
+}
+
+func (peer safePeer) EstimatedBandwidth() (ret uint32) {
+	peer.host.do(func() { ret = peer.Peer.EstimatedBandwidth() })
+	return
+}
+
+func (peer safePeer) SimulateConditions(latency, jitter time.Duration, loss float64) (err error) {
+	peer.host.do(func() { err = peer.Peer.SimulateConditions(latency, jitter, loss) })
+	return
+}
@@ -0,0 +1,198 @@
+// Package scheduler sends messages by priority within a bandwidth budget. Messages are
+// enqueued in categories, and every tick the scheduler sends every peer as many as fit
+// in its budget, the categories of highest priority first, each capped to a budget of
+// its own, so a flood of chat never delays movement, nor movement starves chat:
+//
+//	s, err := scheduler.New(scheduler.Config{
+//		PeerBudget: 8 << 10,
+//		Categories: []scheduler.Category{
+//			movement: {Channel: 0, Priority: 2, MaxQueued: 1},
+//			events:   {Channel: 1, Flags: enet.PacketFlagReliable, Priority: 1},
+//			chat:     {Channel: 2, Flags: enet.PacketFlagReliable, Budget: 512},
+//		},
+//	})
+//
+//	// As messages come up:
+//	s.Enqueue(peer, chat, message)
+//
+//	// On the goroutine servicing the host, every tick:
+//	s.Tick()
+package scheduler
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// DefaultPeerBudget is the number of bytes sent to every peer per tick by default
+const DefaultPeerBudget = 4 << 10
+
+// ErrCategory is returned when enqueueing in a category that does not exist
+var ErrCategory = errors.New("unknown scheduler category")
+
+// Category is a category of messages
+type Category struct {
+	// Channel is the channel the messages are sent on
+	Channel uint8
+
+	// Flags are the flags the messages are sent with
+	Flags enet.PacketFlags
+
+	// Priority orders categories, the messages of the highest one being sent first.
+	// Messages of categories of the same priority are sent in the order of the
+	// categories.
+	Priority int
+
+	// Budget is the number of bytes of the messages sent to every peer per tick at
+	// most, 0 for the category to only be bound by the budget of the peer
+	Budget int
+
+	// MaxQueued is the number of messages queued per peer, beyond which the oldest are
+	// dropped. 0 queues messages without bound; 1 suits state updates, each replacing
+	// the one before.
+	MaxQueued int
+}
+
+// Config configures a Scheduler
+type Config struct {
+	// PeerBudget is the number of bytes sent to every peer per tick. Defaults to
+	// DefaultPeerBudget.
+	PeerBudget int
+
+	// Categories are the categories of messages, identified by their index
+	Categories []Category
+}
+
+// peerQueues holds the messages queued for a peer, by category.
+type peerQueues struct {
+	queues  [][][]byte
+	dropped uint64
+}
+
+// Scheduler schedules messages, see the package documentation. It is not safe for
+// concurrent use, and must be used by the goroutine servicing the host of its peers.
+type Scheduler struct {
+	config Config
+
+	// order holds the categories by descending priority.
+	order []int
+	peers map[enet.Peer]*peerQueues
+}
+
+// New creates a scheduler
+func New(config Config) (*Scheduler, error) {
+	if config.PeerBudget <= 0 {
+		config.PeerBudget = DefaultPeerBudget
+	}
+	if len(config.Categories) == 0 {
+		return nil, errors.New("no categories")
+	}
+	order := make([]int, len(config.Categories))
+	for i, category := range config.Categories {
+		if category.Budget < 0 || category.MaxQueued < 0 {
+			return nil, errors.New("category budget and queue size must not be negative")
+		}
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(config.Categories[b].Priority, config.Categories[a].Priority)
+	})
+	return &Scheduler{config: config, order: order, peers: make(map[enet.Peer]*peerQueues)}, nil
+}
+
+// Enqueue queues data for peer in category, to be sent on a coming tick
+func (s *Scheduler) Enqueue(peer enet.Peer, category int, data []byte) error {
+	if category < 0 || category >= len(s.config.Categories) {
+		return ErrCategory
+	}
+	p, ok := s.peers[peer]
+	if !ok {
+		p = &peerQueues{queues: make([][][]byte, len(s.config.Categories))}
+		s.peers[peer] = p
+	}
+	queue := append(p.queues[category], append([]byte(nil), data...))
+	if limit := s.config.Categories[category].MaxQueued; limit > 0 && len(queue) > limit {
+		p.dropped += uint64(len(queue) - limit)
+		queue = slices.Delete(queue, 0, len(queue)-limit)
+	}
+	p.queues[category] = queue
+	return nil
+}
+
+// Tick sends every peer the messages that fit in its budget, highest priority first.
+// Messages of a category are sent in order, and those that don't fit wait for the next
+// tick, but for a message larger than the budget of the peer, which is sent alone.
+func (s *Scheduler) Tick() error {
+	var errs []error
+	for peer, p := range s.peers {
+		budget := s.config.PeerBudget
+		for _, category := range s.order {
+			c := s.config.Categories[category]
+			categoryBudget := c.Budget
+			if categoryBudget == 0 {
+				categoryBudget = budget
+			}
+			queue := p.queues[category]
+			sent := 0
+			for sent < len(queue) {
+				size := len(queue[sent])
+				alone := budget == s.config.PeerBudget && size > budget
+				if !alone && (size > budget || size > categoryBudget) {
+					break
+				}
+				if err := peer.SendBytes(queue[sent], c.Channel, c.Flags); err != nil {
+					errs = append(errs, err)
+				}
+				budget -= size
+				categoryBudget -= size
+				sent++
+			}
+			clear(queue[:sent])
+			p.queues[category] = queue[sent:]
+			if budget <= 0 {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Queued returns the number of messages queued for peer
+func (s *Scheduler) Queued(peer enet.Peer) int {
+	p, ok := s.peers[peer]
+	if !ok {
+		return 0
+	}
+	queued := 0
+	for _, queue := range p.queues {
+		queued += len(queue)
+	}
+	return queued
+}
+
+// Dropped returns the number of messages for peer dropped as their category had too
+// many queued
+func (s *Scheduler) Dropped(peer enet.Peer) uint64 {
+	if p, ok := s.peers[peer]; ok {
+		return p.dropped
+	}
+	return 0
+}
+
+// RemovePeer drops the messages queued for peer. Handle does it for the disconnect
+// events it sees.
+func (s *Scheduler) RemovePeer(peer enet.Peer) {
+	delete(s.peers, peer)
+}
+
+// Handle forgets peers once they disconnect. It can be called for every event
+// serviced.
+func (s *Scheduler) Handle(event enet.Event) {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		s.RemovePeer(event.GetPeer())
+	}
+}
@@ -0,0 +1,433 @@
+// Package session keeps the sessions of clients alive across lost connections. A
+// Client reconnects by itself whenever its connection drops, resumes its session with
+// the token the server issued it, and sends the server again the reliable messages it
+// had not acknowledged, so players riding through a network change lose nothing:
+//
+//	client, err := session.NewClient(session.ClientConfig{
+//		Address:       enet.NewAddress("play.example.com", 7777),
+//		ChannelCount:  3,
+//		Credentials:   authToken,
+//		OnStateChange: func(state session.State) { log.Println("session", state) },
+//	})
+//	for {
+//		event := client.Service(10)
+//		...
+//	}
+//
+// On the server, a Server issues the resume tokens, single use as each resume issues a
+// new one, and re-associates resuming clients with their session, its data and the room
+// they were in:
+//
+//	server := session.NewServer(session.ServerConfig{Rooms: manager, GraceWindow: time.Minute})
+//
+//	// On the goroutine servicing the host, every tick:
+//	server.Process()
+//
+//	// And for every event:
+//	if data, ok := server.Handle(event); ok {
+//		handleMessage(server.SessionOf(event.GetPeer()), data)
+//	}
+//
+// Channel 0 is reserved for the session protocol by default. Reliable messages sent by
+// the client are numbered with a 4 bytes sequence number, which the server strips and
+// acknowledges.
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultMinBackoff and DefaultMaxBackoff bound the time waited before reconnecting
+	// by default
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+
+	// DefaultMaxReplay is the number of bytes of reliable messages a client keeps
+	// until the server acknowledges them by default
+	DefaultMaxReplay = 1 << 20
+)
+
+var (
+	// ErrNotConnected is returned for unreliable messages sent while reconnecting, as
+	// they would be stale by the time they could be sent
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrReplayFull is returned for reliable messages sent while the client keeps as
+	// many unacknowledged messages as it may
+	ErrReplayFull = errors.New("too many unacknowledged messages")
+
+	// ErrClosed is returned once the client has been closed or gave up
+	ErrClosed = errors.New("session closed")
+
+	// ErrReservedChannel is returned for messages sent on the control channel
+	ErrReservedChannel = errors.New("channel is reserved for the session protocol")
+)
+
+// State is the state of the connection of a client
+type State int
+
+const (
+	// StateConnecting is the state of a client opening its first session
+	StateConnecting State = iota
+
+	// StateConnected is the state of a client whose session is open
+	StateConnected
+
+	// StateReconnecting is the state of a client whose connection dropped, until it
+	// resumed its session or opened a new one
+	StateReconnecting
+
+	// StateClosed is the state of a client that has been closed, gave up reconnecting
+	// or was rejected by the server
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// ClientConfig configures a Client
+type ClientConfig struct {
+	// Address is the address of the server
+	Address enet.Address
+
+	// ChannelCount is the number of channels of the connection, including the control
+	// channel
+	ChannelCount int
+
+	// Channel is the control channel, reserved for the session protocol
+	Channel uint8
+
+	// Data is passed to the server with every connection attempt
+	Data uint32
+
+	// Credentials authenticate the client when it opens a new session
+	Credentials []byte
+
+	// MinBackoff and MaxBackoff bound the time waited before reconnecting, doubling from
+	// one to the other with every failed attempt. Default to DefaultMinBackoff and
+	// DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the number of attempts to reconnect in a row before the client
+	// gives up, unlimited if 0
+	MaxAttempts int
+
+	// MaxReplay is the number of bytes of reliable messages kept until the server
+	// acknowledges them. Defaults to DefaultMaxReplay. At most 4096 messages are kept
+	// whatever their size, as the server drops those further ahead.
+	MaxReplay int
+
+	// OnStateChange is called whenever the state of the client changes. It may be nil.
+	OnStateChange func(state State)
+
+	// OnSessionLost is called when the server could not resume the session of the
+	// client and opened a new one, with the number of reliable messages lost with the
+	// old session. It may be nil.
+	OnSessionLost func(lost int)
+}
+
+// pending is a reliable message sent but not acknowledged by the server yet.
+type pending struct {
+	seq     uint32
+	channel uint8
+	data    []byte
+}
+
+// Client is a connection to a server that survives connections dropping, see the
+// package documentation. It is not safe for concurrent use, and must be used by the
+// goroutine servicing it.
+type Client struct {
+	config ClientConfig
+
+	host  enet.Host
+	peer  enet.Peer
+	state State
+	err   error
+
+	token   []byte
+	resumed bool
+
+	seq        uint32
+	unacked    []pending
+	unackedLen int
+
+	attempts int
+	next     time.Time
+}
+
+// NewClient creates a client, which connects as it is first serviced
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.Address == nil {
+		return nil, errors.New("no server address")
+	}
+	if int(config.Channel) >= config.ChannelCount {
+		return nil, errors.New("control channel out of range")
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	config.MaxBackoff = max(config.MaxBackoff, config.MinBackoff)
+	if config.MaxReplay <= 0 {
+		config.MaxReplay = DefaultMaxReplay
+	}
+
+	host, err := enet.NewHost(nil, 1, uint64(config.ChannelCount), 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{config: config, host: host}, nil
+}
+
+// State returns the state of the client
+func (c *Client) State() State {
+	return c.state
+}
+
+// Err returns why the client closed, nil while it is not closed or if it was closed
+// with Close
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Resumed returns true if the current session of the client was resumed rather than
+// opened anew on the last connection
+func (c *Client) Resumed() bool {
+	return c.resumed
+}
+
+// Token returns the resume token of the session of the client, nil until the server
+// accepted it. The server issues a new token every time the session is resumed.
+func (c *Client) Token() []byte {
+	return c.token
+}
+
+// Peer returns the peer of the server, nil while the client is not connected
+func (c *Client) Peer() enet.Peer {
+	if c.state != StateConnected {
+		return nil
+	}
+	return c.peer
+}
+
+// Pending returns the number of reliable messages not acknowledged by the server yet
+func (c *Client) Pending() int {
+	return len(c.unacked)
+}
+
+// Service services the connection for up to timeout milliseconds, connecting and
+// reconnecting as needed, and returns the messages received from the server as events
+// of type EventReceive, whose packets must be destroyed. Other events are handled by
+// the client, which then returns an event of type EventNone.
+func (c *Client) Service(timeout uint32) enet.Event {
+	if c.state == StateClosed {
+		return enet.NewEvent()
+	}
+	if c.peer == nil {
+		wait := time.Until(c.next)
+		if wait > 0 {
+			// Still backing off, but the host may have a disconnect left to send.
+			c.host.Service(uint32(min(wait, time.Duration(timeout)*time.Millisecond) / time.Millisecond))
+			return enet.NewEvent()
+		}
+		if !c.connect() {
+			return enet.NewEvent()
+		}
+	}
+
+	event := c.host.Service(timeout)
+	switch event.GetType() {
+	case enet.EventConnect:
+		c.peer.SendBytes(encodeHello(c.token, c.config.Credentials), c.config.Channel, enet.PacketFlagReliable)
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		c.dropped()
+	case enet.EventReceive:
+		if event.GetChannelID() != c.config.Channel {
+			return event
+		}
+		packet := event.GetPacket()
+		c.control(packet.GetData())
+		packet.Destroy()
+	}
+	return enet.NewEvent()
+}
+
+// connect starts a connection attempt. Returns false if it could not.
+func (c *Client) connect() bool {
+	peer, err := c.host.Connect(c.config.Address, c.config.ChannelCount, c.config.Data)
+	if err != nil {
+		c.dropped()
+		return false
+	}
+	c.peer = peer
+	return true
+}
+
+// dropped schedules the next attempt to reconnect once the connection dropped, or an
+// attempt failed.
+func (c *Client) dropped() {
+	c.peer = nil
+	c.attempts++
+	if c.config.MaxAttempts > 0 && c.attempts > c.config.MaxAttempts {
+		c.close(errors.New("unable to reconnect"))
+		return
+	}
+
+	backoff := c.config.MinBackoff << min(c.attempts-1, 30)
+	if backoff <= 0 || backoff > c.config.MaxBackoff {
+		backoff = c.config.MaxBackoff
+	}
+	// Jitter keeps clients dropped together from reconnecting together.
+	c.next = time.Now().Add(backoff/2 + rand.N(backoff/2+1))
+	if c.state == StateConnected {
+		c.setState(StateReconnecting)
+	}
+}
+
+// control handles a message of the session protocol.
+func (c *Client) control(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	switch data[0] {
+	case kindWelcome:
+		resumed, acked, token, err := decodeWelcome(data)
+		if err != nil {
+			return
+		}
+		c.welcome(resumed, acked, token)
+	case kindReject:
+		c.peer.DisconnectNow(0)
+		c.close(fmt.Errorf("session rejected: %s", data[1:]))
+	case kindAck:
+		if len(data) >= 5 {
+			c.ack(binary.BigEndian.Uint32(data[1:]))
+		}
+	}
+}
+
+// welcome opens the session the server accepted, sending the messages it did not
+// receive yet.
+func (c *Client) welcome(resumed bool, acked uint32, token []byte) {
+	if !resumed && c.token != nil {
+		// The old session is gone with the messages the server had not acknowledged.
+		lost := len(c.unacked)
+		c.unacked = nil
+		c.unackedLen = 0
+		c.seq = 0
+		if c.config.OnSessionLost != nil {
+			c.config.OnSessionLost(lost)
+		}
+	}
+	c.token = append([]byte(nil), token...)
+	c.resumed = resumed
+	c.attempts = 0
+
+	c.ack(acked)
+	for _, message := range c.unacked {
+		c.peer.SendBytes(sequenced(message.seq, message.data), message.channel, enet.PacketFlagReliable)
+	}
+	c.setState(StateConnected)
+}
+
+// ack drops the messages the server acknowledged.
+func (c *Client) ack(seq uint32) {
+	n := 0
+	for n < len(c.unacked) && c.unacked[n].seq <= seq {
+		c.unackedLen -= len(c.unacked[n].data)
+		n++
+	}
+	c.unacked = c.unacked[n:]
+}
+
+// SendBytes sends data to the server on channel. Reliable messages are kept until the
+// server acknowledges them, and sent again as the client reconnects, so they may be
+// sent while reconnecting. Unreliable messages fail with ErrNotConnected then.
+func (c *Client) SendBytes(data []byte, channel uint8, flags enet.PacketFlags) error {
+	if c.state == StateClosed {
+		return ErrClosed
+	}
+	if channel == c.config.Channel {
+		return ErrReservedChannel
+	}
+	if flags&enet.PacketFlagReliable == 0 {
+		if c.state != StateConnected {
+			return ErrNotConnected
+		}
+		return c.peer.SendBytes(data, channel, flags)
+	}
+
+	if c.unackedLen+len(data) > c.config.MaxReplay || len(c.unacked) >= seqWindow {
+		return ErrReplayFull
+	}
+	c.seq++
+	c.unacked = append(c.unacked, pending{seq: c.seq, channel: channel, data: append([]byte(nil), data...)})
+	c.unackedLen += len(data)
+	if c.state != StateConnected {
+		return nil
+	}
+	return c.peer.SendBytes(sequenced(c.seq, data), channel, flags)
+}
+
+// Close disconnects from the server, ending the session, and destroys the host of the
+// client
+func (c *Client) Close() error {
+	if c.state == StateClosed && c.host == nil {
+		return ErrClosed
+	}
+	if c.peer != nil {
+		c.peer.DisconnectNow(0)
+	}
+	c.close(nil)
+	return nil
+}
+
+// close closes the client for err.
+func (c *Client) close(err error) {
+	c.peer = nil
+	c.err = err
+	if c.host != nil {
+		c.host.Destroy()
+		c.host = nil
+	}
+	c.setState(StateClosed)
+}
+
+func (c *Client) setState(state State) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	if c.config.OnStateChange != nil {
+		c.config.OnStateChange(state)
+	}
+}
+
+// sequenced prefixes data with its sequence number.
+func sequenced(seq uint32, data []byte) []byte {
+	ret := make([]byte, seqSize, seqSize+len(data))
+	binary.BigEndian.PutUint32(ret, seq)
+	return append(ret, data...)
+}
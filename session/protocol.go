@@ -0,0 +1,76 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Kinds of session control messages, exchanged reliably on the control channel.
+const (
+	// kindHello opens or resumes a session: the resume token, empty for a new
+	// session, as a 2 bytes length then the token, followed by the credentials.
+	kindHello byte = iota
+
+	// kindWelcome accepts a hello: whether the session was resumed, the sequence
+	// number of the last message received, then the resume token.
+	kindWelcome
+
+	// kindReject refuses a hello, followed by the reason.
+	kindReject
+
+	// kindAck acknowledges the messages up to a sequence number.
+	kindAck
+)
+
+// seqSize is the size of the sequence number leading the reliable messages of clients.
+const seqSize = 4
+
+// seqWindow is how far past the last message received in order the server accepts
+// sequence numbers, and so how many reliable messages clients keep unacknowledged.
+const seqWindow = 4096
+
+var errMalformed = errors.New("malformed session message")
+
+func encodeHello(token, credentials []byte) []byte {
+	data := make([]byte, 0, 3+len(token)+len(credentials))
+	data = append(data, kindHello)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(token)))
+	data = append(data, token...)
+	return append(data, credentials...)
+}
+
+func decodeHello(data []byte) (token, credentials []byte, err error) {
+	if len(data) < 3 || data[0] != kindHello {
+		return nil, nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(data[1:]))
+	if len(data) < 3+n {
+		return nil, nil, errMalformed
+	}
+	return data[3 : 3+n], data[3+n:], nil
+}
+
+func encodeWelcome(resumed bool, acked uint32, token []byte) []byte {
+	data := make([]byte, 0, 6+len(token))
+	data = append(data, kindWelcome, 0)
+	if resumed {
+		data[1] = 1
+	}
+	data = binary.BigEndian.AppendUint32(data, acked)
+	return append(data, token...)
+}
+
+func decodeWelcome(data []byte) (resumed bool, acked uint32, token []byte, err error) {
+	if len(data) < 6 || data[0] != kindWelcome {
+		return false, 0, nil, errMalformed
+	}
+	return data[1] == 1, binary.BigEndian.Uint32(data[2:]), data[6:], nil
+}
+
+func encodeReject(reason string) []byte {
+	return append([]byte{kindReject}, reason...)
+}
+
+func encodeAck(seq uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{kindAck}, seq)
+}
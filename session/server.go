@@ -0,0 +1,314 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/rooms"
+)
+
+const (
+	// DefaultGraceWindow is how long sessions outlive the connection of their client by
+	// default
+	DefaultGraceWindow = 30 * time.Second
+
+	// tokenSize is the size of resume tokens.
+	tokenSize = 16
+)
+
+// ServerConfig configures a Server
+type ServerConfig struct {
+	// Channel is the control channel, reserved for the session protocol
+	Channel uint8
+
+	// GraceWindow is how long a session outlives the connection of its client, during
+	// which the client may resume it. Defaults to DefaultGraceWindow.
+	GraceWindow time.Duration
+
+	// Authenticate verifies the credentials of clients opening a new session, returning
+	// the data of the session, or an error telling the client why it is rejected. It
+	// may be nil to accept every client.
+	Authenticate func(peer enet.Peer, credentials []byte) (any, error)
+
+	// Rooms, if not nil, is the manager of the rooms clients are in. Clients resuming
+	// their session join the room they were in again, if it still exists. Handle must
+	// then be called before Rooms.Handle.
+	Rooms *rooms.Manager
+
+	// OnOpen is called once a client opened a new session, OnResume once a client
+	// resumed its session, OnDrop once the connection of the client of a session
+	// dropped, and OnExpire once a session expired as its client did not resume it in
+	// time. They may be nil.
+	OnOpen   func(session *Session)
+	OnResume func(session *Session)
+	OnDrop   func(session *Session)
+	OnExpire func(session *Session)
+
+	// Clock tells the time sessions expire at. Defaults to the SystemClock.
+	Clock enet.Clock
+}
+
+// Session is the session of a client of a Server, which outlives the connections of
+// the client
+type Session struct {
+	token []byte
+	peer  enet.Peer
+
+	// Data is the data of the session, as returned by Authenticate
+	Data any
+
+	// room is the room the client was in as its connection dropped.
+	room string
+
+	// received is the sequence number of the last message received, all before it
+	// were received too, and ahead the messages received after it, as messages sent
+	// on different channels may arrive out of order.
+	received uint32
+	ahead    map[uint32]struct{}
+	acked    uint32
+
+	expires time.Time
+}
+
+// Token returns the resume token of the session, which changes every time the session
+// is resumed
+func (s *Session) Token() []byte {
+	return s.token
+}
+
+// Peer returns the peer of the client of the session, nil while it is not connected
+func (s *Session) Peer() enet.Peer {
+	return s.peer
+}
+
+// receive records the message with sequence number seq as received. Returns false if
+// it already was, or if it is too far ahead to be kept track of.
+func (s *Session) receive(seq uint32) bool {
+	if seq <= s.received || seq-s.received > seqWindow {
+		return false
+	}
+	if _, ok := s.ahead[seq]; ok {
+		return false
+	}
+	if seq != s.received+1 {
+		if s.ahead == nil {
+			s.ahead = make(map[uint32]struct{})
+		}
+		s.ahead[seq] = struct{}{}
+		return true
+	}
+	s.received = seq
+	for {
+		if _, ok := s.ahead[s.received+1]; !ok {
+			break
+		}
+		delete(s.ahead, s.received+1)
+		s.received++
+	}
+	return true
+}
+
+// Server keeps the sessions of the clients of a host, issuing resume tokens to clients
+// opening a session and re-associating those presenting one with their session, so
+// reconnecting clients are not treated as new ones. It is not safe for concurrent use,
+// and must be used by the goroutine servicing the host.
+type Server struct {
+	config ServerConfig
+
+	sessions map[string]*Session
+	peers    map[enet.Peer]*Session
+}
+
+// NewServer creates a server without sessions
+func NewServer(config ServerConfig) *Server {
+	if config.GraceWindow <= 0 {
+		config.GraceWindow = DefaultGraceWindow
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Server{
+		config:   config,
+		sessions: make(map[string]*Session),
+		peers:    make(map[enet.Peer]*Session),
+	}
+}
+
+// SessionOf returns the session of peer, nil if it has not opened or resumed one
+func (s *Server) SessionOf(peer enet.Peer) *Session {
+	return s.peers[peer]
+}
+
+// Sessions returns the sessions of the server, including those waiting for their
+// client to resume them
+func (s *Server) Sessions() []*Session {
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Handle handles the session protocol for event, which must be called for every event
+// serviced. For events carrying a message of the application from a client with a
+// session, it returns the message without its sequence number and true. Messages sent
+// again by resuming clients that had already been received are dropped. The packets of
+// receive events are owned by the caller.
+func (s *Server) Handle(event enet.Event) ([]byte, bool) {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case enet.EventConnect:
+		// Peers are reused, a peer disconnected without an event may be back for another
+		// client.
+		if session, ok := s.peers[peer]; ok {
+			s.drop(session)
+		}
+		return nil, false
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		if session, ok := s.peers[peer]; ok {
+			s.drop(session)
+		}
+		return nil, false
+	case enet.EventReceive:
+	default:
+		return nil, false
+	}
+
+	data := event.GetPacket().GetData()
+	if event.GetChannelID() == s.config.Channel {
+		if len(data) > 0 && data[0] == kindHello {
+			s.hello(peer, data)
+		}
+		return nil, false
+	}
+	session, ok := s.peers[peer]
+	if !ok {
+		return nil, false
+	}
+	if event.GetPacket().GetFlags()&enet.PacketFlagReliable == 0 {
+		return data, true
+	}
+	if len(data) < seqSize || !session.receive(binary.BigEndian.Uint32(data)) {
+		return nil, false
+	}
+	return data[seqSize:], true
+}
+
+// hello opens or resumes the session of peer.
+func (s *Server) hello(peer enet.Peer, data []byte) {
+	if _, ok := s.peers[peer]; ok {
+		return
+	}
+	token, credentials, err := decodeHello(data)
+	if err != nil {
+		return
+	}
+
+	if session, ok := s.sessions[string(token)]; ok && len(token) > 0 {
+		if session.peer != nil {
+			// The client noticed its connection dropped before the server did. Its old
+			// peer is told, and its disconnect event finds no session left.
+			old := session.peer
+			s.drop(session)
+			old.Disconnect(0)
+		}
+		// Tokens are single use, so a token seen once cannot take the session over
+		// after its client resumed it.
+		delete(s.sessions, string(session.token))
+		session.token = newToken()
+		s.sessions[string(session.token)] = session
+		session.peer = peer
+		session.expires = time.Time{}
+		s.peers[peer] = session
+		if s.config.Rooms != nil && session.room != "" {
+			s.config.Rooms.Join(peer, session.room)
+		}
+		session.room = ""
+		session.acked = session.received
+		peer.SendBytes(encodeWelcome(true, session.received, session.token), s.config.Channel, enet.PacketFlagReliable)
+		if s.config.OnResume != nil {
+			s.config.OnResume(session)
+		}
+		return
+	}
+
+	var sessionData any
+	if s.config.Authenticate != nil {
+		if sessionData, err = s.config.Authenticate(peer, credentials); err != nil {
+			peer.SendBytes(encodeReject(err.Error()), s.config.Channel, enet.PacketFlagReliable)
+			peer.DisconnectLater(0)
+			return
+		}
+	}
+	session := &Session{token: newToken(), peer: peer, Data: sessionData}
+	s.sessions[string(session.token)] = session
+	s.peers[peer] = session
+	peer.SendBytes(encodeWelcome(false, 0, session.token), s.config.Channel, enet.PacketFlagReliable)
+	if s.config.OnOpen != nil {
+		s.config.OnOpen(session)
+	}
+}
+
+// newToken returns a new random resume token.
+func newToken() []byte {
+	token := make([]byte, tokenSize)
+	rand.Read(token)
+	return token
+}
+
+// drop detaches a session from the connection of its client, which then has the grace
+// window to resume it.
+func (s *Server) drop(session *Session) {
+	peer := session.peer
+	delete(s.peers, peer)
+	session.peer = nil
+	session.expires = s.config.Clock.Now().Add(s.config.GraceWindow)
+	if s.config.Rooms != nil {
+		if room := s.config.Rooms.RoomOf(peer); room != nil {
+			session.room = room.ID()
+			s.config.Rooms.Leave(peer)
+		}
+	}
+	if s.config.OnDrop != nil {
+		s.config.OnDrop(session)
+	}
+}
+
+// Close ends a session, disconnecting its client if it is connected
+func (s *Server) Close(session *Session) error {
+	if s.sessions[string(session.token)] != session {
+		return errors.New("session not found")
+	}
+	delete(s.sessions, string(session.token))
+	if session.peer != nil {
+		delete(s.peers, session.peer)
+		session.peer.Disconnect(0)
+		session.peer = nil
+	}
+	return nil
+}
+
+// Process acknowledges the messages received from clients, so they can stop keeping
+// them, and expires the sessions not resumed within the grace window. It must be called
+// regularly, typically once per tick.
+func (s *Server) Process() {
+	now := s.config.Clock.Now()
+	for token, session := range s.sessions {
+		if session.peer == nil {
+			if now.After(session.expires) {
+				delete(s.sessions, token)
+				if s.config.OnExpire != nil {
+					s.config.OnExpire(session)
+				}
+			}
+			continue
+		}
+		if session.received != session.acked {
+			session.acked = session.received
+			session.peer.SendBytes(encodeAck(session.received), s.config.Channel, enet.PacketFlagReliable)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package snapshot helps clients render the state servers send them as snapshots. A
+// Buffer keeps the snapshots received, stamped with the time they arrived, and renders
+// a little in the past, between two snapshots, so motion stays smooth even though
+// snapshots arrive at a lower rate than frames and with jitter:
+//
+//	buffer := snapshot.NewBuffer(snapshot.BufferConfig{Channel: 1, Delay: 100 * time.Millisecond})
+//
+//	// For every event:
+//	if buffer.Handle(event) {
+//		return
+//	}
+//
+//	// Every frame:
+//	if from, to, alpha, ok := buffer.Sample(time.Now()); ok {
+//		render(interpolate(from.Data, to.Data, alpha))
+//	}
+//
+// Servers cut the bandwidth of snapshots with a DeltaEncoder, which sends every peer the
+// bytes that changed since the last snapshot it acknowledged, and clients decode them
+// with a DeltaDecoder before buffering them.
+package snapshot
+
+import (
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+const (
+	// DefaultDelay is how far in the past buffers render by default, about two
+	// snapshots at 20 per second, so one can be lost without motion stopping
+	DefaultDelay = 100 * time.Millisecond
+
+	// DefaultCapacity is the number of snapshots buffers keep by default
+	DefaultCapacity = 32
+)
+
+// Snapshot is a snapshot of the state of the server
+type Snapshot struct {
+	// Time is the time the snapshot was received
+	Time time.Time
+
+	Data []byte
+}
+
+// BufferConfig configures a Buffer
+type BufferConfig struct {
+	// Channel is the channel snapshots are received on, see Buffer.Handle
+	Channel uint8
+
+	// Delay is how far in the past snapshots are rendered. Defaults to DefaultDelay.
+	Delay time.Duration
+
+	// Capacity is the number of snapshots kept, beyond which the oldest are dropped.
+	// Defaults to DefaultCapacity.
+	Capacity int
+
+	// Clock stamps the snapshots received. Defaults to the SystemClock.
+	Clock enet.Clock
+}
+
+// Buffer buffers snapshots for interpolation, see the package documentation. It is
+// not safe for concurrent use.
+type Buffer struct {
+	config    BufferConfig
+	snapshots []Snapshot
+}
+
+// NewBuffer creates an empty buffer
+func NewBuffer(config BufferConfig) *Buffer {
+	if config.Delay <= 0 {
+		config.Delay = DefaultDelay
+	}
+	if config.Capacity <= 1 {
+		config.Capacity = DefaultCapacity
+	}
+	if config.Clock == nil {
+		config.Clock = enet.SystemClock
+	}
+	return &Buffer{config: config}
+}
+
+// Delay returns how far in the past snapshots are rendered
+func (b *Buffer) Delay() time.Duration {
+	return b.config.Delay
+}
+
+// SetDelay changes how far in the past snapshots are rendered, for example to adapt it
+// to the jitter of the connection
+func (b *Buffer) SetDelay(delay time.Duration) {
+	b.config.Delay = max(delay, 0)
+}
+
+// Len returns the number of snapshots buffered
+func (b *Buffer) Len() int {
+	return len(b.snapshots)
+}
+
+// Handle buffers the snapshots received on the snapshot channel, returning true if the
+// event carried one. The packet of the event is left to the caller.
+func (b *Buffer) Handle(event enet.Event) bool {
+	if event.GetType() != enet.EventReceive || event.GetChannelID() != b.config.Channel {
+		return false
+	}
+	b.Add(event.GetPacket().GetData())
+	return true
+}
+
+// Add buffers a snapshot received now
+func (b *Buffer) Add(data []byte) {
+	b.AddAt(b.config.Clock.Now(), data)
+}
+
+// AddAt buffers a snapshot with the time t, for example a time derived from the tick
+// of the server. Snapshots older than the newest one buffered are dropped, as they
+// arrived too late.
+func (b *Buffer) AddAt(t time.Time, data []byte) {
+	if n := len(b.snapshots); n > 0 && t.Before(b.snapshots[n-1].Time) {
+		return
+	}
+	if len(b.snapshots) == b.config.Capacity {
+		copy(b.snapshots, b.snapshots[1:])
+		b.snapshots = b.snapshots[:len(b.snapshots)-1]
+	}
+	b.snapshots = append(b.snapshots, Snapshot{Time: t, Data: append([]byte(nil), data...)})
+}
+
+// Sample returns the snapshots to render at t, those around t minus the delay, and how
+// far between them the render time is, from 0 at from to 1 at to. Until the render time
+// reaches the oldest snapshot, and once it is past the newest one, both snapshots are
+// the same and alpha is 0, the state holding still until the next snapshot arrives.
+// Snapshots no longer needed for render times after t are dropped. Returns false if
+// the buffer is empty.
+func (b *Buffer) Sample(t time.Time) (from, to Snapshot, alpha float64, ok bool) {
+	if len(b.snapshots) == 0 {
+		return Snapshot{}, Snapshot{}, 0, false
+	}
+	render := t.Add(-b.config.Delay)
+
+	// Index of the newest snapshot not after the render time.
+	i := 0
+	for i+1 < len(b.snapshots) && !b.snapshots[i+1].Time.After(render) {
+		i++
+	}
+	if i > 0 {
+		b.snapshots = b.snapshots[i:]
+	}
+
+	from = b.snapshots[0]
+	if len(b.snapshots) == 1 || render.Before(from.Time) {
+		return from, from, 0, true
+	}
+	to = b.snapshots[1]
+	if !to.Time.After(from.Time) {
+		return from, to, 1, true
+	}
+	alpha = float64(render.Sub(from.Time)) / float64(to.Time.Sub(from.Time))
+	return from, to, min(max(alpha, 0), 1), true
+}
+
+// Reset drops the snapshots buffered, for example as the client changes servers
+func (b *Buffer) Reset() {
+	b.snapshots = nil
+}
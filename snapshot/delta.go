@@ -0,0 +1,255 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// DefaultHistory is the number of snapshots delta encoders and decoders keep as
+// possible baselines by default
+const DefaultHistory = 32
+
+// deltaHeaderSize is the size of the sequence numbers of a snapshot and its baseline
+// leading delta encoded snapshots.
+const deltaHeaderSize = 8
+
+var (
+	// ErrMissingBaseline is returned for snapshots encoded against a baseline the
+	// decoder no longer has
+	ErrMissingBaseline = errors.New("baseline of snapshot is missing")
+
+	errMalformed = errors.New("malformed delta snapshot")
+)
+
+// DeltaConfig configures a DeltaEncoder or a DeltaDecoder
+type DeltaConfig struct {
+	// Channel is the channel snapshots are sent on, unreliably
+	Channel uint8
+
+	// AckChannel is the channel decoders acknowledge snapshots on, unreliably
+	AckChannel uint8
+
+	// History is the number of snapshots kept as possible baselines. Defaults to
+	// DefaultHistory.
+	History int
+}
+
+// deltaPeer is the state of the snapshots sent to a peer.
+type deltaPeer struct {
+	seq     uint32
+	acked   uint32
+	history map[uint32][]byte
+}
+
+// DeltaEncoder sends snapshots to peers as deltas against the last snapshot they
+// acknowledged, so state that barely changes costs next to nothing. Snapshots are sent
+// unreliably, and peers acknowledge those they get, so a lost snapshot only means the
+// next ones are encoded against an older baseline. It is not safe for concurrent use,
+// and must be used by the goroutine servicing the host of its peers.
+type DeltaEncoder struct {
+	config DeltaConfig
+	peers  map[enet.Peer]*deltaPeer
+}
+
+// NewDeltaEncoder creates a delta encoder
+func NewDeltaEncoder(config DeltaConfig) *DeltaEncoder {
+	if config.History <= 0 {
+		config.History = DefaultHistory
+	}
+	return &DeltaEncoder{config: config, peers: make(map[enet.Peer]*deltaPeer)}
+}
+
+// Send sends the snapshot state to peer, encoded against the last snapshot it
+// acknowledged, or whole if it acknowledged none yet
+func (e *DeltaEncoder) Send(peer enet.Peer, state []byte) error {
+	return peer.SendBytes(e.Encode(peer, state), e.config.Channel, 0)
+}
+
+// Encode encodes the snapshot state for peer as Send does, for applications sending it
+// themselves
+func (e *DeltaEncoder) Encode(peer enet.Peer, state []byte) []byte {
+	p, ok := e.peers[peer]
+	if !ok {
+		p = &deltaPeer{history: make(map[uint32][]byte)}
+		e.peers[peer] = p
+	}
+	p.seq++
+	p.history[p.seq] = append([]byte(nil), state...)
+	delete(p.history, p.seq-uint32(e.config.History))
+
+	baseline, ok := p.history[p.acked]
+	if !ok {
+		p.acked = 0
+	}
+	data := make([]byte, deltaHeaderSize, deltaHeaderSize+len(state)/4)
+	binary.BigEndian.PutUint32(data, p.seq)
+	binary.BigEndian.PutUint32(data[4:], p.acked)
+	return appendDelta(data, baseline, state)
+}
+
+// Ack records that peer received the snapshot seq, the baseline of the next ones
+func (e *DeltaEncoder) Ack(peer enet.Peer, seq uint32) {
+	p, ok := e.peers[peer]
+	if !ok || int32(seq-p.acked) <= 0 {
+		return
+	}
+	if _, ok := p.history[seq]; ok {
+		p.acked = seq
+	}
+}
+
+// RemovePeer forgets the snapshots sent to peer. Handle does it for the disconnect
+// events it sees.
+func (e *DeltaEncoder) RemovePeer(peer enet.Peer) {
+	delete(e.peers, peer)
+}
+
+// Handle consumes the acknowledgements received on the ack channel, returning true if
+// the event carried one, and forgets peers once they disconnect. It must be called for
+// every event serviced.
+func (e *DeltaEncoder) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventDisconnect, enet.EventDisconnectTimeout:
+		e.RemovePeer(event.GetPeer())
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != e.config.AckChannel {
+		return false
+	}
+	if data := event.GetPacket().GetData(); len(data) >= 4 {
+		e.Ack(event.GetPeer(), binary.BigEndian.Uint32(data))
+	}
+	return true
+}
+
+// DeltaDecoder decodes the snapshots of a DeltaEncoder and acknowledges them. It is not
+// safe for concurrent use.
+type DeltaDecoder struct {
+	config  DeltaConfig
+	latest  uint32
+	history map[uint32][]byte
+}
+
+// NewDeltaDecoder creates a delta decoder
+func NewDeltaDecoder(config DeltaConfig) *DeltaDecoder {
+	if config.History <= 0 {
+		config.History = DefaultHistory
+	}
+	return &DeltaDecoder{config: config, history: make(map[uint32][]byte)}
+}
+
+// Decode decodes a snapshot, returning it with its sequence number. Snapshots older
+// than the latest one decoded are decoded too, the caller deciding whether they are
+// still of use.
+func (d *DeltaDecoder) Decode(data []byte) ([]byte, uint32, error) {
+	if len(data) < deltaHeaderSize {
+		return nil, 0, errMalformed
+	}
+	seq := binary.BigEndian.Uint32(data)
+	var baseline []byte
+	if base := binary.BigEndian.Uint32(data[4:]); base != 0 {
+		var ok bool
+		if baseline, ok = d.history[base]; !ok {
+			return nil, 0, ErrMissingBaseline
+		}
+	}
+	state, err := applyDelta(baseline, data[deltaHeaderSize:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d.history[seq] = state
+	if int32(seq-d.latest) > 0 {
+		d.latest = seq
+	}
+	for old := range d.history {
+		if int32(d.latest-old) >= int32(d.config.History) {
+			delete(d.history, old)
+		}
+	}
+	return state, seq, nil
+}
+
+// Handle decodes the snapshots received on the snapshot channel and acknowledges them
+// to their sender, returning the snapshot and true if the event carried one. The
+// packet of the event is left to the caller.
+func (d *DeltaDecoder) Handle(event enet.Event) ([]byte, bool) {
+	if event.GetType() != enet.EventReceive || event.GetChannelID() != d.config.Channel {
+		return nil, false
+	}
+	state, seq, err := d.Decode(event.GetPacket().GetData())
+	if err != nil {
+		return nil, false
+	}
+	event.GetPeer().SendBytes(binary.BigEndian.AppendUint32(nil, seq), d.config.AckChannel, 0)
+	return state, true
+}
+
+// appendDelta appends the delta of state against baseline to data: the length of
+// state, then runs of bytes equal in both, which are skipped, each followed by the XOR
+// of the bytes that differ. Missing bytes of the baseline count as zeros.
+func appendDelta(data, baseline, state []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(state)))
+	at := 0
+	for at < len(state) {
+		same := at
+		for same < len(state) && same < len(baseline) && state[same] == baseline[same] {
+			same++
+		}
+		differ := same
+		for differ < len(state) && (differ >= len(baseline) || state[differ] != baseline[differ]) {
+			differ++
+		}
+		data = binary.AppendUvarint(data, uint64(same-at))
+		data = binary.AppendUvarint(data, uint64(differ-same))
+		for i := same; i < differ; i++ {
+			b := state[i]
+			if i < len(baseline) {
+				b ^= baseline[i]
+			}
+			data = append(data, b)
+		}
+		at = differ
+	}
+	return data
+}
+
+// applyDelta applies a delta of appendDelta to baseline.
+func applyDelta(baseline, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 || size > uint64(len(delta)+len(baseline)) {
+		return nil, errMalformed
+	}
+	delta = delta[n:]
+	state := make([]byte, size)
+	copy(state, baseline)
+
+	at := uint64(0)
+	for at < size {
+		same, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		delta = delta[n:]
+		differ, n := binary.Uvarint(delta)
+		if n <= 0 || differ > uint64(len(delta)-n) || same+differ > size-at {
+			return nil, errMalformed
+		}
+		delta = delta[n:]
+		at += same
+		for _, b := range delta[:differ] {
+			state[at] ^= b
+			at++
+		}
+		delta = delta[differ:]
+		if same == 0 && differ == 0 {
+			return nil, errMalformed
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,342 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// SOCKS5Config configures the proxy a host tunnels its traffic through
+type SOCKS5Config struct {
+	// Address of the proxy as host:port
+	Address string
+
+	// Username and Password authenticate with the proxy. Leave both empty if the
+	// proxy does not require authentication.
+	Username string
+	Password string
+
+	// Timeout bounds how long setting up a tunnel with the proxy may take. Defaults to
+	// 10 seconds.
+	Timeout time.Duration
+}
+
+type socksHost struct {
+	Host
+	config SOCKS5Config
+
+	lock    sync.Mutex
+	tunnels map[uint16]*socksTunnel
+}
+
+// NewSOCKS5Host wraps a client host so Connect reaches peers through a SOCKS5 proxy
+// using UDP ASSOCIATE. Every connection gets its own association, tunneled through a
+// local UDP socket, so the addresses of peers connected this way are local ones.
+// Associations are released when the peer disconnects or the host is destroyed.
+func NewSOCKS5Host(host Host, config SOCKS5Config) Host {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &socksHost{
+		Host:    host,
+		config:  config,
+		tunnels: make(map[uint16]*socksTunnel),
+	}
+}
+
+func (host *socksHost) Connect(addr Address, channelCount int, data uint32) (Peer, error) {
+	tunnel, err := openSOCKS5Tunnel(host.config, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port := tunnel.port()
+	peer, err := host.Host.Connect(NewAddress("127.0.0.1", port), channelCount, data)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	host.lock.Lock()
+	host.tunnels[port] = tunnel
+	host.lock.Unlock()
+	return peer, nil
+}
+
+func (host *socksHost) Service(timeout uint32) Event {
+	event := NewEvent()
+	host.ServiceV2(event, timeout)
+	return event
+}
+
+func (host *socksHost) ServiceV2(event *enetEvent, timeout uint32) int {
+	ret := host.Host.ServiceV2(event, timeout)
+	if ret > 0 {
+		switch event.GetType() {
+		case EventDisconnect, EventDisconnectTimeout:
+			host.closeTunnel(event.GetPeer().GetAddress().GetPort())
+		}
+	}
+	return ret
+}
+
+func (host *socksHost) ServiceBatch(events []Event, timeout uint32) int {
+	n := host.Host.ServiceBatch(events, timeout)
+	for _, event := range events[:max(n, 0)] {
+		switch event.GetType() {
+		case EventDisconnect, EventDisconnectTimeout:
+			host.closeTunnel(event.GetPeer().GetAddress().GetPort())
+		}
+	}
+	return n
+}
+
+func (host *socksHost) closeTunnel(port uint16) {
+	host.lock.Lock()
+	tunnel := host.tunnels[port]
+	delete(host.tunnels, port)
+	host.lock.Unlock()
+
+	if tunnel != nil {
+		tunnel.Close()
+	}
+}
+
+func (host *socksHost) Destroy() error {
+	host.lock.Lock()
+	tunnels := host.tunnels
+	host.tunnels = make(map[uint16]*socksTunnel)
+	host.lock.Unlock()
+
+	for _, tunnel := range tunnels {
+		tunnel.Close()
+	}
+	return host.Host.Destroy()
+}
+
+// socksTunnel relays the datagrams between a host and a single peer through a SOCKS5
+// UDP association. The host talks to the local socket, which adds the SOCKS5 header
+// towards the relay and strips it from the replies.
+type socksTunnel struct {
+	control net.Conn
+	local   *net.UDPConn
+	relay   *net.UDPAddr
+	header  []byte
+
+	closeOnce sync.Once
+}
+
+func openSOCKS5Tunnel(config SOCKS5Config, target Address) (*socksTunnel, error) {
+	header, err := socksAddress(target)
+	if err != nil {
+		return nil, err
+	}
+
+	control, err := net.DialTimeout("tcp", config.Address, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	control.SetDeadline(time.Now().Add(config.Timeout))
+
+	relay, err := socksAssociate(control, config)
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	if relay.IP.IsUnspecified() {
+		// The relay is on the proxy itself.
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP
+	}
+	control.SetDeadline(time.Time{})
+
+	local, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	tunnel := &socksTunnel{
+		control: control,
+		local:   local,
+		relay:   relay,
+		header:  append([]byte{0, 0, 0}, header...),
+	}
+	go tunnel.watch()
+	go tunnel.run()
+	return tunnel, nil
+}
+
+// socksAssociate negotiates a UDP association over the control connection and returns
+// the address of the relay.
+func socksAssociate(conn net.Conn, config SOCKS5Config) (*net.UDPAddr, error) {
+	auth := config.Username != "" || config.Password != ""
+	methods := []byte{5, 1, 0}
+	if auth {
+		methods = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != 5 {
+		return nil, errors.New("not a socks5 proxy")
+	}
+
+	switch reply[1] {
+	case 0:
+	case 2:
+		if !auth {
+			return nil, errors.New("proxy requires authentication")
+		}
+		if len(config.Username) > 255 || len(config.Password) > 255 {
+			return nil, errors.New("username or password too long")
+		}
+		req := []byte{1, byte(len(config.Username))}
+		req = append(req, config.Username...)
+		req = append(req, byte(len(config.Password)))
+		req = append(req, config.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+		if reply[1] != 0 {
+			return nil, errors.New("authentication failed")
+		}
+	default:
+		return nil, errors.New("no acceptable authentication method")
+	}
+
+	// The address we will send from isn't known yet, which the request allows for.
+	if _, err := conn.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	if head[1] != 0 {
+		return nil, fmt.Errorf("udp associate failed with code %d", head[1])
+	}
+
+	var ip net.IP
+	switch head[3] {
+	case 1:
+		ip = make(net.IP, net.IPv4len)
+	case 4:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, errors.New("unsupported relay address type")
+	}
+	if _, err := io.ReadFull(conn, ip); err != nil {
+		return nil, err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+// socksAddress encodes an address the way it is written in SOCKS5 requests.
+func socksAddress(addr Address) ([]byte, error) {
+	ip := net.ParseIP(addr.String())
+	if ip == nil {
+		return nil, errors.New("address is not an ip address")
+	}
+
+	var b []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{1}, ip4...)
+	} else {
+		b = append([]byte{4}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, addr.GetPort()), nil
+}
+
+func (tunnel *socksTunnel) port() uint16 {
+	return uint16(tunnel.local.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// watch closes the tunnel once the proxy closes the control connection, which ends
+// the association.
+func (tunnel *socksTunnel) watch() {
+	io.Copy(io.Discard, tunnel.control)
+	tunnel.Close()
+}
+
+func (tunnel *socksTunnel) run() {
+	var host *net.UDPAddr
+	buffer := make([]byte, 64*1024)
+	out := make([]byte, 0, len(tunnel.header)+len(buffer))
+
+	for {
+		n, from, err := tunnel.local.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		if from.IP.Equal(tunnel.relay.IP) && from.Port == tunnel.relay.Port {
+			data, ok := socksPayload(buffer[:n])
+			if ok && host != nil {
+				tunnel.local.WriteToUDP(data, host)
+			}
+			continue
+		}
+
+		if !from.IP.IsLoopback() {
+			continue
+		}
+		host = from
+		out = append(append(out[:0], tunnel.header...), buffer[:n]...)
+		tunnel.local.WriteToUDP(out, tunnel.relay)
+	}
+}
+
+// socksPayload strips the SOCKS5 header of a datagram received from the relay.
+// Fragmented datagrams are not supported and dropped.
+func socksPayload(datagram []byte) ([]byte, bool) {
+	if len(datagram) < 4 || datagram[2] != 0 {
+		return nil, false
+	}
+
+	n := 4
+	switch datagram[3] {
+	case 1:
+		n += net.IPv4len
+	case 4:
+		n += net.IPv6len
+	case 3:
+		if len(datagram) < 5 {
+			return nil, false
+		}
+		n += 1 + int(datagram[4])
+	default:
+		return nil, false
+	}
+	n += 2
+
+	if len(datagram) < n {
+		return nil, false
+	}
+	return datagram[n:], true
+}
+
+func (tunnel *socksTunnel) Close() error {
+	tunnel.closeOnce.Do(func() {
+		tunnel.control.Close()
+		tunnel.local.Close()
+	})
+	return nil
+}
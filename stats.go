@@ -0,0 +1,325 @@
+package enet
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// peerStatsInterval is how often tracked peer statistics are snapshot at most.
+const peerStatsInterval = 100 * time.Millisecond
+
+// HostStats is a snapshot of the statistics of a host
+type HostStats struct {
+	BytesSent       uint32
+	BytesReceived   uint32
+	PacketsSent     uint32
+	PacketsReceived uint32
+
+	// ConnectedPeers is the number of peers currently connected to the host
+	ConnectedPeers uint32
+
+	// QueuedPackets is the number of packets queued with Peer.SendAsync that the host
+	// has yet to take
+	QueuedPackets uint32
+
+	// PerSecond and PerMinute are the rates of the traffic and events of the host over
+	// the last second and minute, as of UpdatedAt
+	PerSecond Rates
+	PerMinute Rates
+
+	// Events counts the events of the host since it was created
+	Events EventCounts
+
+	// Channels counts the events of the host per channel, indexed by channel ID, if
+	// tracked with TrackChannelStats. Only receives and dispatch errors belong to a
+	// channel.
+	Channels []EventCounts
+
+	// UpdatedAt is the time the statistics were last copied from enet
+	UpdatedAt time.Time
+}
+
+// EventCounts are counts of the events of a host, for dashboards to show connection
+// churn and errors
+type EventCounts struct {
+	Connects    uint64
+	Disconnects uint64
+
+	// Timeouts counts the peers that timed out or failed to connect
+	Timeouts uint64
+
+	Receives uint64
+
+	// DispatchErrors counts the received packets Dispatcher.Dispatch failed to route,
+	// such as for an unknown opcode
+	DispatchErrors uint64
+}
+
+// eventCounters are the atomic counters behind EventCounts.
+type eventCounters struct {
+	connects       atomic.Uint64
+	disconnects    atomic.Uint64
+	timeouts       atomic.Uint64
+	receives       atomic.Uint64
+	dispatchErrors atomic.Uint64
+}
+
+func (counters *eventCounters) count(eventType EventType) {
+	switch eventType {
+	case EventConnect:
+		counters.connects.Add(1)
+	case EventDisconnect:
+		counters.disconnects.Add(1)
+	case EventDisconnectTimeout:
+		counters.timeouts.Add(1)
+	case EventReceive:
+		counters.receives.Add(1)
+	}
+}
+
+func (counters *eventCounters) snapshot() EventCounts {
+	return EventCounts{
+		Connects:       counters.connects.Load(),
+		Disconnects:    counters.disconnects.Load(),
+		Timeouts:       counters.timeouts.Load(),
+		Receives:       counters.receives.Load(),
+		DispatchErrors: counters.dispatchErrors.Load(),
+	}
+}
+
+// channelCounters are the event counters of the channels of a host, the channels used
+// so far being the first used.
+type channelCounters struct {
+	channels [256]eventCounters
+	used     atomic.Int32
+}
+
+// channel returns the counters of a channel, marking it used.
+func (counters *channelCounters) channel(channel uint8) *eventCounters {
+	for {
+		used := counters.used.Load()
+		if int32(channel) < used || counters.used.CompareAndSwap(used, int32(channel)+1) {
+			return &counters.channels[channel]
+		}
+	}
+}
+
+func (counters *channelCounters) snapshot() []EventCounts {
+	ret := make([]EventCounts, counters.used.Load())
+	for i := range ret {
+		ret[i] = counters.channels[i].snapshot()
+	}
+	return ret
+}
+
+// hostStats mirrors the counters of a host in atomics, so they can be read from any
+// goroutine while the host is being serviced.
+type hostStats struct {
+	bytesSent       atomic.Uint32
+	bytesReceived   atomic.Uint32
+	packetsSent     atomic.Uint32
+	packetsReceived atomic.Uint32
+	connectedPeers  atomic.Uint32
+	updatedAt       atomic.Int64
+
+	window rateWindow
+	rates  atomic.Pointer[[2]Rates]
+
+	events   eventCounters
+	channels atomic.Pointer[channelCounters]
+
+	trackPeers   atomic.Bool
+	peers        atomic.Pointer[[]PeerStats]
+	peersUpdated time.Time
+}
+
+// update stores the counters of the host. Must be called by the goroutine servicing
+// the host.
+func (stats *hostStats) update(bytesSent, bytesReceived, packetsSent, packetsReceived, connectedPeers uint32) {
+	stats.bytesSent.Store(bytesSent)
+	stats.bytesReceived.Store(bytesReceived)
+	stats.packetsSent.Store(packetsSent)
+	stats.packetsReceived.Store(packetsReceived)
+	stats.connectedPeers.Store(connectedPeers)
+
+	now := time.Now()
+	if stats.window.update(now, bytesSent, bytesReceived, packetsSent, packetsReceived) {
+		second, minute := stats.window.rates()
+		stats.rates.Store(&[2]Rates{second, minute})
+	}
+	stats.updatedAt.Store(now.UnixNano())
+}
+
+// countEvent counts an event returned by Service. Must be called by the goroutine
+// servicing the host.
+func (stats *hostStats) countEvent(event Event) {
+	eventType := event.GetType()
+	if eventType == EventNone {
+		return
+	}
+	stats.window.events++
+	stats.events.count(eventType)
+	if channels := stats.channels.Load(); channels != nil && eventType == EventReceive {
+		channels.channel(event.GetChannelID()).count(eventType)
+	}
+}
+
+// countDispatchError counts a received packet on channel that failed to be dispatched.
+// Safe to call from any goroutine.
+func (stats *hostStats) countDispatchError(channel uint8) {
+	stats.events.dispatchErrors.Add(1)
+	if channels := stats.channels.Load(); channels != nil {
+		channels.channel(channel).dispatchErrors.Add(1)
+	}
+}
+
+// eventStats returns the stats of the host of the peer of event, nil if it has none.
+func eventStats(event Event) *hostStats {
+	peer := event.GetPeer()
+	if safe, ok := peer.(safePeer); ok {
+		peer = safe.Peer
+	}
+	switch peer := peer.(type) {
+	case enetPeer:
+		if host := peer.host(); host != nil {
+			return &host.stats
+		}
+	case *loopbackPeer:
+		return &peer.host.stats
+	}
+	return nil
+}
+
+func (stats *hostStats) snapshot() HostStats {
+	ret := HostStats{
+		BytesSent:       stats.bytesSent.Load(),
+		BytesReceived:   stats.bytesReceived.Load(),
+		PacketsSent:     stats.packetsSent.Load(),
+		PacketsReceived: stats.packetsReceived.Load(),
+		ConnectedPeers:  stats.connectedPeers.Load(),
+		Events:          stats.events.snapshot(),
+	}
+	if channels := stats.channels.Load(); channels != nil {
+		ret.Channels = channels.snapshot()
+	}
+	if rates := stats.rates.Load(); rates != nil {
+		ret.PerSecond, ret.PerMinute = rates[0], rates[1]
+	}
+	if updatedAt := stats.updatedAt.Load(); updatedAt != 0 {
+		ret.UpdatedAt = time.Unix(0, updatedAt)
+	}
+	return ret
+}
+
+// PeerStats is a snapshot of the statistics of a connected peer
+type PeerStats struct {
+	ID      uint32
+	Address Address
+
+	BytesSent     uint64
+	BytesReceived uint64
+	PacketsSent   uint64
+	PacketsLost   uint64
+
+	// RoundTripTime is the mean round trip time to the peer
+	RoundTripTime time.Duration
+
+	// QueuedCommands is the number of commands waiting to be sent to the peer or to be
+	// acknowledged by it
+	QueuedCommands int
+}
+
+// TrackPeerStats makes host snapshot the statistics of its connected peers as it is
+// serviced, at most every 100 milliseconds, for GetPeerStats.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func TrackPeerStats(host Host) error {
+	var err error
+	track := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("peer stats are only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		h.stats.trackPeers.Store(true)
+		h.updatePeerStats()
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(track)
+	} else {
+		track(host)
+	}
+	return err
+}
+
+// TrackChannelStats makes host count its events per channel as well, for
+// HostStats.Channels. It is safe to call from any goroutine.
+func TrackChannelStats(host Host) error {
+	if safe, ok := host.(*safeHost); ok {
+		host = safe.host
+	}
+	var stats *hostStats
+	switch h := host.(type) {
+	case *enetHost:
+		stats = &h.stats
+	case *loopbackHost:
+		stats = &h.stats
+	default:
+		return errors.New("channel stats are only supported on enet and loopback hosts")
+	}
+	stats.channels.CompareAndSwap(nil, &channelCounters{})
+	return nil
+}
+
+// GetPeerStats returns the statistics of the connected peers of host as of their last
+// snapshot, see TrackPeerStats. Like Host.GetStats it is safe to call from any
+// goroutine.
+func GetPeerStats(host Host) []PeerStats {
+	if safe, ok := host.(*safeHost); ok {
+		host = safe.host
+	}
+	h, ok := host.(*enetHost)
+	if !ok {
+		return nil
+	}
+	peers := h.stats.peers.Load()
+	if peers == nil {
+		return nil
+	}
+	return append([]PeerStats(nil), *peers...)
+}
+
+// updatePeerStats snapshots the statistics of the connected peers of the host if they
+// are tracked and due. Must be called by the goroutine servicing the host.
+func (host *enetHost) updatePeerStats() {
+	if !host.stats.trackPeers.Load() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(host.stats.peersUpdated) < peerStatsInterval {
+		return
+	}
+	host.stats.peersUpdated = now
+
+	connected := host.connectedPeerList()
+	peers := make([]PeerStats, 0, len(connected))
+	for _, peer := range connected {
+		peers = append(peers, PeerStats{
+			ID:             peer.GetID(),
+			Address:        peer.GetAddress(),
+			BytesSent:      peer.GetBytesSent(),
+			BytesReceived:  peer.GetBytesReceived(),
+			PacketsSent:    peer.GetPacketsSent(),
+			PacketsLost:    peer.GetPacketsLost(),
+			RoundTripTime:  peer.roundTripTime(),
+			QueuedCommands: peer.queuedCommands(),
+		})
+	}
+	host.stats.peers.Store(&peers)
+}
@@ -0,0 +1,280 @@
+package enet
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// PeerTags implements the tag methods of Peer, for implementations of Peer to embed.
+// The zero value has no tags. It is safe for concurrent use.
+type PeerTags struct {
+	lock sync.Mutex
+	tags map[string]struct{}
+}
+
+func (t *PeerTags) Tag(tags ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.tags == nil {
+		t.tags = make(map[string]struct{}, len(tags))
+	}
+	for _, tag := range tags {
+		t.tags[tag] = struct{}{}
+	}
+}
+
+func (t *PeerTags) Untag(tags ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, tag := range tags {
+		delete(t.tags, tag)
+	}
+}
+
+func (t *PeerTags) Tags() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return sortedTags(t.tags)
+}
+
+// reset drops every tag.
+func (t *PeerTags) reset() {
+	t.lock.Lock()
+	t.tags = nil
+	t.lock.Unlock()
+}
+
+func sortedTags(set map[string]struct{}) []string {
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// tagTable holds the tags of the peers of a host.
+type tagTable struct {
+	lock  sync.Mutex
+	peers map[enetPeer]*PeerTags
+}
+
+// of returns the tags of peer, creating them if create is set.
+func (table *tagTable) of(peer enetPeer, create bool) *PeerTags {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	tags := table.peers[peer]
+	if tags == nil && create {
+		if table.peers == nil {
+			table.peers = make(map[enetPeer]*PeerTags)
+		}
+		tags = &PeerTags{}
+		table.peers[peer] = tags
+	}
+	return tags
+}
+
+// forget drops the tags peers had before they connected, as IDs are reused. Tags are
+// kept through disconnect events for the application to see.
+func (table *tagTable) forget(event *enetEvent) {
+	if event.GetType() != EventConnect {
+		return
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return
+	}
+	if tags := table.of(peer, false); tags != nil {
+		tags.reset()
+	}
+}
+
+func (peer enetPeer) Tag(tags ...string) {
+	if host := peer.host(); host != nil {
+		host.features.join(featureTags)
+		host.tags.of(peer, true).Tag(tags...)
+	}
+}
+
+func (peer enetPeer) Untag(tags ...string) {
+	if host := peer.host(); host != nil {
+		if t := host.tags.of(peer, false); t != nil {
+			t.Untag(tags...)
+		}
+	}
+}
+
+func (peer enetPeer) Tags() []string {
+	if host := peer.host(); host != nil {
+		if t := host.tags.of(peer, false); t != nil {
+			return t.Tags()
+		}
+	}
+	return []string{}
+}
+
+// HasTags returns true if peer has all of tags
+func HasTags(peer Peer, tags ...string) bool {
+	has := peer.Tags()
+	for _, tag := range tags {
+		if _, found := slices.BinarySearch(has, tag); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectTagged returns the peers that have all of tags
+func SelectTagged(peers []Peer, tags ...string) []Peer {
+	var selected []Peer
+	for _, peer := range peers {
+		if HasTags(peer, tags...) {
+			selected = append(selected, peer)
+		}
+	}
+	return selected
+}
+
+// TaggedPeers returns the connected peers of host that have all of tags, for example to
+// broadcast to a team with a Group.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func TaggedPeers(host Host, tags ...string) ([]Peer, error) {
+	var peers []Peer
+	var err error
+	list := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("peers can only be listed on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+		for _, peer := range h.connectedPeerList() {
+			if HasTags(peer, tags...) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(list)
+		for i, peer := range peers {
+			peers[i] = safePeer{Peer: peer, host: safe}
+		}
+	} else {
+		list(host)
+	}
+	return peers, err
+}
+
+// Group is a set of peers sent to at once, such as a team or the players of a region.
+// A broadcast is a single packet shared by all the peers of the group, rather than a
+// copy per peer.
+type Group interface {
+	// Add adds peers to the group. Adding a peer twice has no effect.
+	Add(peers ...Peer)
+
+	// Remove removes peers from the group
+	Remove(peers ...Peer)
+
+	// Contains returns true if peer is in the group
+	Contains(peer Peer) bool
+
+	// Peers returns the peers of the group
+	Peers() []Peer
+
+	// Len returns the number of peers in the group
+	Len() int
+
+	// Broadcast sends data to the peers of the group. Peers that can't be sent to, for
+	// example because they are disconnecting, are skipped. Must be called by the
+	// goroutine servicing the host of the peers.
+	Broadcast(data []byte, channel uint8, flags PacketFlags) error
+
+	// BroadcastPacket is like Broadcast, handing the packet over to the peers. The
+	// packet must not be used afterwards.
+	BroadcastPacket(packet Packet, channel uint8) error
+
+	// Handle removes peers from the group when they disconnect, since their IDs are
+	// reused. It can be called for every event serviced.
+	Handle(event Event)
+}
+
+type enetGroup struct {
+	lock  sync.RWMutex
+	peers map[Peer]struct{}
+}
+
+// NewGroup creates a group of peers. Peers may be added and removed from any goroutine.
+func NewGroup(peers ...Peer) Group {
+	group := &enetGroup{peers: make(map[Peer]struct{}, len(peers))}
+	group.Add(peers...)
+	return group
+}
+
+func (g *enetGroup) Add(peers ...Peer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, peer := range peers {
+		g.peers[peer] = struct{}{}
+	}
+}
+
+func (g *enetGroup) Remove(peers ...Peer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, peer := range peers {
+		delete(g.peers, peer)
+	}
+}
+
+func (g *enetGroup) Contains(peer Peer) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	_, ok := g.peers[peer]
+	return ok
+}
+
+func (g *enetGroup) Peers() []Peer {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	peers := make([]Peer, 0, len(g.peers))
+	for peer := range g.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (g *enetGroup) Len() int {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return len(g.peers)
+}
+
+func (g *enetGroup) Broadcast(data []byte, channel uint8, flags PacketFlags) error {
+	peers := g.Peers()
+	if len(peers) == 0 {
+		return nil
+	}
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	return multicast(peers, channel, packet)
+}
+
+func (g *enetGroup) BroadcastPacket(packet Packet, channel uint8) error {
+	return multicast(g.Peers(), channel, packet)
+}
+
+func (g *enetGroup) Handle(event Event) {
+	switch event.GetType() {
+	case EventDisconnect, EventDisconnectTimeout:
+		g.Remove(event.GetPeer())
+	}
+}
@@ -0,0 +1,120 @@
+package enet
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ThreadCheckMode controls the detection of hosts being used from multiple goroutines
+type ThreadCheckMode int32
+
+const (
+	// ThreadCheckOff disables the checks, this is the default
+	ThreadCheckOff ThreadCheckMode = iota
+
+	// ThreadCheckLog logs every host or peer method called from another goroutine than
+	// the one servicing the host, together with the offending stack trace
+	ThreadCheckLog
+
+	// ThreadCheckPanic panics when a host or peer method is called from another
+	// goroutine than the one servicing the host
+	ThreadCheckPanic
+)
+
+var threadCheckMode atomic.Int32
+
+// hostOwners maps the rawHost of a host to the *hostOwner tracking it.
+var hostOwners sync.Map
+
+type hostOwner struct {
+	goroutine atomic.Uint64
+
+	// safe is set for hosts wrapped by a SafeHost, which serializes access itself.
+	safe atomic.Bool
+}
+
+// SetThreadCheck enables or disables debug checks for hosts and peers being used from
+// other goroutines than the one that last called Host.Service. Enet is not thread safe
+// and such calls race with the C library, silently corrupting its state. Hosts wrapped
+// with NewSafeHost are exempt. The checks are expensive and meant for debugging only.
+func SetThreadCheck(mode ThreadCheckMode) {
+	threadCheckMode.Store(int32(mode))
+}
+
+func threadCheckEnabled() bool {
+	return ThreadCheckMode(threadCheckMode.Load()) != ThreadCheckOff
+}
+
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	// The stack starts with "goroutine 123 [running]:".
+	b := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+func hostOwnerOf(host rawHost) *hostOwner {
+	owner, _ := hostOwners.LoadOrStore(host, &hostOwner{})
+	return owner.(*hostOwner)
+}
+
+// threadCheckService records the calling goroutine as the one servicing the host.
+func threadCheckService(host rawHost) {
+	if !threadCheckEnabled() || host == nil {
+		return
+	}
+	hostOwnerOf(host).goroutine.Store(goroutineID())
+}
+
+// threadCheck reports if the host is being serviced by another goroutine than the
+// calling one.
+func threadCheck(host rawHost, method string) {
+	if !threadCheckEnabled() || host == nil {
+		return
+	}
+
+	value, ok := hostOwners.Load(host)
+	if !ok {
+		return
+	}
+	owner := value.(*hostOwner)
+	if owner.safe.Load() {
+		return
+	}
+
+	serviced := owner.goroutine.Load()
+	current := goroutineID()
+	if serviced == 0 || serviced == current {
+		return
+	}
+
+	msg := fmt.Sprintf("enet: %s called from goroutine %d while the host is serviced by goroutine %d", method, current, serviced)
+	if ThreadCheckMode(threadCheckMode.Load()) == ThreadCheckPanic {
+		panic(msg)
+	}
+
+	stack := make([]byte, 4096)
+	stack = stack[:runtime.Stack(stack, false)]
+	log.Printf("%s\n%s", msg, stack)
+}
+
+// threadCheckExempt disables the checks for a host that synchronizes access itself.
+func threadCheckExempt(host Host) {
+	if h, ok := host.(*enetHost); ok {
+		hostOwnerOf(h.raw()).safe.Store(true)
+	}
+}
+
+// threadCheckForget drops the tracking state of a destroyed host.
+func threadCheckForget(host rawHost) {
+	hostOwners.Delete(host)
+}
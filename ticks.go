@@ -0,0 +1,135 @@
+package enet
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// maxTickCatchUp is the number of ticks RunTicks runs back to back to catch up after
+// falling behind, beyond which it skips ticks instead.
+const maxTickCatchUp = 5
+
+// Tick is a tick of RunTicks
+type Tick struct {
+	// Number counts the ticks run, from 1
+	Number uint64
+
+	// Time is the time the tick was due, which advances by exactly one interval every
+	// tick, however late ticks run
+	Time time.Time
+
+	// Interval is the fixed time step between ticks
+	Interval time.Duration
+
+	// Events are the events serviced since the previous tick, oldest first. Their
+	// packets are destroyed once the tick returns.
+	Events []Event
+
+	// Skipped is the number of ticks skipped right before this one, as the loop fell
+	// too far behind to catch up
+	Skipped int
+}
+
+// RunTicks runs a fixed timestep loop on host at rate ticks per second until ctx is
+// cancelled or servicing fails. Between ticks the host is serviced, collecting events
+// as they arrive, and every tick onTick is called with the events collected. The
+// packets onTick sends are flushed to the network right after it returns rather than on
+// the next wait for events. Ticks are due at fixed times from the first one, so timing
+// errors don't add up: ticks running late are caught up back to back, and skipped if
+// the loop falls more than a few ticks behind. On a host running off a virtual clock,
+// see SetHostClock, ticks follow the clock as it is advanced.
+//
+// It must be called by the goroutine servicing the host, which nothing else may
+// service while it runs.
+func RunTicks(ctx context.Context, host Host, rate int, onTick func(tick Tick)) error {
+	if rate <= 0 {
+		return errors.New("tick rate must be positive")
+	}
+
+	clock := SystemClock
+	if h, ok := host.(*enetHost); ok && h.clock != nil {
+		clock = h.clock.clock
+	}
+	interval := time.Second / time.Duration(rate)
+
+	var events []Event
+	service := func(timeout uint32) (bool, error) {
+		event := NewEvent()
+		ret := host.ServiceV2(event, timeout)
+		if ret < 0 {
+			return false, errors.New("servicing host failed")
+		}
+		if ret > 0 {
+			events = append(events, event)
+		}
+		return ret > 0, nil
+	}
+
+	tick := Tick{Time: clock.Now(), Interval: interval}
+	for ctx.Err() == nil {
+		// Service the host until the tick is due.
+		for ctx.Err() == nil {
+			wait := tick.Time.Sub(clock.Now())
+			if wait <= 0 {
+				break
+			}
+			handled, err := service(uint32(wait / time.Millisecond))
+			if err != nil {
+				return err
+			}
+			if handled {
+				continue
+			}
+			if clock != SystemClock {
+				// Service does not wait on a virtual clock, so wait for it to move.
+				waitClock(ctx, clock, wait)
+			} else if wait < time.Millisecond {
+				time.Sleep(wait)
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Collect what arrived while the last tick ran.
+		for {
+			handled, err := service(0)
+			if err != nil {
+				return err
+			}
+			if !handled {
+				break
+			}
+		}
+
+		tick.Number++
+		tick.Events = events
+		onTick(tick)
+		for _, event := range events {
+			if event.GetType() == EventReceive {
+				event.GetPacket().Destroy()
+			}
+		}
+		events = nil
+
+		// Flush what the tick sent.
+		if _, err := service(0); err != nil {
+			return err
+		}
+
+		tick.Time = tick.Time.Add(interval)
+		tick.Skipped = 0
+		if behind := clock.Now().Sub(tick.Time); behind > maxTickCatchUp*interval {
+			tick.Skipped = int(behind / interval)
+			tick.Time = tick.Time.Add(time.Duration(tick.Skipped) * interval)
+		}
+	}
+
+	for _, event := range events {
+		if event.GetType() == EventReceive {
+			event.GetPacket().Destroy()
+		}
+	}
+	return nil
+}
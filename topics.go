@@ -0,0 +1,189 @@
+package enet
+
+import (
+	"sort"
+	"sync"
+)
+
+// Topics tracks which peers are subscribed to which string topics, and publishes
+// messages to the subscribers of a topic. A published message is a single packet
+// shared by all its subscribers, rather than a copy per peer, which is what chat
+// rooms, zones and interest groups want.
+type Topics interface {
+	// Subscribe subscribes peer to topic. Subscribing twice has no effect.
+	Subscribe(peer Peer, topic string)
+
+	// Unsubscribe unsubscribes peer from topic
+	Unsubscribe(peer Peer, topic string)
+
+	// UnsubscribeAll unsubscribes peer from all its topics. Peers must be unsubscribed
+	// once they disconnect, since their IDs are reused, Handle does it for the
+	// disconnect events it sees.
+	UnsubscribeAll(peer Peer)
+
+	// Subscribers returns the peers subscribed to topic
+	Subscribers(topic string) []Peer
+
+	// TopicsOf returns the topics peer is subscribed to, in order
+	TopicsOf(peer Peer) []string
+
+	// Publish sends data to the subscribers of topic. Subscribers that can't be sent
+	// to, for example because they are disconnecting, are skipped. Must be called by
+	// the goroutine servicing the host of the subscribers.
+	Publish(topic string, data []byte, channel uint8, flags PacketFlags) error
+
+	// PublishPacket is like Publish, handing the packet over to the subscribers. The
+	// packet must not be used afterwards.
+	PublishPacket(topic string, packet Packet, channel uint8) error
+
+	// Handle unsubscribes peers from all their topics when they disconnect. It can be
+	// called for every event serviced.
+	Handle(event Event)
+}
+
+type enetTopics struct {
+	lock   sync.RWMutex
+	topics map[string]map[Peer]struct{}
+	peers  map[Peer]map[string]struct{}
+}
+
+// NewTopics creates topics without subscribers. They may be subscribed to from any
+// goroutine.
+func NewTopics() Topics {
+	return &enetTopics{
+		topics: make(map[string]map[Peer]struct{}),
+		peers:  make(map[Peer]map[string]struct{}),
+	}
+}
+
+func (t *enetTopics) Subscribe(peer Peer, topic string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	subscribers, ok := t.topics[topic]
+	if !ok {
+		subscribers = make(map[Peer]struct{})
+		t.topics[topic] = subscribers
+	}
+	subscribers[peer] = struct{}{}
+
+	topics, ok := t.peers[peer]
+	if !ok {
+		topics = make(map[string]struct{})
+		t.peers[peer] = topics
+	}
+	topics[topic] = struct{}{}
+}
+
+func (t *enetTopics) Unsubscribe(peer Peer, topic string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.unsubscribe(peer, topic)
+}
+
+// unsubscribe drops the subscription, and the topic and the peer if it was their
+// last one. Must be called with the lock held.
+func (t *enetTopics) unsubscribe(peer Peer, topic string) {
+	if subscribers, ok := t.topics[topic]; ok {
+		delete(subscribers, peer)
+		if len(subscribers) == 0 {
+			delete(t.topics, topic)
+		}
+	}
+	if topics, ok := t.peers[peer]; ok {
+		delete(topics, topic)
+		if len(topics) == 0 {
+			delete(t.peers, peer)
+		}
+	}
+}
+
+func (t *enetTopics) UnsubscribeAll(peer Peer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for topic := range t.peers[peer] {
+		t.unsubscribe(peer, topic)
+	}
+}
+
+func (t *enetTopics) Subscribers(topic string) []Peer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	subscribers := make([]Peer, 0, len(t.topics[topic]))
+	for peer := range t.topics[topic] {
+		subscribers = append(subscribers, peer)
+	}
+	return subscribers
+}
+
+func (t *enetTopics) TopicsOf(peer Peer) []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	topics := make([]string, 0, len(t.peers[peer]))
+	for topic := range t.peers[peer] {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (t *enetTopics) Publish(topic string, data []byte, channel uint8, flags PacketFlags) error {
+	subscribers := t.Subscribers(topic)
+	if len(subscribers) == 0 {
+		return nil
+	}
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	return multicast(subscribers, channel, packet)
+}
+
+func (t *enetTopics) PublishPacket(topic string, packet Packet, channel uint8) error {
+	return multicast(t.Subscribers(topic), channel, packet)
+}
+
+func (t *enetTopics) Handle(event Event) {
+	switch event.GetType() {
+	case EventDisconnect, EventDisconnectTimeout:
+		t.UnsubscribeAll(event.GetPeer())
+	}
+}
+
+// multicast hands packet over to every peer, skipping those that refuse it. The peers
+// of the backend share the packet, which is freed once sent to all of them, while other
+// peers get a copy.
+func multicast(peers []Peer, channel uint8, packet Packet) error {
+	p, err := toEnetPacket(packet)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	for _, peer := range peers {
+		if _, ok := peer.(enetPeer); !ok {
+			data = p.GetData()
+			break
+		}
+	}
+	flags := p.GetFlags()
+
+	raw, err := p.handOver()
+	if err != nil {
+		return err
+	}
+	defer destroyUnreferenced(raw)
+
+	for _, peer := range peers {
+		if p, ok := peer.(enetPeer); ok {
+			p.sendRaw(channel, raw)
+		} else {
+			peer.SendBytes(data, channel, flags)
+		}
+	}
+	return nil
+}
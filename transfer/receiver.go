@@ -0,0 +1,228 @@
+package transfer
+
+import (
+	"crypto/sha256"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// ReceiverConfig configures a Receiver
+type ReceiverConfig struct {
+	// Channel is the channel transfers are exchanged on
+	Channel uint8
+
+	// MaxSize is the size of the largest content accepted. Defaults to DefaultMaxSize.
+	MaxSize int64
+
+	// Accept decides whether to accept content offered by peer, whose size is at most
+	// MaxSize. It may be nil to accept every offer.
+	Accept func(peer enet.Peer, offer Offer) bool
+
+	// OnProgress is called as content is received. It may be nil.
+	OnProgress func(in *Incoming)
+
+	// OnComplete is called once a transfer is over, with the content and a nil error if
+	// it was received whole and matched its checksum. It may be nil.
+	OnComplete func(in *Incoming, data []byte, err error)
+}
+
+// Incoming is content received from a peer by a Receiver
+type Incoming struct {
+	id      uint32
+	peer    enet.Peer
+	offer   Offer
+	data    []byte
+	resumed int64
+}
+
+// Peer returns the peer sending the content
+func (in *Incoming) Peer() enet.Peer {
+	return in.peer
+}
+
+// Name returns the name the content is sent with
+func (in *Incoming) Name() string {
+	return in.offer.Name
+}
+
+// Size returns the size of the content
+func (in *Incoming) Size() int64 {
+	return in.offer.Size
+}
+
+// Checksum returns the SHA-256 checksum of the content
+func (in *Incoming) Checksum() [sha256.Size]byte {
+	return in.offer.Checksum
+}
+
+// Received returns the number of bytes of the content received, including those
+// received before resuming
+func (in *Incoming) Received() int64 {
+	return int64(len(in.data))
+}
+
+// Resumed returns the offset the transfer resumed from, the number of bytes kept from a
+// transfer of the content cut short
+func (in *Incoming) Resumed() int64 {
+	return in.resumed
+}
+
+// Receiver receives content from peers, see the package documentation. It is not safe
+// for concurrent use, and must be used by the goroutine servicing the host of its
+// peers.
+type Receiver struct {
+	config   ReceiverConfig
+	incoming map[enet.Peer]map[uint32]*Incoming
+
+	// partial holds the content received of transfers cut short, by checksum.
+	partial map[[sha256.Size]byte][]byte
+}
+
+// NewReceiver creates a receiver
+func NewReceiver(config ReceiverConfig) *Receiver {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	return &Receiver{
+		config:   config,
+		incoming: make(map[enet.Peer]map[uint32]*Incoming),
+		partial:  make(map[[sha256.Size]byte][]byte),
+	}
+}
+
+// Incoming returns the transfers from peer that are not over
+func (r *Receiver) Incoming(peer enet.Peer) []*Incoming {
+	incoming := make([]*Incoming, 0, len(r.incoming[peer]))
+	for _, in := range r.incoming[peer] {
+		incoming = append(incoming, in)
+	}
+	return incoming
+}
+
+// Partial returns the number of bytes kept of the content with checksum from transfers
+// cut short, which a transfer of the same content resumes from
+func (r *Receiver) Partial(checksum [sha256.Size]byte) int64 {
+	return int64(len(r.partial[checksum]))
+}
+
+// Discard drops what was kept of the content with checksum from transfers cut short
+func (r *Receiver) Discard(checksum [sha256.Size]byte) {
+	delete(r.partial, checksum)
+}
+
+// Cancel aborts in, reporting ErrCancelled. What was received of it is dropped.
+func (r *Receiver) Cancel(in *Incoming) {
+	if r.incoming[in.peer][in.id] != in {
+		return
+	}
+	in.peer.SendBytes(appendHeader(kindReject, in.id), r.config.Channel, enet.PacketFlagReliable)
+	r.finish(in, nil, ErrCancelled)
+}
+
+// Handle consumes the messages received on the transfer channel, returning true if the
+// event carried one. Transfers from peers that disconnect fail with ErrDisconnected,
+// and what was received of them is kept to resume from. A connect event on a peer
+// counts as a disconnect of the connection it replaces, for those dropped without one.
+// The packet of the event is left to the caller.
+func (r *Receiver) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventConnect, enet.EventDisconnect, enet.EventDisconnectTimeout:
+		for _, in := range r.incoming[event.GetPeer()] {
+			if len(in.data) > 0 {
+				r.partial[in.offer.Checksum] = in.data
+			}
+			r.finish(in, nil, ErrDisconnected)
+		}
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != r.config.Channel {
+		return false
+	}
+
+	msg, err := decode(event.GetPacket().GetData())
+	if err != nil {
+		return true
+	}
+	peer := event.GetPeer()
+	if msg.kind == kindOffer {
+		r.offered(peer, msg.id, msg.offer)
+		return true
+	}
+	in, ok := r.incoming[peer][msg.id]
+	if !ok {
+		return true
+	}
+
+	switch msg.kind {
+	case kindChunk:
+		if msg.value != uint64(len(in.data)) || int64(len(in.data)+len(msg.data)) > in.offer.Size {
+			peer.SendBytes(appendHeader(kindReject, in.id), r.config.Channel, enet.PacketFlagReliable)
+			r.finish(in, nil, errMalformed)
+			return true
+		}
+		in.data = append(in.data, msg.data...)
+		peer.SendBytes(encodeValue(kindAck, in.id, uint64(len(in.data))), r.config.Channel, enet.PacketFlagReliable)
+		if r.config.OnProgress != nil {
+			r.config.OnProgress(in)
+		}
+		r.verify(in)
+	case kindCancel:
+		r.finish(in, nil, ErrRejected)
+	}
+	return true
+}
+
+// offered accepts or rejects an offer, resuming from the content kept of it if any.
+func (r *Receiver) offered(peer enet.Peer, id uint32, offer Offer) {
+	if _, ok := r.incoming[peer][id]; ok {
+		return
+	}
+	if offer.Size > r.config.MaxSize || (r.config.Accept != nil && !r.config.Accept(peer, offer)) {
+		peer.SendBytes(appendHeader(kindReject, id), r.config.Channel, enet.PacketFlagReliable)
+		return
+	}
+
+	in := &Incoming{id: id, peer: peer, offer: offer}
+	if data, ok := r.partial[offer.Checksum]; ok && int64(len(data)) <= offer.Size {
+		delete(r.partial, offer.Checksum)
+		in.data = data
+		in.resumed = int64(len(data))
+	}
+	if r.incoming[peer] == nil {
+		r.incoming[peer] = make(map[uint32]*Incoming)
+	}
+	r.incoming[peer][id] = in
+	peer.SendBytes(encodeValue(kindAccept, id, uint64(in.resumed)), r.config.Channel, enet.PacketFlagReliable)
+	r.verify(in)
+}
+
+// verify completes in once it has been received whole.
+func (r *Receiver) verify(in *Incoming) {
+	if int64(len(in.data)) < in.offer.Size {
+		return
+	}
+	ok := sha256.Sum256(in.data) == in.offer.Checksum
+	result := uint64(0)
+	if ok {
+		result = 1
+	}
+	in.peer.SendBytes(encodeValue(kindResult, in.id, result), r.config.Channel, enet.PacketFlagReliable)
+	if ok {
+		r.finish(in, in.data, nil)
+	} else {
+		r.finish(in, nil, ErrChecksum)
+	}
+}
+
+func (r *Receiver) finish(in *Incoming, data []byte, err error) {
+	delete(r.incoming[in.peer], in.id)
+	if len(r.incoming[in.peer]) == 0 {
+		delete(r.incoming, in.peer)
+	}
+	if r.config.OnComplete != nil {
+		r.config.OnComplete(in, data, err)
+	}
+}
@@ -0,0 +1,294 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"slices"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+)
+
+// SenderConfig configures a Sender
+type SenderConfig struct {
+	// Channel is the channel transfers are exchanged on
+	Channel uint8
+
+	// ChunkSize is the size of the chunks content is sent in. Defaults to
+	// DefaultChunkSize.
+	ChunkSize int
+
+	// Window is the number of bytes of a transfer in flight, sent but not yet
+	// acknowledged by the peer, which bounds the content queued in enet. Defaults to
+	// DefaultWindow.
+	Window int
+
+	// MaxConcurrent is the number of transfers run at once per peer, the others waiting
+	// for them to complete. Defaults to DefaultMaxConcurrent.
+	MaxConcurrent int
+
+	// OnProgress is called as the peer acknowledges content. It may be nil.
+	OnProgress func(t *Transfer)
+
+	// OnComplete is called once a transfer is over, with a nil error if the peer
+	// received and verified the content. It may be nil.
+	OnComplete func(t *Transfer, err error)
+}
+
+// Transfer is content sent to a peer by a Sender
+type Transfer struct {
+	id      uint32
+	peer    enet.Peer
+	offer   Offer
+	content io.ReaderAt
+
+	started bool
+	resumed int64
+	sent    int64
+	acked   int64
+}
+
+// Peer returns the peer the content is sent to
+func (t *Transfer) Peer() enet.Peer {
+	return t.peer
+}
+
+// Name returns the name the content is sent with
+func (t *Transfer) Name() string {
+	return t.offer.Name
+}
+
+// Size returns the size of the content
+func (t *Transfer) Size() int64 {
+	return t.offer.Size
+}
+
+// Checksum returns the SHA-256 checksum of the content
+func (t *Transfer) Checksum() [sha256.Size]byte {
+	return t.offer.Checksum
+}
+
+// Acked returns the number of bytes of the content the peer acknowledged, including
+// those it had before resuming
+func (t *Transfer) Acked() int64 {
+	return t.acked
+}
+
+// Resumed returns the offset the transfer resumed from, the number of bytes the peer
+// already had from a transfer cut short
+func (t *Transfer) Resumed() int64 {
+	return t.resumed
+}
+
+// senderPeer holds the transfers to a peer.
+type senderPeer struct {
+	transfers map[uint32]*Transfer
+	queue     []*Transfer
+	active    int
+}
+
+// Sender sends content to peers, see the package documentation. It is not safe for
+// concurrent use, and must be used by the goroutine servicing the host of its peers.
+type Sender struct {
+	config SenderConfig
+	nextID uint32
+	peers  map[enet.Peer]*senderPeer
+}
+
+// NewSender creates a sender
+func NewSender(config SenderConfig) *Sender {
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	config.Window = max(config.Window, config.ChunkSize)
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = DefaultMaxConcurrent
+	}
+	return &Sender{config: config, peers: make(map[enet.Peer]*senderPeer)}
+}
+
+// Send sends size bytes of content to peer under name, offering it right away unless
+// the peer already runs as many transfers as it may, in which case it is queued.
+// Content is read as it is sent, once first to compute its checksum, and must not
+// change until the transfer completes.
+func (s *Sender) Send(peer enet.Peer, name string, content io.ReaderAt, size int64) (*Transfer, error) {
+	if len(name) > MaxNameSize {
+		return nil, errors.New("transfer name is too long")
+	}
+	if size < 0 {
+		return nil, errors.New("transfer size is negative")
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(content, 0, size)); err != nil {
+		return nil, err
+	}
+
+	s.nextID++
+	t := &Transfer{
+		id:      s.nextID,
+		peer:    peer,
+		offer:   Offer{Name: name, Size: size},
+		content: content,
+	}
+	hash.Sum(t.offer.Checksum[:0])
+
+	p, ok := s.peers[peer]
+	if !ok {
+		p = &senderPeer{transfers: make(map[uint32]*Transfer)}
+		s.peers[peer] = p
+	}
+	p.transfers[t.id] = t
+	p.queue = append(p.queue, t)
+	s.startQueued(p)
+	return t, nil
+}
+
+// SendBytes sends data to peer under name, like Send
+func (s *Sender) SendBytes(peer enet.Peer, name string, data []byte) (*Transfer, error) {
+	return s.Send(peer, name, bytes.NewReader(data), int64(len(data)))
+}
+
+// Transfers returns the transfers to peer that are not over, running or queued
+func (s *Sender) Transfers(peer enet.Peer) []*Transfer {
+	p, ok := s.peers[peer]
+	if !ok {
+		return nil
+	}
+	transfers := make([]*Transfer, 0, len(p.transfers))
+	for _, t := range p.transfers {
+		transfers = append(transfers, t)
+	}
+	return transfers
+}
+
+// Cancel aborts t, reporting ErrCancelled
+func (s *Sender) Cancel(t *Transfer) {
+	p, ok := s.peers[t.peer]
+	if !ok || p.transfers[t.id] != t {
+		return
+	}
+	if t.started {
+		t.peer.SendBytes(appendHeader(kindCancel, t.id), s.config.Channel, enet.PacketFlagReliable)
+	}
+	s.finish(p, t, ErrCancelled)
+}
+
+// Handle consumes the messages received on the transfer channel, returning true if the
+// event carried one, and fails the transfers to peers once they disconnect, reporting
+// ErrDisconnected. A connect event on a peer counts as a disconnect of the connection it
+// replaces, for those dropped without one. The packet of the event is left to the
+// caller.
+func (s *Sender) Handle(event enet.Event) bool {
+	switch event.GetType() {
+	case enet.EventConnect, enet.EventDisconnect, enet.EventDisconnectTimeout:
+		if p, ok := s.peers[event.GetPeer()]; ok {
+			delete(s.peers, event.GetPeer())
+			p.queue = nil
+			for _, t := range p.transfers {
+				s.complete(t, ErrDisconnected)
+			}
+		}
+		return false
+	case enet.EventReceive:
+	default:
+		return false
+	}
+	if event.GetChannelID() != s.config.Channel {
+		return false
+	}
+
+	msg, err := decode(event.GetPacket().GetData())
+	if err != nil {
+		return true
+	}
+	p, ok := s.peers[event.GetPeer()]
+	if !ok {
+		return true
+	}
+	t, ok := p.transfers[msg.id]
+	if !ok || !t.started {
+		return true
+	}
+
+	switch msg.kind {
+	case kindAccept:
+		offset := int64(min(msg.value, uint64(t.offer.Size)))
+		t.resumed, t.sent, t.acked = offset, offset, offset
+		s.pump(p, t)
+	case kindAck:
+		if acked := int64(msg.value); acked > t.acked && acked <= t.sent {
+			t.acked = acked
+			if s.config.OnProgress != nil {
+				s.config.OnProgress(t)
+			}
+			s.pump(p, t)
+		}
+	case kindResult:
+		if msg.value == 1 {
+			s.finish(p, t, nil)
+		} else {
+			s.finish(p, t, ErrChecksum)
+		}
+	case kindReject:
+		s.finish(p, t, ErrRejected)
+	}
+	return true
+}
+
+// startQueued offers the transfers queued for p as long as it runs fewer than it may.
+func (s *Sender) startQueued(p *senderPeer) {
+	for len(p.queue) > 0 && p.active < s.config.MaxConcurrent {
+		t := p.queue[0]
+		p.queue = p.queue[1:]
+		p.active++
+		t.started = true
+		if err := t.peer.SendBytes(encodeOffer(t.id, t.offer), s.config.Channel, enet.PacketFlagReliable); err != nil {
+			s.finish(p, t, err)
+		}
+	}
+}
+
+// pump sends the content of t until the window is full.
+func (s *Sender) pump(p *senderPeer, t *Transfer) {
+	for t.sent < t.offer.Size && t.sent-t.acked < int64(s.config.Window) {
+		chunk := make([]byte, min(int64(s.config.ChunkSize), t.offer.Size-t.sent))
+		if n, err := t.content.ReadAt(chunk, t.sent); n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			t.peer.SendBytes(appendHeader(kindCancel, t.id), s.config.Channel, enet.PacketFlagReliable)
+			s.finish(p, t, err)
+			return
+		}
+		if err := t.peer.SendBytes(encodeChunk(t.id, t.sent, chunk), s.config.Channel, enet.PacketFlagReliable); err != nil {
+			s.finish(p, t, err)
+			return
+		}
+		t.sent += int64(len(chunk))
+	}
+}
+
+// finish ends t, starting the next transfer queued.
+func (s *Sender) finish(p *senderPeer, t *Transfer, err error) {
+	delete(p.transfers, t.id)
+	if t.started {
+		p.active--
+	} else if i := slices.Index(p.queue, t); i >= 0 {
+		p.queue = slices.Delete(p.queue, i, i+1)
+	}
+	if len(p.transfers) == 0 {
+		delete(s.peers, t.peer)
+	}
+	s.complete(t, err)
+	s.startQueued(p)
+}
+
+func (s *Sender) complete(t *Transfer, err error) {
+	if s.config.OnComplete != nil {
+		s.config.OnComplete(t, err)
+	}
+}
@@ -0,0 +1,175 @@
+// Package transfer transfers files and other blobs to peers in chunks, over a channel of
+// their own, for example the maps and assets clients download as they connect. A
+// Sender offers the content to a peer, streams it once accepted, never having more than
+// a window of it in flight, and runs a few transfers per peer at once, queueing the
+// others:
+//
+//	sender := transfer.NewSender(transfer.SenderConfig{
+//		Channel:    3,
+//		OnComplete: func(t *transfer.Transfer, err error) { log.Println(t.Name(), err) },
+//	})
+//	file, err := os.Open("maps/harbor.map")
+//	info, err := file.Stat()
+//	_, err = sender.Send(peer, "harbor.map", file, info.Size())
+//
+//	// For every event, on the goroutine servicing the host:
+//	if sender.Handle(event) {
+//		return
+//	}
+//
+// The peer receives it with a Receiver:
+//
+//	receiver := transfer.NewReceiver(transfer.ReceiverConfig{
+//		Channel:    3,
+//		OnProgress: func(in *transfer.Incoming) { showProgress(in.Received(), in.Size()) },
+//		OnComplete: func(in *transfer.Incoming, data []byte, err error) { loadMap(data) },
+//	})
+//
+//	// For every event:
+//	if receiver.Handle(event) {
+//		return
+//	}
+//
+// Content is verified against its SHA-256 checksum once received. Receivers keep what
+// they got of transfers cut short by a disconnect, and resume from there when the same
+// content is offered again once the peer reconnected.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks content is sent in by default
+	DefaultChunkSize = 16 << 10
+
+	// DefaultWindow is the number of bytes of a transfer in flight, sent but not yet
+	// acknowledged, by default
+	DefaultWindow = 256 << 10
+
+	// DefaultMaxConcurrent is the number of transfers run at once per peer by default
+	DefaultMaxConcurrent = 2
+
+	// DefaultMaxSize is the size of the largest content receivers accept by default
+	DefaultMaxSize = 256 << 20
+
+	// MaxNameSize is the size of the longest name content can be sent with
+	MaxNameSize = 1024
+)
+
+var (
+	// ErrRejected is reported for transfers the peer refused or cancelled
+	ErrRejected = errors.New("transfer rejected by peer")
+
+	// ErrChecksum is reported for transfers whose content did not match its checksum
+	ErrChecksum = errors.New("transfer checksum mismatch")
+
+	// ErrDisconnected is reported for transfers cut short by the peer disconnecting
+	ErrDisconnected = errors.New("peer disconnected during transfer")
+
+	// ErrCancelled is reported for transfers cancelled locally
+	ErrCancelled = errors.New("transfer cancelled")
+
+	errMalformed = errors.New("malformed transfer message")
+)
+
+// Kinds of transfer messages, exchanged reliably on the transfer channel. Every one is
+// followed by the ID of the transfer, a uvarint.
+const (
+	// kindOffer offers content: its size, its checksum, then its name.
+	kindOffer byte = iota
+
+	// kindAccept accepts an offer, followed by the offset to send the content from.
+	kindAccept
+
+	// kindReject refuses an offer, or aborts the transfer.
+	kindReject
+
+	// kindChunk carries content: its offset, then the bytes.
+	kindChunk
+
+	// kindAck acknowledges the content received, followed by its size.
+	kindAck
+
+	// kindResult reports whether the content received matched its checksum.
+	kindResult
+
+	// kindCancel aborts a transfer on the side of the sender.
+	kindCancel
+)
+
+// Offer describes content offered by a sender
+type Offer struct {
+	Name     string
+	Size     int64
+	Checksum [sha256.Size]byte
+}
+
+// message is a decoded transfer message.
+type message struct {
+	kind  byte
+	id    uint32
+	value uint64
+	offer Offer
+	data  []byte
+}
+
+func appendHeader(kind byte, id uint32) []byte {
+	return binary.AppendUvarint([]byte{kind}, uint64(id))
+}
+
+func encodeOffer(id uint32, offer Offer) []byte {
+	data := appendHeader(kindOffer, id)
+	data = binary.AppendUvarint(data, uint64(offer.Size))
+	data = append(data, offer.Checksum[:]...)
+	return append(data, offer.Name...)
+}
+
+func encodeValue(kind byte, id uint32, value uint64) []byte {
+	return binary.AppendUvarint(appendHeader(kind, id), value)
+}
+
+func encodeChunk(id uint32, offset int64, chunk []byte) []byte {
+	return append(encodeValue(kindChunk, id, uint64(offset)), chunk...)
+}
+
+func decode(data []byte) (message, error) {
+	if len(data) == 0 {
+		return message{}, errMalformed
+	}
+	msg := message{kind: data[0]}
+	id, n := binary.Uvarint(data[1:])
+	if n <= 0 || id > 1<<32-1 {
+		return message{}, errMalformed
+	}
+	msg.id = uint32(id)
+	data = data[1+n:]
+
+	switch msg.kind {
+	case kindReject, kindCancel:
+		return msg, nil
+	case kindOffer, kindAccept, kindChunk, kindAck, kindResult:
+	default:
+		return message{}, errMalformed
+	}
+	msg.value, n = binary.Uvarint(data)
+	if n <= 0 || msg.value > 1<<62 {
+		return message{}, errMalformed
+	}
+	data = data[n:]
+
+	switch msg.kind {
+	case kindOffer:
+		if len(data) < sha256.Size || len(data) > sha256.Size+MaxNameSize {
+			return message{}, errMalformed
+		}
+		msg.offer.Size = int64(msg.value)
+		copy(msg.offer.Checksum[:], data)
+		msg.offer.Name = string(data[sha256.Size:])
+	case kindChunk:
+		msg.data = data
+	}
+	return msg, nil
+}
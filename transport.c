@@ -0,0 +1,26 @@
+//go:build !purego && !(js && wasm)
+
+#include "enet.h"
+#include "_cgo_export.h"
+
+static int goenet_transport_send(ENetHost* host, const ENetAddress* address, const ENetBuffer* buffers, size_t bufferCount) {
+	return goenetTransportSend(host, (ENetAddress*)address, (ENetBuffer*)buffers, bufferCount);
+}
+
+static int goenet_transport_receive(ENetHost* host, ENetAddress* address, ENetBuffer* buffers, size_t bufferCount) {
+	return goenetTransportReceive(host, address, buffers, bufferCount);
+}
+
+static int goenet_transport_wait(ENetHost* host, uint32_t* condition, uint64_t timeout) {
+	return goenetTransportWait(host, condition, timeout);
+}
+
+static const ENetTransport goenet_transport = {
+	goenet_transport_send,
+	goenet_transport_receive,
+	goenet_transport_wait
+};
+
+void goenet_host_set_transport(ENetHost* host, const ENetAddress* address) {
+	enet_host_set_transport(host, &goenet_transport, address);
+}
@@ -0,0 +1,95 @@
+package enet
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// Transport carries the datagrams of a host in place of its own UDP socket, for
+// example an in-memory network, a network simulator or a tunnel. Addresses are UDP
+// addresses, which may well be virtual ones. Every net.PacketConn is a Transport.
+type Transport interface {
+	// ReadFrom blocks until a datagram arrives. It's called from a goroutine of the
+	// host, and must return an error once the transport is closed.
+	ReadFrom(p []byte) (n int, addr net.Addr, err error)
+
+	WriteTo(p []byte, addr net.Addr) (n int, err error)
+
+	// LocalAddr returns the address peers reach the host at
+	LocalAddr() net.Addr
+
+	Close() error
+}
+
+// TransportFactory creates the transport of a host bound to addr, which is nil for
+// hosts that only connect to others
+type TransportFactory func(addr Address) (Transport, error)
+
+var transportFactory atomic.Pointer[TransportFactory]
+
+// SetTransportFactory makes NewHost create hosts on the transports of factory instead
+// of UDP sockets, so an application can be moved onto another substrate without
+// changing it, for example in tests. Passing nil restores UDP sockets. Hosts created
+// before are not affected, and hosts in the browser never use it.
+func SetTransportFactory(factory TransportFactory) {
+	if factory == nil {
+		transportFactory.Store(nil)
+		return
+	}
+	transportFactory.Store(&factory)
+}
+
+// NewTransportHost creates a host sending and receiving through transport instead of
+// a UDP socket of its own. The host owns the transport and closes it once destroyed.
+// The other parameters are those of NewHost.
+func NewTransportHost(transport Transport, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (Host, error) {
+	if transport == nil {
+		return nil, errors.New("no transport")
+	}
+	return newTransportHost(transport, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth)
+}
+
+// factoryHost creates the host for NewHost if a transport factory is set.
+func factoryHost(addr Address, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (Host, bool, error) {
+	factory := transportFactory.Load()
+	if factory == nil {
+		return nil, false, nil
+	}
+
+	transport, err := (*factory)(addr)
+	if err != nil {
+		return nil, true, err
+	}
+	host, err := newTransportHost(transport, peerCount, channelLimit, incomingBandwidth, outgoingBandwidth)
+	if err != nil {
+		transport.Close()
+	}
+	return host, true, err
+}
+
+// transportUDPAddr converts an address of a transport to a UDP address.
+func transportUDPAddr(addr net.Addr) *net.UDPAddr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp
+	}
+	if addr == nil {
+		return &net.UDPAddr{IP: net.IPv6unspecified}
+	}
+	if udp, err := net.ResolveUDPAddr("udp", addr.String()); err == nil {
+		return udp
+	}
+	return &net.UDPAddr{IP: net.IPv6unspecified}
+}
+
+// peerUDPAddr converts the address of a peer to a UDP address.
+func peerUDPAddr(addr Address) *net.UDPAddr {
+	if addr == nil {
+		return &net.UDPAddr{IP: net.IPv6unspecified}
+	}
+	ip := net.ParseIP(addr.String())
+	if ip == nil {
+		ip = net.IPv6unspecified
+	}
+	return &net.UDPAddr{IP: ip, Port: int(addr.GetPort())}
+}
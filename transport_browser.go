@@ -0,0 +1,343 @@
+//go:build js && wasm
+
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+
+	"github.com/TubbyStubby/go-enet-sharp/internal/protocol"
+)
+
+// browserPacketSizeMax matches the largest packet the bridges accept on a stream.
+const browserPacketSizeMax = 32 * 1024 * 1024
+
+// browserTransport carries a peer over a connection to a bridge, matching the wire
+// format of the wsbridge or wtbridge package.
+type browserTransport interface {
+	// start begins posting the events of the connection, tagged with generation.
+	start(generation uint32)
+	send(channel uint8, flags PacketFlags, data []byte) error
+	// close closes the connection, after which it posts no more events.
+	close(data uint32)
+}
+
+// ignore swallows the rejections of promises nobody waits for.
+var ignore = js.FuncOf(func(this js.Value, args []js.Value) any { return nil })
+
+// dialBrowser opens a WebTransport session to https URLs and a WebSocket otherwise.
+func dialBrowser(url string, peer *browserPeer) (transport browserTransport, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			transport, err = nil, errors.New("unable to dial bridge")
+		}
+	}()
+
+	if strings.HasPrefix(url, "https://") {
+		if !js.Global().Get("WebTransport").Truthy() {
+			return nil, errors.New("WebTransport is not supported by the browser")
+		}
+		return &wtTransport{
+			peer:    peer,
+			session: js.Global().Get("WebTransport").New(url),
+			closing: make(chan struct{}),
+		}, nil
+	}
+
+	ws := js.Global().Get("WebSocket").New(url)
+	ws.Set("binaryType", "arraybuffer")
+	return &wsTransport{peer: peer, ws: ws}, nil
+}
+
+// await blocks until the promise settles. Must not be called from a JavaScript
+// callback.
+func await(promise js.Value) (js.Value, error) {
+	done := make(chan struct{})
+	var result js.Value
+	var err error
+
+	resolve := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) > 0 {
+			result = args[0]
+		}
+		close(done)
+		return nil
+	})
+	defer resolve.Release()
+	reject := js.FuncOf(func(this js.Value, args []js.Value) any {
+		err = errors.New("promise rejected")
+		if len(args) > 0 {
+			err = js.Error{Value: args[0]}
+		}
+		close(done)
+		return nil
+	})
+	defer reject.Release()
+
+	promise.Call("then", resolve, reject)
+	<-done
+	return result, err
+}
+
+// bytesOf copies the bytes of an ArrayBuffer or Uint8Array.
+func bytesOf(value js.Value) []byte {
+	array := value
+	if !value.InstanceOf(js.Global().Get("Uint8Array")) {
+		array = js.Global().Get("Uint8Array").New(value)
+	}
+	b := make([]byte, array.Length())
+	js.CopyBytesToGo(b, array)
+	return b
+}
+
+// arrayOf copies b to a new Uint8Array.
+func arrayOf(b []byte) js.Value {
+	array := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(array, b)
+	return array
+}
+
+// wsTransport is a WebSocket connection to a wsbridge. Every message is the channel
+// followed by the payload, and the bridge treats every packet as reliable.
+type wsTransport struct {
+	peer   *browserPeer
+	ws     js.Value
+	funcs  []js.Func
+	closed bool
+	buffer []byte
+}
+
+func (t *wsTransport) start(generation uint32) {
+	host := t.peer.host
+	t.handle("onopen", func(js.Value) {
+		host.post(browserEvent{eventType: EventConnect, peer: t.peer, generation: generation})
+	})
+	t.handle("onmessage", func(event js.Value) {
+		msg := bytesOf(event.Get("data"))
+		if len(msg) == 0 {
+			return
+		}
+		host.post(browserEvent{
+			eventType:  EventReceive,
+			peer:       t.peer,
+			generation: generation,
+			channelID:  msg[0],
+			packet:     &protocol.Packet{Data: msg[1:], Flags: uint32(PacketFlagReliable)},
+		})
+	})
+	t.handle("onclose", func(event js.Value) {
+		ev := browserEvent{eventType: EventDisconnectTimeout, peer: t.peer, generation: generation}
+		if event.Get("code").Int() == 1000 {
+			data, _ := strconv.ParseUint(event.Get("reason").String(), 10, 32)
+			ev.eventType = EventDisconnect
+			ev.data = uint32(data)
+		}
+		t.release()
+		host.post(ev)
+	})
+}
+
+// handle sets an event handler of the socket, which stops being called once the
+// transport is closed.
+func (t *wsTransport) handle(name string, handler func(event js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if !t.closed {
+			handler(args[0])
+		}
+		return nil
+	})
+	t.funcs = append(t.funcs, f)
+	t.ws.Set(name, f)
+}
+
+func (t *wsTransport) release() {
+	t.closed = true
+	for _, name := range []string{"onopen", "onmessage", "onclose"} {
+		t.ws.Set(name, js.Null())
+	}
+	for _, f := range t.funcs {
+		f.Release()
+	}
+	t.funcs = nil
+}
+
+func (t *wsTransport) send(channel uint8, flags PacketFlags, data []byte) error {
+	if t.closed {
+		return errors.New("connection is closed")
+	}
+	t.buffer = append(append(t.buffer[:0], channel), data...)
+	t.ws.Call("send", arrayOf(t.buffer))
+	return nil
+}
+
+func (t *wsTransport) close(data uint32) {
+	if t.closed {
+		return
+	}
+	t.release()
+	t.ws.Call("close", 1000, strconv.FormatUint(uint64(data), 10))
+}
+
+// wtTransport is a WebTransport session to a wtbridge. Reliable packets are framed on
+// a bidirectional stream as a uvarint size, the channel and the payload, and
+// unreliable ones are sent as datagrams when they fit.
+type wtTransport struct {
+	peer    *browserPeer
+	session js.Value
+	closed  atomic.Bool
+
+	stream    js.Value
+	datagrams js.Value
+	buffer    []byte
+
+	// closing is closed with closeInfo set once the session has been closed cleanly.
+	closing   chan struct{}
+	closeInfo js.Value
+}
+
+func (t *wtTransport) start(generation uint32) {
+	go func() {
+		info, err := await(t.session.Get("closed"))
+		if err == nil {
+			t.closeInfo = info
+			close(t.closing)
+		}
+	}()
+	go t.run(generation)
+}
+
+func (t *wtTransport) run(generation uint32) {
+	host := t.peer.host
+	if _, err := await(t.session.Get("ready")); err != nil {
+		t.disconnected(generation)
+		return
+	}
+	stream, err := await(t.session.Call("createBidirectionalStream"))
+	if err != nil {
+		t.disconnected(generation)
+		return
+	}
+	if t.closed.Load() {
+		return
+	}
+	t.stream = stream.Get("writable").Call("getWriter")
+	t.datagrams = t.session.Get("datagrams").Get("writable").Call("getWriter")
+	host.post(browserEvent{eventType: EventConnect, peer: t.peer, generation: generation})
+
+	go t.readDatagrams(generation)
+	t.readStream(generation, stream.Get("readable").Call("getReader"))
+}
+
+func (t *wtTransport) readStream(generation uint32, reader js.Value) {
+	var buffer []byte
+	for {
+		chunk, err := await(reader.Call("read"))
+		if err != nil || chunk.Get("done").Bool() {
+			t.disconnected(generation)
+			return
+		}
+		buffer = append(buffer, bytesOf(chunk.Get("value"))...)
+
+		for {
+			size, n := binary.Uvarint(buffer)
+			if n == 0 {
+				break
+			}
+			if n < 0 || size == 0 || size > browserPacketSizeMax {
+				t.disconnected(generation)
+				return
+			}
+			if uint64(len(buffer)-n) < size {
+				break
+			}
+
+			packet := buffer[n : n+int(size)]
+			t.receive(generation, packet[0], PacketFlagReliable, append([]byte(nil), packet[1:]...))
+			buffer = buffer[n+int(size):]
+		}
+	}
+}
+
+func (t *wtTransport) readDatagrams(generation uint32) {
+	reader := t.session.Get("datagrams").Get("readable").Call("getReader")
+	for {
+		chunk, err := await(reader.Call("read"))
+		if err != nil || chunk.Get("done").Bool() {
+			// The stream reader reports the disconnect.
+			return
+		}
+		datagram := bytesOf(chunk.Get("value"))
+		if len(datagram) == 0 {
+			continue
+		}
+		t.receive(generation, datagram[0], 0, datagram[1:])
+	}
+}
+
+func (t *wtTransport) receive(generation uint32, channel uint8, flags PacketFlags, data []byte) {
+	if t.closed.Load() {
+		return
+	}
+	t.peer.host.post(browserEvent{
+		eventType:  EventReceive,
+		peer:       t.peer,
+		generation: generation,
+		channelID:  channel,
+		packet:     &protocol.Packet{Data: data, Flags: uint32(flags)},
+	})
+}
+
+// disconnected reports the end of the session, closing it if it's still open.
+func (t *wtTransport) disconnected(generation uint32) {
+	if t.closed.Swap(true) {
+		return
+	}
+
+	ev := browserEvent{eventType: EventDisconnectTimeout, peer: t.peer, generation: generation}
+	select {
+	case <-t.closing:
+		ev.eventType = EventDisconnect
+		ev.data = uint32(t.closeInfo.Get("closeCode").Int())
+	case <-time.After(time.Second):
+		// The session may close right after the stream, otherwise it failed.
+		t.session.Call("close")
+	}
+	t.peer.host.post(ev)
+}
+
+func (t *wtTransport) send(channel uint8, flags PacketFlags, data []byte) error {
+	if t.closed.Load() {
+		return errors.New("connection is closed")
+	}
+
+	if flags&PacketFlagReliable == 0 {
+		size := 1200
+		if max := t.session.Get("datagrams").Get("maxDatagramSize"); max.Truthy() {
+			size = max.Int()
+		}
+		if 1+len(data) <= size {
+			t.buffer = append(append(t.buffer[:0], channel), data...)
+			t.datagrams.Call("write", arrayOf(t.buffer)).Call("catch", ignore)
+			return nil
+		}
+		// Too large for a datagram, the stream takes anything.
+	}
+
+	t.buffer = binary.AppendUvarint(t.buffer[:0], uint64(1+len(data)))
+	t.buffer = append(t.buffer, channel)
+	t.buffer = append(t.buffer, data...)
+	t.stream.Call("write", arrayOf(t.buffer)).Call("catch", ignore)
+	return nil
+}
+
+func (t *wtTransport) close(data uint32) {
+	if t.closed.Swap(true) {
+		return
+	}
+	t.session.Call("close", map[string]any{"closeCode": data, "reason": ""})
+}
@@ -0,0 +1,201 @@
+//go:build !purego && !(js && wasm)
+
+package enet
+
+// #include "enet.h"
+// void goenet_host_set_transport(ENetHost* host, const ENetAddress* address);
+import "C"
+import (
+	"errors"
+	"net"
+	"time"
+	"unsafe"
+)
+
+// transportQueueSize bounds the datagrams read from a transport but not yet received
+// by enet, beyond which reading waits like a full socket buffer would drop.
+const transportQueueSize = 256
+
+type transportDatagram struct {
+	addr *net.UDPAddr
+	data []byte
+}
+
+// transportConn feeds the datagrams of a transport to enet, which polls for them
+// like it does on its socket.
+type transportConn struct {
+	transport Transport
+	received  chan transportDatagram
+	pending   *transportDatagram
+	closed    chan struct{}
+
+	// poller is the transport if the host polls it, with the buffer it polls into.
+	poller     pollingTransport
+	pollBuffer []byte
+}
+
+func newTransportHost(transport Transport, peerCount, channelLimit uint64, incomingBandwidth, outgoingBandwidth uint32) (Host, error) {
+	host := C.enet_host_create(
+		nil,
+		(C.size_t)(peerCount),
+		(C.size_t)(channelLimit),
+		(C.uint32_t)(incomingBandwidth),
+		(C.uint32_t)(outgoingBandwidth),
+		0,
+	)
+	if host == nil {
+		return nil, errors.New("unable to create host")
+	}
+
+	conn := &transportConn{
+		transport: transport,
+		received:  make(chan transportDatagram, transportQueueSize),
+		closed:    make(chan struct{}),
+	}
+	local := enetAddressOf(transportUDPAddr(transport.LocalAddr()))
+	C.goenet_host_set_transport(host, &local)
+
+	ret := &enetHost{
+		hostBackend: hostBackend{cHost: host, transport: conn},
+	}
+	registerHost(host, ret)
+	leakTrack(unsafe.Pointer(host), leakHost)
+	if polling, ok := transport.(pollingTransport); ok && polling.polling() {
+		conn.poller = polling
+		conn.pollBuffer = make([]byte, 64*1024)
+	} else {
+		go conn.read()
+	}
+	return ret, nil
+}
+
+// read moves the datagrams arriving on the transport to the received queue.
+func (conn *transportConn) read() {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.transport.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-conn.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		dgram := transportDatagram{addr: transportUDPAddr(addr), data: append([]byte(nil), buffer[:n]...)}
+		select {
+		case conn.received <- dgram:
+		case <-conn.closed:
+			return
+		}
+	}
+}
+
+func (conn *transportConn) close() {
+	close(conn.closed)
+	conn.transport.Close()
+}
+
+// next returns the next received datagram, waiting up to timeout for one.
+func (conn *transportConn) next(timeout time.Duration) (*transportDatagram, bool) {
+	if conn.pending != nil {
+		return conn.pending, true
+	}
+	if conn.poller != nil {
+		// Time stands still for polled hosts, there is nothing to wait for.
+		n, addr, ok := conn.poller.poll(conn.pollBuffer)
+		if !ok {
+			return nil, false
+		}
+		conn.pending = &transportDatagram{addr: transportUDPAddr(addr), data: append([]byte(nil), conn.pollBuffer[:n]...)}
+		return conn.pending, true
+	}
+
+	select {
+	case dgram := <-conn.received:
+		conn.pending = &dgram
+		return conn.pending, true
+	default:
+	}
+	if timeout <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case dgram := <-conn.received:
+		conn.pending = &dgram
+		return conn.pending, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+func transportOf(cHost *C.ENetHost) *transportConn {
+	host := hostOf(cHost)
+	if host == nil {
+		return nil
+	}
+	return host.transport
+}
+
+//export goenetTransportSend
+func goenetTransportSend(cHost *C.ENetHost, address *C.ENetAddress, buffers *C.ENetBuffer, bufferCount C.size_t) C.int {
+	conn := transportOf(cHost)
+	if conn == nil {
+		return -1
+	}
+
+	var data []byte
+	for _, buffer := range unsafe.Slice(buffers, int(bufferCount)) {
+		data = append(data, unsafe.Slice((*byte)(buffer.data), int(buffer.dataLength))...)
+	}
+	if _, err := conn.transport.WriteTo(data, udpAddrOf(address)); err != nil {
+		// Like a socket that could not send, the datagram is lost.
+		return 0
+	}
+	return C.int(len(data))
+}
+
+//export goenetTransportReceive
+func goenetTransportReceive(cHost *C.ENetHost, address *C.ENetAddress, buffers *C.ENetBuffer, bufferCount C.size_t) C.int {
+	conn := transportOf(cHost)
+	if conn == nil {
+		return -1
+	}
+
+	dgram, ok := conn.next(0)
+	if !ok {
+		return 0
+	}
+	conn.pending = nil
+
+	buffer := unsafe.Slice(buffers, int(bufferCount))[0]
+	if len(dgram.data) > int(buffer.dataLength) {
+		// Truncated, like the socket does.
+		return -2
+	}
+	copy(unsafe.Slice((*byte)(buffer.data), len(dgram.data)), dgram.data)
+	*address = enetAddressOf(dgram.addr)
+	return C.int(len(dgram.data))
+}
+
+//export goenetTransportWait
+func goenetTransportWait(cHost *C.ENetHost, condition *C.uint32_t, timeout C.uint64_t) C.int {
+	conn := transportOf(cHost)
+	if conn == nil {
+		return -1
+	}
+
+	if _, ok := conn.next(time.Duration(timeout) * time.Millisecond); ok {
+		*condition = C.ENET_SOCKET_WAIT_RECEIVE
+	} else {
+		*condition = C.ENET_SOCKET_WAIT_NONE
+	}
+	return 0
+}
@@ -0,0 +1,274 @@
+package enet
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultValidationWindow is the span offenses are counted over by default
+const DefaultValidationWindow = time.Minute
+
+// Schema is what the messages of an opcode must look like, checked by a Validator
+// before they are dispatched
+type Schema struct {
+	// MaxSize bounds the size of the payloads following the opcode, 0 meaning no bound
+	MaxSize int
+
+	// Decode decodes the payload, failing if it is malformed. What it returns is handed
+	// to Validate. It may be nil, see DecodeAs.
+	Decode func(payload []byte) (any, error)
+
+	// Validate checks the decoded message makes sense, such as a position being within
+	// the map. It may be nil.
+	Validate func(msg *Message, v any) error
+}
+
+// DecodeAs returns a Schema.Decode decoding payloads into a T with codec
+func DecodeAs[T any](codec Codec) func(payload []byte) (any, error) {
+	return func(payload []byte) (any, error) {
+		var v T
+		err := codec.Unmarshal(payload, &v)
+		return v, err
+	}
+}
+
+// InvalidMessage is a message that failed validation
+type InvalidMessage struct {
+	Peer      Peer
+	ChannelID uint8
+	Opcode    uint64
+	Err       error
+
+	// Offenses is the number of invalid messages of the peer over the window, this one
+	// included
+	Offenses int
+
+	// Kicked is set if the peer is being disconnected for it
+	Kicked bool
+}
+
+// ValidationStats counts the messages checked by a Validator
+type ValidationStats struct {
+	Valid   uint64
+	Invalid uint64
+
+	// Kicked counts the peers disconnected for repeat offenses
+	Kicked uint64
+
+	// InvalidByOpcode counts the invalid messages per opcode. Messages whose opcode
+	// could not be decoded are not counted.
+	InvalidByOpcode map[uint64]uint64
+}
+
+// ValidatorConfig configures a Validator
+type ValidatorConfig struct {
+	// Format is how opcodes are encoded, as for the Dispatcher the messages go on to
+	Format OpcodeFormat
+
+	// RejectUnknown makes messages of opcodes without a schema invalid. By default they
+	// pass unchecked.
+	RejectUnknown bool
+
+	// KickAfter disconnects peers sending that many invalid messages over the window,
+	// 0 meaning never. Messages of peers being disconnected are dropped.
+	KickAfter int
+
+	// KickData is the data peers are disconnected with
+	KickData uint32
+
+	// Window is the span offenses are counted over. Defaults to
+	// DefaultValidationWindow.
+	Window time.Duration
+
+	// OnInvalid is called for every invalid message. It may be nil.
+	OnInvalid func(InvalidMessage)
+
+	// Clock tells the time offenses are counted at. Defaults to the SystemClock.
+	Clock Clock
+}
+
+// Validator checks received messages against the schema of their opcode before they are
+// dispatched, centralizing defensive parsing, and disconnects peers that keep sending
+// invalid ones
+type Validator interface {
+	// Register sets the schema of opcode, replacing any previous one
+	Register(opcode uint64, schema Schema)
+
+	// Check checks the message of a receive event, returning why it is invalid. Events
+	// other than EventReceive are valid, and forget the offenses of peers as they
+	// connect or disconnect. It must be called for every event, by the goroutine
+	// servicing the host.
+	Check(event Event) error
+
+	// Middleware returns a ServiceLoop middleware dropping invalid messages. The packet
+	// of the event is left to the loop.
+	Middleware() Middleware
+
+	// Stats returns the counts of the validator. It is safe to call from any goroutine.
+	Stats() ValidationStats
+}
+
+// offenses are the invalid messages of a peer over the current window.
+type offenses struct {
+	start  time.Time
+	count  int
+	kicked bool
+}
+
+type enetValidator struct {
+	config ValidatorConfig
+
+	lock    sync.Mutex
+	schemas map[uint64]Schema
+	peers   map[Peer]*offenses
+	stats   ValidationStats
+}
+
+// NewValidator creates a validator without schemas
+func NewValidator(config ValidatorConfig) Validator {
+	if config.Window <= 0 {
+		config.Window = DefaultValidationWindow
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	return &enetValidator{
+		config:  config,
+		schemas: make(map[uint64]Schema),
+		peers:   make(map[Peer]*offenses),
+		stats:   ValidationStats{InvalidByOpcode: make(map[uint64]uint64)},
+	}
+}
+
+func (v *enetValidator) Register(opcode uint64, schema Schema) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.schemas[opcode] = schema
+}
+
+func (v *enetValidator) Check(event Event) error {
+	peer := event.GetPeer()
+	switch event.GetType() {
+	case EventConnect, EventDisconnect, EventDisconnectTimeout:
+		v.lock.Lock()
+		delete(v.peers, peer)
+		v.lock.Unlock()
+		return nil
+	case EventReceive:
+	default:
+		return nil
+	}
+
+	v.lock.Lock()
+	if o := v.peers[peer]; o != nil && o.kicked {
+		v.lock.Unlock()
+		return errors.New("peer is being disconnected")
+	}
+	data := event.GetPacketDataUnsafe()
+	opcode, n, err := DecodeOpcode(v.config.Format, data)
+	schema, known := v.schemas[opcode]
+	v.lock.Unlock()
+
+	if err != nil {
+		v.offend(event, opcode, false, err)
+		return err
+	}
+	if err := v.check(event, schema, known, opcode, data[n:]); err != nil {
+		v.offend(event, opcode, true, err)
+		return err
+	}
+	v.lock.Lock()
+	v.stats.Valid++
+	v.lock.Unlock()
+	return nil
+}
+
+// check checks the payload of a message of opcode against its schema.
+func (v *enetValidator) check(event Event, schema Schema, known bool, opcode uint64, payload []byte) error {
+	if !known {
+		if v.config.RejectUnknown {
+			return fmt.Errorf("no schema for opcode %d", opcode)
+		}
+		return nil
+	}
+	if schema.MaxSize > 0 && len(payload) > schema.MaxSize {
+		return fmt.Errorf("payload of %d bytes is larger than %d", len(payload), schema.MaxSize)
+	}
+	var decoded any
+	if schema.Decode != nil {
+		var err error
+		if decoded, err = schema.Decode(payload); err != nil {
+			return err
+		}
+	}
+	if schema.Validate != nil {
+		return schema.Validate(&Message{
+			Peer:      event.GetPeer(),
+			ChannelID: event.GetChannelID(),
+			Opcode:    opcode,
+			Timestamp: event.GetTimestamp(),
+			Payload:   append([]byte(nil), payload...),
+		}, decoded)
+	}
+	return nil
+}
+
+// offend counts an invalid message of the peer of event, of opcode if it could be
+// decoded, disconnecting the peer once it has sent too many.
+func (v *enetValidator) offend(event Event, opcode uint64, decoded bool, err error) {
+	peer := event.GetPeer()
+	now := v.config.Clock.Now()
+
+	v.lock.Lock()
+	v.stats.Invalid++
+	if decoded {
+		v.stats.InvalidByOpcode[opcode]++
+	}
+	o := v.peers[peer]
+	if o == nil || now.Sub(o.start) >= v.config.Window {
+		o = &offenses{start: now}
+		v.peers[peer] = o
+	}
+	o.count++
+	if v.config.KickAfter > 0 && o.count >= v.config.KickAfter {
+		o.kicked = true
+		v.stats.Kicked++
+	}
+	invalid := InvalidMessage{
+		Peer:      peer,
+		ChannelID: event.GetChannelID(),
+		Opcode:    opcode,
+		Err:       err,
+		Offenses:  o.count,
+		Kicked:    o.kicked,
+	}
+	onInvalid := v.config.OnInvalid
+	v.lock.Unlock()
+
+	if invalid.Kicked {
+		peer.Disconnect(v.config.KickData)
+	}
+	if onInvalid != nil {
+		onInvalid(invalid)
+	}
+}
+
+func (v *enetValidator) Middleware() Middleware {
+	return func(event Event, next EventHandler) {
+		if v.Check(event) == nil {
+			next(event)
+		}
+	}
+}
+
+func (v *enetValidator) Stats() ValidationStats {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	stats := v.stats
+	stats.InvalidByOpcode = maps.Clone(v.stats.InvalidByOpcode)
+	return stats
+}
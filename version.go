@@ -0,0 +1,412 @@
+package enet
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultVersionTimeout is how long peers are given by default to send their
+	// version
+	DefaultVersionTimeout = 5 * time.Second
+
+	// versionMaxHeld bounds the packets held for a peer until its version is accepted,
+	// beyond which the peer is disconnected.
+	versionMaxHeld = 64
+
+	// versionHello heads the message carrying the version of a host.
+	versionHello byte = 0
+
+	versionHelloSize = 14
+)
+
+// Version is the version a host advertises to its peers, see NegotiateVersion
+type Version struct {
+	// App is the version of the application, for example "1.4.2". At most 255 bytes.
+	App string
+
+	// Revision is the revision of the protocol of the application
+	Revision uint32
+
+	// Features are flags of the optional features supported, defined by the
+	// application
+	Features uint64
+}
+
+// Supports returns true if the version has all the features flagged in features
+func (v Version) Supports(features uint64) bool {
+	return v.Features&features == features
+}
+
+// VersionDecision is what a VersionPolicy decides about a peer
+type VersionDecision int
+
+const (
+	// VersionAccept accepts the peer
+	VersionAccept VersionDecision = iota
+
+	// VersionAcceptDegraded accepts the peer, which the application serves without the
+	// features it lacks, see NegotiatedVersion.Degraded
+	VersionAcceptDegraded
+
+	// VersionReject disconnects the peer with the reason code returned along, which
+	// the peer gets as the data of its disconnect event
+	VersionReject
+)
+
+// VersionPolicy decides whether a peer of version remote is accepted. Peers that did
+// not send their version in time are handed over with the zero Version. When rejecting,
+// reason is the data the peer is disconnected with.
+type VersionPolicy func(peer Peer, remote Version) (decision VersionDecision, reason uint32)
+
+// VersionConfig configures the version handshake of a host, see NegotiateVersion
+type VersionConfig struct {
+	// Channel is the channel reserved for the handshake, whose packets are no longer
+	// returned by Host.Service
+	Channel uint8
+
+	// Version is the version of the host
+	Version Version
+
+	// Policy decides whether peers are accepted. It may be nil, every peer is then
+	// accepted.
+	Policy VersionPolicy
+
+	// Timeout is how long peers are given to send their version. Defaults to
+	// DefaultVersionTimeout.
+	Timeout time.Duration
+}
+
+// NegotiatedVersion is the outcome of the version handshake with a peer
+type NegotiatedVersion struct {
+	// Remote is the version of the peer, zero if it did not send it in time
+	Remote Version
+
+	// Degraded is set if the peer was accepted with VersionAcceptDegraded
+	Degraded bool
+}
+
+// versionPeer is the state of the version handshake with a peer.
+type versionPeer struct {
+	initiator   bool
+	started     time.Time
+	connectData uint32
+
+	// deciding is set while the policy decides, released once the peer is accepted and
+	// rejected once it is not.
+	deciding bool
+	released bool
+	rejected bool
+	result   NegotiatedVersion
+
+	held []heldPacket
+}
+
+// versionTable holds the version handshakes with the peers of a host.
+type versionTable struct {
+	enabled atomic.Bool
+
+	lock      sync.Mutex
+	config    VersionConfig
+	hello     []byte
+	peers     map[enetPeer]*versionPeer
+	initiated map[enetPeer]struct{}
+
+	// ready holds the events released by an accepted handshake, returned by the next
+	// Service calls.
+	ready []Event
+}
+
+// NegotiateVersion makes host send its version as the first message to every peer
+// that connects from then on, and hand the version of the peer to config.Policy.
+// Connect events are held back until the peer is accepted, and peers that are rejected
+// are disconnected with the reason code of the policy without the application ever
+// seeing them. Peers the host connects to get the reason as the data of the disconnect
+// event. Both hosts must negotiate on the same channel. Calling it again replaces the
+// configuration for the peers connecting from then on.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func NegotiateVersion(host Host, config VersionConfig) error {
+	if len(config.Version.App) > 255 {
+		return errors.New("app version is too long")
+	}
+	if config.Timeout < 0 {
+		return errors.New("timeout is negative")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultVersionTimeout
+	}
+	hello := []byte{versionHello}
+	hello = binary.BigEndian.AppendUint32(hello, config.Version.Revision)
+	hello = binary.BigEndian.AppendUint64(hello, config.Version.Features)
+	hello = append(hello, byte(len(config.Version.App)))
+	hello = append(hello, config.Version.App...)
+
+	var err error
+	enable := func(host Host) {
+		h, ok := host.(*enetHost)
+		if !ok {
+			err = errors.New("version negotiation is only supported on enet hosts")
+			return
+		}
+		if h.destroyed {
+			err = errHostDestroyed
+			return
+		}
+
+		table := &h.versions
+		table.lock.Lock()
+		table.config = config
+		table.hello = hello
+		if table.peers == nil {
+			table.peers = make(map[enetPeer]*versionPeer)
+			table.initiated = make(map[enetPeer]struct{})
+		}
+		table.lock.Unlock()
+		table.enabled.Store(true)
+		h.features.join(featureVersions)
+	}
+
+	if safe, ok := host.(*safeHost); ok {
+		safe.Do(enable)
+	} else {
+		enable(host)
+	}
+	return err
+}
+
+// PeerVersion returns the outcome of the version handshake with peer, whose host
+// negotiates versions with NegotiateVersion. Returns false if the peer has not been
+// accepted. It is safe to call from any goroutine.
+func PeerVersion(peer Peer) (NegotiatedVersion, bool) {
+	p, ok := peer.(enetPeer)
+	if safe, isSafe := peer.(safePeer); isSafe {
+		p, ok = safe.Peer.(enetPeer)
+	}
+	if !ok {
+		return NegotiatedVersion{}, false
+	}
+	host := p.host()
+	if host == nil || !host.versions.enabled.Load() {
+		return NegotiatedVersion{}, false
+	}
+
+	table := &host.versions
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	state := table.peers[p]
+	if state == nil || !state.released {
+		return NegotiatedVersion{}, false
+	}
+	return state.result, true
+}
+
+// parseVersionHello returns the version carried by a hello message.
+func parseVersionHello(msg []byte) (Version, bool) {
+	if len(msg) < versionHelloSize || msg[0] != versionHello || len(msg) != versionHelloSize+int(msg[13]) {
+		return Version{}, false
+	}
+	return Version{
+		Revision: binary.BigEndian.Uint32(msg[1:]),
+		Features: binary.BigEndian.Uint64(msg[5:]),
+		App:      string(msg[versionHelloSize:]),
+	}, true
+}
+
+// connecting remembers that the host connects to peer, which the application knows of.
+func (table *versionTable) connecting(peer enetPeer) {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	table.initiated[peer] = struct{}{}
+	table.lock.Unlock()
+}
+
+// next sets event to the next event released by a handshake. Returns false if there
+// is none.
+func (table *versionTable) next(event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if len(table.ready) == 0 {
+		return false
+	}
+	event.goEvent = table.ready[0]
+	event.timestamp = time.Now()
+	table.ready[0] = nil
+	table.ready = table.ready[1:]
+	return true
+}
+
+// expire hands the peers that take too long to send their version to the policy.
+func (table *versionTable) expire() {
+	if !table.enabled.Load() {
+		return
+	}
+	table.lock.Lock()
+	now := time.Now()
+	var expired []enetPeer
+	for peer, state := range table.peers {
+		if !state.deciding && !state.released && !state.rejected && now.Sub(state.started) > table.config.Timeout {
+			state.deciding = true
+			expired = append(expired, peer)
+		}
+	}
+	table.lock.Unlock()
+
+	for _, peer := range expired {
+		if connect := table.decide(peer, Version{}); connect != nil {
+			table.lock.Lock()
+			table.ready = append([]Event{connect}, table.ready...)
+			table.lock.Unlock()
+		}
+	}
+}
+
+// decide hands the version of peer to the policy, disconnecting the peer if it is
+// rejected. Returns the connect event of the peer if it is accepted, the packets held
+// until now being queued to follow it.
+func (table *versionTable) decide(peer enetPeer, remote Version) Event {
+	table.lock.Lock()
+	policy := table.config.Policy
+	table.lock.Unlock()
+
+	decision, reason := VersionAccept, uint32(0)
+	if policy != nil {
+		decision, reason = policy(peer, remote)
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	state := table.peers[peer]
+	if state == nil {
+		// Disconnected while the policy decided.
+		return nil
+	}
+	state.deciding = false
+	if decision != VersionAccept && decision != VersionAcceptDegraded {
+		state.rejected = true
+		state.held = nil
+		peer.Disconnect(reason)
+		return nil
+	}
+
+	state.released = true
+	state.result = NegotiatedVersion{Remote: remote, Degraded: decision == VersionAcceptDegraded}
+	for _, held := range state.held {
+		if received := newNoiseReceiveEvent(peer, held.channel, held.flags, held.data); received != nil {
+			table.ready = append(table.ready, received)
+		}
+	}
+	state.held = nil
+	return &noiseEvent{
+		eventType: EventConnect,
+		peer:      peer,
+		data:      state.connectData,
+		timestamp: time.Now(),
+	}
+}
+
+// intercept runs the handshakes. Returns true if the event has been consumed and must
+// not be returned to the application. Connect events held back until now replace the
+// event.
+func (table *versionTable) intercept(event *enetEvent) bool {
+	if !table.enabled.Load() {
+		return false
+	}
+	peer, ok := event.GetPeer().(enetPeer)
+	if !ok {
+		return false
+	}
+	table.lock.Lock()
+
+	state := table.peers[peer]
+	switch event.GetType() {
+	case EventConnect:
+		_, initiator := table.initiated[peer]
+		delete(table.initiated, peer)
+		state = &versionPeer{initiator: initiator, started: time.Now(), connectData: event.GetData()}
+		table.peers[peer] = state
+		if peer.SendBytes(table.hello, table.config.Channel, PacketFlagReliable) != nil {
+			state.rejected = true
+			peer.Disconnect(0)
+		}
+		table.lock.Unlock()
+		return true
+
+	case EventDisconnect, EventDisconnectTimeout:
+		_, initiated := table.initiated[peer]
+		delete(table.initiated, peer)
+		delete(table.peers, peer)
+		table.lock.Unlock()
+		if state == nil {
+			return false
+		}
+		// The application knows of the peers it connected to or was told about.
+		return !state.released && !state.initiator && !initiated
+
+	case EventReceive:
+		if state == nil {
+			// The peer connected before versions were negotiated.
+			table.lock.Unlock()
+			return false
+		}
+	default:
+		table.lock.Unlock()
+		return false
+	}
+
+	packet := event.GetPacket()
+	switch {
+	case state.released && event.GetChannelID() != table.config.Channel:
+		table.lock.Unlock()
+		return false
+
+	case state.released || state.rejected:
+		table.lock.Unlock()
+		packet.Destroy()
+		return true
+
+	case event.GetChannelID() != table.config.Channel:
+		if len(state.held) == versionMaxHeld {
+			state.rejected = true
+			state.held = nil
+			peer.Disconnect(0)
+		} else {
+			state.held = append(state.held, heldPacket{
+				channel: event.GetChannelID(),
+				flags:   packet.GetFlags(),
+				data:    packet.GetData(),
+			})
+		}
+		table.lock.Unlock()
+		packet.Destroy()
+		return true
+	}
+
+	remote, ok := parseVersionHello(event.GetPacketDataUnsafe())
+	packet.Destroy()
+	if !ok {
+		state.rejected = true
+		state.held = nil
+		peer.Disconnect(0)
+		table.lock.Unlock()
+		return true
+	}
+	state.deciding = true
+	table.lock.Unlock()
+
+	connect := table.decide(peer, remote)
+	if connect == nil {
+		return true
+	}
+	event.goEvent = connect
+	return false
+}
@@ -0,0 +1,322 @@
+package enet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VirtualHosts serves several applications on one host, so a single UDP port can serve
+// for example a game, a chat and telemetry backends. Peers are routed to the
+// application registered with the key of their connect data, or of the first message
+// they send with RouteByMessage, and only ever see that application.
+type VirtualHosts interface {
+	// Register registers app under key, returning the virtual host serving it. Fails
+	// if key is already registered.
+	Register(key uint32, app VirtualApp) (VirtualHost, error)
+
+	// Unregister removes the application registered under key. Its peers are
+	// disconnected with the RejectData of the configuration as their next event is
+	// handled.
+	Unregister(key uint32)
+
+	// Lookup returns the virtual host registered under key
+	Lookup(key uint32) (VirtualHost, bool)
+
+	// Of returns the virtual host peer has been routed to
+	Of(peer Peer) (VirtualHost, bool)
+
+	// Handle routes an event to the application of its peer. It must be called for
+	// every event serviced, by the goroutine servicing the host.
+	Handle(event Event)
+}
+
+// VirtualApp is an application served by a VirtualHost
+type VirtualApp struct {
+	// Dispatcher routes the packets received from the peers of the application. It
+	// may be nil, packets are then handed to OnEvent.
+	Dispatcher Dispatcher
+
+	// OnEvent is handed the connect and disconnect events of the peers of the
+	// application, and the packets they send if there's no dispatcher. It may be nil.
+	OnEvent EventHandler
+}
+
+// VirtualHost is an application registered with VirtualHosts
+type VirtualHost interface {
+	// Key is the key the application is registered under
+	Key() uint32
+
+	// Peers returns the peers routed to the application
+	Peers() []Peer
+
+	// Broadcast sends data to the peers of the application. Must be called by the
+	// goroutine servicing the host.
+	Broadcast(data []byte, channel uint8, flags PacketFlags) error
+
+	// Stats returns the statistics of the application. It is safe to call from any
+	// goroutine.
+	Stats() VirtualHostStats
+}
+
+// VirtualHostStats is a snapshot of the statistics of a VirtualHost
+type VirtualHostStats struct {
+	// Peers is the number of peers routed to the application
+	Peers int
+
+	// BytesReceived counts the payloads received from the peers of the application,
+	// and BytesSent those it broadcast
+	BytesReceived uint64
+	BytesSent     uint64
+
+	// Events counts the events of the peers of the application
+	Events EventCounts
+}
+
+// VirtualHostsConfig configures VirtualHosts
+type VirtualHostsConfig struct {
+	// RouteByMessage routes peers by the first message they send rather than by their
+	// connect data, for clients that can't set it. The message holds the key as a
+	// 4 bytes big endian word, see SendRoute, and is not handed to the application.
+	// Connect events are held back until it arrives.
+	RouteByMessage bool
+
+	// RejectData is the data peers with a key that is not registered are disconnected
+	// with
+	RejectData uint32
+}
+
+// SendRoute sends the message routing peer to the application registered under key, for
+// VirtualHosts routing by message. It must be the first message sent to the peer.
+func SendRoute(peer Peer, key uint32, channel uint8) error {
+	return peer.SendBytes(binary.BigEndian.AppendUint32(nil, key), channel, PacketFlagReliable)
+}
+
+type enetVirtualHost struct {
+	key  uint32
+	app  VirtualApp
+	lock sync.RWMutex
+
+	peers         map[Peer]struct{}
+	bytesReceived atomic.Uint64
+	bytesSent     atomic.Uint64
+	events        eventCounters
+}
+
+// pendingPeer is a peer waiting for its route message.
+type pendingPeer struct {
+	data      uint32
+	timestamp time.Time
+}
+
+type enetVirtualHosts struct {
+	config VirtualHostsConfig
+
+	lock    sync.RWMutex
+	hosts   map[uint32]*enetVirtualHost
+	routes  map[Peer]*enetVirtualHost
+	pending map[Peer]pendingPeer
+}
+
+// NewVirtualHosts creates virtual hosts without applications. Applications may be
+// registered from any goroutine.
+func NewVirtualHosts(config VirtualHostsConfig) VirtualHosts {
+	return &enetVirtualHosts{
+		config:  config,
+		hosts:   make(map[uint32]*enetVirtualHost),
+		routes:  make(map[Peer]*enetVirtualHost),
+		pending: make(map[Peer]pendingPeer),
+	}
+}
+
+func (v *enetVirtualHosts) Register(key uint32, app VirtualApp) (VirtualHost, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, ok := v.hosts[key]; ok {
+		return nil, fmt.Errorf("key %d is already registered", key)
+	}
+	host := &enetVirtualHost{key: key, app: app, peers: make(map[Peer]struct{})}
+	v.hosts[key] = host
+	return host, nil
+}
+
+func (v *enetVirtualHosts) Unregister(key uint32) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.hosts, key)
+}
+
+func (v *enetVirtualHosts) Lookup(key uint32) (VirtualHost, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	host, ok := v.hosts[key]
+	return host, ok
+}
+
+func (v *enetVirtualHosts) Of(peer Peer) (VirtualHost, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	host, ok := v.routes[peer]
+	return host, ok
+}
+
+func (v *enetVirtualHosts) Handle(event Event) {
+	peer := event.GetPeer()
+	if peer == nil {
+		return
+	}
+
+	v.lock.Lock()
+	host, routed := v.routes[peer]
+	_, pending := v.pending[peer]
+	switch event.GetType() {
+	case EventConnect:
+		delete(v.routes, peer)
+		if v.config.RouteByMessage {
+			v.pending[peer] = pendingPeer{data: event.GetData(), timestamp: event.GetTimestamp()}
+			v.lock.Unlock()
+			return
+		}
+		host = v.route(peer, event.GetData())
+		v.lock.Unlock()
+		if host != nil {
+			host.handle(event)
+		}
+		return
+
+	case EventDisconnect, EventDisconnectTimeout:
+		delete(v.routes, peer)
+		delete(v.pending, peer)
+		v.lock.Unlock()
+		if routed {
+			host.forget(peer)
+			host.handle(event)
+		}
+		return
+
+	case EventReceive:
+	default:
+		v.lock.Unlock()
+		return
+	}
+
+	if pending {
+		// The first message is the route.
+		connect := v.pending[peer]
+		delete(v.pending, peer)
+		data := event.GetPacketDataUnsafe()
+		if len(data) != 4 {
+			v.lock.Unlock()
+			peer.DisconnectNow(v.config.RejectData)
+			return
+		}
+		host = v.route(peer, binary.BigEndian.Uint32(data))
+		v.lock.Unlock()
+		if host != nil {
+			host.handle(&noiseEvent{
+				eventType: EventConnect,
+				peer:      peer,
+				data:      connect.data,
+				timestamp: connect.timestamp,
+			})
+		}
+		return
+	}
+	if routed && v.hosts[host.key] != host {
+		// Its application has been unregistered.
+		delete(v.routes, peer)
+		host.forget(peer)
+		routed = false
+	}
+	v.lock.Unlock()
+
+	if !routed {
+		peer.DisconnectNow(v.config.RejectData)
+		return
+	}
+	host.handle(event)
+}
+
+// route routes peer to the application registered under key, disconnecting it if
+// there's none. Must be called with the lock held.
+func (v *enetVirtualHosts) route(peer Peer, key uint32) *enetVirtualHost {
+	host, ok := v.hosts[key]
+	if !ok {
+		peer.DisconnectNow(v.config.RejectData)
+		return nil
+	}
+	v.routes[peer] = host
+	host.lock.Lock()
+	host.peers[peer] = struct{}{}
+	host.lock.Unlock()
+	return host
+}
+
+// handle hands an event of one of its peers to the application.
+func (host *enetVirtualHost) handle(event Event) {
+	host.events.count(event.GetType())
+	if event.GetType() == EventReceive {
+		host.bytesReceived.Add(uint64(len(event.GetPacketDataUnsafe())))
+		if host.app.Dispatcher != nil {
+			if host.app.Dispatcher.Dispatch(event) != nil {
+				host.events.dispatchErrors.Add(1)
+			}
+			return
+		}
+	}
+	if host.app.OnEvent != nil {
+		host.app.OnEvent(event)
+	}
+}
+
+func (host *enetVirtualHost) forget(peer Peer) {
+	host.lock.Lock()
+	delete(host.peers, peer)
+	host.lock.Unlock()
+}
+
+func (host *enetVirtualHost) Key() uint32 {
+	return host.key
+}
+
+func (host *enetVirtualHost) Peers() []Peer {
+	host.lock.RLock()
+	defer host.lock.RUnlock()
+
+	peers := make([]Peer, 0, len(host.peers))
+	for peer := range host.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (host *enetVirtualHost) Broadcast(data []byte, channel uint8, flags PacketFlags) error {
+	peers := host.Peers()
+	if len(peers) == 0 {
+		return nil
+	}
+	packet, err := NewPacket(data, flags)
+	if err != nil {
+		return err
+	}
+	if err := multicast(peers, channel, packet); err != nil {
+		return err
+	}
+	host.bytesSent.Add(uint64(len(data) * len(peers)))
+	return nil
+}
+
+func (host *enetVirtualHost) Stats() VirtualHostStats {
+	host.lock.RLock()
+	peers := len(host.peers)
+	host.lock.RUnlock()
+	return VirtualHostStats{
+		Peers:         peers,
+		BytesReceived: host.bytesReceived.Load(),
+		BytesSent:     host.bytesSent.Load(),
+		Events:        host.events.snapshot(),
+	}
+}
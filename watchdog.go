@@ -0,0 +1,185 @@
+package enet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stall describes the goroutine servicing a host going without servicing it for longer
+// than the threshold of a Watchdog
+type Stall struct {
+	// Since is the time the host was last serviced
+	Since time.Time
+
+	// Duration is how long the host went without being serviced, so far unless Ended
+	Duration time.Duration
+
+	// Ended is set once the host is serviced again
+	Ended bool
+}
+
+// WatchdogConfig configures a Watchdog
+type WatchdogConfig struct {
+	// Threshold is how long the host may go without being serviced before the loop is
+	// considered stalled. It must be longer than the time handlers are expected to take
+	// between Service calls, and than the tick interval in ServiceModeTick.
+	Threshold time.Duration
+
+	// OnStall is called once as a stall is detected, by the goroutine of the watchdog
+	// while the loop is still stalled, and once more as it ends, by the goroutine
+	// servicing the host. It may be nil, stalls being logged either way.
+	OnStall func(Stall)
+}
+
+// Watchdog watches the goroutine servicing a host for stalls, see NewWatchdog
+type Watchdog interface {
+	// Stalls returns the number of stalls detected
+	Stalls() uint64
+
+	// Longest returns the longest stall that ended
+	Longest() time.Duration
+
+	// Stop stops watching the host
+	Stop()
+}
+
+type enetWatchdog struct {
+	config WatchdogConfig
+	host   *enetHost
+	attrs  []slog.Attr
+
+	lock sync.Mutex
+	// serviced is when Service last returned, zero while the host is being serviced
+	// or before it first was.
+	serviced time.Time
+	reported bool
+
+	stalls  atomic.Uint64
+	longest atomic.Int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatchdog watches the time the goroutine servicing host spends between Service
+// calls, running handlers and anything else, and reports when it exceeds the
+// threshold. A blocked loop stops acknowledging its peers, which is the most common
+// cause of timeouts that seem to come from nowhere. Stalls are logged at warn level,
+// see HostConfig.Logger, and passed to OnStall.
+//
+// It must be called by the goroutine servicing the host, or on a SafeHost.
+func NewWatchdog(host Host, config WatchdogConfig) (Watchdog, error) {
+	if config.Threshold <= 0 {
+		return nil, errors.New("threshold must be positive")
+	}
+
+	w := &enetWatchdog{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	h := host
+	if safe, ok := host.(*safeHost); ok {
+		h = safe.host
+	}
+	if h, ok := h.(*enetHost); ok {
+		w.host = h
+		w.attrs = []slog.Attr{h.logAttr()}
+	}
+
+	if err := ObserveService(host, w.observe); err != nil {
+		return nil, err
+	}
+	go w.watch()
+	return w, nil
+}
+
+func (w *enetWatchdog) Stalls() uint64 {
+	return w.stalls.Load()
+}
+
+func (w *enetWatchdog) Longest() time.Duration {
+	return time.Duration(w.longest.Load())
+}
+
+func (w *enetWatchdog) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// observe marks the host as being serviced, reporting the end of a stall if any.
+func (w *enetWatchdog) observe(Host) func(Event) {
+	select {
+	case <-w.stop:
+		return nil
+	default:
+	}
+
+	now := time.Now()
+	w.lock.Lock()
+	since, reported := w.serviced, w.reported
+	w.serviced, w.reported = time.Time{}, false
+	w.lock.Unlock()
+
+	if reported {
+		stall := Stall{Since: since, Duration: now.Sub(since), Ended: true}
+		if stall.Duration > w.Longest() {
+			w.longest.Store(int64(stall.Duration))
+		}
+		w.report(stall)
+	}
+
+	return func(Event) {
+		w.lock.Lock()
+		w.serviced = time.Now()
+		w.lock.Unlock()
+	}
+}
+
+// watch checks for stalls until stopped.
+func (w *enetWatchdog) watch() {
+	ticker := time.NewTicker(max(w.config.Threshold/4, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+// check reports a stall if the host has not been serviced for longer than the
+// threshold and it was not reported yet.
+func (w *enetWatchdog) check(now time.Time) {
+	w.lock.Lock()
+	since := w.serviced
+	stalled := !since.IsZero() && !w.reported && now.Sub(since) >= w.config.Threshold
+	if stalled {
+		w.reported = true
+	}
+	w.lock.Unlock()
+
+	if stalled {
+		w.stalls.Add(1)
+		w.report(Stall{Since: since, Duration: now.Sub(since)})
+	}
+}
+
+// report logs a stall and passes it to OnStall.
+func (w *enetWatchdog) report(stall Stall) {
+	if stall.Ended {
+		if l := w.host.loggerAt(slog.LevelInfo); l != nil {
+			l.LogAttrs(context.Background(), slog.LevelInfo, "service loop recovered", append(w.attrs, slog.Duration("stalled", stall.Duration))...)
+		}
+	} else if l := w.host.loggerAt(slog.LevelWarn); l != nil {
+		l.LogAttrs(context.Background(), slog.LevelWarn, "service loop stalled", append(w.attrs, slog.Duration("stalled", stall.Duration))...)
+	}
+	if w.config.OnStall != nil {
+		w.config.OnStall(stall)
+	}
+}
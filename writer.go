@@ -0,0 +1,174 @@
+package enet
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// PeerWriter returns an io.Writer sending every Write as a single packet to the peer,
+// so standard library encoders can write directly to it. Sends are queued with
+// Peer.SendAsync, so the writer can be used from any goroutine.
+//
+// Each Write becomes its own packet, which is only a problem for encoders splitting
+// messages over several writes when the flags make delivery unreliable. Use a
+// BufferedPeerWriter to combine writes.
+func PeerWriter(peer Peer, channel uint8, flags PacketFlags) io.Writer {
+	return &peerWriter{
+		peer:    peer,
+		channel: channel,
+		flags:   flags,
+	}
+}
+
+type peerWriter struct {
+	peer    Peer
+	channel uint8
+	flags   PacketFlags
+}
+
+func (w *peerWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.peer.SendAsync(p, w.channel, w.flags); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// BufferedPeerWriter collects writes and sends them to the peer as a single packet when
+// flushed. It is safe for concurrent use.
+type BufferedPeerWriter interface {
+	io.Writer
+
+	// Flush sends everything written since the last flush as one packet
+	Flush() error
+
+	// Close flushes the writer and stops automatic flushing
+	Close() error
+}
+
+type bufferedPeerWriter struct {
+	peerWriter
+	size int
+	host *enetHost
+
+	lock   sync.Mutex
+	buffer []byte
+	closed bool
+}
+
+// NewBufferedPeerWriter creates a writer buffering up to size bytes before sending them
+// to the peer. If autoFlush is set, the buffer is also flushed every time the host is
+// serviced, so the application doesn't need to call Flush at the end of each tick.
+func NewBufferedPeerWriter(peer Peer, channel uint8, flags PacketFlags, size int, autoFlush bool) (BufferedPeerWriter, error) {
+	w := &bufferedPeerWriter{
+		peerWriter: peerWriter{
+			peer:    peer,
+			channel: channel,
+			flags:   flags,
+		},
+		size: size,
+	}
+
+	if autoFlush {
+		if safe, ok := peer.(safePeer); ok {
+			peer = safe.Peer
+		}
+		p, ok := peer.(enetPeer)
+		if !ok {
+			return nil, errors.New("automatic flushing is only supported on enet peers")
+		}
+		w.host = p.host()
+		if w.host == nil {
+			return nil, errors.New("peer has no host")
+		}
+		w.host.flushers.add(w)
+	}
+
+	return w, nil
+}
+
+func (w *bufferedPeerWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, errors.New("writer is closed")
+	}
+
+	w.buffer = append(w.buffer, p...)
+	if len(w.buffer) >= w.size {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *bufferedPeerWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.flush()
+}
+
+func (w *bufferedPeerWriter) flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	err := w.peer.SendAsync(w.buffer, w.channel, w.flags)
+	w.buffer = w.buffer[:0]
+	return err
+}
+
+func (w *bufferedPeerWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.host != nil {
+		w.host.flushers.remove(w)
+	}
+	return w.flush()
+}
+
+// flusher is flushed every time its host is serviced.
+type flusher interface {
+	Flush() error
+}
+
+// flusherSet holds the writers and coalescers flushed every time a host is serviced.
+type flusherSet struct {
+	lock     sync.Mutex
+	flushers map[flusher]struct{}
+}
+
+func (set *flusherSet) add(w flusher) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	if set.flushers == nil {
+		set.flushers = make(map[flusher]struct{})
+	}
+	set.flushers[w] = struct{}{}
+}
+
+func (set *flusherSet) remove(w flusher) {
+	set.lock.Lock()
+	delete(set.flushers, w)
+	set.lock.Unlock()
+}
+
+func (set *flusherSet) flush() {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	for w := range set.flushers {
+		// Errors mean the peer is gone, nothing to report them to here.
+		w.Flush()
+	}
+}
@@ -0,0 +1,181 @@
+// Package wsbridge lets browser clients connect to an enet host over WebSockets. Each
+// WebSocket connection shows up as a virtual peer in the event stream of a host
+// created with enet.NewBridgedHost, so the same code serves native and browser clients.
+//
+// Every WebSocket message is a binary message carrying one packet: the channel ID in
+// the first byte, followed by the payload. Clients can pass the data of the connect
+// event in the "data" query parameter. Disconnecting sends a normal close frame with
+// the disconnect data as its decimal reason, and clients are expected to do the same.
+// WebSockets are always reliable and ordered, so packet flags have no effect.
+package wsbridge
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/internal/bridge"
+	"github.com/gorilla/websocket"
+)
+
+// Config configures a Bridge
+type Config struct {
+	// CheckOrigin decides whether to accept a connection from the origin of the
+	// request. If nil, only connections from the same origin are accepted.
+	CheckOrigin func(r *http.Request) bool
+
+	// WriteTimeout bounds how long sending a packet may block. Defaults to 5 seconds.
+	WriteTimeout time.Duration
+
+	// PingInterval is how often clients are pinged to detect broken connections.
+	// Clients not answering within twice the interval time out. Defaults to 10 seconds.
+	PingInterval time.Duration
+}
+
+// Bridge accepts WebSocket connections as an http.Handler and implements enet.Bridge
+type Bridge struct {
+	hub      *bridge.Hub
+	config   Config
+	upgrader websocket.Upgrader
+}
+
+// New creates a bridge. Mount it on an HTTP server to accept clients.
+func New(config Config) *Bridge {
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 5 * time.Second
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = 10 * time.Second
+	}
+
+	return &Bridge{
+		hub:    bridge.NewHub(),
+		config: config,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: config.CheckOrigin,
+		},
+	}
+}
+
+// Events implements enet.Bridge
+func (b *Bridge) Events() <-chan enet.Event {
+	return b.hub.Events()
+}
+
+// Broadcast implements enet.Bridge
+func (b *Bridge) Broadcast(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return b.hub.Broadcast(data, channel, flags)
+}
+
+// Close implements enet.Bridge. The HTTP server is left running but refuses new
+// connections from then on.
+func (b *Bridge) Close() error {
+	return b.hub.Close()
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves it until the
+// connection is closed.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data uint32
+	if s := r.URL.Query().Get("data"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "malformed connect data", http.StatusBadRequest)
+			return
+		}
+		data = uint32(n)
+	}
+
+	conn, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		return
+	}
+
+	transport := &transport{conn: conn, writeTimeout: b.config.WriteTimeout}
+	peer, err := b.hub.Connect(transport, data)
+	if err != nil {
+		transport.Close(0)
+		return
+	}
+
+	b.serve(conn, peer)
+}
+
+func (b *Bridge) serve(conn *websocket.Conn, peer *bridge.Peer) {
+	timeout := 2 * b.config.PingInterval
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(b.config.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(b.config.WriteTimeout))
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		kind, msg, err := conn.ReadMessage()
+		if err != nil {
+			peer.Disconnected(disconnectOf(err))
+			conn.Close()
+			return
+		}
+		if kind != websocket.BinaryMessage || len(msg) == 0 {
+			continue
+		}
+		peer.Receive(msg[0], enet.PacketFlagReliable, msg[1:])
+	}
+}
+
+// disconnectOf returns the type and data of the disconnect event for the error that
+// ended a connection.
+func disconnectOf(err error) (enet.EventType, uint32) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		data, _ := strconv.ParseUint(closeErr.Text, 10, 32)
+		return enet.EventDisconnect, uint32(data)
+	}
+	return enet.EventDisconnectTimeout, 0
+}
+
+type transport struct {
+	conn         *websocket.Conn
+	writeTimeout time.Duration
+
+	lock   sync.Mutex
+	buffer []byte
+}
+
+func (t *transport) Send(channel uint8, flags enet.PacketFlags, data []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.buffer = append(append(t.buffer[:0], channel), data...)
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	return t.conn.WriteMessage(websocket.BinaryMessage, t.buffer)
+}
+
+func (t *transport) Close(data uint32) error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, strconv.FormatUint(uint64(data), 10))
+	t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(t.writeTimeout))
+	return t.conn.Close()
+}
+
+func (t *transport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
@@ -0,0 +1,205 @@
+// Package wtbridge lets browser clients connect to an enet host over WebTransport. Each
+// WebTransport session shows up as a virtual peer in the event stream of a host created
+// with enet.NewBridgedHost.
+//
+// Reliable packets travel over a bidirectional stream the client opens right after
+// connecting, each prefixed with its length as an unsigned varint followed by the
+// channel ID. Other packets are sent as datagrams holding the channel ID followed by
+// the payload, falling back to the stream when too large for a datagram. Clients can
+// pass the data of the connect event in the "data" query parameter. Disconnecting
+// closes the session with the disconnect data as its error code.
+package wtbridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	enet "github.com/TubbyStubby/go-enet-sharp"
+	"github.com/TubbyStubby/go-enet-sharp/internal/bridge"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+// maxPacketSize is the largest packet accepted on the reliable stream.
+const maxPacketSize = 32 * 1024 * 1024
+
+// Config configures a Bridge
+type Config struct {
+	// StreamTimeout bounds how long a client may take to open its reliable stream.
+	// Defaults to 5 seconds.
+	StreamTimeout time.Duration
+}
+
+// Bridge accepts WebTransport sessions as an http.Handler and implements enet.Bridge
+type Bridge struct {
+	hub    *bridge.Hub
+	server *webtransport.Server
+	config Config
+}
+
+// New creates a bridge upgrading requests with server. Mount it on the HTTP/3 server
+// of server, configured with webtransport.ConfigureHTTP3Server, to accept clients.
+func New(server *webtransport.Server, config Config) *Bridge {
+	if config.StreamTimeout <= 0 {
+		config.StreamTimeout = 5 * time.Second
+	}
+
+	return &Bridge{
+		hub:    bridge.NewHub(),
+		server: server,
+		config: config,
+	}
+}
+
+// Events implements enet.Bridge
+func (b *Bridge) Events() <-chan enet.Event {
+	return b.hub.Events()
+}
+
+// Broadcast implements enet.Bridge
+func (b *Bridge) Broadcast(data []byte, channel uint8, flags enet.PacketFlags) error {
+	return b.hub.Broadcast(data, channel, flags)
+}
+
+// Close implements enet.Bridge. The server is left running but refuses new sessions
+// from then on.
+func (b *Bridge) Close() error {
+	return b.hub.Close()
+}
+
+// ServeHTTP upgrades the request to a WebTransport session and waits for the client to
+// open its reliable stream. The session is served in the background from then on.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data uint32
+	if s := r.URL.Query().Get("data"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "malformed connect data", http.StatusBadRequest)
+			return
+		}
+		data = uint32(n)
+	}
+
+	session, err := b.server.Upgrade(w, r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(session.Context(), b.config.StreamTimeout)
+	stream, err := session.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		session.CloseWithError(0, "no reliable stream")
+		return
+	}
+
+	t := &transport{session: session, stream: stream}
+	peer, err := b.hub.Connect(t, data)
+	if err != nil {
+		t.Close(0)
+		return
+	}
+
+	go t.readStream(peer)
+	go t.readDatagrams(peer)
+}
+
+type transport struct {
+	session *webtransport.Session
+	stream  *webtransport.Stream
+
+	lock   sync.Mutex
+	buffer []byte
+}
+
+func (t *transport) Send(channel uint8, flags enet.PacketFlags, data []byte) error {
+	if flags&enet.PacketFlagReliable == 0 {
+		datagram := append([]byte{channel}, data...)
+		if err := t.session.SendDatagram(datagram); err == nil {
+			return nil
+		}
+		// Most likely too large for a datagram, the stream takes anything.
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.buffer = binary.AppendUvarint(t.buffer[:0], uint64(1+len(data)))
+	t.buffer = append(t.buffer, channel)
+	t.buffer = append(t.buffer, data...)
+	_, err := t.stream.Write(t.buffer)
+	return err
+}
+
+func (t *transport) Close(data uint32) error {
+	return t.session.CloseWithError(webtransport.SessionErrorCode(data), "")
+}
+
+func (t *transport) RemoteAddr() net.Addr {
+	return t.session.RemoteAddr()
+}
+
+func (t *transport) readStream(peer *bridge.Peer) {
+	r := bufio.NewReader(t.stream)
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == nil && (size == 0 || size > maxPacketSize) {
+			err = errors.New("malformed packet size")
+		}
+		if err != nil {
+			t.disconnected(peer, err)
+			return
+		}
+
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(r, packet); err != nil {
+			t.disconnected(peer, err)
+			return
+		}
+		peer.Receive(packet[0], enet.PacketFlagReliable, packet[1:])
+	}
+}
+
+func (t *transport) readDatagrams(peer *bridge.Peer) {
+	for {
+		datagram, err := t.session.ReceiveDatagram(t.session.Context())
+		if err != nil {
+			// The stream reader reports the disconnect.
+			return
+		}
+		if len(datagram) == 0 {
+			continue
+		}
+		peer.Receive(datagram[0], 0, datagram[1:])
+	}
+}
+
+// disconnected reports the end of the session, closing it if it's still open.
+func (t *transport) disconnected(peer *bridge.Peer, err error) {
+	// The session may have been closed before the stream noticed.
+	err = errors.Join(err, context.Cause(t.session.Context()))
+
+	var sessionErr *webtransport.SessionError
+	if errors.As(err, &sessionErr) && sessionErr.Remote {
+		peer.Disconnected(enet.EventDisconnect, uint32(sessionErr.ErrorCode))
+		return
+	}
+	// Clients closing the whole connection instead of just the session.
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote {
+		peer.Disconnected(enet.EventDisconnect, 0)
+		return
+	}
+
+	peer.Disconnected(enet.EventDisconnectTimeout, 0)
+	t.session.CloseWithError(0, "")
+}